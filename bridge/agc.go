@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"fmt"
+
+	"gotgcalls/bridge/pcm"
+)
+
+// AGCConfig is the automatic gain control of one audio direction.
+type AGCConfig struct {
+	Enabled bool
+	// TargetDBFS is the speech level aimed for; MaxGainDB is the most quiet
+	// audio is amplified.
+	TargetDBFS float64
+	MaxGainDB  float64
+}
+
+type yamlAGC struct {
+	Enabled    bool     `yaml:"enabled"`
+	TargetDBFS *float64 `yaml:"target_dbfs"`
+	MaxGainDB  *float64 `yaml:"max_gain_db"`
+}
+
+// parse applies y to c; key names the section in errors.
+func (c *AGCConfig) parse(y yamlAGC, key string) error {
+	c.Enabled = y.Enabled
+	if y.TargetDBFS != nil {
+		if v := *y.TargetDBFS; v < -40 || v > -3 {
+			return fmt.Errorf("invalid %s.target_dbfs %g (-40 to -3)", key, v)
+		}
+		c.TargetDBFS = *y.TargetDBFS
+	}
+	if y.MaxGainDB != nil {
+		if v := *y.MaxGainDB; v < 0 || v > 40 {
+			return fmt.Errorf("invalid %s.max_gain_db %g (0-40)", key, v)
+		}
+		c.MaxGainDB = *y.MaxGainDB
+	}
+	return nil
+}
+
+// newAGC returns the gain control configured for a direction, or nil.
+func (s *Service) newAGC(c AGCConfig) *pcm.AGC {
+	if !c.Enabled {
+		return nil
+	}
+	format := s.tgFormat()
+	return pcm.NewAGC(format.SampleRate, format.Channels, c.TargetDBFS, c.MaxGainDB)
+}
+
+// attachAGC adds the configured gain control to both directions of bridge.
+func (s *Service) attachAGC(bridge *MediaBridge) {
+	bridge.SetAGC(s.newAGC(s.cfg.AGCToTG), s.newAGC(s.cfg.AGCToSIP))
+}
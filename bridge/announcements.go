@@ -0,0 +1,106 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/emiago/diago"
+
+	"gotgcalls/bridge/audiofile"
+)
+
+// Announcement keys: the situations in which a rejected inbound caller can
+// hear a clip (announcements.<key>) instead of only a status code.
+const (
+	AnnounceBusy         = "busy"
+	AnnounceBlocked      = "blocked"
+	AnnounceUnavailable  = "unavailable"
+	AnnounceDeclined     = "declined"
+	AnnounceNoAnswer     = "no_answer"
+	AnnounceShuttingDown = "shutting_down"
+)
+
+// announcementGrace is added to a clip's length before playback is cut off.
+const announcementGrace = time.Second
+
+// loadAnnouncements decodes the configured announcement clips.
+func (s *Service) loadAnnouncements() error {
+	for key, path := range s.cfg.Announcements {
+		clip, err := audiofile.Load(path, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("announcements.%s: %w", key, err)
+		}
+		if s.announcements == nil {
+			s.announcements = make(map[string]*audiofile.Clip)
+		}
+		s.announcements[key] = clip
+	}
+	return nil
+}
+
+// rejectInbound ends an unanswered inbound call with status. When an
+// announcement is configured for key, the caller hears it first: as early
+// media followed by the status, or with announcements.answer in a briefly
+// answered call that is then hung up, for carriers that drop early media.
+// earlyMedia tells whether a 183 was already sent for the dialog. Calls
+// answered already (by the IVR) hear the clip and are hung up.
+func (s *Service) rejectInbound(dialog *diago.DialogServerSession, status int, reason, key string, earlyMedia bool, logger *slog.Logger) {
+	s.rejectInboundClip(dialog, status, reason, s.announcements[key], earlyMedia, logger)
+}
+
+// rejectInboundClip is rejectInbound with clip (nil for none) as the
+// announcement.
+func (s *Service) rejectInboundClip(dialog *diago.DialogServerSession, status int, reason string, clip *audiofile.Clip, earlyMedia bool, logger *slog.Logger) {
+	if dialogAnswered(dialog) {
+		// Too late for a status; play the clip in the call and hang up.
+		if clip != nil {
+			s.playAnnouncement(dialog, clip, logger)
+		}
+		hangupDialog(dialog, logger)
+		return
+	}
+	if clip == nil || dialog.Context().Err() != nil {
+		_ = dialog.Respond(status, reason, nil)
+		return
+	}
+	codecs := s.sipCodecs()
+	var err error
+	switch {
+	case s.cfg.AnnounceAnswer:
+		err = dialog.AnswerOptions(diago.AnswerOptions{Codecs: codecs, RTPNAT: s.rtpNAT()})
+	case !earlyMedia:
+		err = dialog.ProgressMediaOptions(diago.ProgressMediaOptions{Codecs: codecs, RTPNAT: s.rtpNAT()})
+	}
+	if err != nil {
+		logger.Warn("sip: announcement media failed", "file", clip.Path, "error", err)
+		_ = dialog.Respond(status, reason, nil)
+		return
+	}
+	s.playAnnouncement(dialog, clip, logger)
+	logger.Info("sip: announcement played", "file", clip.Path)
+	if s.cfg.AnnounceAnswer {
+		hangupDialog(dialog, logger)
+		return
+	}
+	_ = dialog.Respond(status, reason, nil)
+}
+
+// playAnnouncement plays clip once over dialog's media and returns when it
+// has ended or the caller hung up.
+func (s *Service) playAnnouncement(dialog *diago.DialogServerSession, clip *audiofile.Clip, logger *slog.Logger) {
+	once := clip.Once()
+	stop, done, err := s.playToSIP(dialog, clip, once.ReadSample, logger)
+	if err != nil {
+		logger.Warn("sip: announcement playback failed", "file", clip.Path, "error", err)
+		return
+	}
+	defer stop()
+	ctx, cancel := context.WithTimeout(dialog.Context(), clipLength(clip)+announcementGrace)
+	defer cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
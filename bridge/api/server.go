@@ -0,0 +1,704 @@
+// Package api exposes an HTTP control surface for the bridge.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge"
+)
+
+type Server struct {
+	svc    *bridge.Service
+	token  string
+	logger *slog.Logger
+	mux    *http.ServeMux
+	// ctx is the lifetime of calls originated through the API. It must
+	// outlive individual HTTP requests.
+	ctx context.Context
+}
+
+func NewServer(svc *bridge.Service, token string, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Server{
+		svc:    svc,
+		token:  token,
+		logger: logger,
+		mux:    http.NewServeMux(),
+		ctx:    context.Background(),
+	}
+	s.mux.HandleFunc("POST /calls", s.handleOriginate)
+	s.mux.HandleFunc("GET /calls", s.handleListCalls)
+	s.mux.HandleFunc("GET /calls/{id}", s.handleGetCall)
+	s.mux.HandleFunc("DELETE /calls/{id}", s.handleHangup)
+	s.mux.HandleFunc("GET /calls/{id}/stats", s.handleCallStats)
+	s.mux.HandleFunc("POST /calls/{id}/recording", s.handleStartRecording)
+	s.mux.HandleFunc("DELETE /calls/{id}/recording", s.handleStopRecording)
+	s.mux.HandleFunc("POST /calls/{id}/dump", s.handleStartDump)
+	s.mux.HandleFunc("DELETE /calls/{id}/dump", s.handleStopDump)
+	s.mux.HandleFunc("POST /calls/{id}/rtplog", s.handleStartRTPLog)
+	s.mux.HandleFunc("DELETE /calls/{id}/rtplog", s.handleStopRTPLog)
+	s.mux.HandleFunc("POST /calls/{id}/dtmf", s.handleDTMF)
+	s.mux.HandleFunc("POST /calls/{id}/say", s.handleSay)
+	s.mux.HandleFunc("POST /calls/{id}/transfer", s.handleTransfer)
+	s.mux.HandleFunc("POST /calls/{id}/answer", s.handleDecide(true))
+	s.mux.HandleFunc("POST /calls/{id}/decline", s.handleDecide(false))
+	s.mux.HandleFunc("GET /callers", s.handleListCallerRules)
+	s.mux.HandleFunc("POST /callers/{list}", s.handleAddCallerRule)
+	s.mux.HandleFunc("DELETE /callers/{list}", s.handleRemoveCallerRule)
+	s.mux.HandleFunc("GET /debug/dump", s.handleDebugDump)
+	s.mux.HandleFunc("POST /debug/dump", s.handleSetDebugDump(true))
+	s.mux.HandleFunc("DELETE /debug/dump", s.handleSetDebugDump(false))
+	s.mux.HandleFunc("GET /debug/rtplog", s.handleRTPEventLog)
+	s.mux.HandleFunc("POST /debug/rtplog", s.handleSetRTPEventLog(true))
+	s.mux.HandleFunc("DELETE /debug/rtplog", s.handleSetRTPEventLog(false))
+	s.mux.HandleFunc("POST /bulk", s.handleStartBulk)
+	s.mux.HandleFunc("GET /bulk", s.handleListBulk)
+	s.mux.HandleFunc("GET /bulk/{id}", s.handleGetBulk)
+	s.mux.HandleFunc("DELETE /bulk/{id}", s.handleCancelBulk)
+	s.mux.HandleFunc("POST /schedule", s.handleScheduleCall)
+	s.mux.HandleFunc("GET /schedule", s.handleListScheduled)
+	s.mux.HandleFunc("DELETE /schedule/{id}", s.handleCancelScheduled)
+	s.mux.HandleFunc("GET /cdr", s.handleCallHistory)
+	s.mux.HandleFunc("GET /voicemails", s.handleVoicemails)
+	s.mux.HandleFunc("GET /contacts", s.handleListContactAliases)
+	s.mux.HandleFunc("PUT /contacts/{number}", s.handleSetContactAlias)
+	s.mux.HandleFunc("DELETE /contacts/{number}", s.handleRemoveContactAlias)
+	s.mux.HandleFunc("GET /status", s.handleStatus)
+	s.mux.HandleFunc("GET /registration", s.handleRegistration)
+	s.mux.HandleFunc("POST /reload", s.handleReload)
+	s.mux.HandleFunc("POST /sip/restart", s.handleRestartSIP)
+	return s
+}
+
+// Handle registers an additional route on the API mux (auth is applied).
+func (s *Server) Handle(pattern string, h http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, h)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until ctx is canceled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	s.ctx = ctx
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	s.logger.Info("api: listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type originateRequest struct {
+	Number string `json:"number"`
+	// CallerID selects the number presented to the callee (sip.caller_ids).
+	CallerID string `json:"caller_id,omitempty"`
+	// ChatID, when set to a group id, dials the number into that voice chat.
+	ChatID int64 `json:"chat_id,omitempty"`
+}
+
+func (s *Server) handleOriginate(w http.ResponseWriter, r *http.Request) {
+	var req originateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if strings.TrimSpace(req.Number) == "" {
+		writeError(w, http.StatusBadRequest, "number is required")
+		return
+	}
+	var (
+		call *bridge.Call
+		err  error
+	)
+	if req.ChatID != 0 {
+		call, err = s.svc.Invite(s.ctx, req.ChatID, req.Number)
+	} else {
+		call, err = s.svc.Originate(s.ctx, req.Number, req.CallerID)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, bridge.ErrCallLimit), errors.Is(err, bridge.ErrDraining):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, bridge.ErrTGBusy):
+			status = http.StatusConflict
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, call.Info())
+}
+
+func (s *Server) handleListCalls(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.Calls())
+}
+
+func (s *Server) handleGetCall(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, call.Info())
+}
+
+func (s *Server) handleHangup(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	call.Hangup()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCallStats(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	stats, ok := call.Stats()
+	if !ok {
+		writeError(w, http.StatusConflict, "media not bridged yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleStartRecording(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	files, err := s.svc.StartRecording(call)
+	if err != nil {
+		writeError(w, recordingErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+func (s *Server) handleStopRecording(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	files, err := s.svc.StopRecording(call)
+	if err != nil {
+		writeError(w, recordingErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+func (s *Server) handleStartDump(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	files, err := s.svc.StartDump(call)
+	if err != nil {
+		writeError(w, dumpErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+func (s *Server) handleStopDump(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	files, err := s.svc.StopDump(call)
+	if err != nil {
+		writeError(w, dumpErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+func (s *Server) handleDebugDump(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": s.svc.DebugDump()})
+}
+
+// handleSetDebugDump turns the debug dump of all calls on or off.
+func (s *Server) handleSetDebugDump(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		s.svc.SetDebugDump(on)
+		writeJSON(w, http.StatusOK, map[string]any{"enabled": on})
+	}
+}
+
+func (s *Server) handleStartRTPLog(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	file, err := s.svc.StartRTPLog(call)
+	if err != nil {
+		writeError(w, dumpErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"file": file})
+}
+
+func (s *Server) handleStopRTPLog(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	file, err := s.svc.StopRTPLog(call)
+	if err != nil {
+		writeError(w, dumpErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"file": file})
+}
+
+func (s *Server) handleRTPEventLog(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": s.svc.RTPEventLog()})
+}
+
+// handleSetRTPEventLog turns the RTP event log of all calls on or off.
+func (s *Server) handleSetRTPEventLog(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		s.svc.SetRTPEventLog(on)
+		writeJSON(w, http.StatusOK, map[string]any{"enabled": on})
+	}
+}
+
+func (s *Server) handleDTMF(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	var req struct {
+		Digits string `json:"digits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if err := s.svc.SendDTMF(call, req.Digits); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, bridge.ErrNotBridged) || errors.Is(err, bridge.ErrDTMFDisabled) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSay(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+		Leg  string `json:"leg"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	dur, err := s.svc.Say(r.Context(), call, req.Text, bridge.SayLeg(req.Leg))
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, bridge.ErrNotBridged), errors.Is(err, bridge.ErrNoTTS):
+			status = http.StatusConflict
+		case !errors.Is(err, bridge.ErrInvalidSay):
+			status = http.StatusBadGateway
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]float64{"duration": dur.Seconds()})
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.svc.Call(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+	var req struct {
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		writeError(w, http.StatusBadRequest, "target is required")
+		return
+	}
+	if err := s.svc.Transfer(r.Context(), call, req.Target); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, bridge.ErrNoSIPLeg) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleDecide answers or declines an inbound call waiting for confirmation.
+func (s *Server) handleDecide(answer bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		call, ok := s.svc.Call(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "call not found")
+			return
+		}
+		decide := s.svc.DeclineCall
+		if answer {
+			decide = s.svc.AnswerCall
+		}
+		if err := decide(call); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *Server) handleListCallerRules(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.CallerRules())
+}
+
+func (s *Server) handleAddCallerRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pattern string `json:"pattern"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	err := s.svc.AddCallerRule(bridge.CallerList(r.PathValue("list")), req.Pattern)
+	if errors.Is(err, bridge.ErrUnknownCallerList) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.svc.CallerRules())
+}
+
+// handleRemoveCallerRule takes the pattern as a query parameter since
+// regular expression rules may contain slashes.
+func (s *Server) handleRemoveCallerRule(w http.ResponseWriter, r *http.Request) {
+	err := s.svc.RemoveCallerRule(bridge.CallerList(r.PathValue("list")), r.URL.Query().Get("pattern"))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, bridge.ErrCallerRuleConfigured):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusNotFound, err.Error())
+	}
+}
+
+func recordingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, bridge.ErrNotBridged), errors.Is(err, bridge.ErrRecordingActive), errors.Is(err, bridge.ErrNotRecording):
+		return http.StatusConflict
+	case errors.Is(err, bridge.ErrDiskFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func dumpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, bridge.ErrNotBridged), errors.Is(err, bridge.ErrDumpActive), errors.Is(err, bridge.ErrNotDumping),
+		errors.Is(err, bridge.ErrRTPLogActive), errors.Is(err, bridge.ErrNotRTPLogged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type bulkRequest struct {
+	Numbers []string `json:"numbers"`
+	// CSV is a list of numbers as CSV (first column) or one per line, on
+	// top of Numbers.
+	CSV              string `json:"csv,omitempty"`
+	CallerID         string `json:"caller_id,omitempty"`
+	Mode             string `json:"mode,omitempty"`
+	AnnouncementFile string `json:"announcement_file,omitempty"`
+	AnnouncementText string `json:"announcement_text,omitempty"`
+	Concurrency      int    `json:"concurrency,omitempty"`
+	// Interval is the least time between two call starts, e.g. "30s".
+	Interval string `json:"interval,omitempty"`
+}
+
+// handleStartBulk starts a bulk dial job from a JSON body, or from a CSV
+// body (Content-Type text/csv or text/plain) with the other fields of
+// bulkRequest as query parameters.
+func (s *Server) handleStartBulk(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/") {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		q := r.URL.Query()
+		req.CSV = string(body)
+		req.CallerID = q.Get("caller_id")
+		req.Mode = q.Get("mode")
+		req.AnnouncementFile = q.Get("announcement_file")
+		req.AnnouncementText = q.Get("announcement_text")
+		req.Interval = q.Get("interval")
+		if c := q.Get("concurrency"); c != "" {
+			if req.Concurrency, err = strconv.Atoi(c); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid concurrency")
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	bulk := bridge.BulkDialRequest{
+		Numbers:          req.Numbers,
+		CallerID:         req.CallerID,
+		Mode:             req.Mode,
+		AnnouncementFile: req.AnnouncementFile,
+		AnnouncementText: req.AnnouncementText,
+		Concurrency:      req.Concurrency,
+	}
+	if req.CSV != "" {
+		numbers, err := bridge.ParseNumberList([]byte(req.CSV))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid csv: "+err.Error())
+			return
+		}
+		bulk.Numbers = append(bulk.Numbers, numbers...)
+	}
+	if req.Interval != "" {
+		interval, err := time.ParseDuration(req.Interval)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid interval")
+			return
+		}
+		bulk.Interval = interval
+	}
+	job, err := s.svc.StartBulkDial(s.ctx, bulk)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, bridge.ErrDraining) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleListBulk(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.BulkDialJobs())
+}
+
+// handleGetBulk returns the report of a bulk dial job, as CSV with
+// ?format=csv.
+func (s *Server) handleGetBulk(w http.ResponseWriter, r *http.Request) {
+	job, err := s.svc.BulkDialJob(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		_ = job.WriteCSV(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleCancelBulk(w http.ResponseWriter, r *http.Request) {
+	if err := s.svc.CancelBulkDial(r.PathValue("id")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type scheduleRequest struct {
+	Number   string `json:"number"`
+	CallerID string `json:"caller_id,omitempty"`
+	// At (RFC 3339) or In (a delay like "30m") is when the call is placed.
+	At time.Time `json:"at,omitzero"`
+	In string    `json:"in,omitempty"`
+}
+
+func (s *Server) handleScheduleCall(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if req.Number == "" {
+		writeError(w, http.StatusBadRequest, "number is required")
+		return
+	}
+	at := req.At
+	if req.In != "" {
+		d, err := time.ParseDuration(req.In)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid in")
+			return
+		}
+		at = time.Now().Add(d)
+	}
+	if at.IsZero() {
+		writeError(w, http.StatusBadRequest, "at or in is required")
+		return
+	}
+	sc, err := s.svc.ScheduleCall(req.Number, req.CallerID, at)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, sc)
+}
+
+func (s *Server) handleListScheduled(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.ScheduledCalls())
+}
+
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	if err := s.svc.CancelScheduledCall(r.PathValue("id")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// queryLimit reads the limit query parameter, def by default and at most
+// 1000.
+func queryLimit(r *http.Request, def int) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		return min(n, 1000)
+	}
+	return def
+}
+
+func (s *Server) handleCallHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.CallHistory(queryLimit(r, 100)))
+}
+
+func (s *Server) handleVoicemails(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.Voicemails(queryLimit(r, 100)))
+}
+
+func (s *Server) handleListContactAliases(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.ContactAliases())
+}
+
+func (s *Server) handleSetContactAlias(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if err := s.svc.SetContactAlias(r.PathValue("number"), req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRemoveContactAlias(w http.ResponseWriter, r *http.Request) {
+	if err := s.svc.RemoveContactAlias(r.PathValue("number")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.Status())
+}
+
+func (s *Server) handleRegistration(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.svc.Registration())
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, _ *http.Request) {
+	res, err := s.svc.Reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleRestartSIP(w http.ResponseWriter, r *http.Request) {
+	res, err := s.svc.RestartSIP(r.URL.Query().Get("force") == "true")
+	switch {
+	case errors.Is(err, bridge.ErrCallsActive):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,328 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	msdk "github.com/livekit/media-sdk"
+)
+
+const (
+	// asrSpeechDB is the frame level that counts as speech for endpointing.
+	asrSpeechDB = -40
+	// asrEndSilence ends an utterance; asrMaxUtterance bounds one.
+	asrEndSilence   = 800 * time.Millisecond
+	asrMaxUtterance = 10 * time.Second
+	// asrQueueFrames buffers audio between the media goroutine and the
+	// upload.
+	asrQueueFrames = 100
+)
+
+// asr.format values.
+const (
+	// asrFormatStream streams the utterance as it is spoken, raw PCM in a
+	// chunked POST.
+	asrFormatStream = "stream"
+	// asrFormatWhisper posts the whole utterance as a WAV file in a
+	// multipart form (field "file"), as the whisper.cpp server and
+	// OpenAI-style transcription APIs take it.
+	asrFormatWhisper = "whisper"
+)
+
+// speechResult is the transcript of one utterance as returned by asr.url.
+type speechResult struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+	err        error
+}
+
+// recognizer streams one utterance of the caller to asr.url: audio is
+// uploaded as it arrives, raw 16-bit little-endian mono PCM at
+// asr.sample_rate in a chunked POST, and the body ends after the caller
+// stops speaking. The response is a JSON speechResult.
+type recognizer struct {
+	sourceRate int
+	targetRate int
+	frames     chan []byte
+	result     chan speechResult
+	// speaking is closed at the first speech frame.
+	speaking     chan struct{}
+	speakingOnce sync.Once
+
+	// Touched only by write.
+	heardSpeech bool
+	silence     time.Duration
+	length      time.Duration
+	ended       bool
+	buf         msdk.PCM16Sample
+}
+
+// startRecognizer opens a recognition request that lasts until the
+// utterance ends or ctx does.
+func (s *Service) startRecognizer(ctx context.Context, logger *slog.Logger) *recognizer {
+	r := &recognizer{
+		sourceRate: s.tgFormat().SampleRate,
+		targetRate: s.cfg.ASRSampleRate,
+		frames:     make(chan []byte, asrQueueFrames),
+		result:     make(chan speechResult, 1),
+		speaking:   make(chan struct{}),
+	}
+	body, upload := io.Pipe()
+	go func() {
+		defer upload.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = upload.CloseWithError(ctx.Err())
+				return
+			case frame, ok := <-r.frames:
+				if !ok {
+					return
+				}
+				if _, err := upload.Write(frame); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		res, err := s.recognize(ctx, body)
+		if err != nil {
+			_ = body.CloseWithError(err)
+			if ctx.Err() == nil {
+				logger.Warn("asr: speech recognition failed", "error", err)
+			}
+			res.err = err
+		}
+		r.result <- res
+	}()
+	return r
+}
+
+// recognize posts body to asr.url and decodes the transcript.
+func (s *Service) recognize(ctx context.Context, body io.Reader) (speechResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, asrMaxUtterance+s.cfg.ASRTimeout)
+	defer cancel()
+	contentType := fmt.Sprintf("audio/L16; rate=%d; channels=1", s.cfg.ASRSampleRate)
+	if s.cfg.ASRFormat == asrFormatWhisper {
+		var err error
+		if body, contentType, err = whisperForm(body, s.cfg.ASRSampleRate, s.cfg.ASRFields); err != nil {
+			return speechResult{}, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.ASRURL, body)
+	if err != nil {
+		return speechResult{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.cfg.ASRHeaders {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return speechResult{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return speechResult{}, fmt.Errorf("asr returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	var out speechResult
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return speechResult{}, fmt.Errorf("asr response: %w", err)
+	}
+	if s.cfg.ASRFormat == asrFormatWhisper && out.Confidence == 0 {
+		// Whisper servers don't rate their transcripts.
+		out.Confidence = 1
+	}
+	return out, nil
+}
+
+// whisperForm waits for the whole utterance in pcm (16-bit mono at
+// sampleRate) and wraps it as a WAV file in a multipart form with fields.
+func whisperForm(pcm io.Reader, sampleRate int, fields map[string]string) (io.Reader, string, error) {
+	samples, err := io.ReadAll(pcm)
+	if err != nil {
+		return nil, "", err
+	}
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return nil, "", err
+		}
+	}
+	file, err := w.CreateFormFile("file", "speech.wav")
+	if err != nil {
+		return nil, "", err
+	}
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+len(samples)))
+	copy(header[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:24], 1) // mono
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(2*sampleRate))
+	binary.LittleEndian.PutUint16(header[32:34], 2)
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(len(samples)))
+	if _, err := file.Write(append(header, samples...)); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return &form, w.FormDataContentType(), nil
+}
+
+// write passes a frame of caller audio (PCM16LE mono at the source rate)
+// and ends the upload once the utterance is over. It must not be called
+// concurrently.
+func (r *recognizer) write(frame []byte) {
+	if r.ended {
+		return
+	}
+	samples := len(frame) / 2
+	dur := time.Duration(samples) * time.Second / time.Duration(r.sourceRate)
+	if frameLevelDB(frame) >= asrSpeechDB {
+		r.heardSpeech = true
+		r.silence = 0
+		r.speakingOnce.Do(func() { close(r.speaking) })
+	} else {
+		r.silence += dur
+	}
+	if r.heardSpeech {
+		r.length += dur
+	}
+
+	r.buf = r.buf[:0]
+	for i := 0; i+1 < len(frame); i += 2 {
+		r.buf = append(r.buf, int16(binary.LittleEndian.Uint16(frame[i:])))
+	}
+	out := r.buf
+	if r.targetRate != r.sourceRate {
+		out = msdk.Resample(nil, r.targetRate, out, r.sourceRate)
+	}
+	chunk := make([]byte, 2*len(out))
+	for i, v := range out {
+		binary.LittleEndian.PutUint16(chunk[2*i:], uint16(v))
+	}
+	select {
+	case r.frames <- chunk:
+	default:
+	}
+
+	if r.heardSpeech && (r.silence >= asrEndSilence || r.length >= asrMaxUtterance) {
+		r.ended = true
+		close(r.frames)
+	}
+}
+
+// inSpeech reports whether the caller has started an utterance.
+func (r *recognizer) inSpeech() bool {
+	select {
+	case <-r.speaking:
+		return true
+	default:
+		return false
+	}
+}
+
+func frameLevelDB(frame []byte) float64 {
+	n := len(frame) / 2
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for i := 0; i+1 < len(frame); i += 2 {
+		f := float64(int16(binary.LittleEndian.Uint16(frame[i:]))) / math.MaxInt16
+		sum += f * f
+	}
+	return 10 * math.Log10(max(sum/float64(n), 1e-12))
+}
+
+// ivrSpeech routes caller audio of an IVR call to the recognizer of the
+// menu waiting for input, if any.
+type ivrSpeech struct {
+	current atomic.Pointer[recognizer]
+	// mu serializes write with the end of a recognizer.
+	mu sync.Mutex
+}
+
+// write is the IVR's localPort sink.
+func (sp *ivrSpeech) write(frame []byte) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if r := sp.current.Load(); r != nil {
+		r.write(frame)
+	}
+	return nil
+}
+
+func (sp *ivrSpeech) listen(r *recognizer) {
+	sp.current.Store(r)
+}
+
+// stop detaches the current recognizer and ends its upload.
+func (sp *ivrSpeech) stop() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if r := sp.current.Swap(nil); r != nil && !r.ended {
+		r.ended = true
+		close(r.frames)
+	}
+}
+
+// hasSpeechOptions reports whether any option of a menu can be spoken.
+func hasSpeechOptions(options map[string]IVRAction) bool {
+	for _, a := range options {
+		if len(a.Words) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// speechMatch returns the option whose words occur in the transcript; an
+// utterance matching several options selects none.
+func speechMatch(options map[string]IVRAction, transcript string) (IVRAction, bool) {
+	said := " " + strings.Join(speechWords(transcript), " ") + " "
+	var (
+		action IVRAction
+		found  int
+	)
+	for _, a := range options {
+		for _, w := range a.Words {
+			phrase := strings.Join(speechWords(w), " ")
+			if phrase != "" && strings.Contains(said, " "+phrase+" ") {
+				action = a
+				found++
+				break
+			}
+		}
+	}
+	return action, found == 1
+}
+
+// speechWords lowercases text and splits it into words without punctuation.
+func speechWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
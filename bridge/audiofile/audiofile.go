@@ -0,0 +1,131 @@
+// Package audiofile loads short audio clips (ringback, music on hold,
+// announcements) into memory and plays them back as fixed-size PCM frames.
+package audiofile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	msdk "github.com/livekit/media-sdk"
+)
+
+// Clip is decoded mono PCM16 audio.
+type Clip struct {
+	Path       string
+	SampleRate int
+	Samples    msdk.PCM16Sample
+}
+
+// Load decodes a WAV (16-bit PCM) or Ogg/Opus file and resamples it to
+// sampleRate mono.
+func Load(path string, sampleRate int) (*Clip, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		samples msdk.PCM16Sample
+		rate    int
+	)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".wav":
+		samples, rate, err = decodeWAV(data)
+	case ".ogg", ".opus":
+		samples, rate, err = decodeOggOpus(data)
+	default:
+		return nil, fmt.Errorf("%s: unsupported audio file type %q (use .wav or .ogg)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s: no audio", path)
+	}
+	if rate != sampleRate {
+		samples = msdk.Resample(nil, sampleRate, samples, rate)
+	}
+	return &Clip{Path: path, SampleRate: sampleRate, Samples: samples}, nil
+}
+
+// Loop plays a clip over and over. It is safe for concurrent use.
+type Loop struct {
+	clip *Clip
+	mu   sync.Mutex
+	pos  int
+}
+
+// Loop returns a new player positioned at the start of the clip.
+func (c *Clip) Loop() *Loop {
+	return &Loop{clip: c}
+}
+
+// ReadFrame fills frame (PCM16LE mono) with the next part of the clip.
+func (l *Loop) ReadFrame(frame []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; i+1 < len(frame); i += 2 {
+		v := l.next()
+		frame[i] = byte(v)
+		frame[i+1] = byte(uint16(v) >> 8)
+	}
+}
+
+// ReadSample fills dst with the next len(dst) samples of the clip.
+func (l *Loop) ReadSample(dst msdk.PCM16Sample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range dst {
+		dst[i] = l.next()
+	}
+}
+
+func (l *Loop) next() int16 {
+	v := l.clip.Samples[l.pos]
+	l.pos++
+	if l.pos == len(l.clip.Samples) {
+		l.pos = 0
+	}
+	return v
+}
+
+// Once plays a clip a single time. It is safe for concurrent use.
+type Once struct {
+	clip *Clip
+	mu   sync.Mutex
+	pos  int
+}
+
+// Once returns a new one-shot player positioned at the start of the clip.
+func (c *Clip) Once() *Once {
+	return &Once{clip: c}
+}
+
+// MixFrame adds the next part of the clip to frame (PCM16LE mono),
+// clipping at full scale. It reports false once the clip has ended.
+func (o *Once) MixFrame(frame []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	samples := o.clip.Samples
+	for i := 0; i+1 < len(frame) && o.pos < len(samples); i += 2 {
+		v := int32(int16(uint16(frame[i])|uint16(frame[i+1])<<8)) + int32(samples[o.pos])
+		v = min(max(v, -32768), 32767)
+		frame[i] = byte(v)
+		frame[i+1] = byte(uint16(v) >> 8)
+		o.pos++
+	}
+	return o.pos < len(samples)
+}
+
+// ReadSample fills dst with the next part of the clip, padding with silence
+// after its end. It reports false once the clip has ended.
+func (o *Once) ReadSample(dst msdk.PCM16Sample) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := copy(dst, o.clip.Samples[o.pos:])
+	clear(dst[n:])
+	o.pos += n
+	return o.pos < len(o.clip.Samples)
+}
@@ -0,0 +1,48 @@
+package audiofile
+
+import (
+	"errors"
+)
+
+const oggPageHeaderSize = 27
+
+// oggPackets splits an Ogg stream into its packets (first logical stream only).
+func oggPackets(data []byte) ([][]byte, error) {
+	var (
+		packets [][]byte
+		cur     []byte
+		serial  uint32
+		first   = true
+	)
+	for len(data) > 0 {
+		if len(data) < oggPageHeaderSize || string(data[0:4]) != "OggS" {
+			return nil, errors.New("bad ogg page")
+		}
+		pageSerial := uint32(data[14]) | uint32(data[15])<<8 | uint32(data[16])<<16 | uint32(data[17])<<24
+		nseg := int(data[26])
+		if len(data) < oggPageHeaderSize+nseg {
+			return nil, errors.New("truncated ogg page")
+		}
+		lacing := data[oggPageHeaderSize : oggPageHeaderSize+nseg]
+		body := data[oggPageHeaderSize+nseg:]
+		if first {
+			serial, first = pageSerial, false
+		}
+		for _, l := range lacing {
+			if int(l) > len(body) {
+				return nil, errors.New("truncated ogg page")
+			}
+			if pageSerial == serial {
+				cur = append(cur, body[:l]...)
+				// A lacing value below 255 ends the packet.
+				if l < 255 {
+					packets = append(packets, cur)
+					cur = nil
+				}
+			}
+			body = body[l:]
+		}
+		data = body
+	}
+	return packets, nil
+}
@@ -0,0 +1,37 @@
+//go:build (opus || with_opus_c) && cgo
+
+package audiofile
+
+import (
+	"bytes"
+	"errors"
+
+	msdk "github.com/livekit/media-sdk"
+	msdkopus "github.com/livekit/media-sdk/opus"
+	"github.com/livekit/protocol/logger"
+)
+
+const opusSampleRate = 48000
+
+func decodeOggOpus(data []byte) (msdk.PCM16Sample, int, error) {
+	packets, err := oggPackets(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(packets) < 2 || !bytes.HasPrefix(packets[0], []byte("OpusHead")) {
+		return nil, 0, errors.New("not an Ogg/Opus file")
+	}
+	var out msdk.PCM16Sample
+	dec, err := msdkopus.Decode(msdk.NewPCM16BufferWriter(&out, opusSampleRate), 1, logger.GetLogger())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer dec.Close()
+	// Packets 0 and 1 are the OpusHead and OpusTags headers.
+	for _, p := range packets[2:] {
+		if err := dec.WriteSample(msdkopus.Sample(p)); err != nil {
+			return nil, 0, err
+		}
+	}
+	return out, opusSampleRate, nil
+}
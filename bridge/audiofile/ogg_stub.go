@@ -0,0 +1,13 @@
+//go:build !((opus || with_opus_c) && cgo)
+
+package audiofile
+
+import (
+	"errors"
+
+	msdk "github.com/livekit/media-sdk"
+)
+
+func decodeOggOpus([]byte) (msdk.PCM16Sample, int, error) {
+	return nil, 0, errors.New("ogg audio files require building with -tags opus")
+}
@@ -0,0 +1,67 @@
+package audiofile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	msdk "github.com/livekit/media-sdk"
+)
+
+// decodeWAV reads 16-bit PCM WAV data, averaging all channels into mono.
+func decodeWAV(data []byte) (msdk.PCM16Sample, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	var (
+		channels, bits int
+		rate           int
+		haveFmt        bool
+	)
+	chunks := data[12:]
+	for len(chunks) >= 8 {
+		id := string(chunks[0:4])
+		size := int(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		if size > len(body) {
+			size = len(body) // tolerate truncated files
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("short fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+			// 0xFFFE is WAVE_FORMAT_EXTENSIBLE; accept it when it carries 16-bit PCM.
+			if (format != 1 && format != 0xFFFE) || bits != 16 || channels < 1 || rate <= 0 {
+				return nil, 0, fmt.Errorf("unsupported WAV format %d, %d bits, %d channels (need 16-bit PCM)", format, bits, channels)
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, 0, errors.New("data chunk before fmt chunk")
+			}
+			frames := size / (2 * channels)
+			out := make(msdk.PCM16Sample, frames)
+			for i := range out {
+				var sum int32
+				for ch := 0; ch < channels; ch++ {
+					off := (i*channels + ch) * 2
+					sum += int32(int16(binary.LittleEndian.Uint16(body[off : off+2])))
+				}
+				out[i] = int16(sum / int32(channels))
+			}
+			return out, rate, nil
+		}
+		// Chunks are padded to an even size.
+		next := 8 + size + size&1
+		if next > len(chunks) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+	return nil, 0, errors.New("no data chunk")
+}
@@ -0,0 +1,283 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/export"
+	"gotgcalls/bridge/logring"
+	"gotgcalls/bridge/store"
+	"gotgcalls/bridge/webhook"
+	"gotgcalls/third_party/ubot"
+)
+
+var (
+	ErrPreflight      = errors.New("preflight checks failed (preflight.strict)")
+	ErrAlreadyStarted = errors.New("bridge already started")
+	ErrNotStarted     = errors.New("bridge not started")
+)
+
+// Bridge is the whole bridge: the Telegram client and its calls, the SIP
+// stack and the Service joining them, with the state store, CDRs, metrics
+// exporters and webhooks around it. Programs embed it with New, Start and
+// Stop; cmd/sip-tg-bridge is one of them.
+type Bridge struct {
+	cfg    Config
+	logger *slog.Logger
+
+	tgClient  *tg.Client
+	tgCalls   *ubot.Context
+	service   *Service
+	exporters export.Set
+	state     *store.DB
+	cdr       *cdr.Recorder
+	webhooks  *webhook.Sender
+
+	mu        sync.Mutex
+	started   bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+type options struct {
+	logger     *slog.Logger
+	logRing    *logring.Ring
+	configPath string
+	hooks      CallHooks
+	authorizer CallAuthorizer
+	preflight  io.Writer
+}
+
+// Option customizes New.
+type Option func(*options)
+
+// WithLogger logs to logger instead of slog.Default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithLogRing adds the last lines of ring to crash reports; it should be
+// fed by the logger's handler.
+func WithLogRing(ring *logring.Ring) Option {
+	return func(o *options) { o.logRing = ring }
+}
+
+// WithConfigPath is the file Reload and RestartSIP read the config from;
+// without it both fail with ErrNoConfigPath.
+func WithConfigPath(path string) Option {
+	return func(o *options) { o.configPath = path }
+}
+
+// WithCallHooks authorizes and routes calls with hooks.
+func WithCallHooks(hooks CallHooks) Option {
+	return func(o *options) { o.hooks = hooks }
+}
+
+// WithCallAuthorizer decides which calls get through with a instead of
+// the authorizer of the config.
+func WithCallAuthorizer(a CallAuthorizer) Option {
+	return func(o *options) { o.authorizer = a }
+}
+
+// WithPreflightReport writes the preflight report to w instead of stderr.
+func WithPreflightReport(w io.Writer) Option {
+	return func(o *options) { o.preflight = w }
+}
+
+// New sets up a bridge for cfg: it signs in to Telegram (with the session
+// file of cfg), runs the preflight checks and builds the SIP stack, but
+// serves no calls until Start. Telegram command handlers can be added to
+// TelegramClient in between.
+func New(cfg Config, opts ...Option) (_ *Bridge, err error) {
+	o := options{logger: slog.Default(), preflight: os.Stderr}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	logger := o.logger
+	b := &Bridge{cfg: cfg, logger: logger}
+	defer func() {
+		if err != nil {
+			b.close()
+		}
+	}()
+
+	sessionKey, err := ResolveSessionKey(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("telegram session key: %w", err)
+	}
+	if migrated, err := MigrateSessionFile(cfg.TGSession, sessionKey); err != nil {
+		return nil, fmt.Errorf("telegram session migration: %w", err)
+	} else if migrated {
+		logger.Info("telegram session file re-encrypted with configured key", "session", cfg.TGSession)
+	}
+	b.tgClient, err = tg.NewClient(tg.ClientConfig{
+		AppID:         cfg.TGAppID,
+		AppHash:       cfg.TGAppHash,
+		Session:       cfg.TGSession,
+		SessionAESKey: sessionKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("telegram client init: %w", err)
+	}
+	if err := b.tgClient.Start(); err != nil {
+		return nil, fmt.Errorf("telegram client start: %w", err)
+	}
+	if me, err := b.tgClient.GetMe(); err == nil && me != nil {
+		logger.Info("telegram session", "self_id", me.ID, "first_name", me.FirstName, "last_name", me.LastName, "username", me.Username)
+		logger.Info("telegram target", "target_user_id", cfg.TGUserID)
+	} else if err != nil {
+		logger.Warn("telegram getMe failed", "error", err)
+	}
+
+	if cfg.PreflightEnabled {
+		report := RunPreflight(cfg, b.tgClient)
+		fmt.Fprint(o.preflight, report.String())
+		if report.Failed() {
+			if cfg.PreflightStrict {
+				return nil, ErrPreflight
+			}
+			logger.Warn("preflight checks failed, starting anyway")
+		}
+	}
+
+	b.tgCalls = ubot.NewInstance(b.tgClient)
+	ConfigureRTPPorts(cfg)
+	sipStack, err := NewSIPStack(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("sip ua init: %w", err)
+	}
+
+	b.service = NewService(cfg, sipStack, b.tgCalls, logger)
+	b.service.SetTelegramClient(b.tgClient)
+	b.service.SetConfigPath(o.configPath)
+	b.service.SetLogRing(o.logRing)
+	b.service.SetCallHooks(o.hooks)
+	if o.authorizer != nil {
+		b.service.SetCallAuthorizer(o.authorizer)
+	}
+
+	if b.exporters, err = NewExporters(cfg, logger); err != nil {
+		return nil, fmt.Errorf("exporter setup: %w", err)
+	}
+	b.service.SetExporters(b.exporters)
+	if b.state, err = store.Open(cfg.StateFile); err != nil {
+		return nil, fmt.Errorf("state store open %s: %w", cfg.StateFile, err)
+	}
+	b.service.SetStateStore(b.state)
+	if b.cdr, err = NewCDRRecorder(cfg, logger, b.exporters, b.state); err != nil {
+		return nil, fmt.Errorf("cdr setup: %w", err)
+	}
+	b.service.SetCDRRecorder(b.cdr)
+	b.webhooks = NewWebhookSender(cfg, logger)
+	b.service.SetWebhooks(b.webhooks)
+	return b, nil
+}
+
+// Service returns the service for call control (the HTTP and gRPC APIs
+// are built on it).
+func (b *Bridge) Service() *Service {
+	return b.service
+}
+
+// TelegramClient returns the signed-in Telegram client, e.g. to add
+// command handlers.
+func (b *Bridge) TelegramClient() *tg.Client {
+	return b.tgClient
+}
+
+// Config returns the config the bridge was built with.
+func (b *Bridge) Config() Config {
+	return b.cfg
+}
+
+// Start serves calls in the background until ctx ends, Stop is called or
+// the SIP stack fails; Wait returns the error.
+func (b *Bridge) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started {
+		return ErrAlreadyStarted
+	}
+	b.started = true
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.done = make(chan struct{})
+	go func() {
+		err := b.service.Start(ctx)
+		if ctx.Err() != nil {
+			// Stopped on purpose.
+			err = nil
+		}
+		b.logger.Info("shutting down...")
+		b.close()
+		b.err = err
+		close(b.done)
+	}()
+	return nil
+}
+
+// Wait blocks until the bridge stopped and returns why, or nil if it was
+// stopped with Stop or its context.
+func (b *Bridge) Wait() error {
+	b.mu.Lock()
+	done := b.done
+	b.mu.Unlock()
+	if done == nil {
+		return ErrNotStarted
+	}
+	<-done
+	return b.err
+}
+
+// Stop stops accepting calls, lets active ones finish for up to
+// call.drain_timeout (hanging them up early if ctx ends) and shuts the
+// bridge down.
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	cancel := b.cancel
+	b.mu.Unlock()
+	if cancel == nil {
+		b.close()
+		return nil
+	}
+	b.service.Drain(ctx, b.service.Tunables().DrainTimeout)
+	cancel()
+	return b.Wait()
+}
+
+// close releases what New set up.
+func (b *Bridge) close() {
+	b.closeOnce.Do(b.release)
+}
+
+func (b *Bridge) release() {
+	if b.tgCalls != nil {
+		b.tgCalls.Close()
+	}
+	if b.tgClient != nil {
+		b.tgClient.Stop()
+	}
+	if err := b.cdr.Close(); err != nil {
+		b.logger.Warn("cdr close failed", "error", err)
+	}
+	if err := b.webhooks.Close(); err != nil {
+		b.logger.Warn("webhooks close failed", "error", err)
+	}
+	if err := b.exporters.Close(); err != nil {
+		b.logger.Warn("exporter close failed", "error", err)
+	}
+	if b.state != nil {
+		if err := b.state.Close(); err != nil {
+			b.logger.Warn("state store close failed", "error", err)
+		}
+	}
+}
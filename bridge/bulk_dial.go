@@ -0,0 +1,475 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+)
+
+// Bulk dial modes: answered calls are bridged to the Telegram user, or hear
+// an announcement and are hung up.
+const (
+	BulkBridge   = "bridge"
+	BulkAnnounce = "announce"
+)
+
+// States of a bulk dial job and of its numbers.
+const (
+	BulkRunning   = "running"
+	BulkDone      = "done"
+	BulkCancelled = "cancelled"
+
+	BulkPending  = "pending"
+	BulkDialing  = "dialing"
+	BulkAnswered = "answered"
+	BulkFailed   = "failed"
+)
+
+const (
+	// MaxBulkNumbers bounds the numbers of one bulk dial job.
+	MaxBulkNumbers = 10000
+	// maxBulkJobs finished jobs are kept for their report.
+	maxBulkJobs = 20
+	// bulkRetryWait is how often a job checks whether the call limit (or,
+	// bridging, the Telegram user) allows its next call.
+	bulkRetryWait = time.Second
+)
+
+var (
+	ErrNoBulkJob        = errors.New("no such bulk dial job")
+	ErrBulkNoNumbers    = errors.New("no numbers to dial")
+	ErrBulkAnnouncement = errors.New("announce mode needs an announcement file or text")
+)
+
+// BulkDialRequest describes a bulk dial job.
+type BulkDialRequest struct {
+	Numbers []string
+	// CallerID (optional) is one of sip.caller_ids.
+	CallerID string
+	// Mode is BulkBridge (default) or BulkAnnounce.
+	Mode string
+	// AnnouncementFile is played in announce mode; AnnouncementText is
+	// spoken with tts.command instead.
+	AnnouncementFile string
+	AnnouncementText string
+	// Concurrency is the number of calls placed at once (default 1, and
+	// always 1 bridging: the Telegram user takes one call at a time);
+	// Interval is the least time between two call starts.
+	Concurrency int
+	Interval    time.Duration
+}
+
+// BulkDialResult is the outcome of one number of a bulk dial job.
+type BulkDialResult struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+	CallID string `json:"call_id,omitempty"`
+	// Cause is the hangup cause of the call (as in call detail records).
+	Cause     string    `json:"cause,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+	Duration  string    `json:"duration,omitempty"`
+}
+
+// BulkDialJob is the report of a bulk dial job.
+type BulkDialJob struct {
+	ID          string           `json:"id"`
+	Mode        string           `json:"mode"`
+	State       string           `json:"state"`
+	Concurrency int              `json:"concurrency"`
+	Interval    string           `json:"interval"`
+	CreatedAt   time.Time        `json:"created_at"`
+	FinishedAt  time.Time        `json:"finished_at,omitzero"`
+	Answered    int              `json:"answered"`
+	Failed      int              `json:"failed"`
+	Pending     int              `json:"pending"`
+	Results     []BulkDialResult `json:"results"`
+}
+
+// WriteCSV writes the results of the job as CSV with a header row.
+func (j BulkDialJob) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"number", "status", "call_id", "cause", "error", "started_at", "duration"})
+	for _, r := range j.Results {
+		started := ""
+		if !r.StartedAt.IsZero() {
+			started = r.StartedAt.Format(time.RFC3339)
+		}
+		_ = cw.Write([]string{r.Number, r.Status, r.CallID, r.Cause, r.Error, started, r.Duration})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type bulkJob struct {
+	id      string
+	req     BulkDialRequest
+	clip    *audiofile.Clip
+	created time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	state    string
+	finished time.Time
+	results  []BulkDialResult
+}
+
+func (j *bulkJob) info() BulkDialJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := BulkDialJob{
+		ID:          j.id,
+		Mode:        j.req.Mode,
+		State:       j.state,
+		Concurrency: j.req.Concurrency,
+		Interval:    j.req.Interval.String(),
+		CreatedAt:   j.created,
+		FinishedAt:  j.finished,
+		Results:     append([]BulkDialResult(nil), j.results...),
+	}
+	for _, r := range j.results {
+		switch r.Status {
+		case BulkAnswered:
+			info.Answered++
+		case BulkFailed:
+			info.Failed++
+		default:
+			info.Pending++
+		}
+	}
+	return info
+}
+
+func (j *bulkJob) update(i int, f func(r *BulkDialResult)) {
+	j.mu.Lock()
+	f(&j.results[i])
+	j.mu.Unlock()
+}
+
+// ParseNumberList reads the numbers of a bulk dial job from CSV (the first
+// column of each row) or plain text (one number per line). Rows whose first
+// column has no digits, like a header, are skipped, as are duplicates.
+func ParseNumberList(data []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	seen := map[string]bool{}
+	var numbers []string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		number := strings.TrimSpace(rec[0])
+		if !strings.ContainsAny(number, "0123456789") || seen[number] {
+			continue
+		}
+		seen[number] = true
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// StartBulkDial starts dialing the numbers of req one after another, or
+// req.Concurrency at a time at least req.Interval apart, and returns the
+// job for tracking. Calls wait while max_active_calls is reached; bridged
+// calls also wait while the Telegram user is in a call. The Telegram user
+// gets a summary once the job is done.
+func (s *Service) StartBulkDial(ctx context.Context, req BulkDialRequest) (BulkDialJob, error) {
+	if s.draining.Load() {
+		return BulkDialJob{}, ErrDraining
+	}
+	if len(req.Numbers) == 0 {
+		return BulkDialJob{}, ErrBulkNoNumbers
+	}
+	if len(req.Numbers) > MaxBulkNumbers {
+		return BulkDialJob{}, fmt.Errorf("at most %d numbers per job", MaxBulkNumbers)
+	}
+	if req.Mode == "" {
+		req.Mode = BulkBridge
+	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = 1
+	}
+	if req.Interval < 0 {
+		return BulkDialJob{}, errors.New("interval must not be negative")
+	}
+	if req.CallerID != "" {
+		req.CallerID = normalizePhone(req.CallerID)
+		if !s.callerIDAllowed(req.CallerID) {
+			return BulkDialJob{}, ErrCallerID
+		}
+	}
+	job := &bulkJob{
+		id:      newCallID(),
+		req:     req,
+		created: time.Now(),
+		state:   BulkRunning,
+		results: make([]BulkDialResult, len(req.Numbers)),
+	}
+	for i, number := range req.Numbers {
+		job.results[i] = BulkDialResult{Number: number, Status: BulkPending}
+	}
+	switch req.Mode {
+	case BulkBridge:
+		// A private chat carries one call, so a second concurrent call
+		// would find the chat busy and fail without being dialed.
+		job.req.Concurrency = 1
+	case BulkAnnounce:
+		var err error
+		switch {
+		case req.AnnouncementFile != "":
+			job.clip, err = audiofile.Load(req.AnnouncementFile, s.cfg.SampleRate)
+		case req.AnnouncementText != "":
+			job.clip, err = s.synthesize(ctx, req.AnnouncementText)
+		default:
+			err = ErrBulkAnnouncement
+		}
+		if err != nil {
+			return BulkDialJob{}, err
+		}
+	default:
+		return BulkDialJob{}, fmt.Errorf("invalid mode %q (%s or %s)", req.Mode, BulkBridge, BulkAnnounce)
+	}
+
+	ctx, job.cancel = context.WithCancel(ctx)
+	s.bulkMu.Lock()
+	s.pruneBulkJobs()
+	s.bulkJobs[job.id] = job
+	s.bulkMu.Unlock()
+	s.logger.Info("bulk dial: started", "job", job.id, "numbers", len(req.Numbers), "mode", req.Mode,
+		"concurrency", req.Concurrency, "interval", req.Interval)
+	go s.runBulkDial(ctx, job)
+	return job.info(), nil
+}
+
+// BulkDialJobs returns the reports of the running and recent jobs.
+func (s *Service) BulkDialJobs() []BulkDialJob {
+	s.bulkMu.Lock()
+	jobs := make([]*bulkJob, 0, len(s.bulkJobs))
+	for _, job := range s.bulkJobs {
+		jobs = append(jobs, job)
+	}
+	s.bulkMu.Unlock()
+	infos := make([]BulkDialJob, len(jobs))
+	for i, job := range jobs {
+		infos[i] = job.info()
+	}
+	return infos
+}
+
+// BulkDialJob returns the report of job id.
+func (s *Service) BulkDialJob(id string) (BulkDialJob, error) {
+	s.bulkMu.Lock()
+	job, ok := s.bulkJobs[id]
+	s.bulkMu.Unlock()
+	if !ok {
+		return BulkDialJob{}, ErrNoBulkJob
+	}
+	return job.info(), nil
+}
+
+// CancelBulkDial stops job id: numbers not dialed yet are skipped and its
+// calls in progress are hung up.
+func (s *Service) CancelBulkDial(id string) error {
+	s.bulkMu.Lock()
+	job, ok := s.bulkJobs[id]
+	s.bulkMu.Unlock()
+	if !ok {
+		return ErrNoBulkJob
+	}
+	job.mu.Lock()
+	if job.state == BulkRunning {
+		job.state = BulkCancelled
+	}
+	job.mu.Unlock()
+	job.cancel()
+	return nil
+}
+
+// pruneBulkJobs drops the oldest finished jobs beyond maxBulkJobs. Called
+// with bulkMu held.
+func (s *Service) pruneBulkJobs() {
+	for len(s.bulkJobs) >= maxBulkJobs {
+		var oldest *bulkJob
+		for _, job := range s.bulkJobs {
+			job.mu.Lock()
+			done := job.state != BulkRunning
+			job.mu.Unlock()
+			if done && (oldest == nil || job.created.Before(oldest.created)) {
+				oldest = job
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(s.bulkJobs, oldest.id)
+	}
+}
+
+func (s *Service) runBulkDial(ctx context.Context, job *bulkJob) {
+	logger := s.logger.With("bulk_job", job.id)
+	slots := make(chan struct{}, job.req.Concurrency)
+	var wg sync.WaitGroup
+	var next time.Time
+	for i := range job.results {
+		if !waitUntil(ctx, next) {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if !s.waitBulkSlot(ctx, job) {
+			break
+		}
+		next = time.Now().Add(job.req.Interval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.bulkDialOne(ctx, job, i, logger)
+		}()
+	}
+	wg.Wait()
+	job.cancel()
+
+	job.mu.Lock()
+	if job.state == BulkRunning {
+		job.state = BulkDone
+	}
+	job.finished = time.Now()
+	job.mu.Unlock()
+	info := job.info()
+	logger.Info("bulk dial: finished", "state", info.State, "answered", info.Answered, "failed", info.Failed, "skipped", info.Pending)
+	text := fmt.Sprintf("Bulk dial %s %s: %d answered, %d failed", job.id, info.State, info.Answered, info.Failed)
+	if info.Pending > 0 {
+		text += fmt.Sprintf(", %d not dialed", info.Pending)
+	}
+	s.notify(s.cfg.TGUserID, text)
+}
+
+// waitBulkSlot waits until another call of job can be placed; false when
+// the job was cancelled or the bridge shuts down.
+func (s *Service) waitBulkSlot(ctx context.Context, job *bulkJob) bool {
+	chatID := int64(0)
+	if job.req.Mode == BulkBridge {
+		chatID = s.cfg.TGUserID
+	}
+	for {
+		if ctx.Err() != nil || s.draining.Load() {
+			return false
+		}
+		if !s.dialBlocked(chatID) {
+			return true
+		}
+		if !waitUntil(ctx, time.Now().Add(bulkRetryWait)) {
+			return false
+		}
+	}
+}
+
+// bulkDialOne dials the i-th number of job and records the outcome.
+func (s *Service) bulkDialOne(ctx context.Context, job *bulkJob, i int, logger *slog.Logger) {
+	number := job.results[i].Number
+	job.update(i, func(r *BulkDialResult) {
+		r.Status = BulkDialing
+		r.StartedAt = time.Now()
+	})
+	chatID := s.cfg.TGUserID
+	if job.req.Mode == BulkAnnounce {
+		chatID = 0
+	}
+	call, err := s.prepareOutboundCall(chatID, number, job.req.CallerID)
+	if err == nil {
+		call.bulkJob = job.id
+		job.update(i, func(r *BulkDialResult) { r.CallID = call.ID })
+		logger.Info("bulk dial: dialing", "number", number, "bridge_call_id", call.ID)
+		stop := context.AfterFunc(ctx, call.Hangup)
+		if job.req.Mode == BulkAnnounce {
+			err = s.announceCall(ctx, call, job.clip)
+		} else {
+			err = s.runOutboundCall(ctx, call)
+		}
+		stop()
+	}
+	job.update(i, func(r *BulkDialResult) {
+		r.Duration = time.Since(r.StartedAt).Round(time.Second).String()
+		if call != nil {
+			r.Cause = call.hangupCause()
+		}
+		if err != nil {
+			r.Status = BulkFailed
+			r.Error = err.Error()
+			return
+		}
+		r.Status = BulkAnswered
+	})
+	if err != nil {
+		logger.Info("bulk dial: call failed", "number", number, "error", err)
+	}
+}
+
+// announceCall dials call without a Telegram side, plays clip once it is
+// answered and hangs up. It returns nil if the call was answered.
+func (s *Service) announceCall(ctx context.Context, call *Call, clip *audiofile.Clip) error {
+	defer s.activeCalls.Add(-1)
+	defer s.unregisterCall(call)
+	logger := s.logger.With("dial", call.Number, "bridge_call_id", call.ID, "announcement", true)
+	sipMedia, release, err := s.dialSIP(ctx, call, logger)
+	if err != nil {
+		return err
+	}
+	defer release()
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, newLocalPort(s.tgFormat(), nil), tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		call.setCause(cdr.CauseMediaFailure)
+		return err
+	}
+	bridge.Start()
+	defer bridge.Stop()
+	call.setMedia(bridge)
+	playCtx, cancel := context.WithCancel(call.ctx)
+	defer cancel()
+	stop := context.AfterFunc(call.sipDialog().Context(), cancel)
+	defer stop()
+	if s.ivrPlayClip(playCtx, bridge, clip) {
+		call.setCause(cdr.CauseAnnouncement)
+	} else if call.sipDialog().Context().Err() != nil {
+		call.setCause(call.sipHangupCause())
+	} else {
+		call.setCause(cdr.CauseLocalHangup)
+	}
+	return nil
+}
+
+// waitUntil sleeps until t; false when ctx ended first.
+func waitUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
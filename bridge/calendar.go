@@ -0,0 +1,207 @@
+package bridge
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/ical"
+)
+
+// Calendar feed types (calendar.type) and actions for calls during busy
+// events (calendar.action).
+const (
+	CalendarICS    = "ics"
+	CalendarCalDAV = "caldav"
+
+	CalendarVoicemail = "voicemail"
+	CalendarReject    = "reject"
+)
+
+const (
+	// calendarLookBehind and calendarLookAhead bound the events kept from a
+	// refresh; the look-ahead covers calls until the next refresh even when
+	// fetching fails for a while.
+	calendarLookBehind = 24 * time.Hour
+	calendarLookAhead  = 48 * time.Hour
+	calendarTimeout    = 30 * time.Second
+	calendarMaxBody    = 16 << 20
+)
+
+// startCalendar loads calendar.url and keeps it refreshed.
+func (s *Service) startCalendar(ctx context.Context) {
+	if s.cfg.CalendarURL == "" {
+		return
+	}
+	go func() {
+		s.refreshCalendar(ctx)
+		ticker := time.NewTicker(s.cfg.CalendarRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s.refreshCalendar(ctx)
+		}
+	}()
+}
+
+func (s *Service) refreshCalendar(ctx context.Context) {
+	now := time.Now()
+	events, unsupported, err := s.fetchCalendar(ctx, now.Add(-calendarLookBehind), now.Add(calendarLookAhead))
+	if err != nil {
+		// Keep the last events; they reach calendarLookAhead ahead.
+		s.logger.Warn("calendar: refresh failed", "url", s.cfg.CalendarURL, "error", err)
+		return
+	}
+	for _, u := range unsupported {
+		s.logger.Warn("calendar: recurrence rule not supported, only the first occurrence blocks calls", "event", u.Summary, "rrule", u.Rule)
+	}
+	s.calendarMu.Lock()
+	s.calendarEvents = events
+	s.calendarMu.Unlock()
+	s.logger.Debug("calendar: refreshed", "events", len(events))
+}
+
+// calendarBusy reports whether at falls in a busy calendar event, and until
+// when the busy time lasts.
+func (s *Service) calendarBusy(at time.Time) (until time.Time, summary string, busy bool) {
+	s.calendarMu.Lock()
+	defer s.calendarMu.Unlock()
+	return ical.Busy(s.calendarEvents, at)
+}
+
+func (s *Service) fetchCalendar(ctx context.Context, from, to time.Time) ([]ical.Event, []ical.Unsupported, error) {
+	ctx, cancel := context.WithTimeout(ctx, calendarTimeout)
+	defer cancel()
+	var req *http.Request
+	var err error
+	if s.cfg.CalendarType == CalendarCalDAV {
+		req, err = http.NewRequestWithContext(ctx, "REPORT", s.cfg.CalendarURL, strings.NewReader(calendarQuery(from, to)))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+			req.Header.Set("Depth", "1")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.CalendarURL, nil)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.cfg.CalendarUser != "" {
+		req.SetBasicAuth(s.cfg.CalendarUser, s.cfg.CalendarPassword)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, calendarMaxBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("server returned %s", res.Status)
+	}
+	if s.cfg.CalendarType != CalendarCalDAV {
+		return ical.Parse(data, from, to, time.Local)
+	}
+	// The server expanded recurring events already; each response holds one
+	// calendar object.
+	var ms struct {
+		Responses []struct {
+			Data string `xml:"propstat>prop>calendar-data"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, nil, fmt.Errorf("caldav: %w", err)
+	}
+	var (
+		events      []ical.Event
+		unsupported []ical.Unsupported
+	)
+	for _, r := range ms.Responses {
+		if strings.TrimSpace(r.Data) == "" {
+			continue
+		}
+		evs, unsup, err := ical.Parse([]byte(r.Data), from, to, time.Local)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, evs...)
+		unsupported = append(unsupported, unsup...)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, unsupported, nil
+}
+
+// calendarQuery is a CalDAV calendar-query for the events in [from, to),
+// with recurring events expanded by the server.
+func calendarQuery(from, to time.Time) string {
+	const layout = "20060102T150405Z"
+	f, t := from.UTC().Format(layout), to.UTC().Format(layout)
+	return `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-data><C:expand start="` + f + `" end="` + t + `"/></C:calendar-data>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="` + f + `" end="` + t + `"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+}
+
+// calendarMessage fills in the calendar.message template.
+func (s *Service) calendarMessage(until time.Time, summary string) string {
+	return strings.NewReplacer(
+		"{until}", until.Local().Format("15:04"),
+		"{summary}", summary,
+	).Replace(s.cfg.CalendarMessage)
+}
+
+// routeBusyCalendar handles an inbound call that came in during a busy
+// calendar event according to calendar.action. The caller hears
+// calendar.message, spoken with tts.command; without it (or when it fails)
+// the voicemail greeting or the busy announcement plays instead.
+func (s *Service) routeBusyCalendar(dialog *diago.DialogServerSession, call *Call, until time.Time, summary string, logger *slog.Logger) {
+	logger.Info("calendar: call during busy event", "until", until, "action", s.cfg.CalendarAction)
+	text := s.calendarMessage(until, summary)
+	var clip *audiofile.Clip
+	if text != "" && s.cfg.ttsEnabled() {
+		var err error
+		if clip, err = s.synthesize(dialog.Context(), text); err != nil {
+			logger.Warn("calendar: message synthesis failed", "error", err)
+		}
+	}
+	if s.cfg.CalendarAction == CalendarVoicemail {
+		if clip == nil {
+			clip = s.voicemailGreeting
+		}
+		s.recordVoicemail(dialog, call, clip, logger)
+		return
+	}
+	call.setCause(cdr.CauseBusy)
+	s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (busy until %s)", displayParty(call.Name, call.Number), until.Local().Format("15:04")))
+	if clip == nil {
+		s.rejectInbound(dialog, sip.StatusBusyHere, "Busy", AnnounceBusy, false, logger)
+		return
+	}
+	s.rejectInboundClip(dialog, sip.StatusBusyHere, "Busy", clip, false, logger)
+}
@@ -0,0 +1,406 @@
+package bridge
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// Authorizer types (authorizer.type).
+const (
+	// AuthorizerDefault allows every SIP call and telegram.user_id only.
+	AuthorizerDefault = ""
+	// AuthorizerRules applies authorizer.rules, then the default.
+	AuthorizerRules = "rules"
+	// AuthorizerHTTP asks authorizer.http.url.
+	AuthorizerHTTP = "http"
+)
+
+// AuthSource is where a call to authorize comes from.
+type AuthSource string
+
+const (
+	AuthSIP      AuthSource = "sip"
+	AuthTelegram AuthSource = "telegram"
+)
+
+// AuthAction is what a CallAuthorizer decided.
+type AuthAction string
+
+const (
+	AuthAllow    AuthAction = "allow"
+	AuthReject   AuthAction = "reject"
+	AuthRedirect AuthAction = "redirect"
+)
+
+// AuthRequest describes a call to authorize: an inbound SIP call, a
+// private Telegram call to the bridge account or a Telegram command (Callee
+// is the number for /call and empty otherwise).
+type AuthRequest struct {
+	Source AuthSource `json:"source"`
+	// Caller is the SIP From user or the Telegram user ID.
+	Caller     string `json:"caller"`
+	CallerName string `json:"caller_name,omitempty"`
+	// Callee is the SIP To user or the number a Telegram user dials.
+	Callee string `json:"callee,omitempty"`
+	// Trunk is the host the INVITE came from; empty for Telegram.
+	Trunk string    `json:"trunk,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// AuthDecision is the answer of a CallAuthorizer.
+type AuthDecision struct {
+	Action AuthAction `json:"action"`
+	// Status and Reason are the SIP response of a rejected SIP call (403
+	// Forbidden by default).
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Target is the number a redirect goes to: SIP callers get a 302 to it,
+	// Telegram users are connected to it instead.
+	Target string `json:"target,omitempty"`
+}
+
+// CallAuthorizer decides which calls get through. It is asked on the
+// goroutine setting up the call, so it should answer quickly; an error
+// rejects SIP calls with 503 and leaves Telegram to telegram.user_id.
+type CallAuthorizer interface {
+	AuthorizeCall(ctx context.Context, req AuthRequest) (AuthDecision, error)
+}
+
+// defaultAuthorizer allows every SIP call (the caller lists, script and
+// hooks still apply) and Telegram user userID only.
+type defaultAuthorizer struct {
+	userID int64
+}
+
+func (a defaultAuthorizer) AuthorizeCall(_ context.Context, req AuthRequest) (AuthDecision, error) {
+	if req.Source == AuthTelegram && req.Caller != strconv.FormatInt(a.userID, 10) {
+		return AuthDecision{Action: AuthReject, Reason: "unexpected user"}, nil
+	}
+	return AuthDecision{Action: AuthAllow}, nil
+}
+
+// AuthRule is an entry of authorizer.rules. Empty fields match anything;
+// Caller and Callee are caller rule patterns (a number or /regex/), Trunk a
+// host or /regex/, Hours a local time window such as "09:00-18:00" (it may
+// wrap midnight) and Days three-letter weekdays.
+type AuthRule struct {
+	Source AuthSource `yaml:"source"`
+	Caller string     `yaml:"caller"`
+	Callee string     `yaml:"callee"`
+	Trunk  string     `yaml:"trunk"`
+	Hours  string     `yaml:"hours"`
+	Days   []string   `yaml:"days"`
+	Action AuthAction `yaml:"action"`
+	Status int        `yaml:"status"`
+	Reason string     `yaml:"reason"`
+	Target string     `yaml:"target"`
+}
+
+// authRule is an AuthRule with its time window parsed.
+type authRule struct {
+	AuthRule
+	// from and to are minutes after midnight; from == to is all day.
+	from, to int
+	days     []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func compileAuthRule(r AuthRule) (authRule, error) {
+	c := authRule{AuthRule: r}
+	switch r.Source {
+	case "", AuthSIP, AuthTelegram:
+	default:
+		return c, fmt.Errorf("unknown source %q (want sip or telegram)", r.Source)
+	}
+	switch r.Action {
+	case AuthAllow:
+	case AuthReject:
+		if r.Status != 0 && (r.Status < 400 || r.Status > 699) {
+			return c, fmt.Errorf("invalid status %d", r.Status)
+		}
+	case AuthRedirect:
+		if strings.TrimSpace(r.Target) == "" {
+			return c, fmt.Errorf("redirect needs a target")
+		}
+	default:
+		return c, fmt.Errorf("unknown action %q (want allow, reject or redirect)", r.Action)
+	}
+	for _, p := range []string{r.Caller, r.Callee, r.Trunk} {
+		if p != "" {
+			if err := validateCallerRule(p); err != nil {
+				return c, err
+			}
+		}
+	}
+	if r.Hours != "" {
+		from, to, ok := strings.Cut(r.Hours, "-")
+		var err1, err2 error
+		c.from, err1 = parseClockMinutes(from)
+		c.to, err2 = parseClockMinutes(to)
+		if !ok || err1 != nil || err2 != nil {
+			return c, fmt.Errorf("invalid hours %q (want HH:MM-HH:MM)", r.Hours)
+		}
+	}
+	for _, d := range r.Days {
+		name := strings.ToLower(strings.TrimSpace(d))
+		day, ok := weekdays[name[:min(3, len(name))]]
+		if !ok {
+			return c, fmt.Errorf("invalid day %q", d)
+		}
+		c.days = append(c.days, day)
+	}
+	return c, nil
+}
+
+func parseClockMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r authRule) matches(req AuthRequest) bool {
+	if r.Source != "" && r.Source != req.Source {
+		return false
+	}
+	if r.Caller != "" && !callerRuleMatches(r.Caller, req.Caller) {
+		return false
+	}
+	if r.Callee != "" && !callerRuleMatches(r.Callee, req.Callee) {
+		return false
+	}
+	if r.Trunk != "" && !trunkMatches(r.Trunk, req.Trunk) {
+		return false
+	}
+	local := req.Time.Local()
+	if len(r.days) > 0 && !slices.Contains(r.days, local.Weekday()) {
+		return false
+	}
+	if r.from != r.to {
+		now := local.Hour()*60 + local.Minute()
+		if r.from < r.to {
+			return now >= r.from && now < r.to
+		}
+		return now >= r.from || now < r.to
+	}
+	return true
+}
+
+// trunkMatches compares a host, or matches a /regex/ against it.
+func trunkMatches(pattern, host string) bool {
+	if re, ok := strings.CutPrefix(pattern, "/"); ok {
+		re, _ = strings.CutSuffix(re, "/")
+		matched, err := regexp.MatchString(re, host)
+		return err == nil && matched
+	}
+	return strings.EqualFold(strings.TrimSpace(pattern), host)
+}
+
+// RuleAuthorizer decides with the first matching rule, and with fallback
+// when none matches.
+type RuleAuthorizer struct {
+	rules    []authRule
+	fallback CallAuthorizer
+}
+
+// NewRuleAuthorizer checks rules; a nil fallback allows every SIP call and
+// no Telegram user.
+func NewRuleAuthorizer(rules []AuthRule, fallback CallAuthorizer) (*RuleAuthorizer, error) {
+	a := &RuleAuthorizer{fallback: fallback}
+	for i, r := range rules {
+		c, err := compileAuthRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("authorizer.rules[%d]: %w", i, err)
+		}
+		a.rules = append(a.rules, c)
+	}
+	if a.fallback == nil {
+		a.fallback = defaultAuthorizer{}
+	}
+	return a, nil
+}
+
+func (a *RuleAuthorizer) AuthorizeCall(ctx context.Context, req AuthRequest) (AuthDecision, error) {
+	for _, r := range a.rules {
+		if r.matches(req) {
+			return AuthDecision{Action: r.Action, Status: r.Status, Reason: r.Reason, Target: r.Target}, nil
+		}
+	}
+	return a.fallback.AuthorizeCall(ctx, req)
+}
+
+// authMaxBody bounds the answer of an HTTP authorizer.
+const authMaxBody = 64 << 10
+
+// HTTPAuthorizer posts each AuthRequest as JSON to a URL and expects an
+// AuthDecision back.
+type HTTPAuthorizer struct {
+	URL     string
+	Timeout time.Duration
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (a *HTTPAuthorizer) AuthorizeCall(ctx context.Context, req AuthRequest) (AuthDecision, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return AuthDecision{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return AuthDecision{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range a.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return AuthDecision{}, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, authMaxBody))
+	if err != nil {
+		return AuthDecision{}, err
+	}
+	if res.StatusCode >= 300 {
+		return AuthDecision{}, fmt.Errorf("authorizer returned %s: %s", res.Status, strings.TrimSpace(string(data[:min(len(data), 512)])))
+	}
+	var d AuthDecision
+	if err := json.Unmarshal(data, &d); err != nil {
+		return AuthDecision{}, fmt.Errorf("authorizer answer: %w", err)
+	}
+	switch d.Action {
+	case AuthAllow:
+	case AuthReject:
+		// Anything but a final error response would not reject the call.
+		if d.Status != 0 && (d.Status < 400 || d.Status > 699) {
+			d.Status, d.Reason = sip.StatusForbidden, "Forbidden"
+		}
+	case AuthRedirect:
+		if d.Target == "" {
+			return AuthDecision{}, fmt.Errorf("authorizer redirect without a target")
+		}
+	default:
+		return AuthDecision{}, fmt.Errorf("authorizer answered unknown action %q", d.Action)
+	}
+	return d, nil
+}
+
+// newConfigAuthorizer builds the authorizer of cfg.
+func newConfigAuthorizer(cfg Config) (CallAuthorizer, error) {
+	def := defaultAuthorizer{userID: cfg.TGUserID}
+	switch cfg.Authorizer {
+	case AuthorizerRules:
+		return NewRuleAuthorizer(cfg.AuthRules, def)
+	case AuthorizerHTTP:
+		return &HTTPAuthorizer{URL: cfg.AuthURL, Timeout: cfg.AuthTimeout, Headers: cfg.AuthHeaders}, nil
+	default:
+		return def, nil
+	}
+}
+
+// SetCallAuthorizer replaces the authorizer of the config (authorizer.type)
+// with a. Must be called before Start.
+func (s *Service) SetCallAuthorizer(a CallAuthorizer) {
+	s.authorizer = a
+}
+
+// authorize asks the authorizer about req. Errors reject SIP calls with
+// 503; Telegram falls back to telegram.user_id, so a failing authorizer
+// doesn't lock its owner out.
+func (s *Service) authorize(ctx context.Context, req AuthRequest) AuthDecision {
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+	d, err := s.authorizer.AuthorizeCall(ctx, req)
+	if err != nil {
+		s.logger.Warn("call authorizer failed", "source", req.Source, "caller", req.Caller, "error", err)
+		if req.Source == AuthTelegram {
+			d, _ = defaultAuthorizer{userID: s.cfg.TGUserID}.AuthorizeCall(ctx, req)
+			return d
+		}
+		return AuthDecision{Action: AuthReject, Status: sip.StatusServiceUnavailable, Reason: "Authorization Unavailable"}
+	}
+	if d.Action == AuthReject && d.Status == 0 {
+		d.Status, d.Reason = sip.StatusForbidden, cmp.Or(d.Reason, "Forbidden")
+	}
+	return d
+}
+
+// AuthorizeTelegram asks the authorizer whether Telegram user userID may
+// use the bridge, or with callee set, call that number.
+func (s *Service) AuthorizeTelegram(ctx context.Context, userID int64, callee string) AuthDecision {
+	return s.authorize(ctx, AuthRequest{
+		Source: AuthTelegram,
+		Caller: strconv.FormatInt(userID, 10),
+		Callee: callee,
+	})
+}
+
+// authorizeInbound asks the authorizer about a new inbound SIP call and
+// rejects or redirects it. It reports whether the call was turned away.
+func (s *Service) authorizeInbound(dialog *diago.DialogServerSession, call *Call, logger *slog.Logger) bool {
+	trunk, _, err := net.SplitHostPort(dialog.InviteRequest.Source())
+	if err != nil {
+		trunk = dialog.InviteRequest.Source()
+	}
+	d := s.authorize(dialog.Context(), AuthRequest{
+		Source:     AuthSIP,
+		Caller:     call.Number,
+		CallerName: call.Name,
+		Callee:     call.Local,
+		Trunk:      trunk,
+	})
+	switch d.Action {
+	case AuthReject:
+		logger.Info("sip: call rejected (authorizer)", "status", d.Status, "reason", d.Reason)
+		call.setCause(cdr.CauseUnauthorized)
+		s.rejectInbound(dialog, d.Status, d.Reason, "", false, logger)
+		return true
+	case AuthRedirect:
+		target, err := s.buildOutboundURI(d.Target)
+		if err != nil {
+			logger.Warn("sip: authorizer redirect target invalid", "target", d.Target, "error", err)
+			call.setCause(cdr.CauseUnauthorized)
+			_ = dialog.Respond(sip.StatusServiceUnavailable, "Invalid Redirect", nil)
+			return true
+		}
+		logger.Info("sip: call redirected (authorizer)", "target", target.String())
+		call.setCause(cdr.CauseForwarded)
+		_ = dialog.Respond(sip.StatusMovedTemporarily, "Moved Temporarily", nil, &sip.ContactHeader{Address: target})
+		return true
+	}
+	return false
+}
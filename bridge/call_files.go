@@ -0,0 +1,219 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subdirectories of call_files.dir that processed call files are moved to.
+const (
+	callFilesDone   = "done"
+	callFilesFailed = "failed"
+)
+
+// CallFile is a click-to-dial request dropped into call_files.dir, in the
+// spirit of Asterisk call files.
+type CallFile struct {
+	// Number is dialed like /call dials it.
+	Number string
+	// CallerID (optional) is one of sip.caller_ids.
+	CallerID string
+	// ChatID (optional) bridges the call into the voice chat of a group
+	// instead of calling the Telegram user.
+	ChatID int64
+}
+
+// ParseCallFile reads a call file: one "key: value" (flat YAML) or
+// "key = value" (INI) pair per line, with number, caller_id and chat_id as
+// keys. Keys are case-insensitive and may leave out the underscore
+// (Asterisk's CallerID); # and ; start comments.
+func ParseCallFile(data []byte) (CallFile, error) {
+	var cf CallFile
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+		i := strings.IndexAny(line, ":=")
+		if i < 0 {
+			return CallFile{}, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(strings.TrimSpace(line[:i])))
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+		switch key {
+		case "number":
+			cf.Number = value
+		case "callerid", "from":
+			cf.CallerID = value
+		case "chatid":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return CallFile{}, fmt.Errorf("line %d: invalid chat_id %q", n, value)
+			}
+			if id >= 0 {
+				return CallFile{}, fmt.Errorf("line %d: %w", n, ErrNotGroupChat)
+			}
+			cf.ChatID = id
+		default:
+			return CallFile{}, fmt.Errorf("line %d: unknown key %q", n, strings.TrimSpace(line[:i]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return CallFile{}, err
+	}
+	if cf.Number == "" {
+		return CallFile{}, errors.New("number is required")
+	}
+	return cf, nil
+}
+
+// startCallFiles watches call_files.dir: each file dropped into it places
+// a call, and the file is moved to done/ once the call was bridged or to
+// failed/ (with the error appended) otherwise. Files with a modification
+// time in the future wait for it, so touch -d schedules a call. Files are
+// picked up while the call limit and the Telegram user allow another call.
+func (s *Service) startCallFiles(ctx context.Context) {
+	dir := s.cfg.CallFilesDir
+	if dir == "" {
+		return
+	}
+	for _, sub := range []string{callFilesDone, callFilesFailed} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			s.logger.Warn("call files: directory setup failed", "dir", dir, "error", err)
+			return
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(s.cfg.CallFilesPoll)
+		defer ticker.Stop()
+		// active holds the files whose call is in progress.
+		active := map[string]bool{}
+		finished := make(chan string)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case name := <-finished:
+				delete(active, name)
+				continue
+			case <-ticker.C:
+			}
+			if s.draining.Load() {
+				continue
+			}
+			for _, name := range s.pendingCallFiles(active) {
+				active[name] = true
+				go func() {
+					s.runCallFile(ctx, name)
+					select {
+					case finished <- name:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		}
+	}()
+	s.logger.Info("call files: watching", "dir", dir)
+}
+
+// pendingCallFiles lists the call files due now that are not in active,
+// oldest first. Hidden and editor temporary files are skipped, so a file
+// can be written under such a name and renamed into place.
+func (s *Service) pendingCallFiles(active map[string]bool) []string {
+	entries, err := os.ReadDir(s.cfg.CallFilesDir)
+	if err != nil {
+		s.logger.Warn("call files: read failed", "dir", s.cfg.CallFilesDir, "error", err)
+		return nil
+	}
+	type pending struct {
+		name string
+		mod  time.Time
+	}
+	var due []pending
+	now := time.Now()
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || active[name] || strings.HasPrefix(name, ".") ||
+			strings.HasSuffix(name, "~") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(now) {
+			continue
+		}
+		due = append(due, pending{name, info.ModTime()})
+	}
+	slices.SortFunc(due, func(a, b pending) int { return a.mod.Compare(b.mod) })
+	names := make([]string, len(due))
+	for i, p := range due {
+		names[i] = p.name
+	}
+	return names
+}
+
+// runCallFile places the call of the call file name and files it away
+// once the call ended. The file stays queued while no call can be placed.
+func (s *Service) runCallFile(ctx context.Context, name string) {
+	path := filepath.Join(s.cfg.CallFilesDir, name)
+	logger := s.logger.With("call_file", name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Warn("call files: read failed", "error", err)
+		return
+	}
+	cf, err := ParseCallFile(data)
+	if err != nil {
+		logger.Warn("call files: invalid call file", "error", err)
+		s.fileCallFile(path, callFilesFailed, "invalid: "+err.Error())
+		return
+	}
+	if s.dialBlocked(s.callFileChat(cf)) {
+		return
+	}
+	call, err := s.prepareOutboundCall(s.callFileChat(cf), cf.Number, cf.CallerID)
+	if errors.Is(err, ErrCallLimit) {
+		return
+	}
+	if err == nil {
+		logger.Info("call files: dialing", "number", cf.Number, "bridge_call_id", call.ID)
+		err = s.runOutboundCall(ctx, call)
+	}
+	if err != nil {
+		logger.Warn("call files: call failed", "number", cf.Number, "error", err)
+		s.fileCallFile(path, callFilesFailed, "failed: "+err.Error())
+		return
+	}
+	s.fileCallFile(path, callFilesDone, "done: "+call.ID)
+}
+
+func (s *Service) callFileChat(cf CallFile) int64 {
+	if cf.ChatID != 0 {
+		return cf.ChatID
+	}
+	return s.cfg.TGUserID
+}
+
+// fileCallFile appends a result comment to the call file at path and moves
+// it into the subdirectory sub.
+func (s *Service) fileCallFile(path, sub, result string) {
+	if f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0); err == nil {
+		_, _ = fmt.Fprintf(f, "\n# %s %s\n", time.Now().Format(time.RFC3339), result)
+		_ = f.Close()
+	}
+	dst := filepath.Join(filepath.Dir(path), sub, filepath.Base(path))
+	if err := os.Rename(path, dst); err != nil {
+		s.logger.Warn("call files: move failed", "file", path, "to", dst, "error", err)
+		// Don't dial it again.
+		_ = os.Remove(path)
+	}
+}
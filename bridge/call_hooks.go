@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// ErrCallRejected is returned for outbound calls CallHooks.Authorize
+// rejected; it wraps the hook's error.
+var ErrCallRejected = errors.New("call rejected")
+
+// CallHooks let a program embedding the bridge authorize and route calls in
+// Go, next to (and after) script.file. Hooks run on the goroutine setting
+// up the call, so they should return quickly.
+type CallHooks struct {
+	// Authorize (optional) is asked about each new call before it rings
+	// anyone. An error rejects it: inbound calls get 403, or the status of
+	// a *RejectError; outbound calls fail with ErrCallRejected.
+	Authorize func(call CallInfo) error
+	// RouteInbound (optional) returns the Telegram chat an inbound call
+	// rings instead of its default one, or 0 to keep that.
+	RouteInbound func(call CallInfo) int64
+	// RouteOutbound (optional) returns the number an outbound call dials
+	// instead, or "" to dial the requested one.
+	RouteOutbound func(call CallInfo) string
+}
+
+// RejectError rejects an inbound call with a SIP status when returned by
+// CallHooks.Authorize.
+type RejectError struct {
+	Status int
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected with %d %s", e.Status, e.Reason)
+}
+
+// SetCallHooks installs hooks for authorizing and routing calls. Must be
+// called before Start.
+func (s *Service) SetCallHooks(hooks CallHooks) {
+	s.hooks = hooks
+}
+
+// hookInbound runs the call hooks for a new inbound call, rejecting it or
+// routing it to another chat. It reports whether the call was rejected.
+func (s *Service) hookInbound(dialog *diago.DialogServerSession, call *Call, logger *slog.Logger) bool {
+	if s.hooks.Authorize != nil {
+		if err := s.hooks.Authorize(call.Info()); err != nil {
+			status, reason := sip.StatusForbidden, "Forbidden"
+			var rej *RejectError
+			if errors.As(err, &rej) {
+				status, reason = rej.Status, rej.Reason
+			}
+			logger.Info("sip: call rejected (hook)", "error", err, "status", status)
+			call.setCause(cdr.CauseHookRejected)
+			s.rejectInbound(dialog, status, reason, "", false, logger)
+			return true
+		}
+	}
+	if s.hooks.RouteInbound != nil {
+		if chatID := s.hooks.RouteInbound(call.Info()); chatID != 0 {
+			logger.Info("sip: call routed by hook", "tg_chat_id", chatID)
+			call.setChatID(chatID)
+		}
+	}
+	return false
+}
+
+// hookOutbound runs the call hooks for an outbound call about to be placed
+// and returns the number a hook routed it to, if any.
+func (s *Service) hookOutbound(info CallInfo, logger *slog.Logger) (string, error) {
+	if s.hooks.Authorize != nil {
+		if err := s.hooks.Authorize(info); err != nil {
+			logger.Info("outbound call rejected by hook", "error", err)
+			return "", fmt.Errorf("%w: %w", ErrCallRejected, err)
+		}
+	}
+	if s.hooks.RouteOutbound != nil {
+		if number := s.hooks.RouteOutbound(info); number != "" {
+			if err := s.validateDialTarget(number); err != nil {
+				return "", err
+			}
+			logger.Info("outbound call routed by hook", "number", number)
+			return number, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,38 @@
+package bridge
+
+// MuteMode is what the Telegram user paused of a bridged call.
+type MuteMode string
+
+const (
+	// MuteOff: audio flows both ways.
+	MuteOff MuteMode = ""
+	// MuteMic: the SIP party no longer hears the Telegram user (/mute).
+	MuteMic MuteMode = "mic"
+	// MuteHold: neither side hears the other (/hold). Unlike a SIP hold
+	// there is no re-INVITE; both legs stay up as they are.
+	MuteHold MuteMode = "hold"
+)
+
+// SetMute pauses the audio of call as mode asks, or resumes it for
+// MuteOff. The SIP party hears comfort noise in place of the Telegram
+// user, the Telegram user silence on hold.
+func (s *Service) SetMute(call *Call, mode MuteMode) error {
+	media := call.mediaBridge()
+	if media == nil {
+		return ErrNotBridged
+	}
+	media.SetMute(mode != MuteOff, mode == MuteHold)
+	s.logger.Info("call: mute changed", "bridge_call_id", call.ID, "mute", mode)
+	return nil
+}
+
+// muteMode is the MuteMode of media.
+func muteMode(media *MediaBridge) MuteMode {
+	switch toSIP, toTG := media.Muted(); {
+	case toTG:
+		return MuteHold
+	case toSIP:
+		return MuteMic
+	}
+	return MuteOff
+}
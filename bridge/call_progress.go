@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// CallProgress is a step of an outbound call started with /call, for the
+// live status of the command's message.
+type CallProgress struct {
+	// State is CallRinging (a provisional response), CallAnswered or
+	// CallEnded.
+	State CallState
+	// SIPStatus and SIPReason are the response behind the step: the
+	// provisional one while ringing, the final one of a failed INVITE (0
+	// when it failed otherwise).
+	SIPStatus int
+	SIPReason string
+	// Cause is the hangup cause (cdr.Cause*) and Duration the talk time of
+	// an ended call.
+	Cause    string
+	Duration time.Duration
+}
+
+// Text describes the step in a few words.
+func (p CallProgress) Text() string {
+	switch p.State {
+	case CallRinging:
+		if p.SIPStatus == sip.StatusRinging {
+			return "Ringing..."
+		}
+		return fmt.Sprintf("Ringing... (%d %s)", p.SIPStatus, p.SIPReason)
+	case CallAnswered:
+		return "Answered"
+	case CallEnded:
+	default:
+		return string(p.State)
+	}
+	switch {
+	case p.Duration > 0:
+		return "Hung up after " + p.Duration.Round(time.Second).String()
+	case p.SIPStatus == sip.StatusBusyHere || p.SIPStatus == sip.StatusGlobalBusyEverywhere:
+		return "Busy"
+	case p.SIPStatus == sip.StatusRequestTerminated || p.Cause == cdr.CauseLocalHangup:
+		return "Cancelled"
+	case p.SIPStatus != 0:
+		return fmt.Sprintf("Failed: %d %s", p.SIPStatus, p.SIPReason)
+	case p.Cause == cdr.CauseNoAnswer:
+		return "No answer"
+	case p.Cause == cdr.CauseTelegramHangup:
+		return "Hung up before answer"
+	}
+	return "Failed (" + p.Cause + ")"
+}
+
+// reportProgress passes a step of call to its progress callback, if any.
+func (c *Call) reportProgress(p CallProgress) {
+	if c.progress != nil {
+		c.progress(p)
+	}
+}
+
+// runOutboundCallWithProgress runs call, reporting its answer and end to
+// progress along with the provisional responses reportProgress passes on.
+func (s *Service) runOutboundCallWithProgress(ctx context.Context, call *Call, progress func(CallProgress)) error {
+	if progress == nil {
+		return s.runOutboundCall(ctx, call)
+	}
+	call.progress = progress
+	var ended CallProgress
+	unsubscribe := s.events.Subscribe(func(ev CallEvent) {
+		if ev.Call != call {
+			return
+		}
+		switch ev.To {
+		case CallAnswered:
+			progress(CallProgress{State: CallAnswered})
+		case CallEnded:
+			rec := call.cdrRecord(ev.Time)
+			ended = CallProgress{
+				State:    CallEnded,
+				Cause:    ev.Cause,
+				Duration: time.Duration(rec.Duration * float64(time.Second)),
+			}
+		}
+	})
+	err := s.runOutboundCall(ctx, call)
+	unsubscribe()
+	if res := inviteFailureResponse(err); res != nil {
+		ended.SIPStatus, ended.SIPReason = res.StatusCode, res.Reason
+	}
+	if ended.State == CallEnded {
+		progress(ended)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package bridge
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gotgcalls/bridge/recording"
+)
+
+var (
+	ErrNotBridged      = errors.New("media not bridged yet")
+	ErrRecordingActive = errors.New("recording already active")
+	ErrNotRecording    = errors.New("not recording")
+	ErrDiskFull        = errors.New("not enough free disk space for recording")
+)
+
+// RecordingKey returns the AES-256 key for encrypting recordings, taken from
+// recording.encryption_key or the environment variable named by
+// recording.encryption_key_env and stretched with SHA-256 like the session
+// key. It returns nil when encryption is not configured.
+func RecordingKey(cfg Config) ([]byte, error) {
+	secret := cfg.RecordingEncryptionKey
+	if secret == "" && cfg.RecordingEncryptionKeyEnv != "" {
+		secret = os.Getenv(cfg.RecordingEncryptionKeyEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("recording key env %q is empty", cfg.RecordingEncryptionKeyEnv)
+		}
+	}
+	if secret == "" {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:], nil
+}
+
+// StartRecording begins recording call and returns the files being written.
+func (s *Service) StartRecording(call *Call) ([]string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return nil, ErrNotBridged
+	}
+	if media.Recording() {
+		return nil, ErrRecordingActive
+	}
+	if !s.storage.HasSpace() {
+		return nil, ErrDiskFull
+	}
+	rec, err := recording.Start(s.recordingOptions(), recording.Vars{
+		ID:        call.ID,
+		Direction: string(call.Direction),
+		Number:    call.Number,
+		ChatID:    call.ChatID,
+		StartedAt: call.StartedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !media.StartRecording(rec) {
+		_ = rec.Close()
+		return nil, ErrRecordingActive
+	}
+	call.setRecorded()
+	if s.recordingNotice != nil {
+		media.PlaySIP(s.recordingNotice.Once())
+	}
+	return rec.Files(), nil
+}
+
+// StopRecording finalizes the active recording of call and returns its files.
+func (s *Service) StopRecording(call *Call) ([]string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return nil, ErrNotBridged
+	}
+	rec := media.StopRecording()
+	if rec == nil {
+		return nil, ErrNotRecording
+	}
+	return rec.Files(), nil
+}
+
+func (s *Service) recordingOptions() recording.Options {
+	tgFormat := s.tgFormat()
+	opts := recording.Options{
+		Dir:        s.cfg.RecordingDir,
+		Template:   s.cfg.RecordingTemplate,
+		Format:     s.cfg.RecordingFormat,
+		Mixed:      s.cfg.RecordingMixed,
+		Key:        s.recordingKey,
+		SampleRate: tgFormat.SampleRate,
+		FrameBytes: tgFormat.FrameBytes(),
+	}
+	if s.cfg.PostProcess {
+		opts.OnClose = func(files []string) { s.queuePostProcess(files, nil) }
+	}
+	return opts
+}
+
+// attachPreRoll lets recordings started mid-call begin recording.pre_roll in
+// the past.
+func (s *Service) attachPreRoll(bridge *MediaBridge) {
+	if s.cfg.RecordingPreRoll <= 0 {
+		return
+	}
+	format := s.tgFormat()
+	frames := int(s.cfg.RecordingPreRoll / format.FrameDur)
+	bridge.SetPreRoll(recording.NewPreRoll(frames, format.FrameBytes()))
+}
+
+// autoRecord starts recording a freshly bridged call when recording.enabled is set.
+func (s *Service) autoRecord(call *Call, logger *slog.Logger) {
+	if !s.cfg.RecordingEnabled {
+		return
+	}
+	if _, err := s.StartRecording(call); err != nil {
+		logger.Warn("recording start failed", "error", err)
+	}
+}
+
+// toggleRecording flips recording on or off in response to a DTMF command.
+func (s *Service) toggleRecording(call *Call, logger *slog.Logger) {
+	media := call.mediaBridge()
+	if media == nil {
+		return
+	}
+	if media.Recording() {
+		if _, err := s.StopRecording(call); err != nil {
+			logger.Warn("recording stop failed", "error", err)
+		}
+		return
+	}
+	if _, err := s.StartRecording(call); err != nil {
+		logger.Warn("recording start failed", "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/export"
+	"gotgcalls/bridge/store"
+)
+
+// NewCDRRecorder builds a CDR recorder with the sinks enabled in cfg, plus
+// exporters and the state store (for CallHistory) if any. It returns a
+// recorder without sinks (a no-op) when none are configured.
+func NewCDRRecorder(cfg Config, logger *slog.Logger, exporters export.Set, state *store.DB) (*cdr.Recorder, error) {
+	var sinks []cdr.Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			_ = s.Close()
+		}
+	}
+	if cfg.CDRJSONFile != "" {
+		sink, err := cdr.NewJSONFileSink(cfg.CDRJSONFile)
+		if err != nil {
+			return nil, fmt.Errorf("cdr json sink: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.CDRCSVFile != "" {
+		sink, err := cdr.NewCSVSink(cfg.CDRCSVFile)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("cdr csv sink: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.CDRWebhookURL != "" {
+		sinks = append(sinks, cdr.NewWebhookSink(cfg.CDRWebhookURL, cfg.CDRWebhookTimeout))
+	}
+	if len(exporters) > 0 {
+		sinks = append(sinks, exportSink{set: exporters})
+	}
+	if state != nil && cfg.StateCDRLimit > 0 {
+		sinks = append(sinks, stateSink{db: state, limit: cfg.StateCDRLimit})
+	}
+	return cdr.NewRecorder(logger, sinks...), nil
+}
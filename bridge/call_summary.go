@@ -0,0 +1,164 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// summaryPrefix starts the callback data of the call summary buttons:
+// "summary:<action>:<number>[|<name>]".
+const summaryPrefix = "summary:"
+
+// Call summary actions.
+const (
+	summaryCallBack = "call"
+	summaryAlias    = "alias"
+	summaryBlock    = "block"
+)
+
+// maxCallbackData is Telegram's limit on the data of a callback button.
+const maxCallbackData = 64
+
+// startCallSummaries messages a summary to the Telegram user after each
+// answered or outbound call (call.summary); bulk dial calls are left to the
+// job report. Bot accounts get buttons to
+// call back, add the number as a contact alias or block it; user accounts,
+// which can't send buttons, get the commands instead.
+func (s *Service) startCallSummaries(ctx context.Context) {
+	if !s.cfg.CallSummary || s.tgClient == nil {
+		return
+	}
+	if me, err := s.tgClient.GetMe(); err == nil && me != nil && me.Bot {
+		s.summaryButtons = true
+		s.tgClient.On("callback:^"+summaryPrefix, func(cb *tg.CallbackQuery) error {
+			return s.handleSummaryButton(ctx, cb)
+		})
+	}
+	s.events.Subscribe(func(ev CallEvent) {
+		if ev.To != CallEnded || ev.Call.bulkJob != "" {
+			return
+		}
+		rec := ev.Call.cdrRecord(ev.Time)
+		if rec.AnswerTime.IsZero() && ev.Call.Direction != CallOutbound {
+			// Missed and rejected calls have their own messages.
+			return
+		}
+		go s.sendCallSummary(ev.Call, rec)
+	})
+}
+
+func (s *Service) sendCallSummary(call *Call, rec cdr.Record) {
+	text := callSummaryText(call, rec)
+	if !s.summaryButtons {
+		text += fmt.Sprintf("\n/call %[1]s · /alias %[1]s <name> · /block %[1]s", call.Number)
+		s.notify(s.cfg.TGUserID, text)
+		return
+	}
+	if len(summaryData(summaryCallBack, call.Number, "")) > maxCallbackData {
+		// A SIP URI too long for the buttons.
+		s.notify(s.cfg.TGUserID, text)
+		return
+	}
+	// A name from the SIP display name, to save with Add contact.
+	name := call.Name
+	if lookupPhone(s.ContactAliases(), call.Number) != "" {
+		name = ""
+	}
+	kb := tg.NewKeyboard().AddRow(
+		tg.Button.Data("Call back", summaryData(summaryCallBack, call.Number, "")),
+		tg.Button.Data("Add contact", summaryData(summaryAlias, call.Number, name)),
+		tg.Button.Data("Block", summaryData(summaryBlock, call.Number, "")),
+	)
+	if _, err := s.tgClient.SendMessage(s.cfg.TGUserID, text, &tg.SendOptions{ReplyMarkup: kb.Build()}); err != nil {
+		s.logger.Warn("tg call summary failed", "bridge_call_id", call.ID, "error", err)
+	}
+}
+
+// callSummaryText describes a finished call in two lines, plus its
+// captured SIP headers.
+func callSummaryText(call *Call, rec cdr.Record) string {
+	var b strings.Builder
+	party := displayParty(call.Name, call.Number)
+	if call.Direction == CallInbound {
+		b.WriteString("Call from " + party)
+	} else {
+		b.WriteString("Call to " + party)
+	}
+	if len(rec.SIPHeaders) > 0 {
+		b.WriteString("\n" + headerLines(rec.SIPHeaders))
+	}
+	if rec.AnswerTime.IsZero() {
+		fmt.Fprintf(&b, "\nNot answered (%s)", rec.HangupCause)
+		return b.String()
+	}
+	details := []string{(time.Duration(rec.Duration * float64(time.Second))).Round(time.Second).String()}
+	if rec.Codec != "" {
+		details = append(details, rec.Codec)
+	}
+	if rec.MOS > 0 {
+		details = append(details, fmt.Sprintf("MOS %.1f", rec.MOS))
+	}
+	if rec.Recorded {
+		details = append(details, "recorded")
+	}
+	b.WriteString("\n" + strings.Join(details, " · "))
+	return b.String()
+}
+
+// summaryData builds the callback data of a summary button; name is
+// dropped when it doesn't fit.
+func summaryData(action, number, name string) string {
+	data := summaryPrefix + action + ":" + number
+	if name != "" && len(data)+1+len(name) <= maxCallbackData {
+		data += "|" + name
+	}
+	return data
+}
+
+// handleSummaryButton runs the action of a call summary button.
+func (s *Service) handleSummaryButton(ctx context.Context, cb *tg.CallbackQuery) error {
+	if s.AuthorizeTelegram(ctx, cb.GetSenderID(), "").Action != AuthAllow {
+		return nil
+	}
+	action, rest, _ := strings.Cut(strings.TrimPrefix(cb.DataString(), summaryPrefix), ":")
+	number, name, _ := strings.Cut(rest, "|")
+	var (
+		answer string
+		err    error
+	)
+	switch action {
+	case summaryCallBack:
+		answer = "Calling " + number
+		go func() {
+			if err := s.StartCallFromCommand(ctx, number, "", nil); err != nil {
+				s.logger.Warn("call back failed", "number", number, "error", err)
+				s.notify(s.cfg.TGUserID, fmt.Sprintf("Call to %s failed: %v", number, err))
+			}
+		}()
+	case summaryAlias:
+		if name == "" {
+			answer = fmt.Sprintf("Send /alias %s <name>", number)
+			break
+		}
+		if err = s.SetContactAlias(number, name); err == nil {
+			answer = fmt.Sprintf("%s saved as %s", number, name)
+		}
+	case summaryBlock:
+		if err = s.AddCallerRule(CallerDeny, number); err == nil {
+			answer = number + " blocked"
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		answer = err.Error()
+	}
+	_, err = cb.Answer(answer, &tg.CallbackOptions{Alert: true})
+	return err
+}
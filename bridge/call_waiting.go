@@ -0,0 +1,190 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// BusyWait is the call.busy_action that offers calls for a user in a call
+// as a waiting call.
+const BusyWait = "wait"
+
+var ErrNoHeldCall = errors.New("no call on hold")
+
+// holdPort is the Telegram side of a call on hold for call waiting: the SIP
+// party hears the hold music (or silence), and nobody hears them.
+type holdPort struct {
+	*localPort
+}
+
+func (s *Service) newHoldPort() *holdPort {
+	format := s.tgFormat()
+	var next func(frame []byte)
+	if s.holdMusic != nil {
+		next = s.holdMusic.Loop().ReadFrame
+	}
+	p := &holdPort{}
+	// Paced by the audio of the SIP party, like the echo.
+	p.localPort = newLocalPort(format, func([]byte) error {
+		frame := make([]byte, format.FrameBytes())
+		if next != nil {
+			next(frame)
+		}
+		p.speak(frame)
+		return nil
+	})
+	return p
+}
+
+func (p *holdPort) Close() {
+	p.finish()
+}
+
+// tgCallOf returns the call that has the Telegram call of the private chat
+// chatID, if it's bridged.
+func (s *Service) tgCallOf(chatID int64) *Call {
+	session := s.getTGSession(chatID)
+	if session == nil || chatID < 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.calls {
+		if c.tgLeg() == tgLeg(session) && c.mediaBridge() != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// heldCall returns the call of chatID on hold for call waiting, other than
+// except.
+func (s *Service) heldCall(chatID int64, except *Call) *Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.calls {
+		if c.waitingHeld() && c != except && c.ChatID == chatID {
+			return c
+		}
+	}
+	return nil
+}
+
+// callWaitingFor returns the bridged call that inbound call would be waiting
+// for with call.busy_action wait, or nil when it can't wait: the action is
+// another, the user isn't in a bridged call, or a call is already held.
+func (s *Service) callWaitingFor(call *Call) *Call {
+	if s.cfg.BusyAction != BusyWait {
+		return nil
+	}
+	active := s.tgCallOf(call.ChatID)
+	if active == nil || s.heldCall(call.ChatID, nil) != nil {
+		return nil
+	}
+	return active
+}
+
+// awaitCallWaiting tells the Telegram user about call waiting while they
+// talk to active and waits for them to take it (/answer) or decline it; the
+// caller keeps hearing ringback until then. It reports whether the call was
+// taken; otherwise it has been dealt with.
+func (s *Service) awaitCallWaiting(dialog *diago.DialogServerSession, call, active *Call, earlyMediaSent bool, stopRingback func(), logger *slog.Logger) bool {
+	notice := fmt.Sprintf("Call waiting: %s to %s\nReply /answer to put %s on hold and take it, or /decline (call %s)",
+		displayParty(call.Name, call.Number), call.Local, displayParty(active.Name, active.Number), call.ID)
+	decision := s.awaitInboundDecision(dialog, call, notice, logger)
+	stopRingback()
+	switch decision {
+	case decisionAnswer:
+		return true
+	case decisionDecline:
+		logger.Info("sip: waiting call declined by telegram user")
+		call.setCause(cdr.CauseDeclined)
+		s.rejectInbound(dialog, sip.StatusGlobalDecline, "Decline", AnnounceDeclined, earlyMediaSent, logger)
+	case decisionTimeout:
+		logger.Info("sip: waiting call not answered by telegram user")
+		if s.voicemailEnabled() {
+			s.takeVoicemail(dialog, call, logger)
+			return false
+		}
+		call.setCause(cdr.CauseNoAnswer)
+		s.rejectInbound(dialog, sip.StatusBusyHere, "Busy", AnnounceNoAnswer, earlyMediaSent, logger)
+	case decisionCancelled:
+		call.setCause(cdr.CauseCancelled)
+	}
+	return false
+}
+
+// swapTG exchanges the Telegram legs of two bridged calls: for call
+// waiting, the one with the Telegram call goes on hold and the held one
+// takes it.
+func (s *Service) swapTG(a, b *Call) error {
+	legA, legB := a.tgLeg(), b.tgLeg()
+	mediaA, mediaB := a.mediaBridge(), b.mediaBridge()
+	if legA == nil || legB == nil || mediaA == nil || mediaB == nil {
+		return ErrNotBridged
+	}
+	// Both legs stay open, so neither call takes the swap for a hangup.
+	a.setTGLeg(legB)
+	b.setTGLeg(legA)
+	if err := mediaA.ReplaceTG(legB); err != nil {
+		a.setTGLeg(legA)
+		b.setTGLeg(legB)
+		return err
+	}
+	if err := mediaB.ReplaceTG(legA); err != nil {
+		_ = mediaA.ReplaceTG(legA)
+		a.setTGLeg(legA)
+		b.setTGLeg(legB)
+		return err
+	}
+	if vb := s.getVideoBridge(a.ChatID); vb != nil {
+		// Call waiting is audio only.
+		vb.stop()
+	}
+	s.setHoldState(a, a.waitingHeld() || mediaA.OnHold())
+	s.setHoldState(b, b.waitingHeld() || mediaB.OnHold())
+	return nil
+}
+
+// SwapHeld puts the bridged call of chatID on hold and resumes its held
+// call, returning the resumed call.
+func (s *Service) SwapHeld(chatID int64) (*Call, error) {
+	active := s.tgCallOf(chatID)
+	held := s.heldCall(chatID, active)
+	if active == nil || held == nil {
+		return nil, ErrNoHeldCall
+	}
+	if err := s.swapTG(active, held); err != nil {
+		return nil, err
+	}
+	s.logger.Info("call waiting: swapped", "resumed", held.ID, "held", active.ID)
+	return held, nil
+}
+
+// releaseTGLeg ends the Telegram leg of a finished call. If another call of
+// the chat is on hold, it gets the Telegram call and resumes instead.
+func (s *Service) releaseTGLeg(call *Call) {
+	if leg := call.tgLeg(); leg != nil && !call.waitingHeld() {
+		if h := s.heldCall(call.ChatID, call); h != nil {
+			select {
+			case <-leg.Done():
+				// The Telegram call itself ended: so does the held call.
+				h.Hangup()
+			default:
+				if err := s.swapTG(call, h); err != nil {
+					s.logger.Warn("call waiting: resume failed", "bridge_call_id", h.ID, "error", err)
+				} else {
+					s.logger.Info("call waiting: held call resumed", "bridge_call_id", h.ID)
+					s.notify(s.cfg.TGUserID, "Resumed call with "+displayParty(h.Name, h.Number))
+				}
+			}
+		}
+	}
+	call.closeTGLeg()
+}
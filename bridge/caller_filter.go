@@ -0,0 +1,207 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CallerList names one of the inbound caller lists.
+type CallerList string
+
+const (
+	// CallerAllow, when not empty, admits only matching callers.
+	CallerAllow CallerList = "allow"
+	// CallerDeny rejects matching callers; it wins over CallerAllow.
+	CallerDeny CallerList = "deny"
+)
+
+var (
+	ErrUnknownCallerList  = errors.New("unknown caller list (want allow or deny)")
+	ErrCallerRuleNotFound = errors.New("caller rule not found")
+	// ErrCallerRuleConfigured is returned when removing a rule that comes
+	// from the config file; those can only be changed there.
+	ErrCallerRuleConfigured = errors.New("caller rule is set in the config file")
+)
+
+// callerRejectReasons are the SIP statuses blocked callers may get.
+var callerRejectReasons = map[int]string{
+	603: "Decline",
+	486: "Busy Here",
+	404: "Not Found",
+}
+
+// CallerRule is an entry of a caller list. Pattern is a phone number
+// (compared by digits only) or a regular expression between slashes
+// matched against the caller as sent by SIP, e.g. "/^\+7/". Runtime rules
+// were added through the API or Telegram and last until restart.
+type CallerRule struct {
+	Pattern string `json:"pattern"`
+	Runtime bool   `json:"runtime,omitempty"`
+}
+
+// CallerRules are the caller lists in effect.
+type CallerRules struct {
+	Allow []CallerRule `json:"allow"`
+	Deny  []CallerRule `json:"deny"`
+}
+
+// String renders the lists for Telegram replies.
+func (r CallerRules) String() string {
+	var b strings.Builder
+	for _, l := range []struct {
+		name  string
+		rules []CallerRule
+	}{{"Allowed", r.Allow}, {"Blocked", r.Deny}} {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		if len(l.rules) == 0 {
+			fmt.Fprintf(&b, "%s: none", l.name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:", l.name)
+		for _, rule := range l.rules {
+			b.WriteString("\n  " + rule.Pattern)
+			if rule.Runtime {
+				b.WriteString(" (until restart)")
+			}
+		}
+	}
+	if len(r.Allow) == 0 {
+		b.WriteString("\nEveryone not blocked may call.")
+	}
+	return b.String()
+}
+
+// validateCallerRule checks that pattern is a usable rule.
+func validateCallerRule(pattern string) error {
+	if re, ok := strings.CutPrefix(pattern, "/"); ok {
+		re, ok = strings.CutSuffix(re, "/")
+		if !ok || re == "" {
+			return fmt.Errorf("caller rule %q: regular expressions must be written as /pattern/", pattern)
+		}
+		if _, err := regexp.Compile(re); err != nil {
+			return fmt.Errorf("caller rule %q: %w", pattern, err)
+		}
+		return nil
+	}
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New("caller rule is empty")
+	}
+	return nil
+}
+
+// callerRuleMatches reports whether number matches pattern. Numbers without
+// digits (e.g. "anonymous") are compared case-insensitively.
+func callerRuleMatches(pattern, number string) bool {
+	if re, ok := strings.CutPrefix(pattern, "/"); ok {
+		re, _ = strings.CutSuffix(re, "/")
+		matched, err := regexp.MatchString(re, number)
+		return err == nil && matched
+	}
+	if want := phoneDigits(pattern); want != "" {
+		return want == phoneDigits(number)
+	}
+	return strings.EqualFold(strings.TrimSpace(pattern), number)
+}
+
+// CallerRules returns the configured and runtime caller lists.
+func (s *Service) CallerRules() CallerRules {
+	t := s.Tunables()
+	s.callersMu.Lock()
+	defer s.callersMu.Unlock()
+	return CallerRules{
+		Allow: callerRules(t.CallersAllow, s.callersAllow),
+		Deny:  callerRules(t.CallersDeny, s.callersDeny),
+	}
+}
+
+func callerRules(configured, runtime []string) []CallerRule {
+	rules := make([]CallerRule, 0, len(configured)+len(runtime))
+	for _, p := range configured {
+		rules = append(rules, CallerRule{Pattern: p})
+	}
+	for _, p := range runtime {
+		rules = append(rules, CallerRule{Pattern: p, Runtime: true})
+	}
+	return rules
+}
+
+// AddCallerRule adds pattern to list until restart.
+func (s *Service) AddCallerRule(list CallerList, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if err := validateCallerRule(pattern); err != nil {
+		return err
+	}
+	s.callersMu.Lock()
+	defer s.callersMu.Unlock()
+	rules, err := s.runtimeCallerList(list)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(*rules, pattern) {
+		*rules = append(*rules, pattern)
+	}
+	s.logger.Info("caller rule added", "list", list, "pattern", pattern)
+	return nil
+}
+
+// RemoveCallerRule removes a rule added with AddCallerRule.
+func (s *Service) RemoveCallerRule(list CallerList, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	t := s.Tunables()
+	s.callersMu.Lock()
+	defer s.callersMu.Unlock()
+	rules, err := s.runtimeCallerList(list)
+	if err != nil {
+		return err
+	}
+	if i := slices.Index(*rules, pattern); i >= 0 {
+		*rules = slices.Delete(*rules, i, i+1)
+		s.logger.Info("caller rule removed", "list", list, "pattern", pattern)
+		return nil
+	}
+	configured := t.CallersAllow
+	if list == CallerDeny {
+		configured = t.CallersDeny
+	}
+	if slices.Contains(configured, pattern) {
+		return ErrCallerRuleConfigured
+	}
+	return ErrCallerRuleNotFound
+}
+
+// runtimeCallerList returns the runtime rules of list. s.callersMu must be
+// held.
+func (s *Service) runtimeCallerList(list CallerList) (*[]string, error) {
+	switch list {
+	case CallerAllow:
+		return &s.callersAllow, nil
+	case CallerDeny:
+		return &s.callersDeny, nil
+	default:
+		return nil, ErrUnknownCallerList
+	}
+}
+
+// screenCaller returns why number may not call in, or "" when it may.
+func (s *Service) screenCaller(number string) string {
+	rules := s.CallerRules()
+	for _, r := range rules.Deny {
+		if callerRuleMatches(r.Pattern, number) {
+			return "denied by " + r.Pattern
+		}
+	}
+	if len(rules.Allow) == 0 {
+		return ""
+	}
+	for _, r := range rules.Allow {
+		if callerRuleMatches(r.Pattern, number) {
+			return ""
+		}
+	}
+	return "not on allow list"
+}
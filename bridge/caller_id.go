@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// tgContactsTTL is how long the Telegram contact list is cached.
+const tgContactsTTL = 10 * time.Minute
+
+// callerName returns a friendly name for number: from the aliases set with
+// /alias, contacts.names, then the Telegram account's contacts
+// (contacts.telegram), then the SIP display name. It returns "" when
+// nothing is known.
+func (s *Service) callerName(number, displayName string) string {
+	if name := lookupPhone(s.ContactAliases(), number); name != "" {
+		return name
+	}
+	if name := lookupPhone(s.cfg.ContactNames, number); name != "" {
+		return name
+	}
+	if s.cfg.ContactsFromTelegram {
+		if name := lookupPhone(s.telegramContacts(), number); name != "" {
+			return name
+		}
+	}
+	displayName = strings.Trim(displayName, `" `)
+	if displayName == number {
+		return ""
+	}
+	return displayName
+}
+
+// telegramContacts returns the account's contacts by phone number, fetching
+// them at most every tgContactsTTL.
+func (s *Service) telegramContacts() map[string]string {
+	s.contactsMu.Lock()
+	defer s.contactsMu.Unlock()
+	if s.tgClient == nil || time.Since(s.tgContactsAt) < tgContactsTTL {
+		return s.tgContacts
+	}
+	s.tgContactsAt = time.Now()
+	res, err := s.tgClient.ContactsGetContacts(0)
+	if err != nil {
+		s.logger.Warn("tg contacts lookup failed", "error", err)
+		return s.tgContacts
+	}
+	list, ok := res.(*tg.ContactsContactsObj)
+	if !ok {
+		return s.tgContacts
+	}
+	contacts := make(map[string]string, len(list.Users))
+	for _, u := range list.Users {
+		user, ok := u.(*tg.UserObj)
+		if !ok || user.Phone == "" {
+			continue
+		}
+		name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+		if name == "" && user.Username != "" {
+			name = "@" + user.Username
+		}
+		if name != "" {
+			contacts[user.Phone] = name
+		}
+	}
+	s.tgContacts = contacts
+	return contacts
+}
+
+// lookupPhone finds number in entries, comparing digits only. When there is
+// no exact match, the last 10 digits are compared so national and
+// international formats of the same number match.
+func lookupPhone[V any](entries map[string]V, number string) V {
+	var suffixMatch V
+	want := phoneDigits(number)
+	if want == "" {
+		return suffixMatch
+	}
+	for n, v := range entries {
+		have := phoneDigits(n)
+		if have == want {
+			return v
+		}
+		if len(have) >= 10 && len(want) >= 10 && have[len(have)-10:] == want[len(want)-10:] {
+			suffixMatch = v
+		}
+	}
+	return suffixMatch
+}
+
+func phoneDigits(s string) string {
+	return strings.TrimPrefix(normalizePhone(s), "+")
+}
+
+// displayParty renders a call party as "Name (number)", or just the number.
+func displayParty(name, number string) string {
+	if name == "" {
+		return number
+	}
+	return name + " (" + number + ")"
+}
@@ -0,0 +1,507 @@
+package bridge
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/webhook"
+)
+
+type CallDirection string
+
+const (
+	CallInbound  CallDirection = "inbound"
+	CallOutbound CallDirection = "outbound"
+)
+
+// Call tracks one bridged SIP<->TG call so control surfaces (API, Telegram
+// commands) can list and manipulate it while the call handler owns its lifecycle.
+type Call struct {
+	ID        string
+	Direction CallDirection
+	// Number is the remote SIP party (caller for inbound, callee for outbound).
+	Number string
+	// Local is our SIP party (dialed user for inbound, From user for outbound).
+	Local string
+	// callerID, when set, is presented as the From and P-Asserted-Identity
+	// user of an outbound call instead of sip.auth_user.
+	callerID string
+	// Name is the friendly name of the remote party (contacts or SIP display
+	// name), empty if unknown.
+	Name      string
+	ChatID    int64
+	StartedAt time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	state      CallState
+	sipCallID  string
+	media      *MediaBridge
+	answeredAt time.Time
+	codec      string
+	cause      string
+	// recorded is set once a recording of the call started.
+	recorded bool
+	// dtmf holds recent digits for matching DTMF command sequences.
+	dtmf string
+
+	// sip is the current SIP dialog; a transfer replaces it. sipEnded is
+	// closed when the current dialog ends.
+	sip        sipDialog
+	sipEnded   chan struct{}
+	sipEndOnce sync.Once
+	// referred is set once the SIP party accepted our transfer request.
+	referred bool
+	// tg is the current Telegram leg; /conference replaces it. tgEnded is
+	// closed when the current leg ends.
+	tg        tgLeg
+	tgEnded   chan struct{}
+	tgEndOnce sync.Once
+	// mixer, set for calls added to a conference (AddToCall), is where the
+	// call gets its Telegram leg instead of calling Telegram itself.
+	mixer *endpoints.Mixer
+	// decision receives the user's answer while an inbound call waits for
+	// confirmation; nil otherwise.
+	decision chan bool
+
+	// ring is the ring profile of an inbound caller; ringing how the call
+	// rings with it applied.
+	ring    RingProfile
+	ringing Ringing
+	// codecs limits the SIP leg to these codecs (script set_codec); set
+	// before the SIP leg is set up.
+	codecs []string
+	// redirects are the targets the outbound INVITE was redirected to.
+	redirects []string
+	// sipHeaders are the headers of an inbound INVITE matching
+	// sip.capture_headers; set before the call is registered.
+	sipHeaders map[string]string
+	// scriptMu runs the on_dtmf hooks of the call one at a time.
+	scriptMu sync.Mutex
+	// videoClip starts the video.clip capture once.
+	videoClip sync.Once
+	// answerHook sends the call.answered webhook once.
+	answerHook sync.Once
+	// alerted is closed once the callee of an outbound call rings without
+	// early media.
+	alerted     chan struct{}
+	alertedOnce sync.Once
+	// progress receives the steps of an outbound call started with /call;
+	// set before the call runs.
+	progress func(CallProgress)
+	// bulkJob is the bulk dial job that placed the call; set before the
+	// call runs.
+	bulkJob string
+}
+
+// CallInfo is a point-in-time snapshot of a Call.
+type CallInfo struct {
+	ID        string        `json:"id"`
+	Direction CallDirection `json:"direction"`
+	Number    string        `json:"number"`
+	Name      string        `json:"name,omitempty"`
+	ChatID    int64         `json:"chat_id"`
+	SIPCallID string        `json:"sip_call_id,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  string        `json:"duration"`
+	State     CallState     `json:"state"`
+	Bridged   bool          `json:"bridged"`
+	Recording bool          `json:"recording"`
+	OnHold    bool          `json:"on_hold"`
+	// Mute is what the Telegram user paused (/mute, /hold).
+	Mute MuteMode `json:"mute,omitempty"`
+	// SIPHeaders are the captured headers of an inbound call
+	// (sip.capture_headers).
+	SIPHeaders map[string]string `json:"sip_headers,omitempty"`
+}
+
+func newCall(direction CallDirection, number string, chatID int64) *Call {
+	ctx, cancel := context.WithCancel(context.Background())
+	state := CallRinging
+	if direction == CallOutbound {
+		state = CallConnectingTG
+	}
+	return &Call{
+		ID:        newCallID(),
+		Direction: direction,
+		Number:    number,
+		ChatID:    chatID,
+		StartedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		sipEnded:  make(chan struct{}),
+		tgEnded:   make(chan struct{}),
+		alerted:   make(chan struct{}),
+		state:     state,
+	}
+}
+
+func newCallID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Hangup asks the call handler to terminate both legs.
+func (c *Call) Hangup() {
+	c.cancel()
+}
+
+// Done is closed once Hangup was requested.
+func (c *Call) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c *Call) setSIPCallID(id string) {
+	c.mu.Lock()
+	c.sipCallID = id
+	c.mu.Unlock()
+}
+
+// setChatID routes the call to another Telegram chat before it is dialed.
+func (c *Call) setChatID(chatID int64) {
+	c.mu.Lock()
+	c.ChatID = chatID
+	c.mu.Unlock()
+}
+
+func (c *Call) setMedia(b *MediaBridge) {
+	c.mu.Lock()
+	c.media = b
+	c.mu.Unlock()
+}
+
+func (c *Call) mediaBridge() *MediaBridge {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.media
+}
+
+// State returns the current lifecycle state of the call.
+func (c *Call) State() CallState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// transition moves the call to state to if callTransitions allows it and
+// returns the previous state.
+func (c *Call) transition(to CallState) (from CallState, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from = c.state
+	if !slices.Contains(callTransitions[from], to) {
+		return from, false
+	}
+	c.state = to
+	if to == CallAnswered && c.answeredAt.IsZero() {
+		c.answeredAt = time.Now()
+	}
+	return from, true
+}
+
+func (c *Call) setCodec(name string) {
+	c.mu.Lock()
+	c.codec = name
+	c.mu.Unlock()
+}
+
+func (c *Call) setRecorded() {
+	c.mu.Lock()
+	c.recorded = true
+	c.mu.Unlock()
+}
+
+func (c *Call) addRedirect(target string) {
+	c.mu.Lock()
+	c.redirects = append(c.redirects, target)
+	c.mu.Unlock()
+}
+
+// hangupCause returns why the call ended, defaulting to normal clearing.
+func (c *Call) hangupCause() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cause == "" {
+		return cdr.CauseNormal
+	}
+	return c.cause
+}
+
+// setCause records why the call ended. The first cause wins, so the most
+// specific failure is kept when teardown triggers further errors.
+func (c *Call) setCause(cause string) {
+	c.mu.Lock()
+	if c.cause == "" {
+		c.cause = cause
+	}
+	c.mu.Unlock()
+}
+
+// setSIPDialog makes d the SIP leg of the call.
+func (c *Call) setSIPDialog(d sipDialog) {
+	c.mu.Lock()
+	c.sip = d
+	c.mu.Unlock()
+	go func() {
+		<-d.Context().Done()
+		if c.sipDialog() == d {
+			c.sipEndOnce.Do(func() { close(c.sipEnded) })
+		}
+	}()
+}
+
+func (c *Call) sipDialog() sipDialog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sip
+}
+
+// setTGLeg makes leg the Telegram leg of the call.
+func (c *Call) setTGLeg(leg tgLeg) {
+	c.mu.Lock()
+	c.tg = leg
+	c.mu.Unlock()
+	go func() {
+		<-leg.Done()
+		if c.tgLeg() == leg {
+			c.tgEndOnce.Do(func() { close(c.tgEnded) })
+		}
+	}()
+}
+
+func (c *Call) tgLeg() tgLeg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tg
+}
+
+// tgDone is closed when the current Telegram leg ends, following
+// /conference.
+func (c *Call) tgDone() <-chan struct{} {
+	return c.tgEnded
+}
+
+// waitingHeld reports whether the call is on hold for call waiting.
+func (c *Call) waitingHeld() bool {
+	_, held := c.tgLeg().(*holdPort)
+	return held
+}
+
+// closeTGLeg closes the current Telegram leg.
+func (c *Call) closeTGLeg() {
+	if leg := c.tgLeg(); leg != nil {
+		leg.Close()
+	}
+}
+
+// sipDone is closed when the current SIP leg ends, following transfers.
+func (c *Call) sipDone() <-chan struct{} {
+	return c.sipEnded
+}
+
+// markAlerted records that the callee rings (a provisional response
+// without early media).
+func (c *Call) markAlerted() {
+	c.alertedOnce.Do(func() { close(c.alerted) })
+}
+
+// sipAlerted is closed once the callee rings.
+func (c *Call) sipAlerted() <-chan struct{} {
+	return c.alerted
+}
+
+// sipHangupCause is the hangup cause for the SIP leg ending.
+func (c *Call) sipHangupCause() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.referred {
+		return cdr.CauseTransferred
+	}
+	return cdr.CauseSIPHangup
+}
+
+func (c *Call) resetDTMF() {
+	c.mu.Lock()
+	c.dtmf = ""
+	c.mu.Unlock()
+}
+
+// Stats returns media counters of the call. ok is false until media is bridged.
+func (c *Call) Stats() (stats MediaStats, ok bool) {
+	c.mu.Lock()
+	media := c.media
+	c.mu.Unlock()
+	if media == nil {
+		return MediaStats{}, false
+	}
+	return media.Stats(), true
+}
+
+func (c *Call) Info() CallInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := CallInfo{
+		ID:        c.ID,
+		Direction: c.Direction,
+		Number:    c.Number,
+		Name:      c.Name,
+		ChatID:    c.ChatID,
+		SIPCallID: c.sipCallID,
+		StartedAt: c.StartedAt,
+		Duration:  time.Since(c.StartedAt).Round(time.Second).String(),
+		State:     c.state,
+		Bridged:   c.media != nil,
+		Recording: c.media != nil && c.media.Recording(),
+		OnHold:    c.media != nil && c.media.OnHold(),
+
+		SIPHeaders: maps.Clone(c.sipHeaders),
+	}
+	if c.media != nil {
+		info.Mute = muteMode(c.media)
+	}
+	return info
+}
+
+// cdrRecord builds the detail record of a finished call.
+func (c *Call) cdrRecord(end time.Time) cdr.Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rec := cdr.Record{
+		ID:          c.ID,
+		Direction:   string(c.Direction),
+		SIPCallID:   c.sipCallID,
+		TGChatID:    c.ChatID,
+		StartTime:   c.StartedAt,
+		AnswerTime:  c.answeredAt,
+		EndTime:     end,
+		Codec:       c.codec,
+		HangupCause: c.cause,
+		Redirects:   slices.Clone(c.redirects),
+		Recorded:    c.recorded,
+		SIPHeaders:  maps.Clone(c.sipHeaders),
+	}
+	if rec.HangupCause == "" {
+		rec.HangupCause = cdr.CauseNormal
+	}
+	if c.Direction == CallInbound {
+		rec.Caller, rec.Callee = c.Number, c.Local
+		rec.CallerName = c.Name
+	} else {
+		rec.Caller, rec.Callee = c.Local, c.Number
+		rec.CalleeName = c.Name
+	}
+	if !c.answeredAt.IsZero() {
+		rec.Duration = end.Sub(c.answeredAt).Seconds()
+	}
+	if c.media != nil {
+		st := c.media.Stats()
+		var loss float64
+		if total := st.SIPPacketsReceived + st.SIPPacketsLost; total > 0 {
+			loss = 100 * float64(st.SIPPacketsLost) / float64(total)
+		}
+		// Half the RTCP round trip plus our playout backlog when known.
+		delay := cdrAssumedDelay
+		if st.SIPRTTMs > 0 {
+			delay = time.Duration(st.SIPRTTMs/2+st.SIPToTGBufferMs) * time.Millisecond
+		}
+		rec.MOS = cdr.EstimateMOS(c.codec, loss, delay)
+		rec.JitterMs = st.SIPJitterMs
+		rec.RTTMs = st.SIPRTTMs
+	}
+	return rec
+}
+
+// cdrAssumedDelay approximates one-way delay for the MOS estimate when RTCP
+// gave no round trip: jitter buffer plus TG playout backlog plus network.
+const cdrAssumedDelay = 150 * time.Millisecond
+
+func (s *Service) registerCall(call *Call) {
+	s.mu.Lock()
+	s.calls[call.ID] = call
+	s.mu.Unlock()
+	s.webhooks.Send(webhook.CallStarted, call.Info())
+}
+
+// unregisterCall removes call from the registry and ends it, which emits its
+// CDR. It is safe to call for calls that were rejected before being
+// registered.
+func (s *Service) unregisterCall(call *Call) {
+	call.cancel()
+	s.mu.Lock()
+	delete(s.calls, call.ID)
+	s.mu.Unlock()
+	s.setCallState(call, CallEnded)
+}
+
+// Calls returns snapshots of all active calls ordered by start time.
+func (s *Service) Calls() []CallInfo {
+	s.mu.Lock()
+	calls := make([]*Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		calls = append(calls, c)
+	}
+	s.mu.Unlock()
+
+	infos := make([]CallInfo, 0, len(calls))
+	for _, c := range calls {
+		infos = append(infos, c.Info())
+	}
+	slices.SortFunc(infos, func(a, b CallInfo) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return infos
+}
+
+// CurrentCall returns the most recently started active call.
+func (s *Service) CurrentCall() (*Call, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *Call
+	for _, c := range s.calls {
+		// A call held for call waiting is current only if it's the last.
+		if latest == nil || latest.waitingHeld() && !c.waitingHeld() ||
+			latest.waitingHeld() == c.waitingHeld() && c.StartedAt.After(latest.StartedAt) {
+			latest = c
+		}
+	}
+	return latest, latest != nil
+}
+
+// Call looks up an active call by ID.
+func (s *Service) Call(id string) (*Call, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.calls[id]
+	return c, ok
+}
+
+// HangupCalls hangs up the call with id, or every active call when id is
+// empty, and returns the calls it hung up. Their handlers end the SIP leg
+// (BYE, or CANCEL while it rings) and discard the Telegram call.
+func (s *Service) HangupCalls(id string) []*Call {
+	var calls []*Call
+	if id != "" {
+		if c, ok := s.Call(id); ok {
+			calls = append(calls, c)
+		}
+	} else {
+		s.mu.Lock()
+		calls = slices.Collect(maps.Values(s.calls))
+		s.mu.Unlock()
+	}
+	for _, c := range calls {
+		s.logger.Info("call: hangup requested", "bridge_call_id", c.ID, "number", c.Number)
+		c.Hangup()
+	}
+	return calls
+}
@@ -0,0 +1,204 @@
+// Package cdr produces Call Detail Records and delivers them to pluggable sinks.
+package cdr
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record describes a single call once it has ended.
+type Record struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+	SIPCallID string `json:"sip_call_id,omitempty"`
+	Caller    string `json:"caller"`
+	Callee    string `json:"callee"`
+	// CallerName and CalleeName are the friendly name of the remote party
+	// (from the contact map or SIP display name), if known.
+	CallerName  string    `json:"caller_name,omitempty"`
+	CalleeName  string    `json:"callee_name,omitempty"`
+	TGChatID    int64     `json:"tg_chat_id"`
+	StartTime   time.Time `json:"start_time"`
+	AnswerTime  time.Time `json:"answer_time,omitzero"`
+	EndTime     time.Time `json:"end_time"`
+	Codec       string    `json:"codec,omitempty"`
+	HangupCause string    `json:"hangup_cause"`
+	// Duration is the billable (answered) duration in seconds.
+	Duration float64 `json:"duration"`
+	MOS      float64 `json:"mos,omitempty"`
+	// JitterMs and RTTMs are the SIP leg's last RTCP jitter and round trip
+	// (0 when unknown).
+	JitterMs float64 `json:"jitter_ms,omitempty"`
+	RTTMs    int64   `json:"rtt_ms,omitempty"`
+	// Recorded is set when part of the call was recorded.
+	Recorded bool `json:"recorded,omitempty"`
+	// Redirects are the targets an outbound INVITE was redirected to (3xx),
+	// in order.
+	Redirects []string `json:"redirects,omitempty"`
+	// SIPHeaders are the headers of an inbound INVITE captured with
+	// sip.capture_headers (e.g. Diversion, P-Asserted-Identity).
+	SIPHeaders map[string]string `json:"sip_headers,omitempty"`
+}
+
+// Sink receives finished records. Implementations must be safe for use by a
+// single writer goroutine; Recorder serializes calls.
+type Sink interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// Recorder fans records out to sinks on a background goroutine so slow sinks
+// (e.g. webhooks) never delay call teardown.
+type Recorder struct {
+	sinks  []Sink
+	logger *slog.Logger
+	queue  chan Record
+	done   chan struct{}
+	// closed (under mu) turns Emit into a no-op once Close started.
+	mu     sync.RWMutex
+	closed bool
+}
+
+const recorderQueueSize = 256
+
+func NewRecorder(logger *slog.Logger, sinks ...Sink) *Recorder {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := &Recorder{
+		sinks:  sinks,
+		logger: logger,
+		queue:  make(chan Record, recorderQueueSize),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Emit queues rec for delivery. It never blocks; records are dropped when the
+// queue is full.
+func (r *Recorder) Emit(rec Record) {
+	if r == nil || len(r.sinks) == 0 {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.logger.Warn("cdr: recorder closed, record dropped", "id", rec.ID)
+		return
+	}
+	select {
+	case r.queue <- rec:
+	default:
+		r.logger.Warn("cdr: queue full, record dropped", "id", rec.ID)
+	}
+}
+
+// Close flushes queued records and closes all sinks.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	<-r.done
+	var err error
+	for _, s := range r.sinks {
+		err = errors.Join(err, s.Close())
+	}
+	return err
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	for rec := range r.queue {
+		for _, s := range r.sinks {
+			if err := s.Write(rec); err != nil {
+				r.logger.Warn("cdr: sink write failed", "id", rec.ID, "sink", sinkName(s), "error", err)
+			}
+		}
+	}
+}
+
+func sinkName(s Sink) string {
+	if n, ok := s.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return "unknown"
+}
+
+// EstimateMOS returns a rough listening-quality MOS from packet loss using a
+// simplified ITU-T G.107 E-model. delay is the one-way mouth-to-ear estimate.
+func EstimateMOS(codec string, lossPercent float64, delay time.Duration) float64 {
+	// Equipment impairment (Ie) and packet-loss robustness (Bpl) per codec.
+	ie, bpl := 0.0, 25.1
+	switch strings.ToLower(codec) {
+	case "g722":
+		ie, bpl = 0, 20
+	case "opus":
+		ie, bpl = 0, 30
+	}
+	if lossPercent < 0 {
+		lossPercent = 0
+	}
+	ieEff := ie + (95-ie)*lossPercent/(lossPercent+bpl)
+
+	d := float64(delay.Milliseconds())
+	id := 0.024 * d
+	if d > 177.3 {
+		id += 0.11 * (d - 177.3)
+	}
+
+	rf := 93.2 - id - ieEff
+	switch {
+	case rf <= 0:
+		return 1
+	case rf >= 100:
+		return 4.5
+	}
+	mos := 1 + 0.035*rf + rf*(rf-60)*(100-rf)*7e-6
+	return math.Round(mos*100) / 100
+}
+
+// Hangup causes recorded in Record.HangupCause. Failed outbound INVITEs use
+// "sip_<status>" (e.g. "sip_486").
+const (
+	CauseNormal              = "normal_clearing"
+	CauseSIPHangup           = "sip_hangup"
+	CauseTelegramHangup      = "telegram_hangup"
+	CauseLocalHangup         = "local_hangup"
+	CauseTransferred         = "transferred"
+	CauseCancelled           = "cancelled"
+	CauseAuthFailed          = "auth_failed"
+	CauseBusy                = "busy"
+	CauseBlocked             = "blocked"
+	CauseScriptRejected      = "script_rejected"
+	CauseHookRejected        = "hook_rejected"
+	CauseUnauthorized        = "unauthorized"
+	CauseShuttingDown        = "shutting_down"
+	CauseSIPRestart          = "sip_restart"
+	CauseIncompatibleSDP     = "incompatible_sdp"
+	CauseTelegramUnavailable = "telegram_unavailable"
+	CausePeerTooOld          = "peer_client_too_old"
+	CauseIncompatiblePeer    = "incompatible_peer_protocol"
+	CauseNoAnswer            = "no_answer"
+	CauseEarlyMediaTimeout   = "early_media_timeout"
+	CauseDeclined            = "declined"
+	CauseVoicemail           = "voicemail"
+	CauseForwarded           = "forwarded"
+	CauseIVRHangup           = "ivr_hangup"
+	CauseAnnouncement        = "announcement"
+	CauseSIPFailure          = "sip_failure"
+	CauseMediaFailure        = "media_failure"
+	CauseLoopDetected        = "loop_detected"
+)
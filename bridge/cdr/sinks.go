@@ -0,0 +1,150 @@
+package cdr
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONFileSink appends one JSON object per line.
+type JSONFileSink struct {
+	f *os.File
+}
+
+func NewJSONFileSink(path string) (*JSONFileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileSink{f: f}, nil
+}
+
+func (s *JSONFileSink) Name() string { return "json" }
+
+func (s *JSONFileSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+func (s *JSONFileSink) Close() error { return s.f.Close() }
+
+var csvHeader = []string{
+	"id", "direction", "sip_call_id", "caller", "callee", "tg_chat_id",
+	"start_time", "answer_time", "end_time", "codec", "hangup_cause", "duration", "mos",
+	"caller_name", "callee_name", "jitter_ms", "rtt_ms",
+	"redirects", "sip_headers",
+}
+
+// CSVSink appends rows to a CSV file, writing the header when the file is new.
+type CSVSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func NewCSVSink(path string) (*CSVSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSVSink{f: f, w: csv.NewWriter(f)}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		if err := s.w.Write(csvHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.w.Flush()
+	}
+	return s, nil
+}
+
+func (s *CSVSink) Name() string { return "csv" }
+
+func (s *CSVSink) Write(rec Record) error {
+	answer := ""
+	if !rec.AnswerTime.IsZero() {
+		answer = rec.AnswerTime.Format(time.RFC3339Nano)
+	}
+	// Header values contain any separator; the cell is a JSON object.
+	headers := ""
+	if len(rec.SIPHeaders) > 0 {
+		data, err := json.Marshal(rec.SIPHeaders)
+		if err != nil {
+			return err
+		}
+		headers = string(data)
+	}
+	row := []string{
+		rec.ID,
+		rec.Direction,
+		rec.SIPCallID,
+		rec.Caller,
+		rec.Callee,
+		strconv.FormatInt(rec.TGChatID, 10),
+		rec.StartTime.Format(time.RFC3339Nano),
+		answer,
+		rec.EndTime.Format(time.RFC3339Nano),
+		rec.Codec,
+		rec.HangupCause,
+		strconv.FormatFloat(rec.Duration, 'f', 3, 64),
+		strconv.FormatFloat(rec.MOS, 'f', 2, 64),
+		rec.CallerName,
+		rec.CalleeName,
+		strconv.FormatFloat(rec.JitterMs, 'f', 1, 64),
+		strconv.FormatInt(rec.RTTMs, 10),
+		strings.Join(rec.Redirects, " "),
+		headers,
+	}
+	if err := s.w.Write(row); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	return s.f.Close()
+}
+
+// WebhookSink POSTs each record as JSON.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &WebhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error { return nil }
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gotgcalls/bridge/endpoints"
+)
+
+var ErrNotInConference = errors.New("call is not in a conference (/add a number to it first)")
+
+// AddToCall dials number and adds the answered party to the Telegram side
+// of call, so the Telegram user (or voice chat), call and every other added
+// party hear each other. The first /add turns the call's Telegram leg into
+// a conference mixer; the Telegram side stays up until the last party left.
+// Like Originate it returns once the new call is registered.
+func (s *Service) AddToCall(ctx context.Context, call *Call, number string) (*Call, error) {
+	mixer, err := s.callMixer(call)
+	if err != nil {
+		return nil, err
+	}
+	added, err := s.prepareOutboundCall(call.ChatID, number, "")
+	if err != nil {
+		return nil, err
+	}
+	added.mixer = mixer
+	go func() {
+		if err := s.runOutboundCall(ctx, added); err != nil {
+			s.logger.Warn("add to call failed", "error", err, "number", number, "bridge_call_id", added.ID, "conference_call_id", call.ID)
+		}
+	}()
+	return added, nil
+}
+
+// callMixer returns the conference mixer of call, first putting one between
+// its media bridge and Telegram leg if it has none.
+func (s *Service) callMixer(call *Call) (*endpoints.Mixer, error) {
+	media := call.mediaBridge()
+	prev := call.tgLeg()
+	if media == nil || prev == nil {
+		return nil, ErrNotBridged
+	}
+	if leg, ok := prev.(*endpoints.MixerLeg); ok {
+		return leg.Mixer(), nil
+	}
+	mixer := endpoints.NewMixer(prev, prev.Close)
+	leg, err := mixer.AddLeg()
+	if err != nil {
+		return nil, err
+	}
+	// The mixer leg becomes current first, so the old leg ending with the
+	// mixer doesn't look like a Telegram hangup.
+	call.setTGLeg(leg)
+	if err := media.ReplaceTG(leg); err != nil {
+		call.setTGLeg(prev)
+		mixer.Close()
+		return nil, err
+	}
+	if vb := s.getVideoBridge(call.ChatID); vb != nil {
+		// Conferences are audio only.
+		vb.stop()
+	}
+	s.logger.Info("conference started", "bridge_call_id", call.ID, "tg_chat_id", call.ChatID)
+	return mixer, nil
+}
+
+// SetConferenceGain sets how loud call is mixed for the other parties of
+// its conference, 0-MaxVolume percent.
+func (s *Service) SetConferenceGain(call *Call, percent int) error {
+	leg, ok := call.tgLeg().(*endpoints.MixerLeg)
+	if !ok {
+		return ErrNotInConference
+	}
+	if percent < 0 || percent > MaxVolume {
+		return fmt.Errorf("gain must be between 0 and %d", MaxVolume)
+	}
+	leg.SetGain(percent)
+	s.logger.Info("conference gain set", "bridge_call_id", call.ID, "gain", percent)
+	return nil
+}
+
+// SetConferenceTGGain sets how loud the Telegram side is mixed for the SIP
+// parties of the conference of call, 0-MaxVolume percent.
+func (s *Service) SetConferenceTGGain(call *Call, percent int) error {
+	leg, ok := call.tgLeg().(*endpoints.MixerLeg)
+	if !ok {
+		return ErrNotInConference
+	}
+	if percent < 0 || percent > MaxVolume {
+		return fmt.Errorf("gain must be between 0 and %d", MaxVolume)
+	}
+	leg.Mixer().SetTGGain(percent)
+	s.logger.Info("conference telegram gain set", "bridge_call_id", call.ID, "gain", percent)
+	return nil
+}
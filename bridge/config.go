@@ -3,20 +3,32 @@ package bridge
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/emiago/diago"
 	"gopkg.in/yaml.v3"
+
+	"gotgcalls/bridge/recording"
+	"gotgcalls/bridge/rtpdump"
+	"gotgcalls/bridge/webhook"
 )
 
 const (
 	defaultSIPBindPort = 5060
 	defaultTransport   = "udp"
-	defaultSessionName = "session"
+	defaultSessionName = "session.dat"
 	defaultSampleRate  = 48000
 	defaultChannels    = 1
 	defaultFrameMs     = 20
+
+	gigabyte = 1 << 30
+
+	ProtocolCheckStrict = "strict"
+	ProtocolCheckWarn   = "warn"
 )
 
 type Config struct {
@@ -28,22 +40,462 @@ type Config struct {
 	SIPBindPort   int
 	SIPTransport  string
 	SIPExternalIP string
-	SIPAuthUser   string
-	SIPAuthPass   string
-	SIPAuthRealm  string
+	// SIPDetectExternalIP discovers the public IPv4 address with STUN when
+	// SIPExternalIP is not set, and checks it again every
+	// SIPExternalIPRefresh (0 = only at startup).
+	SIPDetectExternalIP  bool
+	SIPExternalIPRefresh time.Duration
+	// SIPUDPBindHosts and SIPTCPBindHosts are the addresses SIP and the RTP
+	// of its calls listen on, one transport each; "::" adds IPv6.
+	// SIPExternalIP6 is advertised on IPv6 transports instead of
+	// SIPExternalIP.
+	SIPUDPBindHosts []string
+	SIPTCPBindHosts []string
+	SIPExternalIP6  string
+	SIPAuthUser     string
+	SIPAuthPass     string
+	SIPAuthRealm    string
+	// SIPAuthAlgorithms are the digest algorithms inbound calls are
+	// challenged with, in order of preference (RFC 8760), and SIPAuthQOP
+	// the qop values offered ("auth", "auth-int"; empty for none).
+	SIPAuthAlgorithms []string
+	SIPAuthQOP        []string
+	STUNServer        string
+	// SIPAccounts are local accounts phones register to the bridge with;
+	// calls from them must authenticate as the account.
+	SIPAccounts []SIPAccount
+	// SIPRegisterExpiry is the registration lifetime requested from the
+	// provider; it is refreshed at 3/4 of what the registrar grants.
+	SIPRegisterExpiry time.Duration
+	// SIPOutbound registers as an RFC 5626 (SIP outbound) client with
+	// SIPInstanceID and reg-id 1, so the registrar keeps the bridge's binding
+	// next to other devices on the account, and sends keep-alives on the
+	// registered flow every SIPKeepAlive (0 = the registrar's Flow-Timer or
+	// the RFC's recommendation for the transport).
+	SIPOutbound   bool
+	SIPInstanceID string
+	SIPKeepAlive  time.Duration
+	// SIPCallerIDs lists the caller IDs an outbound call may select (sent as
+	// From and P-Asserted-Identity); empty allows any.
+	SIPCallerIDs []string
+	// SIPMaxRedirects is how many 3xx redirects an outbound INVITE follows
+	// to their Contact targets (0 = fail on a redirect).
+	SIPMaxRedirects int
+	// SIPProfile is the provider profile whose quirks apply; SIPQuirks are
+	// that profile's quirks plus the ones listed in sip.quirks.
+	SIPProfile string
+	SIPQuirks  Quirks
+	// SIPTCPFallback sends INVITEs too large for UDP (over 1300 bytes, RFC
+	// 3261 18.1.1) over TCP; SIPConnectionReuse sends requests over an
+	// already open TCP/TLS connection to the same address.
+	SIPTCPFallback     bool
+	SIPConnectionReuse bool
+	// SIPOutboundProxy is the host[:port] requests to the trunk are sent to
+	// instead of SIPProvider, e.g. the provider's SBC. INVITEs keep
+	// SIPProvider in the Request-URI and get a loose Route to the proxy,
+	// followed by the SIPRoute URIs. SIPHeaders are static headers added
+	// to INVITEs to the trunk.
+	SIPOutboundProxy string
+	SIPRoute         []string
+	SIPHeaders       map[string]string
+	// SIPCaptureHeaders are the headers of inbound INVITEs (names, or
+	// prefixes such as "X-*") shown in the Telegram notices and added to
+	// the call's webhooks and CDR.
+	SIPCaptureHeaders []string
+	// SIPRel100 is the use of reliable provisional responses (RFC 3262):
+	// "" (off), "on" (offered on outbound INVITEs, PRACKed when the callee
+	// sends them and used towards callers supporting them) or "require"
+	// (outbound INVITEs require it).
+	SIPRel100 string
+
+	// Session file encryption secret sources (see ResolveSessionKey).
+	TGSessionKey     string
+	TGSessionKeyEnv  string
+	TGSessionKeyring string
+
+	// TGProtocolCheck is ProtocolCheckStrict (refuse calls with peers whose
+	// protocol layers don't overlap ours) or ProtocolCheckWarn (log and try anyway).
+	TGProtocolCheck string
+	// TGParticipantEvents posts join/leave/mute events of bridged group calls.
+	TGParticipantEvents bool
 
 	EstablishTimeout time.Duration
 	SampleRate       int
 	Channels         int
 	FrameDuration    time.Duration
+	// TGSendRate and TGReceiveRate are the PCM rates of the audio sent to and
+	// received from ntgcalls (which encodes Opus for Telegram); audio is
+	// resampled from and to SampleRate. 0 uses SampleRate.
+	TGSendRate    int
+	TGReceiveRate int
+	// RingbackFile is played to SIP callers (as early media) while the
+	// Telegram leg is being set up; HoldMusicFile is played to Telegram while
+	// the SIP side holds the call. WAV or Ogg/Opus.
+	RingbackFile  string
+	HoldMusicFile string
+	// ProgressTones is the country whose ringback, busy and congestion
+	// tones outbound calls play to Telegram while the callee rings without
+	// early media, and after it failed; "" plays none.
+	ProgressTones string
+	// OpusFEC offers and sends Opus in-band FEC (useinbandfec=1) and uses it
+	// to recover lost packets; OpusPLC conceals lost packets with the Opus
+	// decoder instead of silence.
+	OpusFEC bool
+	OpusPLC bool
+	// Codecs are the audio codecs offered and accepted on SIP (lowercase
+	// names, e.g. "opus", "g722", "pcmu"), most preferred first; empty
+	// advertises every codec in the build with the registry priorities.
+	Codecs []string
+	// AGCToTG and AGCToSIP are the automatic gain control of the audio sent
+	// to Telegram and to the SIP side.
+	AGCToTG  AGCConfig
+	AGCToSIP AGCConfig
+	// NoiseSuppression is NoiseSuppressionOff or NoiseSuppressionSpectral,
+	// applied to the audio sent to Telegram; NoiseSuppressionDB is how far
+	// noise is turned down at most.
+	NoiseSuppression   string
+	NoiseSuppressionDB float64
+	// EchoProtection attenuates the audio sent to SIP by EchoAttenuationDB
+	// while it correlates (EchoThreshold, 0-1) with the audio recently sent
+	// to Telegram, so a speakerphone on either end can't start a feedback
+	// loop.
+	EchoProtection    bool
+	EchoThreshold     float64
+	EchoAttenuationDB float64
+	// LevelLogInterval logs the audio levels of each call's directions this
+	// often (0 = off).
+	LevelLogInterval time.Duration
+	// RTPDumpEnabled writes a debug dump of every call into RTPDumpDir: its
+	// SIP RTP as RTPDumpFormat (rtpdump.FormatPCAP or FormatRaw) and the
+	// decoded audio of both directions as WAV files. It can be toggled at
+	// runtime (Service.SetDebugDump).
+	RTPDumpEnabled bool
+	RTPDumpDir     string
+	RTPDumpFormat  string
+	// RTPEventsEnabled writes a compact JSON log of the notable events of
+	// every call's received RTP (gaps, payload type changes, jitter spikes,
+	// concealed frames, ...) into RTPEventsDir at call end. It can be
+	// toggled at runtime (Service.SetRTPEventLog).
+	RTPEventsEnabled bool
+	RTPEventsDir     string
+	// CrashReportsEnabled writes a crash report (call states, media buffer
+	// depths, all goroutine stacks and the last CrashLogLines log lines)
+	// into CrashReportsDir when a call panics or its media pipeline fails;
+	// CrashReportsNotify also tells the Telegram user.
+	CrashReportsEnabled bool
+	CrashReportsDir     string
+	CrashReportsNotify  bool
+	// Announcements maps Announce* keys to clips played to rejected inbound
+	// callers; AnnounceAnswer answers the call for them instead of using
+	// early media.
+	Announcements  map[string]string
+	AnnounceAnswer bool
+	// VoicemailEnabled answers inbound calls the Telegram user does not take
+	// (Telegram setup failed or call.confirm_timeout passed), plays
+	// VoicemailGreetingFile and records up to VoicemailMaxLength into
+	// VoicemailDir. The message is sent to the user on Telegram.
+	VoicemailEnabled      bool
+	VoicemailGreetingFile string
+	VoicemailMaxLength    time.Duration
+	VoicemailDir          string
+	// ScreeningEnabled answers inbound calls first and asks the caller for
+	// their name with ScreeningPromptFile (or ScreeningPrompt spoken with
+	// TTS). Up to ScreeningMaxLength of the answer is sent to the Telegram
+	// user, who accepts or rejects the call before Telegram rings.
+	ScreeningEnabled    bool
+	ScreeningPromptFile string
+	ScreeningPrompt     string
+	ScreeningMaxLength  time.Duration
+	// IVRMenus is the inbound IVR menu tree, entered at IVRStart before
+	// Telegram rings; nil disables the IVR. A menu waits IVRTimeout for
+	// input after its prompt and IVRDigitTimeout between digits, and plays
+	// IVRInvalidPrompt before repeating after invalid input.
+	IVRMenus         map[string]IVRMenuConfig
+	IVRStart         string
+	IVRTimeout       time.Duration
+	IVRDigitTimeout  time.Duration
+	IVRInvalidPrompt string
+	// IVRWebhookURL, instead of IVRMenus, drives inbound calls with the
+	// instructions it returns (see ivrWebhook); each request may take
+	// IVRWebhookTimeout and carries IVRWebhookHeaders.
+	IVRWebhookURL     string
+	IVRWebhookTimeout time.Duration
+	IVRWebhookHeaders map[string]string
+	// Directory lists the Telegram users inbound callers can reach by
+	// spelling their name (IVR action "directory") after DirectoryPrompt.
+	Directory       []DirectoryEntry
+	DirectoryPrompt string
+	// TTSCommand speaks text, see synthesize; empty disables text-to-speech
+	// unless TTSURL is set, which takes precedence and sends TTSHeaders.
+	TTSCommand []string
+	TTSURL     string
+	TTSHeaders map[string]string
+	// ASRURL recognizes spoken IVR input (see recognizer); results below
+	// ASRMinConfidence are treated as invalid input. ASRTimeout bounds the
+	// wait for a transcript after the caller stops speaking.
+	ASRURL           string
+	ASRSampleRate    int
+	ASRMinConfidence float64
+	ASRTimeout       time.Duration
+	ASRHeaders       map[string]string
+	// ASRFormat is how audio is sent to ASRURL: asrFormatStream or
+	// asrFormatWhisper, which adds ASRFields to the form.
+	ASRFormat string
+	ASRFields map[string]string
+
+	// TranscriptionEnabled transcribes every bridged call with ASRURL from
+	// the start, the directions in Transcription; /transcribe starts and
+	// stops it per call.
+	TranscriptionEnabled bool
+	Transcription        TranscribeLegs
+	// SMSEnabled forwards SIP MESSAGE requests to the Telegram user and
+	// lets /sms send text to a number: as a SIP MESSAGE through the
+	// provider, or posted to SMSURL (with SMSHeaders) when set.
+	SMSEnabled bool
+	SMSURL     string
+	SMSHeaders map[string]string
 
-	JitterMinPackets  uint16
+	// RTPPortMin and RTPPortMax bound the local RTP ports of calls (RTCP
+	// uses the odd port above each); 0 leaves them to the OS. RTPSymmetric
+	// sends media back to where the SIP party's media comes from rather than
+	// the address in its SDP.
+	RTPPortMin   int
+	RTPPortMax   int
+	RTPSymmetric bool
+
+	// JitterMinDelay and JitterMaxDelay bound the delay of the adaptive SIP
+	// jitter buffer, which follows the measured jitter in between;
+	// JitterMaxDelay 0 disables it.
+	JitterMinDelay time.Duration
+	JitterMaxDelay time.Duration
+	// JitterRTCPAdapt raises the SIP->TG playout target above
+	// DriftTargetFrames while RTCP statistics show jitter or loss.
+	JitterRTCPAdapt   bool
 	EnableEarlyMedia  bool
 	DriftTargetFrames int
 	DriftMaxBurst     int
+	// LatencyProbe mixes a short chirp into both legs every
+	// LatencyProbeInterval and times its echo (see package probe).
+	LatencyProbe         bool
+	LatencyProbeInterval time.Duration
+	// TestCallNumber is the echo test number /testcall dials; with
+	// TestCallInterval set it is also called on that schedule.
+	TestCallNumber   string
+	TestCallInterval time.Duration
+	// CallFilesDir is watched for click-to-dial call files (ParseCallFile),
+	// every CallFilesPoll; processed files move to its done/ and failed/.
+	CallFilesDir  string
+	CallFilesPoll time.Duration
+	// Failed scheduled calls (ScheduleCall) are retried ScheduleRetries
+	// times, ScheduleRetryInterval apart.
+	ScheduleRetries       int
+	ScheduleRetryInterval time.Duration
+	// EchoExtension is the dialed user that reaches the echo test instead of
+	// Telegram; the caller hears themselves EchoDelay later, after
+	// EchoGreetingFile. /call echo does the same for the Telegram user.
+	EchoExtension    string
+	EchoDelay        time.Duration
+	EchoGreetingFile string
+	// InfoLines maps called numbers (compared like ContactNames) to
+	// announcement-only lines answered without Telegram.
+	InfoLines map[string]InfoLine
+	// ScriptFile is a Starlark script whose hooks route, reject and control
+	// calls; each hook run is stopped after ScriptTimeout.
+	ScriptFile    string
+	ScriptTimeout time.Duration
+	// Plugins are Go plugins adding audio stages and call endpoints.
+	Plugins []PluginConfig
+	// Authorizer decides which inbound SIP calls and Telegram users get
+	// through: AuthorizerDefault, AuthorizerRules (AuthRules first) or
+	// AuthorizerHTTP (asks AuthURL within AuthTimeout, with AuthHeaders).
+	Authorizer  string
+	AuthRules   []AuthRule
+	AuthURL     string
+	AuthTimeout time.Duration
+	AuthHeaders map[string]string
 
 	MaxActiveCalls int64
-	EnableDTMF     bool
+	// DrainTimeout is how long active calls may continue after a shutdown
+	// signal before they are hung up.
+	DrainTimeout time.Duration
+	// ConfirmInbound announces inbound SIP calls with a Telegram message and
+	// only rings the user after /answer; unanswered calls get 486 after
+	// ConfirmInboundTimeout.
+	ConfirmInbound        bool
+	ConfirmInboundTimeout time.Duration
+	// Ringing is how inbound calls ring; RingingRoutes override it per
+	// called number (compared like ContactNames).
+	Ringing       Ringing
+	RingingRoutes map[string]Ringing
+	// BusyAction handles inbound calls for a Telegram user who is already
+	// in a call: BusyReject (486), BusyNotify (486 and a missed call
+	// message), BusyVoicemail or BusyWait (call waiting).
+	BusyAction string
+	// CallSummary sends a summary with quick actions to the Telegram user
+	// after each answered or outbound call.
+	CallSummary bool
+
+	EnableDTMF bool
+	// DTMFRelay plays digits received from SIP as in-band tones toward Telegram.
+	DTMFRelay bool
+
+	// APIListen enables the HTTP control API on this address (e.g. "127.0.0.1:8080").
+	APIListen string
+	APIToken  string
+	// GRPCListen enables the gRPC control API on this address; GRPCToken is
+	// its bearer token.
+	GRPCListen string
+	GRPCToken  string
+
+	// PreflightEnabled runs startup checks; PreflightStrict refuses to serve
+	// when a critical check fails.
+	PreflightEnabled bool
+	PreflightStrict  bool
+
+	// Call detail record sinks; each is disabled when empty.
+	CDRJSONFile       string
+	CDRCSVFile        string
+	CDRWebhookURL     string
+	CDRWebhookTimeout time.Duration
+
+	// Webhooks receive call, registration and DTMF events; each request may
+	// take WebhookTimeout and failed ones are retried WebhookRetries times.
+	Webhooks       []Webhook
+	WebhookTimeout time.Duration
+	WebhookRetries int
+
+	// ContactNames maps phone numbers to friendly names for caller ID;
+	// ContactsFromTelegram also looks numbers up in the account's contacts.
+	ContactNames         map[string]string
+	ContactsFromTelegram bool
+	// RingProfiles change how calls from these numbers ring (compared like
+	// ContactNames).
+	RingProfiles map[string]RingProfile
+	// FollowMe maps called numbers (DIDs, compared like ContactNames) to
+	// targets tried in order until one answers.
+	FollowMe map[string][]FollowMeStep
+
+	// CalendarURL is an iCalendar feed (CalendarType "ics") or a CalDAV
+	// calendar collection ("caldav"), fetched every CalendarRefresh with
+	// optional basic auth. Inbound calls during its busy events take
+	// CalendarAction ("voicemail" or "reject") and hear CalendarMessage
+	// ({until}, {summary}) spoken with tts.command.
+	CalendarURL      string
+	CalendarType     string
+	CalendarUser     string
+	CalendarPassword string
+	CalendarRefresh  time.Duration
+	CalendarAction   string
+	CalendarMessage  string
+
+	// VideoClipEnabled accepts H.264 video offered by inbound SIP callers
+	// (e.g. door intercoms) and sends its first VideoClipLength to the
+	// Telegram user as a video, muxed with the VideoClipFFmpeg command.
+	VideoClipEnabled bool
+	VideoClipLength  time.Duration
+	VideoClipFFmpeg  string
+	// VideoEnabled bridges H.264 video between SIP and private Telegram
+	// calls both ways, transcoded by VideoFFmpeg; Telegram gets
+	// VideoWidth x VideoHeight at VideoFPS, as does SIP.
+	VideoEnabled bool
+	VideoWidth   int
+	VideoHeight  int
+	VideoFPS     int
+	VideoFFmpeg  string
+
+	// CallersAllow and CallersDeny screen inbound callers before Telegram
+	// rings (see CallerRule); blocked calls are answered with
+	// CallersRejectStatus (603, 486 or 404).
+	CallersAllow        []string
+	CallersDeny         []string
+	CallersRejectStatus int
+
+	// RecordingEnabled records every call automatically; recordings can also be
+	// toggled per call with RecordingDTMFToggle or the control API.
+	RecordingEnabled    bool
+	RecordingDir        string
+	RecordingTemplate   string
+	RecordingFormat     string
+	RecordingMixed      bool
+	RecordingDTMFToggle string
+	// RecordingPreRoll is how much audio before a mid-call start a recording
+	// includes.
+	RecordingPreRoll time.Duration
+	// RecordingEncryptionKey (or the env var named by
+	// RecordingEncryptionKeyEnv) encrypts recordings at rest, see
+	// RecordingKey. RecordingAnnouncementFile is played to the SIP party
+	// whenever recording starts. RecordingCompliance refuses to start unless
+	// encryption, retention and the announcement are all configured.
+	RecordingEncryptionKey    string
+	RecordingEncryptionKeyEnv string
+	RecordingAnnouncementFile string
+	RecordingCompliance       bool
+
+	// PostProcess trims silence (PostTrimSilence, below
+	// PostSilenceThreshold dBFS), normalizes loudness to PostTargetLUFS
+	// (0 disables) and optionally re-encodes as Opus (PostOpus) once a
+	// recording is complete. Only WAV recordings are processed.
+	PostProcess          bool
+	PostTrimSilence      bool
+	PostSilenceThreshold float64
+	PostTargetLUFS       float64
+	PostOpus             bool
+
+	// Storage limits for recordings: files older than StorageRetention or
+	// beyond StorageMaxBytes are deleted oldest first. New recordings are
+	// refused below StorageMinFree, and the owner is alerted below
+	// StorageAlertFree. Zero disables a limit.
+	StorageRetention     time.Duration
+	StorageMaxBytes      int64
+	StorageMinFree       uint64
+	StorageAlertFree     uint64
+	StorageCheckInterval time.Duration
+	// StateFile is the embedded store (package store) that keeps call
+	// records, voicemail, scheduled calls, contact aliases and the
+	// registration across restarts ("" = memory only). It holds the last
+	// StateCDRLimit call records (0 = none).
+	StateFile     string
+	StateCDRLimit int
+
+	// VoiceChatAutoJoin lists voice chats the bridge joins (dialing the given
+	// numbers into them) as soon as they start; VoiceChatPollInterval is how
+	// often their state is checked.
+	VoiceChatAutoJoin     []VoiceChatAutoJoin
+	VoiceChatPollInterval time.Duration
+	// VoiceChats maps called numbers (compared like ContactNames) to voice
+	// chats their inbound callers join as participants instead of ringing
+	// the Telegram user.
+	VoiceChats map[string]int64
+
+	// Direct metric/CDR exporters; each is disabled when its URL/DSN is empty.
+	ExportInterval       time.Duration
+	ExportBatchSize      int
+	ExportFlushInterval  time.Duration
+	ExportInfluxURL      string
+	ExportInfluxToken    string
+	ExportInfluxOrg      string
+	ExportInfluxBucket   string
+	ExportInfluxDatabase string
+	ExportSQLDriver      string
+	ExportSQLDSN         string
+}
+
+// VoiceChatAutoJoin connects SIP numbers to a (usually scheduled) voice chat.
+type VoiceChatAutoJoin struct {
+	ChatID  int64    `yaml:"chat_id"`
+	Numbers []string `yaml:"numbers"`
+	// StartScheduled starts the voice chat at its scheduled time if nobody
+	// has yet (the account must be a chat admin).
+	StartScheduled bool `yaml:"start_scheduled"`
+}
+
+// Webhook is an entry of webhooks.endpoints.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret signs the requests (HMAC-SHA256); empty sends them unsigned.
+	Secret string `yaml:"secret"`
+	// Events limits the endpoint to these event types; empty sends all.
+	Events []string `yaml:"events"`
 }
 
 type yamlConfig struct {
@@ -52,50 +504,445 @@ type yamlConfig struct {
 		AppHash string `yaml:"app_hash"`
 		Session string `yaml:"session"`
 		UserID  int64  `yaml:"user_id"`
+
+		SessionKey        string `yaml:"session_key"`
+		SessionKeyEnv     string `yaml:"session_key_env"`
+		SessionKeyKeyring string `yaml:"session_key_keyring"`
+
+		ProtocolCheck     string `yaml:"protocol_check"`
+		ParticipantEvents *bool  `yaml:"participant_events"`
 	} `yaml:"telegram"`
 	SIP struct {
-		ProviderHost string `yaml:"provider_host"`
-		BindPort     int    `yaml:"bind_port"`
-		Transport    string `yaml:"transport"`
-		ExternalIP   string `yaml:"external_ip"`
-		AuthUser     string `yaml:"auth_user"`
-		AuthPassword string `yaml:"auth_password"`
-		AuthRealm    string `yaml:"auth_realm"`
-		DTMFEnabled  bool   `yaml:"dtmf_enabled"`
-		EarlyMedia   bool   `yaml:"early_media"`
+		ProviderHost   string   `yaml:"provider_host"`
+		BindPort       int      `yaml:"bind_port"`
+		Transport      string   `yaml:"transport"`
+		ExternalIP     string   `yaml:"external_ip"`
+		ExternalIP6    string   `yaml:"external_ip6"`
+		BindHosts      []string `yaml:"bind_hosts"`
+		UDPBindHosts   []string `yaml:"udp_bind_hosts"`
+		TCPBindHosts   []string `yaml:"tcp_bind_hosts"`
+		AuthUser       string   `yaml:"auth_user"`
+		AuthPassword   string   `yaml:"auth_password"`
+		AuthRealm      string   `yaml:"auth_realm"`
+		AuthAlgorithms []string `yaml:"auth_algorithms"`
+		AuthQOP        []string `yaml:"auth_qop"`
+		RegisterExpiry string   `yaml:"register_expiry"`
+		Outbound       bool     `yaml:"outbound"`
+		InstanceID     string   `yaml:"instance_id"`
+		KeepAlive      string   `yaml:"keepalive_interval"`
+		DTMFEnabled    bool     `yaml:"dtmf_enabled"`
+		DTMFRelay      *bool    `yaml:"dtmf_relay"`
+		EarlyMedia     bool     `yaml:"early_media"`
+		STUNServer     string   `yaml:"stun_server"`
+		DetectIP       *bool    `yaml:"detect_external_ip"`
+		IPRefresh      string   `yaml:"external_ip_refresh"`
+		CallerIDs      []string `yaml:"caller_ids"`
+		MaxRedirects   *int     `yaml:"max_redirects"`
+
+		ProviderProfile  string              `yaml:"provider_profile"`
+		ProviderProfiles map[string][]string `yaml:"provider_profiles"`
+		Quirks           []string            `yaml:"quirks"`
+
+		TCPFallback     *bool `yaml:"tcp_fallback"`
+		ConnectionReuse *bool `yaml:"connection_reuse"`
+
+		OutboundProxy string            `yaml:"outbound_proxy"`
+		Route         []string          `yaml:"route"`
+		Headers       map[string]string `yaml:"headers"`
+
+		CaptureHeaders []string `yaml:"capture_headers"`
+
+		Rel100 string `yaml:"rel100"`
+
+		Accounts []SIPAccount `yaml:"accounts"`
 	} `yaml:"sip"`
 	Audio struct {
 		SampleRate int `yaml:"sample_rate"`
 		Channels   int `yaml:"channels"`
 		FrameMs    int `yaml:"frame_ms"`
+
+		TelegramSendRate    int `yaml:"telegram_send_rate"`
+		TelegramReceiveRate int `yaml:"telegram_receive_rate"`
+
+		RingbackFile  string   `yaml:"ringback_file"`
+		HoldMusicFile string   `yaml:"hold_music_file"`
+		OpusFEC       *bool    `yaml:"opus_fec"`
+		OpusPLC       *bool    `yaml:"opus_plc"`
+		Codecs        []string `yaml:"codecs"`
+
+		ProgressTones string `yaml:"progress_tones"`
+
+		AGCToTG  yamlAGC `yaml:"agc_to_telegram"`
+		AGCToSIP yamlAGC `yaml:"agc_to_sip"`
+
+		NoiseSuppression   string   `yaml:"noise_suppression"`
+		NoiseSuppressionDB *float64 `yaml:"noise_suppression_db"`
+
+		EchoProtection    bool     `yaml:"echo_protection"`
+		EchoThreshold     *float64 `yaml:"echo_threshold"`
+		EchoAttenuationDB *float64 `yaml:"echo_attenuation_db"`
+
+		LevelLogInterval *string `yaml:"level_log_interval"`
 	} `yaml:"audio"`
+	Debug struct {
+		RTPDump struct {
+			Enabled bool   `yaml:"enabled"`
+			Dir     string `yaml:"dir"`
+			Format  string `yaml:"format"`
+		} `yaml:"rtp_dump"`
+		RTPEvents struct {
+			Enabled bool   `yaml:"enabled"`
+			Dir     string `yaml:"dir"`
+		} `yaml:"rtp_events"`
+		CrashReports struct {
+			Enabled *bool  `yaml:"enabled"`
+			Dir     string `yaml:"dir"`
+			Notify  bool   `yaml:"notify"`
+		} `yaml:"crash_reports"`
+	} `yaml:"debug"`
+	Announcements struct {
+		Answer       bool   `yaml:"answer"`
+		Busy         string `yaml:"busy"`
+		Blocked      string `yaml:"blocked"`
+		Unavailable  string `yaml:"unavailable"`
+		Declined     string `yaml:"declined"`
+		NoAnswer     string `yaml:"no_answer"`
+		ShuttingDown string `yaml:"shutting_down"`
+	} `yaml:"announcements"`
+	Voicemail struct {
+		Enabled   bool   `yaml:"enabled"`
+		Greeting  string `yaml:"greeting_file"`
+		MaxLength string `yaml:"max_length"`
+		Dir       string `yaml:"dir"`
+	} `yaml:"voicemail"`
+	Screening struct {
+		Enabled    bool   `yaml:"enabled"`
+		PromptFile string `yaml:"prompt_file"`
+		Prompt     string `yaml:"prompt"`
+		MaxLength  string `yaml:"max_length"`
+	} `yaml:"screening"`
+	IVR struct {
+		Enabled       bool                     `yaml:"enabled"`
+		Start         string                   `yaml:"start"`
+		Timeout       string                   `yaml:"timeout"`
+		DigitTimeout  string                   `yaml:"digit_timeout"`
+		InvalidPrompt string                   `yaml:"invalid_prompt"`
+		Menus         map[string]IVRMenuConfig `yaml:"menus"`
+		Webhook       struct {
+			URL     string            `yaml:"url"`
+			Timeout string            `yaml:"timeout"`
+			Headers map[string]string `yaml:"headers"`
+		} `yaml:"webhook"`
+	} `yaml:"ivr"`
+	Directory struct {
+		Prompt  string           `yaml:"prompt"`
+		Entries []DirectoryEntry `yaml:"entries"`
+	} `yaml:"directory"`
+	TTS struct {
+		Command []string          `yaml:"command"`
+		URL     string            `yaml:"url"`
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"tts"`
+	ASR struct {
+		URL           string            `yaml:"url"`
+		SampleRate    int               `yaml:"sample_rate"`
+		MinConfidence *float64          `yaml:"min_confidence"`
+		Timeout       string            `yaml:"timeout"`
+		Headers       map[string]string `yaml:"headers"`
+
+		Format string            `yaml:"format"`
+		Fields map[string]string `yaml:"fields"`
+	} `yaml:"asr"`
+	Transcription struct {
+		Enabled bool   `yaml:"enabled"`
+		Legs    string `yaml:"legs"`
+	} `yaml:"transcription"`
+	SMS struct {
+		Enabled bool              `yaml:"enabled"`
+		URL     string            `yaml:"url"`
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"sms"`
 	Call struct {
 		EstablishTimeout string `yaml:"establish_timeout"`
 		MaxActiveCalls   int64  `yaml:"max_active_calls"`
+		DrainTimeout     string `yaml:"drain_timeout"`
+		ConfirmInbound   bool   `yaml:"confirm_inbound"`
+		ConfirmTimeout   string `yaml:"confirm_timeout"`
+		BusyAction       string `yaml:"busy_action"`
+		Summary          *bool  `yaml:"summary"`
+
+		Ringing struct {
+			yamlRinging `yaml:",inline"`
+			Routes      map[string]yamlRinging `yaml:"routes"`
+		} `yaml:"ringing"`
 	} `yaml:"call"`
+	RTP struct {
+		PortMin   int   `yaml:"port_min"`
+		PortMax   int   `yaml:"port_max"`
+		Symmetric *bool `yaml:"symmetric"`
+	} `yaml:"rtp"`
 	Jitter struct {
-		MinPackets        int `yaml:"min_packets"`
-		DriftTargetFrames int `yaml:"drift_target_frames"`
-		DriftMaxBurst     int `yaml:"drift_max_burst"`
+		MinPackets        int    `yaml:"min_packets"`
+		MinDelay          string `yaml:"min_delay"`
+		MaxDelay          string `yaml:"max_delay"`
+		RTCPAdapt         bool   `yaml:"rtcp_adapt"`
+		DriftTargetFrames int    `yaml:"drift_target_frames"`
+		DriftMaxBurst     int    `yaml:"drift_max_burst"`
 	} `yaml:"jitter"`
+	LatencyProbe struct {
+		Enabled  bool   `yaml:"enabled"`
+		Interval string `yaml:"interval"`
+	} `yaml:"latency_probe"`
+	TestCall struct {
+		Number   string `yaml:"number"`
+		Interval string `yaml:"interval"`
+	} `yaml:"test_call"`
+	CallFiles struct {
+		Dir  string `yaml:"dir"`
+		Poll string `yaml:"poll"`
+	} `yaml:"call_files"`
+	Schedule struct {
+		Retries       *int   `yaml:"retries"`
+		RetryInterval string `yaml:"retry_interval"`
+	} `yaml:"schedule"`
+	Echo struct {
+		Extension string `yaml:"extension"`
+		Delay     string `yaml:"delay"`
+		Greeting  string `yaml:"greeting_file"`
+	} `yaml:"echo"`
+	InfoLines map[string]InfoLine `yaml:"info_lines"`
+	Script    struct {
+		File    string `yaml:"file"`
+		Timeout string `yaml:"timeout"`
+	} `yaml:"script"`
+	Plugins []PluginConfig `yaml:"plugins"`
+	API     struct {
+		Listen string `yaml:"listen"`
+		Token  string `yaml:"token"`
+	} `yaml:"api"`
+	GRPC struct {
+		Listen string `yaml:"listen"`
+		Token  string `yaml:"token"`
+	} `yaml:"grpc"`
+	Authorizer struct {
+		Type  string     `yaml:"type"`
+		Rules []AuthRule `yaml:"rules"`
+		HTTP  struct {
+			URL     string            `yaml:"url"`
+			Timeout string            `yaml:"timeout"`
+			Headers map[string]string `yaml:"headers"`
+		} `yaml:"http"`
+	} `yaml:"authorizer"`
+	Preflight struct {
+		Enabled *bool `yaml:"enabled"`
+		Strict  bool  `yaml:"strict"`
+	} `yaml:"preflight"`
+	CDR struct {
+		JSONFile       string `yaml:"json_file"`
+		CSVFile        string `yaml:"csv_file"`
+		WebhookURL     string `yaml:"webhook_url"`
+		WebhookTimeout string `yaml:"webhook_timeout"`
+	} `yaml:"cdr"`
+	Webhooks struct {
+		Timeout   string    `yaml:"timeout"`
+		Retries   *int      `yaml:"retries"`
+		Endpoints []Webhook `yaml:"endpoints"`
+	} `yaml:"webhooks"`
+	Contacts struct {
+		Names        map[string]string          `yaml:"names"`
+		Telegram     bool                       `yaml:"telegram"`
+		RingProfiles map[string]yamlRingProfile `yaml:"ring_profiles"`
+	} `yaml:"contacts"`
+	FollowMe map[string][]yamlFollowMeStep `yaml:"follow_me"`
+	Calendar struct {
+		URL      string  `yaml:"url"`
+		Type     string  `yaml:"type"`
+		Username string  `yaml:"username"`
+		Password string  `yaml:"password"`
+		Refresh  string  `yaml:"refresh"`
+		Action   string  `yaml:"action"`
+		Message  *string `yaml:"message"`
+	} `yaml:"calendar"`
+	Video struct {
+		Enabled bool   `yaml:"enabled"`
+		Width   int    `yaml:"width"`
+		Height  int    `yaml:"height"`
+		FPS     int    `yaml:"fps"`
+		FFmpeg  string `yaml:"ffmpeg"`
+		Clip    struct {
+			Enabled bool   `yaml:"enabled"`
+			Length  string `yaml:"length"`
+			FFmpeg  string `yaml:"ffmpeg"`
+		} `yaml:"clip"`
+	} `yaml:"video"`
+	Callers struct {
+		Allow        []string `yaml:"allow"`
+		Deny         []string `yaml:"deny"`
+		RejectStatus int      `yaml:"reject_status"`
+	} `yaml:"callers"`
+	Recording struct {
+		Enabled    bool   `yaml:"enabled"`
+		Dir        string `yaml:"dir"`
+		Filename   string `yaml:"filename"`
+		Format     string `yaml:"format"`
+		Mixed      *bool  `yaml:"mixed"`
+		DTMFToggle string `yaml:"dtmf_toggle"`
+		PreRoll    string `yaml:"pre_roll"`
+
+		EncryptionKey    string `yaml:"encryption_key"`
+		EncryptionKeyEnv string `yaml:"encryption_key_env"`
+		Announcement     string `yaml:"announcement_file"`
+		Compliance       bool   `yaml:"compliance"`
+	} `yaml:"recording"`
+	PostProcess struct {
+		Enabled            bool     `yaml:"enabled"`
+		TrimSilence        *bool    `yaml:"trim_silence"`
+		SilenceThresholdDB *float64 `yaml:"silence_threshold_db"`
+		TargetLUFS         *float64 `yaml:"target_lufs"`
+		Opus               bool     `yaml:"opus"`
+	} `yaml:"postprocess"`
+	Storage struct {
+		RetentionDays int     `yaml:"retention_days"`
+		MaxGB         float64 `yaml:"max_gb"`
+		MinFreeGB     float64 `yaml:"min_free_gb"`
+		AlertFreeGB   float64 `yaml:"alert_free_gb"`
+		CheckInterval string  `yaml:"check_interval"`
+		StateFile     *string `yaml:"state_file"`
+		CDRHistory    *int    `yaml:"cdr_history"`
+	} `yaml:"storage"`
+	VoiceChats struct {
+		AutoJoin     []VoiceChatAutoJoin `yaml:"auto_join"`
+		PollInterval string              `yaml:"poll_interval"`
+		Inbound      map[string]int64    `yaml:"inbound"`
+	} `yaml:"voice_chats"`
+	Export struct {
+		Interval      string `yaml:"interval"`
+		BatchSize     int    `yaml:"batch_size"`
+		FlushInterval string `yaml:"flush_interval"`
+		Influx        struct {
+			URL      string `yaml:"url"`
+			Token    string `yaml:"token"`
+			Org      string `yaml:"org"`
+			Bucket   string `yaml:"bucket"`
+			Database string `yaml:"database"`
+		} `yaml:"influx"`
+		Timescale struct {
+			Driver string `yaml:"driver"`
+			DSN    string `yaml:"dsn"`
+		} `yaml:"timescale"`
+	} `yaml:"export"`
 }
 
 func LoadConfig(path string) (Config, error) {
 	cfg := Config{
-		TGSession:        defaultSessionName,
-		SIPBindPort:      defaultSIPBindPort,
-		SIPTransport:     defaultTransport,
-		EstablishTimeout: 25 * time.Second,
-		SampleRate:       defaultSampleRate,
-		Channels:         defaultChannels,
-		FrameDuration:    defaultFrameMs * time.Millisecond,
+		TGSession:           defaultSessionName,
+		SIPBindPort:         defaultSIPBindPort,
+		SIPTransport:        defaultTransport,
+		SIPRegisterExpiry:   time.Hour,
+		SIPMaxRedirects:     3,
+		SIPTCPFallback:      true,
+		SIPConnectionReuse:  true,
+		EstablishTimeout:    25 * time.Second,
+		SampleRate:          defaultSampleRate,
+		Channels:            defaultChannels,
+		FrameDuration:       defaultFrameMs * time.Millisecond,
+		OpusFEC:             true,
+		OpusPLC:             true,
+		AGCToTG:             AGCConfig{TargetDBFS: -18, MaxGainDB: 20},
+		AGCToSIP:            AGCConfig{TargetDBFS: -18, MaxGainDB: 20},
+		NoiseSuppression:    NoiseSuppressionOff,
+		NoiseSuppressionDB:  20,
+		EchoThreshold:       0.85,
+		EchoAttenuationDB:   24,
+		LevelLogInterval:    10 * time.Second,
+		RTPDumpDir:          "rtpdump",
+		RTPDumpFormat:       rtpdump.FormatPCAP,
+		RTPEventsDir:        "rtpdump",
+		CrashReportsEnabled: true,
+		CrashReportsDir:     "crash",
 		// More jitter buffering reduces packet-loss-like glitches (at cost of latency).
-		JitterMinPackets: 10,
+		RTPSymmetric: true,
+
+		JitterMinDelay:   20 * time.Millisecond,
+		JitterMaxDelay:   200 * time.Millisecond,
 		EnableEarlyMedia: true,
 		// Target backlog (10ms TG frames). Higher reduces drop-induced microstutters.
 		DriftTargetFrames: 10,
 		DriftMaxBurst:     2,
 		EnableDTMF:        true,
+		STUNServer:        defaultSTUNServer,
+		PreflightEnabled:  true,
+		TGProtocolCheck:   ProtocolCheckStrict,
+		CDRWebhookTimeout: 5 * time.Second,
+		WebhookTimeout:    5 * time.Second,
+		WebhookRetries:    3,
+		RecordingDir:      "recordings",
+		RecordingTemplate: recording.DefaultTemplate,
+		RecordingFormat:   recording.FormatWAV,
+		RecordingMixed:    true,
+
+		TGParticipantEvents: true,
+		DTMFRelay:           true,
+
+		VoiceChatPollInterval: 30 * time.Second,
+
+		DrainTimeout:          5 * time.Minute,
+		ConfirmInboundTimeout: 30 * time.Second,
+		CallFilesPoll:         time.Second,
+		ScheduleRetries:       2,
+		ScheduleRetryInterval: 5 * time.Minute,
+		BusyAction:            BusyNotify,
+		CallSummary:           true,
+
+		StorageCheckInterval: 10 * time.Minute,
+		StateFile:            "state.db",
+		StateCDRLimit:        10000,
+
+		LatencyProbeInterval: 15 * time.Second,
+
+		CallersRejectStatus: 603,
+
+		CalendarType:    CalendarICS,
+		CalendarRefresh: 5 * time.Minute,
+		CalendarAction:  CalendarVoicemail,
+		CalendarMessage: "I'm in a meeting until {until}. Please leave a message after the tone.",
+
+		VideoClipLength: 10 * time.Second,
+		VideoClipFFmpeg: "ffmpeg",
+		VideoWidth:      640,
+		VideoHeight:     480,
+		VideoFPS:        15,
+		VideoFFmpeg:     "ffmpeg",
+
+		VoicemailMaxLength: time.Minute,
+		VoicemailDir:       "voicemail",
+
+		ScreeningPrompt:    "Please say your name after the tone.",
+		ScreeningMaxLength: 5 * time.Second,
+
+		IVRStart:        "main",
+		IVRTimeout:      5 * time.Second,
+		IVRDigitTimeout: 3 * time.Second,
+
+		IVRWebhookTimeout: 5 * time.Second,
+
+		ASRSampleRate:    16000,
+		ASRMinConfidence: 0.5,
+		ASRTimeout:       5 * time.Second,
+		ASRFormat:        asrFormatStream,
+		Transcription:    TranscribeLegs{SIP: true, TG: true},
+
+		ScriptTimeout: time.Second,
+
+		AuthTimeout: 3 * time.Second,
+
+		PostTrimSilence:      true,
+		PostSilenceThreshold: -50,
+		PostTargetLUFS:       -16,
+
+		ExportInterval:      10 * time.Second,
+		ExportFlushInterval: 5 * time.Second,
+		ExportSQLDriver:     "pgx",
 	}
 
 	data, err := os.ReadFile(path)
@@ -123,6 +970,20 @@ func LoadConfig(path string) (Config, error) {
 		cfg.TGSession = yc.Telegram.Session
 	}
 
+	cfg.TGSessionKey = yc.Telegram.SessionKey
+	cfg.TGSessionKeyEnv = yc.Telegram.SessionKeyEnv
+	cfg.TGSessionKeyring = yc.Telegram.SessionKeyKeyring
+
+	if yc.Telegram.ProtocolCheck != "" {
+		cfg.TGProtocolCheck = strings.ToLower(yc.Telegram.ProtocolCheck)
+	}
+	if cfg.TGProtocolCheck != ProtocolCheckStrict && cfg.TGProtocolCheck != ProtocolCheckWarn {
+		return Config{}, fmt.Errorf("telegram.protocol_check must be 'strict' or 'warn', got %q", cfg.TGProtocolCheck)
+	}
+	if yc.Telegram.ParticipantEvents != nil {
+		cfg.TGParticipantEvents = *yc.Telegram.ParticipantEvents
+	}
+
 	if yc.Telegram.UserID == 0 {
 		return Config{}, errors.New("telegram.user_id is required")
 	}
@@ -146,6 +1007,37 @@ func LoadConfig(path string) (Config, error) {
 	}
 
 	cfg.SIPExternalIP = yc.SIP.ExternalIP
+	if ip := net.ParseIP(yc.SIP.ExternalIP6); yc.SIP.ExternalIP6 != "" && (ip == nil || ip.To4() != nil) {
+		return Config{}, fmt.Errorf("invalid sip.external_ip6 %q (want an IPv6 address)", yc.SIP.ExternalIP6)
+	}
+	cfg.SIPExternalIP6 = yc.SIP.ExternalIP6
+	bindHosts := func(key string, hosts, fallback []string) ([]string, error) {
+		if len(hosts) == 0 {
+			return fallback, nil
+		}
+		ipv6 := map[bool]bool{}
+		for _, host := range hosts {
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s entry %q (want an IP address such as 0.0.0.0 or ::)", key, host)
+			}
+			if ipv6[ip.To4() == nil] {
+				return nil, fmt.Errorf("%s: one address per IP family", key)
+			}
+			ipv6[ip.To4() == nil] = true
+		}
+		return hosts, nil
+	}
+	hosts, err := bindHosts("sip.bind_hosts", yc.SIP.BindHosts, []string{"0.0.0.0"})
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.SIPUDPBindHosts, err = bindHosts("sip.udp_bind_hosts", yc.SIP.UDPBindHosts, hosts); err != nil {
+		return Config{}, err
+	}
+	if cfg.SIPTCPBindHosts, err = bindHosts("sip.tcp_bind_hosts", yc.SIP.TCPBindHosts, hosts); err != nil {
+		return Config{}, err
+	}
 
 	cfg.SIPAuthUser = yc.SIP.AuthUser
 	cfg.SIPAuthPass = yc.SIP.AuthPassword
@@ -153,9 +1045,123 @@ func LoadConfig(path string) (Config, error) {
 		return Config{}, errors.New("sip.auth_user and sip.auth_password must be set together")
 	}
 	cfg.SIPAuthRealm = yc.SIP.AuthRealm
+	for _, alg := range yc.SIP.AuthAlgorithms {
+		alg = strings.ToUpper(strings.TrimSpace(alg))
+		if !slices.Contains(diago.DigestAlgorithms, alg) {
+			return Config{}, fmt.Errorf("invalid sip.auth_algorithms entry %q (%s)", alg, strings.Join(diago.DigestAlgorithms, ", "))
+		}
+		if !slices.Contains(cfg.SIPAuthAlgorithms, alg) {
+			cfg.SIPAuthAlgorithms = append(cfg.SIPAuthAlgorithms, alg)
+		}
+	}
+	for _, qop := range yc.SIP.AuthQOP {
+		qop = strings.ToLower(strings.TrimSpace(qop))
+		if qop != "auth" && qop != "auth-int" {
+			return Config{}, fmt.Errorf("invalid sip.auth_qop entry %q (auth or auth-int)", qop)
+		}
+		if !slices.Contains(cfg.SIPAuthQOP, qop) {
+			cfg.SIPAuthQOP = append(cfg.SIPAuthQOP, qop)
+		}
+	}
+	for i, acc := range yc.SIP.Accounts {
+		acc.Username = strings.TrimSpace(acc.Username)
+		if acc.Username == "" || acc.Password == "" {
+			return Config{}, fmt.Errorf("sip.accounts[%d]: username and password are required", i)
+		}
+		if slices.ContainsFunc(cfg.SIPAccounts, func(a SIPAccount) bool { return a.Username == acc.Username }) {
+			return Config{}, fmt.Errorf("sip.accounts[%d]: duplicate username %q", i, acc.Username)
+		}
+		cfg.SIPAccounts = append(cfg.SIPAccounts, acc)
+	}
+	if yc.SIP.RegisterExpiry != "" {
+		d, err := time.ParseDuration(yc.SIP.RegisterExpiry)
+		if err != nil || d < time.Minute {
+			return Config{}, fmt.Errorf("invalid sip.register_expiry %q (duration of at least 1m)", yc.SIP.RegisterExpiry)
+		}
+		cfg.SIPRegisterExpiry = d
+	}
+	cfg.SIPOutbound = yc.SIP.Outbound
+	if id := strings.TrimSpace(yc.SIP.InstanceID); id != "" {
+		uuid := strings.TrimPrefix(strings.ToLower(id), "urn:uuid:")
+		if !isUUID(uuid) {
+			return Config{}, fmt.Errorf("invalid sip.instance_id %q (a UUID)", yc.SIP.InstanceID)
+		}
+		cfg.SIPInstanceID = "urn:uuid:" + uuid
+	} else {
+		cfg.SIPInstanceID = defaultInstanceID(cfg.SIPAuthUser, cfg.SIPProvider)
+	}
+	if yc.SIP.KeepAlive != "" {
+		d, err := time.ParseDuration(yc.SIP.KeepAlive)
+		if err != nil || (d != 0 && d < 5*time.Second) {
+			return Config{}, fmt.Errorf("invalid sip.keepalive_interval %q (0 or at least 5s)", yc.SIP.KeepAlive)
+		}
+		cfg.SIPKeepAlive = d
+	}
+	if yc.SIP.MaxRedirects != nil {
+		if n := *yc.SIP.MaxRedirects; n < 0 || n > 10 {
+			return Config{}, fmt.Errorf("invalid sip.max_redirects %d (0-10)", n)
+		}
+		cfg.SIPMaxRedirects = *yc.SIP.MaxRedirects
+	}
+	cfg.SIPProfile = strings.TrimSpace(yc.SIP.ProviderProfile)
+	quirks, err := parseQuirks(cfg.SIPProfile, yc.SIP.ProviderProfiles, yc.SIP.Quirks)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.SIPQuirks = quirks
+	if yc.SIP.TCPFallback != nil {
+		cfg.SIPTCPFallback = *yc.SIP.TCPFallback
+	}
+	if yc.SIP.ConnectionReuse != nil {
+		cfg.SIPConnectionReuse = *yc.SIP.ConnectionReuse
+	}
+	cfg.SIPOutboundProxy = strings.TrimSpace(yc.SIP.OutboundProxy)
+	if host, _ := splitHostPort(cfg.SIPOutboundProxy); cfg.SIPOutboundProxy != "" && (host == "" || strings.ContainsAny(host, "/;<> ")) {
+		return Config{}, fmt.Errorf("invalid sip.outbound_proxy %q (host or host:port)", yc.SIP.OutboundProxy)
+	}
+	if cfg.SIPRoute, err = parseRoute(yc.SIP.Route); err != nil {
+		return Config{}, err
+	}
+	if cfg.SIPHeaders, err = parseTrunkHeaders(yc.SIP.Headers); err != nil {
+		return Config{}, err
+	}
+	if cfg.SIPCaptureHeaders, err = parseCaptureHeaders(yc.SIP.CaptureHeaders); err != nil {
+		return Config{}, err
+	}
+	switch rel100 := strings.ToLower(strings.TrimSpace(yc.SIP.Rel100)); rel100 {
+	case "", "off":
+		cfg.SIPRel100 = diago.Rel100Off
+	case diago.Rel100On, diago.Rel100Require:
+		cfg.SIPRel100 = rel100
+	default:
+		return Config{}, fmt.Errorf("invalid sip.rel100 %q (off, on or require)", yc.SIP.Rel100)
+	}
 
 	cfg.EnableDTMF = yc.SIP.DTMFEnabled
+	if yc.SIP.DTMFRelay != nil {
+		cfg.DTMFRelay = *yc.SIP.DTMFRelay
+	}
 	cfg.EnableEarlyMedia = yc.SIP.EarlyMedia
+	if yc.SIP.STUNServer != "" {
+		cfg.STUNServer = yc.SIP.STUNServer
+	}
+	cfg.SIPDetectExternalIP = cfg.SIPExternalIP == ""
+	if yc.SIP.DetectIP != nil {
+		cfg.SIPDetectExternalIP = *yc.SIP.DetectIP && cfg.SIPExternalIP == ""
+	}
+	if yc.SIP.IPRefresh != "" {
+		d, err := time.ParseDuration(yc.SIP.IPRefresh)
+		if err != nil || (d != 0 && d < 10*time.Second) {
+			return Config{}, fmt.Errorf("invalid sip.external_ip_refresh %q (0 or at least 10s)", yc.SIP.IPRefresh)
+		}
+		cfg.SIPExternalIPRefresh = d
+	}
+	for _, id := range yc.SIP.CallerIDs {
+		if normalizePhone(id) == "" {
+			return Config{}, fmt.Errorf("invalid sip.caller_ids entry %q", id)
+		}
+	}
+	cfg.SIPCallerIDs = yc.SIP.CallerIDs
 
 	// Audio
 	if yc.Audio.SampleRate > 0 {
@@ -167,9 +1173,301 @@ func LoadConfig(path string) (Config, error) {
 	if cfg.Channels != 1 {
 		return Config{}, fmt.Errorf("audio.channels must be 1 for now, got %d", cfg.Channels)
 	}
+	cfg.RingbackFile = yc.Audio.RingbackFile
+	cfg.HoldMusicFile = yc.Audio.HoldMusicFile
+	tones := strings.ToLower(strings.TrimSpace(yc.Audio.ProgressTones))
+	if _, ok := progressToneSets[tones]; ok {
+		cfg.ProgressTones = tones
+	} else if tones != "" && tones != "off" {
+		return Config{}, fmt.Errorf("invalid audio.progress_tones %q (off or one of %s)", yc.Audio.ProgressTones, progressToneCountries())
+	}
 	if yc.Audio.FrameMs > 0 {
 		cfg.FrameDuration = time.Duration(yc.Audio.FrameMs) * time.Millisecond
 	}
+	for _, r := range []struct {
+		name string
+		rate int
+		dst  *int
+	}{
+		{"audio.telegram_send_rate", yc.Audio.TelegramSendRate, &cfg.TGSendRate},
+		{"audio.telegram_receive_rate", yc.Audio.TelegramReceiveRate, &cfg.TGReceiveRate},
+	} {
+		if r.rate == 0 {
+			continue
+		}
+		if r.rate < 8000 || r.rate > 48000 || r.rate%100 != 0 {
+			return Config{}, fmt.Errorf("invalid %s %d (8000-48000 Hz in whole 10ms frames)", r.name, r.rate)
+		}
+		*r.dst = r.rate
+	}
+	if yc.Audio.OpusFEC != nil {
+		cfg.OpusFEC = *yc.Audio.OpusFEC
+	}
+	if yc.Audio.OpusPLC != nil {
+		cfg.OpusPLC = *yc.Audio.OpusPLC
+	}
+	available := AvailableCodecs()
+	for _, name := range yc.Audio.Codecs {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(available, name) {
+			hint := ""
+			if name == "opus" {
+				hint = "; opus needs -tags opus"
+			}
+			return Config{}, fmt.Errorf("invalid audio.codecs entry %q (available: %s%s)", name, strings.Join(available, ", "), hint)
+		}
+		if !slices.Contains(cfg.Codecs, name) {
+			cfg.Codecs = append(cfg.Codecs, name)
+		}
+	}
+	if err := cfg.AGCToTG.parse(yc.Audio.AGCToTG, "audio.agc_to_telegram"); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.AGCToSIP.parse(yc.Audio.AGCToSIP, "audio.agc_to_sip"); err != nil {
+		return Config{}, err
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(yc.Audio.NoiseSuppression)); mode {
+	case "":
+	case NoiseSuppressionOff, NoiseSuppressionSpectral:
+		cfg.NoiseSuppression = mode
+	default:
+		return Config{}, fmt.Errorf("invalid audio.noise_suppression %q (off or spectral)", yc.Audio.NoiseSuppression)
+	}
+	if yc.Audio.NoiseSuppressionDB != nil {
+		if v := *yc.Audio.NoiseSuppressionDB; v < 3 || v > 40 {
+			return Config{}, fmt.Errorf("invalid audio.noise_suppression_db %g (3-40)", v)
+		}
+		cfg.NoiseSuppressionDB = *yc.Audio.NoiseSuppressionDB
+	}
+	cfg.EchoProtection = yc.Audio.EchoProtection
+	if yc.Audio.EchoThreshold != nil {
+		if v := *yc.Audio.EchoThreshold; v < 0.5 || v >= 1 {
+			return Config{}, fmt.Errorf("invalid audio.echo_threshold %g (0.5-0.99)", v)
+		}
+		cfg.EchoThreshold = *yc.Audio.EchoThreshold
+	}
+	if yc.Audio.EchoAttenuationDB != nil {
+		if v := *yc.Audio.EchoAttenuationDB; v < 6 || v > 60 {
+			return Config{}, fmt.Errorf("invalid audio.echo_attenuation_db %g (6-60)", v)
+		}
+		cfg.EchoAttenuationDB = *yc.Audio.EchoAttenuationDB
+	}
+	if yc.Audio.LevelLogInterval != nil {
+		d, err := time.ParseDuration(*yc.Audio.LevelLogInterval)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid audio.level_log_interval %q", *yc.Audio.LevelLogInterval)
+		}
+		cfg.LevelLogInterval = d
+	}
+
+	// Debug dumps
+	cfg.RTPDumpEnabled = yc.Debug.RTPDump.Enabled
+	if yc.Debug.RTPDump.Dir != "" {
+		cfg.RTPDumpDir = yc.Debug.RTPDump.Dir
+	}
+	if yc.Debug.RTPDump.Format != "" {
+		switch f := strings.ToLower(yc.Debug.RTPDump.Format); f {
+		case rtpdump.FormatPCAP, rtpdump.FormatRaw:
+			cfg.RTPDumpFormat = f
+		default:
+			return Config{}, fmt.Errorf("invalid debug.rtp_dump.format %q (pcap or raw)", yc.Debug.RTPDump.Format)
+		}
+	}
+	cfg.RTPEventsEnabled = yc.Debug.RTPEvents.Enabled
+	if yc.Debug.RTPEvents.Dir != "" {
+		cfg.RTPEventsDir = yc.Debug.RTPEvents.Dir
+	}
+	if yc.Debug.CrashReports.Enabled != nil {
+		cfg.CrashReportsEnabled = *yc.Debug.CrashReports.Enabled
+	}
+	if yc.Debug.CrashReports.Dir != "" {
+		cfg.CrashReportsDir = yc.Debug.CrashReports.Dir
+	}
+	cfg.CrashReportsNotify = yc.Debug.CrashReports.Notify
+
+	// Announcements
+	cfg.AnnounceAnswer = yc.Announcements.Answer
+	for key, path := range map[string]string{
+		AnnounceBusy:         yc.Announcements.Busy,
+		AnnounceBlocked:      yc.Announcements.Blocked,
+		AnnounceUnavailable:  yc.Announcements.Unavailable,
+		AnnounceDeclined:     yc.Announcements.Declined,
+		AnnounceNoAnswer:     yc.Announcements.NoAnswer,
+		AnnounceShuttingDown: yc.Announcements.ShuttingDown,
+	} {
+		if path = strings.TrimSpace(path); path != "" {
+			if cfg.Announcements == nil {
+				cfg.Announcements = make(map[string]string)
+			}
+			cfg.Announcements[key] = path
+		}
+	}
+
+	// Voicemail
+	cfg.VoicemailEnabled = yc.Voicemail.Enabled
+	cfg.VoicemailGreetingFile = strings.TrimSpace(yc.Voicemail.Greeting)
+	if yc.Voicemail.MaxLength != "" {
+		maxLength, err := time.ParseDuration(yc.Voicemail.MaxLength)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid voicemail.max_length: %w", err)
+		}
+		if maxLength < 5*time.Second || maxLength > 10*time.Minute {
+			return Config{}, errors.New("voicemail.max_length must be between 5s and 10m")
+		}
+		cfg.VoicemailMaxLength = maxLength
+	}
+	if yc.Voicemail.Dir != "" {
+		cfg.VoicemailDir = yc.Voicemail.Dir
+	}
+
+	// Call screening
+	cfg.ScreeningEnabled = yc.Screening.Enabled
+	cfg.ScreeningPromptFile = strings.TrimSpace(yc.Screening.PromptFile)
+	if p := strings.TrimSpace(yc.Screening.Prompt); p != "" {
+		cfg.ScreeningPrompt = p
+	}
+	if yc.Screening.MaxLength != "" {
+		maxLength, err := time.ParseDuration(yc.Screening.MaxLength)
+		if err != nil || maxLength < 2*time.Second || maxLength > 30*time.Second {
+			return Config{}, fmt.Errorf("invalid screening.max_length %q (2s to 30s)", yc.Screening.MaxLength)
+		}
+		cfg.ScreeningMaxLength = maxLength
+	}
+
+	// Text-to-speech and directory
+	cfg.TTSCommand = yc.TTS.Command
+	if len(cfg.TTSCommand) > 0 && strings.TrimSpace(cfg.TTSCommand[0]) == "" {
+		return Config{}, errors.New("tts.command must start with the program to run")
+	}
+	cfg.TTSURL = strings.TrimSpace(yc.TTS.URL)
+	if cfg.TTSURL != "" && !strings.HasPrefix(cfg.TTSURL, "http://") && !strings.HasPrefix(cfg.TTSURL, "https://") {
+		return Config{}, fmt.Errorf("invalid tts.url %q (want http:// or https://)", cfg.TTSURL)
+	}
+	cfg.TTSHeaders = yc.TTS.Headers
+	cfg.DirectoryPrompt = strings.TrimSpace(yc.Directory.Prompt)
+	seen := make(map[int64]bool)
+	for i, e := range yc.Directory.Entries {
+		switch {
+		case strings.TrimSpace(e.Name) == "":
+			return Config{}, fmt.Errorf("directory.entries[%d].name is required", i)
+		case e.UserID <= 0:
+			return Config{}, fmt.Errorf("directory.entries[%d].user_id must be a Telegram user id", i)
+		case seen[e.UserID]:
+			return Config{}, fmt.Errorf("directory.entries[%d]: user %d is listed twice", i, e.UserID)
+		case e.Prompt == "" && !cfg.ttsEnabled():
+			return Config{}, fmt.Errorf("directory.entries[%d] needs a prompt, tts.command or tts.url", i)
+		case strings.Trim(e.Keys, "0123456789") != "":
+			return Config{}, fmt.Errorf("directory.entries[%d].keys must be digits", i)
+		}
+		seen[e.UserID] = true
+	}
+	cfg.Directory = yc.Directory.Entries
+	if cfg.ScreeningEnabled && cfg.ScreeningPromptFile == "" && !cfg.ttsEnabled() {
+		return Config{}, errors.New("screening needs a prompt_file, tts.command or tts.url")
+	}
+
+	// Speech recognition
+	cfg.ASRURL = strings.TrimSpace(yc.ASR.URL)
+	if cfg.ASRURL != "" && !strings.HasPrefix(cfg.ASRURL, "http://") && !strings.HasPrefix(cfg.ASRURL, "https://") {
+		return Config{}, fmt.Errorf("invalid asr.url %q (want http:// or https://)", cfg.ASRURL)
+	}
+	if yc.ASR.SampleRate != 0 {
+		if yc.ASR.SampleRate < 8000 || yc.ASR.SampleRate > 48000 {
+			return Config{}, errors.New("asr.sample_rate must be between 8000 and 48000")
+		}
+		cfg.ASRSampleRate = yc.ASR.SampleRate
+	}
+	if yc.ASR.MinConfidence != nil {
+		if *yc.ASR.MinConfidence < 0 || *yc.ASR.MinConfidence > 1 {
+			return Config{}, errors.New("asr.min_confidence must be between 0 and 1")
+		}
+		cfg.ASRMinConfidence = *yc.ASR.MinConfidence
+	}
+	if yc.ASR.Timeout != "" {
+		timeout, err := time.ParseDuration(yc.ASR.Timeout)
+		if err != nil || timeout < time.Second {
+			return Config{}, fmt.Errorf("invalid asr.timeout %q (at least 1s)", yc.ASR.Timeout)
+		}
+		cfg.ASRTimeout = timeout
+	}
+	cfg.ASRHeaders = yc.ASR.Headers
+	switch format := strings.ToLower(strings.TrimSpace(yc.ASR.Format)); format {
+	case "":
+	case asrFormatStream, asrFormatWhisper:
+		cfg.ASRFormat = format
+	default:
+		return Config{}, fmt.Errorf("invalid asr.format %q (stream or whisper)", yc.ASR.Format)
+	}
+	cfg.ASRFields = yc.ASR.Fields
+
+	// Transcription
+	if yc.Transcription.Legs != "" {
+		legs, err := ParseTranscribeLegs(yc.Transcription.Legs)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid transcription.legs: %w", err)
+		}
+		cfg.Transcription = legs
+	}
+	cfg.TranscriptionEnabled = yc.Transcription.Enabled
+	if cfg.TranscriptionEnabled && cfg.ASRURL == "" {
+		return Config{}, errors.New("transcription.enabled needs asr.url")
+	}
+
+	// Text messages
+	cfg.SMSEnabled = yc.SMS.Enabled
+	cfg.SMSURL = strings.TrimSpace(yc.SMS.URL)
+	if cfg.SMSURL != "" && !strings.HasPrefix(cfg.SMSURL, "http://") && !strings.HasPrefix(cfg.SMSURL, "https://") {
+		return Config{}, fmt.Errorf("invalid sms.url %q (want http:// or https://)", cfg.SMSURL)
+	}
+	cfg.SMSHeaders = yc.SMS.Headers
+
+	// IVR
+	cfg.IVRWebhookURL = strings.TrimSpace(yc.IVR.Webhook.URL)
+	if yc.IVR.Enabled || cfg.IVRWebhookURL != "" {
+		if yc.IVR.Timeout != "" {
+			timeout, err := time.ParseDuration(yc.IVR.Timeout)
+			if err != nil || timeout < time.Second {
+				return Config{}, fmt.Errorf("invalid ivr.timeout %q (at least 1s)", yc.IVR.Timeout)
+			}
+			cfg.IVRTimeout = timeout
+		}
+		if yc.IVR.DigitTimeout != "" {
+			timeout, err := time.ParseDuration(yc.IVR.DigitTimeout)
+			if err != nil || timeout < time.Second {
+				return Config{}, fmt.Errorf("invalid ivr.digit_timeout %q (at least 1s)", yc.IVR.DigitTimeout)
+			}
+			cfg.IVRDigitTimeout = timeout
+		}
+		if !cfg.EnableDTMF {
+			return Config{}, errors.New("ivr needs sip.dtmf_enabled")
+		}
+	}
+	if yc.IVR.Enabled {
+		if cfg.IVRWebhookURL != "" {
+			return Config{}, errors.New("ivr.webhook.url replaces the ivr menus; disable ivr.enabled")
+		}
+		if yc.IVR.Start != "" {
+			cfg.IVRStart = yc.IVR.Start
+		}
+		cfg.IVRInvalidPrompt = strings.TrimSpace(yc.IVR.InvalidPrompt)
+		cfg.IVRMenus = yc.IVR.Menus
+		if err := validateIVR(cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.IVRWebhookURL != "" {
+		if !strings.HasPrefix(cfg.IVRWebhookURL, "http://") && !strings.HasPrefix(cfg.IVRWebhookURL, "https://") {
+			return Config{}, fmt.Errorf("invalid ivr.webhook.url %q (want http:// or https://)", cfg.IVRWebhookURL)
+		}
+		if yc.IVR.Webhook.Timeout != "" {
+			timeout, err := time.ParseDuration(yc.IVR.Webhook.Timeout)
+			if err != nil || timeout <= 0 {
+				return Config{}, fmt.Errorf("invalid ivr.webhook.timeout %q", yc.IVR.Webhook.Timeout)
+			}
+			cfg.IVRWebhookTimeout = timeout
+		}
+		cfg.IVRWebhookHeaders = yc.IVR.Webhook.Headers
+	}
 
 	// Call
 	if yc.Call.EstablishTimeout != "" {
@@ -182,11 +1480,77 @@ func LoadConfig(path string) (Config, error) {
 	if yc.Call.MaxActiveCalls > 0 {
 		cfg.MaxActiveCalls = yc.Call.MaxActiveCalls
 	}
+	if yc.Call.DrainTimeout != "" {
+		timeout, err := time.ParseDuration(yc.Call.DrainTimeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid call.drain_timeout: %w", err)
+		}
+		cfg.DrainTimeout = timeout
+	}
+	cfg.ConfirmInbound = yc.Call.ConfirmInbound
+	if yc.Call.ConfirmTimeout != "" {
+		timeout, err := time.ParseDuration(yc.Call.ConfirmTimeout)
+		if err != nil || timeout <= 0 {
+			return Config{}, fmt.Errorf("invalid call.confirm_timeout %q", yc.Call.ConfirmTimeout)
+		}
+		cfg.ConfirmInboundTimeout = timeout
+	}
+	if yc.Call.BusyAction != "" {
+		switch a := strings.ToLower(yc.Call.BusyAction); a {
+		case BusyReject, BusyNotify, BusyVoicemail, BusyWait:
+			cfg.BusyAction = a
+		default:
+			return Config{}, fmt.Errorf("invalid call.busy_action %q (reject, notify, voicemail or wait)", yc.Call.BusyAction)
+		}
+	}
+	if yc.Call.Summary != nil {
+		cfg.CallSummary = *yc.Call.Summary
+	}
+	if cfg.Ringing, err = parseRinging(yc.Call.Ringing.yamlRinging, "call.ringing"); err != nil {
+		return Config{}, err
+	}
+	if cfg.RingingRoutes, err = parseRingingRoutes(yc.Call.Ringing.Routes); err != nil {
+		return Config{}, err
+	}
+
+	// RTP
+	if yc.RTP.PortMin != 0 || yc.RTP.PortMax != 0 {
+		minPort, maxPort := yc.RTP.PortMin, yc.RTP.PortMax
+		if minPort < 1024 || maxPort > 65535 || maxPort-minPort < 3 {
+			return Config{}, fmt.Errorf("invalid rtp.port_min/port_max %d-%d (a range of at least 4 ports within 1024-65535)", minPort, maxPort)
+		}
+		if minPort%2 != 0 {
+			return Config{}, fmt.Errorf("rtp.port_min must be even, got %d", minPort)
+		}
+		cfg.RTPPortMin, cfg.RTPPortMax = minPort, maxPort
+	}
+	if yc.RTP.Symmetric != nil {
+		cfg.RTPSymmetric = *yc.RTP.Symmetric
+	}
 
 	// Jitter
+	// min_packets is the fixed depth of older configs, in 20ms packets.
 	if yc.Jitter.MinPackets > 0 {
-		cfg.JitterMinPackets = uint16(yc.Jitter.MinPackets)
+		cfg.JitterMinDelay = time.Duration(yc.Jitter.MinPackets) * 20 * time.Millisecond
+	}
+	if yc.Jitter.MinDelay != "" {
+		d, err := time.ParseDuration(yc.Jitter.MinDelay)
+		if err != nil || d < 0 || d > 2*time.Second {
+			return Config{}, fmt.Errorf("invalid jitter.min_delay %q (0 to 2s)", yc.Jitter.MinDelay)
+		}
+		cfg.JitterMinDelay = d
+	}
+	if yc.Jitter.MaxDelay != "" {
+		d, err := time.ParseDuration(yc.Jitter.MaxDelay)
+		if err != nil || d < 0 || d > 2*time.Second {
+			return Config{}, fmt.Errorf("invalid jitter.max_delay %q (0 to 2s)", yc.Jitter.MaxDelay)
+		}
+		cfg.JitterMaxDelay = d
+	}
+	if cfg.JitterMaxDelay > 0 && cfg.JitterMaxDelay < cfg.JitterMinDelay {
+		cfg.JitterMaxDelay = cfg.JitterMinDelay
 	}
+	cfg.JitterRTCPAdapt = yc.Jitter.RTCPAdapt
 	if yc.Jitter.DriftTargetFrames > 0 {
 		cfg.DriftTargetFrames = yc.Jitter.DriftTargetFrames
 	}
@@ -194,5 +1558,424 @@ func LoadConfig(path string) (Config, error) {
 		cfg.DriftMaxBurst = yc.Jitter.DriftMaxBurst
 	}
 
+	// Latency probe
+	cfg.LatencyProbe = yc.LatencyProbe.Enabled
+	if yc.LatencyProbe.Interval != "" {
+		interval, err := time.ParseDuration(yc.LatencyProbe.Interval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid latency_probe.interval: %w", err)
+		}
+		if interval < 2*time.Second {
+			return Config{}, errors.New("latency_probe.interval must be at least 2s")
+		}
+		cfg.LatencyProbeInterval = interval
+	}
+
+	// Test call
+	cfg.TestCallNumber = strings.TrimSpace(yc.TestCall.Number)
+	if yc.TestCall.Interval != "" {
+		interval, err := time.ParseDuration(yc.TestCall.Interval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid test_call.interval: %w", err)
+		}
+		if interval < time.Minute {
+			return Config{}, errors.New("test_call.interval must be at least 1m")
+		}
+		if cfg.TestCallNumber == "" {
+			return Config{}, errors.New("test_call.interval needs test_call.number")
+		}
+		cfg.TestCallInterval = interval
+	}
+
+	// Call files
+	cfg.CallFilesDir = strings.TrimSpace(yc.CallFiles.Dir)
+	if yc.CallFiles.Poll != "" {
+		poll, err := time.ParseDuration(yc.CallFiles.Poll)
+		if err != nil || poll < 100*time.Millisecond {
+			return Config{}, fmt.Errorf("invalid call_files.poll %q (at least 100ms)", yc.CallFiles.Poll)
+		}
+		cfg.CallFilesPoll = poll
+	}
+
+	// Scheduled calls
+	if yc.Schedule.Retries != nil {
+		if *yc.Schedule.Retries < 0 {
+			return Config{}, fmt.Errorf("invalid schedule.retries %d", *yc.Schedule.Retries)
+		}
+		cfg.ScheduleRetries = *yc.Schedule.Retries
+	}
+	if yc.Schedule.RetryInterval != "" {
+		interval, err := time.ParseDuration(yc.Schedule.RetryInterval)
+		if err != nil || interval < time.Second {
+			return Config{}, fmt.Errorf("invalid schedule.retry_interval %q (at least 1s)", yc.Schedule.RetryInterval)
+		}
+		cfg.ScheduleRetryInterval = interval
+	}
+
+	// Echo
+	cfg.EchoExtension = strings.TrimSpace(yc.Echo.Extension)
+	cfg.EchoGreetingFile = strings.TrimSpace(yc.Echo.Greeting)
+	if yc.Echo.Delay != "" {
+		delay, err := time.ParseDuration(yc.Echo.Delay)
+		if err != nil || delay < 0 || delay > 5*time.Second {
+			return Config{}, fmt.Errorf("invalid echo.delay %q (0 to 5s)", yc.Echo.Delay)
+		}
+		cfg.EchoDelay = delay
+	}
+
+	// Info lines
+	cfg.InfoLines = yc.InfoLines
+	if err := validateInfoLines(cfg); err != nil {
+		return Config{}, err
+	}
+
+	// Script
+	cfg.ScriptFile = strings.TrimSpace(yc.Script.File)
+	if yc.Script.Timeout != "" {
+		timeout, err := time.ParseDuration(yc.Script.Timeout)
+		if err != nil || timeout <= 0 {
+			return Config{}, fmt.Errorf("invalid script.timeout %q", yc.Script.Timeout)
+		}
+		cfg.ScriptTimeout = timeout
+	}
+
+	// Plugins
+	for i, p := range yc.Plugins {
+		if strings.TrimSpace(p.Path) == "" {
+			return Config{}, fmt.Errorf("plugins[%d].path is required", i)
+		}
+		yc.Plugins[i].Path = strings.TrimSpace(p.Path)
+	}
+	cfg.Plugins = yc.Plugins
+
+	// Authorizer
+	cfg.Authorizer = strings.ToLower(strings.TrimSpace(yc.Authorizer.Type))
+	switch cfg.Authorizer {
+	case AuthorizerDefault:
+	case AuthorizerRules:
+		if _, err := NewRuleAuthorizer(yc.Authorizer.Rules, nil); err != nil {
+			return Config{}, err
+		}
+		cfg.AuthRules = yc.Authorizer.Rules
+	case AuthorizerHTTP:
+		cfg.AuthURL = strings.TrimSpace(yc.Authorizer.HTTP.URL)
+		if !strings.HasPrefix(cfg.AuthURL, "http://") && !strings.HasPrefix(cfg.AuthURL, "https://") {
+			return Config{}, fmt.Errorf("invalid authorizer.http.url %q (want http:// or https://)", cfg.AuthURL)
+		}
+		if yc.Authorizer.HTTP.Timeout != "" {
+			timeout, err := time.ParseDuration(yc.Authorizer.HTTP.Timeout)
+			if err != nil || timeout <= 0 {
+				return Config{}, fmt.Errorf("invalid authorizer.http.timeout %q", yc.Authorizer.HTTP.Timeout)
+			}
+			cfg.AuthTimeout = timeout
+		}
+		cfg.AuthHeaders = yc.Authorizer.HTTP.Headers
+	default:
+		return Config{}, fmt.Errorf("invalid authorizer.type %q (want rules or http)", yc.Authorizer.Type)
+	}
+
+	// API
+	cfg.APIListen = strings.TrimSpace(yc.API.Listen)
+	cfg.APIToken = yc.API.Token
+	cfg.GRPCListen = strings.TrimSpace(yc.GRPC.Listen)
+	cfg.GRPCToken = yc.GRPC.Token
+
+	// Preflight
+	if yc.Preflight.Enabled != nil {
+		cfg.PreflightEnabled = *yc.Preflight.Enabled
+	}
+	cfg.PreflightStrict = yc.Preflight.Strict
+
+	// CDR
+	cfg.CDRJSONFile = yc.CDR.JSONFile
+	cfg.CDRCSVFile = yc.CDR.CSVFile
+	cfg.CDRWebhookURL = strings.TrimSpace(yc.CDR.WebhookURL)
+	if yc.CDR.WebhookTimeout != "" {
+		timeout, err := time.ParseDuration(yc.CDR.WebhookTimeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid cdr.webhook_timeout: %w", err)
+		}
+		cfg.CDRWebhookTimeout = timeout
+	}
+
+	// Webhooks
+	for i, w := range yc.Webhooks.Endpoints {
+		w.URL = strings.TrimSpace(w.URL)
+		if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+			return Config{}, fmt.Errorf("invalid webhooks.endpoints[%d].url %q (want http:// or https://)", i, w.URL)
+		}
+		for _, ev := range w.Events {
+			if !slices.Contains(webhook.Types, ev) {
+				return Config{}, fmt.Errorf("invalid webhooks.endpoints[%d].events entry %q (one of %s)", i, ev, strings.Join(webhook.Types, ", "))
+			}
+		}
+		cfg.Webhooks = append(cfg.Webhooks, w)
+	}
+	if yc.Webhooks.Timeout != "" {
+		timeout, err := time.ParseDuration(yc.Webhooks.Timeout)
+		if err != nil || timeout <= 0 {
+			return Config{}, fmt.Errorf("invalid webhooks.timeout %q", yc.Webhooks.Timeout)
+		}
+		cfg.WebhookTimeout = timeout
+	}
+	if yc.Webhooks.Retries != nil {
+		if n := *yc.Webhooks.Retries; n < 0 || n > 10 {
+			return Config{}, fmt.Errorf("invalid webhooks.retries %d (0-10)", n)
+		}
+		cfg.WebhookRetries = *yc.Webhooks.Retries
+	}
+
+	// Contacts
+	cfg.ContactNames = yc.Contacts.Names
+	cfg.ContactsFromTelegram = yc.Contacts.Telegram
+	if cfg.RingProfiles, err = parseRingProfiles(yc.Contacts.RingProfiles); err != nil {
+		return Config{}, err
+	}
+	if cfg.FollowMe, err = parseFollowMe(yc.FollowMe, cfg.VoicemailEnabled, cfg.SIPAccounts); err != nil {
+		return Config{}, err
+	}
+
+	// Calendar
+	cfg.CalendarURL = strings.TrimSpace(yc.Calendar.URL)
+	if cfg.CalendarURL != "" && !strings.HasPrefix(cfg.CalendarURL, "http://") && !strings.HasPrefix(cfg.CalendarURL, "https://") {
+		return Config{}, fmt.Errorf("invalid calendar.url %q (want http:// or https://)", cfg.CalendarURL)
+	}
+	if yc.Calendar.Type != "" {
+		switch t := strings.ToLower(yc.Calendar.Type); t {
+		case CalendarICS, CalendarCalDAV:
+			cfg.CalendarType = t
+		default:
+			return Config{}, fmt.Errorf("invalid calendar.type %q (ics or caldav)", yc.Calendar.Type)
+		}
+	}
+	cfg.CalendarUser = yc.Calendar.Username
+	cfg.CalendarPassword = yc.Calendar.Password
+	if yc.Calendar.Refresh != "" {
+		refresh, err := time.ParseDuration(yc.Calendar.Refresh)
+		if err != nil || refresh < time.Minute {
+			return Config{}, fmt.Errorf("invalid calendar.refresh %q (at least 1m)", yc.Calendar.Refresh)
+		}
+		cfg.CalendarRefresh = refresh
+	}
+	if yc.Calendar.Action != "" {
+		switch a := strings.ToLower(yc.Calendar.Action); a {
+		case CalendarVoicemail, CalendarReject:
+			cfg.CalendarAction = a
+		default:
+			return Config{}, fmt.Errorf("invalid calendar.action %q (voicemail or reject)", yc.Calendar.Action)
+		}
+	}
+	if cfg.CalendarURL != "" && cfg.CalendarAction == CalendarVoicemail && !cfg.VoicemailEnabled {
+		return Config{}, errors.New("calendar.action is voicemail, but voicemail.enabled is off")
+	}
+	switch {
+	case yc.Calendar.Message != nil:
+		cfg.CalendarMessage = strings.TrimSpace(*yc.Calendar.Message)
+	case cfg.CalendarAction == CalendarReject:
+		cfg.CalendarMessage = "I'm in a meeting until {until}. Please call back later."
+	}
+
+	// Video clips
+	cfg.VideoClipEnabled = yc.Video.Clip.Enabled
+	if yc.Video.Clip.Length != "" {
+		length, err := time.ParseDuration(yc.Video.Clip.Length)
+		if err != nil || length < time.Second || length > time.Minute {
+			return Config{}, fmt.Errorf("invalid video.clip.length %q (1s to 1m)", yc.Video.Clip.Length)
+		}
+		cfg.VideoClipLength = length
+	}
+	if ffmpeg := strings.TrimSpace(yc.Video.FFmpeg); ffmpeg != "" {
+		cfg.VideoFFmpeg = ffmpeg
+		cfg.VideoClipFFmpeg = ffmpeg
+	}
+	if ffmpeg := strings.TrimSpace(yc.Video.Clip.FFmpeg); ffmpeg != "" {
+		cfg.VideoClipFFmpeg = ffmpeg
+	}
+
+	// Video passthrough
+	cfg.VideoEnabled = yc.Video.Enabled
+	if cfg.VideoEnabled && cfg.VideoClipEnabled {
+		return Config{}, errors.New("video.enabled and video.clip.enabled can't both be on")
+	}
+	if yc.Video.Width != 0 || yc.Video.Height != 0 {
+		if yc.Video.Width < 16 || yc.Video.Width > 1920 || yc.Video.Width%2 != 0 ||
+			yc.Video.Height < 16 || yc.Video.Height > 1920 || yc.Video.Height%2 != 0 {
+			return Config{}, fmt.Errorf("invalid video size %dx%d (even, 16 to 1920)", yc.Video.Width, yc.Video.Height)
+		}
+		cfg.VideoWidth, cfg.VideoHeight = yc.Video.Width, yc.Video.Height
+	}
+	if yc.Video.FPS != 0 {
+		if yc.Video.FPS < 1 || yc.Video.FPS > 30 {
+			return Config{}, fmt.Errorf("invalid video.fps %d (1 to 30)", yc.Video.FPS)
+		}
+		cfg.VideoFPS = yc.Video.FPS
+	}
+
+	// Caller screening
+	for _, list := range [][]string{yc.Callers.Allow, yc.Callers.Deny} {
+		for _, rule := range list {
+			if err := validateCallerRule(rule); err != nil {
+				return Config{}, fmt.Errorf("callers: %w", err)
+			}
+		}
+	}
+	cfg.CallersAllow = yc.Callers.Allow
+	cfg.CallersDeny = yc.Callers.Deny
+	if yc.Callers.RejectStatus != 0 {
+		if _, ok := callerRejectReasons[yc.Callers.RejectStatus]; !ok {
+			return Config{}, fmt.Errorf("callers.reject_status must be 603, 486 or 404, got %d", yc.Callers.RejectStatus)
+		}
+		cfg.CallersRejectStatus = yc.Callers.RejectStatus
+	}
+
+	// Recording
+	cfg.RecordingEnabled = yc.Recording.Enabled
+	if yc.Recording.Dir != "" {
+		cfg.RecordingDir = yc.Recording.Dir
+	}
+	if yc.Recording.Filename != "" {
+		cfg.RecordingTemplate = yc.Recording.Filename
+	}
+	if yc.Recording.Format != "" {
+		cfg.RecordingFormat = strings.ToLower(yc.Recording.Format)
+	}
+	if cfg.RecordingFormat != recording.FormatWAV && cfg.RecordingFormat != recording.FormatOGG {
+		return Config{}, fmt.Errorf("recording.format must be 'wav' or 'ogg', got %q", cfg.RecordingFormat)
+	}
+	if yc.Recording.Mixed != nil {
+		cfg.RecordingMixed = *yc.Recording.Mixed
+	}
+	cfg.RecordingDTMFToggle = strings.TrimSpace(yc.Recording.DTMFToggle)
+	if yc.Recording.PreRoll != "" {
+		preRoll, err := time.ParseDuration(yc.Recording.PreRoll)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid recording.pre_roll: %w", err)
+		}
+		if preRoll < 0 || preRoll > 5*time.Minute {
+			return Config{}, errors.New("recording.pre_roll must be between 0 and 5m")
+		}
+		cfg.RecordingPreRoll = preRoll
+	}
+	cfg.RecordingEncryptionKey = yc.Recording.EncryptionKey
+	cfg.RecordingEncryptionKeyEnv = strings.TrimSpace(yc.Recording.EncryptionKeyEnv)
+	cfg.RecordingAnnouncementFile = strings.TrimSpace(yc.Recording.Announcement)
+	cfg.RecordingCompliance = yc.Recording.Compliance
+
+	// Post-processing
+	cfg.PostProcess = yc.PostProcess.Enabled
+	if yc.PostProcess.TrimSilence != nil {
+		cfg.PostTrimSilence = *yc.PostProcess.TrimSilence
+	}
+	if v := yc.PostProcess.SilenceThresholdDB; v != nil {
+		if *v >= 0 {
+			return Config{}, errors.New("postprocess.silence_threshold_db must be negative (dBFS)")
+		}
+		cfg.PostSilenceThreshold = *v
+	}
+	if v := yc.PostProcess.TargetLUFS; v != nil {
+		if *v > 0 || (*v != 0 && *v < -70) {
+			return Config{}, errors.New("postprocess.target_lufs must be between -70 and 0 (0 disables)")
+		}
+		cfg.PostTargetLUFS = *v
+	}
+	cfg.PostOpus = yc.PostProcess.Opus
+	if cfg.PostProcess && cfg.RecordingFormat != recording.FormatWAV {
+		return Config{}, errors.New("postprocess needs recording.format 'wav' (set postprocess.opus to store Opus)")
+	}
+
+	// Storage
+	if yc.Storage.RetentionDays < 0 || yc.Storage.MaxGB < 0 || yc.Storage.MinFreeGB < 0 || yc.Storage.AlertFreeGB < 0 {
+		return Config{}, errors.New("storage limits must not be negative")
+	}
+	cfg.StorageRetention = time.Duration(yc.Storage.RetentionDays) * 24 * time.Hour
+	cfg.StorageMaxBytes = int64(yc.Storage.MaxGB * gigabyte)
+	cfg.StorageMinFree = uint64(yc.Storage.MinFreeGB * gigabyte)
+	cfg.StorageAlertFree = uint64(yc.Storage.AlertFreeGB * gigabyte)
+	if yc.Storage.CheckInterval != "" {
+		interval, err := time.ParseDuration(yc.Storage.CheckInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid storage.check_interval: %w", err)
+		}
+		if interval < time.Second {
+			return Config{}, errors.New("storage.check_interval must be at least 1s")
+		}
+		cfg.StorageCheckInterval = interval
+	}
+	if yc.Storage.StateFile != nil {
+		cfg.StateFile = strings.TrimSpace(*yc.Storage.StateFile)
+	}
+	if yc.Storage.CDRHistory != nil {
+		if *yc.Storage.CDRHistory < 0 {
+			return Config{}, errors.New("storage.cdr_history must not be negative")
+		}
+		cfg.StateCDRLimit = *yc.Storage.CDRHistory
+	}
+	if cfg.RecordingCompliance {
+		switch {
+		case cfg.RecordingEncryptionKey == "" && cfg.RecordingEncryptionKeyEnv == "":
+			return Config{}, errors.New("recording.compliance requires recording.encryption_key or recording.encryption_key_env")
+		case cfg.StorageRetention == 0:
+			return Config{}, errors.New("recording.compliance requires storage.retention_days")
+		case cfg.RecordingAnnouncementFile == "":
+			return Config{}, errors.New("recording.compliance requires recording.announcement_file")
+		}
+	}
+
+	// Voice chats
+	for i, aj := range yc.VoiceChats.AutoJoin {
+		if aj.ChatID >= 0 {
+			return Config{}, fmt.Errorf("voice_chats.auto_join[%d].chat_id must be a group or channel (negative) id", i)
+		}
+		if len(aj.Numbers) == 0 {
+			return Config{}, fmt.Errorf("voice_chats.auto_join[%d].numbers is required", i)
+		}
+	}
+	cfg.VoiceChatAutoJoin = yc.VoiceChats.AutoJoin
+	for number, chatID := range yc.VoiceChats.Inbound {
+		if chatID >= 0 {
+			return Config{}, fmt.Errorf("voice_chats.inbound %q must be a group or channel (negative) id", number)
+		}
+	}
+	cfg.VoiceChats = yc.VoiceChats.Inbound
+	if yc.VoiceChats.PollInterval != "" {
+		interval, err := time.ParseDuration(yc.VoiceChats.PollInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid voice_chats.poll_interval: %w", err)
+		}
+		if interval < time.Second {
+			return Config{}, errors.New("voice_chats.poll_interval must be at least 1s")
+		}
+		cfg.VoiceChatPollInterval = interval
+	}
+
+	// Export
+	if yc.Export.Interval != "" {
+		interval, err := time.ParseDuration(yc.Export.Interval)
+		if err != nil || interval <= 0 {
+			return Config{}, fmt.Errorf("invalid export.interval %q", yc.Export.Interval)
+		}
+		cfg.ExportInterval = interval
+	}
+	if yc.Export.FlushInterval != "" {
+		interval, err := time.ParseDuration(yc.Export.FlushInterval)
+		if err != nil || interval <= 0 {
+			return Config{}, fmt.Errorf("invalid export.flush_interval %q", yc.Export.FlushInterval)
+		}
+		cfg.ExportFlushInterval = interval
+	}
+	cfg.ExportBatchSize = yc.Export.BatchSize
+	cfg.ExportInfluxURL = strings.TrimSpace(yc.Export.Influx.URL)
+	cfg.ExportInfluxToken = yc.Export.Influx.Token
+	cfg.ExportInfluxOrg = yc.Export.Influx.Org
+	cfg.ExportInfluxBucket = yc.Export.Influx.Bucket
+	cfg.ExportInfluxDatabase = yc.Export.Influx.Database
+	if cfg.ExportInfluxURL != "" && cfg.ExportInfluxBucket == "" && cfg.ExportInfluxDatabase == "" {
+		return Config{}, errors.New("export.influx needs a bucket (InfluxDB 2.x) or database (1.x)")
+	}
+	if yc.Export.Timescale.Driver != "" {
+		cfg.ExportSQLDriver = yc.Export.Timescale.Driver
+	}
+	cfg.ExportSQLDSN = yc.Export.Timescale.DSN
+
 	return cfg, nil
 }
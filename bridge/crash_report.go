@@ -0,0 +1,136 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"gotgcalls/bridge/logring"
+	"gotgcalls/third_party/ntgcalls"
+)
+
+// CrashLogLines is the number of log lines kept for crash reports.
+const CrashLogLines = 200
+
+// CrashReport is the snapshot written to debug.crash_reports.dir when a call
+// goroutine panics or a media pipeline fails.
+type CrashReport struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	// CallID is the call that failed, if known.
+	CallID string `json:"call_id,omitempty"`
+	// Stack is the stack of the goroutine that panicked.
+	Stack           string      `json:"stack,omitempty"`
+	GoVersion       string      `json:"go_version"`
+	NTgCallsVersion string      `json:"ntgcalls_version"`
+	Uptime          string      `json:"uptime"`
+	Calls           []CrashCall `json:"calls"`
+	// Log holds the last CrashLogLines lines of the log.
+	Log           []string `json:"log"`
+	Goroutines    int      `json:"goroutines"`
+	GoroutineDump string   `json:"goroutine_dump"`
+}
+
+// CrashCall is the state of an active call in a crash report, with the
+// buffer depths and counters of its media bridge.
+type CrashCall struct {
+	CallInfo
+	Media *MediaStats `json:"media,omitempty"`
+}
+
+// SetLogRing gives the service the last lines of the log to include in
+// crash reports. Must be called before Start.
+func (s *Service) SetLogRing(r *logring.Ring) {
+	s.logRing = r
+}
+
+// recoverCrash, deferred at the top of a call goroutine, writes a crash
+// report for a panic and panics on, so the process still crashes.
+func (s *Service) recoverCrash(what string) {
+	if v := recover(); v != nil {
+		s.crashReport(fmt.Sprintf("panic in %s: %v", what, v), "", debug.Stack())
+		panic(v)
+	}
+}
+
+// attachCrashReports reports panics and pipeline failures of the media
+// bridge of call.
+func (s *Service) attachCrashReports(bridge *MediaBridge, call *Call) {
+	bridge.OnFault(func(reason string, stack []byte) {
+		s.crashReport(reason, call.ID, stack)
+	})
+}
+
+// crashReport writes a crash report and, with debug.crash_reports.notify,
+// tells the Telegram user where it is.
+func (s *Service) crashReport(reason, callID string, stack []byte) {
+	if !s.cfg.CrashReportsEnabled {
+		return
+	}
+	s.crashMu.Lock()
+	defer s.crashMu.Unlock()
+	report := CrashReport{
+		Time:            time.Now(),
+		Reason:          reason,
+		CallID:          callID,
+		Stack:           string(stack),
+		GoVersion:       runtime.Version(),
+		NTgCallsVersion: ntgcalls.Version(),
+		Uptime:          time.Since(s.startedAt).Round(time.Second).String(),
+		Goroutines:      runtime.NumGoroutine(),
+		GoroutineDump:   goroutineDump(),
+	}
+	for _, info := range s.Calls() {
+		cc := CrashCall{CallInfo: info}
+		if call, ok := s.Call(info.ID); ok {
+			if ms, ok := call.Stats(); ok {
+				cc.Media = &ms
+			}
+		}
+		report.Calls = append(report.Calls, cc)
+	}
+	if s.logRing != nil {
+		report.Log = s.logRing.Lines()
+	}
+	path, err := writeCrashReport(s.cfg.CrashReportsDir, report)
+	if err != nil {
+		s.logger.Error("crash report write failed", "reason", reason, "error", err)
+		return
+	}
+	s.logger.Error("crash report written", "file", path, "reason", reason)
+	if s.cfg.CrashReportsNotify {
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Bridge crash: %s\nReport: %s", reason, path))
+	}
+}
+
+func writeCrashReport(dir string, report CrashReport) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return "", err
+	}
+	name := "crash_" + report.Time.Format("20060102_150405.000")
+	if report.CallID != "" {
+		name += "_" + report.CallID
+	}
+	path := filepath.Join(dir, name+".json")
+	return path, os.WriteFile(path, append(data, '\n'), 0o640)
+}
+
+// goroutineDump returns the stacks of all goroutines.
+func goroutineDump() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 16<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
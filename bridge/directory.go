@@ -0,0 +1,189 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"gotgcalls/bridge/audiofile"
+)
+
+const (
+	// directoryRetries is how often the caller may try again after a name
+	// that matches nobody.
+	directoryRetries = 2
+	// directoryChoices bounds the matches read out for the caller to pick.
+	directoryChoices = 9
+)
+
+// DirectoryEntry is a Telegram user SIP callers can reach by name.
+type DirectoryEntry struct {
+	Name   string `yaml:"name"`
+	UserID int64  `yaml:"user_id"`
+	// Keys selects the entry instead of the keypad spelling of the name,
+	// for names that are not in Latin letters.
+	Keys string `yaml:"keys"`
+	// Prompt is a recording of the name; without it the name is spoken with
+	// tts.command.
+	Prompt string `yaml:"prompt"`
+}
+
+// keypadLetters maps letters to the phone keys they are printed on.
+var keypadLetters = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for _, group := range strings.Fields("2abc 3def 4ghi 5jkl 6mno 7pqrs 8tuv 9wxyz") {
+		for _, r := range group[1:] {
+			m[r] = rune(group[0])
+		}
+	}
+	return m
+}()
+
+// keypadWords returns the digits that spell each word of name (or keys).
+func keypadWords(name, keys string) []string {
+	if keys != "" {
+		return []string{keys}
+	}
+	var words []string
+	for _, word := range strings.Fields(name) {
+		var b strings.Builder
+		for _, r := range strings.ToLower(word) {
+			switch {
+			case unicode.IsDigit(r):
+				b.WriteRune(r)
+			case keypadLetters[r] != 0:
+				b.WriteRune(keypadLetters[r])
+			}
+		}
+		if b.Len() > 0 {
+			words = append(words, b.String())
+		}
+	}
+	return words
+}
+
+// directoryMatches returns the entries with a name word that starts with
+// the keypad digits entered.
+func directoryMatches(entries []DirectoryEntry, entered string) []DirectoryEntry {
+	if entered == "" {
+		return nil
+	}
+	var matches []DirectoryEntry
+	for _, e := range entries {
+		for _, word := range keypadWords(e.Name, e.Keys) {
+			if strings.HasPrefix(word, entered) {
+				matches = append(matches, e)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// loadDirectory loads or synthesizes the spoken name of every directory
+// entry.
+func (s *Service) loadDirectory() error {
+	if len(s.cfg.Directory) == 0 {
+		return nil
+	}
+	if s.cfg.DirectoryPrompt != "" {
+		clip, err := audiofile.Load(s.cfg.DirectoryPrompt, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("directory.prompt: %w", err)
+		}
+		s.directoryPrompt = clip
+	}
+	s.directoryNames = make(map[int64]*audiofile.Clip, len(s.cfg.Directory))
+	for _, e := range s.cfg.Directory {
+		var (
+			clip *audiofile.Clip
+			err  error
+		)
+		if e.Prompt != "" {
+			clip, err = audiofile.Load(e.Prompt, s.cfg.SampleRate)
+		} else {
+			clip, err = s.synthesize(context.Background(), e.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("directory entry %q: %w", e.Name, err)
+		}
+		s.directoryNames[e.UserID] = clip
+	}
+	return nil
+}
+
+// ivrDirectory asks the caller to spell a name and returns the chosen entry.
+// found is false when the caller failed to pick anyone; ok is false when
+// ctx ended.
+func (s *Service) ivrDirectory(ctx context.Context, bridge *MediaBridge, digits <-chan rune, logger *slog.Logger) (entry DirectoryEntry, found, ok bool) {
+	for attempt := 0; attempt <= directoryRetries; attempt++ {
+		if attempt > 0 && !s.ivrPlay(ctx, bridge, s.cfg.IVRInvalidPrompt) {
+			return DirectoryEntry{}, false, false
+		}
+		drainDigits(digits)
+		wait := s.cfg.IVRTimeout
+		if s.directoryPrompt != nil {
+			bridge.PlaySIP(s.directoryPrompt.Once())
+			wait += clipLength(s.directoryPrompt)
+		}
+		entered, err := s.ivrInput(ctx, bridge, digits, wait)
+		if err != nil {
+			return DirectoryEntry{}, false, false
+		}
+		matches := directoryMatches(s.cfg.Directory, entered)
+		logger.Info("ivr: directory lookup", "keys", entered, "matches", len(matches))
+		switch {
+		case len(matches) == 1:
+			entry = matches[0]
+		case len(matches) > 1:
+			entry, found, ok = s.ivrDirectoryChoice(ctx, bridge, digits, matches[:min(len(matches), directoryChoices)])
+			if !ok {
+				return DirectoryEntry{}, false, false
+			}
+			if !found {
+				continue
+			}
+		default:
+			continue
+		}
+		// Confirm the name before ringing.
+		if !s.ivrPlayClip(ctx, bridge, s.directoryNames[entry.UserID]) {
+			return DirectoryEntry{}, false, false
+		}
+		return entry, true, true
+	}
+	return DirectoryEntry{}, false, true
+}
+
+// ivrDirectoryChoice reads out matches ("press 1 for ...", or the recorded
+// names in order without tts.command) and lets the caller press a number.
+func (s *Service) ivrDirectoryChoice(ctx context.Context, bridge *MediaBridge, digits <-chan rune, matches []DirectoryEntry) (entry DirectoryEntry, found, ok bool) {
+	var text strings.Builder
+	for i, e := range matches {
+		fmt.Fprintf(&text, "Press %d for %s. ", i+1, e.Name)
+	}
+	clip, err := s.synthesize(ctx, text.String())
+	if err == nil {
+		ok = s.ivrPlayClip(ctx, bridge, clip)
+	} else {
+		ok = true
+		for _, e := range matches {
+			if ok = s.ivrPlayClip(ctx, bridge, s.directoryNames[e.UserID]); !ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return DirectoryEntry{}, false, false
+	}
+	entered, err := s.ivrInput(ctx, bridge, digits, s.cfg.IVRTimeout)
+	if err != nil {
+		return DirectoryEntry{}, false, false
+	}
+	if len(entered) == 1 && entered[0] >= '1' && int(entered[0]-'0') <= len(matches) {
+		return matches[entered[0]-'1'], true, true
+	}
+	return DirectoryEntry{}, false, true
+}
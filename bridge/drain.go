@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// ErrDraining is returned for new calls once shutdown has begun.
+var ErrDraining = errors.New("bridge is shutting down")
+
+// drainPollInterval is how often Drain checks whether calls have ended.
+const drainPollInterval = 250 * time.Millisecond
+
+// drainHangupWait bounds how long Drain waits for calls to finish tearing
+// down (BYE sent, Telegram leg stopped) after hanging them up.
+const drainHangupWait = 5 * time.Second
+
+// Drain stops accepting new SIP and Telegram calls and waits for the active
+// ones to end. Calls still up after timeout, or when ctx is done, are hung
+// up. The registration is removed last. The SIP server must keep running
+// until Drain returns so BYEs go out.
+func (s *Service) Drain(ctx context.Context, timeout time.Duration) {
+	s.draining.Store(true)
+	defer s.unregister()
+	defer s.closePlugins()
+	if n := s.activeCalls.Load(); n > 0 {
+		s.logger.Info("shutdown: draining active calls", "active_calls", n, "timeout", timeout)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if s.waitIdle(waitCtx) {
+		return
+	}
+
+	s.logger.Info("shutdown: hanging up remaining calls", "active_calls", s.activeCalls.Load())
+	if !s.hangupAll(cdr.CauseShuttingDown) {
+		s.logger.Warn("shutdown: calls still active after hangup", "active_calls", s.activeCalls.Load())
+	}
+}
+
+// hangupAll hangs up every call with cause and waits up to drainHangupWait
+// for them to end; it reports false if some are still up.
+func (s *Service) hangupAll(cause string) bool {
+	s.mu.Lock()
+	calls := make([]*Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		calls = append(calls, c)
+	}
+	s.mu.Unlock()
+	for _, c := range calls {
+		c.setCause(cause)
+		c.Hangup()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainHangupWait)
+	defer cancel()
+	return s.waitIdle(ctx)
+}
+
+// Draining reports whether shutdown has begun.
+func (s *Service) Draining() bool {
+	return s.draining.Load()
+}
+
+// waitIdle waits until no calls are active; it reports false if ctx ended
+// first.
+func (s *Service) waitIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.activeCalls.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
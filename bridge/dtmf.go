@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/livekit/media-sdk/dtmf"
+	msdkrtp "github.com/livekit/media-sdk/rtp"
+)
+
+// ErrDTMFDisabled is returned when sip.dtmf_enabled is off.
+var ErrDTMFDisabled = errors.New("dtmf is disabled")
+
+// dtmfHistory is how many recent digits are kept for command matching.
+const dtmfHistory = 16
+
+// SendDTMF plays digits toward the SIP party of call. Valid digits are
+// 0-9, *, #, A-D; "w" inserts a half-second pause.
+func (s *Service) SendDTMF(call *Call, digits string) error {
+	if !s.cfg.EnableDTMF {
+		return ErrDTMFDisabled
+	}
+	digits = strings.ToUpper(strings.ReplaceAll(digits, " ", ""))
+	if digits == "" {
+		return errors.New("no digits")
+	}
+	for _, r := range digits {
+		if !strings.ContainsRune("0123456789*#ABCDW", r) {
+			return fmt.Errorf("invalid dtmf digit %q", r)
+		}
+	}
+	media := call.mediaBridge()
+	if media == nil {
+		return ErrNotBridged
+	}
+	// media-sdk uses a lowercase "w" for pauses.
+	return media.SendDTMF(strings.ReplaceAll(digits, "W", "w"))
+}
+
+// handleDTMFDigit matches received digits against DTMF commands: the
+// recording toggle sequence and, in group calls, "*N#" speaker selection.
+func (s *Service) handleDTMFDigit(call *Call, digit rune, logger *slog.Logger) {
+	call.mu.Lock()
+	call.dtmf += string(digit)
+	if len(call.dtmf) > dtmfHistory {
+		call.dtmf = call.dtmf[len(call.dtmf)-dtmfHistory:]
+	}
+	digits := call.dtmf
+	call.mu.Unlock()
+
+	if seq := s.cfg.RecordingDTMFToggle; seq != "" && strings.HasSuffix(digits, seq) {
+		call.resetDTMF()
+		logger.Info("dtmf: recording toggle")
+		s.toggleRecording(call, logger)
+		return
+	}
+	if index, ok := listenSelection(digits); ok && call.ChatID < 0 {
+		call.resetDTMF()
+		p, err := s.ListenToIndex(call.ChatID, index)
+		switch {
+		case err != nil:
+			logger.Warn("dtmf: speaker selection failed", "index", index, "error", err)
+		case p == nil:
+			logger.Info("dtmf: listening to everyone")
+		default:
+			logger.Info("dtmf: listening to participant", "participant", p.ID)
+		}
+	}
+}
+
+const (
+	// dtmfEventDur is how long each digit is sent for, and the pause after
+	// it; dtmfPauseDur is the pause for "w". Both match media-sdk.
+	dtmfEventDur = 250 * time.Millisecond
+	dtmfPauseDur = 500 * time.Millisecond
+	dtmfVolume   = 10
+)
+
+// writeTelephoneEvents sends digits as RFC 4733 events on events, a stream
+// at clockRate starting at startTs. It paces like media-sdk's dtmf.Write,
+// which assumes the 8000 Hz clock, but counts durations and timestamps in
+// the clock the telephone-event format was negotiated at.
+func writeTelephoneEvents(ctx context.Context, events *msdkrtp.Stream, clockRate int, startTs uint32, digits string) error {
+	const step = msdkrtp.DefFrameDur
+	ticks := func(d time.Duration) uint32 {
+		return uint32(int64(d) * int64(clockRate) / int64(time.Second))
+	}
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	tick := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		}
+	}
+	wait := func(d time.Duration) error {
+		for range (d + step - 1) / step {
+			if err := tick(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	events.ResetTimestamp(startTs)
+	var buf [4]byte
+	for i := 0; i < len(digits); i++ {
+		if digits[i] == 'w' {
+			events.Delay(ticks(dtmfPauseDur))
+			if err := wait(dtmfPauseDur); err != nil {
+				return err
+			}
+			continue
+		}
+		code, _ := dtmf.Tone(digits[i])
+		for sent := step; ; sent += step {
+			if err := tick(); err != nil {
+				return err
+			}
+			end := sent >= dtmfEventDur
+			n, err := dtmf.Encode(buf[:], dtmf.Event{Code: code, Volume: dtmfVolume, Dur: uint16(ticks(sent)), End: end})
+			if err != nil {
+				return err
+			}
+			// Every packet of a digit carries its start timestamp; the end
+			// packet is sent three times (RFC 4733 2.5.1.4).
+			repeat := 1
+			if end {
+				repeat = 3
+			}
+			for range repeat {
+				if err := events.WritePayloadAtCurrent(buf[:n], sent == step); err != nil {
+					return err
+				}
+			}
+			if end {
+				break
+			}
+		}
+		events.Delay(ticks(2 * dtmfEventDur))
+		if err := wait(dtmfEventDur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/livekit/media-sdk/dtmf"
+	msdkrtp "github.com/livekit/media-sdk/rtp"
+)
+
+func TestWriteTelephoneEvents(t *testing.T) {
+	const startTs = 1000
+	for _, clockRate := range []int{8000, 48000} {
+		t.Run(fmt.Sprint(clockRate), func(t *testing.T) {
+			var buf msdkrtp.Buffer
+			events := msdkrtp.NewSeqWriter(&buf).NewStream(101, clockRate)
+			if err := writeTelephoneEvents(context.Background(), events, clockRate, startTs, "5"); err != nil {
+				t.Fatal(err)
+			}
+			// 13 packets of 20 ms, the end one sent three times.
+			if len(buf) != 15 {
+				t.Fatalf("sent %d packets, want 15", len(buf))
+			}
+			for i, pkt := range buf {
+				ev, err := dtmf.Decode(pkt.Payload)
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				if pkt.Timestamp != startTs || ev.Digit != '5' || pkt.Marker != (i == 0) || ev.End != (i >= 12) {
+					t.Errorf("packet %d: ts %d marker %v %+v", i, pkt.Timestamp, pkt.Marker, ev)
+				}
+			}
+			// The duration is in the clock of the negotiated format.
+			last, _ := dtmf.Decode(buf[len(buf)-1].Payload)
+			if want := uint16(clockRate * 260 / 1000); last.Dur != want {
+				t.Errorf("end duration %d, want %d", last.Dur, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/emiago/diago"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/probe"
+)
+
+// EchoTarget is the /call number that rings the Telegram user with an echo
+// of their own audio instead of dialing out.
+const EchoTarget = "echo"
+
+const (
+	// echoProbeInterval is how often the echo call probes the round trip.
+	echoProbeInterval = 3 * time.Second
+	// echoMaxLength bounds an echo call.
+	echoMaxLength = 10 * time.Minute
+)
+
+// delayLine gives back each frame pushed into it a fixed time later.
+type delayLine struct {
+	frames [][]byte
+	pos    int
+	out    []byte
+}
+
+func newDelayLine(delay, frameDur time.Duration, frameBytes int) *delayLine {
+	frames := make([][]byte, int(delay/frameDur))
+	for i := range frames {
+		frames[i] = make([]byte, frameBytes)
+	}
+	return &delayLine{frames: frames}
+}
+
+// push stores frame and returns the one pushed delay ago (silence at
+// first). The result is only valid until the next push.
+func (d *delayLine) push(frame []byte) []byte {
+	if len(d.frames) == 0 {
+		d.out = append(d.out[:0], frame...)
+		return d.out
+	}
+	old := d.frames[d.pos]
+	d.out = append(d.out[:0], old...)
+	d.frames[d.pos] = append(old[:0], frame...)
+	d.pos = (d.pos + 1) % len(d.frames)
+	return d.out
+}
+
+// isEchoExtension reports whether an inbound call to user is for the echo
+// test extension.
+func (s *Service) isEchoExtension(user string) bool {
+	return s.cfg.EchoExtension != "" && user == s.cfg.EchoExtension
+}
+
+// runSIPEcho answers an inbound call to echo.extension and plays the
+// caller's audio back to them after echo.delay. Once the latency probe has
+// measured the round trip it is spoken (with tts.command).
+func (s *Service) runSIPEcho(dialog *diago.DialogServerSession, call *Call, answer diago.AnswerOptions, logger *slog.Logger) {
+	if err := dialog.AnswerOptions(answer); err != nil {
+		logger.Warn("echo: answer failed", "error", err)
+		call.setCause(cdr.CauseSIPFailure)
+		return
+	}
+	s.setCallState(call, CallAnswered)
+	call.setSIPDialog(dialog)
+
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		logger.Warn("echo: sip media setup failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		hangupDialog(dialog, logger)
+		return
+	}
+	defer sipMedia.Close()
+	call.setCodec(sipMedia.Codec.Name)
+
+	format := s.tgFormat()
+	delay := newDelayLine(s.cfg.EchoDelay, format.FrameDur, format.FrameBytes())
+	var port *localPort
+	port = newLocalPort(format, func(frame []byte) error {
+		port.speak(append([]byte(nil), delay.push(frame)...))
+		return nil
+	})
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, port, tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		logger.Warn("echo: bridge init failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		hangupDialog(dialog, logger)
+		return
+	}
+	// The chirp comes back when the caller's handset echoes what it plays.
+	prober := probe.New(format.SampleRate, probe.Voiceband, echoProbeInterval)
+	bridge.SetLatencyProbes(prober, nil)
+	if s.echoGreeting != nil {
+		bridge.PlaySIP(s.echoGreeting.Once())
+	}
+	bridge.Start()
+	defer bridge.Stop()
+	call.setMedia(bridge)
+	logger.Info("echo: call started", "delay", s.cfg.EchoDelay, "codec", sipMedia.Codec.Name)
+
+	announced := false
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timer := time.NewTimer(echoMaxLength)
+	defer timer.Stop()
+	for {
+		select {
+		case <-dialog.Context().Done():
+			call.setCause(cdr.CauseSIPHangup)
+			return
+		case <-call.Done():
+			call.setCause(cdr.CauseLocalHangup)
+			hangupDialog(dialog, logger)
+			return
+		case <-timer.C:
+			call.setCause(cdr.CauseLocalHangup)
+			hangupDialog(dialog, logger)
+			return
+		case <-ticker.C:
+			rtt, ok := prober.RoundTrip()
+			if !ok || announced {
+				continue
+			}
+			announced = true
+			logger.Info("echo: round trip measured", "rtt", rtt)
+			go func() {
+				if clip := s.speakRoundTrip(call.ctx, rtt, logger); clip != nil {
+					bridge.PlaySIP(clip.Once())
+				}
+			}()
+		}
+	}
+}
+
+// runTelegramEcho rings the Telegram user and plays their audio back to them
+// after echo.delay; /call echo uses it to check the Telegram side alone. The
+// measured round trip is spoken (with tts.command) and sent as a message
+// when the call ends.
+func (s *Service) runTelegramEcho(ctx context.Context) error {
+	if s.draining.Load() {
+		return ErrDraining
+	}
+	chatID := s.cfg.TGUserID
+	logger := s.logger.With("tg_chat_id", chatID, "dial", EchoTarget)
+	if !s.allowCall(logger) {
+		return ErrCallLimit
+	}
+	defer s.activeCalls.Add(-1)
+	call := newCall(CallOutbound, EchoTarget, chatID)
+	s.registerCall(call)
+	defer s.unregisterCall(call)
+	logger = logger.With("bridge_call_id", call.ID)
+
+	setupCtx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
+	defer cancel()
+	stopAbort := context.AfterFunc(call.ctx, cancel)
+	defer stopAbort()
+	leg, err := s.openTGLeg(setupCtx, chatID, false)
+	if err != nil {
+		logger.Warn("echo: tg setup failed", "error", err)
+		call.setCause(tgFailureCause(err))
+		return err
+	}
+	defer leg.Close()
+	s.setCallState(call, CallAnswered)
+
+	format := leg.Format()
+	delay := newDelayLine(s.cfg.EchoDelay, format.FrameDur, format.FrameBytes())
+	prober := probe.New(format.SampleRate, probe.BandFor(format.SampleRate), echoProbeInterval)
+	silence := make([]byte, format.FrameBytes())
+	var (
+		speech    = make(chan *audiofile.Clip, 1)
+		playing   *audiofile.Once
+		announced bool
+		rtt       time.Duration
+		measured  bool
+	)
+	logger.Info("echo: telegram call started", "delay", s.cfg.EchoDelay)
+
+	ticker := time.NewTicker(format.FrameDur)
+	defer ticker.Stop()
+	timer := time.NewTimer(echoMaxLength)
+	defer timer.Stop()
+loop:
+	for {
+		select {
+		case <-leg.Done():
+			call.setCause(cdr.CauseTelegramHangup)
+			break loop
+		case <-call.Done():
+			call.setCause(cdr.CauseLocalHangup)
+			break loop
+		case <-ctx.Done():
+			call.setCause(cdr.CauseLocalHangup)
+			break loop
+		case <-timer.C:
+			call.setCause(cdr.CauseLocalHangup)
+			break loop
+		case clip := <-speech:
+			playing = clip.Once()
+		case <-ticker.C:
+			now := time.Now()
+			frame := popFrame(leg.SpeakerFrames(), silence)
+			prober.Feed(frame, now)
+			out := delay.push(frame)
+			if playing != nil && !playing.MixFrame(out) {
+				playing = nil
+			}
+			prober.Mix(out, now)
+			if err := leg.SendPCMFrame10ms(out); err != nil {
+				logger.Warn("echo: tg send failed", "error", err)
+				call.setCause(cdr.CauseTelegramHangup)
+				break loop
+			}
+			if !announced {
+				if rtt, measured = prober.RoundTrip(); measured {
+					announced = true
+					logger.Info("echo: round trip measured", "rtt", rtt)
+					go func(rtt time.Duration) {
+						if clip := s.speakRoundTrip(call.ctx, rtt, logger); clip != nil {
+							speech <- clip
+						}
+					}(rtt)
+				}
+			}
+		}
+	}
+
+	if measured {
+		s.notify(chatID, fmt.Sprintf("Echo test: round trip %s (delay %s added)", rtt.Round(time.Millisecond), s.cfg.EchoDelay))
+	} else {
+		s.notify(chatID, "Echo test: round trip not measured (the probe did not come back; Telegram's echo cancellation may remove it)")
+	}
+	return nil
+}
+
+// speakRoundTrip synthesizes the round trip announcement; nil without
+// tts.command.
+func (s *Service) speakRoundTrip(ctx context.Context, rtt time.Duration, logger *slog.Logger) *audiofile.Clip {
+	clip, err := s.synthesize(ctx, fmt.Sprintf("Round trip %d milliseconds.", rtt.Milliseconds()))
+	if err != nil {
+		if !errors.Is(err, ErrNoTTS) {
+			logger.Warn("echo: round trip announcement failed", "error", err)
+		}
+		return nil
+	}
+	return clip
+}
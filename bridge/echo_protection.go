@@ -0,0 +1,13 @@
+package bridge
+
+import "gotgcalls/bridge/pcm"
+
+// attachEchoProtection adds the echo guard to bridge when
+// audio.echo_protection is on.
+func (s *Service) attachEchoProtection(bridge *MediaBridge) {
+	if !s.cfg.EchoProtection {
+		return
+	}
+	format := s.tgFormat()
+	bridge.SetEchoGuard(pcm.NewEchoGuard(format.SampleRate, format.Channels, s.cfg.EchoThreshold, s.cfg.EchoAttenuationDB))
+}
@@ -0,0 +1,273 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gotgcalls/bridge/pcm"
+)
+
+var ErrMixerClosed = errors.New("conference mixer closed")
+
+const (
+	// mixerTGFrames bounds the Telegram audio queued ahead of the mixer
+	// clock; older frames are dropped.
+	mixerTGFrames = 4
+	// UnityGain is the gain (in percent) that leaves a source unchanged.
+	UnityGain = 100
+)
+
+// Mixer is a conference of several SIP calls on one Telegram side (a
+// private call, or a leg of a voice chat). Telegram hears every caller;
+// each caller hears Telegram and the other callers, but not themselves.
+// Every source has its own gain.
+type Mixer struct {
+	tg      TgPort
+	format  pcm.AudioFormat
+	onClose func()
+	tgGain  atomic.Int32
+	// tgFrames holds the Telegram audio read ahead of the mixer clock.
+	tgFrames chan []byte
+
+	mu        sync.Mutex
+	legs      []*MixerLeg
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// MixerLeg is one SIP call in a Mixer; it is the Telegram side of that
+// call's media bridge.
+type MixerLeg struct {
+	m         *Mixer
+	gain      atomic.Int32
+	mic       chan []byte
+	frames    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMixer starts mixing calls onto tg. onClose (if set) runs once the
+// mixer is closed: after its last leg left, or when tg ended.
+func NewMixer(tg TgPort, onClose func()) *Mixer {
+	m := &Mixer{
+		tg:       tg,
+		format:   tg.Format(),
+		onClose:  onClose,
+		tgFrames: make(chan []byte, mixerTGFrames),
+		done:     make(chan struct{}),
+	}
+	m.tgGain.Store(UnityGain)
+	go m.readTG()
+	go m.run()
+	return m
+}
+
+// AddLeg attaches a new call to the conference at unity gain.
+func (m *Mixer) AddLeg() (*MixerLeg, error) {
+	leg := &MixerLeg{
+		m:      m,
+		mic:    make(chan []byte, legMicFrames),
+		frames: make(chan []byte, cap(m.tgFrames)*2),
+		done:   make(chan struct{}),
+	}
+	leg.gain.Store(UnityGain)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.done:
+		return nil, ErrMixerClosed
+	default:
+	}
+	m.legs = append(m.legs, leg)
+	return leg, nil
+}
+
+// Legs returns the number of attached calls.
+func (m *Mixer) Legs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.legs)
+}
+
+// SetTGGain sets how loud Telegram is mixed for the callers, in percent.
+func (m *Mixer) SetTGGain(percent int) {
+	m.tgGain.Store(int32(percent))
+}
+
+// TGGain returns the gain of Telegram in percent.
+func (m *Mixer) TGGain() int {
+	return int(m.tgGain.Load())
+}
+
+// Done is closed once the mixer is closed.
+func (m *Mixer) Done() <-chan struct{} {
+	return m.done
+}
+
+// Close ends the conference: every leg ends and onClose runs.
+func (m *Mixer) Close() {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		close(m.done)
+		legs := m.legs
+		m.legs = nil
+		m.mu.Unlock()
+		for _, leg := range legs {
+			leg.closeLocal()
+		}
+		if m.onClose != nil {
+			m.onClose()
+		}
+	})
+}
+
+func (m *Mixer) snapshotLegs() []*MixerLeg {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.legs)
+}
+
+func (m *Mixer) removeLeg(leg *MixerLeg) (last bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.legs = slices.DeleteFunc(m.legs, func(l *MixerLeg) bool { return l == leg })
+	return len(m.legs) == 0
+}
+
+// readTG keeps the Telegram speaker stream flowing, so the Telegram side
+// never blocks on the mixer; the newest mixerTGFrames frames are kept.
+func (m *Mixer) readTG() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.tg.Done():
+			m.Close()
+			return
+		case frame := <-m.tg.SpeakerFrames():
+			queueNewest(m.tgFrames, frame)
+		}
+	}
+}
+
+// run mixes one frame of every source per frame period: the callers for
+// Telegram, and for each caller everything but its own audio.
+func (m *Mixer) run() {
+	frameBytes := m.format.FrameBytes()
+	ticker := time.NewTicker(m.format.FrameDur)
+	defer ticker.Stop()
+	bus := pcm.NewMixBus(frameBytes)
+	// Source 0 is Telegram, source i+1 is legs[i].
+	toTG := make([]byte, frameBytes)
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		legs := m.snapshotLegs()
+		bus.Reset()
+		var tgFrame []byte
+		select {
+		case tgFrame = <-m.tgFrames:
+		default:
+		}
+		bus.Add(0, tgFrame, int(m.tgGain.Load()))
+		for i, leg := range legs {
+			var frame []byte
+			select {
+			case frame = <-leg.mic:
+			default:
+			}
+			bus.Add(i+1, frame, int(leg.gain.Load()))
+		}
+		for i, leg := range legs {
+			out := make([]byte, frameBytes)
+			bus.Mix(out, i+1)
+			leg.pushSpeaker(out)
+		}
+		// Telegram hears the callers only: the mix minus itself.
+		bus.Mix(toTG, 0)
+		if err := m.tg.SendPCMFrame10ms(toTG); err != nil {
+			slog.Warn("conference mixer send failed", "error", err)
+		}
+	}
+}
+
+// SetGain sets how loud this call is mixed for the others, in percent.
+func (l *MixerLeg) SetGain(percent int) {
+	l.gain.Store(int32(percent))
+}
+
+// Gain returns the gain of this call in percent.
+func (l *MixerLeg) Gain() int {
+	return int(l.gain.Load())
+}
+
+// Mixer returns the conference the leg belongs to.
+func (l *MixerLeg) Mixer() *Mixer {
+	return l.m
+}
+
+func (l *MixerLeg) Format() pcm.AudioFormat {
+	return l.m.format
+}
+
+func (l *MixerLeg) SpeakerFrames() <-chan []byte {
+	return l.frames
+}
+
+func (l *MixerLeg) Done() <-chan struct{} {
+	return l.done
+}
+
+// SendPCMFrame10ms queues a frame for the mixer, dropping the oldest queued
+// frame if the leg runs ahead.
+func (l *MixerLeg) SendPCMFrame10ms(pcmFrame []byte) error {
+	select {
+	case <-l.done:
+		return ErrMixerClosed
+	default:
+	}
+	queueNewest(l.mic, append([]byte(nil), pcmFrame...))
+	return nil
+}
+
+func (l *MixerLeg) pushSpeaker(frame []byte) {
+	select {
+	case l.frames <- frame:
+	default:
+		// The bridge drains its backlog itself; don't stall other legs.
+	}
+}
+
+func (l *MixerLeg) closeLocal() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// Close detaches the leg. The mixer closes once its last leg left.
+func (l *MixerLeg) Close() {
+	l.closeLocal()
+	if l.m.removeLeg(l) {
+		l.m.Close()
+	}
+}
+
+// queueNewest queues frame, dropping the oldest queued frames to make room.
+func queueNewest(queue chan []byte, frame []byte) {
+	for {
+		select {
+		case queue <- frame:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+		default:
+		}
+	}
+}
@@ -8,9 +8,11 @@ import (
 
 	"github.com/emiago/diago"
 	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
 	msdk "github.com/livekit/media-sdk"
 	msdkrtp "github.com/livekit/media-sdk/rtp"
 	msdksdp "github.com/livekit/media-sdk/sdp"
+	"github.com/pion/rtp"
 
 	"gotgcalls/bridge/pcm"
 )
@@ -32,6 +34,8 @@ type SipEndpoint struct {
 	// RTP IO (diago).
 	rtpReader media.RTPReader
 	rtpWriter media.RTPWriter
+	// dialogMedia holds the RTP session, which diago replaces on re-INVITE.
+	dialogMedia *diago.DialogMedia
 
 	// SampleRate is the decoded PCM sample rate for the codec (e.g. 16000 for G722, 8000 for G711, 48000 for Opus).
 	SampleRate int
@@ -40,13 +44,35 @@ type SipEndpoint struct {
 	// Channels is number of interleaved PCM channels in the codec stream (e.g. 2 for Opus stereo).
 	Channels int
 
-	FrameDur     time.Duration
-	EnableJitter bool
+	FrameDur time.Duration
+	// JitterMin and JitterMax bound the adaptive jitter buffer's delay;
+	// JitterMax 0 disables it.
+	JitterMin time.Duration
+	JitterMax time.Duration
+	// FEC and PLC enable in-band FEC and packet loss concealment on codecs
+	// that support them (Opus).
+	FEC bool
+	PLC bool
+
+	// HasDTMF is set when telephone-event was negotiated with
+	// DTMFPayloadType, at DTMFClockRate (8000, or 48000 next to Opus).
+	HasDTMF         bool
+	DTMFPayloadType uint8
+	DTMFClockRate   int
+
+	// OnHold is set when the remote SDP asks us not to send (sendonly/inactive).
+	OnHold bool
+	// RemoteAddr is where RTP is sent, LocalAddr where it is received.
+	RemoteAddr string
+	LocalAddr  string
 }
 
 type SIPMediaConfig struct {
-	JitterMinPackets uint16
-	FrameDuration    time.Duration
+	JitterMin     time.Duration
+	JitterMax     time.Duration
+	FrameDuration time.Duration
+	FEC           bool
+	PLC           bool
 }
 
 func NewSipEndpoint(dialog SIPDialog, cfg SIPMediaConfig) (*SipEndpoint, error) {
@@ -95,8 +121,8 @@ func NewSipEndpoint(dialog SIPDialog, cfg SIPMediaConfig) (*SipEndpoint, error)
 		}
 	}
 
-	rtpReader := dialog.Media().RTPPacketReader.Reader()
-	rtpWriter := dialog.Media().RTPPacketWriter.Writer()
+	rtpReader := liveRTPReader{dialog.Media().RTPPacketReader}
+	rtpWriter := liveRTPWriter{dialog.Media().RTPPacketWriter}
 
 	// Map negotiated diago codec to media-sdk SDP name (canonicalized).
 	sdpName := media.CanonicalSDPName(codec)
@@ -112,6 +138,14 @@ func NewSipEndpoint(dialog SIPDialog, cfg SIPMediaConfig) (*SipEndpoint, error)
 
 	info := audioCodec.Info()
 
+	var dtmfCodec media.Codec
+	for _, c := range session.CommonCodecs() {
+		if strings.EqualFold(c.Name, "telephone-event") {
+			dtmfCodec = c
+			break
+		}
+	}
+
 	frameDur := cfg.FrameDuration
 	if frameDur <= 0 {
 		frameDur = 20 * time.Millisecond
@@ -124,14 +158,68 @@ func NewSipEndpoint(dialog SIPDialog, cfg SIPMediaConfig) (*SipEndpoint, error)
 		Codec:        codec,
 		rtpReader:    rtpReader,
 		rtpWriter:    rtpWriter,
+		dialogMedia:  dialog.Media(),
 		SampleRate:   info.SampleRate,
 		RTPClockRate: info.RTPClockRate,
 		Channels:     maxInt(1, codec.NumChannels),
 		FrameDur:     frameDur,
-		EnableJitter: cfg.JitterMinPackets > 0,
+		JitterMin:    cfg.JitterMin,
+		JitterMax:    cfg.JitterMax,
+		FEC:          cfg.FEC,
+		PLC:          cfg.PLC,
+
+		HasDTMF:         dtmfCodec.Name != "",
+		DTMFPayloadType: dtmfCodec.PayloadType,
+		DTMFClockRate:   int(dtmfCodec.SampleRate),
+
+		OnHold:     session.RemoteMode == sdp.ModeSendonly || session.RemoteMode == sdp.ModeInactive,
+		RemoteAddr: session.Raddr.String(),
+		LocalAddr:  session.Laddr.String(),
 	}, nil
 }
 
+// Same reports whether o negotiated the same codec, so the media pipelines
+// built for s can keep running.
+func (s *SipEndpoint) Same(o *SipEndpoint) bool {
+	return o != nil && s.LKSDPName == o.LKSDPName && s.Codec.PayloadType == o.Codec.PayloadType &&
+		s.Channels == o.Channels && s.HasDTMF == o.HasDTMF && s.DTMFPayloadType == o.DTMFPayloadType &&
+		s.DTMFClockRate == o.DTMFClockRate
+}
+
+// liveRTPReader reads through the dialog's packet reader, which diago points
+// at a new RTP session on every re-INVITE.
+type liveRTPReader struct {
+	r *media.RTPPacketReader
+}
+
+func (l liveRTPReader) ReadRTP(buf []byte, p *rtp.Packet) (int, error) {
+	return l.r.Reader().ReadRTP(buf, p)
+}
+
+// liveRTPWriter is the writing counterpart of liveRTPReader, so a changed
+// remote address takes effect without rebuilding the encoder.
+type liveRTPWriter struct {
+	w *media.RTPPacketWriter
+}
+
+func (l liveRTPWriter) WriteRTP(p *rtp.Packet) error {
+	return l.w.Writer().WriteRTP(p)
+}
+
+// RTCPStats returns the statistics of the current RTP session: what our
+// RTCP reports are built from, and what the remote side's reports told us.
+// ok is false before the session exists.
+func (s *SipEndpoint) RTCPStats() (read media.RTPReadStats, write media.RTPWriteStats, ok bool) {
+	if s.dialogMedia == nil {
+		return read, write, false
+	}
+	sess := s.dialogMedia.RTPSession()
+	if sess == nil {
+		return read, write, false
+	}
+	return sess.ReadStats(), sess.WriteStats(), true
+}
+
 func (s *SipEndpoint) Close() {
 	// no-op (media-sdk pipeline lives in bridge)
 }
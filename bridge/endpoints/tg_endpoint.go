@@ -3,6 +3,7 @@ package endpoints
 import (
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gotgcalls/third_party/ntgcalls"
@@ -21,7 +22,22 @@ type TgEndpoint struct {
 	done       chan struct{}
 	assembler  *pcm.FrameAssembler
 	closeOnce  sync.Once
-	onClose    func(chatID int64)
+	// toTG and fromTG resample between sampleRate and the rates exchanged
+	// with ntgcalls when they differ; sendAssembler cuts the resampled
+	// microphone audio back into 10ms frames.
+	toTG          *pcm.Resampler
+	fromTG        *pcm.Resampler
+	sendAssembler *pcm.FrameAssembler
+	onClose       func(*TgEndpoint)
+
+	// listenSSRC restricts group call speaker audio to a single source;
+	// 0 mixes every participant.
+	listenSSRC atomic.Uint32
+
+	// Group call legs; when present they receive speaker audio instead of frames.
+	legsMu    sync.Mutex
+	legs      []*TgLeg
+	mixerOnce sync.Once
 
 	// External microphone timestamps:
 	// Telegram expects a stable, monotonic capture timeline in 10ms steps.
@@ -33,7 +49,12 @@ type TgEndpoint struct {
 	micLastTsMs    int64
 }
 
-func NewTgEndpoint(ctx *ubot.Context, chatID int64, frameSize int, sampleRate int, onClose func(chatID int64)) *TgEndpoint {
+// NewTgEndpoint creates the endpoint of a Telegram call carrying frameSize
+// frames at sampleRate. sendRate and receiveRate are the rates of the
+// external microphone and speaker streams of ntgcalls; audio is resampled
+// when they differ from sampleRate. onClose (if set) runs once the endpoint
+// is closed.
+func NewTgEndpoint(ctx *ubot.Context, chatID int64, frameSize int, sampleRate int, sendRate int, receiveRate int, onClose func(*TgEndpoint)) *TgEndpoint {
 	// Derive frame step from PCM byte size.
 	// PCM16LE mono => 2 bytes/sample.
 	stepMs := int64(10)
@@ -47,7 +68,7 @@ func NewTgEndpoint(ctx *ubot.Context, chatID int64, frameSize int, sampleRate in
 		}
 	}
 
-	return &TgEndpoint{
+	s := &TgEndpoint{
 		ctx:        ctx,
 		chatID:     chatID,
 		frameSize:  frameSize,
@@ -58,6 +79,14 @@ func NewTgEndpoint(ctx *ubot.Context, chatID int64, frameSize int, sampleRate in
 		assembler:  pcm.NewFrameAssembler(frameSize),
 		onClose:    onClose,
 	}
+	if sendRate > 0 && sendRate != sampleRate {
+		s.toTG = pcm.NewResampler(sampleRate, sendRate)
+		s.sendAssembler = pcm.NewFrameAssembler(sendRate / 100 * 2)
+	}
+	if receiveRate > 0 && receiveRate != sampleRate {
+		s.fromTG = pcm.NewResampler(receiveRate, sampleRate)
+	}
+	return s
 }
 
 func (s *TgEndpoint) ChatID() int64 {
@@ -80,22 +109,102 @@ func (s *TgEndpoint) Format() pcm.AudioFormat {
 	}
 }
 
+// SetListenSource selects the group call audio source (SSRC) forwarded to
+// the speaker stream. 0 restores the full mix.
+func (s *TgEndpoint) SetListenSource(ssrc uint32) {
+	s.listenSSRC.Store(ssrc)
+}
+
+func (s *TgEndpoint) ListenSource() uint32 {
+	return s.listenSSRC.Load()
+}
+
 func (s *TgEndpoint) PushSpeakerFrames(frames []ntgcalls.Frame) {
-	for _, frame := range frames {
-		for _, normalized := range s.assembler.Push(frame.Data) {
-			select {
-			case <-s.done:
-				return
-			case s.frames <- normalized:
+	data := s.selectSpeakerAudio(frames)
+	if s.fromTG != nil && len(data) > 0 {
+		var err error
+		if data, err = s.fromTG.Resample(data); err != nil {
+			slog.Warn("tg speaker resample failed", "chat_id", s.chatID, "error", err)
+			return
+		}
+	}
+	for _, normalized := range s.assembler.Push(data) {
+		if legs := s.snapshotLegs(); len(legs) > 0 {
+			for i, leg := range legs {
+				frame := normalized
+				if i > 0 {
+					frame = append([]byte(nil), normalized...)
+				}
+				leg.pushSpeaker(frame)
 			}
+			continue
+		}
+		if s.chatID < 0 {
+			// Voice chat audio is only consumed through legs.
+			continue
+		}
+		select {
+		case <-s.done:
+			return
+		case s.frames <- normalized:
 		}
 	}
 }
 
+// selectSpeakerAudio flattens one frame callback into PCM. Group calls
+// deliver a frame per participant source; these are either filtered down to
+// the selected source or mixed together.
+func (s *TgEndpoint) selectSpeakerAudio(frames []ntgcalls.Frame) []byte {
+	listen := s.listenSSRC.Load()
+	var order []uint32
+	bySource := make(map[uint32][]byte, len(frames))
+	for _, frame := range frames {
+		if listen != 0 && frame.Ssrc != listen {
+			continue
+		}
+		if _, ok := bySource[frame.Ssrc]; !ok {
+			order = append(order, frame.Ssrc)
+		}
+		bySource[frame.Ssrc] = append(bySource[frame.Ssrc], frame.Data...)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+	out := bySource[order[0]]
+	for _, ssrc := range order[1:] {
+		data := bySource[ssrc]
+		if len(data) > len(out) {
+			out, data = data, out
+		}
+		pcm.MixPCM16LE(out, data)
+	}
+	return out
+}
+
 var sendFrameLogCount int64
 
 func (s *TgEndpoint) SendPCMFrame10ms(pcmFrame []byte) error {
+	if s.toTG == nil {
+		return s.sendFrame(pcmFrame)
+	}
+	data, err := s.toTG.Resample(pcmFrame)
+	if err != nil {
+		return err
+	}
+	for _, frame := range s.sendAssembler.Push(data) {
+		if err := s.sendFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TgEndpoint) sendFrame(pcmFrame []byte) error {
 	step := s.stepMs
+	if s.toTG != nil {
+		// Resampled audio is sent in 10ms frames whatever the internal framing.
+		step = 10
+	}
 	if step < 1 {
 		step = 10
 	}
@@ -132,9 +241,21 @@ func (s *TgEndpoint) SendPCMFrame10ms(pcmFrame []byte) error {
 func (s *TgEndpoint) Close() {
 	s.closeOnce.Do(func() {
 		_ = s.ctx.Stop(s.chatID)
+		for _, r := range []*pcm.Resampler{s.toTG, s.fromTG} {
+			if r != nil {
+				_ = r.Close()
+			}
+		}
+		s.legsMu.Lock()
 		close(s.done)
+		legs := s.legs
+		s.legs = nil
+		s.legsMu.Unlock()
+		for _, leg := range legs {
+			leg.closeLocal()
+		}
 		if s.onClose != nil {
-			s.onClose(s.chatID)
+			s.onClose(s)
 		}
 	})
 }
@@ -0,0 +1,161 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"gotgcalls/bridge/pcm"
+)
+
+// TgPort is the Telegram side of a media bridge: either a whole TgEndpoint
+// (private calls) or one TgLeg of a group call shared by several SIP calls.
+type TgPort interface {
+	Format() pcm.AudioFormat
+	SpeakerFrames() <-chan []byte
+	Done() <-chan struct{}
+	SendPCMFrame10ms(pcmFrame []byte) error
+}
+
+var ErrTgLegClosed = errors.New("tg leg closed")
+
+// legMicFrames bounds how far a leg's microphone can run ahead of the mixer.
+const legMicFrames = 4
+
+// TgLeg is one SIP participant in a group call. Every leg hears the voice
+// chat; the microphone frames of all legs are mixed into the single stream
+// the bridge sends to the chat.
+type TgLeg struct {
+	ep        *TgEndpoint
+	frames    chan []byte
+	mic       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// AddLeg attaches a new leg to the endpoint and starts the microphone mixer
+// on first use.
+func (s *TgEndpoint) AddLeg() (*TgLeg, error) {
+	leg := &TgLeg{
+		ep:     s,
+		frames: make(chan []byte, cap(s.frames)),
+		mic:    make(chan []byte, legMicFrames),
+		done:   make(chan struct{}),
+	}
+	s.legsMu.Lock()
+	defer s.legsMu.Unlock()
+	select {
+	case <-s.done:
+		return nil, ErrTgLegClosed
+	default:
+	}
+	s.legs = append(s.legs, leg)
+	s.mixerOnce.Do(func() { go s.runMicMixer() })
+	return leg, nil
+}
+
+// Legs returns the number of attached legs.
+func (s *TgEndpoint) Legs() int {
+	s.legsMu.Lock()
+	defer s.legsMu.Unlock()
+	return len(s.legs)
+}
+
+func (s *TgEndpoint) snapshotLegs() []*TgLeg {
+	s.legsMu.Lock()
+	defer s.legsMu.Unlock()
+	return slices.Clone(s.legs)
+}
+
+func (s *TgEndpoint) removeLeg(leg *TgLeg) (last bool) {
+	s.legsMu.Lock()
+	defer s.legsMu.Unlock()
+	s.legs = slices.DeleteFunc(s.legs, func(l *TgLeg) bool { return l == leg })
+	return len(s.legs) == 0
+}
+
+// runMicMixer sums the pending frame of every leg on the TG frame clock.
+func (s *TgEndpoint) runMicMixer() {
+	ticker := time.NewTicker(time.Duration(s.stepMs) * time.Millisecond)
+	defer ticker.Stop()
+	out := make([]byte, s.frameSize)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			clear(out)
+			for _, leg := range s.snapshotLegs() {
+				select {
+				case frame := <-leg.mic:
+					pcm.MixPCM16LE(out, frame)
+				default:
+				}
+			}
+			if err := s.SendPCMFrame10ms(out); err != nil {
+				slog.Warn("tg group mic send failed", "chat_id", s.chatID, "error", err)
+			}
+		}
+	}
+}
+
+func (l *TgLeg) ChatID() int64 {
+	return l.ep.chatID
+}
+
+func (l *TgLeg) Format() pcm.AudioFormat {
+	return l.ep.Format()
+}
+
+func (l *TgLeg) SpeakerFrames() <-chan []byte {
+	return l.frames
+}
+
+func (l *TgLeg) Done() <-chan struct{} {
+	return l.done
+}
+
+// SendPCMFrame10ms queues a frame for the mixer, dropping the oldest queued
+// frame if the leg runs ahead.
+func (l *TgLeg) SendPCMFrame10ms(pcmFrame []byte) error {
+	select {
+	case <-l.done:
+		return ErrTgLegClosed
+	default:
+	}
+	frame := append([]byte(nil), pcmFrame...)
+	for {
+		select {
+		case l.mic <- frame:
+			return nil
+		default:
+		}
+		select {
+		case <-l.mic:
+		default:
+		}
+	}
+}
+
+func (l *TgLeg) pushSpeaker(frame []byte) {
+	select {
+	case l.frames <- frame:
+	default:
+		// The bridge drains its backlog itself; don't stall other legs.
+	}
+}
+
+func (l *TgLeg) closeLocal() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// Close detaches the leg. The endpoint leaves the group call once its last
+// leg is closed.
+func (l *TgLeg) Close() {
+	l.closeLocal()
+	if l.ep.removeLeg(l) {
+		l.ep.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+)
+
+// CallState is the lifecycle stage of a call.
+type CallState string
+
+const (
+	// CallRinging: the SIP side is ringing (inbound INVITE received, or our
+	// outbound INVITE sent).
+	CallRinging CallState = "ringing"
+	// CallConnectingTG: the Telegram leg is being set up.
+	CallConnectingTG CallState = "connecting_tg"
+	// CallAnswered: the SIP dialog is established; media is not bridged yet.
+	CallAnswered CallState = "answered"
+	// CallBridged: audio flows between SIP and Telegram.
+	CallBridged CallState = "bridged"
+	// CallHeld: the SIP side put the call on hold.
+	CallHeld CallState = "held"
+	// CallEnded is final.
+	CallEnded CallState = "ended"
+)
+
+// callTransitions lists the states each state may move to. Inbound calls go
+// ringing -> connecting_tg -> answered -> bridged, or answered first when
+// the IVR picks up; outbound calls set up Telegram first (connecting_tg ->
+// ringing -> ...).
+var callTransitions = map[CallState][]CallState{
+	CallRinging:      {CallConnectingTG, CallAnswered, CallEnded},
+	CallConnectingTG: {CallRinging, CallAnswered, CallEnded},
+	CallAnswered:     {CallConnectingTG, CallBridged, CallHeld, CallEnded},
+	CallBridged:      {CallHeld, CallEnded},
+	CallHeld:         {CallBridged, CallEnded},
+}
+
+// CallEvent reports a state transition of a call.
+type CallEvent struct {
+	Call *Call
+	From CallState
+	To   CallState
+	// Cause is the hangup cause (cdr.Cause*) when To is CallEnded.
+	Cause string
+	Time  time.Time
+}
+
+// EventBus fans call events out to subscribers. Handlers run synchronously
+// on the goroutine driving the call, in transition order, so they must not
+// block; hand slow work (network I/O) to a queue or goroutine.
+type EventBus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]func(CallEvent)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{subs: map[int]func(CallEvent){}}
+}
+
+// Subscribe registers fn for all call events and returns a function that
+// removes it again.
+func (b *EventBus) Subscribe(fn func(CallEvent)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}
+}
+
+func (b *EventBus) publish(ev CallEvent) {
+	b.mu.RLock()
+	subs := make([]func(CallEvent), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// Events returns the bus carrying call state transitions.
+func (s *Service) Events() *EventBus {
+	return s.events
+}
+
+// setCallState moves call to state to and publishes the transition.
+// Transitions the state machine does not allow are ignored.
+func (s *Service) setCallState(call *Call, to CallState) {
+	from, ok := call.transition(to)
+	if !ok {
+		if from != to {
+			s.logger.Debug("call: state change ignored", "bridge_call_id", call.ID, "from", from, "to", to)
+		}
+		return
+	}
+	ev := CallEvent{Call: call, From: from, To: to, Time: time.Now()}
+	if to == CallEnded {
+		ev.Cause = call.hangupCause()
+	}
+	s.events.publish(ev)
+}
+
+// setHoldState moves a bridged call to held or back, following the SIP
+// side's hold state.
+func (s *Service) setHoldState(call *Call, onHold bool) {
+	switch state := call.State(); {
+	case onHold && state != CallHeld:
+		s.setCallState(call, CallHeld)
+	case !onHold && state == CallHeld:
+		s.setCallState(call, CallBridged)
+	}
+}
+
+// emitCDR sends the detail record of every ended call to the CDR recorder.
+func (s *Service) emitCDR(ev CallEvent) {
+	if ev.To == CallEnded {
+		s.cdr.Emit(ev.Call.cdrRecord(ev.Time))
+	}
+}
@@ -0,0 +1,201 @@
+// Package export ships call metrics and CDRs straight to time-series
+// databases (InfluxDB line protocol, TimescaleDB/PostgreSQL) for setups
+// without Prometheus. Delivery is batched and retried on a background
+// goroutine; callers never block, and points are dropped when a backend is
+// down long enough to fill the queue.
+package export
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Point is a single measurement. Tags are indexed dimensions; Fields hold
+// the values (int64, float64, string or bool).
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        time.Time
+}
+
+// Writer delivers a batch of points to a backend.
+type Writer interface {
+	Name() string
+	Write(ctx context.Context, points []Point) error
+	Close() error
+}
+
+// Options tune batching and retries. Zero values pick the defaults.
+type Options struct {
+	// BatchSize is the maximum number of points per write.
+	BatchSize int
+	// FlushInterval is how long points may wait for a batch to fill.
+	FlushInterval time.Duration
+	// QueueSize bounds the points buffered while the backend is slow or down.
+	QueueSize int
+	// MaxRetries is how often a failed batch is retried before it is dropped.
+	MaxRetries int
+}
+
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultQueueSize     = 10000
+	defaultMaxRetries    = 5
+	retryBaseDelay       = time.Second
+	retryMaxDelay        = 30 * time.Second
+	// closeTimeout bounds how long Close keeps retrying the final batches.
+	closeTimeout = 5 * time.Second
+)
+
+// Exporter batches points for one Writer.
+type Exporter struct {
+	writer  Writer
+	opts    Options
+	logger  *slog.Logger
+	queue   chan Point
+	dropped atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func New(w Writer, opts Options, logger *slog.Logger) *Exporter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Exporter{
+		writer: w,
+		opts:   opts,
+		logger: logger.With("exporter", w.Name()),
+		queue:  make(chan Point, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Add queues points for delivery. It never blocks; points are dropped when
+// the queue is full.
+func (e *Exporter) Add(points ...Point) {
+	for _, p := range points {
+		select {
+		case e.queue <- p:
+		default:
+			if e.dropped.Add(1)%1000 == 1 {
+				e.logger.Warn("export: queue full, dropping points", "dropped_total", e.dropped.Load())
+			}
+		}
+	}
+}
+
+// Dropped returns how many points were discarded (queue full or retries
+// exhausted).
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Close flushes queued points (giving up after a short timeout) and closes
+// the writer. Add must not be called afterwards.
+func (e *Exporter) Close() error {
+	var err error
+	e.once.Do(func() {
+		close(e.queue)
+		timer := time.AfterFunc(closeTimeout, e.cancel)
+		<-e.done
+		timer.Stop()
+		e.cancel()
+		err = e.writer.Close()
+	})
+	return err
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]Point, 0, e.opts.BatchSize)
+	for {
+		select {
+		case p, ok := <-e.queue:
+			if !ok {
+				e.flush(batch)
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= e.opts.BatchSize {
+				e.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes batch, retrying with exponential backoff. Points keep
+// queueing (up to QueueSize) meanwhile.
+func (e *Exporter) flush(batch []Point) {
+	if len(batch) == 0 {
+		return
+	}
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := e.writer.Write(e.ctx, batch)
+		if err == nil {
+			return
+		}
+		if attempt >= e.opts.MaxRetries || e.ctx.Err() != nil {
+			e.dropped.Add(uint64(len(batch)))
+			e.logger.Warn("export: write failed, batch dropped", "points", len(batch), "error", err)
+			return
+		}
+		e.logger.Warn("export: write failed, retrying", "points", len(batch), "attempt", attempt+1, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-e.ctx.Done():
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// Set fans points out to several exporters, each with its own queue so one
+// backend's outage does not hold back the others.
+type Set []*Exporter
+
+func (s Set) Add(points ...Point) {
+	for _, e := range s {
+		e.Add(points...)
+	}
+}
+
+func (s Set) Close() error {
+	var err error
+	for _, e := range s {
+		err = errors.Join(err, e.Close())
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxConfig selects an InfluxDB endpoint. Bucket (and Org) target the 2.x
+// /api/v2/write API; Database targets the 1.x /write API.
+type InfluxConfig struct {
+	URL      string
+	Token    string
+	Org      string
+	Bucket   string
+	Database string
+	Timeout  time.Duration
+}
+
+// InfluxWriter posts points in line protocol.
+type InfluxWriter struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func NewInfluxWriter(cfg InfluxConfig) (*InfluxWriter, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid influx url %q", cfg.URL)
+	}
+	q := url.Values{"precision": {"ns"}}
+	switch {
+	case cfg.Bucket != "":
+		base.Path += "/api/v2/write"
+		q.Set("bucket", cfg.Bucket)
+		if cfg.Org != "" {
+			q.Set("org", cfg.Org)
+		}
+	case cfg.Database != "":
+		base.Path += "/write"
+		q.Set("db", cfg.Database)
+	default:
+		return nil, fmt.Errorf("influx needs a bucket (2.x) or database (1.x)")
+	}
+	base.RawQuery = q.Encode()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &InfluxWriter{endpoint: base.String(), token: cfg.Token, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (w *InfluxWriter) Name() string { return "influx" }
+
+func (w *InfluxWriter) Write(ctx context.Context, points []Point) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		appendLine(&body, p)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("influx returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (w *InfluxWriter) Close() error { return nil }
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// appendLine encodes p as one line of InfluxDB line protocol.
+func appendLine(b *bytes.Buffer, p Point) {
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		if v := p.Tags[k]; v != "" {
+			fmt.Fprintf(b, ",%s=%s", keyEscaper.Replace(k), keyEscaper.Replace(v))
+		}
+	}
+	sep := byte(' ')
+	for _, k := range sortedKeys(p.Fields) {
+		b.WriteByte(sep)
+		sep = ','
+		b.WriteString(keyEscaper.Replace(k))
+		b.WriteByte('=')
+		switch v := p.Fields[k].(type) {
+		case int64:
+			b.WriteString(strconv.FormatInt(v, 10) + "i")
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			b.WriteString(strconv.FormatBool(v))
+		case string:
+			b.WriteString(`"` + stringEscaper.Replace(v) + `"`)
+		default:
+			b.WriteString(`"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`)
+		}
+	}
+	fmt.Fprintf(b, " %d\n", p.Time.UnixNano())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SQLWriter inserts points into PostgreSQL/TimescaleDB, one table per
+// measurement with a "time" column plus one column per tag and field.
+// Tables are created on first use and turned into hypertables when the
+// TimescaleDB extension is available.
+//
+// The binary must link a database/sql driver for the configured driver name
+// (e.g. github.com/jackc/pgx/v5/stdlib registers "pgx").
+type SQLWriter struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+func NewSQLWriter(driver, dsn string) (*SQLWriter, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLWriter{db: db, tables: map[string]bool{}}, nil
+}
+
+func (w *SQLWriter) Name() string { return "sql" }
+
+var identPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+func (w *SQLWriter) Write(ctx context.Context, points []Point) error {
+	// Group by table and column set so each group is one multi-row INSERT.
+	type group struct {
+		table   string
+		columns []string
+		rows    []Point
+	}
+	var groups []*group
+	index := map[string]*group{}
+	for _, p := range points {
+		cols := append(sortedKeys(p.Tags), sortedKeys(p.Fields)...)
+		key := p.Measurement + "|" + strings.Join(cols, ",")
+		g := index[key]
+		if g == nil {
+			for _, c := range cols {
+				if !identPattern.MatchString(c) {
+					return fmt.Errorf("invalid column name %q", c)
+				}
+			}
+			g = &group{table: p.Measurement, columns: cols}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, p)
+	}
+	for _, g := range groups {
+		if err := w.ensureTable(ctx, g.table, g.rows[0]); err != nil {
+			return err
+		}
+		if err := w.insert(ctx, g.table, g.columns, g.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *SQLWriter) insert(ctx context.Context, table string, columns []string, rows []Point) error {
+	var q strings.Builder
+	fmt.Fprintf(&q, "INSERT INTO %s (time", table)
+	for _, c := range columns {
+		q.WriteString(", " + c)
+	}
+	q.WriteString(") VALUES ")
+	args := make([]any, 0, len(rows)*(len(columns)+1))
+	for i, p := range rows {
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		args = append(args, p.Time)
+		fmt.Fprintf(&q, "($%d", len(args))
+		for _, c := range columns {
+			if v, ok := p.Tags[c]; ok {
+				args = append(args, v)
+			} else {
+				args = append(args, p.Fields[c])
+			}
+			fmt.Fprintf(&q, ", $%d", len(args))
+		}
+		q.WriteString(")")
+	}
+	_, err := w.db.ExecContext(ctx, q.String(), args...)
+	return err
+}
+
+// ensureTable creates the table for p's measurement if it does not exist.
+func (w *SQLWriter) ensureTable(ctx context.Context, table string, p Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tables[table] {
+		return nil
+	}
+	if !identPattern.MatchString(table) {
+		return fmt.Errorf("invalid table name %q", table)
+	}
+	var ddl strings.Builder
+	fmt.Fprintf(&ddl, "CREATE TABLE IF NOT EXISTS %s (time TIMESTAMPTZ NOT NULL", table)
+	for _, k := range sortedKeys(p.Tags) {
+		fmt.Fprintf(&ddl, ", %s TEXT", k)
+	}
+	for _, k := range sortedKeys(p.Fields) {
+		fmt.Fprintf(&ddl, ", %s %s", k, sqlType(p.Fields[k]))
+	}
+	ddl.WriteString(")")
+	if _, err := w.db.ExecContext(ctx, ddl.String()); err != nil {
+		return err
+	}
+	// Plain PostgreSQL lacks create_hypertable; the table still works.
+	_, _ = w.db.ExecContext(ctx, "SELECT create_hypertable($1, 'time', if_not_exists => TRUE)", table)
+	w.tables[table] = true
+	return nil
+}
+
+func sqlType(v any) string {
+	switch v.(type) {
+	case int64:
+		return "BIGINT"
+	case float64:
+		return "DOUBLE PRECISION"
+	case bool:
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+func (w *SQLWriter) Close() error { return w.db.Close() }
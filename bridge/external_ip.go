@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// startExternalIPDetection discovers the public IPv4 address with STUN and
+// advertises it on the IPv4 transports, when sip.external_ip is not set.
+// With sip.external_ip_refresh the address is checked again periodically
+// and the registration renewed when it changed.
+func (s *Service) startExternalIPDetection(ctx context.Context) {
+	if !s.cfg.SIPDetectExternalIP || !hasIPv4Transport(s.cfg) {
+		return
+	}
+	if s.providerIsLocal() {
+		s.logger.Info("sip: provider is on a private network, external IP detection skipped", "provider", s.cfg.SIPProvider)
+		return
+	}
+	var current net.IP
+	if ip, err := s.detectExternalIP(); err != nil {
+		s.logger.Warn("sip: external IP detection failed, advertising the local address (set sip.external_ip)", "stun_server", s.cfg.STUNServer, "error", err)
+	} else {
+		current = ip
+		s.sip.Load().SetExternalIP(ip)
+		s.logger.Info("sip: external IP detected", "ip", ip, "stun_server", s.cfg.STUNServer)
+	}
+	if s.cfg.SIPExternalIPRefresh > 0 {
+		go s.watchExternalIP(ctx, current)
+	}
+}
+
+func (s *Service) watchExternalIP(ctx context.Context, current net.IP) {
+	ticker := time.NewTicker(s.cfg.SIPExternalIPRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.sipSettings.Load().SIPDetectExternalIP {
+			// A SIP restart set sip.external_ip.
+			continue
+		}
+		ip, err := s.detectExternalIP()
+		if err != nil {
+			s.logger.Debug("sip: external IP check failed", "error", err)
+			continue
+		}
+		if ip.Equal(current) {
+			continue
+		}
+		s.logger.Info("sip: external IP changed", "old", current, "new", ip)
+		current = ip
+		s.sip.Load().SetExternalIP(ip)
+		// The registrar must learn the new Contact; calls in progress keep
+		// the address they were set up with.
+		if s.Registration().State != RegistrationDisabled && !s.draining.Load() {
+			s.unregister()
+			s.startRegistration(ctx)
+		}
+	}
+}
+
+// detectExternalIP asks sip.stun_server for the public IPv4 address.
+func (s *Service) detectExternalIP() (net.IP, error) {
+	addr, err := STUNMappedAddress(s.cfg.STUNServer)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+func hasIPv4Transport(cfg Config) bool {
+	for _, t := range SIPTransports(cfg) {
+		if strings.HasSuffix(t.Transport, "4") {
+			return true
+		}
+	}
+	return false
+}
+
+// providerIsLocal reports whether sip.provider is on a private network.
+func (s *Service) providerIsLocal() bool {
+	host, _ := splitHostPort(s.cfg.SIPProvider)
+	return isLocalIP(net.ParseIP(host))
+}
+
+// isLocalIP reports whether ip is a private, loopback or link-local
+// address, which a STUN server on the Internet can't tell us about.
+func isLocalIP(ip net.IP) bool {
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+}
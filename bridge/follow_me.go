@@ -0,0 +1,233 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// Follow-me targets besides phone numbers (follow_me).
+const (
+	FollowMeTelegram  = "telegram"
+	FollowMeVoicemail = "voicemail"
+)
+
+// FollowMeStep is one target of a follow-me chain.
+type FollowMeStep struct {
+	// Target is FollowMeTelegram, FollowMeVoicemail, a number dialed
+	// through the SIP trunk or a local SIP account.
+	Target string
+	// Timeout is how long the target rings before the next one is tried
+	// (0 = call.establish_timeout).
+	Timeout time.Duration
+}
+
+type yamlFollowMeStep struct {
+	Target  string `yaml:"target"`
+	Timeout string `yaml:"timeout"`
+}
+
+func parseFollowMe(chains map[string][]yamlFollowMeStep, voicemail bool, accounts []SIPAccount) (map[string][]FollowMeStep, error) {
+	if len(chains) == 0 {
+		return nil, nil
+	}
+	out := make(map[string][]FollowMeStep, len(chains))
+	for did, steps := range chains {
+		if phoneDigits(did) == "" {
+			return nil, fmt.Errorf("invalid follow_me number %q", did)
+		}
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("follow_me[%s] has no targets", did)
+		}
+		for i, y := range steps {
+			step := FollowMeStep{Target: strings.TrimSpace(y.Target)}
+			switch strings.ToLower(step.Target) {
+			case FollowMeTelegram:
+				step.Target = FollowMeTelegram
+			case FollowMeVoicemail:
+				if !voicemail {
+					return nil, fmt.Errorf("follow_me[%s] sends calls to voicemail, but voicemail.enabled is off", did)
+				}
+				if i != len(steps)-1 {
+					return nil, fmt.Errorf("follow_me[%s]: voicemail must be the last target", did)
+				}
+				step.Target = FollowMeVoicemail
+			default:
+				isAccount := slices.ContainsFunc(accounts, func(a SIPAccount) bool { return a.Username == step.Target })
+				if !isAccount && normalizePhone(step.Target) == "" {
+					return nil, fmt.Errorf("invalid follow_me[%s] target %q (telegram, voicemail, a number or a sip account)", did, y.Target)
+				}
+			}
+			if y.Timeout != "" {
+				d, err := time.ParseDuration(y.Timeout)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid follow_me[%s] timeout %q", did, y.Timeout)
+				}
+				step.Timeout = d
+			}
+			out[did] = append(out[did], step)
+		}
+	}
+	return out, nil
+}
+
+// followMe tries the targets of chain in turn for an inbound call. It
+// returns the Telegram session when Telegram answered; otherwise the call
+// was handled (forwarded, voicemail or rejected) and ok is false.
+func (s *Service) followMe(dialog *diago.DialogServerSession, call *Call, chain []FollowMeStep, codecs []media.Codec, earlyMedia bool, stopRingback func(), logger *slog.Logger) (session tgLeg, ok bool) {
+	for i, step := range chain {
+		timeout := step.Timeout
+		if timeout <= 0 {
+			timeout = s.Tunables().EstablishTimeout
+		}
+		stepLogger := logger.With("follow_me_step", i+1, "target", step.Target)
+		stepLogger.Info("follow-me: trying target", "timeout", timeout)
+		switch step.Target {
+		case FollowMeTelegram:
+			ctx, cancel := context.WithTimeout(dialog.Context(), timeout)
+			s.setCallState(call, CallConnectingTG)
+			session, err := s.openTGLeg(ctx, call.ChatID, s.cfg.VideoEnabled && offersVideo(dialog.InviteRequest.Body()))
+			cancel()
+			if err == nil {
+				stopRingback()
+				return session, true
+			}
+			s.setCallState(call, CallRinging)
+			stepLogger.Info("follow-me: telegram not reached", "error", err)
+		case FollowMeVoicemail:
+			stopRingback()
+			s.takeVoicemail(dialog, call, stepLogger)
+			return nil, false
+		default:
+			if s.forwardCall(dialog, call, step.Target, timeout, codecs, stopRingback, stepLogger) {
+				return nil, false
+			}
+		}
+		if dialog.Context().Err() != nil {
+			logger.Info("follow-me: caller hung up")
+			call.setCause(cdr.CauseCancelled)
+			return nil, false
+		}
+	}
+	stopRingback()
+	call.setCause(cdr.CauseNoAnswer)
+	s.rejectInbound(dialog, sip.StatusTemporarilyUnavailable, "No answer", AnnounceNoAnswer, earlyMedia, logger)
+	return nil, false
+}
+
+// forwardCall dials number through the SIP trunk, or the phones of a local
+// account, and once it answers connects the caller to it. Media is relayed
+// between the two dialogs without transcoding, so the number is only
+// offered codecs the caller can use. It reports whether the number answered; the forwarded call is over
+// by the time it returns.
+func (s *Service) forwardCall(dialog *diago.DialogServerSession, call *Call, number string, timeout time.Duration, codecs []media.Codec, stopRingback func(), logger *slog.Logger) bool {
+	targets, err := s.outboundTargets(number)
+	if err != nil {
+		logger.Warn("follow-me: invalid number", "error", err)
+		return false
+	}
+	offer := filterCodecs(codecs, sdpAudioCodecNames(dialog.InviteRequest.Body()))
+	if ms := dialog.MediaSession(); ms != nil {
+		// Early media or an answer already fixed the caller's codec.
+		offer = filterCodecs(codecs, []string{media.CodecAudioFromSession(ms).Name})
+	}
+
+	ctx, cancel := context.WithTimeout(dialog.Context(), timeout)
+	defer cancel()
+	// The phones of a local account all ring at once.
+	out, err := forkInvite(ctx, targets, func(ctx context.Context, recipient sip.Uri) (*diago.DialogClientSession, error) {
+		out, err := s.sip.Load().NewDialog(recipient, diago.NewDialogOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if ms := out.MediaSession(); ms != nil {
+			ms.Codecs = offer
+			ms.RTPNAT = s.rtpNAT()
+		}
+		err = out.Invite(ctx, diago.InviteClientOptions{
+			Username: s.cfg.SIPAuthUser,
+			Password: s.cfg.SIPAuthPass,
+			Headers:  append(s.loopHeaders(call), s.trunkHeaders(recipient, call)...),
+		})
+		if err == nil {
+			err = out.Ack(ctx)
+		}
+		if err != nil {
+			_ = out.Close()
+			return nil, err
+		}
+		return out, nil
+	}, logger)
+	if err != nil {
+		logger.Info("follow-me: number not reached", "error", err)
+		return false
+	}
+	defer hangupDialog(out, logger)
+
+	codec := media.CodecAudioFromSession(out.MediaSession())
+	stopRingback()
+	if !dialogAnswered(dialog) {
+		err := dialog.AnswerOptions(diago.AnswerOptions{Codecs: filterCodecs(codecs, []string{codec.Name}), RTPNAT: s.rtpNAT()})
+		if err != nil {
+			logger.Warn("follow-me: answering the caller failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
+			return true
+		}
+	}
+	s.setCallState(call, CallAnswered)
+	call.setSIPDialog(dialog)
+	call.setCodec(codec.Name)
+
+	bridge := diago.NewBridge()
+	bridge.DTMFpass = true
+	if err := bridge.AddDialogSession(dialog); err == nil {
+		err = bridge.AddDialogSession(out)
+	}
+	if err != nil {
+		logger.Warn("follow-me: media bridge failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		hangupDialog(dialog, logger)
+		return true
+	}
+	s.setCallState(call, CallBridged)
+	call.setCause(cdr.CauseForwarded)
+	logger.Info("follow-me: call forwarded", "number", number, "codec", codec.Name)
+	s.notify(s.cfg.TGUserID, fmt.Sprintf("Call from %s forwarded to %s", displayParty(call.Name, call.Number), number))
+
+	select {
+	case <-dialog.Context().Done():
+	case <-out.Context().Done():
+	case <-call.Done():
+	}
+	hangupDialog(dialog, logger)
+	return true
+}
+
+// sdpAudioCodecNames lists the audio codecs offered in an SDP body.
+func sdpAudioCodecNames(body []byte) []string {
+	desc := sdp.SessionDescription{}
+	if err := sdp.Unmarshal(body, &desc); err != nil {
+		return nil
+	}
+	md, err := desc.MediaDescription("audio")
+	if err != nil {
+		return nil
+	}
+	codecs := make([]media.Codec, len(md.Formats))
+	n, _ := media.CodecsFromSDPRead(md.Formats, desc.Values("a"), codecs)
+	var names []string
+	for _, c := range codecs[:max(0, min(n, len(codecs)))] {
+		names = append(names, c.Name)
+	}
+	return names
+}
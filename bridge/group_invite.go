@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+
+	"gotgcalls/bridge/endpoints"
+)
+
+// tgLeg is the Telegram side of one bridged call.
+type tgLeg interface {
+	endpoints.TgPort
+	Close()
+}
+
+var ErrNotGroupChat = errors.New("chat_id must be a group or channel (negative) id")
+
+// Invite dials number and mixes the answered party into the voice chat of
+// chatID as an additional participant, joining the voice chat if needed.
+// Like Originate it returns once the call is registered.
+func (s *Service) Invite(ctx context.Context, chatID int64, number string) (*Call, error) {
+	if chatID >= 0 {
+		return nil, ErrNotGroupChat
+	}
+	call, err := s.prepareOutboundCall(chatID, number, "")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := s.runOutboundCall(ctx, call); err != nil {
+			s.logger.Warn("invite failed", "error", err, "number", number, "tg_chat_id", chatID, "bridge_call_id", call.ID)
+		}
+	}()
+	return call, nil
+}
+
+// openTGLeg sets up the Telegram side of a call: a private call for users
+// (a video call when video is set), or a leg of the (possibly already
+// joined) voice chat for groups.
+func (s *Service) openTGLeg(ctx context.Context, chatID int64, video bool) (tgLeg, error) {
+	if chatID >= 0 {
+		session, err := s.startTGCall(ctx, chatID, video)
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+	return s.joinGroupCall(ctx, chatID)
+}
+
+func (s *Service) joinGroupCall(ctx context.Context, chatID int64) (*endpoints.TgLeg, error) {
+	s.groupJoinMu.Lock()
+	defer s.groupJoinMu.Unlock()
+	session := s.getTGSession(chatID)
+	if session == nil {
+		var err error
+		if session, err = s.startTGCall(ctx, chatID, false); err != nil {
+			return nil, err
+		}
+		s.logger.Info("tg group call joined", "chat_id", chatID)
+	}
+	return session.AddLeg()
+}
+
+// Conference moves call from its private Telegram call into the voice chat
+// of chatID as a participant, joining the voice chat if needed; the private
+// call is ended.
+func (s *Service) Conference(ctx context.Context, call *Call, chatID int64) error {
+	if chatID >= 0 {
+		return ErrNotGroupChat
+	}
+	media := call.mediaBridge()
+	prev := call.tgLeg()
+	if media == nil || prev == nil {
+		return ErrNotBridged
+	}
+	if call.ChatID == chatID {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
+	defer cancel()
+	leg, err := s.joinGroupCall(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	// The new leg becomes current first, so closing the old one doesn't end
+	// the call.
+	call.setTGLeg(leg)
+	if err := media.ReplaceTG(leg); err != nil {
+		call.setTGLeg(prev)
+		leg.Close()
+		return err
+	}
+	from := call.ChatID
+	call.setChatID(chatID)
+	if vb := s.getVideoBridge(from); vb != nil {
+		// Voice chats are audio only.
+		vb.stop()
+	}
+	prev.Close()
+	s.logger.Info("call moved into voice chat", "bridge_call_id", call.ID, "from_chat_id", from, "tg_chat_id", chatID)
+	return nil
+}
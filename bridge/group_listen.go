@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotInGroupCall      = errors.New("not connected to this voice chat")
+	ErrParticipantNotFound = errors.New("participant not found")
+)
+
+// ListenTo routes a single participant of the group call in chatID to the
+// SIP side. participantID 0 restores the full mix. It returns the selected
+// participant, or nil for the mix.
+func (s *Service) ListenTo(chatID, participantID int64) (*Participant, error) {
+	session := s.getTGSession(chatID)
+	if session == nil || chatID >= 0 {
+		return nil, ErrNotInGroupCall
+	}
+	if participantID == 0 {
+		session.SetListenSource(0)
+		s.logger.Info("tg group call: listening to everyone", "chat_id", chatID)
+		return nil, nil
+	}
+	participants, err := s.Participants(chatID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range participants {
+		if participants[i].ID == participantID {
+			return s.listenToParticipant(chatID, &participants[i])
+		}
+	}
+	return nil, ErrParticipantNotFound
+}
+
+// ListenToIndex is ListenTo addressed by position in the participant list
+// (1-based, as printed by /participants). 0 restores the full mix.
+func (s *Service) ListenToIndex(chatID int64, index int) (*Participant, error) {
+	if index == 0 {
+		return s.ListenTo(chatID, 0)
+	}
+	p, err := s.participantAt(chatID, index)
+	if err != nil {
+		return nil, err
+	}
+	return s.listenToParticipant(chatID, p)
+}
+
+// participantAt returns the participant at index (1-based, as printed by
+// /participants) of the group call in chatID.
+func (s *Service) participantAt(chatID int64, index int) (*Participant, error) {
+	if s.getTGSession(chatID) == nil || chatID >= 0 {
+		return nil, ErrNotInGroupCall
+	}
+	participants, err := s.Participants(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if index < 1 || index > len(participants) {
+		return nil, ErrParticipantNotFound
+	}
+	return &participants[index-1], nil
+}
+
+func (s *Service) listenToParticipant(chatID int64, p *Participant) (*Participant, error) {
+	session := s.getTGSession(chatID)
+	if session == nil {
+		return nil, ErrNotInGroupCall
+	}
+	if p.SSRC == 0 {
+		return nil, fmt.Errorf("%s has no audio source", p.Name)
+	}
+	session.SetListenSource(p.SSRC)
+	s.logger.Info("tg group call: listening to participant", "chat_id", chatID, "participant", p.ID, "ssrc", p.SSRC)
+	return p, nil
+}
+
+// listenSelection parses the DTMF listen command "*N#": N is a participant
+// position, and "*0#" or "*#" returns to the full mix.
+func listenSelection(digits string) (int, bool) {
+	if len(digits) < 2 || digits[len(digits)-1] != '#' {
+		return 0, false
+	}
+	star := -1
+	for i := len(digits) - 2; i >= 0; i-- {
+		if digits[i] == '*' {
+			star = i
+			break
+		}
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+	if star < 0 || len(digits)-star > 4 {
+		return 0, false
+	}
+	n := 0
+	for _, d := range digits[star+1 : len(digits)-1] {
+		n = n*10 + int(d-'0')
+	}
+	return n, true
+}
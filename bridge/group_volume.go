@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"fmt"
+
+	"gotgcalls/third_party/ubot"
+)
+
+// MaxVolume is the highest volume accepted by SetVolumeIndex, in percent.
+const MaxVolume = ubot.MaxVolume
+
+// SetCallVolume sets how loud the Telegram side of the call in chatID (a
+// private call or the whole voice chat) is sent to SIP, 0-MaxVolume
+// percent.
+func (s *Service) SetCallVolume(chatID int64, percent int) error {
+	if s.getTGSession(chatID) == nil {
+		return ErrNotInGroupCall
+	}
+	if err := s.tg.SetCallVolume(chatID, percent); err != nil {
+		return err
+	}
+	s.logger.Info("tg call volume set", "chat_id", chatID, "volume", percent)
+	return nil
+}
+
+// SetVolumeIndex sets how loud the participant at index (as printed by
+// /participants) of the voice chat in chatID is sent to SIP, 0-MaxVolume
+// percent. Only the bridge's mix changes; the voice chat hears no
+// difference.
+func (s *Service) SetVolumeIndex(chatID int64, index, percent int) (*Participant, error) {
+	p, err := s.participantAt(chatID, index)
+	if err != nil {
+		return nil, err
+	}
+	if p.SSRC == 0 {
+		return nil, fmt.Errorf("%s has no audio source", p.Name)
+	}
+	if err := s.tg.SetSourceVolume(chatID, p.SSRC, percent); err != nil {
+		return nil, err
+	}
+	p.LocalVolume = percent
+	s.logger.Info("tg group call: participant volume set", "chat_id", chatID, "participant", p.ID, "volume", percent)
+	return p, nil
+}
+
+// SetMutedIndex silences (or restores) the participant at index in the
+// audio sent to SIP, keeping its volume.
+func (s *Service) SetMutedIndex(chatID int64, index int, muted bool) (*Participant, error) {
+	p, err := s.participantAt(chatID, index)
+	if err != nil {
+		return nil, err
+	}
+	if p.SSRC == 0 {
+		return nil, fmt.Errorf("%s has no audio source", p.Name)
+	}
+	if err := s.tg.SetSourceMuted(chatID, p.SSRC, muted); err != nil {
+		return nil, err
+	}
+	p.LocalMuted = muted
+	s.logger.Info("tg group call: participant silenced", "chat_id", chatID, "participant", p.ID, "muted", muted)
+	return p, nil
+}
@@ -0,0 +1,254 @@
+// Package grpcapi exposes call control over gRPC: the call RPCs of the HTTP
+// API and a stream of call events, for services that would otherwise poll.
+package grpcapi
+
+//go:generate protoc --go_out=bridgepb --go_opt=paths=source_relative --go-grpc_out=bridgepb --go-grpc_opt=paths=source_relative --proto_path=bridgepb bridge.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"gotgcalls/bridge"
+	"gotgcalls/bridge/grpcapi/bridgepb"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before its
+// stream is ended.
+const eventBuffer = 256
+
+type Server struct {
+	bridgepb.UnimplementedBridgeServer
+
+	svc    *bridge.Service
+	token  string
+	logger *slog.Logger
+	// ctx is the lifetime of calls originated through the API. It must
+	// outlive individual RPCs.
+	ctx context.Context
+}
+
+func NewServer(svc *bridge.Service, token string, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{
+		svc:    svc,
+		token:  token,
+		logger: logger,
+		ctx:    context.Background(),
+	}
+}
+
+// ListenAndServe serves the API on addr until ctx is canceled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	s.ctx = ctx
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(s.authUnary),
+		grpc.StreamInterceptor(s.authStream),
+	)
+	bridgepb.RegisterBridgeServer(srv, s)
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	s.logger.Info("grpc: listening", "addr", addr)
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// authorize checks the bearer token in the "authorization" metadata.
+func (s *Server) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func (s *Server) authUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (s *Server) Originate(_ context.Context, req *bridgepb.OriginateRequest) (*bridgepb.Call, error) {
+	if req.GetNumber() == "" {
+		return nil, status.Error(codes.InvalidArgument, "number is required")
+	}
+	var (
+		call *bridge.Call
+		err  error
+	)
+	if req.GetChatId() != 0 {
+		call, err = s.svc.Invite(s.ctx, req.GetChatId(), req.GetNumber())
+	} else {
+		call, err = s.svc.Originate(s.ctx, req.GetNumber(), req.GetCallerId())
+	}
+	if err != nil {
+		code := codes.InvalidArgument
+		switch {
+		case errors.Is(err, bridge.ErrCallLimit), errors.Is(err, bridge.ErrDraining):
+			code = codes.Unavailable
+		case errors.Is(err, bridge.ErrTGBusy):
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	return callProto(call.Info()), nil
+}
+
+func (s *Server) Hangup(_ context.Context, req *bridgepb.HangupRequest) (*bridgepb.HangupResponse, error) {
+	call, err := s.call(req.GetCallId())
+	if err != nil {
+		return nil, err
+	}
+	call.Hangup()
+	return &bridgepb.HangupResponse{}, nil
+}
+
+func (s *Server) SendDTMF(_ context.Context, req *bridgepb.SendDTMFRequest) (*bridgepb.SendDTMFResponse, error) {
+	call, err := s.call(req.GetCallId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.svc.SendDTMF(call, req.GetDigits()); err != nil {
+		code := codes.InvalidArgument
+		if errors.Is(err, bridge.ErrNotBridged) || errors.Is(err, bridge.ErrDTMFDisabled) {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	return &bridgepb.SendDTMFResponse{}, nil
+}
+
+func (s *Server) Transfer(ctx context.Context, req *bridgepb.TransferRequest) (*bridgepb.TransferResponse, error) {
+	if req.GetTarget() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target is required")
+	}
+	call, err := s.call(req.GetCallId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.svc.Transfer(ctx, call, req.GetTarget()); err != nil {
+		code := codes.Unavailable
+		if errors.Is(err, bridge.ErrNoSIPLeg) {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	return &bridgepb.TransferResponse{}, nil
+}
+
+func (s *Server) GetCall(_ context.Context, req *bridgepb.GetCallRequest) (*bridgepb.Call, error) {
+	call, err := s.call(req.GetCallId())
+	if err != nil {
+		return nil, err
+	}
+	return callProto(call.Info()), nil
+}
+
+func (s *Server) ListCalls(context.Context, *bridgepb.ListCallsRequest) (*bridgepb.ListCallsResponse, error) {
+	res := &bridgepb.ListCallsResponse{}
+	for _, info := range s.svc.Calls() {
+		res.Calls = append(res.Calls, callProto(info))
+	}
+	return res, nil
+}
+
+// SubscribeEvents streams call events until the client goes away or falls
+// more than eventBuffer events behind.
+func (s *Server) SubscribeEvents(req *bridgepb.SubscribeEventsRequest, stream grpc.ServerStreamingServer[bridgepb.CallEvent]) error {
+	events := make(chan *bridgepb.CallEvent, eventBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	unsubscribe := s.svc.Events().Subscribe(func(ev bridge.CallEvent) {
+		if req.GetCallId() != "" && ev.Call.ID != req.GetCallId() {
+			return
+		}
+		select {
+		case events <- eventProto(ev):
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "shutting down")
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "event stream fell behind")
+		case ev := <-events:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) call(id string) (*bridge.Call, error) {
+	call, ok := s.svc.Call(id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "call not found")
+	}
+	return call, nil
+}
+
+func callProto(info bridge.CallInfo) *bridgepb.Call {
+	return &bridgepb.Call{
+		Id:        info.ID,
+		Direction: string(info.Direction),
+		Number:    info.Number,
+		Name:      info.Name,
+		ChatId:    info.ChatID,
+		SipCallId: info.SIPCallID,
+		StartedAt: timestamppb.New(info.StartedAt),
+		State:     string(info.State),
+		Bridged:   info.Bridged,
+		Recording: info.Recording,
+		OnHold:    info.OnHold,
+	}
+}
+
+func eventProto(ev bridge.CallEvent) *bridgepb.CallEvent {
+	return &bridgepb.CallEvent{
+		Call:  callProto(ev.Call.Info()),
+		From:  string(ev.From),
+		To:    string(ev.To),
+		Cause: ev.Cause,
+		Time:  timestamppb.New(ev.Time),
+	}
+}
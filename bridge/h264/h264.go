@@ -0,0 +1,141 @@
+// Package h264 reassembles H.264 video from RTP (RFC 6184) into an Annex B
+// byte stream, as read by ffmpeg and most decoders.
+package h264
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// NAL unit types.
+const (
+	NALIDR   = 5
+	NALSPS   = 7
+	NALPPS   = 8
+	nalSTAPA = 24
+	nalFUA   = 28
+)
+
+var startCode = []byte{0, 0, 0, 1}
+
+// ErrShort is returned for a payload too short for its packet type.
+var ErrShort = errors.New("h264: short payload")
+
+// Depacketizer writes the NAL units of RTP payloads to W. Output starts at
+// the first key frame (SPS or IDR), so it is decodable from the start;
+// fragmented units are dropped when packets were lost.
+type Depacketizer struct {
+	W io.Writer
+	// ParameterSets (SPS, PPS) signaled out of band are written before the
+	// first key frame when it doesn't bring its own.
+	ParameterSets [][]byte
+
+	started bool
+	lastSeq uint16
+	haveSeq bool
+	// fu holds a fragmented NAL unit being reassembled; nil when none is or
+	// a fragment was lost.
+	fu []byte
+}
+
+// Started reports whether a key frame was seen and output began.
+func (d *Depacketizer) Started() bool {
+	return d.started
+}
+
+// Push handles the payload of the RTP packet with sequence number seq.
+func (d *Depacketizer) Push(seq uint16, payload []byte) error {
+	lost := d.haveSeq && seq != d.lastSeq+1
+	d.lastSeq, d.haveSeq = seq, true
+	if lost {
+		d.fu = nil
+	}
+	if len(payload) < 1 {
+		return ErrShort
+	}
+	switch typ := payload[0] & 0x1f; typ {
+	case nalSTAPA:
+		b := payload[1:]
+		for len(b) > 2 {
+			n := int(b[0])<<8 | int(b[1])
+			if n == 0 || len(b) < 2+n {
+				return ErrShort
+			}
+			if err := d.write(b[2 : 2+n]); err != nil {
+				return err
+			}
+			b = b[2+n:]
+		}
+		return nil
+	case nalFUA:
+		if len(payload) < 2 {
+			return ErrShort
+		}
+		start, end := payload[1]&0x80 != 0, payload[1]&0x40 != 0
+		if start {
+			// The reconstructed header: F and NRI of the indicator, the type
+			// of the fragment header.
+			d.fu = append(d.fu[:0:0], payload[0]&0xe0|payload[1]&0x1f)
+		} else if d.fu == nil {
+			return nil
+		}
+		d.fu = append(d.fu, payload[2:]...)
+		if !end {
+			return nil
+		}
+		nal := d.fu
+		d.fu = nil
+		return d.write(nal)
+	default:
+		if typ == 0 || typ > 23 {
+			// Interleaved modes (STAP-B, MTAP, FU-B) are not supported.
+			return nil
+		}
+		return d.write(payload)
+	}
+}
+
+func (d *Depacketizer) write(nal []byte) error {
+	if len(nal) == 0 {
+		return nil
+	}
+	if !d.started {
+		typ := nal[0] & 0x1f
+		if typ != NALSPS && typ != NALIDR {
+			return nil
+		}
+		d.started = true
+		if typ == NALIDR {
+			for _, ps := range d.ParameterSets {
+				if err := d.write(ps); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if _, err := d.W.Write(startCode); err != nil {
+		return err
+	}
+	_, err := d.W.Write(nal)
+	return err
+}
+
+// ParameterSets returns the SPS and PPS of the sprop-parameter-sets format
+// parameter of an H.264 fmtp line, if any.
+func ParameterSets(fmtp string) [][]byte {
+	var sets [][]byte
+	for _, param := range strings.Split(fmtp, ";") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(param), "sprop-parameter-sets=")
+		if !ok {
+			continue
+		}
+		for _, b64 := range strings.Split(value, ",") {
+			if ps, err := base64.StdEncoding.DecodeString(b64); err == nil && len(ps) > 0 {
+				sets = append(sets, ps)
+			}
+		}
+	}
+	return sets
+}
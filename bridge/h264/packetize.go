@@ -0,0 +1,90 @@
+package h264
+
+import (
+	"bufio"
+	"io"
+)
+
+// NALAUD is the access unit delimiter, which x264 writes before every frame
+// with aud=1.
+const NALAUD = 9
+
+// Packetize splits a NAL unit into RTP payloads of at most mtu bytes: the
+// unit itself when it fits, FU-A fragments otherwise.
+func Packetize(nal []byte, mtu int) [][]byte {
+	if len(nal) == 0 {
+		return nil
+	}
+	if len(nal) <= mtu {
+		return [][]byte{nal}
+	}
+	indicator := nal[0]&0xe0 | nalFUA
+	typ := nal[0] & 0x1f
+	var payloads [][]byte
+	data := nal[1:]
+	for first := true; len(data) > 0; first = false {
+		n := min(len(data), mtu-2)
+		header := typ
+		if first {
+			header |= 0x80
+		}
+		if n == len(data) {
+			header |= 0x40
+		}
+		payloads = append(payloads, append([]byte{indicator, header}, data[:n]...))
+		data = data[n:]
+	}
+	return payloads
+}
+
+// Reader reads the NAL units of an Annex B byte stream. A unit is returned
+// once the start code of the next one (or the end of the stream) was read.
+type Reader struct {
+	r   *bufio.Reader
+	nal []byte
+	// zeros counts the zero bytes read after the current unit, which belong
+	// to the next start code if one follows.
+	zeros   int
+	started bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next NAL unit; it is only valid until the following
+// call. The error is io.EOF at the end of the stream.
+func (r *Reader) Next() ([]byte, error) {
+	if r.started {
+		r.nal = r.nal[:0]
+	}
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && r.started && len(r.nal) > 0 {
+				r.started = false
+				return r.nal, nil
+			}
+			return nil, err
+		}
+		switch {
+		case b == 0:
+			r.zeros++
+		case b == 1 && r.zeros >= 2:
+			r.zeros = 0
+			if r.started && len(r.nal) > 0 {
+				return r.nal, nil
+			}
+			r.started = true
+		default:
+			for ; r.zeros > 0; r.zeros-- {
+				if r.started {
+					r.nal = append(r.nal, 0)
+				}
+			}
+			if r.started {
+				r.nal = append(r.nal, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	msdk "github.com/livekit/media-sdk"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/pcm"
+	"gotgcalls/bridge/pipeline"
+)
+
+// loadAudioFiles decodes the configured ringback and hold music clips so a
+// bad path fails at startup rather than on the first call.
+func (s *Service) loadAudioFiles() error {
+	if s.cfg.RingbackFile != "" {
+		clip, err := audiofile.Load(s.cfg.RingbackFile, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("audio.ringback_file: %w", err)
+		}
+		if !s.cfg.EnableEarlyMedia {
+			s.logger.Warn("audio.ringback_file is set but sip.early_media is off; it will not be played")
+		}
+		s.ringback = clip
+	}
+	if s.cfg.HoldMusicFile != "" {
+		clip, err := audiofile.Load(s.cfg.HoldMusicFile, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("audio.hold_music_file: %w", err)
+		}
+		s.holdMusic = clip
+	}
+	if s.cfg.RecordingAnnouncementFile != "" {
+		clip, err := audiofile.Load(s.cfg.RecordingAnnouncementFile, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("recording.announcement_file: %w", err)
+		}
+		s.recordingNotice = clip
+	}
+	if err := s.loadAnnouncements(); err != nil {
+		return err
+	}
+	if err := s.loadVoicemail(); err != nil {
+		return err
+	}
+	if err := s.loadScreening(); err != nil {
+		return err
+	}
+	if err := s.loadIVR(); err != nil {
+		return err
+	}
+	if s.cfg.EchoGreetingFile != "" {
+		clip, err := audiofile.Load(s.cfg.EchoGreetingFile, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("echo.greeting_file: %w", err)
+		}
+		s.echoGreeting = clip
+	}
+	if err := s.loadInfoLines(); err != nil {
+		return err
+	}
+	return s.loadDirectory()
+}
+
+// attachHoldMusic makes bridge play the hold music clip (if any) to TG while
+// the SIP side holds the call.
+func (s *Service) attachHoldMusic(bridge *MediaBridge) {
+	if s.holdMusic != nil {
+		bridge.SetHoldAudio(s.holdMusic.Loop())
+	}
+}
+
+// playRingback streams the ringback clip over the dialog's early media
+// session until the returned stop function is called.
+func (s *Service) playRingback(dialog endpoints.SIPDialog, logger *slog.Logger) (stop func(), err error) {
+	loop := s.ringback.Loop()
+	stop, _, err = s.playToSIP(dialog, s.ringback, func(dst msdk.PCM16Sample) bool {
+		loop.ReadSample(dst)
+		return true
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("sip: playing ringback", "file", s.ringback.Path)
+	return stop, nil
+}
+
+// playToSIP streams audio from next (at clip's sample rate) over the
+// dialog's media session until next reports the end or stop is called;
+// done is closed when playback ends either way.
+func (s *Service) playToSIP(dialog endpoints.SIPDialog, clip *audiofile.Clip, next func(dst msdk.PCM16Sample) bool, logger *slog.Logger) (stop func(), done <-chan struct{}, err error) {
+	sip, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, err := pipeline.BuildSipEncodePipeline(pipeline.SipEncodeConfig{
+		Codec:       sip.LKCodec,
+		PayloadType: sip.PayloadType(),
+		RTPClock:    sip.RTPClockRate,
+		SourceRate:  clip.SampleRate,
+		RTPWriter:   sip.RTPWriter(),
+		FEC:         sip.FEC,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(sip.FrameDur)
+		defer ticker.Stop()
+		frame := make(msdk.PCM16Sample, clip.SampleRate*int(sip.FrameDur/time.Millisecond)/1000)
+		var out msdk.PCM16Sample
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				more := next(frame)
+				out = pcm.PCM16ConvertChannels(out, frame, 1, sip.Channels)
+				if err := enc.Writer.WriteSample(out); err != nil {
+					logger.Warn("sip playback write failed", "file", clip.Path, "error", err)
+					return
+				}
+				if !more {
+					return
+				}
+			}
+		}
+	}()
+	logger.Debug("sip: playback started", "file", clip.Path, "codec", sip.Codec.Name)
+	return func() {
+		cancel()
+		<-finished
+	}, finished, nil
+}
@@ -0,0 +1,505 @@
+// Package ical reads the busy time of an iCalendar (RFC 5545) feed: the
+// events of its VEVENT components, with recurring events expanded.
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRecurrences bounds the instances generated for one recurring event,
+// so a daily event from years back or a broken rule cannot stall a refresh.
+const maxRecurrences = 100000
+
+// Event is one (instance of an) event.
+type Event struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Unsupported is a busy recurring event whose rule Parse cannot expand.
+type Unsupported struct {
+	Summary string
+	Rule    string
+}
+
+// Parse returns the events of data that overlap [from, to) and block time:
+// transparent ("free") and cancelled events are left out. Recurring events
+// are expanded for FREQ=DAILY, WEEKLY (with BYDAY), MONTHLY and YEARLY
+// rules with INTERVAL, COUNT, UNTIL and EXDATE; other BY* parts are not
+// supported and such events only count once, and are listed in
+// unsupported. Times without a time zone are taken in loc. Events are
+// sorted by start.
+func Parse(data []byte, from, to time.Time, loc *time.Location) (events []Event, unsupported []Unsupported, err error) {
+	lines := unfold(string(data))
+	if len(lines) == 0 || !strings.EqualFold(lines[0].value, "VCALENDAR") || !strings.EqualFold(lines[0].name, "BEGIN") {
+		return nil, nil, errors.New("ical: not an iCalendar document")
+	}
+	var (
+		overrides = map[string]map[int64]bool{} // UID -> RECURRENCE-ID instants
+		masters   []vevent
+		cur       *vevent
+		depth     int // nesting below the current VEVENT (VALARM)
+	)
+	for _, l := range lines {
+		switch {
+		case l.name == "BEGIN" && strings.EqualFold(l.value, "VEVENT") && cur == nil:
+			cur = &vevent{}
+		case cur == nil:
+		case l.name == "BEGIN":
+			depth++
+		case l.name == "END" && depth > 0:
+			depth--
+		case l.name == "END" && strings.EqualFold(l.value, "VEVENT"):
+			ev, err := cur.finish(loc)
+			if err != nil {
+				return nil, nil, err
+			}
+			cur = nil
+			if ev == nil {
+				continue
+			}
+			if !ev.recurrenceID.IsZero() {
+				if overrides[ev.uid] == nil {
+					overrides[ev.uid] = map[int64]bool{}
+				}
+				overrides[ev.uid][ev.recurrenceID.Unix()] = true
+			}
+			masters = append(masters, *ev)
+		case depth == 0:
+			cur.set(l)
+		}
+	}
+	for _, ev := range masters {
+		if ev.recurrenceID.IsZero() {
+			ev.exdates = mergeSets(ev.exdates, overrides[ev.uid])
+		}
+		if !ev.busy {
+			continue
+		}
+		if ev.rule != nil && !ev.rule.simple {
+			unsupported = append(unsupported, Unsupported{Summary: ev.summary, Rule: ev.rule.raw})
+		}
+		events = append(events, ev.instances(from, to)...)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, unsupported, nil
+}
+
+// Busy returns the end of the busy time around at: the latest end of the
+// events that overlap at, extended by events that start before that end.
+// ok is false when at is free; summary is that of the event running at at.
+func Busy(events []Event, at time.Time) (until time.Time, summary string, ok bool) {
+	for _, ev := range events {
+		if ev.Start.After(at) || !ev.End.After(at) {
+			continue
+		}
+		if !ok {
+			summary, ok = ev.Summary, true
+		}
+		if ev.End.After(until) {
+			until = ev.End
+		}
+	}
+	if !ok {
+		return time.Time{}, "", false
+	}
+	for _, ev := range events {
+		if ev.Start.After(until) {
+			break
+		}
+		if ev.End.After(until) {
+			until = ev.End
+		}
+	}
+	return until, summary, true
+}
+
+type line struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfold splits data into content lines, joining folded ones.
+func unfold(data string) []line {
+	var raw []string
+	for _, l := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(raw) > 0 {
+			raw[len(raw)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			raw = append(raw, l)
+		}
+	}
+	lines := make([]line, 0, len(raw))
+	for _, l := range raw {
+		head, value, ok := cutUnquoted(l, ':')
+		if !ok {
+			continue
+		}
+		parts := strings.Split(head, ";")
+		out := line{name: strings.ToUpper(parts[0]), value: value}
+		for _, p := range parts[1:] {
+			if k, v, ok := strings.Cut(p, "="); ok {
+				if out.params == nil {
+					out.params = map[string]string{}
+				}
+				out.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+		}
+		lines = append(lines, out)
+	}
+	return lines
+}
+
+// cutUnquoted cuts s at the first sep outside double quotes (parameter
+// values may contain colons).
+func cutUnquoted(s string, sep byte) (before, after string, found bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+type vevent struct {
+	lines []line
+
+	uid          string
+	summary      string
+	start, end   time.Time
+	allDay       bool
+	busy         bool
+	recurrenceID time.Time
+	rule         *rrule
+	exdates      map[int64]bool
+}
+
+func (v *vevent) set(l line) { v.lines = append(v.lines, l) }
+
+// finish interprets the collected properties; it returns nil for events
+// without a start.
+func (v *vevent) finish(loc *time.Location) (*vevent, error) {
+	v.busy = true
+	var (
+		duration time.Duration
+		hasEnd   bool
+		rule     string
+	)
+	for _, l := range v.lines {
+		var err error
+		switch l.name {
+		case "UID":
+			v.uid = l.value
+		case "SUMMARY":
+			v.summary = unescape(l.value)
+		case "DTSTART":
+			v.start, v.allDay, err = parseTime(l, loc)
+		case "DTEND":
+			v.end, _, err = parseTime(l, loc)
+			hasEnd = err == nil
+		case "DURATION":
+			duration, err = parseDuration(l.value)
+		case "TRANSP":
+			v.busy = v.busy && !strings.EqualFold(l.value, "TRANSPARENT")
+		case "STATUS":
+			v.busy = v.busy && !strings.EqualFold(l.value, "CANCELLED")
+		case "RECURRENCE-ID":
+			v.recurrenceID, _, err = parseTime(l, loc)
+		case "RRULE":
+			rule = l.value
+		case "EXDATE":
+			for _, value := range strings.Split(l.value, ",") {
+				t, _, terr := parseTime(line{params: l.params, value: value}, loc)
+				if terr != nil {
+					err = terr
+					break
+				}
+				if v.exdates == nil {
+					v.exdates = map[int64]bool{}
+				}
+				v.exdates[t.Unix()] = true
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ical: event %q: %s: %w", v.summary, l.name, err)
+		}
+	}
+	v.lines = nil
+	if v.start.IsZero() {
+		return nil, nil
+	}
+	switch {
+	case hasEnd:
+	case duration > 0:
+		v.end = v.start.Add(duration)
+	case v.allDay:
+		v.end = v.start.AddDate(0, 0, 1)
+	default:
+		v.end = v.start
+	}
+	if rule != "" && v.recurrenceID.IsZero() {
+		r, err := parseRule(rule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("ical: event %q: RRULE: %w", v.summary, err)
+		}
+		v.rule = r
+	}
+	return v, nil
+}
+
+// instances lists the occurrences of v that overlap [from, to).
+func (v *vevent) instances(from, to time.Time) []Event {
+	length := v.end.Sub(v.start)
+	var out []Event
+	add := func(start time.Time) {
+		end := start.Add(length)
+		if v.exdates[start.Unix()] || !end.After(from) || !start.Before(to) || length <= 0 {
+			return
+		}
+		out = append(out, Event{Summary: v.summary, Start: start, End: end})
+	}
+	if v.rule == nil {
+		add(v.start)
+		return out
+	}
+	v.rule.each(v.start, func(start time.Time) bool {
+		if start.After(to) {
+			return false
+		}
+		add(start)
+		return true
+	})
+	return out
+}
+
+// rrule is the supported subset of a recurrence rule.
+type rrule struct {
+	raw      string
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+	simple   bool // only FREQ, INTERVAL, COUNT, UNTIL and (weekly) BYDAY
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRule(s string, loc *time.Location) (*rrule, error) {
+	r := &rrule{raw: s, interval: 1, simple: true}
+	for _, part := range strings.Split(s, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", v)
+			}
+			r.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", v)
+			}
+			r.count = n
+		case "UNTIL":
+			t, allDay, err := parseTime(line{value: v}, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q", v)
+			}
+			if allDay {
+				// The whole day is included.
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			r.until = t
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := weekdays[strings.ToUpper(d)]
+				if !ok {
+					// Ordinals such as 2MO (monthly rules).
+					r.simple = false
+					continue
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "WKST", "":
+		default:
+			r.simple = false
+		}
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return nil, errors.New("FREQ is missing")
+	default:
+		r.simple = false
+	}
+	if len(r.byDay) > 0 && r.freq != "WEEKLY" {
+		r.simple = false
+	}
+	return r, nil
+}
+
+// each calls fn with the start of every occurrence from dtstart on, in
+// order, until it returns false or the rule ends.
+func (r *rrule) each(dtstart time.Time, fn func(time.Time) bool) {
+	if !r.simple {
+		fn(dtstart)
+		return
+	}
+	n := 0
+	emit := func(t time.Time) bool {
+		if !r.until.IsZero() && t.After(r.until) {
+			return false
+		}
+		n++
+		if r.count > 0 && n > r.count {
+			return false
+		}
+		return fn(t)
+	}
+	if r.freq == "WEEKLY" && len(r.byDay) > 0 {
+		// Weeks start on Monday; each week yields its BYDAY days in order.
+		days := append([]time.Weekday(nil), r.byDay...)
+		sort.Slice(days, func(i, j int) bool { return (days[i]+6)%7 < (days[j]+6)%7 })
+		weekStart := dtstart.AddDate(0, 0, -int((dtstart.Weekday()+6)%7))
+		for w := 0; w < maxRecurrences; w++ {
+			week := weekStart.AddDate(0, 0, 7*r.interval*w)
+			for _, d := range days {
+				t := week.AddDate(0, 0, int((d+6)%7))
+				if t.Before(dtstart) {
+					continue
+				}
+				if !emit(t) {
+					return
+				}
+			}
+		}
+		return
+	}
+	for i := 0; i < maxRecurrences; i++ {
+		var t time.Time
+		switch r.freq {
+		case "DAILY":
+			t = dtstart.AddDate(0, 0, i*r.interval)
+		case "WEEKLY":
+			t = dtstart.AddDate(0, 0, 7*i*r.interval)
+		case "MONTHLY":
+			t = dtstart.AddDate(0, i*r.interval, 0)
+			if t.Day() != dtstart.Day() {
+				continue // no such day this month (the 31st)
+			}
+		case "YEARLY":
+			t = dtstart.AddDate(i*r.interval, 0, 0)
+			if t.Day() != dtstart.Day() {
+				continue // February 29th
+			}
+		}
+		if !emit(t) {
+			return
+		}
+	}
+}
+
+// parseTime reads a DATE or DATE-TIME value: UTC ("Z"), with a TZID
+// parameter, or floating (in loc). allDay is set for DATE values.
+func parseTime(l line, loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(l.value)
+	if tzid := l.params["TZID"]; tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			loc = tz
+		}
+		// Unknown zones (Outlook's Windows names) fall back to loc.
+	}
+	switch {
+	case len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	return t, false, err
+}
+
+// parseDuration reads an RFC 5545 duration such as PT1H30M or P1D.
+func parseDuration(s string) (time.Duration, error) {
+	v := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "+"), "P")
+	if v == s || v == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	inTime := false
+	num := 0
+	digits := false
+	for _, c := range v {
+		switch {
+		case c >= '0' && c <= '9':
+			num = num*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(num) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(num) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(num) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(num) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(num) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		num, digits = 0, false
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+func mergeSets(a, b map[int64]bool) map[int64]bool {
+	if len(b) == 0 {
+		return a
+	}
+	out := make(map[int64]bool, len(a)+len(b))
+	for k := range a {
+		out[k] = true
+	}
+	for k := range b {
+		out[k] = true
+	}
+	return out
+}
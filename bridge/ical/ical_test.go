@@ -0,0 +1,361 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func calendar(events ...string) []byte {
+	return []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n")
+}
+
+func eventLines(lines ...string) string {
+	return "BEGIN:VEVENT\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VEVENT\r\n"
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+func date(y int, m time.Month, d, h, min int, loc *time.Location) time.Time {
+	return time.Date(y, m, d, h, min, 0, 0, loc)
+}
+
+func TestParse(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	from := date(2026, 1, 1, 0, 0, time.UTC)
+	to := date(2027, 1, 1, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		data []byte
+		want []Event
+	}{
+		{
+			name: "single event",
+			data: calendar(eventLines(
+				"UID:a",
+				`SUMMARY:Standup\, daily`,
+				"DTSTART:20260105T090000Z",
+				"DTEND:20260105T091500Z",
+			)),
+			want: []Event{{"Standup, daily", date(2026, 1, 5, 9, 0, time.UTC), date(2026, 1, 5, 9, 15, time.UTC)}},
+		},
+		{
+			name: "folded line, time zone and duration",
+			data: calendar(eventLines(
+				"UID:a",
+				"SUMMARY:Long",
+				"  meeting",
+				"DTSTART;TZID=Europe/Berlin:20260105T100000",
+				"DURATION:PT1H30M",
+			)),
+			want: []Event{{"Long meeting", date(2026, 1, 5, 10, 0, berlin), date(2026, 1, 5, 11, 30, berlin)}},
+		},
+		{
+			name: "all day event",
+			data: calendar(eventLines("UID:a", "SUMMARY:Holiday", "DTSTART;VALUE=DATE:20260105")),
+			want: []Event{{"Holiday", date(2026, 1, 5, 0, 0, time.UTC), date(2026, 1, 6, 0, 0, time.UTC)}},
+		},
+		{
+			name: "free and cancelled events are left out",
+			data: calendar(
+				eventLines("UID:a", "SUMMARY:Free", "TRANSP:TRANSPARENT", "DTSTART:20260105T090000Z", "DTEND:20260105T100000Z"),
+				eventLines("UID:b", "SUMMARY:Off", "STATUS:CANCELLED", "DTSTART:20260105T090000Z", "DTEND:20260105T100000Z"),
+			),
+		},
+		{
+			name: "alarms do not end the event",
+			data: calendar(eventLines(
+				"UID:a",
+				"SUMMARY:Review",
+				"DTSTART:20260105T090000Z",
+				"BEGIN:VALARM",
+				"TRIGGER:-PT5M",
+				"END:VALARM",
+				"DTEND:20260105T100000Z",
+			)),
+			want: []Event{{"Review", date(2026, 1, 5, 9, 0, time.UTC), date(2026, 1, 5, 10, 0, time.UTC)}},
+		},
+		{
+			name: "weekly BYDAY",
+			data: calendar(eventLines(
+				"UID:a",
+				"SUMMARY:Gym",
+				"DTSTART:20260107T180000Z", // a Wednesday
+				"DTEND:20260107T190000Z",
+				"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4",
+			)),
+			want: []Event{
+				{"Gym", date(2026, 1, 7, 18, 0, time.UTC), date(2026, 1, 7, 19, 0, time.UTC)},
+				{"Gym", date(2026, 1, 9, 18, 0, time.UTC), date(2026, 1, 9, 19, 0, time.UTC)},
+				{"Gym", date(2026, 1, 12, 18, 0, time.UTC), date(2026, 1, 12, 19, 0, time.UTC)},
+				{"Gym", date(2026, 1, 14, 18, 0, time.UTC), date(2026, 1, 14, 19, 0, time.UTC)},
+			},
+		},
+		{
+			name: "monthly on the 31st skips shorter months",
+			data: calendar(eventLines(
+				"UID:a",
+				"SUMMARY:Report",
+				"DTSTART:20260131T120000Z",
+				"DTEND:20260131T130000Z",
+				"RRULE:FREQ=MONTHLY;COUNT=3",
+			)),
+			want: []Event{
+				{"Report", date(2026, 1, 31, 12, 0, time.UTC), date(2026, 1, 31, 13, 0, time.UTC)},
+				{"Report", date(2026, 3, 31, 12, 0, time.UTC), date(2026, 3, 31, 13, 0, time.UTC)},
+				{"Report", date(2026, 5, 31, 12, 0, time.UTC), date(2026, 5, 31, 13, 0, time.UTC)},
+			},
+		},
+		{
+			name: "EXDATE and RECURRENCE-ID overrides",
+			data: calendar(
+				eventLines(
+					"UID:daily",
+					"SUMMARY:Sync",
+					"DTSTART:20260105T090000Z",
+					"DTEND:20260105T093000Z",
+					"RRULE:FREQ=DAILY;COUNT=4",
+					"EXDATE:20260106T090000Z",
+				),
+				eventLines(
+					"UID:daily",
+					"SUMMARY:Sync (moved)",
+					"RECURRENCE-ID:20260107T090000Z",
+					"DTSTART:20260107T140000Z",
+					"DTEND:20260107T143000Z",
+				),
+			),
+			want: []Event{
+				{"Sync", date(2026, 1, 5, 9, 0, time.UTC), date(2026, 1, 5, 9, 30, time.UTC)},
+				{"Sync (moved)", date(2026, 1, 7, 14, 0, time.UTC), date(2026, 1, 7, 14, 30, time.UTC)},
+				{"Sync", date(2026, 1, 8, 9, 0, time.UTC), date(2026, 1, 8, 9, 30, time.UTC)},
+			},
+		},
+		{
+			name: "daily across a DST change keeps the local time",
+			data: calendar(eventLines(
+				"UID:a",
+				"SUMMARY:Call",
+				"DTSTART;TZID=Europe/Berlin:20260328T090000",
+				"DTEND;TZID=Europe/Berlin:20260328T100000",
+				"RRULE:FREQ=DAILY;UNTIL=20260329",
+			)),
+			want: []Event{
+				{"Call", date(2026, 3, 28, 8, 0, time.UTC), date(2026, 3, 28, 9, 0, time.UTC)},
+				{"Call", date(2026, 3, 29, 7, 0, time.UTC), date(2026, 3, 29, 8, 0, time.UTC)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unsupported, err := Parse(tt.data, from, to, time.UTC)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(unsupported) > 0 {
+				t.Errorf("unsupported = %v, want none", unsupported)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Summary != want.Summary || !got[i].Start.Equal(want.Start) || !got[i].End.Equal(want.End) {
+					t.Errorf("event %d = %v, want %v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	data := calendar(eventLines(
+		"UID:a",
+		"SUMMARY:Daily",
+		"DTSTART:20260101T090000Z",
+		"DTEND:20260101T100000Z",
+		"RRULE:FREQ=DAILY",
+	))
+	got, _, err := Parse(data, date(2026, 6, 1, 9, 30, time.UTC), date(2026, 6, 3, 0, 0, time.UTC), time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []time.Time{date(2026, 6, 1, 9, 0, time.UTC), date(2026, 6, 2, 9, 0, time.UTC)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want starts %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i]) {
+			t.Errorf("event %d starts %v, want %v", i, got[i].Start, want[i])
+		}
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	data := calendar(
+		eventLines(
+			"UID:a",
+			"SUMMARY:Board",
+			"DTSTART:20260105T090000Z", // the first Monday of January
+			"DTEND:20260105T100000Z",
+			"RRULE:FREQ=MONTHLY;BYDAY=1MO",
+		),
+		eventLines(
+			"UID:b",
+			"SUMMARY:Free",
+			"TRANSP:TRANSPARENT",
+			"DTSTART:20260105T090000Z",
+			"DTEND:20260105T100000Z",
+			"RRULE:FREQ=MONTHLY;BYMONTHDAY=1,15",
+		),
+	)
+	got, unsupported, err := Parse(data, date(2026, 1, 1, 0, 0, time.UTC), date(2027, 1, 1, 0, 0, time.UTC), time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %d events, want the first occurrence only", len(got))
+	}
+	if len(unsupported) != 1 || unsupported[0].Summary != "Board" || unsupported[0].Rule != "FREQ=MONTHLY;BYDAY=1MO" {
+		t.Errorf("unsupported = %v, want the busy monthly event", unsupported)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a calendar", []byte("BEGIN:VCARD\r\nEND:VCARD\r\n")},
+		{"bad start", calendar(eventLines("UID:a", "DTSTART:tomorrow"))},
+		{"bad duration", calendar(eventLines("UID:a", "DTSTART:20260105T090000Z", "DURATION:1H"))},
+		{"rule without FREQ", calendar(eventLines("UID:a", "DTSTART:20260105T090000Z", "RRULE:COUNT=3"))},
+		{"bad COUNT", calendar(eventLines("UID:a", "DTSTART:20260105T090000Z", "RRULE:FREQ=DAILY;COUNT=0"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(tt.data, time.Time{}, time.Now(), time.UTC); err == nil {
+				t.Fatal("Parse succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestBusy(t *testing.T) {
+	at := func(h, m int) time.Time { return date(2026, 1, 5, h, m, time.UTC) }
+	events := []Event{
+		{"A", at(9, 0), at(10, 0)},
+		{"B", at(9, 30), at(11, 0)},
+		{"C", at(11, 0), at(12, 0)},
+		{"D", at(14, 0), at(15, 0)},
+	}
+	tests := []struct {
+		name    string
+		at      time.Time
+		until   time.Time
+		summary string
+		ok      bool
+	}{
+		{"before any event", at(8, 0), time.Time{}, "", false},
+		{"chained events", at(9, 15), at(12, 0), "A", true},
+		{"second of overlapping", at(10, 30), at(12, 0), "B", true},
+		{"end is free", at(12, 0), time.Time{}, "", false},
+		{"gap", at(13, 0), time.Time{}, "", false},
+		{"last event", at(14, 0), at(15, 0), "D", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, summary, ok := Busy(events, tt.at)
+			if ok != tt.ok || summary != tt.summary || !until.Equal(tt.until) {
+				t.Errorf("Busy = %v, %q, %v; want %v, %q, %v", until, summary, ok, tt.until, tt.summary, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRuleEach(t *testing.T) {
+	start := date(2024, 1, 31, 9, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		limit int
+		want  []time.Time
+	}{
+		{
+			name:  "daily with interval",
+			rule:  "FREQ=DAILY;INTERVAL=2;COUNT=3",
+			start: start,
+			want:  []time.Time{start, start.AddDate(0, 0, 2), start.AddDate(0, 0, 4)},
+		},
+		{
+			name:  "weekly BYDAY every other week",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;COUNT=4",
+			start: start,
+			want: []time.Time{
+				date(2024, 2, 1, 9, 0, time.UTC),
+				date(2024, 2, 13, 9, 0, time.UTC),
+				date(2024, 2, 15, 9, 0, time.UTC),
+				date(2024, 2, 27, 9, 0, time.UTC),
+			},
+		},
+		{
+			name:  "monthly on the 31st",
+			rule:  "FREQ=MONTHLY;UNTIL=20240531T235959Z",
+			start: start,
+			want:  []time.Time{start, date(2024, 3, 31, 9, 0, time.UTC), date(2024, 5, 31, 9, 0, time.UTC)},
+		},
+		{
+			name:  "yearly on February 29th",
+			rule:  "FREQ=YEARLY;COUNT=2",
+			start: date(2024, 2, 29, 9, 0, time.UTC),
+			want:  []time.Time{date(2024, 2, 29, 9, 0, time.UTC), date(2028, 2, 29, 9, 0, time.UTC)},
+		},
+		{
+			name:  "date UNTIL includes the day",
+			rule:  "FREQ=DAILY;UNTIL=20240202",
+			start: start,
+			want:  []time.Time{start, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2)},
+		},
+		{
+			name:  "stops when fn returns false",
+			rule:  "FREQ=WEEKLY",
+			start: start,
+			limit: 2,
+			want:  []time.Time{start, start.AddDate(0, 0, 7)},
+		},
+		{
+			name:  "unsupported rule yields the start only",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: start,
+			want:  []time.Time{start},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRule(tt.rule, time.UTC)
+			if err != nil {
+				t.Fatalf("parseRule: %v", err)
+			}
+			var got []time.Time
+			r.each(tt.start, func(t time.Time) bool {
+				got = append(got, t)
+				return tt.limit == 0 || len(got) < tt.limit
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("occurrence %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package bridge
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/emiago/diago"
+)
+
+// ErrNotPending is returned when answering or declining a call that is not
+// waiting for the user's decision.
+var ErrNotPending = errors.New("call is not waiting for an answer")
+
+type inboundDecision int
+
+const (
+	decisionAnswer inboundDecision = iota
+	decisionDecline
+	decisionTimeout
+	decisionCancelled
+)
+
+// awaitInboundDecision tells the Telegram user about an incoming SIP call
+// with notice and waits until they answer or decline it, the confirm
+// timeout passes, or the caller hangs up.
+func (s *Service) awaitInboundDecision(dialog *diago.DialogServerSession, call *Call, notice string, logger *slog.Logger) inboundDecision {
+	return s.waitInboundDecision(dialog, call, func() {
+		s.announceRing(call, notice, logger)
+	}, logger)
+}
+
+// waitInboundDecision is awaitInboundDecision with announce telling the
+// user about the call.
+func (s *Service) waitInboundDecision(dialog *diago.DialogServerSession, call *Call, announce func(), logger *slog.Logger) inboundDecision {
+	decision := make(chan bool, 1)
+	call.mu.Lock()
+	call.decision = decision
+	call.mu.Unlock()
+	defer func() {
+		call.mu.Lock()
+		call.decision = nil
+		call.mu.Unlock()
+	}()
+
+	timeout := cmp.Or(call.ringing.Timeout, s.cfg.ConfirmInboundTimeout)
+	announce()
+	logger.Info("sip: waiting for telegram user to answer", "timeout", timeout)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case answer := <-decision:
+		if answer {
+			return decisionAnswer
+		}
+		return decisionDecline
+	case <-timer.C:
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s", displayParty(call.Name, call.Number)))
+		return decisionTimeout
+	case <-dialog.Context().Done():
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (caller hung up)", displayParty(call.Name, call.Number)))
+		return decisionCancelled
+	case <-call.Done():
+		return decisionCancelled
+	}
+}
+
+func inboundNotice(call *Call) string {
+	prompt := fmt.Sprintf("Reply /answer or /decline (call %s)", call.ID)
+	if len(call.sipHeaders) > 0 {
+		prompt = headerLines(call.sipHeaders) + "\n" + prompt
+	}
+	if notice := ringNotice(call); notice != "" {
+		return notice + "\n" + prompt
+	}
+	return fmt.Sprintf("Incoming call from %s to %s\n%s", displayParty(call.Name, call.Number), call.Local, prompt)
+}
+
+// AnswerCall accepts an inbound call waiting for confirmation; the bridge
+// then rings the Telegram user.
+func (s *Service) AnswerCall(call *Call) error {
+	return call.decide(true)
+}
+
+// DeclineCall rejects an inbound call waiting for confirmation with 603.
+func (s *Service) DeclineCall(call *Call) error {
+	return call.decide(false)
+}
+
+// PendingCall returns the most recent inbound call waiting for the user to
+// answer or decline it.
+func (s *Service) PendingCall() (*Call, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *Call
+	for _, c := range s.calls {
+		if c.pending() && (latest == nil || c.StartedAt.After(latest.StartedAt)) {
+			latest = c
+		}
+	}
+	return latest, latest != nil
+}
+
+func (c *Call) pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.decision != nil
+}
+
+func (c *Call) decide(answer bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.decision == nil {
+		return ErrNotPending
+	}
+	select {
+	case c.decision <- answer:
+		return nil
+	default:
+		return ErrNotPending
+	}
+}
@@ -0,0 +1,159 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/emiago/diago"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+)
+
+// InfoMessage is a message of an info line: a recording, or text spoken
+// with tts.command.
+type InfoMessage struct {
+	Prompt string `yaml:"prompt"`
+	Text   string `yaml:"text"`
+}
+
+// InfoLine is an announcement-only number (info_lines.<number>): callers
+// hear its message, optionally pick another one on the keypad, and are hung
+// up on. Telegram is never involved.
+type InfoLine struct {
+	InfoMessage `yaml:",inline"`
+	// Options maps DTMF input ("1", "*", "42") to the message played for it.
+	Options map[string]InfoMessage `yaml:"options"`
+	// Repeat is how often the message is played again when no option was
+	// picked (or the line has none).
+	Repeat int `yaml:"repeat"`
+}
+
+// infoLine is a loaded InfoLine.
+type infoLine struct {
+	message *audiofile.Clip
+	options map[string]*audiofile.Clip
+	// choices are the options as IVR actions for ivrCollect; Prompt holds
+	// the input.
+	choices map[string]IVRAction
+	repeat  int
+}
+
+// validateInfoLines checks the info_lines section of cfg.
+func validateInfoLines(cfg Config) error {
+	check := func(where string, m InfoMessage) error {
+		switch {
+		case m.Prompt == "" && m.Text == "":
+			return fmt.Errorf("%s needs a prompt or text", where)
+		case m.Prompt == "" && !cfg.ttsEnabled():
+			return fmt.Errorf("%s: text needs tts.command or tts.url", where)
+		}
+		return nil
+	}
+	for number, line := range cfg.InfoLines {
+		if phoneDigits(number) == "" {
+			return fmt.Errorf("info_lines: %q is not a number", number)
+		}
+		where := "info_lines." + number
+		if err := check(where, line.InfoMessage); err != nil {
+			return err
+		}
+		if line.Repeat < 0 || line.Repeat > 10 {
+			return fmt.Errorf("%s.repeat must be between 0 and 10", where)
+		}
+		if len(line.Options) > 0 && !cfg.EnableDTMF {
+			return fmt.Errorf("%s.options need sip.dtmf_enabled", where)
+		}
+		for input, m := range line.Options {
+			if input == "" || strings.Trim(input, "0123456789*#") != "" {
+				return fmt.Errorf("%s.options: %q is not a DTMF sequence", where, input)
+			}
+			if err := check(fmt.Sprintf("%s.options.%s", where, input), m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadInfoLines loads or synthesizes the messages of every info line.
+func (s *Service) loadInfoLines() error {
+	if len(s.cfg.InfoLines) == 0 {
+		return nil
+	}
+	load := func(m InfoMessage) (*audiofile.Clip, error) {
+		if m.Prompt != "" {
+			return audiofile.Load(m.Prompt, s.cfg.SampleRate)
+		}
+		return s.synthesize(context.Background(), m.Text)
+	}
+	s.infoLines = make(map[string]*infoLine, len(s.cfg.InfoLines))
+	for number, line := range s.cfg.InfoLines {
+		message, err := load(line.InfoMessage)
+		if err != nil {
+			return fmt.Errorf("info_lines.%s: %w", number, err)
+		}
+		l := &infoLine{message: message, repeat: line.Repeat}
+		if len(line.Options) > 0 {
+			l.options = make(map[string]*audiofile.Clip, len(line.Options))
+			l.choices = make(map[string]IVRAction, len(line.Options))
+			for input, m := range line.Options {
+				clip, err := load(m)
+				if err != nil {
+					return fmt.Errorf("info_lines.%s.options.%s: %w", number, input, err)
+				}
+				l.options[input] = clip
+				l.choices[input] = IVRAction{Action: IVRHangup, Prompt: input}
+			}
+		}
+		s.infoLines[number] = l
+	}
+	return nil
+}
+
+// infoLine returns the info line of the called number, if any.
+func (s *Service) infoLine(called string) (*infoLine, bool) {
+	line := lookupPhone(s.infoLines, called)
+	return line, line != nil
+}
+
+// runInfoLine answers an inbound call to an info line, plays its messages
+// and hangs up.
+func (s *Service) runInfoLine(dialog *diago.DialogServerSession, call *Call, answer diago.AnswerOptions, line *infoLine, logger *slog.Logger) {
+	answer.Video = ""
+	_, ok := s.answerIVR(dialog, call, answer, logger, func(ctx context.Context, leg *ivrLeg, call *Call, logger *slog.Logger) (string, bool) {
+		return IVRHangup, s.playInfoLine(ctx, leg, line, logger)
+	})
+	if !ok {
+		return
+	}
+	call.setCause(cdr.CauseAnnouncement)
+	hangupDialog(dialog, logger)
+}
+
+// playInfoLine plays the message of line, up to line.repeat more times
+// until the caller picks an option, and then that option's message. It
+// returns false when ctx ended first.
+func (s *Service) playInfoLine(ctx context.Context, leg *ivrLeg, line *infoLine, logger *slog.Logger) bool {
+	for play := 0; play <= line.repeat; play++ {
+		drainDigits(leg.digits)
+		if line.choices == nil {
+			if !s.ivrPlayClip(ctx, leg.bridge, line.message) {
+				return false
+			}
+			continue
+		}
+		leg.bridge.PlaySIP(line.message.Once())
+		choice, found, err := s.ivrCollect(ctx, leg.bridge, line.choices, leg.digits, nil, clipLength(line.message)+s.cfg.IVRTimeout, logger)
+		if err != nil {
+			return false
+		}
+		if found {
+			logger.Info("info line: option chosen", "input", choice.Prompt)
+			return s.ivrPlayClip(ctx, leg.bridge, line.options[choice.Prompt])
+		}
+	}
+	return true
+}
@@ -0,0 +1,429 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+)
+
+// IVR actions, the outcome of a menu option.
+const (
+	// IVRTelegram rings the Telegram user as without an IVR.
+	IVRTelegram = "telegram"
+	// IVRVoicemail records a message (needs voicemail.enabled).
+	IVRVoicemail = "voicemail"
+	// IVRMenu goes to IVRAction.Menu.
+	IVRMenu = "menu"
+	// IVRHangup ends the call.
+	IVRHangup = "hangup"
+	// IVRDirectory lets the caller spell the name of a directory entry on
+	// the keypad and rings that Telegram user.
+	IVRDirectory = "directory"
+)
+
+// IVRAction is what a menu option (or a menu's default) does.
+type IVRAction struct {
+	Action string `yaml:"action"`
+	// Menu is the menu IVRMenu goes to.
+	Menu string `yaml:"menu"`
+	// Prompt, when set, is played before the action is taken.
+	Prompt string `yaml:"prompt"`
+	// Words select the option when spoken (needs asr.url), e.g. "sales".
+	Words []string `yaml:"words"`
+}
+
+// IVRMenuConfig is one menu of the inbound IVR (ivr.menus.<name>).
+type IVRMenuConfig struct {
+	Prompt string `yaml:"prompt"`
+	// Options maps DTMF input ("1", "*", "42") to actions.
+	Options map[string]IVRAction `yaml:"options"`
+	// Retries is how often the prompt is repeated after invalid or missing
+	// input before Default is taken (hangup when unset).
+	Retries int       `yaml:"retries"`
+	Default IVRAction `yaml:"default"`
+}
+
+// validateIVR checks the menu tree in cfg.
+func validateIVR(cfg Config) error {
+	if _, ok := cfg.IVRMenus[cfg.IVRStart]; !ok {
+		return fmt.Errorf("ivr.start: no menu %q", cfg.IVRStart)
+	}
+	check := func(where string, a IVRAction) error {
+		switch a.Action {
+		case IVRTelegram, IVRHangup:
+		case IVRVoicemail:
+			if !cfg.VoicemailEnabled {
+				return fmt.Errorf("%s: action voicemail needs voicemail.enabled", where)
+			}
+		case IVRMenu:
+			if _, ok := cfg.IVRMenus[a.Menu]; !ok {
+				return fmt.Errorf("%s: no menu %q", where, a.Menu)
+			}
+		case IVRDirectory:
+			if len(cfg.Directory) == 0 {
+				return fmt.Errorf("%s: action directory needs directory.entries", where)
+			}
+		default:
+			return fmt.Errorf("%s: unknown action %q (want telegram, voicemail, directory, menu or hangup)", where, a.Action)
+		}
+		return nil
+	}
+	for name, menu := range cfg.IVRMenus {
+		if len(menu.Options) == 0 {
+			return fmt.Errorf("ivr.menus.%s: no options", name)
+		}
+		if menu.Retries < 0 {
+			return fmt.Errorf("ivr.menus.%s.retries must not be negative", name)
+		}
+		for input, action := range menu.Options {
+			if input == "" || strings.Trim(input, "0123456789*#") != "" {
+				return fmt.Errorf("ivr.menus.%s.options: %q is not a DTMF sequence", name, input)
+			}
+			if len(action.Words) > 0 && cfg.ASRURL == "" {
+				return fmt.Errorf("ivr.menus.%s.options.%s: words need asr.url", name, input)
+			}
+			if err := check(fmt.Sprintf("ivr.menus.%s.options.%s", name, input), action); err != nil {
+				return err
+			}
+		}
+		if menu.Default.Action != "" {
+			if err := check(fmt.Sprintf("ivr.menus.%s.default", name), menu.Default); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadIVR decodes every prompt of the menu tree.
+func (s *Service) loadIVR() error {
+	if s.cfg.IVRMenus == nil {
+		return nil
+	}
+	s.ivrPrompts = make(map[string]*audiofile.Clip)
+	load := func(path string) error {
+		if path == "" || s.ivrPrompts[path] != nil {
+			return nil
+		}
+		clip, err := audiofile.Load(path, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("ivr prompt: %w", err)
+		}
+		s.ivrPrompts[path] = clip
+		return nil
+	}
+	if err := load(s.cfg.IVRInvalidPrompt); err != nil {
+		return err
+	}
+	for _, menu := range s.cfg.IVRMenus {
+		if err := load(menu.Prompt); err != nil {
+			return err
+		}
+		if err := load(menu.Default.Prompt); err != nil {
+			return err
+		}
+		for _, action := range menu.Options {
+			if err := load(action.Prompt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ivrLeg is the answered SIP leg of an IVR call: prompts play on bridge,
+// keypad input arrives on digits and speech goes to speech (nil without
+// asr.url).
+type ivrLeg struct {
+	bridge *MediaBridge
+	digits chan rune
+	speech *ivrSpeech
+}
+
+// ivrFlow decides what an answered IVR call does next; see answerIVR.
+type ivrFlow func(ctx context.Context, leg *ivrLeg, call *Call, logger *slog.Logger) (action string, ok bool)
+
+// runIVR answers an inbound call with answer and walks the IVR menus until
+// the caller picks an action other than a submenu, or follows the
+// instructions of ivr.webhook.url. ok is false when the call ended first;
+// its cause is set then.
+func (s *Service) runIVR(dialog *diago.DialogServerSession, call *Call, answer diago.AnswerOptions, logger *slog.Logger) (action string, ok bool) {
+	if s.cfg.IVRWebhookURL != "" {
+		return s.answerIVR(dialog, call, answer, logger, s.ivrWebhook)
+	}
+	return s.answerIVR(dialog, call, answer, logger, s.ivrMenus)
+}
+
+// answerIVR answers an inbound call with answer and runs flow on it. ok is
+// false when the call ended first; its cause is set then.
+func (s *Service) answerIVR(dialog *diago.DialogServerSession, call *Call, answer diago.AnswerOptions, logger *slog.Logger, flow ivrFlow) (action string, ok bool) {
+	if err := dialog.AnswerOptions(answer); err != nil {
+		logger.Warn("ivr: answer failed", "error", err)
+		call.setCause(cdr.CauseSIPFailure)
+		return "", false
+	}
+	s.setCallState(call, CallAnswered)
+	call.setSIPDialog(dialog)
+
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		logger.Warn("ivr: sip media setup failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		return "", false
+	}
+	call.setCodec(sipMedia.Codec.Name)
+	var (
+		speech *ivrSpeech
+		sink   func([]byte) error
+	)
+	if s.cfg.ASRURL != "" {
+		speech = &ivrSpeech{}
+		sink = speech.write
+	}
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, newLocalPort(s.tgFormat(), sink), tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		logger.Warn("ivr: bridge init failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		return "", false
+	}
+	digits := make(chan rune, dtmfHistory)
+	bridge.OnDTMF(func(digit rune) {
+		select {
+		case digits <- digit:
+		default:
+		}
+	})
+	bridge.Start()
+	defer bridge.Stop()
+
+	ctx, cancel := context.WithCancel(dialog.Context())
+	defer cancel()
+	stop := context.AfterFunc(call.ctx, cancel)
+	defer stop()
+
+	action, ok = flow(ctx, &ivrLeg{bridge: bridge, digits: digits, speech: speech}, call, logger)
+	if !ok {
+		if call.ctx.Err() != nil {
+			call.setCause(cdr.CauseLocalHangup)
+		} else {
+			call.setCause(call.sipHangupCause())
+		}
+		return "", false
+	}
+	return action, true
+}
+
+// ivrMenus walks the menus of ivr.menus from ivr.start.
+func (s *Service) ivrMenus(ctx context.Context, leg *ivrLeg, call *Call, logger *slog.Logger) (string, bool) {
+	name := s.cfg.IVRStart
+	for {
+		logger.Info("ivr: menu", "menu", name)
+		next, ok := s.ivrMenu(ctx, leg.bridge, s.cfg.IVRMenus[name], leg.digits, leg.speech, logger)
+		if ok && next.Prompt != "" {
+			ok = s.ivrPlay(ctx, leg.bridge, next.Prompt)
+		}
+		if ok && next.Action == IVRDirectory {
+			var (
+				entry DirectoryEntry
+				found bool
+			)
+			entry, found, ok = s.ivrDirectory(ctx, leg.bridge, leg.digits, logger)
+			if ok && !found {
+				// Back to the menu the caller came from.
+				continue
+			}
+			if ok {
+				logger.Info("ivr: directory entry chosen", "name", entry.Name, "user_id", entry.UserID)
+				call.setChatID(entry.UserID)
+				next = IVRAction{Action: IVRTelegram}
+			}
+		}
+		if !ok {
+			logger.Info("ivr: call ended in menu", "menu", name)
+			return "", false
+		}
+		if next.Action != IVRMenu {
+			logger.Info("ivr: done", "menu", name, "action", next.Action)
+			return next.Action, true
+		}
+		name = next.Menu
+	}
+}
+
+// ivrMenu plays menu's prompt and collects input until it selects an option;
+// the prompt stops at the first digit or word. After menu.Retries failed
+// attempts the default action is returned. ok is false when ctx ended.
+func (s *Service) ivrMenu(ctx context.Context, bridge *MediaBridge, menu IVRMenuConfig, digits <-chan rune, speech *ivrSpeech, logger *slog.Logger) (IVRAction, bool) {
+	for attempt := 0; attempt <= menu.Retries; attempt++ {
+		if attempt > 0 && !s.ivrPlay(ctx, bridge, s.cfg.IVRInvalidPrompt) {
+			return IVRAction{}, false
+		}
+		drainDigits(digits)
+		wait := s.cfg.IVRTimeout
+		if clip := s.ivrPrompts[menu.Prompt]; clip != nil {
+			bridge.PlaySIP(clip.Once())
+			wait += clipLength(clip)
+		}
+		action, ok, err := s.ivrCollect(ctx, bridge, menu.Options, digits, speech, wait, logger)
+		if err != nil {
+			return IVRAction{}, false
+		}
+		if ok {
+			return action, true
+		}
+	}
+	if menu.Default.Action == "" {
+		return IVRAction{Action: IVRHangup}, true
+	}
+	return menu.Default, true
+}
+
+// ivrCollect reads digits until they select one of options (ok), cannot
+// select any, or input stops: wait for the first digit, ivr.digit_timeout
+// between digits. An option that is a prefix of a longer one is taken after
+// the digit timeout. With speech, an utterance that names one option's
+// words confidently enough selects it too; digits still work meanwhile.
+func (s *Service) ivrCollect(ctx context.Context, bridge *MediaBridge, options map[string]IVRAction, digits <-chan rune, speech *ivrSpeech, wait time.Duration, logger *slog.Logger) (action IVRAction, ok bool, err error) {
+	var (
+		heard    <-chan speechResult
+		speaking <-chan struct{}
+		rec      *recognizer
+	)
+	if speech != nil && hasSpeechOptions(options) {
+		recCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		rec = s.startRecognizer(recCtx, logger)
+		speech.listen(rec)
+		defer speech.stop()
+		heard, speaking = rec.result, rec.speaking
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	entered := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return IVRAction{}, false, ctx.Err()
+		case digit := <-digits:
+			bridge.PlaySIP(nil)
+			if heard != nil {
+				// Keypad input wins over speech.
+				speech.stop()
+				heard, speaking = nil, nil
+			}
+			entered += string(digit)
+			action, found, more := ivrMatch(options, entered)
+			if !more {
+				return action, found, nil
+			}
+			timer.Reset(s.cfg.IVRDigitTimeout)
+		case <-speaking:
+			// Barge-in: the caller talks over the prompt.
+			bridge.PlaySIP(nil)
+			speaking = nil
+		case res := <-heard:
+			heard = nil
+			if res.err != nil {
+				// Recognition is unavailable; keep waiting for digits.
+				continue
+			}
+			action, found := speechMatch(options, res.Text)
+			if found && res.Confidence < s.cfg.ASRMinConfidence {
+				found = false
+			}
+			logger.Info("ivr: speech input", "text", res.Text, "confidence", res.Confidence, "matched", found)
+			if entered == "" {
+				return action, found, nil
+			}
+		case <-timer.C:
+			if entered == "" && heard != nil && rec.inSpeech() {
+				// Let the caller finish the utterance.
+				continue
+			}
+			action, found, _ := ivrMatch(options, entered)
+			return action, found, nil
+		}
+	}
+}
+
+// ivrInput reads digits until #, a pause of ivr.digit_timeout, or wait
+// without any input, and returns them without the #.
+func (s *Service) ivrInput(ctx context.Context, bridge *MediaBridge, digits <-chan rune, wait time.Duration) (string, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var entered strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case digit := <-digits:
+			bridge.PlaySIP(nil)
+			if digit == '#' {
+				return entered.String(), nil
+			}
+			entered.WriteRune(digit)
+			timer.Reset(s.cfg.IVRDigitTimeout)
+		case <-timer.C:
+			return entered.String(), nil
+		}
+	}
+}
+
+// drainDigits drops digits pressed before the caller was asked for input.
+func drainDigits(digits <-chan rune) {
+	for len(digits) > 0 {
+		<-digits
+	}
+}
+
+// ivrMatch looks entered up in options; more reports whether a longer
+// option starts with it.
+func ivrMatch(options map[string]IVRAction, entered string) (action IVRAction, found, more bool) {
+	if entered == "" {
+		return IVRAction{}, false, false
+	}
+	for input, a := range options {
+		switch {
+		case input == entered:
+			action, found = a, true
+		case strings.HasPrefix(input, entered):
+			more = true
+		}
+	}
+	return action, found, more
+}
+
+// ivrPlay plays the prompt at path to the end; it returns false when ctx
+// ended first.
+func (s *Service) ivrPlay(ctx context.Context, bridge *MediaBridge, path string) bool {
+	return s.ivrPlayClip(ctx, bridge, s.ivrPrompts[path])
+}
+
+// ivrPlayClip plays clip (if any) like ivrPlay.
+func (s *Service) ivrPlayClip(ctx context.Context, bridge *MediaBridge, clip *audiofile.Clip) bool {
+	if clip == nil {
+		return ctx.Err() == nil
+	}
+	bridge.PlaySIP(clip.Once())
+	timer := time.NewTimer(clipLength(clip))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func clipLength(clip *audiofile.Clip) time.Duration {
+	return time.Duration(len(clip.Samples)) * time.Second / time.Duration(clip.SampleRate)
+}
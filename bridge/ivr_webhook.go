@@ -0,0 +1,536 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	msdk "github.com/livekit/media-sdk"
+
+	"gotgcalls/bridge/audiofile"
+)
+
+// Verbs of ivr.webhook.url instructions.
+const (
+	// webhookPlay plays File, a local path or an http(s) URL of a WAV or
+	// Ogg/Opus clip.
+	webhookPlay = "play"
+	// webhookSay speaks Text with tts.command.
+	webhookSay = "say"
+	// webhookPause is Seconds of silence.
+	webhookPause = "pause"
+	// webhookGather plays its Actions (play, say, pause) until the caller
+	// starts typing or talking, collects their input and posts it to URL.
+	// Without input the next instruction follows.
+	webhookGather = "gather"
+	// webhookRedirect fetches the next instructions from URL.
+	webhookRedirect = "redirect"
+	// webhookTelegram rings the Telegram user (or UserID).
+	webhookTelegram = "telegram"
+	// webhookVoicemail records a message (needs voicemail.enabled).
+	webhookVoicemail = "voicemail"
+	// webhookDial transfers the caller to Number (REFER).
+	webhookDial = "dial"
+	// webhookHangup ends the call.
+	webhookHangup = "hangup"
+)
+
+const (
+	// webhookMaxBody bounds an instruction document.
+	webhookMaxBody = 1 << 20
+	// webhookMaxRedirects bounds the documents fetched without the caller
+	// doing anything in between, against redirect loops.
+	webhookMaxRedirects = 10
+)
+
+// webhookRequest is posted to ivr.webhook.url (and gather/redirect URLs).
+type webhookRequest struct {
+	// Event is "incoming" for the first request of a call, "gather" with
+	// the caller's input or "redirect".
+	Event  string `json:"event"`
+	CallID string `json:"call_id"`
+	From   string `json:"from"`
+	Name   string `json:"name,omitempty"`
+	To     string `json:"to"`
+	// Digits or Speech (with its Confidence) is what the caller entered.
+	Digits     string  `json:"digits,omitempty"`
+	Speech     string  `json:"speech,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// webhookAction is one instruction of the document a webhook returns, as
+// JSON ({"actions": [{"verb": "say", "text": "Hello"}]}) or XML
+// (<Response><Say>Hello</Say></Response>).
+type webhookAction struct {
+	Verb string `json:"verb"`
+	File string `json:"file,omitempty"`
+	Text string `json:"text,omitempty"`
+	// Seconds is the length of a pause or how long a gather waits for
+	// input after its prompts (ivr.timeout when 0).
+	Seconds float64 `json:"seconds,omitempty"`
+	// NumDigits ends a gather after that many digits, FinishOnKey (default
+	// "#") at one of its keys. Speech lets the caller talk (needs asr.url).
+	NumDigits   int    `json:"num_digits,omitempty"`
+	FinishOnKey string `json:"finish_on_key,omitempty"`
+	Speech      bool   `json:"speech,omitempty"`
+	// URL is where a gather posts its input (ivr.webhook.url when empty)
+	// or a redirect goes; relative to the document's URL.
+	URL     string          `json:"url,omitempty"`
+	Number  string          `json:"number,omitempty"`
+	UserID  int64           `json:"user_id,omitempty"`
+	Actions []webhookAction `json:"actions,omitempty"`
+}
+
+// ivrWebhook runs the instructions ivr.webhook.url returns for the call. A
+// webhook that fails or returns nothing usable rings the Telegram user so
+// calls are not lost while it is down.
+func (s *Service) ivrWebhook(ctx context.Context, leg *ivrLeg, call *Call, logger *slog.Logger) (string, bool) {
+	req := webhookRequest{Event: "incoming", CallID: call.ID, From: call.Number, Name: call.Name, To: call.Local}
+	base := s.cfg.IVRWebhookURL
+	actions, err := s.fetchWebhook(ctx, base, req)
+	if err != nil {
+		return s.webhookFailed(ctx, err, logger)
+	}
+	clips := map[string]*audiofile.Clip{}
+	redirects := 0
+	for len(actions) > 0 {
+		a := actions[0]
+		actions = actions[1:]
+		logger.Debug("ivr webhook: instruction", "verb", a.Verb)
+		switch a.Verb {
+		case webhookPlay, webhookSay, webhookPause:
+			clip, err := s.webhookClip(ctx, a, clips)
+			if err != nil {
+				logger.Warn("ivr webhook: prompt failed", "verb", a.Verb, "error", err)
+				continue
+			}
+			if !s.ivrPlayClip(ctx, leg.bridge, clip) {
+				return "", false
+			}
+		case webhookGather:
+			prompt := s.webhookPrompt(ctx, a.Actions, clips, logger)
+			input, ok := s.webhookGather(ctx, leg, a, prompt, logger)
+			if !ok {
+				return "", false
+			}
+			if input.Digits == "" && input.Speech == "" {
+				continue
+			}
+			input.Event, input.CallID, input.From, input.Name, input.To = "gather", req.CallID, req.From, req.Name, req.To
+			logger.Info("ivr webhook: input", "digits", input.Digits, "speech", input.Speech)
+			if base, err = resolveWebhookURL(base, a.URL, s.cfg.IVRWebhookURL); err == nil {
+				actions, err = s.fetchWebhook(ctx, base, input)
+			}
+			if err != nil {
+				return s.webhookFailed(ctx, err, logger)
+			}
+			redirects = 0
+		case webhookRedirect:
+			if redirects++; redirects > webhookMaxRedirects {
+				logger.Warn("ivr webhook: too many redirects without input, hanging up")
+				return IVRHangup, true
+			}
+			next := req
+			next.Event = "redirect"
+			if base, err = resolveWebhookURL(base, a.URL, s.cfg.IVRWebhookURL); err == nil {
+				actions, err = s.fetchWebhook(ctx, base, next)
+			}
+			if err != nil {
+				return s.webhookFailed(ctx, err, logger)
+			}
+		case webhookTelegram:
+			if a.UserID != 0 {
+				call.setChatID(a.UserID)
+			}
+			return IVRTelegram, true
+		case webhookVoicemail:
+			if !s.voicemailEnabled() {
+				logger.Warn("ivr webhook: voicemail is not enabled, skipping")
+				continue
+			}
+			return IVRVoicemail, true
+		case webhookDial:
+			if err := s.Transfer(ctx, call, a.Number); err != nil {
+				logger.Warn("ivr webhook: dial failed", "number", a.Number, "error", err)
+				continue
+			}
+			// The caller leaves once connected to the target.
+			<-ctx.Done()
+			return "", false
+		case webhookHangup:
+			return IVRHangup, true
+		}
+	}
+	return IVRHangup, true
+}
+
+// webhookFailed rings the Telegram user after the webhook failed, unless
+// the call is over.
+func (s *Service) webhookFailed(ctx context.Context, err error, logger *slog.Logger) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	logger.Warn("ivr webhook failed, ringing telegram", "error", err)
+	return IVRTelegram, true
+}
+
+// fetchWebhook posts req to target and parses the instructions returned.
+func (s *Service) fetchWebhook(ctx context.Context, target string, req webhookRequest) ([]webhookAction, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.IVRWebhookTimeout)
+	defer cancel()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, application/xml")
+	for k, v := range s.cfg.IVRWebhookHeaders {
+		httpReq.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, webhookMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook returned %s: %s", res.Status, strings.TrimSpace(string(data[:min(len(data), 512)])))
+	}
+	return parseWebhookActions(data)
+}
+
+// resolveWebhookURL resolves ref against the URL of the current document;
+// empty ref means fallback.
+func resolveWebhookURL(base, ref, fallback string) (string, error) {
+	if ref == "" {
+		return fallback, nil
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook url %q: %w", ref, err)
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// parseWebhookActions decodes a JSON or XML instruction document.
+func parseWebhookActions(data []byte) ([]webhookAction, error) {
+	data = bytes.TrimSpace(data)
+	var actions []webhookAction
+	switch {
+	case len(data) == 0:
+		return nil, nil
+	case data[0] == '<':
+		var root webhookXMLNode
+		if err := xml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("webhook xml: %w", err)
+		}
+		actions = root.actions()
+	case data[0] == '[':
+		if err := json.Unmarshal(data, &actions); err != nil {
+			return nil, fmt.Errorf("webhook json: %w", err)
+		}
+	default:
+		var doc struct {
+			Actions []webhookAction `json:"actions"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("webhook json: %w", err)
+		}
+		actions = doc.Actions
+	}
+	if err := validateWebhookActions(actions, false); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func validateWebhookActions(actions []webhookAction, inGather bool) error {
+	for i := range actions {
+		a := &actions[i]
+		a.Verb = strings.ToLower(a.Verb)
+		switch a.Verb {
+		case webhookPlay:
+			if a.File == "" {
+				return fmt.Errorf("webhook: play without file")
+			}
+		case webhookSay:
+			if a.Text == "" {
+				return fmt.Errorf("webhook: say without text")
+			}
+		case webhookPause:
+			if a.Seconds <= 0 {
+				a.Seconds = 1
+			}
+		default:
+			if inGather {
+				return fmt.Errorf("webhook: %q inside gather (want play, say or pause)", a.Verb)
+			}
+		}
+		switch a.Verb {
+		case webhookPlay, webhookSay, webhookPause, webhookRedirect, webhookTelegram, webhookVoicemail, webhookHangup:
+		case webhookGather:
+			if err := validateWebhookActions(a.Actions, true); err != nil {
+				return err
+			}
+		case webhookDial:
+			if a.Number == "" {
+				return fmt.Errorf("webhook: dial without number")
+			}
+		default:
+			return fmt.Errorf("webhook: unknown verb %q", a.Verb)
+		}
+	}
+	return nil
+}
+
+// webhookXMLNode is an element of an XML instruction document. Verbs are
+// element names; the element text is the verb's main argument (play file,
+// say text, dial number, redirect URL) and attributes set the rest
+// (numDigits, finishOnKey, timeout, input="speech", action, userId, length).
+type webhookXMLNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr       `xml:",any,attr"`
+	Text     string           `xml:",chardata"`
+	Children []webhookXMLNode `xml:",any"`
+}
+
+func (n webhookXMLNode) actions() []webhookAction {
+	actions := make([]webhookAction, 0, len(n.Children))
+	for _, c := range n.Children {
+		actions = append(actions, c.action())
+	}
+	return actions
+}
+
+func (n webhookXMLNode) action() webhookAction {
+	a := webhookAction{Verb: strings.ToLower(n.XMLName.Local)}
+	text := strings.TrimSpace(n.Text)
+	switch a.Verb {
+	case webhookPlay:
+		a.File = text
+	case webhookSay:
+		a.Text = text
+	case webhookDial:
+		a.Number = text
+	case webhookRedirect:
+		a.URL = text
+	}
+	for _, attr := range n.Attrs {
+		v := strings.TrimSpace(attr.Value)
+		switch strings.ReplaceAll(strings.ToLower(attr.Name.Local), "_", "") {
+		case "numdigits":
+			a.NumDigits, _ = strconv.Atoi(v)
+		case "finishonkey":
+			a.FinishOnKey = v
+		case "timeout", "length", "seconds":
+			a.Seconds, _ = strconv.ParseFloat(v, 64)
+		case "input":
+			a.Speech = strings.Contains(strings.ToLower(v), "speech")
+		case "speech":
+			a.Speech, _ = strconv.ParseBool(v)
+		case "action", "url":
+			a.URL = v
+		case "userid":
+			a.UserID, _ = strconv.ParseInt(v, 10, 64)
+		case "number":
+			a.Number = v
+		case "file":
+			a.File = v
+		}
+	}
+	if a.Verb == webhookGather {
+		a.Actions = n.actions()
+	}
+	return a
+}
+
+// webhookClip loads the audio of a play, say or pause instruction; played
+// files are kept in clips for the rest of the call.
+func (s *Service) webhookClip(ctx context.Context, a webhookAction, clips map[string]*audiofile.Clip) (*audiofile.Clip, error) {
+	switch a.Verb {
+	case webhookSay:
+		return s.synthesize(ctx, a.Text)
+	case webhookPause:
+		rate := s.cfg.SampleRate
+		return &audiofile.Clip{Path: "pause", SampleRate: rate, Samples: make(msdk.PCM16Sample, int(a.Seconds*float64(rate)))}, nil
+	}
+	if clip := clips[a.File]; clip != nil {
+		return clip, nil
+	}
+	var (
+		clip *audiofile.Clip
+		err  error
+	)
+	if strings.HasPrefix(a.File, "http://") || strings.HasPrefix(a.File, "https://") {
+		clip, err = s.downloadClip(ctx, a.File)
+	} else {
+		clip, err = audiofile.Load(a.File, s.cfg.SampleRate)
+	}
+	if err != nil {
+		return nil, err
+	}
+	clips[a.File] = clip
+	return clip, nil
+}
+
+// downloadClip fetches and decodes a clip; the file type comes from the URL
+// or the Content-Type.
+func (s *Service) downloadClip(ctx context.Context, src string) (*audiofile.Clip, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.IVRWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", src, res.Status)
+	}
+	ext := path.Ext(req.URL.Path)
+	if ext == "" {
+		mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		switch mediaType {
+		case "audio/wav", "audio/x-wav", "audio/wave":
+			ext = ".wav"
+		case "audio/ogg", "audio/opus":
+			ext = ".ogg"
+		}
+	}
+	dir, err := os.MkdirTemp("", "sip-tg-ivr")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "prompt"+ext)
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, io.LimitReader(res.Body, 32<<20))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	clip, err := audiofile.Load(file, s.cfg.SampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	clip.Path = src
+	return clip, nil
+}
+
+// webhookPrompt joins the prompts of a gather into one clip so the caller
+// can interrupt all of them; nil without any.
+func (s *Service) webhookPrompt(ctx context.Context, actions []webhookAction, clips map[string]*audiofile.Clip, logger *slog.Logger) *audiofile.Clip {
+	var samples msdk.PCM16Sample
+	for _, a := range actions {
+		clip, err := s.webhookClip(ctx, a, clips)
+		if err != nil {
+			logger.Warn("ivr webhook: prompt failed", "verb", a.Verb, "error", err)
+			continue
+		}
+		samples = append(samples, clip.Samples...)
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	return &audiofile.Clip{Path: "gather prompt", SampleRate: s.cfg.SampleRate, Samples: samples}
+}
+
+// webhookGather plays prompt and collects digits until a's finish key, its
+// digit count, or a pause; with a.Speech a spoken answer counts too. ok is
+// false when ctx ended.
+func (s *Service) webhookGather(ctx context.Context, leg *ivrLeg, a webhookAction, prompt *audiofile.Clip, logger *slog.Logger) (input webhookRequest, ok bool) {
+	drainDigits(leg.digits)
+	wait := s.cfg.IVRTimeout
+	if a.Seconds > 0 {
+		wait = time.Duration(a.Seconds * float64(time.Second))
+	}
+	if prompt != nil {
+		leg.bridge.PlaySIP(prompt.Once())
+		wait += clipLength(prompt)
+	}
+	finish := a.FinishOnKey
+	if finish == "" {
+		finish = "#"
+	}
+	var (
+		heard    <-chan speechResult
+		speaking <-chan struct{}
+		rec      *recognizer
+	)
+	if a.Speech && leg.speech != nil {
+		recCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		rec = s.startRecognizer(recCtx, logger)
+		leg.speech.listen(rec)
+		defer leg.speech.stop()
+		heard, speaking = rec.result, rec.speaking
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var entered strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return webhookRequest{}, false
+		case digit := <-leg.digits:
+			leg.bridge.PlaySIP(nil)
+			if heard != nil {
+				leg.speech.stop()
+				heard, speaking = nil, nil
+			}
+			if strings.ContainsRune(finish, digit) {
+				return webhookRequest{Digits: entered.String()}, true
+			}
+			entered.WriteRune(digit)
+			if a.NumDigits > 0 && entered.Len() >= a.NumDigits {
+				return webhookRequest{Digits: entered.String()}, true
+			}
+			timer.Reset(s.cfg.IVRDigitTimeout)
+		case <-speaking:
+			leg.bridge.PlaySIP(nil)
+			speaking = nil
+		case res := <-heard:
+			heard = nil
+			if res.err == nil && entered.Len() == 0 && strings.TrimSpace(res.Text) != "" {
+				return webhookRequest{Speech: res.Text, Confidence: res.Confidence}, true
+			}
+		case <-timer.C:
+			if entered.Len() == 0 && heard != nil && rec.inSpeech() {
+				continue
+			}
+			return webhookRequest{Digits: entered.String()}, true
+		}
+	}
+}
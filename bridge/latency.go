@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+
+	"gotgcalls/bridge/probe"
+)
+
+// CallLatency is the audio delay of an active call per direction.
+//
+// The buffer values are audio queued inside the bridge. The loop values are
+// round trips of the latency probe through each leg (including the bridge's
+// own buffering on the way back) and are 0 until the far end has looped a
+// chirp back. Mouth-to-ear estimates need both loops: each leg's one-way
+// delay is taken as half its loop without the bridge buffering.
+type CallLatency struct {
+	CallID          string `json:"call_id"`
+	SIPToTGBufferMs int64  `json:"sip_to_tg_buffer_ms"`
+	TGToSIPBufferMs int64  `json:"tg_to_sip_buffer_ms"`
+	SIPLoopMs       int64  `json:"sip_loop_ms,omitempty"`
+	TGLoopMs        int64  `json:"tg_loop_ms,omitempty"`
+	SIPToTGMs       int64  `json:"sip_to_tg_ms,omitempty"`
+	TGToSIPMs       int64  `json:"tg_to_sip_ms,omitempty"`
+}
+
+func callLatency(id string, st MediaStats) CallLatency {
+	l := CallLatency{
+		CallID:          id,
+		SIPToTGBufferMs: st.SIPToTGBufferMs,
+		TGToSIPBufferMs: st.TGToSIPBufferMs,
+		SIPLoopMs:       st.SIPLoopMs,
+		TGLoopMs:        st.TGLoopMs,
+	}
+	if st.SIPLoopMs > 0 && st.TGLoopMs > 0 {
+		sipLeg := max(st.SIPLoopMs-st.SIPToTGBufferMs, 0) / 2
+		tgLeg := max(st.TGLoopMs-st.TGToSIPBufferMs, 0) / 2
+		l.SIPToTGMs = sipLeg + st.SIPToTGBufferMs + tgLeg
+		l.TGToSIPMs = tgLeg + st.TGToSIPBufferMs + sipLeg
+	}
+	return l
+}
+
+func (l CallLatency) String() string {
+	s := fmt.Sprintf("call %s latency: buffers sip->tg %dms, tg->sip %dms", l.CallID, l.SIPToTGBufferMs, l.TGToSIPBufferMs)
+	if l.SIPLoopMs > 0 || l.TGLoopMs > 0 {
+		s += fmt.Sprintf("; loops sip %s, tg %s", loopString(l.SIPLoopMs), loopString(l.TGLoopMs))
+	}
+	if l.SIPToTGMs > 0 {
+		s += fmt.Sprintf("; mouth-to-ear sip->tg ~%dms, tg->sip ~%dms", l.SIPToTGMs, l.TGToSIPMs)
+	}
+	return s
+}
+
+func loopString(ms int64) string {
+	if ms == 0 {
+		return "n/a"
+	}
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+// attachLatencyProbes enables latency_probe on both legs of bridge. Media
+// runs at the Telegram format in both directions, so the SIP leg always
+// gets a voiceband chirp that survives narrowband codecs.
+func (s *Service) attachLatencyProbes(bridge *MediaBridge) {
+	if !s.cfg.LatencyProbe {
+		return
+	}
+	rate := s.tgFormat().SampleRate
+	interval := s.cfg.LatencyProbeInterval
+	bridge.SetLatencyProbes(
+		probe.New(rate, probe.Voiceband, interval),
+		probe.New(rate, probe.BandFor(rate), interval),
+	)
+}
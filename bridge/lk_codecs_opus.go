@@ -3,12 +3,23 @@
 package bridge
 
 import (
-	"strings"
+	"errors"
+	"fmt"
 
 	msdk "github.com/livekit/media-sdk"
 	msdkopus "github.com/livekit/media-sdk/opus"
 	msdkrtp "github.com/livekit/media-sdk/rtp"
 	"github.com/livekit/protocol/logger"
+	prtp "github.com/pion/rtp"
+	"gopkg.in/hraban/opus.v2"
+)
+
+const (
+	// opusMaxFrameMs is the longest frame an Opus packet can carry.
+	opusMaxFrameMs = 120
+	// opusFECLossPct is the loss the encoder is told to expect with FEC on;
+	// Opus only spends bits on FEC data when it expects loss.
+	opusFECLossPct = 10
 )
 
 // Register Opus codec into media-sdk registry for SIP usage.
@@ -18,7 +29,7 @@ func init() {
 	log := logger.GetLogger()
 
 	register := func(sdpName string, channels int) {
-		msdk.RegisterCodec(msdkrtp.NewAudioCodec(msdk.CodecInfo{
+		codec := msdkrtp.NewAudioCodec(msdk.CodecInfo{
 			SDPName:      sdpName,
 			SampleRate:   48000,
 			RTPClockRate: 48000,
@@ -27,18 +38,15 @@ func init() {
 			Priority: 100,
 			FileExt:  "opus",
 		}, func(w msdk.PCM16Writer) msdk.WriteCloser[msdkopus.Sample] {
-			dec, err := msdkopus.Decode(w, channels, log)
-			if err != nil {
-				panic(err)
-			}
-			return &opusWriterWrap[msdkopus.Sample]{inner: dec}
+			return newOpusDecoder(w, channels, log)
 		}, func(w msdk.WriteCloser[msdkopus.Sample]) msdk.PCM16Writer {
-			enc, err := msdkopus.Encode(w, channels, log)
+			enc, err := newOpusEncoder(w, channels)
 			if err != nil {
 				panic(err)
 			}
-			return &opusPCM16WriterWrap{inner: enc}
-		}))
+			return enc
+		})
+		msdk.RegisterCodec(&opusCodec{AudioCodec: codec, channels: channels, log: log})
 	}
 
 	// IMPORTANT: do not register "opus/48000".
@@ -47,21 +55,189 @@ func init() {
 	register("opus/48000/1", 1)
 }
 
-// Match casing used by other codecs in pipeline strings.
-type opusWriterWrap[S any] struct{ inner msdk.WriteCloser[S] }
+// opusCodec is the media-sdk Opus codec with an RTP decoder that can
+// conceal lost packets (pipeline.LossConcealer).
+type opusCodec struct {
+	msdkrtp.AudioCodec
+	channels int
+	log      logger.Logger
+}
+
+func (c *opusCodec) DecodeRTP(w msdk.Writer[msdk.PCM16Sample], _ byte) msdkrtp.Handler {
+	return &opusRTPDecoder{dec: newOpusDecoder(msdk.NopCloser(w), c.channels, c.log)}
+}
+
+type opusRTPDecoder struct{ dec *opusDecoder }
+
+func (h *opusRTPDecoder) String() string {
+	return fmt.Sprintf("RTP(%d) -> %s", h.dec.SampleRate(), h.dec)
+}
+
+func (h *opusRTPDecoder) HandleRTP(_ *prtp.Header, payload []byte) error {
+	return h.dec.WriteSample(payload)
+}
+
+func (h *opusRTPDecoder) ConcealLoss(fec []byte) error {
+	return h.dec.conceal(fec)
+}
+
+// opusDecoder decodes Opus like media-sdk's decoder, and can also make up
+// frames for lost packets with the decoder's PLC or in-band FEC.
+type opusDecoder struct {
+	w    msdk.PCM16Writer
+	dec  *opus.Decoder
+	buf  msdk.PCM16Sample
+	buf2 msdk.PCM16Sample
+	log  logger.Logger
+
+	targetChannels int
+	channels       int
+
+	successiveErrorCount int
+}
+
+func newOpusDecoder(w msdk.PCM16Writer, targetChannels int, log logger.Logger) *opusDecoder {
+	return &opusDecoder{w: w, targetChannels: targetChannels, log: log}
+}
+
+func (d *opusDecoder) String() string {
+	return fmt.Sprintf("opus(decode) -> %s", d.w)
+}
+
+func (d *opusDecoder) SampleRate() int { return d.w.SampleRate() }
+func (d *opusDecoder) Close() error    { return d.w.Close() }
+
+func (d *opusDecoder) WriteSample(in msdkopus.Sample) error {
+	if len(in) == 0 {
+		return nil
+	}
+	if err := d.reset(opusChannels(in)); err != nil {
+		return err
+	}
+	n, err := d.dec.Decode(in, d.buf)
+	if err != nil {
+		// Ignore a few corrupt packets, like media-sdk does.
+		if !errors.Is(err, opus.ErrInvalidPacket) || d.successiveErrorCount >= 5 {
+			return err
+		}
+		d.log.Debugw("opus decoder failed decoding a sample")
+		d.successiveErrorCount++
+		return nil
+	}
+	d.successiveErrorCount = 0
+	return d.write(d.buf[:n*d.channels])
+}
+
+// conceal writes one 20ms frame in place of a missing packet, recovered
+// from the FEC data of fec (the next packet) when given.
+func (d *opusDecoder) conceal(fec []byte) error {
+	if d.dec == nil {
+		// Nothing to conceal before the first packet.
+		return nil
+	}
+	n := d.w.SampleRate() / msdkrtp.DefFramesPerSec * d.channels
+	// The frame size is taken from the capacity of the buffer.
+	pcm := d.buf[:n:n]
+	var err error
+	if len(fec) > 0 && opusChannels(fec) == d.channels {
+		err = d.dec.DecodeFEC(fec, pcm)
+	} else {
+		err = d.dec.DecodePLC(pcm)
+	}
+	if err != nil {
+		d.log.Debugw("opus loss concealment failed", "error", err)
+		return nil
+	}
+	return d.write(pcm)
+}
+
+// reset (re)creates the decoder for a stream of channels.
+func (d *opusDecoder) reset(channels int) error {
+	if d.dec != nil && d.channels == channels {
+		return nil
+	}
+	dec, err := opus.NewDecoder(d.w.SampleRate(), channels)
+	if err != nil {
+		d.log.Errorw("opus decoder failed to reset", err)
+		return err
+	}
+	d.dec = dec
+	d.channels = channels
+	d.buf = make(msdk.PCM16Sample, d.w.SampleRate()*opusMaxFrameMs/1000*channels)
+	return nil
+}
 
-func (w *opusWriterWrap[S]) String() string {
-	return strings.Replace(w.inner.String(), "OPUS(", "opus(", 1)
+// write passes decoded samples on in the target channel count.
+func (d *opusDecoder) write(out msdk.PCM16Sample) error {
+	if d.channels < d.targetChannels {
+		n2 := len(out) * 2
+		if len(d.buf2) < n2 {
+			d.buf2 = make(msdk.PCM16Sample, n2)
+		}
+		msdk.MonoToStereo(d.buf2, out)
+		out = d.buf2[:n2]
+	} else if d.channels > d.targetChannels {
+		n2 := len(out) / 2
+		if len(d.buf2) < n2 {
+			d.buf2 = make(msdk.PCM16Sample, n2)
+		}
+		msdk.StereoToMono(d.buf2, out)
+		out = d.buf2[:n2]
+	}
+	return d.w.WriteSample(out)
 }
-func (w *opusWriterWrap[S]) SampleRate() int       { return w.inner.SampleRate() }
-func (w *opusWriterWrap[S]) Close() error          { return w.inner.Close() }
-func (w *opusWriterWrap[S]) WriteSample(s S) error { return w.inner.WriteSample(s) }
 
-type opusPCM16WriterWrap struct{ inner msdk.PCM16Writer }
+// opusChannels reads the stereo flag of the TOC byte (RFC 6716 3.1).
+func opusChannels(packet []byte) int {
+	if packet[0]&0x04 != 0 {
+		return 2
+	}
+	return 1
+}
 
-func (w *opusPCM16WriterWrap) String() string {
-	return strings.Replace(w.inner.String(), "OPUS(", "opus(", 1)
+// opusEncoder encodes Opus like media-sdk's encoder; it can also add
+// in-band FEC (pipeline.FECEncoder).
+type opusEncoder struct {
+	w   msdk.WriteCloser[msdkopus.Sample]
+	enc *opus.Encoder
+	buf msdkopus.Sample
+}
+
+func newOpusEncoder(w msdk.WriteCloser[msdkopus.Sample], channels int) (*opusEncoder, error) {
+	enc, err := opus.NewEncoder(w.SampleRate(), channels, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+	return &opusEncoder{
+		w:   w,
+		enc: enc,
+		buf: make(msdkopus.Sample, w.SampleRate()/msdkrtp.DefFramesPerSec*channels),
+	}, nil
+}
+
+func (e *opusEncoder) String() string {
+	return fmt.Sprintf("opus(encode) -> %s", e.w)
+}
+
+func (e *opusEncoder) SampleRate() int { return e.w.SampleRate() }
+func (e *opusEncoder) Close() error    { return e.w.Close() }
+
+func (e *opusEncoder) WriteSample(in msdk.PCM16Sample) error {
+	n, err := e.enc.Encode(in, e.buf)
+	if err != nil {
+		return err
+	}
+	return e.w.WriteSample(e.buf[:n])
+}
+
+// SetFEC turns in-band FEC on or off.
+func (e *opusEncoder) SetFEC(on bool) error {
+	if err := e.enc.SetInBandFEC(on); err != nil {
+		return err
+	}
+	lossPct := 0
+	if on {
+		lossPct = opusFECLossPct
+	}
+	return e.enc.SetPacketLossPerc(lossPct)
 }
-func (w *opusPCM16WriterWrap) SampleRate() int                      { return w.inner.SampleRate() }
-func (w *opusPCM16WriterWrap) Close() error                         { return w.inner.Close() }
-func (w *opusPCM16WriterWrap) WriteSample(s msdk.PCM16Sample) error { return w.inner.WriteSample(s) }
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"sync"
+
+	"gotgcalls/bridge/pcm"
+)
+
+// localSpeakerFrames is how many frames speak can queue for the SIP party.
+const localSpeakerFrames = 5
+
+// localPort stands in for the Telegram leg when the bridge itself talks to
+// the SIP party (voicemail, IVR, echo): audio from SIP goes to sink, if any,
+// and the caller hears what is played with MediaBridge.PlaySIP and speak.
+type localPort struct {
+	format  pcm.AudioFormat
+	sink    func(frame []byte) error
+	speaker chan []byte
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newLocalPort(format pcm.AudioFormat, sink func(frame []byte) error) *localPort {
+	return &localPort{
+		format:  format,
+		sink:    sink,
+		speaker: make(chan []byte, localSpeakerFrames),
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *localPort) Format() pcm.AudioFormat      { return p.format }
+func (p *localPort) SpeakerFrames() <-chan []byte { return p.speaker }
+func (p *localPort) Done() <-chan struct{}        { return p.done }
+
+func (p *localPort) SendPCMFrame10ms(frame []byte) error {
+	if p.sink == nil {
+		return nil
+	}
+	return p.sink(frame)
+}
+
+// speak queues a frame for the SIP party, dropping it when the queue is
+// full.
+func (p *localPort) speak(frame []byte) {
+	select {
+	case p.speaker <- frame:
+	default:
+	}
+}
+
+// finish closes Done.
+func (p *localPort) finish() {
+	p.once.Do(func() { close(p.done) })
+}
@@ -0,0 +1,60 @@
+// Package logring keeps the last lines of the log in memory, so a crash
+// report can tell what led up to it.
+package logring
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Ring is an io.Writer that keeps the last lines written to it; put it
+// next to the log output with io.MultiWriter.
+type Ring struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+	// partial is the start of a line not finished yet.
+	partial []byte
+}
+
+// New returns a ring of the last n lines.
+func New(n int) *Ring {
+	return &Ring{lines: make([]string, max(n, 1))}
+}
+
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.partial = append(r.partial, data...)
+			break
+		}
+		r.add(string(append(r.partial, data[:i]...)))
+		r.partial = r.partial[:0]
+		data = data[i+1:]
+	}
+	return len(p), nil
+}
+
+func (r *Ring) add(line string) {
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Lines returns the lines kept, oldest first.
+func (r *Ring) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// HeaderLoop carries the loop ID of outbound INVITEs, see loopHeaders.
+const HeaderLoop = "X-Bridge-Loop"
+
+// ErrLoopDetected is returned for outbound calls the trunk routed back into
+// the bridge.
+var ErrLoopDetected = errors.New("call looped back into the bridge")
+
+// loopID identifies call in the INVITEs the bridge sends: the token of this
+// bridge instance and the call ID.
+func (s *Service) loopID(call *Call) string {
+	return s.loopToken + "-" + call.ID
+}
+
+// loopHeaders mark an outbound INVITE for call so that it is recognized
+// when the trunk routes it back in: the loop ID goes into an X-Bridge-Loop
+// header, the ICID of a P-Charging-Vector and the Call-ID, as trunks keep
+// some of them and drop or rewrite others.
+func (s *Service) loopHeaders(call *Call) []sip.Header {
+	id := s.loopID(call)
+	callID := sip.CallIDHeader(newCallID() + "-" + id)
+	return []sip.Header{
+		sip.NewHeader(HeaderLoop, id),
+		sip.NewHeader("P-Charging-Vector", "icid-value="+id),
+		&callID,
+	}
+}
+
+// loopedCall returns the ID of the call whose INVITE req is, if it carries
+// the loop ID of one sent by this bridge.
+func (s *Service) loopedCall(req *sip.Request) (string, bool) {
+	values := make([]string, 0, 3)
+	for _, name := range []string{HeaderLoop, "P-Charging-Vector"} {
+		for _, h := range req.GetHeaders(name) {
+			values = append(values, h.Value())
+		}
+	}
+	if h := req.CallID(); h != nil {
+		values = append(values, h.Value())
+	}
+	for _, v := range values {
+		i := strings.Index(v, s.loopToken+"-")
+		if i < 0 {
+			continue
+		}
+		id := v[i+len(s.loopToken)+1:]
+		if end := strings.IndexAny(id, " ;,\""); end >= 0 {
+			id = id[:end]
+		}
+		return id, true
+	}
+	return "", false
+}
+
+// rejectLoop refuses an inbound call that is an outbound call of this
+// bridge routed back by the trunk, and hangs up that outbound call. Both
+// would otherwise ring Telegram and feed each other's audio. It reports
+// whether the call was rejected.
+func (s *Service) rejectLoop(dialog *diago.DialogServerSession, call *Call, logger *slog.Logger) bool {
+	id, ok := s.loopedCall(dialog.InviteRequest)
+	if !ok {
+		return false
+	}
+	logger.Warn("sip: call rejected (loop detected)", "looped_call_id", id)
+	// Marked before the 482 goes out, which the trunk may relay to the
+	// outbound call first. Follow-me legs just fail on it; their caller
+	// stays.
+	out, outbound := s.Call(id)
+	outbound = outbound && out.Direction == CallOutbound
+	if outbound {
+		out.setCause(cdr.CauseLoopDetected)
+	}
+	call.setCause(cdr.CauseLoopDetected)
+	s.rejectInbound(dialog, sip.StatusLoopDetected, "Loop Detected", "", false, logger)
+	if outbound {
+		out.Hangup()
+	}
+	return true
+}
+
+// loopError returns ErrLoopDetected for err if call ended because it looped
+// back into the bridge.
+func loopError(call *Call, err error) error {
+	if call.hangupCause() == cdr.CauseLoopDetected {
+		return ErrLoopDetected
+	}
+	return err
+}
@@ -3,41 +3,188 @@ package bridge
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"math"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emiago/diago/media"
 	msdk "github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/dtmf"
+	msdkrtp "github.com/livekit/media-sdk/rtp"
 	"github.com/livekit/protocol/logger"
 	"github.com/pion/rtp"
 
+	"gotgcalls/bridge/audiofile"
 	"gotgcalls/bridge/endpoints"
 	"gotgcalls/bridge/pcm"
 	"gotgcalls/bridge/pipeline"
+	"gotgcalls/bridge/plugins"
+	"gotgcalls/bridge/probe"
+	"gotgcalls/bridge/recording"
 )
 
 type MediaBridge struct {
-	ctx           context.Context
-	cancel        context.CancelFunc
-	logger        *slog.Logger
-	sipFormat     pcm.AudioFormat
-	tgFormat      pcm.AudioFormat
-	sip           *endpoints.SipEndpoint
-	tg            *endpoints.TgEndpoint
+	ctx      context.Context
+	cancel   context.CancelFunc
+	logger   *slog.Logger
+	tgFormat pcm.AudioFormat
+	sip      atomic.Pointer[endpoints.SipEndpoint]
+	// tg is the Telegram side; ReplaceTG swaps it (tgRef, as the ports
+	// differ in type).
+	tg            atomic.Pointer[tgRef]
 	sipToTGBuffer *pcm.PCMPlayoutBuffer
 	driftTarget   int
 	driftMaxBurst int
+	// playoutTarget is the SIP->TG backlog writeTG steers toward:
+	// driftTarget, or more while adaptPlayout raises it for a lossy,
+	// jittery SIP leg.
+	playoutTarget atomic.Int64
+	adaptPlayout  bool
 	wg            sync.WaitGroup
 
 	// driftAcc accumulates how many 1-sample adjustments we should apply.
 	// Positive => consume extra samples (shrink backlog), negative => consume fewer (grow backlog).
 	driftAcc int
+
+	stats mediaCounters
+	// jitter counts for the SIP jitter buffer across decode chain rebuilds.
+	jitter pipeline.JitterStats
+
+	// sipGen changes when a re-INVITE renegotiates the codec, telling the SIP
+	// goroutines to rebuild their pipelines.
+	sipGen atomic.Uint64
+	// hold pauses audio in both directions while the SIP side holds the call.
+	hold atomic.Bool
+	// holdAudio, when set, is played to TG instead of silence while held.
+	holdAudio *audiofile.Loop
+	// muteToSIP and muteToTG pause a direction at the Telegram user's
+	// request (/mute, /hold): SIP gets comfort noise instead, TG silence.
+	muteToSIP atomic.Bool
+	muteToTG  atomic.Bool
+	// sipPrompt and tgPrompt are mixed into the audio sent to SIP and to
+	// TG until they end.
+	sipPrompt atomic.Pointer[audiofile.Once]
+	tgPrompt  atomic.Pointer[audiofile.Once]
+
+	// recorder taps both directions at the TG format while set; otherwise
+	// preRoll (if any) keeps the last seconds for a recording started later.
+	recorder atomic.Pointer[recording.Session]
+	preRoll  *recording.PreRoll
+	// dump, while set, writes the SIP RTP and the decoded audio of both
+	// directions for debugging.
+	dump atomic.Pointer[callDump]
+	// rtpLog, while set, records the notable events of the SIP RTP.
+	rtpLog atomic.Pointer[callRTPLog]
+	// transcript, while set, transcribes what each side says.
+	transcript atomic.Pointer[callTranscript]
+
+	// sipProbe and tgProbe, when set, time chirps looped back by each leg.
+	sipProbe *probe.Prober
+	tgProbe  *probe.Prober
+
+	// DTMF relay: digits from SIP are reported to onDTMF; in-band tones are
+	// mixed into the TG (tgTones) or SIP (sipTones) direction.
+	onDTMF     func(digit rune)
+	tgTones    *pcm.ToneMixer
+	sipTones   *pcm.ToneMixer
+	sipEncoder atomic.Pointer[pipeline.SipEncodePipeline]
+	dtmfMu     sync.Mutex
+
+	// onFault, when set, is told about a panic of a media goroutine (before
+	// it goes on) and about pipeline failures that stop a direction.
+	onFault func(reason string, stack []byte)
+
+	// Plugin stages process the audio sent to TG and to SIP.
+	toTGStages  []plugins.Stage
+	toSIPStages []plugins.Stage
+	// toTGAGC and toSIPAGC, when set, even out the level of each direction
+	// ahead of the plugin stages.
+	toTGAGC  *pcm.AGC
+	toSIPAGC *pcm.AGC
+	// toTGDenoise, when set, suppresses background noise of the SIP side.
+	toTGDenoise *pcm.NoiseSuppressor
+	// echoGuard, when set, attenuates audio from TG that echoes what was
+	// sent to TG.
+	echoGuard *pcm.EchoGuard
+
+	// toTGLevel and toSIPLevel meter the audio sent to each leg over the
+	// last levelWindow; levelLogEvery, when set, logs them periodically.
+	toTGLevel     *pcm.LevelMeter
+	toSIPLevel    *pcm.LevelMeter
+	levelLogEvery time.Duration
+}
+
+// levelWindow is the span of the rolling audio levels.
+const levelWindow = time.Second
+
+// mediaCounters are updated by the media goroutines and read by Stats.
+type mediaCounters struct {
+	sipPacketsIn   atomic.Uint64
+	sipPacketsLost atomic.Uint64
+	sipFramesOut   atomic.Uint64
+	tgFramesOut    atomic.Uint64
+	tgRealOut      atomic.Uint64
+	tgFramesIn     atomic.Uint64
+	sipToTGDropped atomic.Uint64
+	tgToSIPDropped atomic.Uint64
+	driftAdjPos    atomic.Uint64
+	driftAdjNeg    atomic.Uint64
+	lastEnergy     atomic.Uint64 // math.Float64bits
+	// From RTCP (see monitorRTCP).
+	sipJitter    atomic.Int64  // time.Duration
+	sipRTT       atomic.Int64  // time.Duration
+	remoteJitter atomic.Int64  // time.Duration
+	remoteLoss   atomic.Uint64 // math.Float64bits, percent
 }
 
-func NewMediaBridge(parent context.Context, logger *slog.Logger, sip *endpoints.SipEndpoint, tg *endpoints.TgEndpoint, driftTarget int, driftMaxBurst int) (*MediaBridge, error) {
+// MediaStats is a snapshot of per-call media counters.
+type MediaStats struct {
+	SIPPacketsReceived  uint64  `json:"sip_packets_received"`
+	SIPPacketsLost      uint64  `json:"sip_packets_lost"`
+	SIPFramesSent       uint64  `json:"sip_frames_sent"`
+	TGFramesSent        uint64  `json:"tg_frames_sent"`
+	TGRealFramesSent    uint64  `json:"tg_real_frames_sent"`
+	TGFramesReceived    uint64  `json:"tg_frames_received"`
+	SIPToTGDropped      uint64  `json:"sip_to_tg_dropped_frames"`
+	TGToSIPDropped      uint64  `json:"tg_to_sip_dropped_frames"`
+	SIPToTGQueueFrames  int     `json:"sip_to_tg_queue_frames"`
+	DriftAdjustPositive uint64  `json:"drift_adjust_positive"`
+	DriftAdjustNegative uint64  `json:"drift_adjust_negative"`
+	LastEnergy          float64 `json:"last_energy"`
+	// Audio queued inside the bridge per direction, and the round trip of
+	// each leg measured by the latency probe (0 when unknown).
+	SIPToTGBufferMs int64 `json:"sip_to_tg_buffer_ms"`
+	TGToSIPBufferMs int64 `json:"tg_to_sip_buffer_ms"`
+	SIPLoopMs       int64 `json:"sip_loop_ms,omitempty"`
+	TGLoopMs        int64 `json:"tg_loop_ms,omitempty"`
+	// SIP leg quality from RTCP: the jitter of the received stream, the
+	// round trip, and the loss and jitter the remote side reports for ours.
+	SIPJitterMs       float64 `json:"sip_jitter_ms"`
+	SIPRTTMs          int64   `json:"sip_rtt_ms,omitempty"`
+	SIPRemoteLossPct  float64 `json:"sip_remote_loss_pct"`
+	SIPRemoteJitterMs float64 `json:"sip_remote_jitter_ms"`
+	// SIPToTGTargetFrames is the backlog the SIP->TG playout steers toward.
+	SIPToTGTargetFrames int `json:"sip_to_tg_target_frames"`
+	// The adaptive SIP jitter buffer: its current delay, the packets that
+	// came too late for it, and how often it was resized.
+	JitterBufferMs      int64  `json:"jitter_buffer_ms"`
+	JitterBufferLate    uint64 `json:"jitter_buffer_late_packets"`
+	JitterBufferResizes uint64 `json:"jitter_buffer_resizes"`
+	// Audio levels sent to each leg over the last second, in dBFS (-96 is
+	// digital silence): a leg that only gets silence points at the other
+	// leg's input.
+	SIPToTGRMSDBFS  float64 `json:"sip_to_tg_rms_dbfs"`
+	SIPToTGPeakDBFS float64 `json:"sip_to_tg_peak_dbfs"`
+	TGToSIPRMSDBFS  float64 `json:"tg_to_sip_rms_dbfs"`
+	TGToSIPPeakDBFS float64 `json:"tg_to_sip_peak_dbfs"`
+}
+
+func NewMediaBridge(parent context.Context, logger *slog.Logger, sip *endpoints.SipEndpoint, tg endpoints.TgPort, driftTarget int, driftMaxBurst int) (*MediaBridge, error) {
 	ctx, cancel := context.WithCancel(parent)
 	if logger == nil {
 		logger = slog.Default()
@@ -50,73 +197,511 @@ func NewMediaBridge(parent context.Context, logger *slog.Logger, sip *endpoints.
 	if driftMaxBurst < 1 {
 		driftMaxBurst = 1
 	}
-	sipFormat := sip.Format()
 	tgFormat := tg.Format()
-	return &MediaBridge{
-		ctx:       ctx,
-		cancel:    cancel,
-		logger:    logger,
-		sipFormat: sipFormat,
-		tgFormat:  tgFormat,
-		sip:       sip,
-		tg:        tg,
+	b := &MediaBridge{
+		ctx:      ctx,
+		cancel:   cancel,
+		logger:   logger,
+		tgFormat: tgFormat,
 		// PCM playout buffer decouples bursty SIP decode from TG real-time pacing.
 		sipToTGBuffer: pcm.NewPCMPlayoutBuffer(tgFormat.FrameBytes()),
 		driftTarget:   driftTarget,
 		driftMaxBurst: driftMaxBurst,
-	}, nil
+		tgTones:       pcm.NewToneMixer(tgFormat.SampleRate),
+		sipTones:      pcm.NewToneMixer(tgFormat.SampleRate),
+		toTGLevel:     pcm.NewLevelMeter(int(levelWindow / tgFormat.FrameDur)),
+		toSIPLevel:    pcm.NewLevelMeter(int(levelWindow / tgFormat.FrameDur)),
+	}
+	b.sip.Store(sip)
+	b.tg.Store(&tgRef{tg})
+	b.hold.Store(sip.OnHold)
+	b.playoutTarget.Store(int64(driftTarget))
+	return b, nil
 }
 
 func (b *MediaBridge) Start() {
+	sipFormat := b.sip.Load().Format()
 	b.logger.Info("media bridge starting",
-		"sip_rate", b.sipFormat.SampleRate,
+		"sip_rate", sipFormat.SampleRate,
 		"tg_rate", b.tgFormat.SampleRate,
-		"sip_frame_size", b.sipFormat.FrameBytes(),
+		"sip_frame_size", sipFormat.FrameBytes(),
 		"tg_frame_size", b.tgFormat.FrameBytes(),
 	)
-	b.wg.Add(3)
+	b.wg.Add(4)
 	go b.readSIP()
 	go b.writeTG()
 	go b.writeSIP()
+	go b.monitorRTCP()
+	if b.levelLogEvery > 0 {
+		b.wg.Add(1)
+		go b.logLevels()
+	}
+}
+
+// OnDTMF sets the handler for RFC 4733 digits received from SIP. Must be
+// called before Start.
+func (b *MediaBridge) OnDTMF(fn func(digit rune)) {
+	b.onDTMF = fn
+}
+
+// OnFault sets the handler for panics and fatal pipeline errors of the
+// media goroutines. Must be called before Start.
+func (b *MediaBridge) OnFault(fn func(reason string, stack []byte)) {
+	b.onFault = fn
+}
+
+// fault reports a pipeline failure that stops a direction of the call.
+func (b *MediaBridge) fault(reason string, err error) {
+	if b.onFault != nil && b.ctx.Err() == nil {
+		b.onFault(fmt.Sprintf("%s: %v", reason, err), nil)
+	}
+}
+
+// recoverFault, deferred by the media goroutines, reports a panic and
+// panics on.
+func (b *MediaBridge) recoverFault(goroutine string) {
+	if v := recover(); v != nil {
+		if b.onFault != nil {
+			b.onFault(fmt.Sprintf("panic in %s: %v", goroutine, v), debug.Stack())
+		}
+		panic(v)
+	}
+}
+
+// PlayTGTones mixes in-band DTMF tones for digits into the audio sent to Telegram.
+func (b *MediaBridge) PlayTGTones(digits string) {
+	b.tgTones.EnqueueDigits(digits)
+}
+
+// SendDTMF sends digits to the SIP party as RFC 4733 telephone-events, or as
+// in-band tones when telephone-event wasn't negotiated.
+func (b *MediaBridge) SendDTMF(digits string) error {
+	sip := b.sip.Load()
+	if !sip.HasDTMF {
+		b.sipTones.EnqueueDigits(digits)
+		return nil
+	}
+	enc := b.sipEncoder.Load()
+	if enc == nil {
+		return errors.New("sip encoder not ready")
+	}
+	go func() {
+		defer b.recoverFault("sendDTMF")
+		// Serialize so digit sequences don't interleave.
+		b.dtmfMu.Lock()
+		defer b.dtmfMu.Unlock()
+		clockRate := sip.DTMFClockRate
+		if clockRate == 0 {
+			clockRate = dtmf.SampleRate
+		}
+		events := enc.Seq.NewStream(sip.DTMFPayloadType, clockRate)
+		err := writeTelephoneEvents(b.ctx, events, clockRate, enc.Stream.GetCurrentTimestamp(), digits)
+		if err != nil && b.ctx.Err() == nil {
+			b.logger.Warn("sip dtmf send failed", "error", err, "digits", digits)
+		}
+	}()
+	return nil
+}
+
+// UpdateSIP applies a renegotiated SIP media session (re-INVITE). Hold state
+// takes effect immediately; a codec change restarts the SIP pipelines.
+func (b *MediaBridge) UpdateSIP(sip *endpoints.SipEndpoint) {
+	prev := b.sip.Swap(sip)
+	if wasHeld := b.hold.Swap(sip.OnHold); wasHeld != sip.OnHold {
+		b.logger.Info("sip hold state changed", "on_hold", sip.OnHold)
+	}
+	if !prev.Same(sip) {
+		b.logger.Info("sip media renegotiated", "codec", sip.Codec.Name, "payload_type", sip.Codec.PayloadType, "remote", sip.RemoteAddr)
+		b.sipGen.Add(1)
+	}
+}
+
+// ReplaceSIP switches the bridge to a different SIP dialog (after a
+// transfer), restarting the SIP pipelines even if the codec is unchanged.
+func (b *MediaBridge) ReplaceSIP(sip *endpoints.SipEndpoint) {
+	b.sip.Store(sip)
+	b.hold.Store(sip.OnHold)
+	b.logger.Info("sip leg replaced", "codec", sip.Codec.Name, "remote", sip.RemoteAddr)
+	b.sipGen.Add(1)
+}
+
+type tgRef struct{ endpoints.TgPort }
+
+func (b *MediaBridge) tgPort() endpoints.TgPort {
+	return b.tg.Load().TgPort
+}
+
+// ReplaceTG switches the bridge to a different Telegram side (/conference
+// moving the call into a voice chat). It must have the same format.
+func (b *MediaBridge) ReplaceTG(tg endpoints.TgPort) error {
+	if tg.Format() != b.tgFormat {
+		return fmt.Errorf("telegram format %+v differs from %+v", tg.Format(), b.tgFormat)
+	}
+	b.tg.Store(&tgRef{tg})
+	b.logger.Info("telegram leg replaced")
+	return nil
+}
+
+// SetHoldAudio sets the music played to TG while the SIP side holds the
+// call. The loop must be at the TG sample rate. Must be called before Start.
+func (b *MediaBridge) SetHoldAudio(loop *audiofile.Loop) {
+	b.holdAudio = loop
+}
+
+// PlaySIP mixes a one-shot clip (at the TG sample rate) into the audio sent
+// to the SIP party, replacing any clip still playing; nil stops it.
+func (b *MediaBridge) PlaySIP(prompt *audiofile.Once) {
+	b.sipPrompt.Store(prompt)
+}
+
+// PlayTG mixes a one-shot clip (at the TG sample rate) into the audio sent
+// to TG, replacing any clip still playing; nil stops it.
+func (b *MediaBridge) PlayTG(prompt *audiofile.Once) {
+	b.tgPrompt.Store(prompt)
+}
+
+// OnHold reports whether the SIP side currently holds the call.
+func (b *MediaBridge) OnHold() bool {
+	return b.hold.Load()
+}
+
+// SetMute pauses or resumes the audio sent to SIP and to TG. Both legs stay
+// up; the SIP side hears comfort noise while paused.
+func (b *MediaBridge) SetMute(toSIP, toTG bool) {
+	b.muteToSIP.Store(toSIP)
+	b.muteToTG.Store(toTG)
+}
+
+// Muted reports the directions SetMute paused.
+func (b *MediaBridge) Muted() (toSIP, toTG bool) {
+	return b.muteToSIP.Load(), b.muteToTG.Load()
 }
 
 func (b *MediaBridge) Stop() {
 	b.logger.Info("media bridge stopping")
 	b.cancel()
 	b.wg.Wait()
+	if rec := b.recorder.Load(); rec != nil {
+		b.stopRecording(rec)
+	}
+	b.StopDump()
+	b.StopRTPLog()
+	b.StopTranscript()
+	for _, st := range append(b.toTGStages, b.toSIPStages...) {
+		if err := st.Close(); err != nil {
+			b.logger.Warn("plugin stage close failed", "error", err)
+		}
+	}
 	b.logger.Info("media bridge stopped")
 }
 
+// SetPreRoll makes the bridge buffer the audio a recording started later
+// begins with. Must be called before Start.
+func (b *MediaBridge) SetPreRoll(p *recording.PreRoll) {
+	b.preRoll = p
+}
+
+// SetStages adds plugin stages to the audio sent to TG and to SIP; the
+// bridge closes them when it stops. Must be called before Start.
+func (b *MediaBridge) SetStages(toTG, toSIP []plugins.Stage) {
+	b.toTGStages, b.toSIPStages = toTG, toSIP
+}
+
+// SetAGC adds automatic gain control to the audio sent to TG and to SIP;
+// either may be nil. Must be called before Start.
+func (b *MediaBridge) SetAGC(toTG, toSIP *pcm.AGC) {
+	b.toTGAGC, b.toSIPAGC = toTG, toSIP
+}
+
+// SetNoiseSuppressor suppresses noise in the audio sent to TG. Must be
+// called before Start.
+func (b *MediaBridge) SetNoiseSuppressor(ns *pcm.NoiseSuppressor) {
+	b.toTGDenoise = ns
+}
+
+// SetEchoGuard attenuates audio from TG that is an echo of the audio sent
+// to TG. Must be called before Start.
+func (b *MediaBridge) SetEchoGuard(g *pcm.EchoGuard) {
+	b.echoGuard = g
+}
+
+// SetLevelLog logs the audio levels of both directions every interval.
+// Must be called before Start.
+func (b *MediaBridge) SetLevelLog(interval time.Duration) {
+	b.levelLogEvery = interval
+}
+
+// SetLatencyProbes enables the loopback latency probe on the SIP and
+// Telegram legs. Must be called before Start.
+func (b *MediaBridge) SetLatencyProbes(sip, tg *probe.Prober) {
+	b.sipProbe, b.tgProbe = sip, tg
+}
+
+// StartRecording attaches rec, after writing any pre-roll audio to it; it
+// returns false if a recording is already active.
+func (b *MediaBridge) StartRecording(rec *recording.Session) bool {
+	if b.Recording() {
+		return false
+	}
+	if b.preRoll != nil {
+		if err := b.preRoll.Flush(rec); err != nil {
+			b.logger.Warn("recording pre-roll write failed", "error", err)
+		}
+	}
+	if !b.recorder.CompareAndSwap(nil, rec) {
+		return false
+	}
+	b.logger.Info("recording started", "files", rec.Files())
+	return true
+}
+
+// StopRecording detaches and finalizes the active recording, if any.
+func (b *MediaBridge) StopRecording() *recording.Session {
+	rec := b.recorder.Load()
+	if rec == nil || !b.stopRecording(rec) {
+		return nil
+	}
+	return rec
+}
+
+func (b *MediaBridge) stopRecording(rec *recording.Session) bool {
+	if !b.recorder.CompareAndSwap(rec, nil) {
+		return false
+	}
+	if err := rec.Close(); err != nil {
+		b.logger.Warn("recording close failed", "error", err)
+	}
+	if b.preRoll != nil {
+		b.preRoll.Resume()
+	}
+	if n := rec.Dropped(); n > 0 {
+		b.logger.Warn("recording dropped frames, the disk fell behind", "frames", n)
+	}
+	b.logger.Info("recording stopped", "files", rec.Files())
+	return true
+}
+
+// Recording reports whether a recording is active.
+func (b *MediaBridge) Recording() bool {
+	return b.recorder.Load() != nil
+}
+
+// StartDump attaches d; it returns false if a dump is active.
+func (b *MediaBridge) StartDump(d *callDump) bool {
+	if !b.dump.CompareAndSwap(nil, d) {
+		return false
+	}
+	b.logger.Info("debug dump started", "files", d.Files())
+	return true
+}
+
+// StopDump detaches and finalizes the active dump, if any.
+func (b *MediaBridge) StopDump() *callDump {
+	d := b.dump.Load()
+	if d == nil || !b.dump.CompareAndSwap(d, nil) {
+		return nil
+	}
+	if err := d.Close(); err != nil {
+		b.logger.Warn("debug dump close failed", "error", err)
+	}
+	b.logger.Info("debug dump stopped", "files", d.Files())
+	return d
+}
+
+// StartRTPLog attaches l; it returns false if an RTP event log is active.
+func (b *MediaBridge) StartRTPLog(l *callRTPLog) bool {
+	if !b.rtpLog.CompareAndSwap(nil, l) {
+		return false
+	}
+	b.logger.Info("rtp event log started", "file", l.path)
+	return true
+}
+
+// StopRTPLog detaches the active RTP event log, if any, and writes it.
+func (b *MediaBridge) StopRTPLog() *callRTPLog {
+	l := b.rtpLog.Load()
+	if l == nil || !b.rtpLog.CompareAndSwap(l, nil) {
+		return nil
+	}
+	if err := l.log.WriteFile(l.path, l.info); err != nil {
+		b.logger.Warn("rtp event log write failed", "file", l.path, "error", err)
+	}
+	summary := l.log.Summary()
+	b.logger.Info("rtp event log written", "file", l.path, "packets", summary.Packets, "lost", summary.Lost, "events", summary.Events)
+	return l
+}
+
+// StartTranscript attaches t; it returns false if a transcript is active.
+func (b *MediaBridge) StartTranscript(t *callTranscript) bool {
+	if !b.transcript.CompareAndSwap(nil, t) {
+		return false
+	}
+	b.logger.Info("transcription started", "legs", t.legs)
+	return true
+}
+
+// StopTranscript detaches and stops the active transcript, if any.
+func (b *MediaBridge) StopTranscript() *callTranscript {
+	t := b.transcript.Load()
+	if t == nil || !b.transcript.CompareAndSwap(t, nil) {
+		return nil
+	}
+	t.Close()
+	b.logger.Info("transcription stopped")
+	return t
+}
+
+// Transcribing reports whether a transcript is active.
+func (b *MediaBridge) Transcribing() bool {
+	return b.transcript.Load() != nil
+}
+
+// Dumping reports whether a debug dump is active.
+func (b *MediaBridge) Dumping() bool {
+	return b.dump.Load() != nil
+}
+
+// dumpFailed stops d after a write error.
+func (b *MediaBridge) dumpFailed(d *callDump, err error) {
+	b.logger.Warn("debug dump write failed", "error", err)
+	if b.dump.CompareAndSwap(d, nil) {
+		_ = d.Close()
+	}
+}
+
+// dumpRTPWriter passes the RTP sent to SIP through to the active dump.
+type dumpRTPWriter struct {
+	media.RTPWriter
+	b  *MediaBridge
+	pt uint8
+}
+
+func (w dumpRTPWriter) WriteRTP(p *rtp.Packet) error {
+	if d := w.b.dump.Load(); d != nil {
+		if err := d.rtp.Packet(true, &p.Header, p.Payload, p.PayloadType == w.pt); err != nil {
+			w.b.dumpFailed(d, err)
+		}
+	}
+	return w.RTPWriter.WriteRTP(p)
+}
+
+func (b *MediaBridge) Stats() MediaStats {
+	st := MediaStats{
+		SIPPacketsReceived:  b.stats.sipPacketsIn.Load(),
+		SIPPacketsLost:      b.stats.sipPacketsLost.Load(),
+		SIPFramesSent:       b.stats.sipFramesOut.Load(),
+		TGFramesSent:        b.stats.tgFramesOut.Load(),
+		TGRealFramesSent:    b.stats.tgRealOut.Load(),
+		TGFramesReceived:    b.stats.tgFramesIn.Load(),
+		SIPToTGDropped:      b.stats.sipToTGDropped.Load(),
+		TGToSIPDropped:      b.stats.tgToSIPDropped.Load(),
+		SIPToTGQueueFrames:  b.sipToTGBuffer.LenFrames(),
+		DriftAdjustPositive: b.stats.driftAdjPos.Load(),
+		DriftAdjustNegative: b.stats.driftAdjNeg.Load(),
+		LastEnergy:          math.Float64frombits(b.stats.lastEnergy.Load()),
+		SIPToTGBufferMs:     (time.Duration(b.sipToTGBuffer.LenFrames()) * b.tgFormat.FrameDur).Milliseconds(),
+		TGToSIPBufferMs:     (time.Duration(len(b.tgPort().SpeakerFrames())) * b.tgFormat.FrameDur).Milliseconds(),
+		SIPLoopMs:           loopMs(b.sipProbe),
+		TGLoopMs:            loopMs(b.tgProbe),
+		SIPJitterMs:         durationMs(time.Duration(b.stats.sipJitter.Load())),
+		SIPRTTMs:            time.Duration(b.stats.sipRTT.Load()).Milliseconds(),
+		SIPRemoteLossPct:    math.Float64frombits(b.stats.remoteLoss.Load()),
+		SIPRemoteJitterMs:   durationMs(time.Duration(b.stats.remoteJitter.Load())),
+		SIPToTGTargetFrames: int(b.playoutTarget.Load()),
+		JitterBufferMs:      b.jitter.Depth().Milliseconds(),
+		JitterBufferLate:    b.jitter.Late(),
+		JitterBufferResizes: b.jitter.Resizes(),
+	}
+	st.SIPToTGRMSDBFS, st.SIPToTGPeakDBFS = b.toTGLevel.Levels()
+	st.TGToSIPRMSDBFS, st.TGToSIPPeakDBFS = b.toSIPLevel.Levels()
+	return st
+}
+
+// logLevels logs the audio levels of both directions every levelLogEvery.
+func (b *MediaBridge) logLevels() {
+	defer b.wg.Done()
+	defer b.recoverFault("logLevels")
+	ticker := time.NewTicker(b.levelLogEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			toTGRMS, toTGPeak := b.toTGLevel.Levels()
+			toSIPRMS, toSIPPeak := b.toSIPLevel.Levels()
+			b.logger.Info("audio levels",
+				"sip_to_tg_rms_dbfs", toTGRMS,
+				"sip_to_tg_peak_dbfs", toTGPeak,
+				"tg_to_sip_rms_dbfs", toSIPRMS,
+				"tg_to_sip_peak_dbfs", toSIPPeak,
+			)
+		}
+	}
+}
+
+func loopMs(p *probe.Prober) int64 {
+	if p == nil {
+		return 0
+	}
+	rtt, ok := p.RoundTrip()
+	if !ok {
+		return 0
+	}
+	return rtt.Milliseconds()
+}
+
+func (b *MediaBridge) buildSipDecodeChain(sip *endpoints.SipEndpoint) (msdkrtp.HandlerCloser, error) {
+	// Build LiveKit-like pipeline: jitter -> silence filler -> codec decode -> TG playout buffer.
+	return pipeline.BuildSipDecodeChain(pipeline.SipDecodeConfig{
+		Codec:         sip.LKCodec,
+		PayloadType:   sip.PayloadType(),
+		InputChannels: sip.Channels,
+		OutputFormat:  b.tgFormat,
+		PlayoutBuffer: b.sipToTGBuffer,
+		JitterMin:     sip.JitterMin,
+		JitterMax:     sip.JitterMax,
+		JitterStats:   &b.jitter,
+		PLC:           sip.PLC,
+		FEC:           sip.FEC,
+		OnFill: func(frames int, dtx, concealed bool) {
+			if l := b.rtpLog.Load(); l != nil {
+				l.log.Fill(frames, dtx, concealed)
+			}
+		},
+		Log: logger.GetLogger(),
+	})
+}
+
 func (b *MediaBridge) readSIP() {
 	defer b.wg.Done()
-	if b.sip == nil || b.sip.LKCodec == nil {
+	defer b.recoverFault("readSIP")
+	gen := b.sipGen.Load()
+	sip := b.sip.Load()
+	if sip == nil || sip.LKCodec == nil {
 		b.logger.Warn("sip media not ready (no codec)")
 		return
 	}
-	if b.sip.RTPReader() == nil {
+	if sip.RTPReader() == nil {
 		b.logger.Warn("sip rtp reader not available")
 		return
 	}
 
-	// Build LiveKit-like pipeline: jitter -> silence filler -> codec decode -> TG playout buffer.
-	pt := b.sip.PayloadType()
-	hc, err := pipeline.BuildSipDecodeChain(pipeline.SipDecodeConfig{
-		Codec:         b.sip.LKCodec,
-		PayloadType:   pt,
-		InputChannels: b.sip.Channels,
-		OutputFormat:  b.tgFormat,
-		PlayoutBuffer: b.sipToTGBuffer,
-		EnableJitter:  b.sip.EnableJitter,
-		Log:           logger.GetLogger(),
-	})
+	hc, err := b.buildSipDecodeChain(sip)
 	if err != nil {
 		b.logger.Warn("sip decode chain failed", "error", err)
+		b.fault("sip decode chain failed", err)
 		return
 	}
-	defer hc.Close()
+	defer func() { hc.Close() }()
+	pt := sip.PayloadType()
 
 	rtpBuf := make([]byte, media.RTPBufSize)
 	pkt := &rtp.Packet{}
+	var lastSeq uint16
+	haveSeq := false
+	var lastDTMFTs uint32
+	haveDTMF := false
 	for {
 		select {
 		case <-b.ctx.Done():
@@ -125,23 +710,78 @@ func (b *MediaBridge) readSIP() {
 		}
 
 		*pkt = rtp.Packet{}
-		_, err := b.sip.RTPReader().ReadRTP(rtpBuf, pkt)
-		if err != nil {
+		_, err := sip.RTPReader().ReadRTP(rtpBuf, pkt)
+		if err != nil && b.sipGen.Load() == gen {
 			if !errors.Is(err, io.EOF) {
 				b.logger.Warn("sip rtp read failed", "error", err)
 			}
 			return
 		}
 
+		if g := b.sipGen.Load(); g != gen {
+			// A re-INVITE changed the codec (or a transfer replaced the
+			// dialog); restart decoding cleanly.
+			gen, sip = g, b.sip.Load()
+			hc.Close()
+			if hc, err = b.buildSipDecodeChain(sip); err != nil {
+				b.logger.Warn("sip decode chain failed", "error", err)
+				b.fault("sip decode chain failed", err)
+				return
+			}
+			pt = sip.PayloadType()
+			haveSeq, haveDTMF = false, false
+			if l := b.rtpLog.Load(); l != nil {
+				l.log.SetClockRate(sip.RTPClockRate)
+			}
+		}
+		if err != nil {
+			// The old dialog closed after a transfer; read from the new one.
+			continue
+		}
+		if d := b.dump.Load(); d != nil {
+			if err := d.rtp.Packet(false, &pkt.Header, pkt.Payload, uint8(pkt.PayloadType) == pt); err != nil {
+				b.dumpFailed(d, err)
+			}
+		}
+
+		if sip.HasDTMF && uint8(pkt.PayloadType) == sip.DTMFPayloadType {
+			// Marker packets start an event; retransmits share the timestamp.
+			if ev, ok := dtmf.DecodeRTP(&pkt.Header, pkt.Payload); ok && ev.Digit != 0 && (!haveDTMF || pkt.Timestamp != lastDTMFTs) {
+				lastDTMFTs, haveDTMF = pkt.Timestamp, true
+				if l := b.rtpLog.Load(); l != nil {
+					l.log.DTMF(&pkt.Header, rune(ev.Digit))
+				}
+				if b.onDTMF != nil {
+					b.onDTMF(rune(ev.Digit))
+				}
+			}
+			continue
+		}
+		if l := b.rtpLog.Load(); l != nil {
+			l.log.Packet(&pkt.Header, time.Now())
+		}
+
 		// Filter only negotiated payload type.
 		if uint8(pkt.PayloadType) != pt || len(pkt.Payload) == 0 {
 			continue
 		}
+		b.stats.sipPacketsIn.Add(1)
+		// Count sequence gaps as loss; large jumps are treated as a stream reset.
+		if haveSeq {
+			if gap := pkt.SequenceNumber - lastSeq; gap > 1 && gap < 1000 {
+				b.stats.sipPacketsLost.Add(uint64(gap - 1))
+			}
+		}
+		if !haveSeq || int16(pkt.SequenceNumber-lastSeq) > 0 {
+			lastSeq = pkt.SequenceNumber
+		}
+		haveSeq = true
 
 		// IMPORTANT: jitter buffer keeps payload references; clone to avoid reuse bugs.
 		payload := append([]byte(nil), pkt.Payload...)
 		if err := hc.HandleRTP(&pkt.Header, payload); err != nil {
 			b.logger.Warn("sip rtp handler failed", "error", err)
+			b.fault("sip rtp handler failed", err)
 			return
 		}
 	}
@@ -149,6 +789,7 @@ func (b *MediaBridge) readSIP() {
 
 func (b *MediaBridge) writeTG() {
 	defer b.wg.Done()
+	defer b.recoverFault("writeTG")
 	// TG external mic injection is done in 10ms steps.
 	tgFrameDur := b.tgFormat.FrameDur
 	b.logger.Info("writeTG goroutine started", "tg_frame_dur_ms", tgFrameDur.Milliseconds())
@@ -169,23 +810,25 @@ func (b *MediaBridge) writeTG() {
 			return
 		case <-ticker.C:
 			backlog := b.sipToTGBuffer.LenFrames()
+			target := int(b.playoutTarget.Load())
 			// Drift control (LiveKit-like idea): avoid dropping whole frames.
 			// Instead, apply tiny time-compression/expansion by +/-1 PCM16 sample
-			// within an output frame to nudge backlog toward driftTarget.
+			// within an output frame to nudge backlog toward target.
 			//
 			// We still keep an emergency hard cap to avoid unbounded latency if
 			// something goes very wrong.
-			if backlog > b.driftTarget+200 {
-				dropped := b.sipToTGBuffer.DropFrames(backlog - b.driftTarget)
+			if backlog > target+200 {
+				dropped := b.sipToTGBuffer.DropFrames(backlog - target)
+				b.stats.sipToTGDropped.Add(uint64(dropped))
 				if dropped > 0 {
-					b.logger.Warn("sip->tg emergency drop (hard cap)", "dropped_frames", dropped, "backlog_before", backlog, "target", b.driftTarget)
+					b.logger.Warn("sip->tg emergency drop (hard cap)", "dropped_frames", dropped, "backlog_before", backlog, "target", target)
 				}
 				b.driftAcc = 0
 				backlog = b.sipToTGBuffer.LenFrames()
 			}
 
 			// Accumulate error with hysteresis so we don't flap.
-			errFrames := backlog - b.driftTarget
+			errFrames := backlog - target
 			if errFrames >= 2 {
 				b.driftAcc += errFrames / 2
 			} else if errFrames <= -2 {
@@ -197,18 +840,72 @@ func (b *MediaBridge) writeTG() {
 				adjust = 1
 				b.driftAcc--
 				adjPos++
+				b.stats.driftAdjPos.Add(1)
 			} else if b.driftAcc < 0 {
 				adjust = -1
 				b.driftAcc++
 				adjNeg++
+				b.stats.driftAdjNeg.Add(1)
 			}
 
-			ok := b.sipToTGBuffer.ReadIntoAdjust(frameBuf, adjust)
+			var ok bool
+			held := b.hold.Load()
+			if held || b.muteToTG.Load() {
+				// Pause injection while held: discard SIP audio and keep the
+				// TG capture timeline going with silence.
+				b.sipToTGBuffer.DropFrames(b.sipToTGBuffer.LenFrames())
+				if held && b.holdAudio != nil {
+					b.holdAudio.ReadFrame(frameBuf)
+				} else {
+					clear(frameBuf)
+				}
+			} else {
+				ok = b.sipToTGBuffer.ReadIntoAdjust(frameBuf, adjust)
+				if d := b.dump.Load(); d != nil {
+					if err := d.in.Write(frameBuf); err != nil {
+						b.dumpFailed(d, err)
+					}
+				}
+				if b.sipProbe != nil {
+					b.sipProbe.Feed(frameBuf, time.Now())
+				}
+				if ok && b.toTGDenoise != nil {
+					b.toTGDenoise.Process(frameBuf)
+				}
+				if ok && b.toTGAGC != nil {
+					b.toTGAGC.Process(frameBuf)
+				}
+				for _, st := range b.toTGStages {
+					st.Process(frameBuf)
+				}
+			}
+			b.tgTones.Mix(frameBuf)
+			if prompt := b.tgPrompt.Load(); prompt != nil && !prompt.MixFrame(frameBuf) {
+				b.tgPrompt.CompareAndSwap(prompt, nil)
+			}
+			if b.echoGuard != nil {
+				b.echoGuard.Reference(frameBuf)
+			}
+			if rec := b.recorder.Load(); rec != nil {
+				if err := rec.WriteSIP(frameBuf); err != nil {
+					b.logger.Warn("recording write failed", "error", err)
+					b.stopRecording(rec)
+				}
+			} else if b.preRoll != nil {
+				b.preRoll.AddSIP(frameBuf)
+			}
+			if t := b.transcript.Load(); t != nil && !held {
+				t.WriteSIP(frameBuf)
+			}
+			b.toTGLevel.Add(frameBuf)
 			frameCount++
+			b.stats.tgFramesOut.Add(1)
 			if ok {
 				realFrameCount++
 				lastRealAt = time.Now()
 				lastEnergy = pcm16leMonoEnergy(frameBuf)
+				b.stats.tgRealOut.Add(1)
+				b.stats.lastEnergy.Store(math.Float64bits(lastEnergy))
 			}
 			// Emit periodic stats so we can see if TG "goes silent" because:
 			// - we are underflowing (queue empty -> fallback silence), or
@@ -228,7 +925,7 @@ func (b *MediaBridge) writeTG() {
 			}
 			// Warn if we haven't seen non-fallback frames in a while.
 			// Rate-limit to avoid log spam during long underflows.
-			if time.Since(lastRealAt) >= 2*time.Second && time.Since(lastUnderflowAt) >= 2*time.Second {
+			if !held && time.Since(lastRealAt) >= 2*time.Second && time.Since(lastUnderflowAt) >= 2*time.Second {
 				b.logger.Warn("sip->tg underflow (sending silence)",
 					"ms_since_last_real", time.Since(lastRealAt).Milliseconds(),
 					"queue_len", b.sipToTGBuffer.LenFrames(),
@@ -241,7 +938,11 @@ func (b *MediaBridge) writeTG() {
 			if realFrameCount == 1 && ok {
 				b.logger.Info("sip->tg first real frame!", "total_sent", frameCount)
 			}
-			if err := b.tg.SendPCMFrame10ms(frameBuf); err != nil {
+			if b.tgProbe != nil {
+				// After the recording tap, so recordings stay free of chirps.
+				b.tgProbe.Mix(frameBuf, time.Now())
+			}
+			if err := b.tgPort().SendPCMFrame10ms(frameBuf); err != nil {
 				b.logger.Warn("tg mic send failed", "error", err)
 				return
 			}
@@ -271,11 +972,14 @@ func pcm16leMonoEnergy(pcm []byte) float64 {
 
 func (b *MediaBridge) writeSIP() {
 	defer b.wg.Done()
-	if b.sip == nil || b.sip.LKCodec == nil {
+	defer b.recoverFault("writeSIP")
+	gen := b.sipGen.Load()
+	sip := b.sip.Load()
+	if sip == nil || sip.LKCodec == nil {
 		b.logger.Warn("sip media not ready (no codec)")
 		return
 	}
-	if b.sip.RTPWriter() == nil {
+	if sip.RTPWriter() == nil {
 		b.logger.Warn("sip rtp writer not available")
 		return
 	}
@@ -287,20 +991,15 @@ func (b *MediaBridge) writeSIP() {
 	defer ticker.Stop()
 	silence := make([]byte, b.tgFormat.FrameBytes())
 
-	pt := b.sip.PayloadType()
-	lkInfo := b.sip.LKCodec.Info()
-	enc, err := pipeline.BuildSipEncodePipeline(pipeline.SipEncodeConfig{
-		Codec:       b.sip.LKCodec,
-		PayloadType: pt,
-		RTPClock:    b.sip.RTPClockRate,
-		SourceRate:  b.tgFormat.SampleRate,
-		RTPWriter:   b.sip.RTPWriter(),
-	})
+	enc, err := b.buildSipEncoder(sip)
 	if err != nil {
 		b.logger.Warn("sip encode pipeline failed", "error", err)
+		b.fault("sip encode pipeline failed", err)
 		return
 	}
 	out := enc.Writer
+	lkInfo := sip.LKCodec.Info()
+	sipFormat := sip.Format()
 
 	// Assemble TG 10ms frames into 20ms PCM16 samples at TG rate.
 	tgSamplesPer10ms := b.tgFormat.FrameBytes() / 2 // interleaved samples
@@ -313,6 +1012,8 @@ func (b *MediaBridge) writeSIP() {
 
 		inBuf     msdk.PCM16Sample
 		tmpCh     msdk.PCM16Sample
+		toneBuf   []byte
+		stageBuf  []byte
 		lastWrite time.Time
 	)
 	for {
@@ -321,7 +1022,22 @@ func (b *MediaBridge) writeSIP() {
 			b.logger.Info("writeSIP stopped", "tg_frames", tgFrameCount, "sip_frames", sipFrameCount, "real_frames", realFrameCount)
 			return
 		case <-ticker.C:
-			backlog := len(b.tg.SpeakerFrames())
+			if g := b.sipGen.Load(); g != gen {
+				// A re-INVITE changed the codec; restart encoding cleanly.
+				gen, sip = g, b.sip.Load()
+				_ = out.Close()
+				if enc, err = b.buildSipEncoder(sip); err != nil {
+					b.logger.Warn("sip encode pipeline failed", "error", err)
+					b.fault("sip encode pipeline failed", err)
+					return
+				}
+				out = enc.Writer
+				lkInfo = sip.LKCodec.Info()
+				sipFormat = sip.Format()
+				assembler = pcm.NewPCM16Assembler(tgSamplesPer10ms * 2)
+				lastWrite = time.Time{}
+			}
+			backlog := len(b.tgPort().SpeakerFrames())
 			// Keep real-time pace; drop oldest frames if TG backlog grows.
 			if backlog > b.driftTarget {
 				// Drop gradually to avoid audible "time jumps".
@@ -329,17 +1045,84 @@ func (b *MediaBridge) writeSIP() {
 				if b.driftMaxBurst > 0 && toDrop > b.driftMaxBurst {
 					toDrop = b.driftMaxBurst
 				}
-				dropped := drainFrames(b.tg.SpeakerFrames(), toDrop)
+				dropped := drainFrames(b.tgPort().SpeakerFrames(), toDrop)
+				b.stats.tgToSIPDropped.Add(uint64(dropped))
 				if dropped > 0 && (dropped >= 10 || tgFrameCount == 0) {
 					b.logger.Warn("tg->sip backlog drop", "dropped_frames", dropped, "backlog_before", backlog, "target", b.driftTarget)
 				}
 			}
 
-			frame := popFrame(b.tg.SpeakerFrames(), silence)
+			frame := popFrame(b.tgPort().SpeakerFrames(), silence)
 			tgFrameCount++
 			isSilence := &frame[0] == &silence[0]
+			if b.tgProbe != nil {
+				b.tgProbe.Feed(frame, time.Now())
+			}
+			if len(b.toSIPStages) > 0 || (b.toSIPAGC != nil && !isSilence) || b.echoGuard != nil {
+				// frame may alias the shared silence buffer; process a copy.
+				stageBuf = append(stageBuf[:0], frame...)
+				if b.echoGuard != nil {
+					// Also fed silence, to keep its timeline.
+					b.echoGuard.Process(stageBuf)
+				}
+				if b.toSIPAGC != nil && !isSilence {
+					b.toSIPAGC.Process(stageBuf)
+				}
+				for _, st := range b.toSIPStages {
+					st.Process(stageBuf)
+				}
+				frame = stageBuf
+			}
 			if !isSilence {
 				realFrameCount++
+				b.stats.tgFramesIn.Add(1)
+			}
+			if b.muteToSIP.Load() {
+				stageBuf = append(stageBuf[:0], frame...)
+				pcm.FillComfortNoise(stageBuf)
+				frame = stageBuf
+			}
+			if b.sipTones.Active() {
+				// frame may alias the shared silence buffer; mix into a copy.
+				toneBuf = append(toneBuf[:0], frame...)
+				b.sipTones.Mix(toneBuf)
+				frame = toneBuf
+			}
+			if prompt := b.sipPrompt.Load(); prompt != nil {
+				toneBuf = append(toneBuf[:0], frame...)
+				if !prompt.MixFrame(toneBuf) {
+					b.sipPrompt.CompareAndSwap(prompt, nil)
+				}
+				frame = toneBuf
+			}
+
+			if rec := b.recorder.Load(); rec != nil {
+				if err := rec.WriteTG(frame); err != nil {
+					b.logger.Warn("recording write failed", "error", err)
+					b.stopRecording(rec)
+				}
+			} else if b.preRoll != nil {
+				b.preRoll.AddTG(frame)
+			}
+			if t := b.transcript.Load(); t != nil {
+				t.WriteTG(frame)
+			}
+			if b.hold.Load() {
+				// Held calls must not receive RTP from us; lastWrite makes the
+				// encoder skip the gap in RTP timestamps on resume.
+				continue
+			}
+			b.toSIPLevel.Add(frame)
+			if b.sipProbe != nil {
+				toneBuf = append(toneBuf[:0], frame...)
+				b.sipProbe.Mix(toneBuf, time.Now())
+				frame = toneBuf
+			}
+
+			if d := b.dump.Load(); d != nil {
+				if err := d.out.Write(frame); err != nil {
+					b.dumpFailed(d, err)
+				}
 			}
 
 			// bytes -> PCM16Sample (TG sample rate)
@@ -351,8 +1134,8 @@ func (b *MediaBridge) writeSIP() {
 				// If we are delayed vs wall clock, advance RTP timestamp to avoid "playing in the past".
 				if !lastWrite.IsZero() {
 					dt := time.Since(lastWrite)
-					if dt > b.sipFormat.FrameDur*2 {
-						skip := dt - b.sipFormat.FrameDur
+					if dt > sipFormat.FrameDur*2 {
+						skip := dt - sipFormat.FrameDur
 						if skip > 0 {
 							enc.Delay(uint32(skip.Seconds() * float64(lkInfo.RTPClockRate)))
 						}
@@ -360,18 +1143,35 @@ func (b *MediaBridge) writeSIP() {
 				}
 
 				// Channel conversion (TG mono <-> SIP stereo) at TG rate, before resample+encode.
-				tmpCh = pcm.PCM16ConvertChannels(tmpCh, outFrame, 1, b.sip.Channels)
+				tmpCh = pcm.PCM16ConvertChannels(tmpCh, outFrame, 1, sip.Channels)
 
 				if err := out.WriteSample(tmpCh); err != nil {
 					b.logger.Warn("sip rtp encode/write failed", "error", err)
 					return
 				}
+				b.stats.sipFramesOut.Add(1)
 				lastWrite = time.Now()
 			}
 		}
 	}
 }
 
+func (b *MediaBridge) buildSipEncoder(sip *endpoints.SipEndpoint) (*pipeline.SipEncodePipeline, error) {
+	enc, err := pipeline.BuildSipEncodePipeline(pipeline.SipEncodeConfig{
+		Codec:       sip.LKCodec,
+		PayloadType: sip.PayloadType(),
+		RTPClock:    sip.RTPClockRate,
+		SourceRate:  b.tgFormat.SampleRate,
+		RTPWriter:   dumpRTPWriter{RTPWriter: sip.RTPWriter(), b: b, pt: sip.PayloadType()},
+		FEC:         sip.FEC,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.sipEncoder.Store(enc)
+	return enc, nil
+}
+
 func drainFrames(queue <-chan []byte, max int) int {
 	dropped := 0
 	for dropped < max {
@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/export"
+)
+
+// NewExporters builds the metric/CDR exporters enabled in cfg. The returned
+// set is empty when none are configured.
+func NewExporters(cfg Config, logger *slog.Logger) (export.Set, error) {
+	opts := export.Options{
+		BatchSize:     cfg.ExportBatchSize,
+		FlushInterval: cfg.ExportFlushInterval,
+	}
+	var set export.Set
+	if cfg.ExportInfluxURL != "" {
+		w, err := export.NewInfluxWriter(export.InfluxConfig{
+			URL:      cfg.ExportInfluxURL,
+			Token:    cfg.ExportInfluxToken,
+			Org:      cfg.ExportInfluxOrg,
+			Bucket:   cfg.ExportInfluxBucket,
+			Database: cfg.ExportInfluxDatabase,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("influx exporter: %w", err)
+		}
+		set = append(set, export.New(w, opts, logger))
+	}
+	if cfg.ExportSQLDSN != "" {
+		w, err := export.NewSQLWriter(cfg.ExportSQLDriver, cfg.ExportSQLDSN)
+		if err != nil {
+			_ = set.Close()
+			return nil, fmt.Errorf("timescale exporter: %w", err)
+		}
+		set = append(set, export.New(w, opts, logger))
+	}
+	return set, nil
+}
+
+// SetExporters sets where periodic call metrics are pushed. Must be called
+// before Start.
+func (s *Service) SetExporters(set export.Set) {
+	s.exporters = set
+}
+
+// runMetricsExport samples bridge and per-call media metrics every
+// export.interval until ctx is done.
+func (s *Service) runMetricsExport(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.exporters.Add(s.metricPoints(now)...)
+		}
+	}
+}
+
+func (s *Service) metricPoints(now time.Time) []export.Point {
+	s.mu.Lock()
+	calls := make([]*Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		calls = append(calls, c)
+	}
+	s.mu.Unlock()
+
+	reg := s.Registration()
+	registered := int64(0)
+	if reg.State == RegistrationRegistered {
+		registered = 1
+	}
+	points := []export.Point{{
+		Measurement: "sip_tg_bridge",
+		Fields: map[string]any{
+			"active_calls":              int64(len(calls)),
+			"uptime_seconds":            now.Sub(s.startedAt).Seconds(),
+			"sip_registered":            registered,
+			"sip_registration_failures": int64(reg.Failures),
+		},
+		Time: now,
+	}}
+	for _, c := range calls {
+		st, ok := c.Stats()
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		codec := c.codec
+		c.mu.Unlock()
+		points = append(points, export.Point{
+			Measurement: "sip_tg_call",
+			Tags: map[string]string{
+				"call_id":   c.ID,
+				"direction": string(c.Direction),
+				"codec":     codec,
+			},
+			Fields: map[string]any{
+				"sip_packets_received":     int64(st.SIPPacketsReceived),
+				"sip_packets_lost":         int64(st.SIPPacketsLost),
+				"sip_frames_sent":          int64(st.SIPFramesSent),
+				"tg_frames_sent":           int64(st.TGFramesSent),
+				"tg_frames_received":       int64(st.TGFramesReceived),
+				"sip_to_tg_dropped_frames": int64(st.SIPToTGDropped),
+				"tg_to_sip_dropped_frames": int64(st.TGToSIPDropped),
+				"sip_to_tg_queue_frames":   int64(st.SIPToTGQueueFrames),
+				"last_energy":              st.LastEnergy,
+				"sip_to_tg_buffer_ms":      st.SIPToTGBufferMs,
+				"tg_to_sip_buffer_ms":      st.TGToSIPBufferMs,
+				"sip_loop_ms":              st.SIPLoopMs,
+				"tg_loop_ms":               st.TGLoopMs,
+				"sip_jitter_ms":            st.SIPJitterMs,
+				"sip_rtt_ms":               st.SIPRTTMs,
+				"sip_remote_loss_pct":      st.SIPRemoteLossPct,
+				"sip_remote_jitter_ms":     st.SIPRemoteJitterMs,
+				"sip_to_tg_target_frames":  int64(st.SIPToTGTargetFrames),
+				"jitter_buffer_ms":         st.JitterBufferMs,
+				"jitter_buffer_late":       int64(st.JitterBufferLate),
+				"jitter_buffer_resizes":    int64(st.JitterBufferResizes),
+				"sip_to_tg_rms_dbfs":       st.SIPToTGRMSDBFS,
+				"sip_to_tg_peak_dbfs":      st.SIPToTGPeakDBFS,
+				"tg_to_sip_rms_dbfs":       st.TGToSIPRMSDBFS,
+				"tg_to_sip_peak_dbfs":      st.TGToSIPPeakDBFS,
+			},
+			Time: now,
+		})
+	}
+	return points
+}
+
+// exportCallEvent records call state transitions as sip_tg_call_event
+// points.
+func (s *Service) exportCallEvent(ev CallEvent) {
+	fields := map[string]any{"from": string(ev.From)}
+	if ev.Cause != "" {
+		fields["cause"] = ev.Cause
+	}
+	s.exporters.Add(export.Point{
+		Measurement: "sip_tg_call_event",
+		Tags: map[string]string{
+			"call_id":   ev.Call.ID,
+			"direction": string(ev.Call.Direction),
+			"state":     string(ev.To),
+		},
+		Fields: fields,
+		Time:   ev.Time,
+	})
+}
+
+// exportSink forwards CDRs to the exporters.
+type exportSink struct {
+	set export.Set
+}
+
+func (s exportSink) Name() string { return "export" }
+
+func (s exportSink) Write(rec cdr.Record) error {
+	answer := ""
+	if !rec.AnswerTime.IsZero() {
+		answer = rec.AnswerTime.Format(time.RFC3339Nano)
+	}
+	s.set.Add(export.Point{
+		Measurement: "sip_tg_cdr",
+		Tags: map[string]string{
+			"direction":    rec.Direction,
+			"hangup_cause": rec.HangupCause,
+			"codec":        rec.Codec,
+		},
+		Fields: map[string]any{
+			"id":          rec.ID,
+			"sip_call_id": rec.SIPCallID,
+			"caller":      rec.Caller,
+			"callee":      rec.Callee,
+			"caller_name": rec.CallerName,
+			"callee_name": rec.CalleeName,
+			"tg_chat_id":  rec.TGChatID,
+			"start_time":  rec.StartTime.Format(time.RFC3339Nano),
+			"answer_time": answer,
+			"duration":    rec.Duration,
+			"mos":         rec.MOS,
+			"jitter_ms":   rec.JitterMs,
+			"rtt_ms":      rec.RTTMs,
+			"redirects":   strings.Join(rec.Redirects, " "),
+		},
+		Time: rec.EndTime,
+	})
+	return nil
+}
+
+// Close is a no-op; the exporters are closed by their owner after the
+// recorder has flushed.
+func (s exportSink) Close() error { return nil }
@@ -0,0 +1,20 @@
+package bridge
+
+import "gotgcalls/bridge/pcm"
+
+// Noise suppression modes (audio.noise_suppression).
+const (
+	NoiseSuppressionOff      = "off"
+	NoiseSuppressionSpectral = "spectral"
+)
+
+// attachNoiseSuppression adds the configured noise suppressor to the audio
+// bridge sends to Telegram.
+func (s *Service) attachNoiseSuppression(bridge *MediaBridge) {
+	if s.cfg.NoiseSuppression != NoiseSuppressionSpectral {
+		return
+	}
+	format := s.tgFormat()
+	channels := max(format.Channels, 1)
+	bridge.SetNoiseSuppressor(pcm.NewNoiseSuppressor(format.FrameSamples()/channels, channels, s.cfg.NoiseSuppressionDB))
+}
@@ -0,0 +1,52 @@
+package bridge
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// ErrCallerID is returned when an outbound call asks for a caller ID that
+// sip.caller_ids does not list.
+var ErrCallerID = errors.New("caller id not allowed (sip.caller_ids)")
+
+// callerIDAllowed reports whether an outbound call may present callerID
+// (normalized): any number when sip.caller_ids is empty.
+func (s *Service) callerIDAllowed(callerID string) bool {
+	if callerID == "" {
+		return false
+	}
+	if len(s.cfg.SIPCallerIDs) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.cfg.SIPCallerIDs, func(allowed string) bool {
+		return normalizePhone(allowed) == callerID
+	})
+}
+
+// callerIDHeaders returns the From and P-Asserted-Identity headers that
+// present the call's selected caller ID to the trunk, or none to keep the
+// default From.
+func (s *Service) callerIDHeaders(call *Call) []sip.Header {
+	if call == nil || call.callerID == "" {
+		return []sip.Header{}
+	}
+	from := s.trunkFrom(call.callerID)
+	return []sip.Header{
+		from,
+		sip.NewHeader("P-Asserted-Identity", "<"+from.Address.String()+">"),
+	}
+}
+
+// trunkFrom is a From header of user at the SIP trunk.
+func (s *Service) trunkFrom(user string) *sip.FromHeader {
+	host, _ := splitHostPort(s.cfg.SIPProvider)
+	from := &sip.FromHeader{
+		Address: sip.Uri{User: user, Host: host},
+		Params:  sip.NewParams(),
+	}
+	// sipgo only tags the From header it builds itself.
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	return from
+}
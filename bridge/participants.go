@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gotgcalls/third_party/ubot"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// Participant is a member of a Telegram group call the bridge is connected to.
+type Participant struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Muted         bool      `json:"muted"`
+	CanSelfUnmute bool      `json:"can_self_unmute"`
+	Video         bool      `json:"video"`
+	Screen        bool      `json:"screen"`
+	Volume        int32     `json:"volume,omitempty"`
+	SSRC          uint32    `json:"ssrc"`
+	JoinedAt      time.Time `json:"joined_at"`
+	// LocalVolume (percent) and LocalMuted are how loud the participant is
+	// in the audio sent to the SIP side (see SetVolumeIndex).
+	LocalVolume int  `json:"local_volume"`
+	LocalMuted  bool `json:"local_muted,omitempty"`
+}
+
+// SetTelegramClient gives the service a client for posting messages (e.g.
+// participant events). Must be called before Start.
+func (s *Service) SetTelegramClient(client *tg.Client) {
+	s.tgClient = client
+}
+
+// GroupCalls returns the chat IDs of group calls with an active bridge session.
+func (s *Service) GroupCalls() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []int64
+	for chatID := range s.tgSessions {
+		if chatID < 0 {
+			ids = append(ids, chatID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Participants lists the members of the group call in chatID.
+func (s *Service) Participants(chatID int64) ([]Participant, error) {
+	raw, err := s.tg.GetParticipants(chatID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Participant, 0, len(raw))
+	for _, p := range raw {
+		id := peerID(p.Peer)
+		volume, muted := s.tg.GetSourceVolume(chatID, uint32(p.Source))
+		out = append(out, Participant{
+			ID:            id,
+			Name:          s.peerName(id),
+			Muted:         p.Muted,
+			CanSelfUnmute: p.CanSelfUnmute,
+			Video:         p.Video != nil,
+			Screen:        p.Presentation != nil,
+			Volume:        p.Volume,
+			SSRC:          uint32(p.Source),
+			JoinedAt:      time.Unix(int64(p.Date), 0),
+			LocalVolume:   volume,
+			LocalMuted:    muted,
+		})
+	}
+	slices.SortFunc(out, func(a, b Participant) int {
+		return a.JoinedAt.Compare(b.JoinedAt)
+	})
+	return out, nil
+}
+
+func (s *Service) handleParticipantUpdate(chatID int64, p *tg.GroupCallParticipant, action ubot.ParticipantAction) {
+	session := s.getTGSession(chatID)
+	if session == nil {
+		return
+	}
+	if action == ubot.ParticipantLeft && p.Source != 0 && session.ListenSource() == uint32(p.Source) {
+		// The selected speaker is gone; fall back to the full mix.
+		session.SetListenSource(0)
+		s.logger.Info("tg group call: selected participant left, listening to everyone", "chat_id", chatID)
+	}
+	if !s.cfg.TGParticipantEvents {
+		return
+	}
+	id := peerID(p.Peer)
+	s.logger.Info("tg group call participant", "chat_id", chatID, "participant", id, "action", action)
+	s.notify(s.controlChat(chatID), fmt.Sprintf("%s %s the voice chat", s.peerName(id), action))
+}
+
+// controlChat is the chat that receives events for a bridged group call.
+func (s *Service) controlChat(int64) int64 {
+	return s.cfg.TGUserID
+}
+
+func (s *Service) notify(chatID int64, text string) {
+	if s.tgClient == nil {
+		return
+	}
+	if _, err := s.tgClient.SendMessage(chatID, text); err != nil {
+		s.logger.Warn("tg notify failed", "chat_id", chatID, "error", err)
+	}
+}
+
+func (s *Service) peerName(id int64) string {
+	if s.tgClient != nil && id > 0 {
+		if user, err := s.tgClient.GetUser(id); err == nil && user != nil {
+			name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+			if name == "" && user.Username != "" {
+				name = "@" + user.Username
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return fmt.Sprintf("id%d", id)
+}
+
+func peerID(peer tg.Peer) int64 {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		return p.UserID
+	case *tg.PeerChat:
+		return -p.ChatID
+	case *tg.PeerChannel:
+		return -1000000000000 - p.ChannelID
+	}
+	return 0
+}
+
+// FormatParticipants renders a participant list for Telegram replies.
+func FormatParticipants(chatID int64, participants []Participant) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Voice chat %d: %d participant(s)", chatID, len(participants))
+	for i, p := range participants {
+		var flags []string
+		if p.Muted {
+			flags = append(flags, "muted")
+		}
+		if p.Video {
+			flags = append(flags, "video")
+		}
+		if p.Screen {
+			flags = append(flags, "screen")
+		}
+		if p.LocalMuted {
+			flags = append(flags, "silenced")
+		} else if p.LocalVolume != 100 {
+			flags = append(flags, fmt.Sprintf("volume %d%%", p.LocalVolume))
+		}
+		fmt.Fprintf(&b, "\n%d. %s", i+1, p.Name)
+		if len(flags) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(flags, ", "))
+		}
+	}
+	return b.String()
+}
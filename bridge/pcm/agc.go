@@ -0,0 +1,88 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// agcGateDBFS is the level below which a frame counts as silence or
+	// background noise: the gain is held instead of raised to meet it.
+	agcGateDBFS = -55
+	// agcAttackDB and agcReleaseDB bound how fast the gain falls (loud
+	// speech) and rises (quiet speech), per second.
+	agcAttackDB  = 60
+	agcReleaseDB = 6
+	// agcLevelTau is the time constant of the speech level estimate.
+	agcLevelTau = 0.3 // seconds
+)
+
+// AGC is an automatic gain control for 16-bit little-endian PCM: it steers
+// the level of speech toward a target, raising it by at most a maximum gain,
+// and never clips. It keeps state between frames of one stream.
+type AGC struct {
+	target  float64 // dBFS
+	maxGain float64 // dB
+	rate    float64 // samples per second (all channels)
+	level   float64 // smoothed speech level, dBFS
+	gain    float64 // current gain, dB
+	started bool
+}
+
+// NewAGC returns an AGC for audio at sampleRate with channels interleaved.
+// targetDBFS is the RMS level speech is brought to, maxGainDB the most it
+// is amplified.
+func NewAGC(sampleRate, channels int, targetDBFS, maxGainDB float64) *AGC {
+	return &AGC{
+		target:  targetDBFS,
+		maxGain: maxGainDB,
+		rate:    float64(sampleRate * max(channels, 1)),
+	}
+}
+
+// Gain is the gain currently applied, in dB.
+func (a *AGC) Gain() float64 { return a.gain }
+
+// Process applies the gain to a frame in place.
+func (a *AGC) Process(frame []byte) {
+	n := len(frame) / 2
+	if n == 0 {
+		return
+	}
+	var sum float64
+	peak := 0
+	for i := 0; i < n; i++ {
+		v := int(int16(binary.LittleEndian.Uint16(frame[i*2:])))
+		sum += float64(v * v)
+		peak = max(peak, v, -v)
+	}
+	dur := float64(n) / a.rate
+	if rms := math.Sqrt(sum / float64(n)); rms > 0 {
+		level := 20 * math.Log10(rms/32768)
+		if level > agcGateDBFS {
+			if !a.started {
+				a.level, a.started = level, true
+			} else {
+				a.level += (level - a.level) * (1 - math.Exp(-dur/agcLevelTau))
+			}
+			want := min(a.target-a.level, a.maxGain)
+			if want < a.gain {
+				a.gain = max(want, a.gain-agcAttackDB*dur)
+			} else {
+				a.gain = min(want, a.gain+agcReleaseDB*dur)
+			}
+		}
+	}
+	if a.gain == 0 {
+		return
+	}
+	g := math.Pow(10, a.gain/20)
+	if peak > 0 {
+		// Scale down rather than clip the frame's peak.
+		g = min(g, 32767/float64(peak))
+	}
+	for i := 0; i < n; i++ {
+		v := float64(int16(binary.LittleEndian.Uint16(frame[i*2:]))) * g
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(int16(max(-32768, min(32767, math.Round(v))))))
+	}
+}
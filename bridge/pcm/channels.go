@@ -38,3 +38,15 @@ func UpmixMonoPCM16LEToStereo(dst []byte, src []byte) int {
 	}
 	return n * 4
 }
+
+// MixPCM16LE adds src into dst sample by sample, clipping to the int16 range.
+// Samples past the end of dst are ignored.
+func MixPCM16LE(dst []byte, src []byte) {
+	n := min(len(dst), len(src)) / 2
+	for i := 0; i < n; i++ {
+		off := i * 2
+		a := int32(int16(binary.LittleEndian.Uint16(dst[off : off+2])))
+		b := int32(int16(binary.LittleEndian.Uint16(src[off : off+2])))
+		binary.LittleEndian.PutUint16(dst[off:off+2], uint16(int16(min(max(a+b, -32768), 32767))))
+	}
+}
@@ -0,0 +1,18 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+)
+
+// comfortNoiseAmplitude is the peak of the comfort noise, about -60 dBFS.
+const comfortNoiseAmplitude = 32
+
+// FillComfortNoise fills a PCM16LE frame with faint white noise, sent in
+// place of paused audio so the other side doesn't take the line for dead.
+func FillComfortNoise(frame []byte) {
+	for i := 0; i+1 < len(frame); i += 2 {
+		v := rand.IntN(2*comfortNoiseAmplitude+1) - comfortNoiseAmplitude
+		binary.LittleEndian.PutUint16(frame[i:], uint16(int16(v)))
+	}
+}
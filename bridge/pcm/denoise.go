@@ -0,0 +1,196 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// denoiseOverSubtract scales the noise estimate before it is taken
+	// off, which keeps residual noise from turning into "musical" tones.
+	denoiseOverSubtract = 3.0
+	// denoiseNoiseRise is how much the noise estimate of a bin may rise per
+	// frame, and denoiseNoiseFall how fast it follows a lower power.
+	denoiseNoiseRise = 1.005
+	denoiseNoiseFall = 0.1
+	// denoisePowerSmooth and denoiseGainSmooth weigh the previous frame in
+	// the smoothed bin power and gain.
+	denoisePowerSmooth = 0.7
+	denoiseGainSmooth  = 0.5
+)
+
+// NoiseSuppressor takes stationary background noise (hiss, hum, line and
+// room noise) out of 16-bit little-endian PCM by spectral subtraction. It
+// works on overlapping windows of two frames, so it delays audio by one
+// frame. It keeps state between frames of one stream.
+type NoiseSuppressor struct {
+	channels []*denoiser
+	samples  []float64
+}
+
+// NewNoiseSuppressor returns a suppressor for frames of frameSamples
+// samples per channel, with channels interleaved. maxAttenuationDB is how
+// far noise is turned down at most.
+func NewNoiseSuppressor(frameSamples, channels int, maxAttenuationDB float64) *NoiseSuppressor {
+	n := &NoiseSuppressor{samples: make([]float64, frameSamples)}
+	floor := math.Pow(10, -maxAttenuationDB/20)
+	for range max(channels, 1) {
+		n.channels = append(n.channels, newDenoiser(frameSamples, floor))
+	}
+	return n
+}
+
+// Process suppresses noise in a frame in place.
+func (n *NoiseSuppressor) Process(frame []byte) {
+	ch := len(n.channels)
+	if len(frame) != len(n.samples)*ch*2 {
+		return
+	}
+	for c, d := range n.channels {
+		for i := range n.samples {
+			n.samples[i] = float64(int16(binary.LittleEndian.Uint16(frame[(i*ch+c)*2:])))
+		}
+		d.process(n.samples)
+		for i, v := range n.samples {
+			binary.LittleEndian.PutUint16(frame[(i*ch+c)*2:], uint16(int16(max(-32768, min(32767, math.Round(v))))))
+		}
+	}
+}
+
+// denoiser is the spectral subtraction of one channel: windows of 2*hop
+// samples with a square root Hann window for analysis and synthesis, so
+// windows overlapping by half add back up to the input.
+type denoiser struct {
+	hop    int
+	window []float64
+	floor  float64
+
+	in      []float64 // the last 2*hop input samples
+	overlap []float64 // second half of the previous output window
+	spec    []complex128
+	power   []float64
+	noise   []float64
+	gain    []float64
+	// primed is set once a whole window held sound; until then the noise
+	// estimate restarts from every window, so silence before the audio
+	// starts (no RTP yet) doesn't leave it at zero.
+	primed    bool
+	lastSound bool
+}
+
+func newDenoiser(hop int, floor float64) *denoiser {
+	size := 1
+	for size < 2*hop {
+		size <<= 1
+	}
+	d := &denoiser{
+		hop:     hop,
+		window:  make([]float64, 2*hop),
+		floor:   floor,
+		in:      make([]float64, 2*hop),
+		overlap: make([]float64, hop),
+		spec:    make([]complex128, size),
+		power:   make([]float64, size/2+1),
+		noise:   make([]float64, size/2+1),
+		gain:    make([]float64, size/2+1),
+	}
+	for i := range d.window {
+		d.window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(2*hop)))
+	}
+	for i := range d.gain {
+		d.gain[i] = 1
+	}
+	return d
+}
+
+// process replaces frame (hop samples) with the denoised audio one frame
+// back.
+func (d *denoiser) process(frame []float64) {
+	copy(d.in, d.in[d.hop:])
+	copy(d.in[d.hop:], frame)
+
+	for i := range d.spec {
+		d.spec[i] = 0
+	}
+	for i, v := range d.in {
+		d.spec[i] = complex(v*d.window[i], 0)
+	}
+	fft(d.spec, false)
+
+	sound := false
+	for _, v := range frame {
+		if v != 0 {
+			sound = true
+			break
+		}
+	}
+	d.primed = d.primed || (sound && d.lastSound)
+	d.lastSound = sound
+	bins := len(d.power)
+	for k := 0; k < bins; k++ {
+		p := real(d.spec[k])*real(d.spec[k]) + imag(d.spec[k])*imag(d.spec[k])
+		if !d.primed {
+			d.power[k], d.noise[k] = p, p
+		} else {
+			d.power[k] = denoisePowerSmooth*d.power[k] + (1-denoisePowerSmooth)*p
+			if d.power[k] < d.noise[k] {
+				d.noise[k] += (d.power[k] - d.noise[k]) * denoiseNoiseFall
+			} else {
+				d.noise[k] *= denoiseNoiseRise
+			}
+		}
+		g := d.floor
+		if d.power[k] > 0 {
+			g = max(1-denoiseOverSubtract*d.noise[k]/d.power[k], d.floor)
+		}
+		g = denoiseGainSmooth*d.gain[k] + (1-denoiseGainSmooth)*g
+		d.gain[k] = g
+		d.spec[k] *= complex(g, 0)
+		if k > 0 && k < len(d.spec)-k {
+			d.spec[len(d.spec)-k] = cmplx.Conj(d.spec[k])
+		}
+	}
+	fft(d.spec, true)
+
+	for i := 0; i < d.hop; i++ {
+		frame[i] = d.overlap[i] + real(d.spec[i])*d.window[i]
+		d.overlap[i] = real(d.spec[d.hop+i]) * d.window[d.hop+i]
+	}
+}
+
+// fft is an in-place radix-2 FFT; len(x) must be a power of two. The
+// inverse is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// suppression feeds silent frames and then frames of white noise through
+// a suppressor and returns how far the noise was turned down, in dB, once
+// the noise estimate settled.
+func suppression(t *testing.T, silent int) float64 {
+	t.Helper()
+	const (
+		frameSamples = 160
+		noiseFrames  = 300
+		measured     = 100
+	)
+	n := NewNoiseSuppressor(frameSamples, 1, 20)
+	frame := make([]byte, frameSamples*2)
+	for range silent {
+		clear(frame)
+		n.Process(frame)
+	}
+	rng := rand.New(rand.NewSource(1))
+	var in, out float64
+	for i := range noiseFrames {
+		var energy float64
+		for s := range frameSamples {
+			v := int16(rng.NormFloat64() * 1000)
+			energy += float64(v) * float64(v)
+			binary.LittleEndian.PutUint16(frame[s*2:], uint16(v))
+		}
+		n.Process(frame)
+		if i < noiseFrames-measured {
+			continue
+		}
+		in += energy
+		for s := range frameSamples {
+			v := float64(int16(binary.LittleEndian.Uint16(frame[s*2:])))
+			out += v * v
+		}
+	}
+	return 10 * math.Log10(in/out)
+}
+
+func TestNoiseSuppressor(t *testing.T) {
+	tests := []struct {
+		name   string
+		silent int
+	}{
+		{"noise from the start", 0},
+		{"silence before the noise", 1},
+		{"long silence before the noise", 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if db := suppression(t, tt.silent); db < 6 {
+				t.Errorf("noise turned down by %.1f dB, want at least 6 dB", db)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+const (
+	// echoBlockMs is the resolution of the level envelopes that are
+	// compared; echoWindowMs how much of the returning audio is compared
+	// (long enough to span a few syllables, or any two voices match at
+	// some delay) and echoMaxDelayMs the longest round trip looked for.
+	echoBlockMs    = 5
+	echoWindowMs   = 600
+	echoMaxDelayMs = 800
+	// echoGateDBFS is the level below which the returning audio is not
+	// checked: there is nothing to attenuate.
+	echoGateDBFS = -50
+	// echoHoldMs keeps the attenuation on after the last match, and
+	// echoReleaseMs is how long it takes to fade out.
+	echoHoldMs    = 300
+	echoReleaseMs = 150
+)
+
+// EchoGuard protects against feedback loops when a far end leaks the audio
+// it plays back into its microphone (speakerphones): audio coming back
+// (Process) whose level envelope closely follows the audio sent out
+// recently (Reference), at any delay up to echoMaxDelayMs, is attenuated.
+// Reference and Process may be called from different goroutines.
+type EchoGuard struct {
+	mu        sync.Mutex
+	threshold float64
+	floor     float64 // gain while echo is detected
+
+	ref  envelope
+	near envelope
+
+	gain float64
+	hold float64 // seconds of attenuation left
+	rate float64 // interleaved samples per second
+	echo bool
+}
+
+// NewEchoGuard returns a guard for audio at sampleRate with channels
+// interleaved. threshold (0-1) is the correlation from which returning
+// audio counts as echo; attenuationDB is how far echo is turned down.
+func NewEchoGuard(sampleRate, channels int, threshold, attenuationDB float64) *EchoGuard {
+	block := max(sampleRate*max(channels, 1)*echoBlockMs/1000, 1)
+	window := echoWindowMs / echoBlockMs
+	return &EchoGuard{
+		threshold: threshold,
+		floor:     math.Pow(10, -attenuationDB/20),
+		ref:       newEnvelope(window+echoMaxDelayMs/echoBlockMs, block),
+		near:      newEnvelope(window, block),
+		gain:      1,
+		rate:      float64(sampleRate * max(channels, 1)),
+	}
+}
+
+// Echo reports whether the last processed frame was taken for echo.
+func (e *EchoGuard) Echo() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.echo
+}
+
+// Reference records a frame of the audio sent to the far end.
+func (e *EchoGuard) Reference(frame []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ref.add(frame)
+}
+
+// Process attenuates a frame of audio from the far end in place when it is
+// an echo of the reference.
+func (e *EchoGuard) Process(frame []byte) {
+	n := len(frame) / 2
+	if n == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.near.add(frame)
+	dur := float64(n) / e.rate
+	e.echo = e.matches()
+	if e.echo {
+		e.hold = echoHoldMs / 1000.0
+	} else {
+		e.hold = max(e.hold-dur, 0)
+	}
+	target := 1.0
+	if e.hold > 0 {
+		target = e.floor
+	}
+	from := e.gain
+	if target < from {
+		// Duck at once; a late duck lets the loop build up.
+		from = target
+	}
+	to := target
+	if target > e.gain {
+		to = min(e.gain+(1-e.floor)*dur/(echoReleaseMs/1000.0), target)
+	}
+	e.gain = to
+	if from == 1 && to == 1 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		g := from + (to-from)*float64(i)/float64(n)
+		v := float64(int16(binary.LittleEndian.Uint16(frame[i*2:]))) * g
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(int16(max(-32768, min(32767, math.Round(v))))))
+	}
+}
+
+// matches correlates the envelope of the returning audio with the
+// reference at every delay and reports whether the best match reaches the
+// threshold.
+func (e *EchoGuard) matches() bool {
+	w := len(e.near.points)
+	if e.near.count < w || e.ref.count < w {
+		return false
+	}
+	near := e.near.last(w)
+	var mean, peak float64
+	for _, v := range near {
+		mean += v
+		peak = max(peak, v)
+	}
+	mean /= float64(w)
+	if peak < 32768*math.Pow(10, echoGateDBFS/20.0) {
+		return false
+	}
+	var nearVar float64
+	for i := range near {
+		near[i] -= mean
+		nearVar += near[i] * near[i]
+	}
+	if nearVar == 0 {
+		return false
+	}
+	ref := e.ref.last(min(e.ref.count, len(e.ref.points)))
+	for end := len(ref); end >= w; end-- {
+		seg := ref[end-w : end]
+		var refMean float64
+		for _, v := range seg {
+			refMean += v
+		}
+		refMean /= float64(w)
+		var cov, refVar float64
+		for i, v := range seg {
+			d := v - refMean
+			cov += d * near[i]
+			refVar += d * d
+		}
+		if refVar > 0 && cov/math.Sqrt(nearVar*refVar) >= e.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// envelope keeps the RMS level of the last blocks of a stream.
+type envelope struct {
+	block  int
+	points []float64 // ring buffer
+	pos    int
+	count  int // points added so far
+	sum    float64
+	n      int
+	out    []float64
+}
+
+func newEnvelope(points, block int) envelope {
+	return envelope{block: block, points: make([]float64, points), out: make([]float64, points)}
+}
+
+func (v *envelope) add(frame []byte) {
+	for i := 0; i+1 < len(frame); i += 2 {
+		s := float64(int16(binary.LittleEndian.Uint16(frame[i:])))
+		v.sum += s * s
+		v.n++
+		if v.n == v.block {
+			v.points[v.pos] = math.Sqrt(v.sum / float64(v.n))
+			v.pos = (v.pos + 1) % len(v.points)
+			v.count++
+			v.sum, v.n = 0, 0
+		}
+	}
+}
+
+// last returns the n latest points, oldest first, in a buffer reused by
+// the next call.
+func (v *envelope) last(n int) []float64 {
+	out := v.out[:n]
+	for i := range out {
+		out[i] = v.points[(v.pos-n+i+len(v.points))%len(v.points)]
+	}
+	return out
+}
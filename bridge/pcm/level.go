@@ -0,0 +1,72 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// SilenceDBFS is the level reported for digital silence.
+const SilenceDBFS = -96
+
+// LevelMeter tracks the RMS and peak level of 16-bit little-endian PCM over
+// the last frames of a stream. Add and Levels may be called from different
+// goroutines.
+type LevelMeter struct {
+	mu     sync.Mutex
+	frames []frameLevel
+	pos    int
+	count  int
+}
+
+type frameLevel struct {
+	sum     float64 // sum of squared samples
+	samples int
+	peak    int
+}
+
+// NewLevelMeter returns a meter over the last window frames.
+func NewLevelMeter(window int) *LevelMeter {
+	return &LevelMeter{frames: make([]frameLevel, max(window, 1))}
+}
+
+// Add measures a frame.
+func (m *LevelMeter) Add(frame []byte) {
+	var l frameLevel
+	for i := 0; i+1 < len(frame); i += 2 {
+		v := int(int16(binary.LittleEndian.Uint16(frame[i:])))
+		l.sum += float64(v * v)
+		l.peak = max(l.peak, v, -v)
+		l.samples++
+	}
+	m.mu.Lock()
+	m.frames[m.pos] = l
+	m.pos = (m.pos + 1) % len(m.frames)
+	m.count = min(m.count+1, len(m.frames))
+	m.mu.Unlock()
+}
+
+// Levels returns the RMS and peak level of the window in dBFS, SilenceDBFS
+// when it is silent or empty.
+func (m *LevelMeter) Levels() (rms, peak float64) {
+	m.mu.Lock()
+	var sum float64
+	samples, maxPeak := 0, 0
+	for _, l := range m.frames[:m.count] {
+		sum += l.sum
+		samples += l.samples
+		maxPeak = max(maxPeak, l.peak)
+	}
+	m.mu.Unlock()
+	if samples == 0 {
+		return SilenceDBFS, SilenceDBFS
+	}
+	return toDBFS(math.Sqrt(sum / float64(samples))), toDBFS(float64(maxPeak))
+}
+
+func toDBFS(v float64) float64 {
+	if v <= 0 {
+		return SilenceDBFS
+	}
+	return max(math.Round(20*math.Log10(v/32768)*10)/10, SilenceDBFS)
+}
@@ -0,0 +1,111 @@
+package pcm
+
+import "math"
+
+const (
+	loudnessBlock     = 0.4 // seconds per gating block
+	loudnessStep      = 0.1 // block hop (75% overlap)
+	loudnessAbsGate   = -70 // LUFS
+	loudnessRelGateLU = -10 // relative to the ungated loudness
+	loudnessOffset    = -0.691
+)
+
+// biquad is a direct form I second-order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the ITU-R BS.1770 pre-filter (high shelf, then high
+// pass) for sampleRate.
+func kWeighting(sampleRate int) [2]biquad {
+	fs := float64(sampleRate)
+
+	const (
+		shelfF0   = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * shelfF0 / fs)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	const (
+		hpF0 = 38.13547087602444
+		hpQ  = 0.5003270373238773
+	)
+	k = math.Tan(math.Pi * hpF0 / fs)
+	a0 = 1 + k/hpQ + k*k
+	highPass := biquad{
+		b0: 1, b1: -2, b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/hpQ + k*k) / a0,
+	}
+	return [2]biquad{shelf, highPass}
+}
+
+// IntegratedLoudness returns the gated loudness of mono samples in LUFS
+// (ITU-R BS.1770 / EBU R128). It returns -Inf for audio that is shorter
+// than one block or entirely below the absolute gate.
+func IntegratedLoudness(samples []int16, sampleRate int) float64 {
+	block := int(loudnessBlock * float64(sampleRate))
+	step := int(loudnessStep * float64(sampleRate))
+	if block == 0 || len(samples) < block {
+		return math.Inf(-1)
+	}
+	filters := kWeighting(sampleRate)
+	// Squared K-weighted samples, summed per step so blocks are 4 steps.
+	steps := make([]float64, len(samples)/step)
+	for i, v := range samples[:len(steps)*step] {
+		x := float64(v) / 32768
+		x = filters[0].process(x)
+		x = filters[1].process(x)
+		steps[i/step] += x * x
+	}
+	perBlock := block / step
+	var powers []float64
+	for i := 0; i+perBlock <= len(steps); i++ {
+		var sum float64
+		for _, p := range steps[i : i+perBlock] {
+			sum += p
+		}
+		power := sum / float64(perBlock*step)
+		if loudnessOffset+10*math.Log10(power) > loudnessAbsGate {
+			powers = append(powers, power)
+		}
+	}
+	if len(powers) == 0 {
+		return math.Inf(-1)
+	}
+	relGate := loudnessOffset + 10*math.Log10(mean(powers)) + loudnessRelGateLU
+	var gated []float64
+	for _, p := range powers {
+		if loudnessOffset+10*math.Log10(p) > relGate {
+			gated = append(gated, p)
+		}
+	}
+	return loudnessOffset + 10*math.Log10(mean(gated))
+}
+
+func mean(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
@@ -0,0 +1,63 @@
+package pcm
+
+import "encoding/binary"
+
+// MixBus mixes one frame of several PCM16LE sources, each at its own gain,
+// at full precision; samples are only clipped on output. Every source can
+// be given the mix without itself (mix-minus), as in a conference where
+// nobody hears their own voice.
+type MixBus struct {
+	total []int32
+	// parts holds the scaled frame of each source added since Reset.
+	parts [][]int32
+	added []bool
+}
+
+// NewMixBus creates a bus for frames of frameBytes.
+func NewMixBus(frameBytes int) *MixBus {
+	return &MixBus{total: make([]int32, frameBytes/2)}
+}
+
+// Reset starts the next frame.
+func (b *MixBus) Reset() {
+	clear(b.total)
+	clear(b.added)
+}
+
+// Add mixes frame in as source i, scaled by gain percent (100 = unchanged).
+// A missing (nil) frame or gain 0 adds silence.
+func (b *MixBus) Add(i int, frame []byte, gain int) {
+	for len(b.parts) <= i {
+		b.parts = append(b.parts, make([]int32, len(b.total)))
+		b.added = append(b.added, false)
+	}
+	if frame == nil || gain <= 0 {
+		return
+	}
+	part := b.parts[i]
+	n := min(len(part), len(frame)/2)
+	for j := 0; j < n; j++ {
+		v := int32(int16(binary.LittleEndian.Uint16(frame[j*2:]))) * int32(gain) / 100
+		part[j] = v
+		b.total[j] += v
+	}
+	clear(part[n:])
+	b.added[i] = true
+}
+
+// Mix writes the mix of all sources except source except (-1 for none) to
+// dst, clipped to the int16 range.
+func (b *MixBus) Mix(dst []byte, except int) {
+	var minus []int32
+	if except >= 0 && except < len(b.parts) && b.added[except] {
+		minus = b.parts[except]
+	}
+	n := min(len(b.total), len(dst)/2)
+	for j := 0; j < n; j++ {
+		v := b.total[j]
+		if minus != nil {
+			v -= minus[j]
+		}
+		binary.LittleEndian.PutUint16(dst[j*2:], uint16(int16(min(max(v, -32768), 32767))))
+	}
+}
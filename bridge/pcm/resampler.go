@@ -0,0 +1,56 @@
+package pcm
+
+import (
+	"errors"
+	"sync"
+
+	msdk "github.com/livekit/media-sdk"
+)
+
+var errResamplerClosed = errors.New("pcm: resampler closed")
+
+// Resampler converts a PCM16LE mono stream between two sample rates. It
+// keeps filter state across calls, so it handles one stream only. Close may
+// be called concurrently with Resample.
+type Resampler struct {
+	mu     sync.Mutex
+	closed bool
+	out    msdk.PCM16Sample
+	w      msdk.PCM16Writer
+	in     msdk.PCM16Sample
+	buf    []byte
+}
+
+func NewResampler(from, to int) *Resampler {
+	r := &Resampler{}
+	r.w = msdk.ResampleWriter(msdk.NewPCM16BufferWriter(&r.out, to), from)
+	return r
+}
+
+// Resample converts frame and returns the audio resampled so far. The
+// result is only valid until the next call; it may be empty while the
+// filter fills.
+func (r *Resampler) Resample(frame []byte) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, errResamplerClosed
+	}
+	r.in = PCM16BytesToSample(r.in, frame)
+	r.out = r.out[:0]
+	if err := r.w.WriteSample(r.in); err != nil {
+		return nil, err
+	}
+	r.buf = PCM16SampleToBytes(r.buf, r.out)
+	return r.buf, nil
+}
+
+func (r *Resampler) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.w.Close()
+}
@@ -0,0 +1,94 @@
+package pcm
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk/dtmf"
+	"github.com/livekit/media-sdk/tones"
+)
+
+const (
+	// DTMF tone/pause lengths used for in-band relay (well above the 40ms
+	// detection minimum of most receivers).
+	dtmfToneDur  = 100 * time.Millisecond
+	dtmfToneGap  = 100 * time.Millisecond
+	dtmfToneGain = 0.25
+	// "w" in a digit string pauses like media-sdk's dtmf.Write.
+	dtmfPauseDur = 500 * time.Millisecond
+)
+
+type toneSegment struct {
+	freq    []tones.Hz
+	samples int
+}
+
+// ToneMixer overlays queued DTMF tones onto PCM16LE mono frames.
+// Enqueue and Mix may be called from different goroutines.
+type ToneMixer struct {
+	mu         sync.Mutex
+	sampleRate int
+	segs       []toneSegment
+	pos        int
+}
+
+func NewToneMixer(sampleRate int) *ToneMixer {
+	return &ToneMixer{sampleRate: sampleRate}
+}
+
+// EnqueueDigits schedules in-band tones for digits (0-9, *, #, A-D).
+// "w" inserts a pause; unknown characters are skipped.
+func (m *ToneMixer) EnqueueDigits(digits string) {
+	toneSamples := int(dtmfToneDur.Seconds() * float64(m.sampleRate))
+	gapSamples := int(dtmfToneGap.Seconds() * float64(m.sampleRate))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < len(digits); i++ {
+		if digits[i] == 'w' {
+			m.segs = append(m.segs, toneSegment{samples: int(dtmfPauseDur.Seconds() * float64(m.sampleRate))})
+			continue
+		}
+		_, freq := dtmf.Tone(digits[i])
+		if len(freq) == 0 {
+			continue
+		}
+		m.segs = append(m.segs,
+			toneSegment{freq: freq, samples: toneSamples},
+			toneSegment{samples: gapSamples},
+		)
+	}
+}
+
+// Active reports whether tones are pending.
+func (m *ToneMixer) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.segs) > 0
+}
+
+// Mix adds pending tone samples to frame in place.
+func (m *ToneMixer) Mix(frame []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i+1 < len(frame) && len(m.segs) > 0; i += 2 {
+		seg := m.segs[0]
+		if len(seg.freq) > 0 {
+			t := float64(m.pos) / float64(m.sampleRate)
+			var tone float64
+			for _, hz := range seg.freq {
+				tone += math.Sin(2 * math.Pi * float64(hz) * t)
+			}
+			tone *= dtmfToneGain * math.MaxInt16 / float64(len(seg.freq))
+			v := float64(int16(uint16(frame[i])|uint16(frame[i+1])<<8)) + tone
+			v = math.Max(math.MinInt16, math.Min(math.MaxInt16, v))
+			s := int16(v)
+			frame[i], frame[i+1] = byte(s), byte(s>>8)
+		}
+		m.pos++
+		if m.pos >= seg.samples {
+			m.segs = m.segs[1:]
+			m.pos = 0
+		}
+	}
+}
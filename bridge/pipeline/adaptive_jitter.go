@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/livekit/media-sdk/jitter"
+	msdkrtp "github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+	prtp "github.com/pion/rtp"
+)
+
+const (
+	// jitterAdjustEvery is how often the buffer depth is re-evaluated.
+	jitterAdjustEvery = 500 * time.Millisecond
+	// jitterShrinkHold is how long the depth stays up before shrinking, and
+	// jitterStep how much it changes at a time when shrinking or after a
+	// packet arrived too late.
+	jitterShrinkHold = 5 * time.Second
+	jitterStep       = 10 * time.Millisecond
+	// jitterDepthFactor is the depth in multiples of the measured jitter.
+	jitterDepthFactor = 3
+)
+
+// JitterStats are the counters of the adaptive jitter buffer of a call. They
+// outlive decode chain rebuilds (re-INVITE), so one value serves the call.
+type JitterStats struct {
+	depth   atomic.Int64 // time.Duration
+	jitter  atomic.Int64 // time.Duration
+	lost    atomic.Uint64
+	late    atomic.Uint64
+	resizes atomic.Uint64
+}
+
+// Depth is the current buffer delay.
+func (s *JitterStats) Depth() time.Duration { return time.Duration(s.depth.Load()) }
+
+// Jitter is the measured inter-arrival jitter (RFC 3550).
+func (s *JitterStats) Jitter() time.Duration { return time.Duration(s.jitter.Load()) }
+
+// Lost counts packets that never arrived; Late those that arrived after
+// their turn and were dropped.
+func (s *JitterStats) Lost() uint64 { return s.lost.Load() }
+func (s *JitterStats) Late() uint64 { return s.late.Load() }
+
+// Resizes counts depth changes.
+func (s *JitterStats) Resizes() uint64 { return s.resizes.Load() }
+
+// adaptiveJitter is a jitter buffer whose delay follows the measured
+// inter-arrival jitter within [min, max]: it grows as soon as the jitter
+// rises or a packet comes too late and shrinks slowly once the network
+// calmed down.
+type adaptiveJitter struct {
+	h         msdkrtp.HandlerCloser
+	buf       *jitter.Buffer
+	clockRate float64
+	min, max  time.Duration
+	stats     *JitterStats
+	log       logger.Logger
+	err       chan error
+
+	mu          sync.Mutex
+	depth       time.Duration
+	jitter      float64 // seconds
+	haveLast    bool
+	lastArrival time.Time
+	lastTS      uint32
+	lastSeq     uint16
+	nextAdjust  time.Time
+	grownAt     time.Time
+	seenLate    uint64
+}
+
+func newAdaptiveJitter(h msdkrtp.HandlerCloser, clockRate int, min, max time.Duration, stats *JitterStats, log logger.Logger) *adaptiveJitter {
+	if stats == nil {
+		stats = &JitterStats{}
+	}
+	j := &adaptiveJitter{
+		h:         h,
+		clockRate: float64(clockRate),
+		min:       min,
+		max:       max,
+		stats:     stats,
+		log:       log,
+		err:       make(chan error, 1),
+		depth:     min,
+	}
+	j.buf = jitter.NewBuffer(audioDepacketizer{}, min, func(packets []jitter.ExtPacket) {
+		for _, p := range packets {
+			if err := j.h.HandleRTP(&p.Header, p.Payload); err != nil {
+				select {
+				case j.err <- err:
+				default:
+				}
+			}
+		}
+	})
+	stats.depth.Store(int64(min))
+	return j
+}
+
+func (j *adaptiveJitter) String() string {
+	return "AdaptiveJitter -> " + j.h.String()
+}
+
+func (j *adaptiveJitter) HandleRTP(h *prtp.Header, payload []byte) error {
+	j.measure(h, time.Now())
+	// This may call the next handler, possibly multiple times.
+	j.buf.Push(&prtp.Packet{Header: *h, Payload: payload})
+	select {
+	case err := <-j.err:
+		return err
+	default:
+		return nil
+	}
+}
+
+// measure updates the jitter estimate with a packet's arrival and resizes
+// the buffer when due.
+func (j *adaptiveJitter) measure(h *prtp.Header, now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.haveLast && int16(h.SequenceNumber-j.lastSeq) > 0 {
+		// https://www.rfc-editor.org/rfc/rfc3550#appendix-A.8
+		d := now.Sub(j.lastArrival).Seconds() - float64(int32(h.Timestamp-j.lastTS))/j.clockRate
+		if d < 0 {
+			d = -d
+		}
+		// Ignore timestamp jumps (silence suppression, stream resets).
+		if d < 1 {
+			j.jitter += (d - j.jitter) / 16
+		}
+	}
+	if !j.haveLast || int16(h.SequenceNumber-j.lastSeq) > 0 {
+		j.haveLast = true
+		j.lastArrival, j.lastTS, j.lastSeq = now, h.Timestamp, h.SequenceNumber
+	}
+	j.stats.jitter.Store(int64(j.jitter * float64(time.Second)))
+	if now.Before(j.nextAdjust) {
+		return
+	}
+	j.nextAdjust = now.Add(jitterAdjustEvery)
+	j.adjust(now)
+}
+
+func (j *adaptiveJitter) adjust(now time.Time) {
+	bs := j.buf.Stats()
+	j.stats.lost.Store(bs.PacketsLost)
+	j.stats.late.Store(bs.PacketsDropped)
+	late := bs.PacketsDropped > j.seenLate
+	j.seenLate = bs.PacketsDropped
+
+	want := time.Duration(jitterDepthFactor * j.jitter * float64(time.Second))
+	want = (want + jitterStep - 1) / jitterStep * jitterStep
+	if late {
+		want = max(want, j.depth+2*jitterStep)
+	}
+	want = min(max(want, j.min), j.max)
+	switch {
+	case want > j.depth:
+		j.grownAt = now
+	case want < j.depth && now.Sub(j.grownAt) >= jitterShrinkHold:
+		want = max(want, j.depth-jitterStep)
+	default:
+		return
+	}
+	if j.log != nil {
+		j.log.Debugw("jitter buffer resized", "from", j.depth, "to", want, "jitter", j.stats.Jitter(), "late", late)
+	}
+	j.depth = want
+	j.buf.UpdateLatency(want)
+	j.stats.depth.Store(int64(want))
+	j.stats.resizes.Add(1)
+}
+
+func (j *adaptiveJitter) Close() {
+	j.buf.Close()
+	j.h.Close()
+}
+
+// audioDepacketizer treats every RTP packet as a complete audio frame.
+type audioDepacketizer struct{}
+
+func (audioDepacketizer) Unmarshal(packet []byte) ([]byte, error) { return packet, nil }
+
+func (audioDepacketizer) IsPartitionHead(payload []byte) bool { return true }
+
+func (audioDepacketizer) IsPartitionTail(marker bool, payload []byte) bool { return true }
@@ -10,28 +10,44 @@ import (
 	prtp "github.com/pion/rtp"
 )
 
+// LossConcealer is implemented by RTP decoders that can make up audio for
+// missing packets (Opus packet loss concealment).
+type LossConcealer interface {
+	// ConcealLoss writes one frame in place of a missing packet. fec is the
+	// payload of the packet following a loss, to recover the lost frame from
+	// its in-band FEC data, or nil for plain concealment.
+	ConcealLoss(fec []byte) error
+}
+
 // silenceFiller detects RTP timestamp discontinuities (DTX/silence suppression)
 // and generates silence samples to fill the gaps before passing packets to the decoder.
+// With a concealer, gaps and lost packets are filled by the decoder instead.
 //
 // This is adapted from LiveKit SIP implementation, but kept local to this bridge.
 type silenceFiller struct {
 	maxGapSize      int
 	encodedSink     msdkrtp.Handler
 	pcmSink         msdk.PCM16Writer
+	conceal         LossConcealer
+	fec             bool
 	samplesPerFrame int
+	onFill          func(frames int, dtx, concealed bool)
 	log             logger.Logger
 	lastTS          atomic.Uint64
 	lastSeq         atomic.Uint64
 	packets         atomic.Uint64
 }
 
-func newSilenceFiller(encodedSink msdkrtp.Handler, pcmSink msdk.PCM16Writer, clockRate int, log logger.Logger) msdkrtp.Handler {
+func newSilenceFiller(encodedSink msdkrtp.Handler, pcmSink msdk.PCM16Writer, conceal LossConcealer, fec bool, clockRate int, onFill func(frames int, dtx, concealed bool), log logger.Logger) msdkrtp.Handler {
 	// media-sdk assumes 20ms frame duration (rtp.DefFrameDur).
 	return &silenceFiller{
 		maxGapSize:      25,
 		encodedSink:     encodedSink,
 		pcmSink:         pcmSink,
+		conceal:         conceal,
+		fec:             fec,
 		samplesPerFrame: clockRate / msdkrtp.DefFramesPerSec,
+		onFill:          onFill,
 		log:             log,
 	}
 }
@@ -40,12 +56,15 @@ func (h *silenceFiller) String() string {
 	return "SilenceFiller -> " + h.encodedSink.String()
 }
 
-func (h *silenceFiller) isSilenceSuppression(header *prtp.Header) (bool, int) {
+// gap returns the frames missing before header. dtx is true when no
+// sequence numbers are missing, i.e. the sender paused (DTX), and false
+// when packets were lost.
+func (h *silenceFiller) gap(header *prtp.Header) (missedFrames int, dtx bool) {
 	packets := h.packets.Add(1)
 	lastSeq := uint16(h.lastSeq.Swap(uint64(header.SequenceNumber)))
 	lastTS := uint32(h.lastTS.Swap(uint64(header.Timestamp)))
 	if packets == 1 {
-		return false, 0
+		return 0, false
 	}
 
 	expectedSeq := lastSeq + 1
@@ -56,14 +75,14 @@ func (h *silenceFiller) isSilenceSuppression(header *prtp.Header) (bool, int) {
 
 	// A key characteristic of DTX is no sequence gaps, but >1 frame TS gaps.
 	if seqDiff != 0 {
-		return false, 0
+		if h.conceal == nil || int16(seqDiff) < 0 || int32(tsDiff) <= 0 {
+			// Reordered or duplicate packets are left alone.
+			return 0, false
+		}
+		return int(int32(tsDiff)) / h.samplesPerFrame, false
 	}
 
-	missedFrames := int(tsDiff) / int(h.samplesPerFrame)
-	if missedFrames == 0 {
-		return false, 0
-	}
-	return true, missedFrames
+	return int(tsDiff) / int(h.samplesPerFrame), true
 }
 
 func (h *silenceFiller) fillWithSilence(framesToFill int) error {
@@ -76,16 +95,40 @@ func (h *silenceFiller) fillWithSilence(framesToFill int) error {
 	return nil
 }
 
+// concealLoss lets the decoder fill the missing frames; the last frame of a
+// loss is recovered from the FEC data of payload when enabled.
+func (h *silenceFiller) concealLoss(missingFrames int, dtx bool, payload []byte) error {
+	for ; missingFrames > 0; missingFrames-- {
+		var fec []byte
+		if missingFrames == 1 && !dtx && h.fec {
+			fec = payload
+		}
+		if err := h.conceal.ConcealLoss(fec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *silenceFiller) HandleRTP(header *prtp.Header, payload []byte) error {
-	isDTX, missingFrameCount := h.isSilenceSuppression(header)
-	if isDTX && missingFrameCount <= h.maxGapSize*100 {
+	missingFrameCount, isDTX := h.gap(header)
+	if missingFrameCount > 0 && missingFrameCount <= h.maxGapSize*100 {
 		// Avoid flooding in case this is actually a reset.
-		if missingFrameCount <= h.maxGapSize {
-			if err := h.fillWithSilence(missingFrameCount); err != nil {
+		if missingFrameCount > h.maxGapSize {
+			if h.log != nil && time.Now().Unix()%15 == 0 {
+				h.log.Infow("large timestamp gap (ignored)", "gapFrames", missingFrameCount)
+			}
+		} else {
+			if h.onFill != nil {
+				h.onFill(missingFrameCount, isDTX, h.conceal != nil)
+			}
+			if h.conceal != nil {
+				if err := h.concealLoss(missingFrameCount, isDTX, payload); err != nil {
+					return err
+				}
+			} else if err := h.fillWithSilence(missingFrameCount); err != nil {
 				return err
 			}
-		} else if h.log != nil && time.Now().Unix()%15 == 0 {
-			h.log.Infow("large timestamp gap (ignored)", "gapFrames", missingFrameCount)
 		}
 	}
 	return h.encodedSink.HandleRTP(header, payload)
@@ -1,6 +1,8 @@
 package pipeline
 
 import (
+	"time"
+
 	msdk "github.com/livekit/media-sdk"
 	msdkrtp "github.com/livekit/media-sdk/rtp"
 	"github.com/livekit/protocol/logger"
@@ -14,8 +16,22 @@ type SipDecodeConfig struct {
 	InputChannels int
 	OutputFormat  pcm.AudioFormat
 	PlayoutBuffer *pcm.PCMPlayoutBuffer
-	EnableJitter  bool
-	Log           logger.Logger
+	// JitterMin and JitterMax bound the adaptive jitter buffer's delay; a
+	// JitterMax of 0 disables it. JitterStats (optional) receives its
+	// counters.
+	JitterMin   time.Duration
+	JitterMax   time.Duration
+	JitterStats *JitterStats
+	// PLC lets codecs that support it (LossConcealer) make up lost and
+	// suppressed frames instead of inserting silence; FEC also recovers lost
+	// frames from the in-band FEC data of the next packet.
+	PLC bool
+	FEC bool
+	// OnFill (optional) is told about the frames made up for a pause of the
+	// sender (dtx) or for lost packets, and whether the codec concealed
+	// them instead of inserting silence.
+	OnFill func(frames int, dtx, concealed bool)
+	Log    logger.Logger
 }
 
 func BuildSipDecodeChain(cfg SipDecodeConfig) (msdkrtp.HandlerCloser, error) {
@@ -43,10 +59,14 @@ func BuildSipDecodeChain(cfg SipDecodeConfig) (msdkrtp.HandlerCloser, error) {
 	clockRate := info.RTPClockRate
 
 	var h msdkrtp.Handler = cfg.Codec.DecodeRTP(sink, cfg.PayloadType)
-	h = newSilenceFiller(h, pcmSink, clockRate, cfg.Log)
+	var conceal LossConcealer
+	if c, ok := h.(LossConcealer); ok && cfg.PLC {
+		conceal = c
+	}
+	h = newSilenceFiller(h, pcmSink, conceal, cfg.FEC, clockRate, cfg.OnFill, cfg.Log)
 	var hc msdkrtp.HandlerCloser = msdkrtp.NewNopCloser(h)
-	if cfg.EnableJitter {
-		hc = msdkrtp.HandleJitter(hc)
+	if cfg.JitterMax > 0 {
+		hc = newAdaptiveJitter(hc, clockRate, cfg.JitterMin, cfg.JitterMax, cfg.JitterStats, cfg.Log)
 	}
 	return hc, nil
 }
@@ -7,17 +7,29 @@ import (
 	"github.com/emiago/diago/media"
 )
 
+// FECEncoder is implemented by encoders that can add in-band forward error
+// correction data to their packets (Opus).
+type FECEncoder interface {
+	SetFEC(on bool) error
+}
+
 type SipEncodeConfig struct {
 	Codec       msdkrtp.AudioCodec
 	PayloadType uint8
 	RTPClock    int
 	SourceRate  int
 	RTPWriter   media.RTPWriter
+	// FEC enables in-band FEC on encoders that support it.
+	FEC bool
 }
 
 type SipEncodePipeline struct {
 	Writer msdk.PCM16Writer
 	Delay  func(uint32)
+	// Seq and Stream allow adding streams (e.g. telephone-event) that share
+	// the audio SSRC and sequence numbers.
+	Seq    *msdkrtp.SeqWriter
+	Stream *msdkrtp.Stream
 }
 
 func BuildSipEncodePipeline(cfg SipEncodeConfig) (*SipEncodePipeline, error) {
@@ -38,10 +50,17 @@ func BuildSipEncodePipeline(cfg SipEncodeConfig) (*SipEncodePipeline, error) {
 	stream := seq.NewStream(cfg.PayloadType, cfg.RTPClock)
 
 	out := cfg.Codec.EncodeRTP(stream)
+	if enc, ok := out.(FECEncoder); ok {
+		if err := enc.SetFEC(cfg.FEC); err != nil {
+			return nil, err
+		}
+	}
 	out = msdk.ResampleWriter(out, cfg.SourceRate)
 
 	return &SipEncodePipeline{
 		Writer: out,
 		Delay:  stream.Delay,
+		Seq:    seq,
+		Stream: stream,
 	}, nil
 }
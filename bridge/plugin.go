@@ -0,0 +1,334 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/plugins"
+)
+
+// PluginConfig is a Go plugin to load and the settings passed to it.
+type PluginConfig struct {
+	// Path is the .so file built with go build -buildmode=plugin.
+	Path   string            `yaml:"path"`
+	Config map[string]string `yaml:"config"`
+}
+
+// loadPlugins opens the configured Go plugins and calls their constructors.
+func (s *Service) loadPlugins() error {
+	for _, pc := range s.cfg.Plugins {
+		lib, err := plugin.Open(pc.Path)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", pc.Path, err)
+		}
+		sym, err := lib.Lookup(plugins.NewPluginSymbol)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", pc.Path, err)
+		}
+		newPlugin, ok := sym.(plugins.NewPluginFunc)
+		if !ok {
+			return fmt.Errorf("plugin %s: %s has type %T, want %T", pc.Path, plugins.NewPluginSymbol, sym, plugins.NewPluginFunc(nil))
+		}
+		host := &pluginHost{s: s, logger: s.logger.With("plugin", filepath.Base(pc.Path))}
+		p, err := newPlugin(host, pc.Config)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", pc.Path, err)
+		}
+		if _, dup := s.plugin(p.Name()); dup {
+			return fmt.Errorf("plugin %s: another plugin is named %q", pc.Path, p.Name())
+		}
+		host.logger = s.logger.With("plugin", p.Name())
+		s.plugins = append(s.plugins, p)
+		_, stages := p.(plugins.StageProvider)
+		_, endpoints := p.(plugins.EndpointProvider)
+		s.logger.Info("plugin loaded", "name", p.Name(), "path", pc.Path, "stages", stages, "endpoints", endpoints)
+	}
+	return nil
+}
+
+// closePlugins shuts the plugins down.
+func (s *Service) closePlugins() {
+	for _, p := range s.plugins {
+		if err := p.Close(); err != nil {
+			s.logger.Warn("plugin close failed", "plugin", p.Name(), "error", err)
+		}
+	}
+}
+
+func (s *Service) plugin(name string) (plugins.Plugin, bool) {
+	for _, p := range s.plugins {
+		if strings.EqualFold(p.Name(), name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// pluginEndpoint returns the plugin that dials number when it has the form
+// "<plugin name>:<target>".
+func (s *Service) pluginEndpoint(number string) (plugins.EndpointProvider, string, bool) {
+	name, target, ok := strings.Cut(strings.TrimSpace(number), ":")
+	if !ok || target == "" {
+		return nil, "", false
+	}
+	p, ok := s.plugin(name)
+	if !ok {
+		return nil, "", false
+	}
+	provider, ok := p.(plugins.EndpointProvider)
+	return provider, target, ok
+}
+
+// validateDialTarget checks that number can be dialed over SIP or through
+// a plugin.
+func (s *Service) validateDialTarget(number string) error {
+	if _, _, ok := s.pluginEndpoint(number); ok {
+		return nil
+	}
+	if s.cfg.SIPQuirks.HairpinCheck && s.ownNumber(number) {
+		return ErrHairpin
+	}
+	_, err := s.buildOutboundURI(number)
+	return err
+}
+
+// dialTarget is the Call.Number of an outbound call to number: plugin
+// targets and local SIP accounts as given, phone numbers normalized.
+func (s *Service) dialTarget(number string) string {
+	if _, _, ok := s.pluginEndpoint(number); ok {
+		return strings.TrimSpace(number)
+	}
+	if _, ok := s.sipAccount(number); ok {
+		return strings.TrimSpace(number)
+	}
+	return normalizePhone(number)
+}
+
+func pluginCall(info CallInfo, local string) plugins.Call {
+	return plugins.Call{
+		ID:        info.ID,
+		Direction: string(info.Direction),
+		Number:    info.Number,
+		Local:     local,
+		ChatID:    info.ChatID,
+	}
+}
+
+// pluginStages returns the plugin stages for both directions of call.
+func (s *Service) pluginStages(call *Call, logger *slog.Logger) (toTG, toRemote []plugins.Stage) {
+	info := pluginCall(call.Info(), call.Local)
+	for _, p := range s.plugins {
+		provider, ok := p.(plugins.StageProvider)
+		if !ok {
+			continue
+		}
+		for _, dir := range []plugins.Direction{plugins.ToTelegram, plugins.ToRemote} {
+			st, err := provider.NewStage(info, dir)
+			if err != nil {
+				logger.Warn("plugin stage failed", "plugin", p.Name(), "direction", dir, "error", err)
+				continue
+			}
+			if st == nil {
+				continue
+			}
+			if dir == plugins.ToTelegram {
+				toTG = append(toTG, st)
+			} else {
+				toRemote = append(toRemote, st)
+			}
+		}
+	}
+	return toTG, toRemote
+}
+
+// attachStages adds the plugin stages to a bridged call.
+func (s *Service) attachStages(bridge *MediaBridge, call *Call, logger *slog.Logger) {
+	if len(s.plugins) == 0 {
+		return
+	}
+	bridge.SetStages(s.pluginStages(call, logger))
+}
+
+// runPluginCall connects an outbound call to target through a plugin
+// endpoint after the Telegram side answered.
+func (s *Service) runPluginCall(ctx context.Context, call *Call, provider plugins.EndpointProvider, target string) error {
+	logger := s.logger.With("tg_chat_id", call.ChatID, "dial", call.Number, "bridge_call_id", call.ID)
+	setupCtx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
+	defer cancel()
+	stopAbort := context.AfterFunc(call.ctx, cancel)
+	defer stopAbort()
+
+	leg, err := s.openTGLeg(setupCtx, call.ChatID, false)
+	if err != nil {
+		logger.Warn("tg setup failed", "error", err)
+		call.setCause(tgFailureCause(err))
+		return err
+	}
+	defer leg.Close()
+
+	s.setCallState(call, CallRinging)
+	ep, err := provider.Dial(setupCtx, target, pluginCall(call.Info(), call.Local))
+	if err != nil {
+		logger.Warn("plugin dial failed", "error", err)
+		call.setCause(cdr.CauseSIPFailure)
+		return err
+	}
+	defer ep.Close()
+	s.setCallState(call, CallAnswered)
+	s.bridgeEndpoint(call, ep, leg, logger)
+	return nil
+}
+
+// incomingPluginCall rings the Telegram user for a call a plugin received
+// and bridges it to ep.
+func (s *Service) incomingPluginCall(ctx context.Context, ep plugins.Endpoint, number, local string, logger *slog.Logger) error {
+	defer ep.Close()
+	chatID := s.cfg.TGUserID
+	call := newCall(CallInbound, number, chatID)
+	call.Local = local
+	call.Name = s.callerName(call.Number, "")
+	defer s.unregisterCall(call)
+	logger = logger.With("bridge_call_id", call.ID, "from", number, "to", local)
+
+	if reason := s.screenCaller(call.Number); reason != "" {
+		logger.Info("plugin call rejected (caller blocked)", "reason", reason)
+		call.setCause(cdr.CauseBlocked)
+		return errors.New("caller blocked")
+	}
+	if s.draining.Load() {
+		call.setCause(cdr.CauseShuttingDown)
+		return ErrDraining
+	}
+	if !s.allowCall(logger) {
+		call.setCause(cdr.CauseBusy)
+		return ErrCallLimit
+	}
+	defer s.activeCalls.Add(-1)
+	s.registerCall(call)
+
+	setupCtx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
+	defer cancel()
+	stopAbort := context.AfterFunc(call.ctx, cancel)
+	defer stopAbort()
+	// A caller hanging up while Telegram rings cancels the setup.
+	go func() {
+		select {
+		case <-ep.Done():
+			cancel()
+		case <-setupCtx.Done():
+		}
+	}()
+
+	s.setCallState(call, CallConnectingTG)
+	leg, err := s.openTGLeg(setupCtx, chatID, false)
+	if err != nil {
+		logger.Warn("tg setup failed", "error", err)
+		select {
+		case <-ep.Done():
+			call.setCause(cdr.CauseCancelled)
+		default:
+			call.setCause(tgFailureCause(err))
+		}
+		return err
+	}
+	defer leg.Close()
+	s.setCallState(call, CallAnswered)
+	s.bridgeEndpoint(call, ep, leg, logger)
+	return nil
+}
+
+// bridgeEndpoint passes audio between a plugin endpoint and the Telegram
+// leg, through the plugin stages, until either side hangs up.
+func (s *Service) bridgeEndpoint(call *Call, ep plugins.Endpoint, leg tgLeg, logger *slog.Logger) {
+	toTG, toRemote := s.pluginStages(call, logger)
+	defer func() {
+		for _, st := range append(toTG, toRemote...) {
+			if err := st.Close(); err != nil {
+				logger.Warn("plugin stage close failed", "error", err)
+			}
+		}
+	}()
+	toTGAGC, toRemoteAGC := s.newAGC(s.cfg.AGCToTG), s.newAGC(s.cfg.AGCToSIP)
+	format := leg.Format()
+	silence := make([]byte, format.FrameBytes())
+	var tgBuf, remoteBuf []byte
+	s.setCallState(call, CallBridged)
+	logger.Info("plugin call bridged")
+	start := time.Now()
+
+	ticker := time.NewTicker(format.FrameDur)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ep.Done():
+			logger.Info("plugin call ended - remote hung up", "duration", time.Since(start).Round(time.Millisecond))
+			call.setCause(cdr.CauseSIPHangup)
+			return
+		case <-leg.Done():
+			logger.Info("plugin call ended - telegram side ended", "duration", time.Since(start).Round(time.Millisecond))
+			call.setCause(cdr.CauseTelegramHangup)
+			return
+		case <-call.Done():
+			call.setCause(cdr.CauseLocalHangup)
+			return
+		case <-ticker.C:
+			tgBuf = append(tgBuf[:0], popFrame(ep.Frames(), silence)...)
+			if toTGAGC != nil {
+				toTGAGC.Process(tgBuf)
+			}
+			for _, st := range toTG {
+				st.Process(tgBuf)
+			}
+			if err := leg.SendPCMFrame10ms(tgBuf); err != nil {
+				logger.Warn("tg send failed", "error", err)
+				call.setCause(cdr.CauseTelegramHangup)
+				return
+			}
+			for {
+				frame := popFrame(leg.SpeakerFrames(), nil)
+				if frame == nil {
+					break
+				}
+				remoteBuf = append(remoteBuf[:0], frame...)
+				if toRemoteAGC != nil {
+					toRemoteAGC.Process(remoteBuf)
+				}
+				for _, st := range toRemote {
+					st.Process(remoteBuf)
+				}
+				if err := ep.WriteFrame(remoteBuf); err != nil {
+					logger.Warn("plugin endpoint write failed", "error", err)
+					call.setCause(cdr.CauseMediaFailure)
+					return
+				}
+			}
+		}
+	}
+}
+
+// pluginHost is the Host handed to a plugin.
+type pluginHost struct {
+	s      *Service
+	logger *slog.Logger
+}
+
+func (h *pluginHost) Version() int { return plugins.Version }
+
+func (h *pluginHost) SampleRate() int { return h.s.tgFormat().SampleRate }
+
+func (h *pluginHost) Logger() *slog.Logger { return h.logger }
+
+func (h *pluginHost) IncomingCall(ctx context.Context, ep plugins.Endpoint, number, local string) error {
+	return h.s.incomingPluginCall(ctx, ep, number, local, h.logger)
+}
+
+func (h *pluginHost) SendMessage(text string) {
+	h.s.notify(h.s.cfg.TGUserID, text)
+}
@@ -0,0 +1,116 @@
+// Package plugins is the interface between the bridge and plugins built as
+// Go plugins (go build -buildmode=plugin), so custom audio stages and call
+// endpoints can ship without forking the repository. It only depends on the
+// standard library and changes in backwards compatible ways only.
+//
+// A plugin is a main package exporting
+//
+//	func NewPlugin(host plugins.Host, config map[string]string) (plugins.Plugin, error)
+//
+// called once at startup with its plugins[].config entries. The returned
+// value may also implement StageProvider and/or EndpointProvider. Go
+// plugins must be built with the same Go version and module versions as the
+// bridge itself.
+//
+// Audio passed to and from plugins is 16-bit little-endian mono PCM at
+// Host.SampleRate, one 10ms frame at a time.
+package plugins
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Version is the interface version; a plugin can check Host.Version.
+const Version = 1
+
+// NewPluginSymbol is the name of the constructor a plugin exports.
+const NewPluginSymbol = "NewPlugin"
+
+// NewPluginFunc is the type of the exported constructor.
+type NewPluginFunc = func(host Host, config map[string]string) (Plugin, error)
+
+// Plugin is a loaded plugin.
+type Plugin interface {
+	// Name identifies the plugin in logs; endpoint targets are addressed
+	// as "<name>:<target>".
+	Name() string
+	// Close is called on shutdown.
+	Close() error
+}
+
+// Call describes a call to plugins.
+type Call struct {
+	ID string
+	// Direction is "inbound" or "outbound".
+	Direction string
+	// Number is the remote party, Local our number.
+	Number string
+	Local  string
+	// ChatID is the Telegram user or group of the call.
+	ChatID int64
+}
+
+// Direction is the direction of audio through a stage.
+type Direction string
+
+const (
+	// ToTelegram is the audio of the remote party, heard on Telegram.
+	ToTelegram Direction = "to_telegram"
+	// ToRemote is the audio from Telegram, heard by the remote party.
+	ToRemote Direction = "to_remote"
+)
+
+// Stage processes the audio of one call in one direction.
+type Stage interface {
+	// Process modifies a frame in place. It runs on the media path and
+	// must not block.
+	Process(frame []byte)
+	// Close is called when the call ends.
+	Close() error
+}
+
+// StageProvider adds stages to bridged calls.
+type StageProvider interface {
+	// NewStage returns the stage for one direction of call, or nil to
+	// leave it alone.
+	NewStage(call Call, dir Direction) (Stage, error)
+}
+
+// Endpoint is the remote party of a call through a plugin, in place of a
+// SIP leg.
+type Endpoint interface {
+	// Frames delivers the remote party's audio. The bridge reads one frame
+	// every 10ms and sends silence when none is queued.
+	Frames() <-chan []byte
+	// WriteFrame passes a frame of Telegram audio to the remote party. It
+	// must not block.
+	WriteFrame(frame []byte) error
+	// Done is closed when the remote party hangs up.
+	Done() <-chan struct{}
+	// Close hangs up; it is called once the call ends on either side.
+	Close() error
+}
+
+// EndpointProvider places calls to targets of the plugin (/call
+// <name>:<target>).
+type EndpointProvider interface {
+	// Dial connects to target, returning once it answered or failed.
+	Dial(ctx context.Context, target string, call Call) (Endpoint, error)
+}
+
+// Host is the bridge as seen by a plugin.
+type Host interface {
+	// Version is the plugins interface version of the bridge.
+	Version() int
+	// SampleRate is the rate of all audio frames (audio.sample_rate).
+	SampleRate() int
+	// Logger logs with the plugin's name attached.
+	Logger() *slog.Logger
+	// IncomingCall rings the Telegram user for a call the plugin received
+	// from number to local and bridges it to ep until either side hangs
+	// up. It returns once the call ended.
+	IncomingCall(ctx context.Context, ep Endpoint, number, local string) error
+	// SendMessage sends a Telegram message to the bridge's user.
+	SendMessage(text string)
+}
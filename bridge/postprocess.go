@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"gotgcalls/bridge/recording"
+)
+
+// postProcessQueue bounds the recordings waiting for post-processing.
+const postProcessQueue = 64
+
+type postJob struct {
+	files []string
+	// done, when set, receives the processed paths (the originals if
+	// processing failed).
+	done func(files []string)
+}
+
+// startPostProcessor runs the postprocess worker until ctx is done. Jobs
+// are handled one at a time so a burst of hangups does not starve the
+// media goroutines of CPU.
+func (s *Service) startPostProcessor(ctx context.Context) {
+	if !s.cfg.PostProcess {
+		return
+	}
+	jobs := make(chan postJob, postProcessQueue)
+	s.postJobs = jobs
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-jobs:
+				files := s.postProcess(job.files)
+				if job.done != nil {
+					job.done(files)
+				}
+			}
+		}
+	}()
+}
+
+// queuePostProcess hands files to the worker. Without a worker (or with a
+// full queue) the files stay as recorded.
+func (s *Service) queuePostProcess(files []string, done func(files []string)) {
+	job := postJob{files: files, done: done}
+	select {
+	case s.postJobs <- job:
+		return
+	default:
+	}
+	if s.postJobs != nil {
+		s.logger.Warn("postprocess: queue full, keeping files as recorded", "files", files)
+	}
+	if done != nil {
+		done(files)
+	}
+}
+
+func (s *Service) postProcess(files []string) []string {
+	started := time.Now()
+	out, err := recording.Process(files, recording.PostOptions{
+		TrimSilence:      s.cfg.PostTrimSilence,
+		SilenceThreshold: s.cfg.PostSilenceThreshold,
+		TargetLUFS:       s.cfg.PostTargetLUFS,
+		Opus:             s.cfg.PostOpus,
+		Key:              s.recordingKey,
+	})
+	if err != nil {
+		s.logger.Warn("postprocess failed, keeping files as recorded", "files", files, "error", err)
+		return files
+	}
+	s.logger.Info("postprocess done", "files", out, "took", time.Since(started).Round(time.Millisecond))
+	return out
+}
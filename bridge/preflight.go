@@ -0,0 +1,228 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gotgcalls/third_party/ntgcalls"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// PreflightResult is the outcome of a single startup check.
+type PreflightResult struct {
+	Name     string
+	OK       bool
+	Critical bool
+	Detail   string
+}
+
+type PreflightReport struct {
+	Results []PreflightResult
+}
+
+// Failed reports whether any critical check failed.
+func (r PreflightReport) Failed() bool {
+	for _, res := range r.Results {
+		if res.Critical && !res.OK {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PreflightReport) String() string {
+	var b strings.Builder
+	b.WriteString("preflight report:\n")
+	for _, res := range r.Results {
+		status := "PASS"
+		if !res.OK {
+			status = "WARN"
+			if res.Critical {
+				status = "FAIL"
+			}
+		}
+		fmt.Fprintf(&b, "  [%s] %-16s %s\n", status, res.Name, res.Detail)
+	}
+	return b.String()
+}
+
+// RunPreflight verifies the environment before the bridge starts serving.
+// It must run before the SIP transports bind, since it probes the SIP port.
+func RunPreflight(cfg Config, tgClient *tg.Client) PreflightReport {
+	results := preflightSIPPorts(cfg)
+	results = append(results,
+		preflightCodecs(cfg),
+		preflightNTgCalls(),
+		preflightClock(),
+		preflightTelegramCalls(cfg, tgClient),
+	)
+	return PreflightReport{Results: results}
+}
+
+// preflightSIPPorts probes the SIP port of every transport the bridge
+// listens on (sip.bind_hosts); the first IPv4 UDP one is also checked with
+// STUN.
+func preflightSIPPorts(cfg Config) []PreflightResult {
+	var results []PreflightResult
+	stunned := false
+	for _, t := range SIPTransports(cfg) {
+		addr := net.JoinHostPort(t.BindHost, strconv.Itoa(t.BindPort))
+		res := PreflightResult{Name: "sip " + t.Transport, Critical: true}
+		if strings.HasPrefix(t.Transport, "tcp") {
+			ln, err := net.Listen(t.Transport, addr)
+			if err != nil {
+				res.Detail = fmt.Sprintf("cannot bind %s/%s: %v", t.Transport, addr, err)
+			} else {
+				ln.Close()
+				res.OK = true
+				res.Detail = fmt.Sprintf("bound %s/%s", t.Transport, addr)
+			}
+			results = append(results, res)
+			continue
+		}
+		conn, err := net.ListenPacket(t.Transport, addr)
+		if err != nil {
+			res.Detail = fmt.Sprintf("cannot bind %s/%s: %v", t.Transport, addr, err)
+			results = append(results, res)
+			continue
+		}
+		if t.Transport == "udp4" && !stunned {
+			stunned = true
+			res = preflightSTUN(cfg, conn, res, t.Transport+"/"+addr)
+		} else {
+			res.OK = true
+			res.Detail = fmt.Sprintf("bound %s/%s", t.Transport, addr)
+		}
+		conn.Close()
+		results = append(results, res)
+	}
+	return results
+}
+
+// preflightSTUN completes res for the UDP socket conn bound to bound with
+// the public address STUN sees for it.
+func preflightSTUN(cfg Config, conn net.PacketConn, res PreflightResult, bound string) PreflightResult {
+	mapped, err := stunBinding(conn, cfg.STUNServer)
+	if err != nil {
+		// Binding works; reachability is unknown but not fatal (e.g. STUN blocked).
+		res.Critical = false
+		res.Detail = fmt.Sprintf("bound %s, stun check failed: %v", bound, err)
+		return res
+	}
+	res.OK = true
+	res.Detail = fmt.Sprintf("bound %s, public address %s", bound, mapped)
+	if mapped.Port != cfg.SIPBindPort {
+		res.Critical = false
+		res.OK = false
+		res.Detail += " (NAT rewrites the port; forward it or use TCP)"
+	}
+	if cfg.SIPExternalIP != "" && !mapped.IP.Equal(net.ParseIP(cfg.SIPExternalIP)) {
+		res.Critical = false
+		res.OK = false
+		res.Detail += fmt.Sprintf(" (sip.external_ip is %s)", cfg.SIPExternalIP)
+	}
+	return res
+}
+
+func preflightCodecs(cfg Config) PreflightResult {
+	res := PreflightResult{Name: "codecs", Critical: true}
+	var names []string
+	hasOpus := false
+	for _, c := range SIPCodecs(cfg) {
+		if strings.EqualFold(c.Name, "telephone-event") {
+			continue
+		}
+		if strings.EqualFold(c.Name, "opus") {
+			hasOpus = true
+		}
+		names = append(names, fmt.Sprintf("%s/%d", c.Name, c.SampleRate))
+	}
+	if len(names) == 0 {
+		res.Detail = "no audio codecs available"
+		return res
+	}
+	res.OK = true
+	res.Detail = strings.Join(names, ", ")
+	if !hasOpus {
+		res.Detail += " (opus not built in; build with -tags opus)"
+	}
+	return res
+}
+
+func preflightNTgCalls() PreflightResult {
+	res := PreflightResult{Name: "ntgcalls", Critical: true}
+	version := ntgcalls.Version()
+	proto := ntgcalls.GetProtocol()
+	if version == "" {
+		res.Detail = "library version unavailable"
+		return res
+	}
+	if proto.MinLayer <= 0 || proto.MaxLayer < proto.MinLayer || len(proto.Versions) == 0 {
+		res.Detail = fmt.Sprintf("v%s reports invalid protocol (layers %d-%d, versions %v)", version, proto.MinLayer, proto.MaxLayer, proto.Versions)
+		return res
+	}
+	res.OK = true
+	res.Detail = fmt.Sprintf("v%s, layers %d-%d, versions %s", version, proto.MinLayer, proto.MaxLayer, strings.Join(proto.Versions, ","))
+	return res
+}
+
+// preflightClock checks that a 10ms ticker (the TG injection pace) is accurate.
+func preflightClock() PreflightResult {
+	res := PreflightResult{Name: "clock"}
+	const (
+		step  = 10 * time.Millisecond
+		ticks = 20
+	)
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	var totalDev, maxDev time.Duration
+	last := time.Now()
+	for i := 0; i < ticks; i++ {
+		<-ticker.C
+		now := time.Now()
+		dev := now.Sub(last) - step
+		if dev < 0 {
+			dev = -dev
+		}
+		totalDev += dev
+		maxDev = max(maxDev, dev)
+		last = now
+	}
+	avg := totalDev / ticks
+	res.Detail = fmt.Sprintf("10ms ticker avg deviation %s, max %s", avg.Round(time.Microsecond), maxDev.Round(time.Microsecond))
+	res.OK = avg <= 2*time.Millisecond
+	return res
+}
+
+func preflightTelegramCalls(cfg Config, tgClient *tg.Client) PreflightResult {
+	res := PreflightResult{Name: "telegram calls", Critical: true}
+	if tgClient == nil {
+		res.Detail = "telegram client not available"
+		return res
+	}
+	user, err := tgClient.GetSendableUser(cfg.TGUserID)
+	if err != nil {
+		res.Detail = fmt.Sprintf("cannot resolve user %d (send a message to the bridge account first): %v", cfg.TGUserID, err)
+		return res
+	}
+	full, err := tgClient.UsersGetFullUser(user)
+	if err != nil || full == nil || full.FullUser == nil {
+		res.Detail = fmt.Sprintf("cannot fetch user %d: %v", cfg.TGUserID, err)
+		return res
+	}
+	switch {
+	case !full.FullUser.PhoneCallsAvailable:
+		res.Detail = fmt.Sprintf("user %d can't receive calls from this account", cfg.TGUserID)
+	case full.FullUser.PhoneCallsPrivate:
+		res.Critical = false
+		res.Detail = fmt.Sprintf("user %d has private calls enabled (P2P disabled, relays only)", cfg.TGUserID)
+	default:
+		res.OK = true
+		res.Detail = fmt.Sprintf("user %d accepts calls", cfg.TGUserID)
+	}
+	return res
+}
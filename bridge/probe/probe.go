@@ -0,0 +1,268 @@
+// Package probe measures the audio round trip of a call leg: a short chirp
+// is mixed into the audio sent to the leg and searched for in the audio that
+// comes back. This only produces a result when the far end loops audio back
+// (echo test numbers, loopback devices, or a handset's acoustic echo), but
+// then it measures what the listener actually hears instead of buffer sizes.
+package probe
+
+import (
+	"math"
+	"math/cmplx"
+	"sync"
+	"time"
+)
+
+const (
+	chirpDur = 40 * time.Millisecond
+	// listenDur bounds the round trip that can be measured.
+	listenDur = 1500 * time.Millisecond
+	// minCorrelation is the normalized correlation a match must reach; the
+	// echo passes through codecs and mixes with speech, so it is far from 1.
+	minCorrelation = 0.3
+	// staleAfter is how many probes may go unheard before the last result
+	// is dropped.
+	staleAfter = 4
+)
+
+// Band is the frequency sweep of a chirp.
+type Band struct {
+	From, To float64
+	Gain     float64
+}
+
+var (
+	// Ultrasonic stays above what most listeners hear; it needs a sample
+	// rate of at least 44.1 kHz and a wideband path (e.g. Telegram's Opus).
+	Ultrasonic = Band{From: 18000, To: 20000, Gain: 0.1}
+	// Voiceband fits narrowband telephone codecs; it is kept short and quiet
+	// but remains faintly audible.
+	Voiceband = Band{From: 500, To: 3000, Gain: 0.03}
+)
+
+// BandFor returns Ultrasonic when sampleRate can carry it, else Voiceband.
+func BandFor(sampleRate int) Band {
+	if sampleRate >= 44100 {
+		return Ultrasonic
+	}
+	return Voiceband
+}
+
+// Prober sends a chirp every interval and reports the delay until it is heard
+// again. Mix and Feed are called from the media goroutines of the outgoing
+// and incoming direction with PCM16LE mono frames.
+type Prober struct {
+	mu         sync.Mutex
+	sampleRate int
+	interval   time.Duration
+	chirp      []int16
+	template   []float64
+	window     int
+
+	pos       int // next chirp sample to mix; -1 when not sending
+	sentAt    time.Time
+	listening bool
+	heardAt   time.Time
+	heard     []int16
+	detecting bool
+	nextAt    time.Time
+
+	last       time.Duration
+	measuredAt time.Time
+}
+
+func New(sampleRate int, band Band, interval time.Duration) *Prober {
+	chirp, template := makeChirp(sampleRate, band)
+	return &Prober{
+		sampleRate: sampleRate,
+		interval:   interval,
+		chirp:      chirp,
+		template:   template,
+		window:     int(listenDur.Seconds() * float64(sampleRate)),
+		pos:        -1,
+	}
+}
+
+// Chirp returns the chirp of band for test signals that are not mixed in by
+// a Prober; Find locates it in the audio that comes back.
+func Chirp(sampleRate int, band Band) []int16 {
+	chirp, _ := makeChirp(sampleRate, band)
+	return chirp
+}
+
+// Find returns the offset in heard where the chirp of band correlates best
+// and the normalized correlation there; ok reports whether the match is
+// strong enough to count as heard.
+func Find(heard []int16, sampleRate int, band Band) (offset int, correlation float64, ok bool) {
+	_, template := makeChirp(sampleRate, band)
+	offset, correlation = findChirp(heard, template)
+	return offset, correlation, correlation >= minCorrelation
+}
+
+func makeChirp(sampleRate int, band Band) (chirp []int16, template []float64) {
+	n := int(chirpDur.Seconds() * float64(sampleRate))
+	chirp = make([]int16, n)
+	template = make([]float64, n)
+	dur := chirpDur.Seconds()
+	for i := range n {
+		t := float64(i) / float64(sampleRate)
+		// Linear sweep with a Hann window so the chirp starts and ends without clicks.
+		phase := 2 * math.Pi * (band.From*t + (band.To-band.From)*t*t/(2*dur))
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		v := math.Sin(phase) * w
+		template[i] = v
+		chirp[i] = int16(v * band.Gain * math.MaxInt16)
+	}
+	return chirp, template
+}
+
+// Mix adds the chirp to frame when a probe is due; now is when frame is sent.
+func (p *Prober) Mix(frame []byte, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pos < 0 {
+		if p.listening || p.detecting || now.Before(p.nextAt) {
+			return
+		}
+		p.pos = 0
+		p.sentAt = now
+		p.listening = true
+		p.heardAt = time.Time{}
+		p.heard = p.heard[:0]
+	}
+	for i := 0; i+1 < len(frame) && p.pos < len(p.chirp); i += 2 {
+		v := int32(int16(uint16(frame[i])|uint16(frame[i+1])<<8)) + int32(p.chirp[p.pos])
+		v = max(math.MinInt16, min(math.MaxInt16, v))
+		frame[i], frame[i+1] = byte(v), byte(v>>8)
+		p.pos++
+	}
+	if p.pos >= len(p.chirp) {
+		p.pos = -1
+	}
+}
+
+// Feed passes audio received from the leg; now is when frame arrived.
+func (p *Prober) Feed(frame []byte, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.listening {
+		return
+	}
+	if p.heardAt.IsZero() {
+		p.heardAt = now
+	}
+	for i := 0; i+1 < len(frame); i += 2 {
+		p.heard = append(p.heard, int16(uint16(frame[i])|uint16(frame[i+1])<<8))
+	}
+	if len(p.heard) < p.window {
+		return
+	}
+	p.listening = false
+	p.detecting = true
+	heard := append([]int16(nil), p.heard...)
+	// The first heard sample was received heardAt, which is slightly after
+	// the chirp left.
+	offset := p.heardAt.Sub(p.sentAt)
+	// Correlating a full window is too slow for the media goroutine.
+	go func() {
+		lag, correlation := findChirp(heard, p.template)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.detecting = false
+		p.nextAt = time.Now().Add(p.interval)
+		if correlation >= minCorrelation {
+			p.last = offset + time.Duration(lag)*time.Second/time.Duration(p.sampleRate)
+			p.measuredAt = time.Now()
+		}
+	}()
+}
+
+// RoundTrip returns the last measured round trip; ok is false until a chirp
+// has been heard back and again once none was heard for a few intervals.
+func (p *Prober) RoundTrip() (rtt time.Duration, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.measuredAt.IsZero() || time.Since(p.measuredAt) > staleAfter*(p.interval+listenDur) {
+		return 0, false
+	}
+	return p.last, true
+}
+
+// findChirp returns the offset in heard where template correlates best and
+// the normalized correlation there.
+func findChirp(heard []int16, template []float64) (int, float64) {
+	m := len(template)
+	if len(heard) < m || m == 0 {
+		return 0, 0
+	}
+	n := 1
+	for n < len(heard)+m {
+		n <<= 1
+	}
+	x := make([]complex128, n)
+	for i, v := range heard {
+		x[i] = complex(float64(v)/math.MaxInt16, 0)
+	}
+	t := make([]complex128, n)
+	var tplEnergy float64
+	for i, v := range template {
+		t[i] = complex(v, 0)
+		tplEnergy += v * v
+	}
+	fft(x, false)
+	fft(t, false)
+	for i := range x {
+		x[i] *= cmplx.Conj(t[i])
+	}
+	fft(x, true)
+
+	// Normalize by the energy of each heard segment so loud speech does not
+	// outscore a quiet echo.
+	prefix := make([]float64, len(heard)+1)
+	for i, v := range heard {
+		f := float64(v) / math.MaxInt16
+		prefix[i+1] = prefix[i] + f*f
+	}
+	best, bestLag := 0.0, 0
+	for lag := 0; lag+m <= len(heard); lag++ {
+		seg := prefix[lag+m] - prefix[lag]
+		if seg <= 1e-9 {
+			continue
+		}
+		c := real(x[lag]) / float64(n) / math.Sqrt(seg*tplEnergy)
+		if c > best {
+			best, bestLag = c, lag
+		}
+	}
+	return bestLag, best
+}
+
+// fft transforms x in place (radix-2, len(x) must be a power of two). The
+// inverse transform is not scaled.
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/pcm"
+)
+
+const (
+	// progressToneGain is the level of the tones (about -17 dBFS).
+	progressToneGain = 0.14
+	// progressToneFailureDur is how long a busy or congestion tone plays
+	// before the Telegram call of a failed outbound call ends.
+	progressToneFailureDur = 4 * time.Second
+)
+
+// toneCadence is a call progress tone: frequencies played in steps that
+// alternate between on and off, repeated.
+type toneCadence struct {
+	freqs []float64
+	steps []time.Duration
+}
+
+// progressTones are the tones of one country (ITU-T E.180).
+type progressTones struct {
+	ringback, busy, congestion toneCadence
+}
+
+// cadence builds a toneCadence from steps in milliseconds.
+func cadence(freqs []float64, stepsMs ...int) toneCadence {
+	c := toneCadence{freqs: freqs}
+	for _, step := range stepsMs {
+		c.steps = append(c.steps, time.Duration(step)*time.Millisecond)
+	}
+	return c
+}
+
+// progressToneSets are the countries audio.progress_tones can select.
+var progressToneSets = map[string]progressTones{
+	"us": {
+		ringback:   cadence([]float64{440, 480}, 2000, 4000),
+		busy:       cadence([]float64{480, 620}, 500, 500),
+		congestion: cadence([]float64{480, 620}, 250, 250),
+	},
+	"uk": {
+		ringback:   cadence([]float64{400, 450}, 400, 200, 400, 2000),
+		busy:       cadence([]float64{400}, 375, 375),
+		congestion: cadence([]float64{400}, 400, 350, 225, 525),
+	},
+	// CEPT, used by most of Europe.
+	"eu": {
+		ringback:   cadence([]float64{425}, 1000, 4000),
+		busy:       cadence([]float64{425}, 500, 500),
+		congestion: cadence([]float64{425}, 250, 250),
+	},
+	"de": {
+		ringback:   cadence([]float64{425}, 1000, 4000),
+		busy:       cadence([]float64{425}, 480, 480),
+		congestion: cadence([]float64{425}, 240, 240),
+	},
+	"fr": {
+		ringback:   cadence([]float64{440}, 1500, 3500),
+		busy:       cadence([]float64{440}, 500, 500),
+		congestion: cadence([]float64{440}, 250, 250),
+	},
+	"ru": {
+		ringback:   cadence([]float64{425}, 800, 3200),
+		busy:       cadence([]float64{425}, 400, 400),
+		congestion: cadence([]float64{425}, 200, 200),
+	},
+	"jp": {
+		ringback:   cadence([]float64{400}, 1000, 2000),
+		busy:       cadence([]float64{400}, 500, 500),
+		congestion: cadence([]float64{400}, 500, 500),
+	},
+	"au": {
+		ringback:   cadence([]float64{400, 450}, 400, 200, 400, 2000),
+		busy:       cadence([]float64{425}, 375, 375),
+		congestion: cadence([]float64{425}, 375, 375),
+	},
+}
+
+// progressToneCountries lists the keys of progressToneSets, for errors.
+func progressToneCountries() string {
+	names := make([]string, 0, len(progressToneSets))
+	for name := range progressToneSets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// fill writes the tone into frame (PCM16LE in format) from sample pos on
+// and returns the position after it.
+func (c toneCadence) fill(frame []byte, format pcm.AudioFormat, pos int) int {
+	var cycle time.Duration
+	for _, step := range c.steps {
+		cycle += step
+	}
+	rate := float64(format.SampleRate)
+	channels := max(format.Channels, 1)
+	for i := 0; i+2*channels <= len(frame); i += 2 * channels {
+		t := float64(pos) / rate
+		on := len(c.steps) == 0
+		if cycle > 0 {
+			offset := time.Duration(math.Mod(t, cycle.Seconds()) * float64(time.Second))
+			for n, step := range c.steps {
+				if offset < step {
+					on = n%2 == 0
+					break
+				}
+				offset -= step
+			}
+		}
+		var v float64
+		if on {
+			for _, hz := range c.freqs {
+				v += math.Sin(2 * math.Pi * hz * t)
+			}
+			v *= progressToneGain / float64(len(c.freqs))
+		}
+		sample := uint16(int16(v * math.MaxInt16))
+		for ch := range channels {
+			binary.LittleEndian.PutUint16(frame[i+2*ch:], sample)
+		}
+		pos++
+	}
+	return pos
+}
+
+// playProgressTone plays tone to leg, once start is closed (nil starts at
+// once), until stop is called. The Telegram user's audio is discarded
+// meanwhile. stop returns once the tone is no longer played.
+func playProgressTone(leg tgLeg, tone toneCadence, start <-chan struct{}) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if start != nil {
+			select {
+			case <-start:
+			case <-ctx.Done():
+				return
+			case <-leg.Done():
+				return
+			}
+		}
+		format := leg.Format()
+		frame := make([]byte, format.FrameBytes())
+		ticker := time.NewTicker(format.FrameDur)
+		defer ticker.Stop()
+		pos := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-leg.Done():
+				return
+			case <-ticker.C:
+				for popFrame(leg.SpeakerFrames(), nil) != nil {
+				}
+				pos = tone.fill(frame, format, pos)
+				if err := leg.SendPCMFrame10ms(frame); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// callProgressTones returns the tones for the Telegram leg of call; ok is
+// false when audio.progress_tones is off or the leg is in a voice chat,
+// where they would disturb everyone.
+func (s *Service) callProgressTones(call *Call) (tones progressTones, ok bool) {
+	if call.mixer != nil || call.ChatID < 0 {
+		return progressTones{}, false
+	}
+	tones, ok = progressToneSets[s.cfg.ProgressTones]
+	return tones, ok
+}
+
+// startRingbackTone plays a ringback tone to leg once the callee of call
+// rings without sending early media, until the returned stop is called.
+func (s *Service) startRingbackTone(call *Call, leg tgLeg) (stop func()) {
+	tones, ok := s.callProgressTones(call)
+	if !ok {
+		return func() {}
+	}
+	return playProgressTone(leg, tones.ringback, call.sipAlerted())
+}
+
+// playFailureTone plays a busy tone (486, 600) or congestion tone (other
+// failure responses) to leg after the INVITE of call failed with err, for
+// progressToneFailureDur or until either side hangs up. Cancelled INVITEs
+// (487) get none.
+func (s *Service) playFailureTone(call *Call, leg tgLeg, err error) {
+	tones, ok := s.callProgressTones(call)
+	status := inviteFailureStatus(err)
+	if !ok || status == 0 || status == sip.StatusRequestTerminated {
+		return
+	}
+	tone := tones.congestion
+	if status == sip.StatusBusyHere || status == sip.StatusGlobalBusyEverywhere {
+		tone = tones.busy
+	}
+	stop := playProgressTone(leg, tone, nil)
+	defer stop()
+	timer := time.NewTimer(progressToneFailureDur)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-call.Done():
+	case <-leg.Done():
+	}
+}
+
+// inviteFailureStatus is the status of the final response an INVITE failed
+// with, or 0 when it failed otherwise.
+func inviteFailureStatus(err error) int {
+	if res := inviteFailureResponse(err); res != nil {
+		return res.StatusCode
+	}
+	return 0
+}
+
+// inviteFailureResponse is the final response an INVITE failed with, or nil.
+func inviteFailureResponse(err error) *sip.Response {
+	var res sipgo.ErrDialogResponse
+	if errors.As(err, &res) && res.Res != nil {
+		return res.Res
+	}
+	var resPtr *sipgo.ErrDialogResponse
+	if errors.As(err, &resPtr) && resPtr.Res != nil {
+		return resPtr.Res
+	}
+	return nil
+}
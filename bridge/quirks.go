@@ -0,0 +1,278 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// SIP quirks, listed in a provider profile or sip.quirks as "name" or
+// "name=value".
+const (
+	// QuirkUserPhone adds user=phone to the Request-URI of outbound calls.
+	QuirkUserPhone = "user_phone"
+	// QuirkNoRport leaves rport (RFC 3581) out of Via headers.
+	QuirkNoRport = "no_rport"
+	// QuirkG711AfterReInvites=N only accepts G.711 once N re-INVITEs were
+	// received on a call (2 by default).
+	QuirkG711AfterReInvites = "g711_after_reinvites"
+	// QuirkOptionsKeepAlive=interval sends in-dialog OPTIONS during calls
+	// (every 30s by default) and ends calls whose dialog the provider lost.
+	QuirkOptionsKeepAlive = "options_keepalive"
+	// QuirkMaxRing=duration cancels outbound INVITEs not answered within
+	// it (60s by default), for trunks whose ring time costs.
+	QuirkMaxRing = "max_ring"
+	// QuirkEarlyMediaTimeout=duration cancels outbound calls that stay in
+	// early media longer (30s by default): trunks that play announcements
+	// or ringback as early media and then claim an answer to bill it.
+	QuirkEarlyMediaTimeout = "early_media_timeout"
+	// QuirkHairpinCheck refuses outbound calls to the bridge's own numbers,
+	// which the trunk would route straight back to us.
+	QuirkHairpinCheck = "hairpin_check"
+)
+
+// quirkProfiles are the built-in sip.provider_profile values; profiles
+// configured in provider_profiles are added to and may replace them.
+var quirkProfiles = map[string][]string{
+	"default": nil,
+	// IMS cores route E.164 numbers only with user=phone and clean up
+	// dialogs that look idle.
+	"ims": {QuirkUserPhone, QuirkOptionsKeepAlive},
+	// Older SBCs that break on rport and fall back to G.711 on re-INVITEs.
+	"legacy_sbc": {QuirkNoRport, QuirkG711AfterReInvites},
+	// Trunks that bill on answer, some of them on a claimed one.
+	"bill_on_answer": {QuirkMaxRing, QuirkEarlyMediaTimeout, QuirkHairpinCheck},
+}
+
+// ErrHairpin is returned by the hairpin_check quirk for calls to one of the
+// bridge's own numbers.
+var ErrHairpin = errors.New("number routes back to the bridge")
+
+// optionsKeepAliveFailures is how many in-dialog OPTIONS in a row may go
+// unanswered before the call is ended.
+const optionsKeepAliveFailures = 2
+
+// Quirks are the provider specific SIP behaviors in effect.
+type Quirks struct {
+	UserPhone bool
+	NoRport   bool
+	// G711AfterReInvites is the number of re-INVITEs after which only G.711
+	// is accepted (0 = off).
+	G711AfterReInvites int
+	// OptionsKeepAlive is the interval of in-dialog OPTIONS (0 = off).
+	OptionsKeepAlive time.Duration
+	// MaxRing bounds the ring time of outbound calls and EarlyMediaTimeout
+	// their time in early media (0 = off).
+	MaxRing           time.Duration
+	EarlyMediaTimeout time.Duration
+	HairpinCheck      bool
+}
+
+// Enabled lists the quirks in effect, for logs.
+func (q Quirks) Enabled() []string {
+	var names []string
+	if q.UserPhone {
+		names = append(names, QuirkUserPhone)
+	}
+	if q.NoRport {
+		names = append(names, QuirkNoRport)
+	}
+	if q.G711AfterReInvites > 0 {
+		names = append(names, QuirkG711AfterReInvites+"="+strconv.Itoa(q.G711AfterReInvites))
+	}
+	if q.OptionsKeepAlive > 0 {
+		names = append(names, QuirkOptionsKeepAlive+"="+q.OptionsKeepAlive.String())
+	}
+	if q.MaxRing > 0 {
+		names = append(names, QuirkMaxRing+"="+q.MaxRing.String())
+	}
+	if q.EarlyMediaTimeout > 0 {
+		names = append(names, QuirkEarlyMediaTimeout+"="+q.EarlyMediaTimeout.String())
+	}
+	if q.HairpinCheck {
+		names = append(names, QuirkHairpinCheck)
+	}
+	return names
+}
+
+// parseQuirks resolves profile (built-in or from custom) and adds extra.
+func parseQuirks(profile string, custom map[string][]string, extra []string) (Quirks, error) {
+	var q Quirks
+	var names []string
+	if profile != "" {
+		list, ok := custom[profile]
+		if !ok {
+			list, ok = quirkProfiles[profile]
+		}
+		if !ok {
+			return q, fmt.Errorf("unknown sip.provider_profile %q", profile)
+		}
+		names = append(names, list...)
+	}
+	names = append(names, extra...)
+	for _, entry := range names {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(entry), "=")
+		switch name {
+		case QuirkUserPhone:
+			q.UserPhone = true
+		case QuirkNoRport:
+			q.NoRport = true
+		case QuirkG711AfterReInvites:
+			q.G711AfterReInvites = 2
+			if hasValue {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return q, fmt.Errorf("invalid quirk %q (a number of re-INVITEs)", entry)
+				}
+				q.G711AfterReInvites = n
+			}
+		case QuirkOptionsKeepAlive:
+			q.OptionsKeepAlive = 30 * time.Second
+			if hasValue {
+				d, err := time.ParseDuration(value)
+				if err != nil || d < 5*time.Second {
+					return q, fmt.Errorf("invalid quirk %q (interval of at least 5s)", entry)
+				}
+				q.OptionsKeepAlive = d
+			}
+		case QuirkMaxRing:
+			q.MaxRing = 60 * time.Second
+			if hasValue {
+				d, err := time.ParseDuration(value)
+				if err != nil || d < 5*time.Second {
+					return q, fmt.Errorf("invalid quirk %q (ring time of at least 5s)", entry)
+				}
+				q.MaxRing = d
+			}
+		case QuirkEarlyMediaTimeout:
+			q.EarlyMediaTimeout = 30 * time.Second
+			if hasValue {
+				d, err := time.ParseDuration(value)
+				if err != nil || d < time.Second {
+					return q, fmt.Errorf("invalid quirk %q (timeout of at least 1s)", entry)
+				}
+				q.EarlyMediaTimeout = d
+			}
+		case QuirkHairpinCheck:
+			q.HairpinCheck = true
+		default:
+			return q, fmt.Errorf("unknown quirk %q", entry)
+		}
+	}
+	return q, nil
+}
+
+// ownNumber reports whether number reaches the bridge itself: the SIP
+// account, a caller ID it presents, or a DID it answers as a voice chat,
+// follow-me chain or info line.
+func (s *Service) ownNumber(number string) bool {
+	own := map[string]bool{s.cfg.SIPAuthUser: true}
+	for _, n := range s.cfg.SIPCallerIDs {
+		own[n] = true
+	}
+	for n := range s.cfg.VoiceChats {
+		own[n] = true
+	}
+	for n := range s.cfg.FollowMe {
+		own[n] = true
+	}
+	for n := range s.cfg.InfoLines {
+		own[n] = true
+	}
+	return lookupPhone(own, number)
+}
+
+// ringBudget bounds ctx by the max_ring quirk.
+func (s *Service) ringBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := s.cfg.SIPQuirks.MaxRing; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// earlyMediaBudget bounds ctx by the early_media_timeout quirk.
+func (s *Service) earlyMediaBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := s.cfg.SIPQuirks.EarlyMediaTimeout; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// reInviteCodecs is the codec limit of re-INVITEs for the
+// g711_after_reinvites quirk; nil without it.
+func (s *Service) reInviteCodecs() diago.ReInviteCodecsFunc {
+	after := s.cfg.SIPQuirks.G711AfterReInvites
+	if after <= 0 {
+		return nil
+	}
+	return func(n int, codecs []media.Codec) []media.Codec {
+		if n <= after {
+			return codecs
+		}
+		return slices.DeleteFunc(slices.Clone(codecs), func(c media.Codec) bool {
+			return !slices.Contains([]string{"pcmu", "pcma", "telephone-event"}, strings.ToLower(c.Name))
+		})
+	}
+}
+
+// dialogOptions is a SIP dialog that can send in-dialog OPTIONS.
+type dialogOptions interface {
+	Options(ctx context.Context) (*sip.Response, error)
+}
+
+// keepDialogAlive sends in-dialog OPTIONS on the SIP leg of call for the
+// options_keepalive quirk until the call ends. The call is ended when the
+// provider no longer knows the dialog (481) or stops answering.
+func (s *Service) keepDialogAlive(call *Call, logger *slog.Logger) {
+	interval := s.cfg.SIPQuirks.OptionsKeepAlive
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-call.Done():
+			return
+		case <-ticker.C:
+		}
+		dialog, ok := call.sipDialog().(dialogOptions)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(call.ctx, interval/2)
+		res, err := dialog.Options(ctx)
+		cancel()
+		if call.ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == nil && res.StatusCode == sip.StatusCallTransactionDoesNotExists:
+			failures = optionsKeepAliveFailures
+		case err != nil || res.StatusCode == sip.StatusRequestTimeout:
+			failures++
+			logger.Warn("sip keep-alive unanswered", "error", err, "failures", failures)
+		default:
+			// Any other answer, even 405 or 501, means the dialog is alive.
+			failures = 0
+		}
+		if failures >= optionsKeepAliveFailures {
+			logger.Warn("sip dialog lost, ending call")
+			call.setCause(cdr.CauseSIPFailure)
+			call.Hangup()
+			return
+		}
+	}
+}
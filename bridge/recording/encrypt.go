@@ -0,0 +1,164 @@
+package recording
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted recordings start with encMagic, a version byte and a random
+// nonce prefix, followed by AES-256-GCM sealed segments of up to
+// encSegmentSize plaintext bytes. Each segment's nonce is the prefix, a
+// big-endian segment counter and a final-segment flag, so truncated or
+// reordered files fail to decrypt.
+const (
+	encMagic       = "SREC"
+	encVersion     = 1
+	encPrefixSize  = 7
+	encHeaderSize  = len(encMagic) + 1 + encPrefixSize
+	encSegmentSize = 64 << 10
+)
+
+// EncryptedExt is appended to the names of encrypted recordings.
+const EncryptedExt = ".enc"
+
+// ErrBadKey is returned for keys that are not 32 bytes long.
+var ErrBadKey = errors.New("recording key must be 32 bytes (AES-256)")
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrBadKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter seals everything written to it into w.
+type encryptWriter struct {
+	w       io.WriteCloser
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func newEncryptWriter(w io.WriteCloser, key []byte) (*encryptWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	header[len(encMagic)] = encVersion
+	prefix := header[len(encMagic)+1:]
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encSegmentSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == encSegmentSize {
+			if err := e.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := copy(e.buf[len(e.buf):encSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("recording too large to encrypt")
+	}
+	out := e.aead.Seal(nil, segmentNonce(e.prefix, e.counter, final), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// Close seals the final segment and closes the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return errors.Join(e.seal(true), e.w.Close())
+}
+
+// Decrypt writes the plaintext of an encrypted recording read from src to
+// dst. It fails if the file was tampered with or truncated.
+func Decrypt(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(header[:len(encMagic)]) != encMagic {
+		return errors.New("not an encrypted recording")
+	}
+	if v := header[len(encMagic)]; v != encVersion {
+		return fmt.Errorf("unsupported encrypted recording version %d", v)
+	}
+	prefix := header[len(encMagic)+1:]
+
+	// A segment is final exactly when nothing follows it, so keep one
+	// segment of lookahead.
+	sealedSize := encSegmentSize + aead.Overhead()
+	cur := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+	n, err := io.ReadFull(src, cur)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	cur = cur[:n]
+	var plain []byte
+	for counter := uint32(0); ; counter++ {
+		m, err := io.ReadFull(src, next[:sealedSize])
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read segment: %w", err)
+		}
+		final := m == 0
+		plain, err = aead.Open(plain[:0], segmentNonce(prefix, counter, final), cur, nil)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", counter, err)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		cur, next = next[:m], cur[:cap(cur)]
+	}
+}
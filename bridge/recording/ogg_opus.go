@@ -0,0 +1,87 @@
+//go:build (opus || with_opus_c) && cgo
+
+package recording
+
+import (
+	"io"
+
+	msdk "github.com/livekit/media-sdk"
+	msdkopus "github.com/livekit/media-sdk/opus"
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+
+	"gotgcalls/bridge/pcm"
+)
+
+// oggOpusWriter encodes PCM frames with Opus into an Ogg container. Each
+// frame becomes one Opus packet, so frames must be a valid Opus duration
+// (10ms or 20ms at 48kHz).
+type oggOpusWriter struct {
+	enc    msdk.PCM16Writer
+	sink   *oggPacketSink
+	sample msdk.PCM16Sample
+}
+
+func newOggOpusWriter(path string, key []byte, sampleRate int) (trackWriter, error) {
+	var (
+		ogg *oggwriter.OggWriter
+		err error
+	)
+	if key == nil {
+		ogg, err = oggwriter.New(path, uint32(sampleRate), 1)
+	} else {
+		// Encrypted output is a stream; the writer closes it on Close.
+		var out io.WriteCloser
+		if out, err = createTrackFile(path, key); err == nil {
+			if ogg, err = oggwriter.NewWith(out, uint32(sampleRate), 1); err != nil {
+				_ = out.Close()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sink := &oggPacketSink{ogg: ogg, sampleRate: sampleRate}
+	enc, err := msdkopus.Encode(sink, 1, logger.GetLogger())
+	if err != nil {
+		_ = ogg.Close()
+		return nil, err
+	}
+	return &oggOpusWriter{enc: enc, sink: sink}, nil
+}
+
+func (w *oggOpusWriter) Write(frame []byte) error {
+	w.sample = pcm.PCM16BytesToSample(w.sample, frame)
+	w.sink.frameSamples = uint32(len(w.sample))
+	return w.enc.WriteSample(w.sample)
+}
+
+func (w *oggOpusWriter) Close() error {
+	return w.enc.Close()
+}
+
+// oggPacketSink wraps encoded Opus packets in synthetic RTP headers, which is
+// what pion's Ogg writer expects.
+type oggPacketSink struct {
+	ogg        *oggwriter.OggWriter
+	sampleRate int
+	seq        uint16
+	ts         uint32
+	// frameSamples is the duration of the packet being written.
+	frameSamples uint32
+}
+
+func (s *oggPacketSink) String() string  { return "ogg" }
+func (s *oggPacketSink) SampleRate() int { return s.sampleRate }
+func (s *oggPacketSink) Close() error    { return s.ogg.Close() }
+
+func (s *oggPacketSink) WriteSample(pkt msdkopus.Sample) error {
+	s.seq++
+	err := s.ogg.WriteRTP(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: s.seq, Timestamp: s.ts},
+		Payload: pkt,
+	})
+	s.ts += s.frameSamples
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !((opus || with_opus_c) && cgo)
+
+package recording
+
+import "errors"
+
+func newOggOpusWriter(string, []byte, int) (trackWriter, error) {
+	return nil, errors.New("ogg recording requires building with -tags opus")
+}
@@ -0,0 +1,278 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge/pcm"
+)
+
+const (
+	// silencePad is kept around the audible part when trimming.
+	silencePad = 250 * time.Millisecond
+	// maxNormalizeGain bounds the boost for very quiet tracks, so near-silent
+	// ones do not turn into amplified noise.
+	maxNormalizeGain = 20.0 // dB
+	// peakCeiling is the sample peak normalized tracks may reach.
+	peakCeiling = -1.0 // dBFS
+	// opusFrame is the duration of the frames fed to the Opus encoder.
+	opusFrame = 20 * time.Millisecond
+)
+
+// ErrNotWAV is returned by Process for tracks that are not PCM WAV files.
+var ErrNotWAV = errors.New("only wav recordings can be post-processed")
+
+// PostOptions configures Process.
+type PostOptions struct {
+	// TrimSilence cuts leading and trailing audio quieter than
+	// SilenceThreshold (dBFS).
+	TrimSilence      bool
+	SilenceThreshold float64
+	// TargetLUFS normalizes each track's integrated loudness; 0 disables.
+	TargetLUFS float64
+	// Opus re-encodes the tracks as Ogg/Opus and removes the WAV files.
+	Opus bool
+	// Key decrypts the input and encrypts the output, as in Options.
+	Key []byte
+}
+
+// Process post-processes the tracks of one recording (as returned by
+// Session.Files) in place and returns the resulting paths. The tracks are
+// trimmed by the same amount so they stay aligned. On error the original
+// files are left untouched.
+func Process(files []string, opts PostOptions) ([]string, error) {
+	tracks := make([][]int16, len(files))
+	rate := 0
+	for i, path := range files {
+		samples, r, err := readWAVFile(path, opts.Key)
+		if err != nil {
+			return files, fmt.Errorf("%s: %w", path, err)
+		}
+		if rate != 0 && r != rate {
+			return files, fmt.Errorf("%s: sample rate %d differs from %d", path, r, rate)
+		}
+		tracks[i], rate = samples, r
+	}
+
+	if opts.TrimSilence {
+		start, end := audibleRange(tracks, opts.SilenceThreshold, int(silencePad.Seconds()*float64(rate)))
+		for i := range tracks {
+			tracks[i] = tracks[i][min(start, len(tracks[i])):min(end, len(tracks[i]))]
+		}
+	}
+	if opts.TargetLUFS != 0 {
+		for _, t := range tracks {
+			normalize(t, rate, opts.TargetLUFS)
+		}
+	}
+
+	// Write every track before replacing any, so a failure leaves the
+	// recording as it was.
+	out := make([]string, len(files))
+	for i, path := range files {
+		out[i] = path
+		if opts.Opus {
+			out[i] = opusPath(path)
+		}
+		if err := writeTrack(out[i]+".tmp", tracks[i], rate, opts); err != nil {
+			for _, done := range out[:i] {
+				_ = os.Remove(done + ".tmp")
+			}
+			return files, fmt.Errorf("%s: %w", out[i], err)
+		}
+	}
+	for i, path := range files {
+		if err := os.Rename(out[i]+".tmp", out[i]); err != nil {
+			return files, err
+		}
+		if out[i] != path {
+			_ = os.Remove(path)
+		}
+	}
+	return out, nil
+}
+
+// opusPath swaps the .wav extension of path (keeping EncryptedExt) for .ogg.
+func opusPath(path string) string {
+	base, encrypted := strings.CutSuffix(path, EncryptedExt)
+	base = strings.TrimSuffix(base, "."+FormatWAV) + "." + FormatOGG
+	if encrypted {
+		base += EncryptedExt
+	}
+	return base
+}
+
+// audibleRange returns the sample range, widened by pad, in which any track
+// is louder than thresholdDB. Entirely silent recordings are kept whole.
+func audibleRange(tracks [][]int16, thresholdDB float64, pad int) (start, end int) {
+	limit := int32(math.Pow(10, thresholdDB/20) * math.MaxInt16)
+	start, end = math.MaxInt, 0
+	longest := 0
+	for _, t := range tracks {
+		longest = max(longest, len(t))
+		for i, v := range t {
+			if abs32(int32(v)) > limit {
+				start = min(start, i)
+				break
+			}
+		}
+		for i := len(t) - 1; i >= 0; i-- {
+			if abs32(int32(t[i])) > limit {
+				end = max(end, i+1)
+				break
+			}
+		}
+	}
+	if start >= end {
+		return 0, longest
+	}
+	return max(start-pad, 0), min(end+pad, longest)
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// normalize applies the gain that brings samples to targetLUFS, limited so
+// the peak stays below peakCeiling.
+func normalize(samples []int16, rate int, targetLUFS float64) {
+	loudness := pcm.IntegratedLoudness(samples, rate)
+	if math.IsInf(loudness, -1) {
+		return
+	}
+	gainDB := min(targetLUFS-loudness, maxNormalizeGain)
+	var peak int32
+	for _, v := range samples {
+		peak = max(peak, abs32(int32(v)))
+	}
+	if peak > 0 {
+		peakDB := 20 * math.Log10(float64(peak)/math.MaxInt16)
+		gainDB = min(gainDB, peakCeiling-peakDB)
+	}
+	gain := math.Pow(10, gainDB/20)
+	for i, v := range samples {
+		samples[i] = int16(max(math.MinInt16, min(math.MaxInt16, math.Round(float64(v)*gain))))
+	}
+}
+
+// writeTrack writes samples to path, removing it again on failure.
+func writeTrack(path string, samples []int16, rate int, opts PostOptions) error {
+	_ = os.Remove(path)
+	var (
+		w     trackWriter
+		err   error
+		frame = int(opusFrame.Seconds() * float64(rate))
+	)
+	if opts.Opus {
+		w, err = newOggOpusWriter(path, opts.Key, rate)
+	} else {
+		w, err = newWAVWriter(path, opts.Key, rate)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	buf := make([]byte, frame*2)
+	for off := 0; off < len(samples); off += frame {
+		chunk := samples[off:min(off+frame, len(samples))]
+		clear(buf)
+		for i, v := range chunk {
+			binary.LittleEndian.PutUint16(buf[2*i:], uint16(v))
+		}
+		// Opus needs whole frames; WAV keeps the exact length.
+		n := len(buf)
+		if !opts.Opus {
+			n = 2 * len(chunk)
+		}
+		if err = w.Write(buf[:n]); err != nil {
+			break
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// readWAVFile reads a 16-bit mono PCM WAV file, decrypting it with key when
+// it is encrypted.
+func readWAVFile(path string, key []byte) ([]int16, int, error) {
+	if !strings.HasSuffix(strings.TrimSuffix(path, EncryptedExt), "."+FormatWAV) {
+		return nil, 0, ErrNotWAV
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if strings.HasSuffix(path, EncryptedExt) {
+		if key == nil {
+			return nil, 0, errors.New("encrypted recording but no key configured")
+		}
+		var plain bytes.Buffer
+		if err := Decrypt(&plain, f, key); err != nil {
+			return nil, 0, err
+		}
+		src = &plain
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseWAV(data)
+}
+
+// parseWAV decodes 16-bit mono PCM. A data chunk with a streaming size runs
+// to the end of the file.
+func parseWAV(data []byte) ([]int16, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, ErrNotWAV
+	}
+	rate := 0
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int64(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, ErrNotWAV
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels := binary.LittleEndian.Uint16(body[2:4])
+			bits := binary.LittleEndian.Uint16(body[14:16])
+			if format != 1 || channels != 1 || bits != 16 {
+				return nil, 0, fmt.Errorf("unsupported wav format (format %d, %d channels, %d bits)", format, channels, bits)
+			}
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+		case "data":
+			if rate == 0 {
+				return nil, 0, ErrNotWAV
+			}
+			if size < int64(len(body)) {
+				body = body[:size]
+			}
+			samples := make([]int16, len(body)/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(body[2*i:]))
+			}
+			return samples, rate, nil
+		}
+		off += 8 + int(size+size&1)
+	}
+	return nil, 0, ErrNotWAV
+}
@@ -0,0 +1,112 @@
+package recording
+
+import "sync"
+
+// PreRoll keeps the last frames of both directions while nothing is being
+// recorded, so a recording started mid-call can begin a few seconds in the
+// past. Add methods may be called from different goroutines.
+type PreRoll struct {
+	mu     sync.Mutex
+	sip    frameRing
+	tg     frameRing
+	paused bool
+}
+
+// NewPreRoll buffers up to frames frames of frameBytes per direction.
+func NewPreRoll(frames, frameBytes int) *PreRoll {
+	return &PreRoll{sip: newFrameRing(frames, frameBytes), tg: newFrameRing(frames, frameBytes)}
+}
+
+// AddSIP buffers a frame heard from the SIP party.
+func (p *PreRoll) AddSIP(frame []byte) {
+	p.mu.Lock()
+	if !p.paused {
+		p.sip.push(frame)
+	}
+	p.mu.Unlock()
+}
+
+// AddTG buffers a frame heard from the Telegram party.
+func (p *PreRoll) AddTG(frame []byte) {
+	p.mu.Lock()
+	if !p.paused {
+		p.tg.push(frame)
+	}
+	p.mu.Unlock()
+}
+
+// Flush writes the buffered audio to s, oldest first, and stops buffering
+// until Resume.
+func (p *PreRoll) Flush(s *Session) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	sip, tg := p.sip.frames(), p.tg.frames()
+	p.sip.reset()
+	p.tg.reset()
+	// Interleave the directions so the mixed track stays aligned.
+	for i := range max(len(sip), len(tg)) {
+		// The pre-roll may be longer than the write queue; wait for room
+		// rather than drop it.
+		if i < len(sip) {
+			if err := s.queue.push(trackSIP, sip[i], true); err != nil {
+				return err
+			}
+		}
+		if i < len(tg) {
+			if err := s.queue.push(trackTG, tg[i], true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resume starts buffering again after a recording ended.
+func (p *PreRoll) Resume() {
+	p.mu.Lock()
+	p.paused = false
+	p.mu.Unlock()
+}
+
+// frameRing is a fixed-size ring of equally sized frames.
+type frameRing struct {
+	slots [][]byte
+	start int
+	n     int
+}
+
+func newFrameRing(frames, frameBytes int) frameRing {
+	slots := make([][]byte, frames)
+	for i := range slots {
+		slots[i] = make([]byte, 0, frameBytes)
+	}
+	return frameRing{slots: slots}
+}
+
+func (r *frameRing) push(frame []byte) {
+	if len(r.slots) == 0 {
+		return
+	}
+	i := (r.start + r.n) % len(r.slots)
+	if r.n == len(r.slots) {
+		r.start = (r.start + 1) % len(r.slots)
+	} else {
+		r.n++
+	}
+	r.slots[i] = append(r.slots[i][:0], frame...)
+}
+
+// frames returns the buffered frames oldest first. They alias the ring and
+// are only valid until the next push.
+func (r *frameRing) frames() [][]byte {
+	out := make([][]byte, r.n)
+	for i := range out {
+		out[i] = r.slots[(r.start+i)%len(r.slots)]
+	}
+	return out
+}
+
+func (r *frameRing) reset() {
+	r.start, r.n = 0, 0
+}
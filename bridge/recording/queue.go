@@ -0,0 +1,96 @@
+package recording
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// queueFrames is how many frames may wait for the disk: two seconds of
+// 20 ms frames.
+const queueFrames = 100
+
+// queuedFrame is a frame for one of the tracks of a writeQueue.
+type queuedFrame struct {
+	track int
+	data  []byte
+}
+
+// writeQueue hands frames to a goroutine that writes them, so a slow disk
+// doesn't stall the media loops calling Write. Frames are dropped, and
+// counted, while the writer is behind.
+type writeQueue struct {
+	mu     sync.Mutex
+	frames chan queuedFrame
+	done   chan struct{}
+	closed bool
+
+	errMu   sync.Mutex
+	err     error
+	dropped atomic.Int64
+}
+
+// newWriteQueue starts the writer, which passes frames to write until the
+// first error.
+func newWriteQueue(write func(track int, frame []byte) error) *writeQueue {
+	q := &writeQueue{frames: make(chan queuedFrame, queueFrames), done: make(chan struct{})}
+	go func() {
+		defer close(q.done)
+		for f := range q.frames {
+			if q.writeErr() != nil {
+				continue
+			}
+			if err := write(f.track, f.data); err != nil {
+				q.errMu.Lock()
+				q.err = err
+				q.errMu.Unlock()
+			}
+		}
+	}()
+	return q
+}
+
+// push queues a copy of frame for track and returns the error a previous
+// frame failed with. wait blocks for room instead of dropping the frame.
+func (q *writeQueue) push(track int, frame []byte, wait bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	if err := q.writeErr(); err != nil {
+		return err
+	}
+	f := queuedFrame{track: track, data: bytes.Clone(frame)}
+	if wait {
+		q.frames <- f
+		return nil
+	}
+	select {
+	case q.frames <- f:
+	default:
+		q.dropped.Add(1)
+	}
+	return nil
+}
+
+func (q *writeQueue) writeErr() error {
+	q.errMu.Lock()
+	defer q.errMu.Unlock()
+	return q.err
+}
+
+// close waits for the queued frames to be written and returns the first
+// write error; ok is false if the queue was already closed.
+func (q *writeQueue) close() (ok bool, err error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false, nil
+	}
+	q.closed = true
+	close(q.frames)
+	q.mu.Unlock()
+	<-q.done
+	return true, q.writeErr()
+}
@@ -0,0 +1,51 @@
+package recording
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteQueue(t *testing.T) {
+	release := make(chan struct{})
+	var written []int
+	q := newWriteQueue(func(track int, frame []byte) error {
+		<-release
+		written = append(written, int(frame[0]))
+		if frame[0] == 255 {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+
+	// The writer holds the first frame; the queue takes queueFrames more
+	// and drops the rest.
+	const extra = 5
+	for i := range 1 + queueFrames + extra {
+		if err := q.push(0, []byte{byte(i)}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := q.dropped.Load(); got < extra || got > extra+1 {
+		t.Errorf("dropped %d frames, want about %d", got, extra)
+	}
+	close(release)
+	// A failed write is reported by close.
+	if err := q.push(0, []byte{255}, true); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := q.close()
+	if !ok || err == nil {
+		t.Errorf("close = %v, %v, want the write error", ok, err)
+	}
+	if err := q.push(0, []byte{1}, false); err != nil {
+		t.Errorf("push after close = %v", err)
+	}
+	if ok, _ := q.close(); ok {
+		t.Error("second close reported ok")
+	}
+	for i, v := range written[:len(written)-1] {
+		if v != i {
+			t.Fatalf("frame %d written as %d, frames out of order", i, v)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package recording writes the two directions of a bridged call (and
+// optionally a mixed track) to audio files.
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FormatWAV = "wav"
+	FormatOGG = "ogg"
+)
+
+// DefaultTemplate names files like "20260102_150405_inbound_79991234567_ab12cd".
+const DefaultTemplate = "{date}_{time}_{direction}_{number}_{id}"
+
+// Options configures a recording session.
+type Options struct {
+	Dir      string
+	Template string
+	Format   string
+	Mixed    bool
+	// Key, when set, encrypts the files (AES-256-GCM, see Decrypt); their
+	// names get EncryptedExt appended.
+	Key []byte
+
+	SampleRate int
+	// FrameBytes is the size of the PCM16 mono frames passed to Write*.
+	FrameBytes int
+
+	// OnClose, when set, is called with the files once they are complete.
+	OnClose func(files []string)
+}
+
+// Vars are substituted into Options.Template.
+type Vars struct {
+	ID        string
+	Direction string
+	Number    string
+	ChatID    int64
+	StartedAt time.Time
+}
+
+// trackWriter encodes PCM16LE mono frames into a file.
+type trackWriter interface {
+	Write(frame []byte) error
+	Close() error
+}
+
+// maxMixLag bounds how many frames one direction may run ahead of the other
+// before the mix is padded with silence.
+const maxMixLag = 20
+
+// The tracks of a Session's writeQueue.
+const (
+	trackSIP = iota
+	trackTG
+)
+
+// Session records one call. Write methods may be called from different
+// goroutines; they queue the frames for a writer goroutine.
+type Session struct {
+	queue *writeQueue
+	sip   trackWriter
+	tg    trackWriter
+	mix   trackWriter
+	files []string
+
+	onClose func(files []string)
+
+	// Only used by the writer goroutine, then by Close.
+	pendingSIP [][]byte
+	pendingTG  [][]byte
+	mixBuf     []byte
+}
+
+// Start creates the output files and returns a session ready for frames.
+func Start(opts Options, vars Vars) (*Session, error) {
+	opts, base, err := prepare(opts, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{onClose: opts.OnClose}
+	if s.sip, err = s.open(opts, base+"_sip"); err != nil {
+		return nil, err
+	}
+	if s.tg, err = s.open(opts, base+"_tg"); err != nil {
+		_ = s.sip.Close()
+		return nil, err
+	}
+	if opts.Mixed {
+		if s.mix, err = s.open(opts, base+"_mix"); err != nil {
+			_ = s.sip.Close()
+			_ = s.tg.Close()
+			return nil, err
+		}
+		s.mixBuf = make([]byte, opts.FrameBytes)
+	}
+	s.queue = newWriteQueue(s.write)
+	return s, nil
+}
+
+// prepare fills in defaults, creates the directory and returns the file
+// name base (without track suffix and extension) for vars.
+func prepare(opts Options, vars Vars) (Options, string, error) {
+	if opts.Format == "" {
+		opts.Format = FormatWAV
+	}
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	if err := os.MkdirAll(opts.Dir, 0o750); err != nil {
+		return opts, "", err
+	}
+	return opts, filepath.Join(opts.Dir, ExpandTemplate(opts.Template, vars)), nil
+}
+
+func (s *Session) open(opts Options, base string) (trackWriter, error) {
+	w, path, err := openTrack(opts, base)
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, path)
+	return w, nil
+}
+
+// openTrack creates the file for base in opts.Format and returns its path.
+func openTrack(opts Options, base string) (trackWriter, string, error) {
+	path := base + "." + opts.Format
+	if opts.Key != nil {
+		path += EncryptedExt
+	}
+	var (
+		w   trackWriter
+		err error
+	)
+	switch opts.Format {
+	case FormatWAV:
+		w, err = newWAVWriter(path, opts.Key, opts.SampleRate)
+	case FormatOGG:
+		w, err = newOggOpusWriter(path, opts.Key, opts.SampleRate)
+	default:
+		err = fmt.Errorf("unsupported recording format %q", opts.Format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return w, path, nil
+}
+
+// createTrackFile creates path, wrapping it in an encryptWriter when key is
+// set.
+func createTrackFile(path string, key []byte) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return f, nil
+	}
+	w, err := newEncryptWriter(f, key)
+	if err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return w, nil
+}
+
+// Files returns the paths written by this session.
+func (s *Session) Files() []string {
+	return append([]string(nil), s.files...)
+}
+
+// WriteSIP records a frame heard from the SIP party. It doesn't wait for
+// the disk; the error is that of an earlier frame.
+func (s *Session) WriteSIP(frame []byte) error {
+	return s.queue.push(trackSIP, frame, false)
+}
+
+// WriteTG records a frame heard from the Telegram party, like WriteSIP.
+func (s *Session) WriteTG(frame []byte) error {
+	return s.queue.push(trackTG, frame, false)
+}
+
+// Dropped returns how many frames were dropped because the disk fell
+// behind.
+func (s *Session) Dropped() int64 {
+	return s.queue.dropped.Load()
+}
+
+// write writes a queued frame; it runs on the writer goroutine.
+func (s *Session) write(track int, frame []byte) error {
+	w, pending := s.sip, &s.pendingSIP
+	if track == trackTG {
+		w, pending = s.tg, &s.pendingTG
+	}
+	if err := w.Write(frame); err != nil {
+		return err
+	}
+	if s.mix != nil {
+		*pending = append(*pending, frame)
+		return s.flushMix()
+	}
+	return nil
+}
+
+func (s *Session) flushMix() error {
+	for len(s.pendingSIP) > 0 && len(s.pendingTG) > 0 {
+		if err := s.writeMix(s.pendingSIP[0], s.pendingTG[0]); err != nil {
+			return err
+		}
+		s.pendingSIP, s.pendingTG = s.pendingSIP[1:], s.pendingTG[1:]
+	}
+	// One side stalled; mix the other with silence so the track keeps time.
+	for len(s.pendingSIP) > maxMixLag {
+		if err := s.writeMix(s.pendingSIP[0], nil); err != nil {
+			return err
+		}
+		s.pendingSIP = s.pendingSIP[1:]
+	}
+	for len(s.pendingTG) > maxMixLag {
+		if err := s.writeMix(nil, s.pendingTG[0]); err != nil {
+			return err
+		}
+		s.pendingTG = s.pendingTG[1:]
+	}
+	return nil
+}
+
+func (s *Session) writeMix(a, b []byte) error {
+	out := s.mixBuf[:0]
+	n := max(len(a), len(b))
+	for i := 0; i+1 < n; i += 2 {
+		var sum int32
+		if i+1 < len(a) {
+			sum += int32(int16(uint16(a[i]) | uint16(a[i+1])<<8))
+		}
+		if i+1 < len(b) {
+			sum += int32(int16(uint16(b[i]) | uint16(b[i+1])<<8))
+		}
+		sum = min(max(sum, -32768), 32767)
+		out = append(out, byte(sum), byte(sum>>8))
+	}
+	s.mixBuf = out
+	return s.mix.Write(out)
+}
+
+// Close writes the queued frames and finalizes all files. It is safe to
+// call more than once.
+func (s *Session) Close() error {
+	ok, err := s.queue.close()
+	if !ok {
+		return nil
+	}
+	err = errors.Join(err, s.closeTracks())
+	if s.onClose != nil && err == nil {
+		s.onClose(s.Files())
+	}
+	return err
+}
+
+func (s *Session) closeTracks() error {
+	var err error
+	if s.mix != nil {
+		for len(s.pendingSIP) > 0 || len(s.pendingTG) > 0 {
+			var a, b []byte
+			if len(s.pendingSIP) > 0 {
+				a, s.pendingSIP = s.pendingSIP[0], s.pendingSIP[1:]
+			}
+			if len(s.pendingTG) > 0 {
+				b, s.pendingTG = s.pendingTG[0], s.pendingTG[1:]
+			}
+			err = errors.Join(err, s.writeMix(a, b))
+		}
+		err = errors.Join(err, s.mix.Close())
+	}
+	return errors.Join(err, s.sip.Close(), s.tg.Close())
+}
+
+// ExpandTemplate returns the file name (without directory and extension)
+// tmpl gives for v.
+func ExpandTemplate(tmpl string, v Vars) string {
+	number := strings.TrimPrefix(v.Number, "+")
+	name := strings.NewReplacer(
+		"{id}", v.ID,
+		"{direction}", v.Direction,
+		"{number}", number,
+		"{chat_id}", fmt.Sprint(v.ChatID),
+		"{date}", v.StartedAt.Format("20060102"),
+		"{time}", v.StartedAt.Format("150405"),
+	).Replace(tmpl)
+	// Templates must not escape the recording directory.
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(name)
+}
@@ -0,0 +1,80 @@
+package recording
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Track records a single stream, such as a voicemail message, to one file
+// named, encoded and encrypted like a Session's tracks (Options.Mixed is
+// ignored).
+type Track struct {
+	queue      *writeQueue
+	w          trackWriter
+	path       string
+	sampleRate int
+	samples    atomic.Int64
+
+	onClose func(files []string)
+}
+
+// StartTrack creates the output file and returns a track ready for frames.
+func StartTrack(opts Options, vars Vars) (*Track, error) {
+	opts, base, err := prepare(opts, vars)
+	if err != nil {
+		return nil, err
+	}
+	w, path, err := openTrack(opts, base)
+	if err != nil {
+		return nil, err
+	}
+	t := &Track{w: w, path: path, sampleRate: opts.SampleRate, onClose: opts.OnClose}
+	t.queue = newWriteQueue(func(_ int, frame []byte) error {
+		if err := t.w.Write(frame); err != nil {
+			return err
+		}
+		t.samples.Add(int64(len(frame) / 2))
+		return nil
+	})
+	return t, nil
+}
+
+// Path returns the file being written.
+func (t *Track) Path() string {
+	return t.path
+}
+
+// Write appends a PCM16LE mono frame. Like Session.WriteSIP it doesn't
+// wait for the disk.
+func (t *Track) Write(frame []byte) error {
+	return t.queue.push(0, frame, false)
+}
+
+// Dropped returns how many frames were dropped because the disk fell
+// behind.
+func (t *Track) Dropped() int64 {
+	return t.queue.dropped.Load()
+}
+
+// Duration returns how much audio has been written.
+func (t *Track) Duration() time.Duration {
+	if t.sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(t.samples.Load()) * time.Second / time.Duration(t.sampleRate)
+}
+
+// Close writes the queued frames and finalizes the file. It is safe to
+// call more than once.
+func (t *Track) Close() error {
+	ok, err := t.queue.close()
+	if !ok {
+		return nil
+	}
+	err = errors.Join(err, t.w.Close())
+	if t.onClose != nil && err == nil {
+		t.onClose([]string{t.path})
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const wavHeaderSize = 44
+
+// wavStreamingSize marks header sizes that could not be patched because the
+// file was written as a stream (encrypted recordings).
+const wavStreamingSize = 0xFFFFFFFF
+
+// wavWriter writes 16-bit mono PCM. Sizes in the header are patched on Close
+// when the file is seekable.
+type wavWriter struct {
+	f          io.WriteCloser
+	w          *bufio.Writer
+	sampleRate int
+	dataBytes  uint32
+	streaming  bool
+}
+
+func newWAVWriter(path string, key []byte, sampleRate int) (*wavWriter, error) {
+	f, err := createTrackFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	_, seekable := f.(io.WriterAt)
+	w := &wavWriter{f: f, w: bufio.NewWriter(f), sampleRate: sampleRate, streaming: !seekable}
+	if _, err := w.w.Write(w.header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavWriter) header() []byte {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	riffSize, dataSize := 36+w.dataBytes, w.dataBytes
+	if w.streaming {
+		riffSize, dataSize = wavStreamingSize, wavStreamingSize
+	}
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], riffSize)
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:24], channels)
+	binary.LittleEndian.PutUint32(h[24:28], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(w.sampleRate*channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(h[32:34], channels*bitsPerSample/8)
+	binary.LittleEndian.PutUint16(h[34:36], bitsPerSample)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], dataSize)
+	return h
+}
+
+func (w *wavWriter) Write(frame []byte) error {
+	n, err := w.w.Write(frame)
+	w.dataBytes += uint32(n)
+	return err
+}
+
+func (w *wavWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if wa, ok := w.f.(io.WriterAt); ok {
+		if _, err := wa.WriteAt(w.header(), 0); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
+
+// FixWAVSizes patches the header of a WAV file written as a stream (as
+// decrypted recordings are) with its real sizes. Other files are left alone.
+func FixWAVSizes(f *os.File) error {
+	h := make([]byte, wavHeaderSize)
+	if _, err := f.ReadAt(h, 0); err != nil {
+		return nil
+	}
+	if !bytes.Equal(h[0:4], []byte("RIFF")) || !bytes.Equal(h[8:12], []byte("WAVE")) ||
+		binary.LittleEndian.Uint32(h[40:44]) != wavStreamingSize {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data := uint32(min(info.Size()-wavHeaderSize, wavStreamingSize-36))
+	binary.LittleEndian.PutUint32(h[4:8], 36+data)
+	binary.LittleEndian.PutUint32(h[40:44], data)
+	_, err = f.WriteAt(h, 0)
+	return err
+}
@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// inviteFollowingRedirects sends the INVITE for the SIP leg of call like
+// inviteWithEarlyMedia, and when it is redirected (300-302) tries the
+// Contact targets of the response instead, up to sip.max_redirects hops.
+// Targets already tried are skipped, so redirect loops end.
+func (s *Service) inviteFollowingRedirects(ctx context.Context, recipient sip.Uri, logger *slog.Logger, call *Call) (*diago.DialogClientSession, bool, error) {
+	tried := []string{redirectKey(recipient)}
+	for hop := 0; ; hop++ {
+		dialog, earlyMedia, err := s.inviteWithEarlyMedia(ctx, recipient, logger, call)
+		res := redirectResponse(err)
+		if res == nil {
+			return dialog, earlyMedia, err
+		}
+		if hop >= s.cfg.SIPMaxRedirects {
+			logger.Warn("sip redirect not followed", "status", res.StatusCode, "max_redirects", s.cfg.SIPMaxRedirects)
+			return nil, false, err
+		}
+		target, ok := redirectTarget(res, tried)
+		if !ok {
+			logger.Warn("sip redirect has no new target", "status", res.StatusCode, "tried", tried)
+			return nil, false, err
+		}
+		logger.Info("sip redirected", "status", res.StatusCode, "from", recipient.String(), "to", target.String())
+		call.addRedirect(target.String())
+		tried = append(tried, redirectKey(target))
+		recipient = target
+	}
+}
+
+// redirectResponse returns the response of a failed INVITE when it is a
+// redirect. 305 Use Proxy and 380 Alternative Service ask for something
+// else than another target and are not followed.
+func redirectResponse(err error) *sip.Response {
+	var res *sip.Response
+	var resVal sipgo.ErrDialogResponse
+	var resPtr *sipgo.ErrDialogResponse
+	switch {
+	case errors.As(err, &resVal):
+		res = resVal.Res
+	case errors.As(err, &resPtr):
+		res = resPtr.Res
+	}
+	if res == nil || res.StatusCode < 300 || res.StatusCode > 302 {
+		return nil
+	}
+	return res
+}
+
+// redirectTarget picks the Contact of res with the highest q value that was
+// not tried yet (RFC 3261 8.1.3.4).
+func redirectTarget(res *sip.Response, tried []string) (sip.Uri, bool) {
+	var contacts []*sip.ContactHeader
+	for _, h := range res.GetHeaders("contact") {
+		if c, ok := h.(*sip.ContactHeader); ok && c.Address.Host != "" {
+			contacts = append(contacts, c)
+		}
+	}
+	slices.SortStableFunc(contacts, func(a, b *sip.ContactHeader) int {
+		return contactQ(b) - contactQ(a)
+	})
+	for _, c := range contacts {
+		if !slices.Contains(tried, redirectKey(c.Address)) {
+			return *c.Address.Clone(), true
+		}
+	}
+	return sip.Uri{}, false
+}
+
+// contactQ is the q parameter of c in thousandths; 1000 when absent.
+func contactQ(c *sip.ContactHeader) int {
+	q, ok := c.Params.Get("q")
+	if !ok {
+		return 1000
+	}
+	f, err := strconv.ParseFloat(q, 64)
+	if err != nil {
+		return 0
+	}
+	return int(f * 1000)
+}
+
+// redirectKey identifies a target for loop detection: user, host and port.
+func redirectKey(u sip.Uri) string {
+	return strings.ToLower(u.User + "@" + u.Host + ":" + strconv.Itoa(u.Port))
+}
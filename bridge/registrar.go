@@ -0,0 +1,455 @@
+package bridge
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// Lifetimes of registrations to the bridge: the default when a phone asks
+// for none, and the bounds of what it may ask for.
+const (
+	registrarDefaultExpiry = time.Hour
+	registrarMinExpiry     = time.Minute
+	registrarMaxExpiry     = 2 * time.Hour
+)
+
+const (
+	// registrarMaxContacts bounds the phones registered to one account.
+	registrarMaxContacts = 10
+	// registrarSweepInterval is how often expired registrations are dropped.
+	registrarSweepInterval = 30 * time.Second
+)
+
+// sipBinding is a phone registered to a local account.
+type sipBinding struct {
+	// Contact is the Contact URI as the phone registered it; Target is
+	// Contact at the address the REGISTER came from, which also gets
+	// through the phone's NAT.
+	Contact    sip.Uri
+	Target     sip.Uri
+	Expires    time.Time
+	Registered time.Time
+	UserAgent  string
+	// key tells the account's phones apart: the +sip.instance of the
+	// Contact (RFC 5626), else its address.
+	key string
+}
+
+// AccountRegistration is a phone registered to a local SIP account.
+type AccountRegistration struct {
+	Username  string    `json:"username"`
+	Contact   string    `json:"contact"`
+	Target    string    `json:"target"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// handleRegister is the registrar of sip.accounts: it authenticates a
+// REGISTER with the account in its To header and adds, refreshes or
+// removes the contacts it carries. Several phones can register to one
+// account; calls to it ring all of them.
+func (s *Service) handleRegister(req *sip.Request, tx sip.ServerTransaction) {
+	respond := func(res *sip.Response) {
+		if err := tx.Respond(res); err != nil {
+			s.logger.Warn("sip register: response failed", "error", err)
+		}
+	}
+	to := req.To()
+	if to == nil {
+		respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil))
+		return
+	}
+	user := to.Address.User
+	logger := s.logger.With("account", user, "source", req.Source())
+	acc, ok := s.sipAccount(user)
+	if !ok {
+		logger.Info("sip register rejected (unknown account)")
+		respond(sip.NewResponseFromRequest(req, sip.StatusNotFound, "Not Found", nil))
+		return
+	}
+	res, err := s.authServer.AuthorizeRequest(req, s.digestAuth(acc.Username, acc.Password))
+	if err != nil || res.StatusCode != sip.StatusOK {
+		if err != nil {
+			logger.Warn("sip register auth failed", "error", err)
+		}
+		respond(res)
+		return
+	}
+
+	// Without contacts the REGISTER only asks for the current ones.
+	contacts := registerContacts(req)
+	expiries := make([]time.Duration, len(contacts))
+	for i, contact := range contacts {
+		expiry, ok := registerExpiry(req, contact)
+		switch {
+		case !ok:
+			respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Expires", nil))
+			return
+		case contact.Address.Wildcard && (expiry != 0 || len(contacts) > 1):
+			respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Wildcard", nil))
+			return
+		case expiry > 0 && expiry < registrarMinExpiry:
+			res := sip.NewResponseFromRequest(req, 423, "Interval Too Brief", nil)
+			res.AppendHeader(sip.NewHeader("Min-Expires", strconv.Itoa(int(registrarMinExpiry.Seconds()))))
+			respond(res)
+			return
+		}
+		expiries[i] = min(expiry, registrarMaxExpiry)
+	}
+	userAgent := ""
+	if h := req.GetHeader("User-Agent"); h != nil {
+		userAgent = h.Value()
+	}
+	var (
+		unset []string
+		set   []sipBinding
+	)
+	now := time.Now()
+	for i, contact := range contacts {
+		switch {
+		case contact.Address.Wildcard:
+			unset = append(unset, "")
+		case expiries[i] == 0:
+			unset = append(unset, bindingKey(contact))
+		default:
+			set = append(set, sipBinding{
+				Contact:    *contact.Address.Clone(),
+				Target:     bindingTarget(req, contact.Address),
+				Expires:    now.Add(expiries[i]),
+				Registered: now,
+				UserAgent:  userAgent,
+				key:        bindingKey(contact),
+			})
+		}
+	}
+	// The request is applied as a whole, so one rejected for too many
+	// contacts leaves the earlier bindings as they were.
+	added, removed, ok := s.registerBindings(acc.Username, unset, set)
+	if !ok {
+		logger.Warn("sip register rejected (too many contacts)", "max", registrarMaxContacts)
+		respond(sip.NewResponseFromRequest(req, sip.StatusForbidden, "Too Many Contacts", nil))
+		return
+	}
+	if slices.Contains(unset, "") {
+		logger.Info("sip account unregistered (all contacts)")
+	}
+	for _, b := range removed {
+		logger.Info("sip account unregistered", "contact", b.Contact.String())
+	}
+	for _, b := range added {
+		logger.Info("sip account registered", "contact", b.Contact.String(), "target", b.Target.String(), "expires", b.Expires.Sub(b.Registered), "user_agent", userAgent)
+	}
+
+	res = sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	for _, b := range s.accountBindings(acc.Username) {
+		remaining := max(time.Until(b.Expires).Round(time.Second), time.Second)
+		res.AppendHeader(&sip.ContactHeader{
+			Address: b.Contact,
+			Params:  sip.HeaderParams{"expires": strconv.Itoa(int(remaining.Seconds()))},
+		})
+	}
+	respond(res)
+}
+
+// registerContacts returns the Contact headers of a REGISTER.
+func registerContacts(req *sip.Request) []*sip.ContactHeader {
+	var contacts []*sip.ContactHeader
+	for _, h := range req.GetHeaders("Contact") {
+		if c, ok := h.(*sip.ContactHeader); ok {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts
+}
+
+// registerExpiry is the lifetime a REGISTER asks for contact: its expires
+// parameter, else the Expires header, else registrarDefaultExpiry. ok is
+// false for an invalid value.
+func registerExpiry(req *sip.Request, contact *sip.ContactHeader) (expiry time.Duration, ok bool) {
+	value, _ := contact.Params.Get("expires")
+	if value == "" {
+		if h := req.GetHeader("Expires"); h != nil {
+			value = strings.TrimSpace(h.Value())
+		}
+	}
+	if value == "" {
+		return registrarDefaultExpiry, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// bindingKey identifies the phone registering contact.
+func bindingKey(contact *sip.ContactHeader) string {
+	if instance, ok := contact.Params.Get("+sip.instance"); ok && instance != "" {
+		return instance
+	}
+	return contact.Address.Addr()
+}
+
+// bindingTarget is contact at the address req came from, over the same
+// transport, so calls reach phones behind NAT.
+func bindingTarget(req *sip.Request, contact sip.Uri) sip.Uri {
+	target := *contact.Clone()
+	host, port, err := net.SplitHostPort(req.Source())
+	if err != nil {
+		return target
+	}
+	target.Host = host
+	if p, err := strconv.Atoi(port); err == nil {
+		target.Port = p
+	}
+	if tp := strings.ToLower(req.Transport()); tp != "" && tp != "udp" {
+		if target.UriParams == nil {
+			target.UriParams = sip.HeaderParams{}
+		}
+		target.UriParams.Add("transport", tp)
+	}
+	return target
+}
+
+// registerBindings applies one REGISTER to the phones of user: the keys in
+// unset are removed ("" removes them all), then each binding in set is
+// added or refreshed. Nothing changes when that would leave user with more
+// than registrarMaxContacts phones; ok is false then. added holds the new
+// contacts (not refreshes), removed the phones unset by key.
+func (s *Service) registerBindings(user string, unset []string, set []sipBinding) (added, removed []sipBinding, ok bool) {
+	s.bindingsMu.Lock()
+	defer s.bindingsMu.Unlock()
+	bindings := slices.Clone(s.bindings[user])
+	for _, key := range unset {
+		if key == "" {
+			bindings = nil
+			continue
+		}
+		bindings = slices.DeleteFunc(bindings, func(b sipBinding) bool {
+			if b.key == key {
+				removed = append(removed, b)
+				return true
+			}
+			return false
+		})
+	}
+	now := time.Now()
+	for _, b := range set {
+		if i := slices.IndexFunc(bindings, func(old sipBinding) bool { return old.key == b.key }); i >= 0 {
+			old := bindings[i]
+			bindings[i] = b
+			if old.Target.String() != b.Target.String() || now.After(old.Expires) {
+				added = append(added, b)
+			}
+			continue
+		}
+		bindings = append(bindings, b)
+		added = append(added, b)
+	}
+	if len(bindings) > registrarMaxContacts {
+		return nil, nil, false
+	}
+	if len(bindings) == 0 {
+		delete(s.bindings, user)
+	} else {
+		s.bindings[user] = bindings
+	}
+	return added, removed, true
+}
+
+// accountBindings returns the phones registered to user that haven't
+// expired, the latest registered first.
+func (s *Service) accountBindings(user string) []sipBinding {
+	s.bindingsMu.Lock()
+	defer s.bindingsMu.Unlock()
+	now := time.Now()
+	var out []sipBinding
+	for _, b := range s.bindings[user] {
+		if now.Before(b.Expires) {
+			out = append(out, b)
+		}
+	}
+	slices.SortFunc(out, func(a, b sipBinding) int { return b.Registered.Compare(a.Registered) })
+	return out
+}
+
+// accountURI is the phone last registered to the local account user.
+func (s *Service) accountURI(user string) (sip.Uri, error) {
+	targets, err := s.accountTargets(user)
+	if err != nil {
+		return sip.Uri{}, err
+	}
+	return targets[0], nil
+}
+
+// accountTargets are the phones registered to the local account user, the
+// latest registered first.
+func (s *Service) accountTargets(user string) ([]sip.Uri, error) {
+	bindings := s.accountBindings(strings.TrimSpace(user))
+	if len(bindings) == 0 {
+		return nil, ErrNotRegistered
+	}
+	targets := make([]sip.Uri, 0, len(bindings))
+	for _, b := range bindings {
+		targets = append(targets, *b.Target.Clone())
+	}
+	return targets, nil
+}
+
+// outboundTargets is where a call to number goes: every phone registered
+// to a local account, or the URI of a number through the SIP trunk.
+func (s *Service) outboundTargets(number string) ([]sip.Uri, error) {
+	if _, ok := s.sipAccount(number); ok {
+		return s.accountTargets(number)
+	}
+	recipient, err := s.buildOutboundURI(number)
+	if err != nil {
+		return nil, err
+	}
+	return []sip.Uri{recipient}, nil
+}
+
+// AccountRegistrations lists the phones registered to local SIP accounts.
+func (s *Service) AccountRegistrations() []AccountRegistration {
+	var out []AccountRegistration
+	for _, acc := range s.cfg.SIPAccounts {
+		for _, b := range s.accountBindings(acc.Username) {
+			out = append(out, AccountRegistration{
+				Username:  acc.Username,
+				Contact:   b.Contact.String(),
+				Target:    b.Target.String(),
+				Expires:   b.Expires,
+				UserAgent: b.UserAgent,
+			})
+		}
+	}
+	return out
+}
+
+// startRegistrar drops the phones that stopped refreshing their
+// registration.
+func (s *Service) startRegistrar(ctx context.Context) {
+	if len(s.cfg.SIPAccounts) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(registrarSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.sweepBindings()
+			}
+		}
+	}()
+}
+
+func (s *Service) sweepBindings() {
+	s.bindingsMu.Lock()
+	defer s.bindingsMu.Unlock()
+	now := time.Now()
+	for user, bindings := range s.bindings {
+		bindings = slices.DeleteFunc(bindings, func(b sipBinding) bool {
+			if now.Before(b.Expires) {
+				return false
+			}
+			s.logger.Info("sip account registration expired", "account", user, "contact", b.Contact.String())
+			return true
+		})
+		if len(bindings) == 0 {
+			delete(s.bindings, user)
+		} else {
+			s.bindings[user] = bindings
+		}
+	}
+}
+
+// forkInvite rings all targets at once, each with invite on its own
+// context, and returns the dialog of the first to answer. The others stop
+// ringing, or are hung up when they answered as well. When nobody answers
+// it fails with the error of the first target.
+func forkInvite(ctx context.Context, targets []sip.Uri, invite func(ctx context.Context, target sip.Uri) (*diago.DialogClientSession, error), logger *slog.Logger) (*diago.DialogClientSession, error) {
+	type branch struct {
+		i      int
+		dialog *diago.DialogClientSession
+		err    error
+	}
+	results := make(chan branch, len(targets))
+	cancels := make([]context.CancelFunc, len(targets))
+	for i, target := range targets {
+		branchCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func() {
+			dialog, err := invite(branchCtx, target)
+			results <- branch{i, dialog, err}
+		}()
+	}
+	var (
+		winner *diago.DialogClientSession
+		errs   = make([]error, len(targets))
+	)
+	for range targets {
+		r := <-results
+		switch {
+		case r.err != nil:
+			errs[r.i] = r.err
+		case winner == nil:
+			winner = r.dialog
+			if len(targets) > 1 {
+				logger.Info("sip fork: answered", "target", targets[r.i].String())
+			}
+			for i, cancel := range cancels {
+				if i != r.i {
+					cancel()
+				}
+			}
+		default:
+			// Answered at the same time as the winner.
+			hangupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_ = r.dialog.Hangup(hangupCtx)
+			cancel()
+			_ = r.dialog.Close()
+		}
+	}
+	if winner == nil {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		return nil, errs[0]
+	}
+	// The winner's context lives on with its dialog.
+	return winner, nil
+}
+
+// inviteForked rings the phones of a local account for an outbound call.
+// Unlike a single target, a branch only wins when it answers: early media
+// from one phone must not stop the others ringing.
+func (s *Service) inviteForked(ctx context.Context, targets []sip.Uri, logger *slog.Logger, call *Call) (*diago.DialogClientSession, error) {
+	logger.Info("sip fork: ringing registered phones", "targets", len(targets))
+	return forkInvite(ctx, targets, func(ctx context.Context, target sip.Uri) (*diago.DialogClientSession, error) {
+		dialog, earlyMedia, err := s.inviteWithEarlyMedia(ctx, target, logger, call)
+		if err != nil || !earlyMedia {
+			return dialog, err
+		}
+		if err := dialog.WaitAnswer(ctx, sipgo.AnswerOptions{}); err != nil {
+			_ = dialog.Close()
+			return nil, err
+		}
+		if err := dialog.Ack(ctx); err != nil {
+			_ = dialog.Close()
+			return nil, err
+		}
+		return dialog, nil
+	}, logger)
+}
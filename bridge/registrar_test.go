@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRegisterBindings(t *testing.T) {
+	binding := func(key string) sipBinding {
+		return sipBinding{Expires: time.Now().Add(time.Hour), key: key}
+	}
+	full := func() *Service {
+		s := &Service{bindings: map[string][]sipBinding{}}
+		for i := range registrarMaxContacts {
+			s.bindings["100"] = append(s.bindings["100"], binding(fmt.Sprint(i)))
+		}
+		return s
+	}
+	tests := []struct {
+		name   string
+		unset  []string
+		set    []sipBinding
+		wantOK bool
+		want   int
+	}{
+		{"refresh", nil, []sipBinding{binding("0")}, true, registrarMaxContacts},
+		{"refresh and one too many", nil, []sipBinding{binding("0"), binding("new")}, false, registrarMaxContacts},
+		{"unset and add", []string{"3"}, []sipBinding{binding("new")}, true, registrarMaxContacts},
+		{"unset all and add", []string{""}, []sipBinding{binding("new")}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := full()
+			_, _, ok := s.registerBindings("100", tt.unset, tt.set)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got := len(s.bindings["100"]); got != tt.want {
+				t.Errorf("%d bindings, want %d", got, tt.want)
+			}
+			if !ok {
+				for i, b := range s.bindings["100"] {
+					if b.key != fmt.Sprint(i) {
+						t.Errorf("binding %d changed to %q after a rejected request", i, b.key)
+					}
+				}
+			}
+		})
+	}
+}
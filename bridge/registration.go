@@ -0,0 +1,375 @@
+package bridge
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/webhook"
+)
+
+// Registration states.
+const (
+	RegistrationDisabled     = "disabled"
+	RegistrationRegistering  = "registering"
+	RegistrationRegistered   = "registered"
+	RegistrationFailed       = "failed"
+	RegistrationUnregistered = "unregistered"
+)
+
+const (
+	// Failed registrations are retried after regBackoffMin, doubling up to
+	// regBackoffMax.
+	regBackoffMin = 5 * time.Second
+	regBackoffMax = 5 * time.Minute
+	// regAuthBackoff is the retry delay after the registrar rejected the
+	// credentials; retrying faster can get the account locked.
+	regAuthBackoff = 10 * time.Minute
+	// regMinRefresh bounds how often a registration is refreshed when the
+	// registrar grants very short expiries.
+	regMinRefresh = 10 * time.Second
+	// regUnregisterTimeout bounds the unregister on shutdown.
+	regUnregisterTimeout = 5 * time.Second
+	// Keep-alive intervals of outbound flows when the registrar sends no
+	// Flow-Timer, within the ranges RFC 5626 recommends.
+	regKeepAliveUDP = 25 * time.Second
+	regKeepAliveTCP = 110 * time.Second
+)
+
+// Registration is the state of the REGISTER binding with sip.provider_host.
+type Registration struct {
+	State     string `json:"state"`
+	Registrar string `json:"registrar,omitempty"`
+	// Expires is when the current binding runs out.
+	Expires time.Time `json:"expires,omitzero"`
+	// Failures counts attempts failed in a row; LastError and LastStatus
+	// (the SIP response code, if any) describe the latest one.
+	Failures   int       `json:"failures"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastStatus int       `json:"last_status,omitempty"`
+	NextRetry  time.Time `json:"next_retry,omitzero"`
+}
+
+// registrar keeps the bridge registered while it runs.
+type registrar struct {
+	mu     sync.Mutex
+	info   Registration
+	tx     *diago.RegisterTransaction
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Registration returns the current registration state.
+func (s *Service) Registration() Registration {
+	s.reg.mu.Lock()
+	defer s.reg.mu.Unlock()
+	if s.reg.info.State == "" {
+		return Registration{State: RegistrationDisabled}
+	}
+	return s.reg.info
+}
+
+func (s *Service) updateRegistration(update func(*Registration)) {
+	s.reg.mu.Lock()
+	update(&s.reg.info)
+	info := s.reg.info
+	s.reg.mu.Unlock()
+	s.saveRegistration(info)
+}
+
+// startRegistration registers with sip.provider_host when credentials are
+// configured and keeps the binding fresh until unregister or ctx ends.
+func (s *Service) startRegistration(ctx context.Context) {
+	if s.cfg.SIPAuthUser == "" || s.cfg.SIPAuthPass == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	recipient := SIPRegisterRecipient(s.cfg)
+	s.reg.mu.Lock()
+	s.reg.cancel = cancel
+	s.reg.done = make(chan struct{})
+	s.reg.info = Registration{State: RegistrationRegistering, Registrar: recipient.String()}
+	s.reg.mu.Unlock()
+	go s.runRegistration(ctx, recipient)
+}
+
+func (s *Service) runRegistration(ctx context.Context, recipient sip.Uri) {
+	defer close(s.reg.done)
+	logger := s.logger.With("registrar", recipient.String())
+	expiry := s.cfg.SIPRegisterExpiry
+	backoff := regBackoffMin
+	if prev, ok := s.savedRegistration(); ok && prev.State == RegistrationFailed &&
+		prev.Registrar == recipient.String() && time.Until(prev.NextRetry) > 0 {
+		// A restart doesn't cut short the wait after a failure, e.g. the
+		// long one after rejected credentials that keeps the account from
+		// being locked.
+		wait := time.Until(prev.NextRetry)
+		s.updateRegistration(func(r *Registration) { *r = prev })
+		logger.Warn("sip registration failed before restart, waiting", "error", prev.LastError, "retry_in", wait.Round(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	for {
+		s.updateRegistration(func(r *Registration) {
+			r.State = RegistrationRegistering
+			r.NextRetry = time.Time{}
+		})
+		opts := diago.RegisterOptions{
+			Username:  s.cfg.SIPAuthUser,
+			Password:  s.cfg.SIPAuthPass,
+			ProxyHost: cmp.Or(s.cfg.SIPOutboundProxy, s.cfg.SIPProvider),
+			Expiry:    expiry,
+		}
+		if s.cfg.SIPOutbound {
+			opts.InstanceID = s.cfg.SIPInstanceID
+			opts.RegID = 1
+		}
+		tx, err := s.sip.Load().RegisterTransaction(ctx, recipient, opts)
+		if err == nil {
+			err = tx.Register(ctx)
+		}
+		if err == nil {
+			backoff = regBackoffMin
+			err = s.keepRegistered(ctx, tx, logger)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := backoff
+		backoff = min(2*backoff, regBackoffMax)
+		var status int
+		var resErr *diago.RegisterResponseError
+		if errors.As(err, &resErr) {
+			status = resErr.StatusCode()
+			switch status {
+			case sip.StatusUnauthorized, sip.StatusProxyAuthRequired, sip.StatusForbidden:
+				logger.Error("sip registration rejected, check sip.auth_user and sip.auth_password", "status", status)
+				wait = regAuthBackoff
+			case 423: // Interval Too Brief
+				if minimum := headerSeconds(resErr.RegisterRes, "Min-Expires"); minimum > expiry {
+					logger.Info("sip registrar requires a longer expiry", "min_expires", minimum)
+					expiry = minimum
+					wait = 0
+				}
+			}
+			if after := headerSeconds(resErr.RegisterRes, "Retry-After"); after > 0 {
+				wait = after
+			}
+		}
+		// Spread retries so a registrar outage isn't followed by a burst.
+		if wait > 0 {
+			wait += rand.N(wait/10 + 1)
+		}
+		s.updateRegistration(func(r *Registration) {
+			r.State = RegistrationFailed
+			r.Expires = time.Time{}
+			r.Failures++
+			r.LastError = err.Error()
+			r.LastStatus = status
+			r.NextRetry = time.Now().Add(wait)
+		})
+		logger.Warn("sip registration failed", "error", err, "status", status, "retry_in", wait.Round(time.Second))
+		s.webhooks.Send(webhook.RegistrationFailed, s.Registration())
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// keepRegistered refreshes a registration at 3/4 of the expiry the
+// registrar granted until a refresh fails or ctx ends. In between it sends
+// keep-alives on the registered flow; a flow that can't be written to is
+// registered again right away.
+func (s *Service) keepRegistered(ctx context.Context, tx *diago.RegisterTransaction, logger *slog.Logger) error {
+	s.reg.mu.Lock()
+	s.reg.tx = tx
+	s.reg.mu.Unlock()
+	defer func() {
+		if ctx.Err() != nil {
+			return // unregister still needs it
+		}
+		s.reg.mu.Lock()
+		s.reg.tx = nil
+		s.reg.mu.Unlock()
+	}()
+
+	first := true
+	for {
+		expiry := tx.Expiry()
+		s.updateRegistration(func(r *Registration) {
+			r.State = RegistrationRegistered
+			r.Expires = time.Now().Add(expiry)
+			r.Failures = 0
+			r.LastError = ""
+			r.LastStatus = 0
+			r.NextRetry = time.Time{}
+		})
+		if first {
+			logger.Info("sip registered", "expiry", expiry)
+			first = false
+		} else {
+			logger.Debug("sip registration refreshed", "expiry", expiry)
+		}
+
+		timer := time.NewTimer(max(expiry*3/4, regMinRefresh))
+		if err := s.keepFlowAlive(ctx, tx, timer.C, logger); err != nil {
+			timer.Stop()
+			return err
+		}
+		if err := tx.Qualify(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// keepFlowAlive sends keep-alives on the registered flow until refresh
+// fires. Without sip.outbound or sip.keepalive_interval it just waits.
+func (s *Service) keepFlowAlive(ctx context.Context, tx *diago.RegisterTransaction, refresh <-chan time.Time, logger *slog.Logger) error {
+	interval := s.keepAliveInterval(tx)
+	if interval <= 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-refresh:
+			return nil
+		}
+	}
+	// RFC 5626 spreads keep-alives over 80-100% of the interval.
+	next := func() time.Duration { return interval - rand.N(interval/5+1) }
+	timer := time.NewTimer(next())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-refresh:
+			return nil
+		case <-timer.C:
+			timer.Reset(next())
+		}
+		if err := tx.KeepAlive(); err != nil {
+			logger.Warn("sip keep-alive failed, registering again", "error", err)
+			return err
+		}
+	}
+}
+
+// keepAliveInterval is sip.keepalive_interval, else for outbound
+// registrations the registrar's Flow-Timer or the default of the transport.
+func (s *Service) keepAliveInterval(tx *diago.RegisterTransaction) time.Duration {
+	if s.cfg.SIPKeepAlive > 0 || !s.cfg.SIPOutbound {
+		return s.cfg.SIPKeepAlive
+	}
+	if flowTimer := headerSeconds(tx.Response(), "Flow-Timer"); flowTimer > 0 {
+		return flowTimer
+	}
+	if s.cfg.SIPTransport == "udp" {
+		return regKeepAliveUDP
+	}
+	return regKeepAliveTCP
+}
+
+// defaultInstanceID derives a stable sip.instance_id from the account, so
+// the registrar recognizes the bridge across restarts.
+func defaultInstanceID(user, provider string) string {
+	sum := sha1.Sum([]byte("sip-tg-bridge:" + user + "@" + provider))
+	sum[6] = sum[6]&0x0f | 0x50 // version 5 (name-based)
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// isUUID reports whether s is a UUID in its canonical textual form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unregister stops refreshing the registration and removes the binding so
+// the provider stops routing calls here.
+func (s *Service) unregister() {
+	s.reg.mu.Lock()
+	cancel, done := s.reg.cancel, s.reg.done
+	s.reg.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+
+	s.reg.mu.Lock()
+	tx := s.reg.tx
+	s.reg.tx = nil
+	s.reg.mu.Unlock()
+	if tx == nil {
+		return
+	}
+	ctx, cancelUnregister := context.WithTimeout(context.Background(), regUnregisterTimeout)
+	defer cancelUnregister()
+	if err := tx.Unregister(ctx); err != nil {
+		s.logger.Warn("sip unregister failed", "error", err)
+		return
+	}
+	s.updateRegistration(func(r *Registration) {
+		r.State = RegistrationUnregistered
+		r.Expires = time.Time{}
+	})
+	s.logger.Info("sip unregistered")
+}
+
+// headerSeconds parses a header holding a number of seconds (Min-Expires,
+// Retry-After); 0 when absent or invalid.
+func headerSeconds(res *sip.Response, name string) time.Duration {
+	if res == nil {
+		return 0
+	}
+	h := res.GetHeader(name)
+	if h == nil {
+		return 0
+	}
+	// Retry-After may carry a comment and parameters after the value.
+	value := h.Value()
+	if i := strings.IndexAny(value, " ;("); i >= 0 {
+		value = value[:i]
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Tunables are the settings a config reload applies to the running bridge
+// without dropping calls. Field names match Config. New calls pick up the
+// reloaded values; calls in progress keep the ones they were set up with.
+type Tunables struct {
+	EstablishTimeout  time.Duration
+	EnableEarlyMedia  bool
+	JitterMinDelay    time.Duration
+	JitterMaxDelay    time.Duration
+	JitterRTCPAdapt   bool
+	DriftTargetFrames int
+	DriftMaxBurst     int
+	MaxActiveCalls    int64
+	DrainTimeout      time.Duration
+
+	CallersAllow        []string
+	CallersDeny         []string
+	CallersRejectStatus int
+}
+
+// Tunables returns the reloadable part of c.
+func (c Config) Tunables() Tunables {
+	return Tunables{
+		EstablishTimeout:  c.EstablishTimeout,
+		EnableEarlyMedia:  c.EnableEarlyMedia,
+		JitterMinDelay:    c.JitterMinDelay,
+		JitterMaxDelay:    c.JitterMaxDelay,
+		JitterRTCPAdapt:   c.JitterRTCPAdapt,
+		DriftTargetFrames: c.DriftTargetFrames,
+		DriftMaxBurst:     c.DriftMaxBurst,
+		MaxActiveCalls:    c.MaxActiveCalls,
+		DrainTimeout:      c.DrainTimeout,
+
+		CallersAllow:        c.CallersAllow,
+		CallersDeny:         c.CallersDeny,
+		CallersRejectStatus: c.CallersRejectStatus,
+	}
+}
+
+// Diff returns the names of the fields whose values differ between c and
+// other.
+func (c Config) Diff(other Config) []string {
+	return diffFields(c, other)
+}
+
+func diffFields[T any](a, b T) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := range va.NumField() {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// ReloadResult lists the settings changed by a reload.
+type ReloadResult struct {
+	// Applied settings are in effect for new calls.
+	Applied []string `json:"applied"`
+	// SIPRestart settings differ from the ones the SIP stack runs with and
+	// take effect after RestartSIP.
+	SIPRestart []string `json:"sip_restart"`
+	// RestartRequired settings differ from the running ones but only take
+	// effect after a restart.
+	RestartRequired []string `json:"restart_required"`
+}
+
+// ErrNoConfigPath is returned by Reload when SetConfigPath was not called.
+var ErrNoConfigPath = errors.New("config path not set")
+
+// SetConfigPath sets the file Reload reads. Must be called before Start.
+func (s *Service) SetConfigPath(path string) {
+	s.configPath = path
+}
+
+// Tunables returns the settings currently in effect for new calls.
+func (s *Service) Tunables() Tunables {
+	s.tunablesMu.RLock()
+	defer s.tunablesMu.RUnlock()
+	return s.tunables
+}
+
+// Reload re-reads the config file and applies the changed tunables. An
+// invalid file leaves the running settings untouched.
+func (s *Service) Reload() (ReloadResult, error) {
+	if s.configPath == "" {
+		return ReloadResult{}, ErrNoConfigPath
+	}
+	cfg, err := LoadConfig(s.configPath)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	next := cfg.Tunables()
+
+	s.tunablesMu.Lock()
+	res := ReloadResult{Applied: diffFields(s.tunables, next)}
+	s.tunables = next
+	s.tunablesMu.Unlock()
+
+	res.SIPRestart = diffFields(*s.sipSettings.Load(), cfg.sipStackSettings())
+	for _, name := range s.cfg.Diff(cfg) {
+		if _, ok := reflect.TypeFor[Tunables]().FieldByName(name); ok {
+			continue
+		}
+		if _, ok := reflect.TypeFor[sipStackSettings]().FieldByName(name); ok {
+			continue
+		}
+		res.RestartRequired = append(res.RestartRequired, name)
+	}
+	s.logger.Info("config reloaded", "path", s.configPath, "applied", res.Applied, "sip_restart", res.SIPRestart, "restart_required", res.RestartRequired)
+	return res, nil
+}
@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// RingProfile changes how inbound calls from one number ring
+// (contacts.ring_profiles).
+type RingProfile struct {
+	// Message replaces the incoming call notice; {name}, {number} and {to}
+	// are filled in. Sound is an audio file sent with it (as the message's
+	// caption). Silent sends them without a notification sound.
+	Message string
+	Sound   string
+	Silent  bool
+	// SkipScreening lets the caller past callers.allow/deny and
+	// call.confirm_inbound.
+	SkipScreening bool
+	// RingTimeout replaces call.establish_timeout and call.confirm_timeout
+	// for these calls (0 = keep them).
+	RingTimeout time.Duration
+	// AutoAnswer answers the SIP call at once, before Telegram rings
+	// (trusted intercoms); the caller hears the ringback in the call.
+	AutoAnswer bool
+}
+
+type yamlRingProfile struct {
+	Message       string `yaml:"message"`
+	Sound         string `yaml:"sound"`
+	Silent        bool   `yaml:"silent"`
+	SkipScreening bool   `yaml:"skip_screening"`
+	RingTimeout   string `yaml:"ring_timeout"`
+	AutoAnswer    bool   `yaml:"auto_answer"`
+}
+
+func parseRingProfiles(profiles map[string]yamlRingProfile) (map[string]RingProfile, error) {
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]RingProfile, len(profiles))
+	for number, y := range profiles {
+		if phoneDigits(number) == "" {
+			return nil, fmt.Errorf("invalid contacts.ring_profiles number %q", number)
+		}
+		p := RingProfile{
+			Message:       y.Message,
+			Sound:         y.Sound,
+			Silent:        y.Silent,
+			SkipScreening: y.SkipScreening,
+			AutoAnswer:    y.AutoAnswer,
+		}
+		if y.RingTimeout != "" {
+			d, err := time.ParseDuration(y.RingTimeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid contacts.ring_profiles[%s].ring_timeout %q", number, y.RingTimeout)
+			}
+			p.RingTimeout = d
+		}
+		out[number] = p
+	}
+	return out, nil
+}
+
+// ringProfile returns the ring profile of number; the zero profile when it
+// has none.
+func (s *Service) ringProfile(number string) RingProfile {
+	return lookupPhone(s.cfg.RingProfiles, number)
+}
+
+// ringNotice is the custom incoming call notice of call, or "" for the
+// default one.
+func ringNotice(call *Call) string {
+	if call.ring.Message == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{name}", displayParty(call.Name, call.Number),
+		"{number}", call.Number,
+		"{to}", call.Local,
+	).Replace(call.ring.Message)
+}
+
+// announceRing tells the Telegram user about call with text, and the ring
+// profile's sound if it has one.
+func (s *Service) announceRing(call *Call, text string, logger *slog.Logger) {
+	if s.tgClient == nil {
+		return
+	}
+	if call.ring.Sound != "" {
+		opts := &tg.MediaOptions{Caption: text, Silent: call.ring.Silent, FileName: filepath.Base(call.ring.Sound)}
+		_, err := s.tgClient.SendMedia(s.cfg.TGUserID, call.ring.Sound, opts)
+		if err == nil {
+			return
+		}
+		logger.Warn("ring profile: sound upload failed", "file", call.ring.Sound, "error", err)
+	}
+	if text == "" {
+		return
+	}
+	if _, err := s.tgClient.SendMessage(s.cfg.TGUserID, text, &tg.SendOptions{Silent: call.ring.Silent}); err != nil {
+		logger.Warn("tg notify failed", "chat_id", s.cfg.TGUserID, "error", err)
+	}
+}
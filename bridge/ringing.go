@@ -0,0 +1,136 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+)
+
+// Ringing is how inbound calls ring (call.ringing). Routes override it per
+// called number; zero fields keep the value they override.
+type Ringing struct {
+	// Timeout is how long Telegram rings before the call is given up (0 =
+	// call.establish_timeout, or call.confirm_timeout with
+	// call.confirm_inbound).
+	Timeout time.Duration
+	// Signal is the provisional response the caller gets while it rings:
+	// 180 Ringing (the default) or 183 Session Progress.
+	Signal int
+	// TimeoutStatus is the final response of a call not answered in time:
+	// 480, 486 or 604 (0 = 486 after call.confirm_inbound, 480 otherwise,
+	// as for other failures).
+	TimeoutStatus int
+}
+
+type yamlRinging struct {
+	Timeout       string `yaml:"timeout"`
+	Signal        string `yaml:"signal"`
+	TimeoutStatus int    `yaml:"timeout_status"`
+}
+
+// ringingTimeoutReasons are the timeout statuses allowed, with their
+// reason phrases.
+var ringingTimeoutReasons = map[int]string{
+	sip.StatusTemporarilyUnavailable: "Temporarily Unavailable",
+	sip.StatusBusyHere:               "Busy Here",
+	sip.StatusGlobalDecline:          "Decline",
+}
+
+func parseRinging(y yamlRinging, name string) (Ringing, error) {
+	var r Ringing
+	if y.Timeout != "" {
+		d, err := time.ParseDuration(y.Timeout)
+		if err != nil || d <= 0 {
+			return Ringing{}, fmt.Errorf("invalid %s.timeout %q", name, y.Timeout)
+		}
+		r.Timeout = d
+	}
+	switch strings.ToLower(strings.TrimSpace(y.Signal)) {
+	case "":
+	case "180", "ringing":
+		r.Signal = sip.StatusRinging
+	case "183", "progress":
+		r.Signal = sip.StatusSessionInProgress
+	default:
+		return Ringing{}, fmt.Errorf("invalid %s.signal %q (180 or 183)", name, y.Signal)
+	}
+	if _, ok := ringingTimeoutReasons[y.TimeoutStatus]; y.TimeoutStatus != 0 && !ok {
+		return Ringing{}, fmt.Errorf("invalid %s.timeout_status %d (480, 486 or 604)", name, y.TimeoutStatus)
+	}
+	r.TimeoutStatus = y.TimeoutStatus
+	return r, nil
+}
+
+func parseRingingRoutes(routes map[string]yamlRinging) (map[string]Ringing, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]Ringing, len(routes))
+	for number, y := range routes {
+		if phoneDigits(number) == "" {
+			return nil, fmt.Errorf("invalid call.ringing.routes number %q", number)
+		}
+		r, err := parseRinging(y, "call.ringing.routes["+number+"]")
+		if err != nil {
+			return nil, err
+		}
+		out[number] = r
+	}
+	return out, nil
+}
+
+// override returns r with the fields set in o replacing its own.
+func (r Ringing) override(o Ringing) Ringing {
+	if o.Timeout > 0 {
+		r.Timeout = o.Timeout
+	}
+	if o.Signal != 0 {
+		r.Signal = o.Signal
+	}
+	if o.TimeoutStatus != 0 {
+		r.TimeoutStatus = o.TimeoutStatus
+	}
+	return r
+}
+
+// ringing returns how a call to local rings: call.ringing, its route for
+// local, then the caller's ring profile timeout.
+func (s *Service) ringing(local string, profile RingProfile) Ringing {
+	r := s.cfg.Ringing.override(lookupPhone(s.cfg.RingingRoutes, local))
+	return r.override(Ringing{Timeout: profile.RingTimeout})
+}
+
+// sendRinging tells the caller the call rings, with 180 or 183 as
+// call.ringing.signal asks.
+func sendRinging(dialog *diago.DialogServerSession, r Ringing) error {
+	if r.Signal == sip.StatusSessionInProgress {
+		return dialog.SessionProgress()
+	}
+	return dialog.Ringing()
+}
+
+// ringTimeoutStatus is the status and reason a call not answered in time
+// gets, fallback unless call.ringing.timeout_status is set.
+func ringTimeoutStatus(r Ringing, fallback int, fallbackReason string) (int, string) {
+	if reason, ok := ringingTimeoutReasons[r.TimeoutStatus]; ok {
+		return r.TimeoutStatus, reason
+	}
+	return fallback, fallbackReason
+}
+
+func describeRinging(r Ringing) string {
+	var parts []string
+	if r.Timeout > 0 {
+		parts = append(parts, "rings "+r.Timeout.String())
+	}
+	if r.Signal == sip.StatusSessionInProgress {
+		parts = append(parts, "sends 183")
+	}
+	if r.TimeoutStatus != 0 {
+		parts = append(parts, fmt.Sprintf("%d on timeout", r.TimeoutStatus))
+	}
+	return strings.Join(parts, ", ")
+}
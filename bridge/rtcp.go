@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// rtcpPollInterval matches the interval diago sends RTCP reports at.
+	rtcpPollInterval = 5 * time.Second
+	// rtcpLossWarnPct is the loss, in either direction, logged as a
+	// degraded SIP leg.
+	rtcpLossWarnPct = 5.0
+	// playoutMaxExtra bounds how many frames adaptive playout adds to the
+	// drift target; playoutLossFrames are added while packets get lost.
+	playoutMaxExtra   = 30
+	playoutLossFrames = 2
+)
+
+// SetAdaptivePlayout makes the SIP->TG playout target follow the quality
+// of the SIP leg: it grows with jitter and loss and shrinks back once the
+// leg recovers. Must be called before Start.
+func (b *MediaBridge) SetAdaptivePlayout(on bool) {
+	b.adaptPlayout = on
+}
+
+// monitorRTCP samples the statistics the RTP session keeps for RTCP every
+// report interval: the jitter of the received stream, the round trip, and
+// the loss and jitter the remote side reports for our stream. They are
+// published in Stats, logged, and drive adaptive playout.
+func (b *MediaBridge) monitorRTCP() {
+	defer b.wg.Done()
+	defer b.recoverFault("monitorRTCP")
+	ticker := time.NewTicker(rtcpPollInterval)
+	defer ticker.Stop()
+	var (
+		lastReport         time.Time
+		lastIn, lastLost   uint64
+		degraded, reported bool
+	)
+	for {
+		select {
+		case <-b.ctx.Done():
+			if reported {
+				st := b.Stats()
+				b.logger.Info("sip rtcp summary",
+					"jitter_ms", st.SIPJitterMs,
+					"rtt_ms", st.SIPRTTMs,
+					"remote_loss_pct", st.SIPRemoteLossPct,
+					"remote_jitter_ms", st.SIPRemoteJitterMs,
+				)
+			}
+			return
+		case <-ticker.C:
+		}
+		read, write, ok := b.sip.Load().RTCPStats()
+		if !ok {
+			continue
+		}
+		jitter := read.Jitter()
+		b.stats.sipJitter.Store(int64(jitter))
+		if read.RTT > 0 {
+			b.stats.sipRTT.Store(int64(read.RTT))
+		}
+
+		// Loss of the received stream over the interval, as in our report.
+		in, lost := b.stats.sipPacketsIn.Load(), b.stats.sipPacketsLost.Load()
+		var localLoss float64
+		if total := (in - lastIn) + (lost - lastLost); total > 0 {
+			localLoss = 100 * float64(lost-lastLost) / float64(total)
+		}
+		lastIn, lastLost = in, lost
+
+		remoteLoss := -1.0
+		if !write.RemoteReportTime.IsZero() && write.RemoteReportTime != lastReport {
+			lastReport = write.RemoteReportTime
+			remoteLoss = 100 * write.RemoteFractionLost
+			b.stats.remoteLoss.Store(math.Float64bits(remoteLoss))
+			b.stats.remoteJitter.Store(int64(write.RemoteJitter))
+			reported = true
+			b.logger.Debug("sip rtcp report",
+				"jitter_ms", durationMs(jitter),
+				"loss_pct", localLoss,
+				"rtt_ms", read.RTT.Milliseconds(),
+				"remote_loss_pct", remoteLoss,
+				"remote_lost_total", write.RemoteTotalLost,
+				"remote_jitter_ms", durationMs(write.RemoteJitter),
+			)
+		}
+
+		if bad := localLoss >= rtcpLossWarnPct || remoteLoss >= rtcpLossWarnPct; bad != degraded {
+			degraded = bad
+			if bad {
+				b.logger.Warn("sip leg quality degraded", "loss_pct", localLoss, "remote_loss_pct", remoteLoss, "jitter_ms", durationMs(jitter))
+			} else {
+				b.logger.Info("sip leg quality recovered", "jitter_ms", durationMs(jitter))
+			}
+		}
+		if b.adaptPlayout {
+			b.adaptPlayoutTarget(jitter, localLoss)
+		}
+	}
+}
+
+// adaptPlayoutTarget sets the playout target to cover twice the jitter,
+// plus a margin while packets are lost. It grows at once and shrinks by a
+// frame per report, so the delay doesn't flap.
+func (b *MediaBridge) adaptPlayoutTarget(jitter time.Duration, lossPct float64) {
+	extra := int(math.Ceil(float64(2*jitter) / float64(b.tgFormat.FrameDur)))
+	if lossPct > 0 {
+		extra += playoutLossFrames
+	}
+	want := b.driftTarget + min(extra, playoutMaxExtra)
+	cur := int(b.playoutTarget.Load())
+	switch {
+	case want > cur:
+	case want < cur:
+		want = cur - 1
+	default:
+		return
+	}
+	b.playoutTarget.Store(int64(want))
+	b.logger.Debug("sip playout target adjusted", "from_frames", cur, "to_frames", want, "jitter_ms", durationMs(jitter), "loss_pct", lossPct)
+}
+
+// durationMs is d in milliseconds with a fractional part.
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
@@ -0,0 +1,154 @@
+package bridge
+
+import (
+	"errors"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gotgcalls/bridge/recording"
+	"gotgcalls/bridge/rtpdump"
+)
+
+var (
+	ErrDumpActive = errors.New("debug dump already active")
+	ErrNotDumping = errors.New("no debug dump active")
+)
+
+// callDump is the debug dump of one call (debug.rtp_dump): its RTP, and the
+// decoded audio of each direction at the Telegram format.
+type callDump struct {
+	rtp *rtpdump.Writer
+	// in is the SIP audio as decoded, out the audio before it is encoded
+	// for SIP.
+	in  *recording.Track
+	out *recording.Track
+}
+
+func (d *callDump) Files() []string {
+	return append(append(d.rtp.Files(), d.in.Path()), d.out.Path())
+}
+
+func (d *callDump) Close() error {
+	return errors.Join(d.rtp.Close(), d.in.Close(), d.out.Close())
+}
+
+// SetDebugDump turns the debug dump of calls on or off at runtime: on dumps
+// every call bridged from now on and the calls bridged already; off stops
+// all dumps.
+func (s *Service) SetDebugDump(on bool) {
+	s.debugDump.Store(on)
+	s.mu.Lock()
+	calls := make([]*Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		calls = append(calls, c)
+	}
+	s.mu.Unlock()
+	for _, call := range calls {
+		var err error
+		if on {
+			_, err = s.StartDump(call)
+		} else {
+			_, err = s.StopDump(call)
+		}
+		if err != nil && !errors.Is(err, ErrNotBridged) && !errors.Is(err, ErrDumpActive) && !errors.Is(err, ErrNotDumping) {
+			s.logger.Warn("debug dump toggle failed", "bridge_call_id", call.ID, "error", err)
+		}
+	}
+	s.logger.Info("debug dump toggled", "enabled", on, "dir", s.cfg.RTPDumpDir, "format", s.cfg.RTPDumpFormat)
+}
+
+// DebugDump reports whether new calls are dumped.
+func (s *Service) DebugDump() bool {
+	return s.debugDump.Load()
+}
+
+// StartDump starts the debug dump of call and returns the files being
+// written.
+func (s *Service) StartDump(call *Call) ([]string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return nil, ErrNotBridged
+	}
+	if media.Dumping() {
+		return nil, ErrDumpActive
+	}
+	sip := media.sip.Load()
+	if sip == nil {
+		return nil, ErrNotBridged
+	}
+	if err := os.MkdirAll(s.cfg.RTPDumpDir, 0o750); err != nil {
+		return nil, err
+	}
+	vars := recording.Vars{
+		ID:        call.ID,
+		Direction: string(call.Direction),
+		Number:    call.Number,
+		ChatID:    call.ChatID,
+		StartedAt: call.StartedAt,
+	}
+	opts := recording.Options{
+		Dir:        s.cfg.RTPDumpDir,
+		Template:   recording.DefaultTemplate + "_decoded_in",
+		SampleRate: s.tgFormat().SampleRate,
+	}
+	in, err := recording.StartTrack(opts, vars)
+	if err != nil {
+		return nil, err
+	}
+	opts.Template = recording.DefaultTemplate + "_decoded_out"
+	out, err := recording.StartTrack(opts, vars)
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	// The RTP dump is named like the tracks.
+	base := filepath.Join(s.cfg.RTPDumpDir, strings.TrimSuffix(filepath.Base(in.Path()), "_decoded_in.wav"))
+	w, err := rtpdump.Create(base, s.cfg.RTPDumpFormat, dumpAddr(sip.LocalAddr), dumpAddr(sip.RemoteAddr))
+	if err != nil {
+		_ = in.Close()
+		_ = out.Close()
+		return nil, err
+	}
+	d := &callDump{rtp: w, in: in, out: out}
+	if !media.StartDump(d) {
+		_ = d.Close()
+		return nil, ErrDumpActive
+	}
+	return d.Files(), nil
+}
+
+// StopDump finalizes the debug dump of call and returns its files.
+func (s *Service) StopDump(call *Call) ([]string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return nil, ErrNotBridged
+	}
+	d := media.StopDump()
+	if d == nil {
+		return nil, ErrNotDumping
+	}
+	return d.Files(), nil
+}
+
+// autoDump starts the debug dump of a freshly bridged call while debug
+// dumps are on.
+func (s *Service) autoDump(call *Call, logger *slog.Logger) {
+	if !s.debugDump.Load() {
+		return
+	}
+	if _, err := s.StartDump(call); err != nil {
+		logger.Warn("debug dump start failed", "error", err)
+	}
+}
+
+// dumpAddr parses an RTP address for the labels of a pcap dump.
+func dumpAddr(addr string) netip.AddrPort {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
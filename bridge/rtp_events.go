@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"gotgcalls/bridge/recording"
+	"gotgcalls/bridge/rtplog"
+)
+
+var (
+	ErrRTPLogActive = errors.New("rtp event log already active")
+	ErrNotRTPLogged = errors.New("no rtp event log active")
+)
+
+// callRTPLog is the RTP event log of one call (debug.rtp_events), written
+// to path when it stops.
+type callRTPLog struct {
+	log  *rtplog.Log
+	path string
+	info rtplog.Info
+}
+
+// SetRTPEventLog turns the RTP event log of calls on or off at runtime: on
+// logs every call bridged from now on and the calls bridged already; off
+// stops (and writes) all logs.
+func (s *Service) SetRTPEventLog(on bool) {
+	s.rtpEvents.Store(on)
+	s.mu.Lock()
+	calls := make([]*Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		calls = append(calls, c)
+	}
+	s.mu.Unlock()
+	for _, call := range calls {
+		var err error
+		if on {
+			_, err = s.StartRTPLog(call)
+		} else {
+			_, err = s.StopRTPLog(call)
+		}
+		if err != nil && !errors.Is(err, ErrNotBridged) && !errors.Is(err, ErrRTPLogActive) && !errors.Is(err, ErrNotRTPLogged) {
+			s.logger.Warn("rtp event log toggle failed", "bridge_call_id", call.ID, "error", err)
+		}
+	}
+	s.logger.Info("rtp event log toggled", "enabled", on, "dir", s.cfg.RTPEventsDir)
+}
+
+// RTPEventLog reports whether new calls get an RTP event log.
+func (s *Service) RTPEventLog() bool {
+	return s.rtpEvents.Load()
+}
+
+// StartRTPLog starts the RTP event log of call and returns the file it is
+// written to when the call ends (or the log is stopped).
+func (s *Service) StartRTPLog(call *Call) (string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return "", ErrNotBridged
+	}
+	sip := media.sip.Load()
+	if sip == nil {
+		return "", ErrNotBridged
+	}
+	if err := os.MkdirAll(s.cfg.RTPEventsDir, 0o750); err != nil {
+		return "", err
+	}
+	name := recording.ExpandTemplate(recording.DefaultTemplate, recording.Vars{
+		ID:        call.ID,
+		Direction: string(call.Direction),
+		Number:    call.Number,
+		ChatID:    call.ChatID,
+		StartedAt: call.StartedAt,
+	})
+	l := &callRTPLog{
+		log:  rtplog.New(sip.RTPClockRate),
+		path: filepath.Join(s.cfg.RTPEventsDir, name+"_rtp_events.json"),
+		info: rtplog.Info{
+			CallID:    call.ID,
+			Direction: string(call.Direction),
+			Number:    call.Number,
+			Codec:     sip.Codec.Name,
+			Remote:    sip.RemoteAddr,
+			StartedAt: call.StartedAt,
+		},
+	}
+	if !media.StartRTPLog(l) {
+		return "", ErrRTPLogActive
+	}
+	return l.path, nil
+}
+
+// StopRTPLog writes the RTP event log of call and returns its file.
+func (s *Service) StopRTPLog(call *Call) (string, error) {
+	media := call.mediaBridge()
+	if media == nil {
+		return "", ErrNotBridged
+	}
+	l := media.StopRTPLog()
+	if l == nil {
+		return "", ErrNotRTPLogged
+	}
+	return l.path, nil
+}
+
+// autoRTPLog starts the RTP event log of a freshly bridged call while RTP
+// event logs are on.
+func (s *Service) autoRTPLog(call *Call, logger *slog.Logger) {
+	if !s.rtpEvents.Load() {
+		return
+	}
+	if _, err := s.StartRTPLog(call); err != nil {
+		logger.Warn("rtp event log start failed", "error", err)
+	}
+}
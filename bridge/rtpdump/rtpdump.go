@@ -0,0 +1,218 @@
+// Package rtpdump writes the RTP of one call to files for debugging codec
+// and timing issues: as a pcap capture, or as the raw payloads of each
+// direction.
+package rtpdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Dump formats.
+const (
+	// FormatPCAP writes both directions to one pcap file, with synthesized
+	// IP/UDP headers so Wireshark can decode and play the RTP streams.
+	FormatPCAP = "pcap"
+	// FormatRaw writes the audio payloads of each direction back to back
+	// (playable as is for G.711 and G.722).
+	FormatRaw = "raw"
+)
+
+// pcap constants: microsecond timestamps and raw IPv4/IPv6 packets.
+const (
+	pcapMagic    = 0xa1b2c3d4
+	pcapSnapLen  = 65535
+	linkTypeRaw  = 101
+	ipProtoUDP   = 17
+	ipv4Header   = 20
+	ipv6Header   = 40
+	udpHeaderLen = 8
+)
+
+// Writer dumps packets. Its methods may be called from different
+// goroutines.
+type Writer struct {
+	mu     sync.Mutex
+	format string
+	local  netip.AddrPort
+	remote netip.AddrPort
+	files  []string
+	closed bool
+
+	pcap    *os.File
+	pcapBuf *bufio.Writer
+	ipID    uint16
+
+	rawIn, rawOut *os.File
+	hdr           []byte
+}
+
+// Create opens the files of a dump named base (a path without extension)
+// in format. local and remote are the RTP addresses of the call; they only
+// label the packets of a pcap dump.
+func Create(base, format string, local, remote netip.AddrPort) (*Writer, error) {
+	w := &Writer{format: format, local: local, remote: remote}
+	switch format {
+	case FormatPCAP:
+		f, err := w.create(base + ".pcap")
+		if err != nil {
+			return nil, err
+		}
+		w.pcap, w.pcapBuf = f, bufio.NewWriter(f)
+		hdr := make([]byte, 24)
+		binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+		binary.LittleEndian.PutUint16(hdr[4:], 2)
+		binary.LittleEndian.PutUint16(hdr[6:], 4)
+		binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+		binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+		if _, err := w.pcapBuf.Write(hdr); err != nil {
+			w.Close()
+			return nil, err
+		}
+	case FormatRaw:
+		var err error
+		if w.rawIn, err = w.create(base + "_in.raw"); err != nil {
+			return nil, err
+		}
+		if w.rawOut, err = w.create(base + "_out.raw"); err != nil {
+			w.Close()
+			return nil, err
+		}
+	default:
+		return nil, errors.New("rtpdump: unknown format " + format)
+	}
+	return w, nil
+}
+
+func (w *Writer) create(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	w.files = append(w.files, path)
+	return f, nil
+}
+
+// Files returns the files being written.
+func (w *Writer) Files() []string {
+	return w.files
+}
+
+// Packet dumps an RTP packet received (out false) or sent. audio tells
+// whether it carries the call's audio codec; raw dumps skip other packets
+// (DTMF events, comfort noise).
+func (w *Writer) Packet(out bool, h *rtp.Header, payload []byte, audio bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	if w.format == FormatRaw {
+		if !audio {
+			return nil
+		}
+		f := w.rawIn
+		if out {
+			f = w.rawOut
+		}
+		_, err := f.Write(payload)
+		return err
+	}
+	hdr, err := h.Marshal()
+	if err != nil {
+		return err
+	}
+	src, dst := w.remote, w.local
+	if out {
+		src, dst = dst, src
+	}
+	w.hdr = w.ipUDP(w.hdr[:0], src, dst, len(hdr)+len(payload))
+	size := len(w.hdr) + len(hdr) + len(payload)
+	now := time.Now()
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(size))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(size))
+	for _, b := range [][]byte{rec, w.hdr, hdr, payload} {
+		if _, err := w.pcapBuf.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipUDP appends the IP and UDP headers of a datagram of n bytes from src
+// to dst. The UDP checksum is left out (0), which IPv4 allows and
+// Wireshark accepts for IPv6 captures.
+func (w *Writer) ipUDP(b []byte, src, dst netip.AddrPort, n int) []byte {
+	udpLen := udpHeaderLen + n
+	if src.Addr().Is4() && dst.Addr().Is4() {
+		w.ipID++
+		ip := make([]byte, ipv4Header)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4Header+udpLen))
+		binary.BigEndian.PutUint16(ip[4:], w.ipID)
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64
+		ip[9] = ipProtoUDP
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		copy(ip[12:], s[:])
+		copy(ip[16:], d[:])
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		b = append(b, ip...)
+	} else {
+		ip := make([]byte, ipv6Header)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6] = ipProtoUDP
+		ip[7] = 64
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		copy(ip[8:], s[:])
+		copy(ip[24:], d[:])
+		b = append(b, ip...)
+	}
+	udp := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	return append(b, udp...)
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// Close finalizes the files. It is safe to call more than once.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var errs []error
+	if w.pcapBuf != nil {
+		errs = append(errs, w.pcapBuf.Flush())
+	}
+	for _, f := range []*os.File{w.pcap, w.rawIn, w.rawOut} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,238 @@
+// Package rtplog keeps a compact log of the notable events of a received
+// RTP stream: sequence gaps, payload type and SSRC changes, marker bits,
+// jitter spikes and the frames the decoder had to make up. Written as JSON
+// at the end of a call, it answers most questions a packet capture would,
+// at a fraction of the cost.
+package rtplog
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Event types.
+const (
+	// EventGap is a jump in sequence numbers; Count packets are missing.
+	EventGap = "gap"
+	// EventReorder is a packet older than the newest one seen (late,
+	// reordered or duplicated).
+	EventReorder = "reorder"
+	// EventReset is a sequence or timestamp jump too large for loss, i.e.
+	// the sender restarted its stream.
+	EventReset = "reset"
+	// EventPT is a payload type change (From, To).
+	EventPT = "pt_change"
+	// EventSSRC is a new synchronization source.
+	EventSSRC = "ssrc_change"
+	// EventMarker is a packet with the marker bit (talkspurt start).
+	EventMarker = "marker"
+	// EventJitter is a packet that arrived far off its expected time
+	// (DeltaMs) compared to the running jitter (JitterMs).
+	EventJitter = "jitter_spike"
+	// EventSilence is Count frames filled in for a pause of the sender (DTX)
+	// or for lost packets without loss concealment; EventConceal are Count
+	// lost frames made up by the codec.
+	EventSilence = "silence_fill"
+	EventConceal = "conceal"
+	// EventDTMF is a telephone-event digit.
+	EventDTMF = "dtmf"
+)
+
+const (
+	// MaxEvents bounds the log; later events are only counted.
+	MaxEvents = 10000
+	// resetGap is the sequence jump treated as a stream restart.
+	resetGap = 1000
+	// spikeFactor and spikeMin: a transit change of spikeFactor times the
+	// running jitter, and at least spikeMin, is a spike.
+	spikeFactor = 4
+	spikeMin    = 30 * time.Millisecond
+)
+
+// Event is one entry of the log. Fields not relevant to Type are omitted.
+type Event struct {
+	// TimeMs is the time since the log started.
+	TimeMs    int64   `json:"t_ms"`
+	Type      string  `json:"type"`
+	Seq       uint16  `json:"seq"`
+	Timestamp uint32  `json:"ts,omitempty"`
+	Count     int     `json:"count,omitempty"`
+	From      uint32  `json:"from,omitempty"`
+	To        uint32  `json:"to,omitempty"`
+	DeltaMs   float64 `json:"delta_ms,omitempty"`
+	JitterMs  float64 `json:"jitter_ms,omitempty"`
+	Digit     string  `json:"digit,omitempty"`
+}
+
+// Info describes the call a log belongs to.
+type Info struct {
+	CallID    string    `json:"call_id"`
+	Direction string    `json:"direction"`
+	Number    string    `json:"number"`
+	Codec     string    `json:"codec"`
+	Remote    string    `json:"remote"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Summary counts the packets and events of a log, including those past
+// MaxEvents.
+type Summary struct {
+	Packets       uint64            `json:"packets"`
+	Lost          uint64            `json:"lost"`
+	MaxJitterMs   float64           `json:"max_jitter_ms"`
+	Events        map[string]uint64 `json:"events"`
+	EventsDropped uint64            `json:"events_dropped,omitempty"`
+}
+
+// Log records the events of one RTP stream. Its methods may be called from
+// different goroutines.
+type Log struct {
+	mu        sync.Mutex
+	start     time.Time
+	clockRate float64
+	events    []Event
+	summary   Summary
+
+	have       bool
+	lastSeq    uint16
+	pt         uint8
+	ssrc       uint32
+	lastTS     uint32
+	lastArrive time.Time
+	jitter     float64 // seconds, RFC 3550 estimate
+}
+
+// New starts a log of a stream with RTP clockRate.
+func New(clockRate int) *Log {
+	return &Log{
+		start:     time.Now(),
+		clockRate: float64(max(clockRate, 1)),
+		summary:   Summary{Events: make(map[string]uint64)},
+	}
+}
+
+// SetClockRate changes the RTP clock rate after a codec change.
+func (l *Log) SetClockRate(clockRate int) {
+	l.mu.Lock()
+	l.clockRate = float64(max(clockRate, 1))
+	l.have = false
+	l.mu.Unlock()
+}
+
+// Packet records a received packet that arrived at at.
+func (l *Log) Packet(h *rtp.Header, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.summary.Packets++
+	if h.Marker {
+		l.add(at, Event{Type: EventMarker, Seq: h.SequenceNumber, Timestamp: h.Timestamp})
+	}
+	if !l.have {
+		l.have = true
+		l.lastSeq, l.pt, l.ssrc = h.SequenceNumber, h.PayloadType, h.SSRC
+		l.lastTS, l.lastArrive = h.Timestamp, at
+		return
+	}
+	if h.SSRC != l.ssrc {
+		l.add(at, Event{Type: EventSSRC, Seq: h.SequenceNumber, From: l.ssrc, To: h.SSRC})
+		l.ssrc = h.SSRC
+		l.lastSeq, l.lastTS, l.lastArrive = h.SequenceNumber, h.Timestamp, at
+		return
+	}
+	if h.PayloadType != l.pt {
+		l.add(at, Event{Type: EventPT, Seq: h.SequenceNumber, From: uint32(l.pt), To: uint32(h.PayloadType)})
+		l.pt = h.PayloadType
+	}
+	switch gap := h.SequenceNumber - l.lastSeq; {
+	case gap == 0 || int16(gap) < 0:
+		l.add(at, Event{Type: EventReorder, Seq: h.SequenceNumber, Count: int(-int16(gap))})
+		return
+	case gap >= resetGap:
+		l.add(at, Event{Type: EventReset, Seq: h.SequenceNumber, Timestamp: h.Timestamp, From: uint32(l.lastSeq)})
+		l.lastSeq, l.lastTS, l.lastArrive = h.SequenceNumber, h.Timestamp, at
+		return
+	case gap > 1:
+		l.summary.Lost += uint64(gap - 1)
+		l.add(at, Event{Type: EventGap, Seq: h.SequenceNumber, Count: int(gap - 1)})
+	}
+
+	// Transit time change (RFC 3550 6.4.1) against the previous packet.
+	arrival := at.Sub(l.lastArrive).Seconds()
+	sent := float64(int32(h.Timestamp-l.lastTS)) / l.clockRate
+	d := math.Abs(arrival - sent)
+	spike := time.Duration(d * float64(time.Second))
+	if spike >= spikeMin && d >= spikeFactor*l.jitter && !h.Marker {
+		// Talkspurts (marker) restart after silence and aren't late.
+		l.add(at, Event{Type: EventJitter, Seq: h.SequenceNumber, DeltaMs: round(d * 1000), JitterMs: round(l.jitter * 1000)})
+	}
+	l.jitter += (d - l.jitter) / 16
+	l.summary.MaxJitterMs = max(l.summary.MaxJitterMs, round(l.jitter*1000))
+	l.lastSeq, l.lastTS, l.lastArrive = h.SequenceNumber, h.Timestamp, at
+}
+
+// Fill records frames made up by the decoder: silence for a pause of the
+// sender (dtx), otherwise lost frames concealed by the codec or replaced
+// with silence.
+func (l *Log) Fill(frames int, dtx, concealed bool) {
+	typ := EventSilence
+	if concealed && !dtx {
+		typ = EventConceal
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(time.Now(), Event{Type: typ, Seq: l.lastSeq, Count: frames})
+}
+
+// DTMF records a telephone-event digit.
+func (l *Log) DTMF(h *rtp.Header, digit rune) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(time.Now(), Event{Type: EventDTMF, Seq: h.SequenceNumber, Timestamp: h.Timestamp, Digit: string(digit)})
+}
+
+func (l *Log) add(at time.Time, e Event) {
+	l.summary.Events[e.Type]++
+	if len(l.events) >= MaxEvents {
+		l.summary.EventsDropped++
+		return
+	}
+	e.TimeMs = at.Sub(l.start).Milliseconds()
+	l.events = append(l.events, e)
+}
+
+// Summary returns the counters of the log so far.
+func (l *Log) Summary() Summary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.summary
+	s.Events = make(map[string]uint64, len(l.summary.Events))
+	for k, v := range l.summary.Events {
+		s.Events[k] = v
+	}
+	return s
+}
+
+// WriteFile writes the log with info to path as indented JSON.
+func (l *Log) WriteFile(path string, info Info) error {
+	l.mu.Lock()
+	data, err := json.MarshalIndent(struct {
+		Info
+		ClockRate int     `json:"clock_rate"`
+		Summary   Summary `json:"summary"`
+		Events    []Event `json:"events"`
+	}{info, int(l.clockRate), l.summary, l.events}, "", " ")
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o640)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
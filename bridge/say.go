@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SayLeg is who hears the text spoken with Say.
+type SayLeg string
+
+const (
+	// SaySIP plays the speech to the SIP party.
+	SaySIP SayLeg = "sip"
+	// SayTG plays it to the Telegram side.
+	SayTG SayLeg = "tg"
+	// SayBoth plays it to both.
+	SayBoth SayLeg = "both"
+)
+
+// maxSayText bounds the text of one Say.
+const maxSayText = 1000
+
+// ErrInvalidSay is returned for a Say without text, with too much of it or
+// for an unknown leg.
+var ErrInvalidSay = errors.New("invalid say request")
+
+// Say speaks text into call with tts.command or tts.url, for the leg
+// given, and returns how long the speech lasts. It is mixed into the
+// call's audio, replacing speech still playing, and returns as soon as it
+// starts.
+func (s *Service) Say(ctx context.Context, call *Call, text string, leg SayLeg) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return 0, fmt.Errorf("%w: no text", ErrInvalidSay)
+	case utf8.RuneCountInString(text) > maxSayText:
+		return 0, fmt.Errorf("%w: text longer than %d characters", ErrInvalidSay, maxSayText)
+	}
+	switch leg {
+	case "":
+		leg = SaySIP
+	case SaySIP, SayTG, SayBoth:
+	default:
+		return 0, fmt.Errorf("%w: leg %q (sip, tg or both)", ErrInvalidSay, leg)
+	}
+	media := call.mediaBridge()
+	if media == nil {
+		return 0, ErrNotBridged
+	}
+	clip, err := s.synthesize(ctx, text)
+	if err != nil {
+		return 0, err
+	}
+	if leg != SayTG {
+		media.PlaySIP(clip.Once())
+	}
+	if leg != SaySIP {
+		media.PlayTG(clip.Once())
+	}
+	s.logger.Info("call: speech injected", "bridge_call_id", call.ID, "leg", leg, "chars", len(text))
+	return time.Duration(len(clip.Samples)) * time.Second / time.Duration(clip.SampleRate), nil
+}
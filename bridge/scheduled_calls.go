@@ -0,0 +1,288 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// scheduleIdleWait is how often the scheduler looks at its queue when
+	// nothing is due; scheduleBusyWait while a due call waits for the
+	// Telegram user or the call limit.
+	scheduleIdleWait = time.Minute
+	scheduleBusyWait = time.Second
+	// MaxScheduledCalls bounds the queue.
+	MaxScheduledCalls = 1000
+)
+
+var (
+	ErrNoScheduledCall = errors.New("no such scheduled call")
+	ErrScheduleFull    = errors.New("too many scheduled calls")
+)
+
+// ScheduledCall is an outbound call queued for later (/call ... at 15:00).
+type ScheduledCall struct {
+	ID     string `json:"id"`
+	Number string `json:"number"`
+	// CallerID (optional) is one of sip.caller_ids.
+	CallerID string `json:"caller_id,omitempty"`
+	// At is when the call is placed next; retries move it.
+	At        time.Time `json:"at"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	// CallID is the call in progress, if any.
+	CallID string `json:"call_id,omitempty"`
+}
+
+// ParseCallTime reads when a scheduled call is placed: "in 10m", "at 15:00"
+// (today, or tomorrow once passed) or "at 2026-01-02 15:00", in the local
+// time of now.
+func ParseCallTime(spec string, now time.Time) (time.Time, error) {
+	word, rest, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(word) {
+	case "in":
+		d, err := time.ParseDuration(strings.ReplaceAll(rest, " ", ""))
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid delay %q", rest)
+		}
+		return now.Add(d), nil
+	case "at":
+		for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", time.RFC3339} {
+			if t, err := time.ParseInLocation(layout, rest, now.Location()); err == nil {
+				return t, nil
+			}
+		}
+		for _, layout := range []string{"15:04", "15:04:05"} {
+			if t, err := time.ParseInLocation(layout, rest, now.Location()); err == nil {
+				at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+				if !at.After(now) {
+					at = at.AddDate(0, 0, 1)
+				}
+				return at, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", rest)
+	}
+	return time.Time{}, fmt.Errorf("expected \"at <time>\" or \"in <delay>\", got %q", spec)
+}
+
+// ScheduleCall queues a call to number for the Telegram user at at. Failed
+// calls are retried schedule.retries times, schedule.retry_interval apart;
+// the queue survives restarts in the state store.
+func (s *Service) ScheduleCall(number, callerID string, at time.Time) (ScheduledCall, error) {
+	if err := s.validateDialTarget(number); err != nil {
+		return ScheduledCall{}, err
+	}
+	if callerID != "" {
+		callerID = normalizePhone(callerID)
+		if !s.callerIDAllowed(callerID) {
+			return ScheduledCall{}, ErrCallerID
+		}
+	}
+	sc := &ScheduledCall{
+		ID:        newCallID(),
+		Number:    number,
+		CallerID:  callerID,
+		At:        at,
+		CreatedAt: time.Now(),
+	}
+	s.scheduleMu.Lock()
+	if len(s.scheduled) >= MaxScheduledCalls {
+		s.scheduleMu.Unlock()
+		return ScheduledCall{}, ErrScheduleFull
+	}
+	s.scheduled[sc.ID] = sc
+	s.saveScheduledCall(sc.ID)
+	s.scheduleMu.Unlock()
+	s.logger.Info("schedule: call queued", "id", sc.ID, "number", number, "at", at)
+	s.wakeScheduler()
+	return *sc, nil
+}
+
+// ScheduledCalls returns the queued calls, next first.
+func (s *Service) ScheduledCalls() []ScheduledCall {
+	s.scheduleMu.Lock()
+	calls := make([]ScheduledCall, 0, len(s.scheduled))
+	for _, sc := range s.scheduled {
+		calls = append(calls, *sc)
+	}
+	s.scheduleMu.Unlock()
+	slices.SortFunc(calls, func(a, b ScheduledCall) int { return a.At.Compare(b.At) })
+	return calls
+}
+
+// CancelScheduledCall drops scheduled call id, hanging it up if it is in
+// progress.
+func (s *Service) CancelScheduledCall(id string) error {
+	s.scheduleMu.Lock()
+	sc, ok := s.scheduled[id]
+	if ok {
+		delete(s.scheduled, id)
+		s.saveScheduledCall(id)
+	}
+	s.scheduleMu.Unlock()
+	if !ok {
+		return ErrNoScheduledCall
+	}
+	if sc.CallID != "" {
+		if call, ok := s.Call(sc.CallID); ok {
+			call.Hangup()
+		}
+	}
+	s.logger.Info("schedule: call cancelled", "id", id)
+	return nil
+}
+
+func (s *Service) wakeScheduler() {
+	select {
+	case s.scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// startScheduler loads the queue from the state store and places the
+// scheduled calls as they come due, one at a time. A due call waits while
+// the Telegram user is in a call or the call limit is reached.
+func (s *Service) startScheduler(ctx context.Context) {
+	s.loadScheduledCalls()
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.scheduleWake:
+			case <-timer.C:
+			}
+			wait := scheduleIdleWait
+			if !s.draining.Load() {
+				wait = s.runDueCall(ctx)
+			}
+			timer.Reset(wait)
+		}
+	}()
+}
+
+// runDueCall places the earliest due call, if the user can take it, and
+// returns how long to wait before looking again.
+func (s *Service) runDueCall(ctx context.Context) time.Duration {
+	calls := s.ScheduledCalls()
+	if len(calls) == 0 {
+		return scheduleIdleWait
+	}
+	next := calls[0]
+	if wait := time.Until(next.At); wait > 0 {
+		return min(wait, scheduleIdleWait)
+	}
+	if s.dialBlocked(s.cfg.TGUserID) {
+		return scheduleBusyWait
+	}
+	call, err := s.prepareOutboundCall(s.cfg.TGUserID, next.Number, next.CallerID)
+	if errors.Is(err, ErrCallLimit) {
+		return scheduleBusyWait
+	}
+	if err == nil {
+		if !s.setScheduledCallID(next.ID, call.ID) {
+			// Cancelled meanwhile.
+			call.Hangup()
+		}
+		s.logger.Info("schedule: placing call", "id", next.ID, "number", next.Number, "bridge_call_id", call.ID, "attempt", next.Attempts+1)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Placing scheduled call to %s (%s)", next.Number, next.ID))
+		err = s.runOutboundCall(ctx, call)
+	}
+	if ctx.Err() != nil {
+		// Shutting down: the call stays queued for the next start.
+		s.setScheduledCallID(next.ID, "")
+		return scheduleIdleWait
+	}
+	s.finishScheduledCall(next.ID, err)
+	return 0
+}
+
+func (s *Service) setScheduledCallID(id, callID string) bool {
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	sc, ok := s.scheduled[id]
+	if ok {
+		sc.CallID = callID
+	}
+	return ok
+}
+
+// finishScheduledCall drops a placed call from the queue, or moves it to
+// its next attempt.
+func (s *Service) finishScheduledCall(id string, err error) {
+	s.scheduleMu.Lock()
+	sc, ok := s.scheduled[id]
+	if !ok {
+		s.scheduleMu.Unlock()
+		return
+	}
+	sc.CallID = ""
+	sc.Attempts++
+	retry := err != nil && sc.Attempts <= s.cfg.ScheduleRetries
+	if retry {
+		sc.At = time.Now().Add(s.cfg.ScheduleRetryInterval)
+		sc.LastError = err.Error()
+	} else {
+		delete(s.scheduled, id)
+	}
+	s.saveScheduledCall(id)
+	info := *sc
+	s.scheduleMu.Unlock()
+
+	switch {
+	case err == nil:
+		s.logger.Info("schedule: call done", "id", id)
+	case retry:
+		s.logger.Warn("schedule: call failed, retrying", "id", id, "error", err, "at", info.At)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Scheduled call to %s failed (%v), retrying at %s", info.Number, err, info.At.Format("15:04")))
+	default:
+		s.logger.Warn("schedule: call failed", "id", id, "error", err, "attempts", info.Attempts)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Scheduled call to %s failed after %d attempts: %v", info.Number, info.Attempts, err))
+	}
+}
+
+// loadScheduledCalls reads the queue from the state store. Calls in
+// progress when the bridge stopped are placed again.
+func (s *Service) loadScheduledCalls() {
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	err := s.state.ForEach(bucketSchedule, func(_ string, value json.RawMessage) error {
+		var sc ScheduledCall
+		if err := json.Unmarshal(value, &sc); err != nil {
+			return err
+		}
+		sc.CallID = ""
+		s.scheduled[sc.ID] = &sc
+		return nil
+	})
+	if err != nil {
+		s.logger.Warn("schedule: load failed", "error", err)
+	}
+	if len(s.scheduled) > 0 {
+		s.logger.Info("schedule: calls loaded", "count", len(s.scheduled))
+	}
+}
+
+// saveScheduledCall writes scheduled call id to the state store, or drops it
+// there once it left the queue. Called with scheduleMu held.
+func (s *Service) saveScheduledCall(id string) {
+	var err error
+	if sc, ok := s.scheduled[id]; ok {
+		err = s.state.Put(bucketSchedule, id, sc)
+	} else {
+		err = s.state.Delete(bucketSchedule, id)
+	}
+	if err != nil {
+		s.logger.Warn("schedule: save failed", "id", id, "error", err)
+	}
+}
@@ -0,0 +1,278 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+	"github.com/emiago/diago"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/recording"
+)
+
+// screenPrefix starts the callback data of the screening buttons:
+// "screen:<action>:<call id>".
+const screenPrefix = "screen:"
+
+// Screening actions.
+const (
+	screenAccept = "accept"
+	screenReject = "reject"
+)
+
+// screenedName is what a screened caller said when asked for their name.
+type screenedName struct {
+	// path is the recording (Ogg/Opus in a temporary file), "" when the
+	// caller said nothing.
+	path   string
+	length time.Duration
+	// text is its transcript, with asr.url.
+	text string
+}
+
+// loadScreening loads the screening prompt, or synthesizes screening.prompt.
+func (s *Service) loadScreening() error {
+	if !s.cfg.ScreeningEnabled {
+		return nil
+	}
+	var err error
+	if s.cfg.ScreeningPromptFile != "" {
+		if s.screeningPrompt, err = audiofile.Load(s.cfg.ScreeningPromptFile, s.cfg.SampleRate); err != nil {
+			return fmt.Errorf("screening.prompt_file: %w", err)
+		}
+	} else if s.screeningPrompt, err = s.synthesize(context.Background(), s.cfg.ScreeningPrompt); err != nil {
+		return fmt.Errorf("screening.prompt: %w", err)
+	}
+	s.screeningBeep = beepClip(s.tgFormat().SampleRate)
+	return nil
+}
+
+// screeningEnabled reports whether inbound calls are screened before
+// Telegram rings.
+func (s *Service) screeningEnabled() bool {
+	return s.cfg.ScreeningEnabled && s.tgClient != nil
+}
+
+// startScreening registers the Accept and Reject buttons of screened calls
+// (bot accounts only; user accounts get /answer and /decline instead).
+func (s *Service) startScreening(ctx context.Context) {
+	if !s.screeningEnabled() {
+		return
+	}
+	if me, err := s.tgClient.GetMe(); err == nil && me != nil && me.Bot {
+		s.screeningButtons = true
+		s.tgClient.On("callback:^"+screenPrefix, func(cb *tg.CallbackQuery) error {
+			return s.handleScreenButton(ctx, cb)
+		})
+	}
+}
+
+// askCallerName answers an inbound call with answer, asks the caller for
+// their name and records it after a beep, for up to screening.max_length,
+// until the caller presses # or, with asr.url, once they stop talking. ok
+// is false when the call ended first; its cause is set then.
+func (s *Service) askCallerName(dialog *diago.DialogServerSession, call *Call, answer diago.AnswerOptions, logger *slog.Logger) (name screenedName, ok bool) {
+	if !dialogAnswered(dialog) {
+		if err := dialog.AnswerOptions(answer); err != nil {
+			logger.Warn("screening: answer failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
+			return screenedName{}, false
+		}
+		s.setCallState(call, CallAnswered)
+		call.setSIPDialog(dialog)
+		logger.Info("screening: call answered")
+	}
+
+	if s.screeningPrompt != nil {
+		s.playAnnouncement(dialog, s.screeningPrompt, logger)
+	}
+	if dialog.Context().Err() != nil {
+		call.setCause(cdr.CauseCancelled)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (caller hung up during screening)", displayParty(call.Name, call.Number)))
+		return screenedName{}, false
+	}
+
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		logger.Warn("screening: sip media setup failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
+		return screenedName{}, false
+	}
+	call.setCodec(sipMedia.Codec.Name)
+
+	opts := s.voicemailOptions()
+	opts.Dir, opts.Format, opts.Key = os.TempDir(), recording.FormatOGG, nil
+	track, err := recording.StartTrack(opts, recording.Vars{
+		ID:        call.ID,
+		Direction: "screening",
+		Number:    call.Number,
+		ChatID:    call.ChatID,
+		StartedAt: call.StartedAt,
+	})
+	if err != nil {
+		// The user still gets the call, only without the name.
+		logger.Warn("screening: recording failed", "error", err)
+		return screenedName{}, true
+	}
+	ctx, cancel := context.WithCancel(dialog.Context())
+	defer cancel()
+	var (
+		rec   *recognizer
+		heard <-chan speechResult
+	)
+	if s.cfg.ASRURL != "" {
+		rec = s.startRecognizer(ctx, logger)
+		heard = rec.result
+	}
+	box := newLocalPort(s.tgFormat(), func(frame []byte) error {
+		if rec != nil {
+			rec.write(frame)
+		}
+		return track.Write(frame)
+	})
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, box, tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		logger.Warn("screening: bridge init failed", "error", err)
+		_ = track.Close()
+		_ = os.Remove(track.Path())
+		call.setCause(cdr.CauseMediaFailure)
+		return screenedName{}, false
+	}
+	bridge.OnDTMF(func(digit rune) {
+		if digit == voicemailEndDigit {
+			box.finish()
+		}
+	})
+	bridge.PlaySIP(s.screeningBeep.Once())
+	bridge.Start()
+	logger.Info("screening: recording name", "max_length", s.cfg.ScreeningMaxLength)
+
+	timer := time.NewTimer(s.cfg.ScreeningMaxLength + voicemailBeepDur)
+	defer timer.Stop()
+	ok = true
+	select {
+	case <-dialog.Context().Done():
+		ok = false
+	case <-call.Done():
+		ok = false
+	case <-box.Done():
+	case <-timer.C:
+	case res := <-heard:
+		if res.err == nil {
+			name.text = strings.TrimSpace(res.Text)
+		}
+	}
+	bridge.Stop()
+
+	closeErr := track.Close()
+	name.length = max(track.Duration()-voicemailBeepDur, 0)
+	if !ok {
+		_ = os.Remove(track.Path())
+		if call.ctx.Err() != nil {
+			call.setCause(cdr.CauseLocalHangup)
+			return screenedName{}, false
+		}
+		call.setCause(cdr.CauseCancelled)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (caller hung up during screening)", displayParty(call.Name, call.Number)))
+		return screenedName{}, false
+	}
+	if closeErr != nil || name.length < voicemailMinLength {
+		if closeErr != nil {
+			logger.Warn("screening: recording close failed", "error", closeErr)
+		}
+		_ = os.Remove(track.Path())
+		return name, true
+	}
+	name.path = track.Path()
+	logger.Info("screening: name recorded", "length", name.length.Round(time.Second), "text", name.text)
+	return name, true
+}
+
+// awaitScreenedDecision sends name to the Telegram user with buttons (or
+// commands) to accept or reject the call, and waits for their decision
+// like awaitInboundDecision.
+func (s *Service) awaitScreenedDecision(dialog *diago.DialogServerSession, call *Call, name screenedName, logger *slog.Logger) inboundDecision {
+	return s.waitInboundDecision(dialog, call, func() {
+		s.sendScreenedName(call, name, logger)
+	}, logger)
+}
+
+// sendScreenedName messages the recording of name (a text message when
+// there is none) and removes it.
+func (s *Service) sendScreenedName(call *Call, name screenedName, logger *slog.Logger) {
+	text := fmt.Sprintf("Incoming call from %s to %s", displayParty(call.Name, call.Number), call.Local)
+	if len(call.sipHeaders) > 0 {
+		text += "\n" + headerLines(call.sipHeaders)
+	}
+	switch {
+	case name.text != "":
+		text += fmt.Sprintf("\nCaller says: %q", name.text)
+	case name.path == "":
+		text += "\nThe caller did not say their name."
+	}
+	var markup tg.ReplyMarkup
+	if s.screeningButtons {
+		markup = tg.NewKeyboard().AddRow(
+			tg.Button.Data("Accept", screenPrefix+screenAccept+":"+call.ID),
+			tg.Button.Data("Reject", screenPrefix+screenReject+":"+call.ID),
+		).Build()
+	} else {
+		text += fmt.Sprintf("\nReply /answer or /decline (call %s)", call.ID)
+	}
+	if name.path == "" {
+		if _, err := s.tgClient.SendMessage(s.cfg.TGUserID, text, &tg.SendOptions{ReplyMarkup: markup, Silent: call.ring.Silent}); err != nil {
+			logger.Warn("screening: telegram message failed", "error", err)
+		}
+		return
+	}
+	defer os.Remove(name.path)
+	opts := &tg.MediaOptions{
+		FileName:    filepath.Base(name.path),
+		Caption:     text,
+		MimeType:    "audio/ogg",
+		ReplyMarkup: markup,
+		Silent:      call.ring.Silent,
+		Attributes: []tg.DocumentAttribute{&tg.DocumentAttributeAudio{
+			Voice:    true,
+			Duration: int32(name.length.Round(time.Second).Seconds()),
+		}},
+	}
+	if _, err := s.tgClient.SendMedia(s.cfg.TGUserID, name.path, opts); err != nil {
+		logger.Warn("screening: telegram upload failed", "file", name.path, "error", err)
+		s.notify(s.cfg.TGUserID, text)
+	}
+}
+
+// handleScreenButton accepts or rejects a screened call.
+func (s *Service) handleScreenButton(ctx context.Context, cb *tg.CallbackQuery) error {
+	if s.AuthorizeTelegram(ctx, cb.GetSenderID(), "").Action != AuthAllow {
+		return nil
+	}
+	action, id, _ := strings.Cut(strings.TrimPrefix(cb.DataString(), screenPrefix), ":")
+	call, ok := s.Call(id)
+	err := ErrNotPending
+	var answer string
+	switch {
+	case !ok:
+	case action == screenAccept:
+		err, answer = s.AnswerCall(call), "Connecting the call"
+	case action == screenReject:
+		err, answer = s.DeclineCall(call), "Call rejected"
+	default:
+		return nil
+	}
+	if err != nil {
+		answer = "The call is no longer waiting"
+	}
+	_, err = cb.Answer(answer)
+	return err
+}
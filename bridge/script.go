@@ -0,0 +1,348 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// Hooks a script.file may define. Each gets the call as a struct (id,
+// direction, number, name, local, chat_id, state, duration).
+const (
+	// hookInboundCall(call) runs before an inbound call is accepted; it may
+	// route, reject and set_codec.
+	hookInboundCall = "on_inbound_call"
+	// hookOutboundCall(call) runs before a number is dialed; route sets the
+	// number dialed instead.
+	hookOutboundCall = "on_outbound_call"
+	// hookDTMF(call, digit) runs for each digit of a bridged call; it may
+	// hangup.
+	hookDTMF = "on_dtmf"
+	// hookCallEnd(call, cause) runs once a call has ended.
+	hookCallEnd = "on_call_end"
+)
+
+// scriptMaxSteps bounds the work one hook may do, against endless loops.
+const scriptMaxSteps = 10_000_000
+
+// scriptDecisionKey is the thread-local slot of the running hook's decision.
+const scriptDecisionKey = "decision"
+
+// ErrScriptRejected is returned for outbound calls on_outbound_call rejected.
+var ErrScriptRejected = errors.New("call rejected by script")
+
+// script is a loaded script.file. Its globals are frozen, so hooks may run
+// concurrently.
+type script struct {
+	globals starlark.StringDict
+}
+
+// scriptDecision collects what a hook asked for through the script API.
+type scriptDecision struct {
+	hook string
+	// routeUser is the Telegram user an inbound call rings instead;
+	// routeNumber the number an outbound call dials instead.
+	routeUser   int64
+	routeNumber string
+	// reject is the SIP status an inbound call is rejected with (any value
+	// rejects an outbound call).
+	reject   int
+	reason   string
+	codecs   []string
+	hangup   bool
+	messages []scriptMessage
+}
+
+type scriptMessage struct {
+	chatID int64
+	text   string
+}
+
+// loadScript runs script.file once to define its hooks.
+func (s *Service) loadScript() error {
+	if s.cfg.ScriptFile == "" {
+		return nil
+	}
+	src, err := os.ReadFile(s.cfg.ScriptFile)
+	if err != nil {
+		return fmt.Errorf("script.file: %w", err)
+	}
+	thread := s.scriptThread("load", nil)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, s.cfg.ScriptFile, src, scriptBuiltins)
+	if err != nil {
+		return fmt.Errorf("script.file: %w", err)
+	}
+	var hooks []string
+	for _, hook := range []string{hookInboundCall, hookOutboundCall, hookDTMF, hookCallEnd} {
+		if _, ok := globals[hook].(starlark.Callable); ok {
+			hooks = append(hooks, hook)
+		}
+	}
+	globals.Freeze()
+	s.script = &script{globals: globals}
+	s.logger.Info("script loaded", "file", s.cfg.ScriptFile, "hooks", hooks)
+	return nil
+}
+
+func (s *Service) scriptThread(name string, d *scriptDecision) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.logger.Info("script: "+msg, "hook", name)
+		},
+	}
+	thread.SetLocal(scriptDecisionKey, d)
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	return thread
+}
+
+// hasHook reports whether the script defines hook.
+func (s *Service) hasHook(hook string) bool {
+	if s.script == nil {
+		return false
+	}
+	_, ok := s.script.globals[hook].(starlark.Callable)
+	return ok
+}
+
+// runHook calls hook with args and sends the messages it queued. ok is
+// false when the script does not define hook or it failed; the call then
+// goes on as without a script.
+func (s *Service) runHook(hook string, logger *slog.Logger, args ...starlark.Value) (*scriptDecision, bool) {
+	start := time.Now()
+	d, err := s.evalHook(hook, args...)
+	if d == nil {
+		return nil, false
+	}
+	for _, m := range d.messages {
+		chatID := m.chatID
+		if chatID == 0 {
+			chatID = s.cfg.TGUserID
+		}
+		go s.notify(chatID, m.text)
+	}
+	if err != nil {
+		logger.Warn("script: hook failed", "hook", hook, "error", err)
+		return nil, false
+	}
+	logger.Debug("script: hook done", "hook", hook, "took", time.Since(start))
+	return d, true
+}
+
+// evalHook calls hook with args and returns its decision without acting on
+// it, e.g. for /simulate. d is nil when the script does not define hook.
+func (s *Service) evalHook(hook string, args ...starlark.Value) (d *scriptDecision, err error) {
+	if !s.hasHook(hook) {
+		return nil, nil
+	}
+	d = &scriptDecision{hook: hook}
+	thread := s.scriptThread(hook, d)
+	timer := time.AfterFunc(s.cfg.ScriptTimeout, func() { thread.Cancel("timeout") })
+	defer timer.Stop()
+	if _, err := starlark.Call(thread, s.script.globals[hook], args, nil); err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			err = errors.New(evalErr.Backtrace())
+		}
+		return d, err
+	}
+	return d, nil
+}
+
+// scriptCall describes a call to a hook.
+func scriptCall(info CallInfo, local string) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("call"), starlark.StringDict{
+		"id":        starlark.String(info.ID),
+		"direction": starlark.String(info.Direction),
+		"number":    starlark.String(info.Number),
+		"name":      starlark.String(info.Name),
+		"local":     starlark.String(local),
+		"chat_id":   starlark.MakeInt64(info.ChatID),
+		"state":     starlark.String(info.State),
+		"duration":  starlark.Float(time.Since(info.StartedAt).Seconds()),
+	})
+}
+
+// scriptInbound runs on_inbound_call for a new inbound call.
+func (s *Service) scriptInbound(call *Call, logger *slog.Logger) (*scriptDecision, bool) {
+	return s.runHook(hookInboundCall, logger, scriptCall(call.Info(), call.Local))
+}
+
+// scriptDTMF runs on_dtmf for a digit of a bridged call; hooks of one call
+// run in order.
+func (s *Service) scriptDTMF(call *Call, digit rune, logger *slog.Logger) {
+	if !s.hasHook(hookDTMF) {
+		return
+	}
+	go func() {
+		call.scriptMu.Lock()
+		defer call.scriptMu.Unlock()
+		d, ok := s.runHook(hookDTMF, logger, scriptCall(call.Info(), call.Local), starlark.String(string(digit)))
+		if ok && d.hangup {
+			logger.Info("script: hanging up", "hook", hookDTMF)
+			call.setCause(cdr.CauseLocalHangup)
+			call.Hangup()
+		}
+	}()
+}
+
+// scriptCallEnd runs on_call_end for ended calls (an event handler).
+func (s *Service) scriptCallEnd(ev CallEvent) {
+	if ev.To != CallEnded {
+		return
+	}
+	info := ev.Call.Info()
+	go s.runHook(hookCallEnd, s.logger.With("bridge_call_id", info.ID), scriptCall(info, ev.Call.Local), starlark.String(ev.Cause))
+}
+
+// filterCodecs restricts codecs to names (case-insensitive), in the order
+// of names; all of them when names is empty or matches none.
+// telephone-event is kept so DTMF still works.
+func filterCodecs(codecs []media.Codec, names []string) []media.Codec {
+	if len(names) == 0 {
+		return codecs
+	}
+	var out []media.Codec
+	for _, name := range names {
+		for _, c := range codecs {
+			if strings.EqualFold(c.Name, name) && !slices.Contains(out, c) {
+				out = append(out, c)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return codecs
+	}
+	for _, c := range codecs {
+		if strings.EqualFold(c.Name, "telephone-event") && !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// scriptBuiltins is the API scripts can use besides print.
+var scriptBuiltins = starlark.StringDict{
+	"route":        starlark.NewBuiltin("route", scriptRoute),
+	"reject":       starlark.NewBuiltin("reject", scriptReject),
+	"set_codec":    starlark.NewBuiltin("set_codec", scriptSetCodec),
+	"send_message": starlark.NewBuiltin("send_message", scriptSendMessage),
+	"hangup":       starlark.NewBuiltin("hangup", scriptHangup),
+}
+
+// decisionFor returns the decision of the running hook, if b may be used
+// in it.
+func decisionFor(thread *starlark.Thread, b *starlark.Builtin, hooks ...string) (*scriptDecision, error) {
+	d, _ := thread.Local(scriptDecisionKey).(*scriptDecision)
+	if d == nil || (len(hooks) > 0 && !slices.Contains(hooks, d.hook)) {
+		return nil, fmt.Errorf("%s: only available in %s", b.Name(), strings.Join(hooks, ", "))
+	}
+	return d, nil
+}
+
+// route(user_id) rings another Telegram user (on_inbound_call);
+// route(number) dials another number (on_outbound_call).
+func scriptRoute(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	d, err := decisionFor(thread, b, hookInboundCall, hookOutboundCall)
+	if err != nil {
+		return nil, err
+	}
+	if d.hook == hookInboundCall {
+		var user int64
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &user); err != nil {
+			return nil, err
+		}
+		d.routeUser = user
+		return starlark.None, nil
+	}
+	var number string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &number); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(number) == "" {
+		return nil, fmt.Errorf("%s: empty number", b.Name())
+	}
+	d.routeNumber = number
+	return starlark.None, nil
+}
+
+// reject(status=403, reason="") refuses the call.
+func scriptReject(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	d, err := decisionFor(thread, b, hookInboundCall, hookOutboundCall)
+	if err != nil {
+		return nil, err
+	}
+	status, reason := 403, ""
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "status?", &status, "reason?", &reason); err != nil {
+		return nil, err
+	}
+	if status < 400 || status > 699 {
+		return nil, fmt.Errorf("%s: status %d is not a SIP failure (400-699)", b.Name(), status)
+	}
+	if reason == "" {
+		reason = "Rejected"
+	}
+	d.reject, d.reason = status, reason
+	return starlark.None, nil
+}
+
+// set_codec(name, ...) limits the SIP leg to these codecs, in this order.
+func scriptSetCodec(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	d, err := decisionFor(thread, b, hookInboundCall, hookOutboundCall)
+	if err != nil {
+		return nil, err
+	}
+	if len(kwargs) > 0 || len(args) == 0 {
+		return nil, fmt.Errorf("%s: want one or more codec names", b.Name())
+	}
+	d.codecs = d.codecs[:0]
+	for _, arg := range args {
+		name, ok := starlark.AsString(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s: codec name must be a string, got %s", b.Name(), arg.Type())
+		}
+		d.codecs = append(d.codecs, name)
+	}
+	return starlark.None, nil
+}
+
+// send_message(text, chat_id=telegram.user_id) sends a Telegram message.
+func scriptSendMessage(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	d, err := decisionFor(thread, b)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		text   string
+		chatID int64
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text, "chat_id?", &chatID); err != nil {
+		return nil, err
+	}
+	d.messages = append(d.messages, scriptMessage{chatID: chatID, text: text})
+	return starlark.None, nil
+}
+
+// hangup() ends the call (on_dtmf).
+func scriptHangup(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	d, err := decisionFor(thread, b, hookDTMF)
+	if err != nil {
+		return nil, err
+	}
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	d.hangup = true
+	return starlark.None, nil
+}
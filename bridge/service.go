@@ -1,6 +1,8 @@
 package bridge
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -16,6 +18,7 @@ import (
 	"gotgcalls/third_party/ubot"
 
 	"github.com/Laky-64/gologging"
+	tg "github.com/amarnathcjd/gogram/telegram"
 	"github.com/emiago/diago"
 	"github.com/emiago/diago/media"
 	"github.com/emiago/diago/media/sdp"
@@ -23,19 +26,160 @@ import (
 	"github.com/emiago/sipgo/sip"
 	msdk "github.com/livekit/media-sdk"
 
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
 	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/export"
+	"gotgcalls/bridge/ical"
+	"gotgcalls/bridge/logring"
 	"gotgcalls/bridge/pcm"
+	"gotgcalls/bridge/plugins"
+	"gotgcalls/bridge/storage"
+	"gotgcalls/bridge/store"
+	"gotgcalls/bridge/webhook"
 )
 
 type Service struct {
-	cfg         Config
-	sip         *diago.Diago
-	tg          *ubot.Context
-	logger      *slog.Logger
-	mu          sync.Mutex
-	tgSessions  map[int64]*endpoints.TgEndpoint
-	activeCalls atomic.Int64
-	authServer  *diago.DigestAuthServer
+	cfg        Config
+	sip        atomic.Pointer[diago.Diago]
+	tg         *ubot.Context
+	tgClient   *tg.Client
+	logger     *slog.Logger
+	mu         sync.Mutex
+	tgSessions map[int64]*endpoints.TgEndpoint
+	// videoBridges are the video.enabled bridges by Telegram chat.
+	videoBridges map[int64]*videoBridge
+	calls        map[string]*Call
+	activeCalls  atomic.Int64
+	draining     atomic.Bool
+	authServer   *diago.DigestAuthServer
+	reg          registrar
+	cdr          *cdr.Recorder
+	startedAt    time.Time
+	// groupJoinMu serializes joining voice chats so concurrent invites share one session.
+	groupJoinMu sync.Mutex
+
+	// ringback and holdMusic are loaded from audio.ringback_file and
+	// audio.hold_music_file; nil means silence.
+	ringback  *audiofile.Clip
+	holdMusic *audiofile.Clip
+	// recordingNotice is played to the SIP party when recording starts;
+	// recordingKey encrypts recordings. Both are optional.
+	recordingNotice *audiofile.Clip
+	recordingKey    []byte
+	// announcements are played to rejected callers, by Announce* key.
+	announcements map[string]*audiofile.Clip
+	// voicemailGreeting (optional) and voicemailBeep are played before a
+	// voicemail message is recorded.
+	voicemailGreeting *audiofile.Clip
+	voicemailBeep     *audiofile.Clip
+	// screeningPrompt and screeningBeep ask a screened caller for their
+	// name.
+	screeningPrompt *audiofile.Clip
+	screeningBeep   *audiofile.Clip
+	// ivrPrompts are the IVR clips by configured path.
+	ivrPrompts map[string]*audiofile.Clip
+	// directoryPrompt asks for a name; directoryNames are the spoken names
+	// of the directory entries by user ID.
+	directoryPrompt *audiofile.Clip
+	directoryNames  map[int64]*audiofile.Clip
+	// echoGreeting is played when an echo call starts (optional).
+	echoGreeting *audiofile.Clip
+	// infoLines are the loaded info_lines by configured number.
+	infoLines map[string]*infoLine
+	// script holds the hooks of script.file; nil without one.
+	script *script
+	// plugins are the loaded Go plugins, in plugins order.
+	plugins []plugins.Plugin
+
+	storage *storage.Manager
+
+	// configPath is re-read by Reload; tunables are the reloadable settings
+	// and take precedence over their counterparts in cfg.
+	configPath string
+	tunablesMu sync.RWMutex
+	tunables   Tunables
+
+	// Voice chat auto-join rules by chat ID, and the voice chat (group call)
+	// ID each rule last dialed into.
+	autoJoinMu    sync.Mutex
+	autoJoinRules map[int64]VoiceChatAutoJoin
+	autoJoined    map[int64]int64
+	autoJoinWake  chan struct{}
+
+	exporters export.Set
+
+	// events carries call state transitions; see EventBus.
+	events *EventBus
+
+	// Cached Telegram contacts by phone number, for caller ID.
+	contactsMu   sync.Mutex
+	tgContacts   map[string]string
+	tgContactsAt time.Time
+
+	// Caller rules added at runtime, on top of callers.allow/deny.
+	callersMu    sync.Mutex
+	callersAllow []string
+	callersDeny  []string
+
+	// postJobs feeds the postprocess worker; nil when it is disabled.
+	postJobs chan postJob
+
+	// debugDump dumps the media of new calls (debug.rtp_dump).
+	debugDump atomic.Bool
+	// rtpEvents logs the RTP events of new calls (debug.rtp_events).
+	rtpEvents atomic.Bool
+	// logRing holds the last log lines for crash reports (SetLogRing).
+	logRing *logring.Ring
+	crashMu sync.Mutex
+
+	// calendarEvents are the busy events of calendar.url around now.
+	calendarMu     sync.Mutex
+	calendarEvents []ical.Event
+
+	// bulkJobs are the running and recent bulk dial jobs by ID.
+	bulkMu   sync.Mutex
+	bulkJobs map[string]*bulkJob
+
+	// state keeps operational data across restarts (SetStateStore).
+	state *store.DB
+
+	// scheduled are the queued calls by ID, also kept in state;
+	// scheduleWake tells the scheduler about a new one.
+	scheduleMu   sync.Mutex
+	scheduled    map[string]*ScheduledCall
+	scheduleWake chan struct{}
+
+	// sipSettings are the transport settings the SIP stack runs with.
+	// sipRestart hands RestartSIP requests to the serve loop, which closes
+	// sipDone when it returns.
+	sipSettings atomic.Pointer[sipStackSettings]
+	sipRestart  chan sipRestartRequest
+	sipServing  atomic.Bool
+	sipDone     chan struct{}
+
+	// webhooks (optional) receives events for webhooks.endpoints.
+	webhooks *webhook.Sender
+
+	// loopToken marks the outbound INVITEs of this bridge, see loopHeaders.
+	loopToken string
+
+	// hooks authorize and route calls for a program embedding the bridge.
+	hooks CallHooks
+	// authorizer decides which SIP and Telegram calls get through.
+	authorizer CallAuthorizer
+
+	// summaryButtons is set when call summaries carry buttons (bot
+	// accounts only).
+	summaryButtons bool
+	// screeningButtons is set when screened calls are accepted or rejected
+	// with buttons (bot accounts only).
+	screeningButtons bool
+
+	// bindings are the phones registered to local SIP accounts, by
+	// username.
+	bindingsMu sync.Mutex
+	bindings   map[string][]sipBinding
 }
 
 func NewService(cfg Config, sip *diago.Diago, tg *ubot.Context, logger *slog.Logger) *Service {
@@ -46,33 +190,116 @@ func NewService(cfg Config, sip *diago.Diago, tg *ubot.Context, logger *slog.Log
 	gologging.GetLogger("ntgcalls").SetLevel(gologging.FatalLevel)
 
 	var authServer *diago.DigestAuthServer
-	if cfg.SIPAuthUser != "" && cfg.SIPAuthPass != "" {
+	if (cfg.SIPAuthUser != "" && cfg.SIPAuthPass != "") || len(cfg.SIPAccounts) > 0 {
 		authServer = diago.NewDigestServer()
 	}
-	return &Service{
-		cfg:        cfg,
-		sip:        sip,
-		tg:         tg,
-		logger:     logger,
-		tgSessions: map[int64]*endpoints.TgEndpoint{},
-		authServer: authServer,
+	autoJoinRules := map[int64]VoiceChatAutoJoin{}
+	for _, rule := range cfg.VoiceChatAutoJoin {
+		autoJoinRules[rule.ChatID] = rule
+	}
+	s := &Service{
+		cfg:          cfg,
+		tg:           tg,
+		logger:       logger,
+		tgSessions:   map[int64]*endpoints.TgEndpoint{},
+		videoBridges: map[int64]*videoBridge{},
+		calls:        map[string]*Call{},
+		bulkJobs:     map[string]*bulkJob{},
+		scheduled:    map[string]*ScheduledCall{},
+		state:        store.Memory(),
+		scheduleWake: make(chan struct{}, 1),
+		sipRestart:   make(chan sipRestartRequest),
+		sipDone:      make(chan struct{}),
+		authServer:   authServer,
+		startedAt:    time.Now(),
+
+		autoJoinRules: autoJoinRules,
+		autoJoined:    map[int64]int64{},
+		autoJoinWake:  make(chan struct{}, 1),
+
+		events: newEventBus(),
+
+		storage: newStorageManager(cfg, logger),
+
+		tunables: cfg.Tunables(),
+
+		loopToken: newCallID(),
+
+		bindings: map[string][]sipBinding{},
+	}
+	s.sip.Store(sip)
+	settings := cfg.sipStackSettings()
+	s.sipSettings.Store(&settings)
+	s.debugDump.Store(cfg.RTPDumpEnabled)
+	s.rtpEvents.Store(cfg.RTPEventsEnabled)
+	s.events.Subscribe(s.emitCDR)
+	authorizer, err := newConfigAuthorizer(cfg)
+	if err != nil {
+		logger.Error("call authorizer setup failed, using the default", "error", err)
+		authorizer = defaultAuthorizer{userID: cfg.TGUserID}
 	}
+	s.authorizer = authorizer
+	return s
 }
 
 func (s *Service) Start(ctx context.Context) error {
+	if err := s.loadAudioFiles(); err != nil {
+		return err
+	}
+	if err := s.loadScript(); err != nil {
+		return err
+	}
+	if s.hasHook(hookCallEnd) {
+		s.events.Subscribe(s.scriptCallEnd)
+	}
+	if err := s.loadPlugins(); err != nil {
+		return err
+	}
+	key, err := RecordingKey(s.cfg)
+	if err != nil {
+		return err
+	}
+	s.recordingKey = key
 	s.tg.OnIncomingCall(func(_ *ubot.Context, chatID int64) {
 		go s.handleIncomingTG(ctx, chatID)
 	})
 	s.tg.OnFrame(s.handleTGFrame)
 	s.tg.OnStreamEnd(s.handleTGStreamEnd)
 	s.tg.OnCallDisconnect(s.handleTGCallDisconnect)
-
-	return s.sip.Serve(ctx, func(inDialog *diago.DialogServerSession) {
-		s.handleIncomingSIP(inDialog)
+	s.tg.OnParticipantUpdate(s.handleParticipantUpdate)
+	s.tg.SetStrictProtocol(s.cfg.TGProtocolCheck == ProtocolCheckStrict)
+	s.tg.OnProtocolMismatch(func(chatID int64, err *ubot.ProtocolError) {
+		s.logger.Warn("tg peer protocol mismatch", "chat_id", chatID, "error", err, "strict", s.cfg.TGProtocolCheck == ProtocolCheckStrict)
 	})
+	if s.tgClient != nil {
+		go s.runAutoJoin(ctx)
+	}
+	if s.webhooks != nil {
+		s.events.Subscribe(s.webhookCallEvent)
+	}
+	if len(s.exporters) > 0 {
+		go s.runMetricsExport(ctx)
+		s.events.Subscribe(s.exportCallEvent)
+	}
+	s.startStorageGuard(ctx)
+	s.startPostProcessor(ctx)
+	s.startExternalIPDetection(ctx)
+	s.startRegistration(ctx)
+	s.startCalendar(ctx)
+	s.startCallFiles(ctx)
+	s.startScheduler(ctx)
+	s.startCallSummaries(ctx)
+	s.startScreening(ctx)
+	s.startRegistrar(ctx)
+	if s.cfg.TestCallInterval > 0 {
+		go s.runTestCalls(ctx)
+	}
+
+	return s.serveSIP(ctx)
 }
 
 func (s *Service) handleIncomingSIP(inDialog *diago.DialogServerSession) {
+	defer s.recoverCrash("sip call handler")
 	callStart := time.Now()
 	callLogger := s.logger.With(
 		"call_id", sipCallID(inDialog),
@@ -94,28 +321,96 @@ func (s *Service) handleIncomingSIP(inDialog *diago.DialogServerSession) {
 		"contact", inDialog.InviteRequest.Contact().Value(),
 	)
 
+	call := newCall(CallInbound, inDialog.FromUser(), s.cfg.TGUserID)
+	call.Local = inDialog.ToUser()
+	if chatID := lookupPhone(s.cfg.VoiceChats, call.Local); chatID != 0 {
+		// The called number is a voice chat: the caller joins it.
+		call.setChatID(chatID)
+	}
+	displayName := ""
+	if from := inDialog.InviteRequest.From(); from != nil {
+		displayName = from.DisplayName
+	}
+	call.Name = s.callerName(call.Number, displayName)
+	if acc, ok := s.sipAccount(call.Number); ok && call.Name == "" {
+		call.Name = acc.Name
+	}
+	call.ring = s.ringProfile(call.Number)
+	call.ringing = s.ringing(call.Local, call.ring)
+	call.setSIPCallID(sipCallID(inDialog))
+	call.sipHeaders = captureHeaders(inDialog.InviteRequest, s.cfg.SIPCaptureHeaders)
+	// Deferred first so rejected calls still produce a CDR. The call slot
+	// is released only after the CDR is emitted, so Drain waits for it.
+	counted := false
+	defer func() {
+		s.unregisterCall(call)
+		if counted {
+			s.activeCalls.Add(-1)
+		}
+	}()
+	callLogger = callLogger.With("bridge_call_id", call.ID)
+
 	if err := s.authorizeInboundSIP(inDialog, callLogger); err != nil {
 		callLogger.Info("sip: call rejected (auth failed)")
+		call.setCause(cdr.CauseAuthFailed)
 		return
 	}
-	if !s.allowCall(callLogger) {
-		callLogger.Info("sip: call rejected (busy)")
-		_ = inDialog.Respond(sip.StatusBusyHere, "Busy", nil)
+	if s.rejectLoop(inDialog, call, callLogger) {
 		return
 	}
-	defer s.activeCalls.Add(-1)
+	if s.authorizeInbound(inDialog, call, callLogger) {
+		return
+	}
+	if reason := s.screenCaller(call.Number); reason != "" && !call.ring.SkipScreening {
+		status := s.Tunables().CallersRejectStatus
+		callLogger.Info("sip: call rejected (caller blocked)", "reason", reason, "status", status)
+		call.setCause(cdr.CauseBlocked)
+		s.rejectInbound(inDialog, status, callerRejectReasons[status], AnnounceBlocked, false, callLogger)
+		return
+	}
+	if d, ok := s.scriptInbound(call, callLogger); ok {
+		if d.reject != 0 {
+			callLogger.Info("sip: call rejected (script)", "status", d.reject, "reason", d.reason)
+			call.setCause(cdr.CauseScriptRejected)
+			s.rejectInbound(inDialog, d.reject, d.reason, "", false, callLogger)
+			return
+		}
+		if d.routeUser != 0 {
+			callLogger.Info("sip: call routed by script", "tg_chat_id", d.routeUser)
+			call.setChatID(d.routeUser)
+		}
+		call.codecs = d.codecs
+	}
+	if s.hookInbound(inDialog, call, callLogger) {
+		return
+	}
+	if s.draining.Load() {
+		callLogger.Info("sip: call rejected (shutting down)")
+		call.setCause(cdr.CauseShuttingDown)
+		s.rejectInbound(inDialog, sip.StatusServiceUnavailable, "Shutting down", AnnounceShuttingDown, false, callLogger)
+		return
+	}
+	// A waiting call puts the current one on hold, so it doesn't need a
+	// slot of its own.
+	if !s.allowCall(callLogger) {
+		if s.callWaitingFor(call) == nil {
+			callLogger.Info("sip: call rejected (busy)")
+			call.setCause(cdr.CauseBusy)
+			s.rejectInbound(inDialog, sip.StatusBusyHere, "Busy", AnnounceBusy, false, callLogger)
+			return
+		}
+		s.activeCalls.Add(1)
+	}
+	counted = true
 	defer inDialog.Close()
+	s.registerCall(call)
 
 	// Monitor SIP caller hangup during setup
-	sipHangupCh := make(chan struct{})
 	go func() {
 		<-inDialog.Context().Done()
-		close(sipHangupCh)
 		callLogger.Info("sip: caller context done (hangup or cancel)", "reason", inDialog.Context().Err())
 	}()
 
-	chatID := s.cfg.TGUserID
-
 	callLogger.Info("sip: sending trying")
 	if err := inDialog.Trying(); err != nil {
 		callLogger.Error("sip trying failed", "error", err)
@@ -123,66 +418,257 @@ func (s *Service) handleIncomingSIP(inDialog *diago.DialogServerSession) {
 		callLogger.Info("sip: trying sent ok")
 	}
 	callLogger.Info("sip: sending ringing")
-	if err := inDialog.Ringing(); err != nil {
+	if err := sendRinging(inDialog, call.ringing); err != nil {
 		callLogger.Error("sip ringing failed", "error", err)
 	} else {
 		callLogger.Info("sip: ringing sent ok")
 	}
 
-	callCtx, cancel := context.WithTimeout(inDialog.Context(), s.cfg.EstablishTimeout)
-	defer cancel()
-
 	if err := s.validateSDPPolicy(inDialog.InviteRequest.Body()); err != nil {
 		callLogger.Warn("sip sdp policy rejected", "error", err)
+		call.setCause(cdr.CauseIncompatibleSDP)
 		_ = inDialog.Respond(sip.StatusNotAcceptableHere, "Unsupported SDP", nil)
 		return
 	}
 	logSDPAudioCodecs(callLogger, "remote offer", inDialog.InviteRequest.Body())
 
-	callLogger.Info("sip: starting telegram call setup")
-	tgSession, err := s.startTGCall(callCtx, chatID)
-	if err != nil {
-		// Check if caller hung up during TG setup
-		select {
-		case <-sipHangupCh:
-			callLogger.Warn("tg setup aborted: sip caller hung up during setup", "chat_id", chatID, "error", err)
-		default:
-			callLogger.Warn("tg setup failed", "chat_id", chatID, "error", err)
-		}
-		callLogger.Warn("sip: SENDING 480 NOW")
-		_ = inDialog.Respond(sip.StatusTemporarilyUnavailable, "Telegram unavailable", nil)
+	localPrefs := filterCodecs(s.sipCodecs(), call.codecs)
+	logCodecPrefs(callLogger, "local codec preferences", localPrefs)
+	answer := diago.AnswerOptions{
+		Codecs: localPrefs,
+		RTPNAT: s.rtpNAT(),
+		OnMediaUpdate: func(*diago.DialogMedia) {
+			// Runs with the dialog locked; apply the update asynchronously.
+			go s.handleSIPMediaUpdate(call, inDialog, callLogger)
+		},
+		OnRefer:        s.onTransferred(call, callLogger),
+		ReferInvite:    s.referInvite(call, callLogger),
+		ReInviteCodecs: s.reInviteCodecs(),
+		Video:          s.videoMode(call.ChatID),
+	}
+
+	if s.isEchoExtension(call.Local) {
+		s.runSIPEcho(inDialog, call, answer, callLogger)
+		return
+	}
+	if line, ok := s.infoLine(call.Local); ok {
+		callLogger.Info("sip: call to info line")
+		s.runInfoLine(inDialog, call, answer, line, callLogger)
+		return
+	}
+	if until, summary, busy := s.calendarBusy(time.Now()); busy && !call.ring.SkipScreening {
+		s.routeBusyCalendar(inDialog, call, until, summary, callLogger)
+		return
+	}
+	if s.tgChatBusy(call.ChatID) && len(lookupPhone(s.cfg.FollowMe, call.Local)) == 0 && s.callWaitingFor(call) == nil {
+		s.routeTGBusy(inDialog, call, false, callLogger)
 		return
 	}
-	defer tgSession.Close()
-	callLogger.Info("sip: telegram call ready")
 
-	localPrefs := s.sipCodecs()
-	logCodecPrefs(callLogger, "local codec preferences", localPrefs)
+	// The IVR answers first and decides whether Telegram rings at all.
+	answered := false
+	if s.cfg.IVRMenus != nil || s.cfg.IVRWebhookURL != "" {
+		action, ok := s.runIVR(inDialog, call, answer, callLogger)
+		if !ok {
+			return
+		}
+		switch action {
+		case IVRVoicemail:
+			s.takeVoicemail(inDialog, call, callLogger)
+			return
+		case IVRHangup:
+			call.setCause(cdr.CauseIVRHangup)
+			hangupDialog(inDialog, callLogger)
+			return
+		}
+		answered = true
+		s.startVideoClip(inDialog, call, callLogger)
+	}
+
+	if call.ring.AutoAnswer && !answered {
+		callLogger.Info("sip: auto-answering call (ring profile)")
+		if err := inDialog.AnswerOptions(answer); err != nil {
+			callLogger.Warn("sip answer failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
+			return
+		}
+		answered = true
+		s.startVideoClip(inDialog, call, callLogger)
+	}
 
-	if s.cfg.EnableEarlyMedia {
-		callLogger.Info("sip: sending early media (183)")
-		if err := inDialog.ProgressMediaOptions(diago.ProgressMediaOptions{Codecs: localPrefs}); err != nil {
-			callLogger.Warn("sip early media failed", "error", err)
+	// Screening answers the call to ask for the caller's name, which the
+	// user then hears before deciding like with call.confirm_inbound.
+	var screened *screenedName
+	if s.screeningEnabled() && !call.ring.SkipScreening && s.callWaitingFor(call) == nil {
+		name, ok := s.askCallerName(inDialog, call, answer, callLogger)
+		if !ok {
+			return
+		}
+		if !answered {
+			s.startVideoClip(inDialog, call, callLogger)
+		}
+		answered = true
+		screened = &name
+	}
+
+	// With a ringback file, open early media now so the caller hears it while
+	// Telegram rings instead of silence (after the IVR, the call is already
+	// answered and the ringback simply plays in it).
+	earlyMediaSent := false
+	stopRingback := func() {}
+	if s.ringback != nil && (answered || s.Tunables().EnableEarlyMedia) {
+		if !answered {
+			callLogger.Info("sip: sending early media (183) for ringback")
+			if err := inDialog.ProgressMediaOptions(diago.ProgressMediaOptions{Codecs: localPrefs, RTPNAT: s.rtpNAT(), Video: s.videoMode(call.ChatID)}); err != nil {
+				callLogger.Warn("sip early media failed", "error", err)
+				call.setCause(cdr.CauseSIPFailure)
+				return
+			}
+			earlyMediaSent = true
+			s.startVideoClip(inDialog, call, callLogger)
+		}
+		if stop, err := s.playRingback(inDialog, callLogger); err != nil {
+			callLogger.Warn("ringback failed", "error", err)
+		} else {
+			stopRingback = stop
+		}
+	}
+
+	// A waiting call is confirmed with /answer below anyway.
+	if screened != nil || s.cfg.ConfirmInbound && !call.ring.SkipScreening && s.callWaitingFor(call) == nil {
+		var decision inboundDecision
+		if screened != nil {
+			decision = s.awaitScreenedDecision(inDialog, call, *screened, callLogger)
+		} else {
+			decision = s.awaitInboundDecision(inDialog, call, inboundNotice(call), callLogger)
+		}
+		switch decision {
+		case decisionDecline:
+			callLogger.Info("sip: call declined by telegram user")
+			stopRingback()
+			call.setCause(cdr.CauseDeclined)
+			s.rejectInbound(inDialog, sip.StatusGlobalDecline, "Decline", AnnounceDeclined, earlyMediaSent, callLogger)
+			return
+		case decisionTimeout:
+			callLogger.Info("sip: call not answered by telegram user")
+			stopRingback()
+			if s.voicemailEnabled() {
+				s.takeVoicemail(inDialog, call, callLogger)
+				return
+			}
+			call.setCause(cdr.CauseNoAnswer)
+			status, reason := ringTimeoutStatus(call.ringing, sip.StatusBusyHere, "Busy")
+			s.rejectInbound(inDialog, status, reason, AnnounceNoAnswer, earlyMediaSent, callLogger)
+			return
+		case decisionCancelled:
+			stopRingback()
+			call.setCause(cdr.CauseCancelled)
 			return
 		}
+	} else if notice := ringNotice(call); notice != "" || call.ring.Sound != "" {
+		s.announceRing(call, notice, callLogger)
 	}
 
-	callLogger.Info("sip: answering call (200 OK)")
-	if err := inDialog.AnswerOptions(diago.AnswerOptions{Codecs: localPrefs}); err != nil {
-		callLogger.Warn("sip answer failed", "error", err)
+	// A follow-me chain of the called number replaces ringing Telegram alone.
+	var tgSession tgLeg
+	// toHold is the call put on hold for this one (call waiting).
+	var toHold *Call
+	if chain := lookupPhone(s.cfg.FollowMe, call.Local); len(chain) > 0 {
+		var ok bool
+		if tgSession, ok = s.followMe(inDialog, call, chain, localPrefs, earlyMediaSent, stopRingback, callLogger); !ok {
+			return
+		}
+	} else if active := s.callWaitingFor(call); active != nil && s.awaitCallWaiting(inDialog, call, active, earlyMediaSent, stopRingback, callLogger) {
+		// The waiting call starts on hold and swaps places with the active
+		// one once bridged.
+		callLogger.Info("sip: taking waiting call", "held_call_id", active.ID)
+		tgSession = s.newHoldPort()
+		toHold = active
+	} else if active != nil {
 		return
+	} else {
+		ringTimeout := cmp.Or(call.ringing.Timeout, s.Tunables().EstablishTimeout)
+		callCtx, cancel := context.WithTimeout(inDialog.Context(), ringTimeout)
+		defer cancel()
+
+		// The directory or a script may have picked another chat.
+		chatID := call.ChatID
+		callLogger.Info("sip: starting telegram call setup", "chat_id", chatID)
+		s.setCallState(call, CallConnectingTG)
+		var err error
+		tgSession, err = s.openTGLeg(callCtx, chatID, s.cfg.VideoEnabled && offersVideo(inDialog.InviteRequest.Body()))
+		stopRingback()
+		if err != nil {
+			// Check if caller hung up during TG setup; the setup is aborted as
+			// soon as the dialog context ends, possibly before the monitor above
+			// logged it.
+			select {
+			case <-inDialog.Context().Done():
+				// The server transaction already answered the CANCEL with 487.
+				callLogger.Warn("tg setup aborted: sip caller hung up during setup", "chat_id", chatID, "error", err)
+				call.setCause(cdr.CauseCancelled)
+				return
+			default:
+				if errors.Is(err, ErrTGBusy) {
+					// Another call took the chat while this one rang.
+					s.routeTGBusy(inDialog, call, earlyMediaSent, callLogger)
+					return
+				}
+				callLogger.Warn("tg setup failed", "chat_id", chatID, "error", err)
+				if s.voicemailEnabled() {
+					s.takeVoicemail(inDialog, call, callLogger)
+					return
+				}
+				call.setCause(tgFailureCause(err))
+			}
+			status, reason := sip.StatusTemporarilyUnavailable, "Telegram unavailable"
+			var protoErr *ubot.ProtocolError
+			if errors.As(err, &protoErr) && protoErr.TooOld {
+				reason = "Telegram client too old"
+			}
+			if errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+				// Rang out without an answer.
+				status, reason = ringTimeoutStatus(call.ringing, status, reason)
+			}
+			callLogger.Warn("sip: rejecting call", "status", status)
+			s.rejectInbound(inDialog, status, reason, AnnounceUnavailable, earlyMediaSent, callLogger)
+			return
+		}
+	}
+	call.setTGLeg(tgSession)
+	defer s.releaseTGLeg(call)
+	callLogger.Info("sip: telegram call ready")
+
+	if !answered {
+		if s.Tunables().EnableEarlyMedia && !earlyMediaSent {
+			callLogger.Info("sip: sending early media (183)")
+			if err := inDialog.ProgressMediaOptions(diago.ProgressMediaOptions{Codecs: localPrefs, RTPNAT: s.rtpNAT(), Video: s.videoMode(call.ChatID)}); err != nil {
+				callLogger.Warn("sip early media failed", "error", err)
+				call.setCause(cdr.CauseSIPFailure)
+				return
+			}
+		}
+
+		callLogger.Info("sip: answering call (200 OK)")
+		if err := inDialog.AnswerOptions(answer); err != nil {
+			callLogger.Warn("sip answer failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
+			return
+		}
 	}
+	s.setCallState(call, CallAnswered)
+	call.setSIPDialog(inDialog)
+	s.startVideoClip(inDialog, call, callLogger)
 	callLogger.Info("sip: call answered, setting up media")
 
-	sipMedia, err := endpoints.NewSipEndpoint(inDialog, endpoints.SIPMediaConfig{
-		JitterMinPackets: s.cfg.JitterMinPackets,
-		FrameDuration:    s.cfg.FrameDuration,
-	})
+	sipMedia, err := endpoints.NewSipEndpoint(inDialog, s.sipMediaConfig())
 	if err != nil {
 		callLogger.Warn("sip media setup failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
 		return
 	}
 	defer sipMedia.Close()
+	call.setCodec(sipMedia.Codec.Name)
 	callLogger.Info("sip: codec negotiated",
 		"codec", sipMedia.Codec.Name,
 		"payload_type", sipMedia.Codec.PayloadType,
@@ -190,87 +676,279 @@ func (s *Service) handleIncomingSIP(inDialog *diago.DialogServerSession) {
 		"rtp_clock_rate", sipMedia.RTPClockRate,
 	)
 
-	if s.cfg.EnableDTMF {
-		s.startDTMFListener(inDialog.Context(), inDialog.Media(), callLogger)
-	}
-
+	tunables := s.Tunables()
 	bridge, err := NewMediaBridge(
-		inDialog.Context(),
+		call.ctx,
 		callLogger,
 		sipMedia,
 		tgSession,
-		s.cfg.DriftTargetFrames,
-		s.cfg.DriftMaxBurst,
+		tunables.DriftTargetFrames,
+		tunables.DriftMaxBurst,
 	)
 	if err != nil {
 		callLogger.Warn("bridge init failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
 		return
 	}
+	s.attachDTMF(bridge, call, callLogger)
+	s.attachCrashReports(bridge, call)
+	s.attachStages(bridge, call, callLogger)
+	bridge.SetAdaptivePlayout(tunables.JitterRTCPAdapt)
+	s.attachHoldMusic(bridge)
+	s.attachNoiseSuppression(bridge)
+	s.attachAGC(bridge)
+	s.attachEchoProtection(bridge)
+	bridge.SetLevelLog(s.cfg.LevelLogInterval)
+	s.attachPreRoll(bridge)
+	s.attachLatencyProbes(bridge)
 	bridge.Start()
 	defer bridge.Stop()
+	call.setMedia(bridge)
+	s.setCallState(call, CallBridged)
+	s.setHoldState(call, bridge.OnHold())
+	if toHold != nil {
+		if err := s.swapTG(toHold, call); err != nil {
+			// The held call may have ended meanwhile.
+			callLogger.Warn("call waiting: swap failed", "held_call_id", toHold.ID, "error", err)
+			call.setCause(cdr.CauseMediaFailure)
+			return
+		}
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("On hold: %s. Reply /swap to switch back.", displayParty(toHold.Name, toHold.Number)))
+	}
+	s.autoRecord(call, callLogger)
+	s.autoDump(call, callLogger)
+	s.autoRTPLog(call, callLogger)
+	s.autoTranscribe(call, callLogger)
+	defer s.startVideoBridge(inDialog, call, callLogger)()
+	go s.keepDialogAlive(call, callLogger)
 
 	callLogger.Info("sip: call in progress (media bridged)")
 
 	select {
-	case <-inDialog.Context().Done():
+	case <-call.sipDone():
 		callLogger.Info("sip: call ended - caller hung up", "duration", time.Since(callStart).Round(time.Millisecond))
-	case <-tgSession.Done():
+		call.setCause(call.sipHangupCause())
+	case <-call.tgDone():
 		callLogger.Info("sip: call ended - telegram side ended", "duration", time.Since(callStart).Round(time.Millisecond))
+		call.setCause(cdr.CauseTelegramHangup)
+	case <-call.Done():
+		callLogger.Info("sip: call ended - hangup requested", "duration", time.Since(callStart).Round(time.Millisecond))
+		call.setCause(cdr.CauseLocalHangup)
 	}
 }
 
 func (s *Service) handleIncomingTG(ctx context.Context, chatID int64) {
 	callLogger := s.logger.With("tg_chat_id", chatID)
-	if chatID != s.cfg.TGUserID {
-		callLogger.Warn("tg call rejected (unexpected user)")
-		_ = s.tg.Stop(chatID)
-		return
-	}
-	callLogger.Warn("tg call rejected (use /call command)")
+	d := s.AuthorizeTelegram(ctx, chatID, "")
 	_ = s.tg.Stop(chatID)
+	switch d.Action {
+	case AuthReject:
+		callLogger.Warn("tg call rejected (not authorized)", "reason", d.Reason)
+	case AuthRedirect:
+		// Incoming Telegram calls aren't bridged; call the user back
+		// instead, connected to the target.
+		callLogger.Info("tg call redirected, calling back", "target", d.Target)
+		if s.tgChatBusy(chatID) {
+			return
+		}
+		call, err := s.prepareOutboundCall(chatID, d.Target, "")
+		if err == nil {
+			err = s.runOutboundCall(ctx, call)
+		}
+		if err != nil {
+			callLogger.Warn("tg call redirect failed", "target", d.Target, "error", err)
+		}
+	default:
+		callLogger.Warn("tg call rejected (use /call command)")
+	}
 }
 
-func (s *Service) StartCallFromCommand(ctx context.Context, number string) error {
-	chatID := s.cfg.TGUserID
-	callLogger := s.logger.With("tg_chat_id", chatID, "dial", number)
-	if !s.allowCall(callLogger) {
-		return errors.New("active call limit reached")
+// ErrCallLimit is returned when max_active_calls would be exceeded.
+var ErrCallLimit = errors.New("active call limit reached")
+
+// SetCDRRecorder sets where call detail records are sent. Must be called
+// before Start.
+func (s *Service) SetCDRRecorder(r *cdr.Recorder) {
+	s.cdr = r
+}
+
+// StartCallFromCommand dials number and bridges it to the Telegram user,
+// blocking until the call ends. progress, when set, receives the steps of
+// the call: ringing, answered and how it ended.
+func (s *Service) StartCallFromCommand(ctx context.Context, number, callerID string, progress func(CallProgress)) error {
+	if strings.EqualFold(strings.TrimSpace(number), EchoTarget) {
+		return s.runTelegramEcho(ctx)
+	}
+	if s.tgChatBusy(s.cfg.TGUserID) {
+		return ErrTGBusy
 	}
+	call, err := s.prepareOutboundCall(s.cfg.TGUserID, number, callerID)
+	if err != nil {
+		return err
+	}
+	return s.runOutboundCallWithProgress(ctx, call, progress)
+}
+
+// Originate starts an outbound call in the background and returns it as soon
+// as it is registered, so callers can track it by ID.
+func (s *Service) Originate(ctx context.Context, number, callerID string) (*Call, error) {
+	if s.tgChatBusy(s.cfg.TGUserID) {
+		return nil, ErrTGBusy
+	}
+	call, err := s.prepareOutboundCall(s.cfg.TGUserID, number, callerID)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := s.runOutboundCall(ctx, call); err != nil {
+			s.logger.Warn("originate failed", "error", err, "number", number, "bridge_call_id", call.ID)
+		}
+	}()
+	return call, nil
+}
+
+func (s *Service) prepareOutboundCall(chatID int64, number, callerID string) (*Call, error) {
+	if s.draining.Load() {
+		return nil, ErrDraining
+	}
+	if err := s.validateDialTarget(number); err != nil {
+		return nil, err
+	}
+	if callerID != "" {
+		callerID = normalizePhone(callerID)
+		if !s.callerIDAllowed(callerID) {
+			return nil, ErrCallerID
+		}
+	}
+	local := s.cfg.SIPAuthUser
+	if callerID != "" {
+		local = callerID
+	}
+	logger := s.logger.With("tg_chat_id", chatID, "dial", number)
+	var codecs []string
+	if d, ok := s.runHook(hookOutboundCall, logger, scriptCall(CallInfo{
+		Direction: CallOutbound,
+		Number:    s.dialTarget(number),
+		ChatID:    chatID,
+		StartedAt: time.Now(),
+		State:     CallConnectingTG,
+	}, local)); ok {
+		if d.reject != 0 {
+			logger.Info("outbound call rejected by script", "reason", d.reason)
+			return nil, ErrScriptRejected
+		}
+		if d.routeNumber != "" {
+			if err := s.validateDialTarget(d.routeNumber); err != nil {
+				return nil, err
+			}
+			logger.Info("outbound call routed by script", "number", d.routeNumber)
+			number = d.routeNumber
+		}
+		codecs = d.codecs
+	}
+	routed, err := s.hookOutbound(CallInfo{
+		Direction: CallOutbound,
+		Number:    s.dialTarget(number),
+		ChatID:    chatID,
+		StartedAt: time.Now(),
+		State:     CallConnectingTG,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	if routed != "" {
+		number = routed
+	}
+	if !s.allowCall(s.logger.With("tg_chat_id", chatID, "dial", number)) {
+		return nil, ErrCallLimit
+	}
+	call := newCall(CallOutbound, s.dialTarget(number), chatID)
+	call.Local, call.callerID = local, callerID
+	call.codecs = codecs
+	call.Name = s.callerName(call.Number, "")
+	s.registerCall(call)
+	return call, nil
+}
+
+func (s *Service) runOutboundCall(ctx context.Context, call *Call) error {
+	defer s.recoverCrash("outbound call")
 	defer s.activeCalls.Add(-1)
+	defer s.unregisterCall(call)
+	if provider, target, ok := s.pluginEndpoint(call.Number); ok {
+		return s.runPluginCall(ctx, call, provider, target)
+	}
+
+	chatID := call.ChatID
+	number := call.Number
+	callLogger := s.logger.With("tg_chat_id", chatID, "dial", number, "bridge_call_id", call.ID)
 
-	callCtx, cancel := context.WithTimeout(ctx, s.cfg.EstablishTimeout)
+	callCtx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
 	defer cancel()
+	// Abort setup if a hangup is requested before the call is established.
+	stopAbort := context.AfterFunc(call.ctx, cancel)
+	defer stopAbort()
 
-	tgSession, err := s.startTGCall(callCtx, chatID)
+	var (
+		tgSession tgLeg
+		err       error
+	)
+	if call.mixer != nil {
+		tgSession, err = call.mixer.AddLeg()
+	} else {
+		tgSession, err = s.openTGLeg(callCtx, chatID, s.cfg.VideoEnabled)
+	}
 	if err != nil {
 		callLogger.Warn("tg setup failed", "chat_id", chatID, "error", err)
+		call.setCause(tgFailureCause(err))
 		return err
 	}
-	defer tgSession.Close()
+	call.setTGLeg(tgSession)
+	defer s.releaseTGLeg(call)
 
-	recipient, err := s.buildOutboundURI(number)
+	targets, err := s.outboundTargets(number)
 	if err != nil {
 		callLogger.Warn("invalid sip target", "number", number, "error", err)
+		call.setCause(cdr.CauseSIPFailure)
 		return err
 	}
 
-	dialog, earlyMedia, err := s.inviteWithEarlyMedia(callCtx, recipient, callLogger)
+	s.setCallState(call, CallRinging)
+	ringCtx, cancelRing := s.ringBudget(callCtx)
+	defer cancelRing()
+	var (
+		dialog     *diago.DialogClientSession
+		earlyMedia bool
+	)
+	stopTone := s.startRingbackTone(call, tgSession)
+	if len(targets) > 1 {
+		dialog, err = s.inviteForked(ringCtx, targets, callLogger, call)
+	} else {
+		dialog, earlyMedia, err = s.inviteFollowingRedirects(ringCtx, targets[0], callLogger, call)
+	}
+	stopTone()
 	if err != nil {
 		callLogger.Warn("sip invite failed", "error", err)
+		call.setCause(outboundFailureCause(call, err))
+		err = loopError(call, err)
+		s.playFailureTone(call, tgSession, err)
 		return err
 	}
 	defer dialog.Close()
+	call.setSIPDialog(dialog)
+	if !earlyMedia {
+		s.setCallState(call, CallAnswered)
+	}
 
+	call.setSIPCallID(sipCallID(dialog))
 	callLogger = callLogger.With("call_id", sipCallID(dialog))
-	sipMedia, err := endpoints.NewSipEndpoint(dialog, endpoints.SIPMediaConfig{
-		JitterMinPackets: s.cfg.JitterMinPackets,
-		FrameDuration:    s.cfg.FrameDuration,
-	})
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
 	if err != nil {
 		callLogger.Warn("sip media setup failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
 		return err
 	}
 	defer sipMedia.Close()
+	call.setCodec(sipMedia.Codec.Name)
 	callLogger.Info("sip: codec negotiated",
 		"codec", sipMedia.Codec.Name,
 		"payload_type", sipMedia.Codec.PayloadType,
@@ -278,43 +956,123 @@ func (s *Service) StartCallFromCommand(ctx context.Context, number string) error
 		"rtp_clock_rate", sipMedia.RTPClockRate,
 	)
 
-	if s.cfg.EnableDTMF {
-		s.startDTMFListener(dialog.Context(), dialog.Media(), callLogger)
-	}
-
+	tunables := s.Tunables()
 	bridge, err := NewMediaBridge(
-		dialog.Context(),
+		call.ctx,
 		callLogger,
 		sipMedia,
 		tgSession,
-		s.cfg.DriftTargetFrames,
-		s.cfg.DriftMaxBurst,
+		tunables.DriftTargetFrames,
+		tunables.DriftMaxBurst,
 	)
 	if err != nil {
 		callLogger.Warn("bridge init failed", "error", err)
+		call.setCause(cdr.CauseMediaFailure)
 		return err
 	}
+	s.attachDTMF(bridge, call, callLogger)
+	s.attachCrashReports(bridge, call)
+	s.attachStages(bridge, call, callLogger)
+	bridge.SetAdaptivePlayout(tunables.JitterRTCPAdapt)
+	s.attachHoldMusic(bridge)
+	s.attachNoiseSuppression(bridge)
+	s.attachAGC(bridge)
+	s.attachEchoProtection(bridge)
+	bridge.SetLevelLog(s.cfg.LevelLogInterval)
+	s.attachPreRoll(bridge)
+	s.attachLatencyProbes(bridge)
 	bridge.Start()
 	defer bridge.Stop()
+	call.setMedia(bridge)
+	s.autoRecord(call, callLogger)
+	s.autoDump(call, callLogger)
+	s.autoRTPLog(call, callLogger)
+	s.autoTranscribe(call, callLogger)
 
 	if earlyMedia {
-		if err := dialog.WaitAnswer(callCtx, sipgo.AnswerOptions{}); err != nil {
-			callLogger.Warn("sip wait answer failed", "error", err)
-			return err
+		// The callee's ringback or announcements already reach Telegram
+		// through the bridge; the answer may move the media elsewhere.
+		callLogger.Info("sip: early media from callee, passing it to telegram")
+		earlySDP := dialog.InviteResponse.Body()
+		answerCtx, cancelAnswer := s.earlyMediaBudget(ringCtx)
+		err := dialog.WaitAnswer(answerCtx, sipgo.AnswerOptions{})
+		// Only the early media budget ran out, not the ring time.
+		earlyMediaTimeout := answerCtx.Err() != nil && ringCtx.Err() == nil
+		cancelAnswer()
+		if err != nil {
+			callLogger.Warn("sip wait answer failed", "error", err, "early_media_timeout", earlyMediaTimeout)
+			cause := outboundFailureCause(call, err)
+			if earlyMediaTimeout {
+				cause = cdr.CauseEarlyMediaTimeout
+			}
+			call.setCause(cause)
+			return loopError(call, err)
 		}
 		if err := dialog.Ack(callCtx); err != nil {
 			callLogger.Warn("sip ack failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
 			return err
 		}
+		if !bytes.Equal(earlySDP, dialog.InviteResponse.Body()) {
+			s.handleSIPMediaUpdate(call, dialog, callLogger)
+		}
+		s.setCallState(call, CallAnswered)
 	}
+	s.setCallState(call, CallBridged)
+	s.setHoldState(call, bridge.OnHold())
+	defer s.startVideoBridge(dialog, call, callLogger)()
+	go s.keepDialogAlive(call, callLogger)
 
 	select {
-	case <-dialog.Context().Done():
-	case <-tgSession.Done():
+	case <-call.sipDone():
+		call.setCause(call.sipHangupCause())
+		return nil
+	case <-call.tgDone():
+		call.setCause(cdr.CauseTelegramHangup)
+	case <-call.Done():
+		callLogger.Info("sip: hangup requested")
+		call.setCause(cdr.CauseLocalHangup)
+	}
+	// We ended the call; release the SIP leg with a BYE. Legs that replaced
+	// it in a transfer are released when the call is unregistered.
+	if call.sipDialog() == dialog {
+		byeCtx, byeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer byeCancel()
+		if err := dialog.Hangup(byeCtx); err != nil {
+			callLogger.Warn("sip hangup failed", "error", err)
+		}
 	}
 	return nil
 }
 
+// tgFailureCause maps a Telegram call setup error to a CDR hangup cause.
+func tgFailureCause(err error) string {
+	var protoErr *ubot.ProtocolError
+	if errors.As(err, &protoErr) {
+		if protoErr.TooOld {
+			return cdr.CausePeerTooOld
+		}
+		return cdr.CauseIncompatiblePeer
+	}
+	return cdr.CauseTelegramUnavailable
+}
+
+// outboundFailureCause maps an INVITE error to a CDR hangup cause.
+func outboundFailureCause(call *Call, err error) string {
+	if status := inviteFailureStatus(err); status != 0 {
+		return fmt.Sprintf("sip_%d", status)
+	}
+	select {
+	case <-call.Done():
+		return cdr.CauseLocalHangup
+	default:
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return cdr.CauseNoAnswer
+	}
+	return cdr.CauseSIPFailure
+}
+
 var tgFrameLogCount int64
 
 func (s *Service) handleTGFrame(chatID int64, mode ntgcalls.StreamMode, device ntgcalls.StreamDevice, frames []ntgcalls.Frame) {
@@ -330,6 +1088,17 @@ func (s *Service) handleTGFrame(chatID int64, mode ntgcalls.StreamMode, device n
 	if mode != ntgcalls.PlaybackStream {
 		return
 	}
+	switch device {
+	case ntgcalls.CameraStream:
+		if vb := s.getVideoBridge(chatID); vb != nil {
+			for _, frame := range frames {
+				vb.pushTGFrame(frame)
+			}
+		}
+		return
+	case ntgcalls.ScreenStream:
+		return
+	}
 	session := s.getTGSession(chatID)
 	if session == nil {
 		return
@@ -355,13 +1124,19 @@ func (s *Service) handleTGCallDisconnect(chatID int64, reason string) {
 	}
 }
 
-func (s *Service) startTGCall(ctx context.Context, chatID int64) (*endpoints.TgEndpoint, error) {
-	session := s.ensureTGSession(chatID)
+// startTGCall calls chatID, with the camera when video is set (private
+// calls only).
+func (s *Service) startTGCall(ctx context.Context, chatID int64, video bool) (*endpoints.TgEndpoint, error) {
+	session, err := s.newTGSession(chatID)
+	if err != nil {
+		return nil, err
+	}
 
+	sendRate, receiveRate := s.tgRates()
 	capture := ntgcalls.MediaDescription{
 		Microphone: &ntgcalls.AudioDescription{
 			MediaSource:  ntgcalls.MediaSourceExternal,
-			SampleRate:   uint32(s.cfg.SampleRate),
+			SampleRate:   uint32(sendRate),
 			ChannelCount: uint8(s.cfg.Channels),
 			KeepOpen:     true,
 		},
@@ -369,14 +1144,20 @@ func (s *Service) startTGCall(ctx context.Context, chatID int64) (*endpoints.TgE
 	playback := ntgcalls.MediaDescription{
 		Microphone: &ntgcalls.AudioDescription{
 			MediaSource:  ntgcalls.MediaSourceExternal,
-			SampleRate:   uint32(s.cfg.SampleRate),
+			SampleRate:   uint32(receiveRate),
 			ChannelCount: uint8(s.cfg.Channels),
 			KeepOpen:     true,
 		},
 	}
+	if video && chatID >= 0 {
+		capture.Camera = s.tgVideo()
+		playback.Camera = s.tgVideo()
+	}
 	s.logger.Info("tg call: initiating play stream", "chat_id", chatID)
-	if err := s.tg.Play(chatID, capture); err != nil {
-		s.logger.Error("tg play failed", "chat_id", chatID, "error", err, "error_type", fmt.Sprintf("%T", err))
+	if err := s.playTG(ctx, s.tg, chatID, capture); err != nil {
+		if ctx.Err() == nil {
+			s.logger.Error("tg play failed", "chat_id", chatID, "error", err, "error_type", fmt.Sprintf("%T", err))
+		}
 		session.Close()
 		return nil, fmt.Errorf("tg play: %w", err)
 	}
@@ -385,26 +1166,71 @@ func (s *Service) startTGCall(ctx context.Context, chatID int64) (*endpoints.TgE
 		session.Close()
 		return nil, fmt.Errorf("tg record: %w", err)
 	}
+	if err := ctx.Err(); err != nil {
+		// Cancelled after the callee answered.
+		session.Close()
+		return nil, fmt.Errorf("tg setup: %w", context.Cause(ctx))
+	}
 	s.logger.Info("tg call: connected and ready", "chat_id", chatID)
+	return session, nil
+}
 
-	// Note: We don't check ctx.Done() here anymore because the TG session
-	// is already established. If the SIP side canceled during setup, we still
-	// want to return the session and let the caller handle cleanup properly.
-	// The caller will detect the canceled context and clean up appropriately.
+// tgCaller is the part of the Telegram client that sets up calls.
+type tgCaller interface {
+	Play(chatId any, mediaDescription ntgcalls.MediaDescription) error
+	CancelCall(chatId any) (bool, error)
+}
 
-	return session, nil
+// playTG starts the Telegram call to chatID. The caller may give up (SIP
+// CANCEL) while the callee is still ringing: the pending Telegram call is
+// aborted then rather than finishing the setup only to tear it down again,
+// and the cause of ctx is returned.
+func (s *Service) playTG(ctx context.Context, caller tgCaller, chatID int64, capture ntgcalls.MediaDescription) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	stopCancel := context.AfterFunc(ctx, func() {
+		if pending, _ := caller.CancelCall(chatID); pending {
+			s.logger.Info("tg call: setup cancelled, discarding pending call", "chat_id", chatID)
+		}
+	})
+	err := caller.Play(chatID, capture)
+	stopCancel()
+	if errors.Is(err, ubot.ErrCallCancelled) {
+		return context.Cause(ctx)
+	}
+	return err
 }
 
-func (s *Service) ensureTGSession(chatID int64) *endpoints.TgEndpoint {
+// newTGSession creates the endpoint of a new Telegram call to chatID. The
+// call that creates it owns it; the session is forgotten once it closes.
+// Telegram carries one call per chat, so this fails with ErrTGBusy while
+// chatID has a session.
+func (s *Service) newTGSession(chatID int64) (*endpoints.TgEndpoint, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if session, ok := s.tgSessions[chatID]; ok {
-		return session
+	if _, ok := s.tgSessions[chatID]; ok {
+		return nil, ErrTGBusy
 	}
 	frameSize := s.frameSize()
-	session := endpoints.NewTgEndpoint(s.tg, chatID, frameSize, s.cfg.SampleRate, s.removeTGSession)
+	sendRate, receiveRate := s.tgRates()
+	session := endpoints.NewTgEndpoint(s.tg, chatID, frameSize, s.cfg.SampleRate, sendRate, receiveRate, s.removeTGSession)
 	s.tgSessions[chatID] = session
-	return session
+	return session, nil
+}
+
+// tgRates returns the sample rates of the audio sent to and received from
+// ntgcalls: audio.telegram_send_rate and audio.telegram_receive_rate, or the
+// internal rate.
+func (s *Service) tgRates() (send, receive int) {
+	send, receive = s.cfg.TGSendRate, s.cfg.TGReceiveRate
+	if send == 0 {
+		send = s.cfg.SampleRate
+	}
+	if receive == 0 {
+		receive = s.cfg.SampleRate
+	}
+	return send, receive
 }
 
 func (s *Service) getTGSession(chatID int64) *endpoints.TgEndpoint {
@@ -413,13 +1239,19 @@ func (s *Service) getTGSession(chatID int64) *endpoints.TgEndpoint {
 	return s.tgSessions[chatID]
 }
 
-func (s *Service) removeTGSession(chatID int64) {
+// removeTGSession forgets session unless its chat already has a newer one.
+func (s *Service) removeTGSession(session *endpoints.TgEndpoint) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.tgSessions, chatID)
+	if s.tgSessions[session.ChatID()] == session {
+		delete(s.tgSessions, session.ChatID())
+	}
 }
 
 func (s *Service) buildOutboundURI(number string) (sip.Uri, error) {
+	if _, ok := s.sipAccount(number); ok {
+		return s.accountURI(number)
+	}
 	normalized := normalizePhone(number)
 	if normalized == "" {
 		return sip.Uri{}, fmt.Errorf("invalid phone number")
@@ -435,34 +1267,87 @@ func (s *Service) buildOutboundURI(number string) (sip.Uri, error) {
 	if s.cfg.SIPTransport != "" {
 		recipient.UriParams = sip.HeaderParams{"transport": s.cfg.SIPTransport}
 	}
+	if s.cfg.SIPQuirks.UserPhone {
+		if recipient.UriParams == nil {
+			recipient.UriParams = sip.HeaderParams{}
+		}
+		recipient.UriParams.Add("user", "phone")
+	}
 	return recipient, nil
 }
 
+func (s *Service) sipMediaConfig() endpoints.SIPMediaConfig {
+	tunables := s.Tunables()
+	return endpoints.SIPMediaConfig{
+		JitterMin:     tunables.JitterMinDelay,
+		JitterMax:     tunables.JitterMaxDelay,
+		FrameDuration: s.cfg.FrameDuration,
+		FEC:           s.cfg.OpusFEC,
+		PLC:           s.cfg.OpusPLC,
+	}
+}
+
+// handleSIPMediaUpdate applies a mid-call re-INVITE (hold/resume, codec or
+// address change) to the call's media bridge.
+func (s *Service) handleSIPMediaUpdate(call *Call, dialog endpoints.SIPDialog, logger *slog.Logger) {
+	if current := call.sipDialog(); current != nil && current != dialog {
+		// The dialog was transferred away; its media is no longer bridged.
+		return
+	}
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		logger.Warn("sip re-invite: media update rejected", "error", err)
+		return
+	}
+	logger.Info("sip re-invite", "codec", sipMedia.Codec.Name, "remote", sipMedia.RemoteAddr, "on_hold", sipMedia.OnHold)
+	media := call.mediaBridge()
+	if media == nil {
+		return
+	}
+	media.UpdateSIP(sipMedia)
+	call.setCodec(sipMedia.Codec.Name)
+	s.setHoldState(call, call.waitingHeld() || sipMedia.OnHold)
+}
+
 func (s *Service) sipCodecs() []media.Codec {
 	return SIPCodecs(s.cfg)
 }
 
 func (s *Service) frameSize() int {
+	return s.tgFormat().FrameBytes()
+}
+
+func (s *Service) tgFormat() pcm.AudioFormat {
 	// TG external audio injection is most stable with 10ms PCM blocks.
 	// We keep SIP ptime (FrameDuration) at e.g. 20ms, but we inject into TG at half that.
 	tgFrameDuration := s.cfg.FrameDuration / 2
-	format := pcm.AudioFormat{
+	return pcm.AudioFormat{
 		SampleRate: s.cfg.SampleRate,
 		Channels:   s.cfg.Channels,
 		FrameDur:   tgFrameDuration,
 	}
-	return format.FrameBytes()
+}
+
+// dialBlocked reports whether an outbound call to chatID can't be placed
+// right now: the call limit is reached or chatID is a private chat in a
+// call. Automated dialers wait for it instead of failing.
+func (s *Service) dialBlocked(chatID int64) bool {
+	if maxCalls := s.Tunables().MaxActiveCalls; maxCalls > 0 && s.activeCalls.Load() >= maxCalls {
+		return true
+	}
+	return s.tgChatBusy(chatID)
 }
 
 func (s *Service) allowCall(logger *slog.Logger) bool {
-	if s.cfg.MaxActiveCalls <= 0 {
+	maxCalls := s.Tunables().MaxActiveCalls
+	if maxCalls <= 0 {
 		s.activeCalls.Add(1)
 		return true
 	}
 	for {
 		current := s.activeCalls.Load()
-		if current >= s.cfg.MaxActiveCalls {
-			logger.Warn("active call limit reached", "max", s.cfg.MaxActiveCalls)
+		if current >= maxCalls {
+			logger.Warn("active call limit reached", "max", maxCalls)
 			return false
 		}
 		if s.activeCalls.CompareAndSwap(current, current+1) {
@@ -482,23 +1367,43 @@ func sipCallID(dialog diago.DialogSession) string {
 	return req.CallID().Value()
 }
 
-func (s *Service) inviteWithEarlyMedia(ctx context.Context, recipient sip.Uri, logger *slog.Logger) (*diago.DialogClientSession, bool, error) {
-	dialog, err := s.sip.NewDialog(recipient, diago.NewDialogOptions{})
+// inviteWithEarlyMedia sends the INVITE for the SIP leg of call. Re-INVITEs
+// and transfers received on the dialog are applied to call.
+func (s *Service) inviteWithEarlyMedia(ctx context.Context, recipient sip.Uri, logger *slog.Logger, call *Call) (*diago.DialogClientSession, bool, error) {
+	video := s.videoMode(call.ChatID)
+	if call.mixer != nil {
+		// Conferences are audio only.
+		video = ""
+	}
+	dialog, err := s.sip.Load().NewDialog(recipient, diago.NewDialogOptions{Video: video})
 	if err != nil {
 		return nil, false, err
 	}
-	headers := []sip.Header{}
+	headers := append(s.callerIDHeaders(call), s.loopHeaders(call)...)
+	headers = append(headers, s.trunkHeaders(recipient, call)...)
+	if ms := dialog.MediaSession(); ms != nil {
+		ms.Codecs = filterCodecs(ms.Codecs, call.codecs)
+		ms.RTPNAT = s.rtpNAT()
+	}
 	if logger != nil {
 		if ms := dialog.MediaSession(); ms != nil {
 			logCodecPrefs(logger, "local codec offer (outbound INVITE)", ms.Codecs)
 		}
 	}
 	err = dialog.Invite(ctx, diago.InviteClientOptions{
-		EarlyMediaDetect: s.cfg.EnableEarlyMedia,
+		EarlyMediaDetect: s.Tunables().EnableEarlyMedia,
 		Username:         s.cfg.SIPAuthUser,
 		Password:         s.cfg.SIPAuthPass,
 		OnResponse: func(res *sip.Response) error {
-			if res.ContentType() != nil && res.ContentType().Value() == "application/sdp" {
+			sdpBody := res.ContentType() != nil && res.ContentType().Value() == "application/sdp"
+			if res.IsProvisional() && res.StatusCode != sip.StatusTrying && (!sdpBody || !s.Tunables().EnableEarlyMedia) {
+				// Ringing without early media of its own.
+				call.markAlerted()
+			}
+			if res.IsProvisional() && res.StatusCode != sip.StatusTrying {
+				call.reportProgress(CallProgress{State: CallRinging, SIPStatus: res.StatusCode, SIPReason: res.Reason})
+			}
+			if sdpBody {
 				if logger != nil {
 					logSDPAudioCodecs(logger, "remote answer", res.Body())
 				}
@@ -506,7 +1411,14 @@ func (s *Service) inviteWithEarlyMedia(ctx context.Context, recipient sip.Uri, l
 			}
 			return nil
 		},
-		Headers: headers,
+		OnMediaUpdate: func(*diago.DialogMedia) {
+			// Runs with the dialog locked; apply the update asynchronously.
+			go s.handleSIPMediaUpdate(call, dialog, logger)
+		},
+		OnRefer:        s.onTransferred(call, logger),
+		ReferInvite:    s.referInvite(call, logger),
+		ReInviteCodecs: s.reInviteCodecs(),
+		Headers:        headers,
 	})
 	if err != nil {
 		if errors.Is(err, diago.ErrClientEarlyMedia) {
@@ -558,31 +1470,30 @@ func parseSDPTimeAttr(attrs []string, key string) (int, bool) {
 	return 0, false
 }
 
-func (s *Service) startDTMFListener(ctx context.Context, dialogMedia *diago.DialogMedia, logger *slog.Logger) {
-	if dialogMedia == nil {
-		return
-	}
-	dtmfReader := dialogMedia.AudioReaderDTMF()
-	if dtmfReader == nil {
+// attachDTMF routes RFC 4733 digits received from SIP to DTMF commands and,
+// with sip.dtmf_relay, to in-band tones on the Telegram side.
+func (s *Service) attachDTMF(bridge *MediaBridge, call *Call, logger *slog.Logger) {
+	if !s.cfg.EnableDTMF {
 		return
 	}
-	go func() {
-		dtmfReader.OnDTMF(func(digit rune) error {
-			logger.Info("DTMF received", "digit", string(digit))
-			return nil
-		})
-		<-ctx.Done()
-	}()
+	bridge.OnDTMF(func(digit rune) {
+		logger.Info("DTMF received", "digit", string(digit))
+		if s.cfg.DTMFRelay {
+			bridge.PlayTGTones(string(digit))
+		}
+		s.handleDTMFDigit(call, digit, logger)
+		s.scriptDTMF(call, digit, logger)
+		s.webhooks.Send(webhook.DTMFReceived, DTMFEvent{CallID: call.ID, Digit: string(digit)})
+	})
 }
 
 func (s *Service) authorizeInboundSIP(dialog *diago.DialogServerSession, logger *slog.Logger) error {
 	if s.authServer == nil {
 		return nil
 	}
-	auth := diago.DigestAuth{
-		Username: s.cfg.SIPAuthUser,
-		Password: s.cfg.SIPAuthPass,
-		Realm:    s.cfg.SIPAuthRealm,
+	auth, ok := s.inboundDigest(dialog.FromUser())
+	if !ok {
+		return nil
 	}
 	if err := s.authServer.AuthorizeDialog(dialog, auth); err != nil {
 		logger.Warn("sip auth failed", "error", err)
@@ -633,6 +1544,20 @@ func SIPCodecs(cfg Config) []media.Codec {
 		}
 		return bi.Priority - ai.Priority
 	})
+	if len(cfg.Codecs) > 0 {
+		// audio.codecs: only these, in this order, and preferred in this
+		// order over the registry priorities when negotiating. DTMF stays
+		// up to sip.dtmf_enabled.
+		var listed []msdk.Codec
+		for _, name := range append(slices.Clone(cfg.Codecs), "telephone-event") {
+			for _, c := range enabled {
+				if codecName(c) == name {
+					listed = append(listed, c)
+				}
+			}
+		}
+		enabled = listed
+	}
 
 	usedPT := map[uint8]bool{}
 	const dynamicStart = uint8(101)
@@ -682,6 +1607,13 @@ func SIPCodecs(cfg Config) []media.Codec {
 		usedPT[pt] = true
 
 		dc.PayloadType = pt
+		if i := slices.Index(cfg.Codecs, codecName(c)); i >= 0 {
+			dc.Priority = len(cfg.Codecs) - i
+		}
+		if strings.EqualFold(dc.Name, "opus") && cfg.OpusFEC {
+			// https://www.rfc-editor.org/rfc/rfc7587#section-6.1
+			dc.FMTP = "useinbandfec=1"
+		}
 		codecs = append(codecs, dc)
 	}
 
@@ -713,6 +1645,26 @@ func SIPCodecs(cfg Config) []media.Codec {
 	return codecs
 }
 
+// codecName is the lowercase name of a media-sdk codec ("opus" for
+// opus/48000/2), as used by audio.codecs.
+func codecName(c msdk.Codec) string {
+	name, _, _ := strings.Cut(c.Info().SDPName, "/")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// AvailableCodecs returns the names of the audio codecs in this build, for
+// audio.codecs.
+func AvailableCodecs() []string {
+	var names []string
+	for _, c := range msdk.EnabledCodecs() {
+		if name := codecName(c); name != "" && name != "telephone-event" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
 func logSDPAudioCodecs(logger *slog.Logger, label string, body []byte) {
 	if logger == nil || len(body) == 0 {
 		return
@@ -0,0 +1,157 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/third_party/ntgcalls"
+	"gotgcalls/third_party/ubot"
+)
+
+// fakeTG rings the callee until answer is closed or the setup is cancelled.
+type fakeTG struct {
+	started   chan struct{}
+	answer    chan struct{}
+	cancelled chan struct{}
+	once      sync.Once
+	plays     int
+}
+
+func newFakeTG() *fakeTG {
+	return &fakeTG{
+		started:   make(chan struct{}, 1),
+		answer:    make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+func (f *fakeTG) Play(chatId any, _ ntgcalls.MediaDescription) error {
+	f.plays++
+	f.started <- struct{}{}
+	select {
+	case <-f.answer:
+		return nil
+	case <-f.cancelled:
+		return ubot.ErrCallCancelled
+	}
+}
+
+func (f *fakeTG) CancelCall(chatId any) (bool, error) {
+	f.once.Do(func() { close(f.cancelled) })
+	return true, nil
+}
+
+func (f *fakeTG) wasCancelled() bool {
+	select {
+	case <-f.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+func newTestService() *Service {
+	return &Service{logger: slog.New(slog.DiscardHandler), events: newEventBus()}
+}
+
+// recordEvents collects the transitions published by s.
+func recordEvents(s *Service) func() []CallEvent {
+	var mu sync.Mutex
+	var events []CallEvent
+	s.Events().Subscribe(func(ev CallEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+	return func() []CallEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]CallEvent(nil), events...)
+	}
+}
+
+func TestPlayTGCancelDuringSetup(t *testing.T) {
+	s := newTestService()
+	events := recordEvents(s)
+	call := newCall(CallInbound, "100", 42)
+	s.setCallState(call, CallConnectingTG)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := newFakeTG()
+	errc := make(chan error, 1)
+	go func() { errc <- s.playTG(ctx, fake, call.ChatID, ntgcalls.MediaDescription{}) }()
+	<-fake.started
+	// The SIP caller sends CANCEL while Telegram still rings.
+	cancel()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(time.Second):
+		t.Fatal("setup was not aborted on cancel")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if !fake.wasCancelled() {
+		t.Fatal("pending telegram call was not cancelled")
+	}
+
+	call.setCause(cdr.CauseCancelled)
+	s.setCallState(call, CallEnded)
+	got := events()
+	want := []CallEvent{
+		{From: CallRinging, To: CallConnectingTG},
+		{From: CallConnectingTG, To: CallEnded, Cause: cdr.CauseCancelled},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].From != want[i].From || got[i].To != want[i].To || got[i].Cause != want[i].Cause {
+			t.Errorf("event %d = %s -> %s (%q), want %s -> %s (%q)", i, got[i].From, got[i].To, got[i].Cause, want[i].From, want[i].To, want[i].Cause)
+		}
+	}
+}
+
+func TestPlayTGCancelledBeforeSetup(t *testing.T) {
+	s := newTestService()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fake := newFakeTG()
+	if err := s.playTG(ctx, fake, 42, ntgcalls.MediaDescription{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if fake.plays != 0 {
+		t.Fatal("telegram call was requested after cancel")
+	}
+}
+
+func TestPlayTGAnswered(t *testing.T) {
+	s := newTestService()
+	events := recordEvents(s)
+	call := newCall(CallInbound, "100", 42)
+	s.setCallState(call, CallConnectingTG)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := newFakeTG()
+	close(fake.answer)
+	if err := s.playTG(ctx, fake, call.ChatID, ntgcalls.MediaDescription{}); err != nil {
+		t.Fatalf("playTG: %v", err)
+	}
+	// A CANCEL after the answer no longer touches the setup.
+	cancel()
+	if fake.wasCancelled() {
+		t.Fatal("answered call was cancelled as pending")
+	}
+	s.setCallState(call, CallAnswered)
+	if got := events(); len(got) != 2 || got[1].To != CallAnswered {
+		t.Fatalf("events = %+v, want connecting then answered", got)
+	}
+}
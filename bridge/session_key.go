@@ -0,0 +1,141 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// gogram encrypts its session file with this key when no SessionAESKey is set.
+// We need it to migrate sessions created before encryption was configured.
+const gogramDefaultSessionKey = "1234567890123456"
+
+// ResolveSessionKey returns the AES-256 key used to encrypt the Telegram session
+// file at rest. The secret is taken (in order) from telegram.session_key,
+// the environment variable named by telegram.session_key_env, or the OS keyring
+// entry named by telegram.session_key_keyring ("service/account").
+//
+// Returns "" when no encryption is configured.
+func ResolveSessionKey(cfg Config) (string, error) {
+	secret := cfg.TGSessionKey
+	if secret == "" && cfg.TGSessionKeyEnv != "" {
+		secret = os.Getenv(cfg.TGSessionKeyEnv)
+		if secret == "" {
+			return "", fmt.Errorf("session key env %q is empty", cfg.TGSessionKeyEnv)
+		}
+	}
+	if secret == "" && cfg.TGSessionKeyring != "" {
+		var err error
+		secret, err = keyringLookup(cfg.TGSessionKeyring)
+		if err != nil {
+			return "", fmt.Errorf("session key keyring lookup: %w", err)
+		}
+	}
+	if secret == "" {
+		return "", nil
+	}
+	// Stretch arbitrary passphrases into a fixed 32-byte AES key.
+	sum := sha256.Sum256([]byte(secret))
+	return string(sum[:]), nil
+}
+
+// MigrateSessionFile re-encrypts a session file written with gogram's built-in
+// default key using key. It is a no-op if the file doesn't exist or is already
+// encrypted with key.
+func MigrateSessionFile(path string, key string) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := decryptSessionBytes(data, key); err == nil {
+		return false, nil
+	}
+	plain, err := decryptSessionBytes(data, gogramDefaultSessionKey)
+	if err != nil {
+		return false, errors.New("session file can't be decrypted with the configured key")
+	}
+	encrypted, err := encryptSessionBytes(plain, key)
+	if err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encrypted, 0600); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+func keyringLookup(ref string) (string, error) {
+	service, account, ok := strings.Cut(ref, "/")
+	if !ok || service == "" || account == "" {
+		return "", fmt.Errorf("keyring reference must be \"service/account\", got %q", ref)
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("keyring not supported on %s", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", errors.New("keyring entry is empty")
+	}
+	return secret, nil
+}
+
+// The helpers below mirror gogram's session file format (AES-CBC, IV = key
+// prefix, PKCS#5 padding) so we can validate and migrate files ourselves.
+
+func encryptSessionBytes(data []byte, key string) ([]byte, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	buf := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	out := make([]byte, len(buf))
+	cipher.NewCBCEncrypter(block, []byte(key)[:block.BlockSize()]).CryptBlocks(out, buf)
+	return out, nil
+}
+
+func decryptSessionBytes(data []byte, key string) ([]byte, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid session ciphertext size")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, []byte(key)[:block.BlockSize()]).CryptBlocks(out, data)
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > block.BlockSize() || padding > len(out) {
+		return nil, errors.New("invalid session padding")
+	}
+	out = out[:len(out)-padding]
+	// Session files are JSON; anything else means the key was wrong.
+	if len(out) == 0 || out[0] != '{' {
+		return nil, errors.New("session key mismatch")
+	}
+	return out, nil
+}
@@ -0,0 +1,281 @@
+package bridge
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+)
+
+// SimulationStep is one decision taken while routing a simulated call.
+type SimulationStep struct {
+	Step   string `json:"step"`
+	Result string `json:"result"`
+}
+
+type simulation []SimulationStep
+
+func (sim *simulation) add(step, format string, args ...any) {
+	*sim = append(*sim, SimulationStep{Step: step, Result: fmt.Sprintf(format, args...)})
+}
+
+// SimulateOutbound evaluates how a call to number (presenting callerID if
+// set) would be routed: script, plugin or trunk, caller ID, codecs and the
+// call limit. Nothing is dialed; messages the script queues are reported,
+// not sent.
+func (s *Service) SimulateOutbound(number, callerID string) []SimulationStep {
+	var sim simulation
+	if strings.EqualFold(strings.TrimSpace(number), EchoTarget) {
+		sim.add("target", "echo test: Telegram calls you back and plays your voice back")
+		return sim
+	}
+	if s.draining.Load() {
+		sim.add("service", "shutting down, the call would be refused")
+		return sim
+	}
+	if err := s.validateDialTarget(number); err != nil {
+		sim.add("target", "%q can't be dialed: %v", number, err)
+		return sim
+	}
+	local := s.cfg.SIPAuthUser
+	if callerID != "" {
+		callerID = normalizePhone(callerID)
+		if !s.callerIDAllowed(callerID) {
+			sim.add("caller ID", "%s is not in sip.caller_ids, the call would be refused", callerID)
+			return sim
+		}
+		local = callerID
+		sim.add("caller ID", "%s (From and P-Asserted-Identity)", callerID)
+	} else {
+		sim.add("caller ID", "default From user %q", s.cfg.SIPAuthUser)
+	}
+
+	var codecs []string
+	d, err := s.evalHook(hookOutboundCall, scriptCall(CallInfo{
+		Direction: CallOutbound,
+		Number:    s.dialTarget(number),
+		ChatID:    s.cfg.TGUserID,
+		StartedAt: time.Now(),
+		State:     CallConnectingTG,
+	}, local))
+	if d != nil {
+		sim.script(hookOutboundCall, d, err)
+		if err == nil {
+			if d.reject != 0 {
+				return sim
+			}
+			if d.routeNumber != "" {
+				if err := s.validateDialTarget(d.routeNumber); err != nil {
+					sim.add("target", "script target %q can't be dialed: %v", d.routeNumber, err)
+					return sim
+				}
+				number = d.routeNumber
+			}
+			codecs = d.codecs
+		}
+	}
+
+	if _, target, ok := s.pluginEndpoint(number); ok {
+		name, _, _ := strings.Cut(strings.TrimSpace(number), ":")
+		sim.add("route", "plugin %s, target %q", name, target)
+	} else {
+		uri, _ := s.buildOutboundURI(number)
+		sim.add("route", "SIP trunk %s", s.cfg.SIPProvider)
+		sim.add("request URI", "%s", uri.String())
+		if s.cfg.SIPOutboundProxy != "" {
+			sim.add("outbound proxy", "%s", s.cfg.SIPOutboundProxy)
+		}
+		if s.cfg.SIPAuthUser != "" {
+			sim.add("auth", "digest as %q if challenged", s.cfg.SIPAuthUser)
+		}
+		sim.add("codecs offered", "%s", codecList(filterCodecs(s.sipCodecs(), codecs)))
+		if mode := s.videoMode(s.cfg.TGUserID); mode != "" {
+			sim.add("video", "H.264 offered (%s)", mode)
+		}
+	}
+	if name := s.callerName(s.dialTarget(number), ""); name != "" {
+		sim.add("contact", "%s", name)
+	}
+	sim.limit(s)
+	return sim
+}
+
+// SimulateInbound evaluates how a SIP call from caller to called (our
+// number, optional) would be handled: caller screening, script, ring
+// profile, calendar, IVR, follow-me and voice chat routes. Nothing is rung.
+func (s *Service) SimulateInbound(caller, called string) []SimulationStep {
+	var sim simulation
+	call := newCall(CallInbound, caller, s.cfg.TGUserID)
+	defer call.cancel()
+	call.Local = called
+	if chatID := lookupPhone(s.cfg.VoiceChats, called); chatID != 0 {
+		call.setChatID(chatID)
+	}
+	call.Name = s.callerName(caller, "")
+	call.ring = s.ringProfile(caller)
+	call.ringing = s.ringing(called, call.ring)
+	if call.Name != "" {
+		sim.add("contact", "%s", call.Name)
+	}
+	if reason := s.screenCaller(caller); reason != "" {
+		if !call.ring.SkipScreening {
+			sim.add("callers", "rejected (%s) with %d", reason, s.Tunables().CallersRejectStatus)
+			return sim
+		}
+		sim.add("callers", "%s, but the ring profile skips screening", reason)
+	}
+	d, err := s.evalHook(hookInboundCall, scriptCall(call.Info(), call.Local))
+	if d != nil {
+		sim.script(hookInboundCall, d, err)
+		if err == nil {
+			if d.reject != 0 {
+				return sim
+			}
+			if d.routeUser != 0 {
+				call.setChatID(d.routeUser)
+			}
+			call.codecs = d.codecs
+		}
+	}
+	if s.draining.Load() {
+		sim.add("service", "shutting down, rejected with 503")
+		return sim
+	}
+	if !sim.limit(s) {
+		return sim
+	}
+	if s.isEchoExtension(called) {
+		sim.add("route", "echo test extension: answered, the caller hears themselves")
+		return sim
+	}
+	if line, ok := s.infoLine(called); ok {
+		sim.add("route", "info line: answered with its message (%d options), then hung up", len(line.options))
+		return sim
+	}
+	if until, summary, busy := s.calendarBusy(time.Now()); busy && !call.ring.SkipScreening {
+		sim.add("calendar", "busy until %s (%s): %s", until.Local().Format("15:04"), summary, s.cfg.CalendarAction)
+		return sim
+	}
+	sim.add("codecs accepted", "%s", codecList(filterCodecs(s.sipCodecs(), call.codecs)))
+	if mode := s.videoMode(call.ChatID); mode != "" {
+		sim.add("video", "H.264 accepted (%s)", mode)
+	}
+	if s.cfg.IVRMenus != nil {
+		sim.add("IVR", "answers with menu %q first; the menu may pick another route", s.cfg.IVRStart)
+	} else if s.cfg.IVRWebhookURL != "" {
+		sim.add("IVR", "answers and asks %s first; it may pick another route", s.cfg.IVRWebhookURL)
+	}
+	if profile := describeRingProfile(call.ring); profile != "" {
+		sim.add("ring profile", "%s", profile)
+	}
+	if ringing := describeRinging(call.ringing); ringing != "" {
+		sim.add("ringing", "%s", ringing)
+	}
+	if s.cfg.ConfirmInbound && !call.ring.SkipScreening {
+		sim.add("confirm", "you are asked to /answer or /decline within %s", cmp.Or(call.ringing.Timeout, s.cfg.ConfirmInboundTimeout))
+	}
+	if chain := lookupPhone(s.cfg.FollowMe, called); len(chain) > 0 {
+		steps := make([]string, 0, len(chain))
+		for _, step := range chain {
+			target := step.Target
+			if target == FollowMeTelegram {
+				target = fmt.Sprintf("telegram %d", call.ChatID)
+			}
+			if step.Timeout > 0 {
+				target += " for " + step.Timeout.String()
+			}
+			steps = append(steps, target)
+		}
+		sim.add("route", "follow-me: %s", strings.Join(steps, ", then "))
+		return sim
+	}
+	if call.ChatID < 0 {
+		sim.add("route", "joins voice chat %d as a participant", call.ChatID)
+	} else {
+		sim.add("route", "rings Telegram user %d", call.ChatID)
+	}
+	if s.voicemailEnabled() {
+		sim.add("no answer", "voicemail")
+	} else {
+		status, _ := ringTimeoutStatus(call.ringing, sip.StatusTemporarilyUnavailable, "")
+		sim.add("no answer", "rejected with %d", status)
+	}
+	return sim
+}
+
+// script reports the decision of a script hook.
+func (sim *simulation) script(hook string, d *scriptDecision, err error) {
+	if err != nil {
+		sim.add("script", "%s failed, the call goes on without it: %v", hook, err)
+		return
+	}
+	for _, m := range d.messages {
+		sim.add("script", "would send %q", m.text)
+	}
+	switch {
+	case d.reject != 0:
+		sim.add("script", "%s rejects the call (%d %s)", hook, d.reject, d.reason)
+	case d.routeNumber != "":
+		sim.add("script", "%s routes the call to %s", hook, d.routeNumber)
+	case d.routeUser != 0:
+		sim.add("script", "%s routes the call to %d", hook, d.routeUser)
+	default:
+		sim.add("script", "%s lets the call through", hook)
+	}
+	if len(d.codecs) > 0 {
+		sim.add("script", "limits codecs to %s", strings.Join(d.codecs, ", "))
+	}
+}
+
+// limit reports the active call limit and whether the call fits.
+func (sim *simulation) limit(s *Service) bool {
+	maxCalls := s.Tunables().MaxActiveCalls
+	active := s.activeCalls.Load()
+	if maxCalls <= 0 {
+		sim.add("call limit", "none (%d active)", active)
+		return true
+	}
+	if active >= maxCalls {
+		sim.add("call limit", "reached (%d of %d active), the call would be refused", active, maxCalls)
+		return false
+	}
+	sim.add("call limit", "%d of %d active", active, maxCalls)
+	return true
+}
+
+func codecList(codecs []media.Codec) string {
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+func describeRingProfile(p RingProfile) string {
+	var parts []string
+	if p.AutoAnswer {
+		parts = append(parts, "auto-answer")
+	}
+	if p.Silent {
+		parts = append(parts, "silent")
+	}
+	if p.SkipScreening {
+		parts = append(parts, "skips screening")
+	}
+	if p.RingTimeout > 0 {
+		parts = append(parts, "rings "+p.RingTimeout.String())
+	}
+	if p.Message != "" {
+		parts = append(parts, "custom notice")
+	}
+	if p.Sound != "" {
+		parts = append(parts, "sound "+p.Sound)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"cmp"
+	"errors"
+	"strings"
+
+	"github.com/emiago/diago"
+)
+
+// ErrNotRegistered is returned when dialing a local SIP account that has no
+// current registration.
+var ErrNotRegistered = errors.New("sip account not registered")
+
+// SIPAccount is a local SIP account (sip.accounts) for softphones and desk
+// phones: they register to the bridge with it, authenticate the calls they
+// place with it and are dialed by its username.
+type SIPAccount struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Name is the caller name of the account's calls (optional).
+	Name string `yaml:"name"`
+}
+
+// sipAccount returns the local account named user.
+func (s *Service) sipAccount(user string) (SIPAccount, bool) {
+	user = strings.TrimSpace(user)
+	for _, a := range s.cfg.SIPAccounts {
+		if a.Username == user {
+			return a, true
+		}
+	}
+	return SIPAccount{}, false
+}
+
+// digestAuth is the digest challenge for username and password.
+func (s *Service) digestAuth(username, password string) diago.DigestAuth {
+	return diago.DigestAuth{
+		Username:   username,
+		Password:   password,
+		Realm:      cmp.Or(s.cfg.SIPAuthRealm, "sipgo"),
+		Algorithms: s.cfg.SIPAuthAlgorithms,
+		QOP:        s.cfg.SIPAuthQOP,
+	}
+}
+
+// inboundDigest returns the credentials an inbound call from fromUser must
+// authenticate with: those of its local account, or sip.auth_user. ok is
+// false when the call needs no authentication.
+func (s *Service) inboundDigest(fromUser string) (auth diago.DigestAuth, ok bool) {
+	if acc, ok := s.sipAccount(fromUser); ok {
+		return s.digestAuth(acc.Username, acc.Password), true
+	}
+	if s.cfg.SIPAuthUser == "" {
+		return diago.DigestAuth{}, false
+	}
+	return s.digestAuth(s.cfg.SIPAuthUser, s.cfg.SIPAuthPass), true
+}
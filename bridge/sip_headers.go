@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// parseCaptureHeaders checks the sip.capture_headers patterns: header
+// names, or name prefixes ending in "*" such as "X-*".
+func parseCaptureHeaders(patterns []string) ([]string, error) {
+	var out []string
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		name := strings.TrimSuffix(p, "*")
+		if name == "" || strings.ContainsAny(name, " :*\t") {
+			return nil, fmt.Errorf("invalid sip.capture_headers entry %q (a header name or prefix such as X-*)", p)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// headerMatches reports whether the header name matches a
+// sip.capture_headers pattern.
+func headerMatches(name string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+		}
+		return strings.EqualFold(name, p)
+	})
+}
+
+// captureHeaders returns the headers of req matching patterns, by name.
+// Repeated headers are joined with ", ".
+func captureHeaders(req *sip.Request, patterns []string) map[string]string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	var out map[string]string
+	for _, h := range req.Headers() {
+		if !headerMatches(h.Name(), patterns) {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		if v, ok := out[h.Name()]; ok {
+			out[h.Name()] = v + ", " + h.Value()
+		} else {
+			out[h.Name()] = h.Value()
+		}
+	}
+	return out
+}
+
+// headerLines renders captured headers for Telegram messages, one
+// "Name: value" line each.
+func headerLines(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+": "+headers[name])
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,225 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"gotgcalls/bridge/cdr"
+
+	"github.com/emiago/diago"
+)
+
+var (
+	ErrSIPNotRunning = errors.New("sip stack is not running")
+	ErrCallsActive   = errors.New("calls in progress")
+)
+
+// sipStackSettings are the settings RestartSIP applies by rebuilding the SIP
+// stack. Field names match Config.
+type sipStackSettings struct {
+	SIPBindPort         int
+	SIPUDPBindHosts     []string
+	SIPTCPBindHosts     []string
+	SIPExternalIP       string
+	SIPExternalIP6      string
+	SIPDetectExternalIP bool
+	SIPTCPFallback      bool
+	SIPConnectionReuse  bool
+}
+
+func (c Config) sipStackSettings() sipStackSettings {
+	return sipStackSettings{
+		SIPBindPort:         c.SIPBindPort,
+		SIPUDPBindHosts:     c.SIPUDPBindHosts,
+		SIPTCPBindHosts:     c.SIPTCPBindHosts,
+		SIPExternalIP:       c.SIPExternalIP,
+		SIPExternalIP6:      c.SIPExternalIP6,
+		SIPDetectExternalIP: c.SIPDetectExternalIP,
+		SIPTCPFallback:      c.SIPTCPFallback,
+		SIPConnectionReuse:  c.SIPConnectionReuse,
+	}
+}
+
+func (st sipStackSettings) apply(c *Config) {
+	c.SIPBindPort = st.SIPBindPort
+	c.SIPUDPBindHosts = st.SIPUDPBindHosts
+	c.SIPTCPBindHosts = st.SIPTCPBindHosts
+	c.SIPExternalIP = st.SIPExternalIP
+	c.SIPExternalIP6 = st.SIPExternalIP6
+	c.SIPDetectExternalIP = st.SIPDetectExternalIP
+	c.SIPTCPFallback = st.SIPTCPFallback
+	c.SIPConnectionReuse = st.SIPConnectionReuse
+}
+
+// SIPRestartResult describes a restart of the SIP stack.
+type SIPRestartResult struct {
+	// Changed lists the settings that differ from the ones the old stack
+	// ran with.
+	Changed []string `json:"changed"`
+	// HungUp is the number of calls a forced restart ended.
+	HungUp int `json:"hung_up"`
+	// Transports are the addresses listened on, as "udp4/0.0.0.0:5060".
+	Transports []string `json:"transports"`
+}
+
+type sipRestartRequest struct {
+	settings sipStackSettings
+	done     chan error
+}
+
+// RestartSIP re-reads the config file and rebuilds the SIP stack with its
+// transport settings (bind port and hosts, external IP, TCP fallback,
+// connection reuse), then registers again. The Telegram client and its
+// sessions are left alone; other settings need Reload or a full restart.
+//
+// Calls in progress would lose their SIP leg, so RestartSIP refuses with
+// ErrCallsActive unless force is set, which hangs them up first. When the
+// new settings don't come up (e.g. the port is taken), the stack is brought
+// back with the old ones and the error returned.
+func (s *Service) RestartSIP(force bool) (SIPRestartResult, error) {
+	var res SIPRestartResult
+	if s.configPath == "" {
+		return res, ErrNoConfigPath
+	}
+	cfg, err := LoadConfig(s.configPath)
+	if err != nil {
+		return res, err
+	}
+	if !s.sipServing.Load() || s.draining.Load() {
+		return res, ErrSIPNotRunning
+	}
+	if n := s.activeCalls.Load(); n > 0 {
+		if !force {
+			return res, fmt.Errorf("%w (%d)", ErrCallsActive, n)
+		}
+		s.logger.Info("sip: hanging up calls for restart", "active_calls", n)
+		res.HungUp = int(n)
+		if !s.hangupAll(cdr.CauseSIPRestart) {
+			s.logger.Warn("sip: calls still active after hangup", "active_calls", s.activeCalls.Load())
+		}
+	}
+	settings := cfg.sipStackSettings()
+	res.Changed = diffFields(*s.sipSettings.Load(), settings)
+	req := sipRestartRequest{settings: settings, done: make(chan error, 1)}
+	select {
+	case s.sipRestart <- req:
+	case <-s.sipDone:
+		return res, ErrSIPNotRunning
+	}
+	if err := <-req.done; err != nil {
+		return res, err
+	}
+	sipCfg := s.cfg
+	settings.apply(&sipCfg)
+	for _, t := range SIPTransports(sipCfg) {
+		res.Transports = append(res.Transports, t.Transport+"/"+net.JoinHostPort(t.BindHost, strconv.Itoa(t.BindPort)))
+	}
+	s.logger.Info("sip: stack restarted", "changed", res.Changed, "transports", res.Transports)
+	return res, nil
+}
+
+// serveSIP serves the SIP stack until ctx ends or a transport fails,
+// swapping in a new stack for each RestartSIP.
+func (s *Service) serveSIP(ctx context.Context) error {
+	defer close(s.sipDone)
+	stop, errs, err := s.listenSIP(ctx, s.sip.Load())
+	if err != nil {
+		return err
+	}
+	s.sipServing.Store(true)
+	defer s.sipServing.Store(false)
+	for {
+		select {
+		case err := <-errs:
+			stop()
+			return err
+		case req := <-s.sipRestart:
+			stop, errs, err = s.replaceSIP(ctx, stop, req.settings)
+			req.done <- err
+			if stop == nil {
+				return err
+			}
+		}
+	}
+}
+
+// listenSIP serves dg and waits until all its transports listen. errs
+// receives the error that ends serving; stop ends it.
+func (s *Service) listenSIP(ctx context.Context, dg *diago.Diago) (stop func(), errs <-chan error, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ready := make(chan struct{})
+	errCh := make(chan error, 1)
+	if len(s.cfg.SIPAccounts) > 0 {
+		dg.OnRegister(s.handleRegister)
+	}
+	if s.cfg.SMSEnabled {
+		dg.OnMessage(s.handleMessage)
+	}
+	go func() {
+		errCh <- dg.ServeReady(ctx, func(inDialog *diago.DialogServerSession) {
+			s.handleIncomingSIP(inDialog)
+		}, func() { close(ready) })
+	}()
+	select {
+	case <-ready:
+		return cancel, errCh, nil
+	case err := <-errCh:
+		cancel()
+		if err == nil {
+			err = ErrSIPNotRunning
+		}
+		return nil, nil, err
+	}
+}
+
+// replaceSIP stops the running SIP stack and brings up one with settings,
+// or one with the old settings again if that fails. stop is nil when
+// neither came up.
+func (s *Service) replaceSIP(ctx context.Context, stopOld func(), settings sipStackSettings) (stop func(), errs <-chan error, err error) {
+	old, oldSettings := s.sip.Load(), *s.sipSettings.Load()
+	s.logger.Info("sip: restarting stack", "changed", diffFields(oldSettings, settings))
+	s.unregister()
+	stopOld()
+	if err := old.Close(); err != nil {
+		s.logger.Debug("sip: closing old stack failed", "error", err)
+	}
+	stop, errs, err = s.startSIP(ctx, settings)
+	if err != nil {
+		s.logger.Warn("sip: new stack failed, restoring the previous settings", "error", err)
+		var restoreErr error
+		if stop, errs, restoreErr = s.startSIP(ctx, oldSettings); restoreErr != nil {
+			s.logger.Error("sip: restoring the previous stack failed", "error", restoreErr)
+			return nil, nil, errors.Join(err, restoreErr)
+		}
+	}
+	s.startRegistration(ctx)
+	return stop, errs, err
+}
+
+// startSIP builds a SIP stack with settings, serves it and makes it the
+// running one.
+func (s *Service) startSIP(ctx context.Context, settings sipStackSettings) (stop func(), errs <-chan error, err error) {
+	cfg := s.cfg
+	settings.apply(&cfg)
+	dg, err := NewSIPStack(cfg, s.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.SIPDetectExternalIP && hasIPv4Transport(cfg) && !s.providerIsLocal() {
+		if ip, err := s.detectExternalIP(); err != nil {
+			s.logger.Warn("sip: external IP detection failed, advertising the local address (set sip.external_ip)", "stun_server", s.cfg.STUNServer, "error", err)
+		} else {
+			dg.SetExternalIP(ip)
+		}
+	}
+	if stop, errs, err = s.listenSIP(ctx, dg); err != nil {
+		_ = dg.Close()
+		return nil, nil, err
+	}
+	s.sip.Store(dg)
+	s.sipSettings.Store(&settings)
+	return stop, errs, nil
+}
@@ -1,10 +1,14 @@
 package bridge
 
 import (
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
 
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 )
 
@@ -22,6 +26,75 @@ func splitHostPort(host string) (string, int) {
 	return host, 0
 }
 
+// NewSIPStack builds the SIP user agent with the transports and codecs of
+// cfg. RTP ports are set apart by ConfigureRTPPorts.
+func NewSIPStack(cfg Config, logger *slog.Logger) (*diago.Diago, error) {
+	ua, err := sipgo.NewUA(sipgo.WithUserAgentTransportLayerOptions(
+		sip.WithTransportLayerConnectionReuse(cfg.SIPConnectionReuse),
+	))
+	if err != nil {
+		return nil, err
+	}
+	opts := []diago.DiagoOption{
+		diago.WithLogger(logger),
+		diago.WithMediaConfig(diago.MediaConfig{
+			Codecs: SIPCodecs(cfg),
+		}),
+		diago.WithRel100(cfg.SIPRel100),
+	}
+	for _, t := range SIPTransports(cfg) {
+		opts = append(opts, diago.WithTransport(t))
+	}
+	return diago.NewDiago(ua, opts...), nil
+}
+
+// SIPTransports returns a UDP and TCP transport per configured bind address.
+// IPv4 and IPv6 listeners are kept apart (udp4/udp6) so both can use the
+// same port; calls pick the one matching the peer's address family and
+// advertise its address in Via, Contact and SDP.
+func SIPTransports(cfg Config) []diago.Transport {
+	var transports []diago.Transport
+	add := func(transport string, hosts []string) {
+		for _, host := range hosts {
+			t := diago.Transport{
+				Transport:    transport + "4",
+				BindHost:     host,
+				BindPort:     cfg.SIPBindPort,
+				ExternalHost: cfg.SIPExternalIP,
+				NoRport:      cfg.SIPQuirks.NoRport,
+				TCPFallback:  cfg.SIPTCPFallback,
+			}
+			if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+				t.Transport = transport + "6"
+				t.ExternalHost = cfg.SIPExternalIP6
+			}
+			t.ID = t.Transport + "/" + host
+			transports = append(transports, t)
+		}
+	}
+	add("udp", cfg.SIPUDPBindHosts)
+	add("tcp", cfg.SIPTCPBindHosts)
+	return transports
+}
+
+// ConfigureRTPPorts limits the local RTP ports of calls to rtp.port_min and
+// rtp.port_max. It sets media package globals, so call it once at startup.
+func ConfigureRTPPorts(cfg Config) {
+	if cfg.RTPPortMin == 0 {
+		return
+	}
+	media.RTPPortStart = cfg.RTPPortMin
+	media.RTPPortEnd = cfg.RTPPortMax + 1
+}
+
+// rtpNAT is the media.MediaSession.RTPNAT mode for rtp.symmetric.
+func (s *Service) rtpNAT() int {
+	if s.cfg.RTPSymmetric {
+		return media.RTPNATSymetric
+	}
+	return media.RTPNATDisabled
+}
+
 func SIPRegisterRecipient(cfg Config) sip.Uri {
 	host, port := splitHostPort(cfg.SIPProvider)
 	recipient := sip.Uri{
@@ -36,3 +109,9 @@ func SIPRegisterRecipient(cfg Config) sip.Uri {
 	}
 	return recipient
 }
+
+// dialogAnswered reports whether a 200 OK was sent for an inbound dialog
+// (e.g. by the IVR), after which it can only be ended with BYE.
+func dialogAnswered(d *diago.DialogServerSession) bool {
+	return d.LoadState() >= sip.DialogStateEstablished
+}
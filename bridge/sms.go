@@ -0,0 +1,179 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+)
+
+const (
+	// maxSMSText bounds the text of one SendSMS (ten concatenated SMS).
+	maxSMSText = 1530
+	// smsTimeout bounds sending one message.
+	smsTimeout = 30 * time.Second
+)
+
+var (
+	// ErrSMSDisabled is returned by SendSMS without sms.enabled.
+	ErrSMSDisabled = errors.New("text messages are disabled (sms.enabled)")
+	// ErrInvalidSMS is returned for a message without text or with too
+	// much of it, or to an invalid number.
+	ErrInvalidSMS = errors.New("invalid text message")
+)
+
+// handleMessage forwards the text of a SIP MESSAGE request (RFC 3428) to
+// the Telegram user. Senders are checked like callers: sip.auth_user
+// digest and callers.allow/deny.
+func (s *Service) handleMessage(req *sip.Request, tx sip.ServerTransaction) {
+	respond := func(status int, reason string) {
+		if err := tx.Respond(sip.NewResponseFromRequest(req, status, reason, nil)); err != nil {
+			s.logger.Warn("sip message: response failed", "error", err)
+		}
+	}
+	from, to := req.From(), req.To()
+	if from == nil || to == nil {
+		respond(sip.StatusBadRequest, "Bad Request")
+		return
+	}
+	number, local := from.Address.User, to.Address.User
+	logger := s.logger.With("sip_from", number, "sip_to", local, "source", req.Source())
+
+	if s.authServer != nil {
+		if auth, ok := s.inboundDigest(number); ok {
+			res, err := s.authServer.AuthorizeRequest(req, auth)
+			if err != nil || res.StatusCode != sip.StatusOK {
+				if err != nil {
+					logger.Warn("sip message auth failed", "error", err)
+				}
+				if err := tx.Respond(res); err != nil {
+					logger.Warn("sip message: response failed", "error", err)
+				}
+				return
+			}
+		}
+	}
+	if reason := s.screenCaller(number); reason != "" && !s.ringProfile(number).SkipScreening {
+		logger.Info("sip message rejected (sender blocked)", "reason", reason)
+		respond(sip.StatusForbidden, "Forbidden")
+		return
+	}
+	if ct := req.ContentType(); ct != nil {
+		mediaType, _, err := mime.ParseMediaType(ct.Value())
+		if err != nil || mediaType != "text/plain" {
+			respond(sip.StatusUnsupportedMediaType, "Unsupported Media Type")
+			return
+		}
+	}
+	text := strings.TrimSpace(string(req.Body()))
+	if text == "" {
+		respond(sip.StatusOK, "OK")
+		return
+	}
+	if s.tgClient == nil {
+		respond(sip.StatusTemporarilyUnavailable, "Temporarily Unavailable")
+		return
+	}
+
+	party := displayParty(s.callerName(number, from.DisplayName), number)
+	if _, err := s.tgClient.SendMessage(s.cfg.TGUserID, fmt.Sprintf("SMS from %s to %s:\n%s", party, local, text)); err != nil {
+		logger.Warn("sip message: telegram forward failed", "error", err)
+		respond(sip.StatusInternalServerError, "Server Internal Error")
+		return
+	}
+	logger.Info("sip message forwarded to telegram", "length", len(text))
+	respond(sip.StatusOK, "OK")
+}
+
+// SendSMS sends text to number: posted to sms.url when set, otherwise as a
+// SIP MESSAGE through the provider (or to the local SIP account).
+func (s *Service) SendSMS(ctx context.Context, number, text string) error {
+	if !s.cfg.SMSEnabled {
+		return ErrSMSDisabled
+	}
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return fmt.Errorf("%w: no text", ErrInvalidSMS)
+	case utf8.RuneCountInString(text) > maxSMSText:
+		return fmt.Errorf("%w: text longer than %d characters", ErrInvalidSMS, maxSMSText)
+	}
+	ctx, cancel := context.WithTimeout(ctx, smsTimeout)
+	defer cancel()
+	if s.cfg.SMSURL != "" {
+		to := normalizePhone(number)
+		if to == "" {
+			return fmt.Errorf("%w: invalid phone number", ErrInvalidSMS)
+		}
+		return s.sendSMSHTTP(ctx, to, text)
+	}
+	recipient, err := s.buildOutboundURI(number)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSMS, err)
+	}
+	res, err := s.sip.Load().Message(ctx, recipient, "text/plain;charset=UTF-8", []byte(text), diago.MessageOptions{
+		Username: s.cfg.SIPAuthUser,
+		Password: s.cfg.SIPAuthPass,
+		Headers:  s.smsHeaders(recipient),
+	})
+	if err != nil {
+		return fmt.Errorf("sip message: %w", err)
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("sip message: %d %s", res.StatusCode, res.Reason)
+	}
+	s.logger.Info("sip message sent", "number", recipient.User, "length", len(text))
+	return nil
+}
+
+// smsHeaders are the headers of a MESSAGE to recipient: at the SIP trunk,
+// the From of the account (sip.auth_user) and the headers INVITEs get.
+func (s *Service) smsHeaders(recipient sip.Uri) []sip.Header {
+	if !s.isTrunkURI(recipient) {
+		return nil
+	}
+	var headers []sip.Header
+	if s.cfg.SIPAuthUser != "" {
+		headers = append(headers, s.trunkFrom(s.cfg.SIPAuthUser))
+	}
+	msg := &Call{ID: newCallID(), Direction: CallOutbound, Local: s.cfg.SIPAuthUser, ChatID: s.cfg.TGUserID}
+	return append(headers, s.trunkHeaders(recipient, msg)...)
+}
+
+// sendSMSHTTP posts {"to": to, "text": text} to sms.url, a provider's SMS
+// API or a script in front of one.
+func (s *Service) sendSMSHTTP(ctx context.Context, to, text string) error {
+	body, err := json.Marshal(map[string]string{"to": to, "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.SMSURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.SMSHeaders {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("sms api returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	s.logger.Info("sms sent", "number", to, "length", len(text))
+	return nil
+}
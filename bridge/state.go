@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/store"
+)
+
+// Buckets of the state store (storage.state_file).
+const (
+	bucketCDR       = "cdr"
+	bucketVoicemail = "voicemail"
+	bucketSchedule  = "scheduled_calls"
+	bucketContacts  = "contacts"
+	bucketState     = "state"
+)
+
+// stateRegistration is the key of the last SIP registration in bucketState.
+const stateRegistration = "registration"
+
+var ErrNoContactAlias = errors.New("no such contact alias")
+
+// SetStateStore sets the store that keeps call records, voicemail, scheduled
+// calls, contact aliases and the registration across restarts. Without one
+// they are kept in memory. Must be called before Start.
+func (s *Service) SetStateStore(db *store.DB) {
+	s.state = db
+}
+
+// stateSink keeps CDRs in the state store, up to limit of them.
+type stateSink struct {
+	db    *store.DB
+	limit int
+}
+
+func (s stateSink) Name() string { return "state" }
+
+func (s stateSink) Write(rec cdr.Record) error {
+	// Keys sort by end time.
+	key := rec.EndTime.UTC().Format("20060102T150405.000000000") + "_" + rec.ID
+	if err := s.db.Put(bucketCDR, key, rec); err != nil {
+		return err
+	}
+	if keys := s.db.Keys(bucketCDR); len(keys) > s.limit {
+		for _, k := range keys[:len(keys)-s.limit] {
+			if err := s.db.Delete(bucketCDR, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s stateSink) Close() error { return nil }
+
+// CallHistory returns the records of the last limit calls from the state
+// store, newest first.
+func (s *Service) CallHistory(limit int) []cdr.Record {
+	keys := s.state.Keys(bucketCDR)
+	var records []cdr.Record
+	for i := len(keys) - 1; i >= 0 && len(records) < limit; i-- {
+		var rec cdr.Record
+		if ok, err := s.state.Get(bucketCDR, keys[i], &rec); ok && err == nil {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// VoicemailRecord describes a voicemail message left with the bridge.
+type VoicemailRecord struct {
+	CallID string    `json:"call_id"`
+	Number string    `json:"number"`
+	Name   string    `json:"name,omitempty"`
+	Time   time.Time `json:"time"`
+	// Length is the message length in seconds.
+	Length float64 `json:"length"`
+	File   string  `json:"file"`
+	// Delivered reports whether the message was sent to Telegram.
+	Delivered bool `json:"delivered"`
+}
+
+func (s *Service) saveVoicemail(rec VoicemailRecord) {
+	key := rec.Time.UTC().Format("20060102T150405") + "_" + rec.CallID
+	if err := s.state.Put(bucketVoicemail, key, rec); err != nil {
+		s.logger.Warn("state: voicemail save failed", "error", err)
+	}
+}
+
+// Voicemails returns the last limit voicemail messages, newest first.
+func (s *Service) Voicemails(limit int) []VoicemailRecord {
+	keys := s.state.Keys(bucketVoicemail)
+	var records []VoicemailRecord
+	for i := len(keys) - 1; i >= 0 && len(records) < limit; i-- {
+		var rec VoicemailRecord
+		if ok, err := s.state.Get(bucketVoicemail, keys[i], &rec); ok && err == nil {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// ContactAliases returns the names given to numbers at runtime (/alias).
+func (s *Service) ContactAliases() map[string]string {
+	aliases := map[string]string{}
+	_ = s.state.ForEach(bucketContacts, func(number string, value json.RawMessage) error {
+		var name string
+		if json.Unmarshal(value, &name) == nil {
+			aliases[number] = name
+		}
+		return nil
+	})
+	return aliases
+}
+
+// SetContactAlias names number for caller ID, ahead of contacts.names.
+func (s *Service) SetContactAlias(number, name string) error {
+	number, name = normalizePhone(number), strings.TrimSpace(name)
+	if phoneDigits(number) == "" || name == "" {
+		return errors.New("a number and a name are required")
+	}
+	return s.state.Put(bucketContacts, number, name)
+}
+
+// RemoveContactAlias drops the alias of number.
+func (s *Service) RemoveContactAlias(number string) error {
+	number = normalizePhone(number)
+	var name string
+	if ok, _ := s.state.Get(bucketContacts, number, &name); !ok {
+		return ErrNoContactAlias
+	}
+	return s.state.Delete(bucketContacts, number)
+}
+
+// saveRegistration keeps the registration state, so a restart keeps to the
+// retry delay the registrar asked for.
+func (s *Service) saveRegistration(r Registration) {
+	if err := s.state.Put(bucketState, stateRegistration, r); err != nil {
+		s.logger.Warn("state: registration save failed", "error", err)
+	}
+}
+
+// savedRegistration returns the registration state saved by the last run.
+func (s *Service) savedRegistration() (Registration, bool) {
+	var r Registration
+	ok, err := s.state.Get(bucketState, stateRegistration, &r)
+	return r, ok && err == nil
+}
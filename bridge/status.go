@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gotgcalls/third_party/ntgcalls"
+)
+
+// Status describes the running bridge and the call protocol it negotiates.
+type Status struct {
+	NTgCallsVersion string                `json:"ntgcalls_version"`
+	Protocol        ProtocolInfo          `json:"protocol"`
+	ProtocolCheck   string                `json:"protocol_check"`
+	ActiveCalls     int                   `json:"active_calls"`
+	Registration    Registration          `json:"registration"`
+	Accounts        []AccountRegistration `json:"accounts,omitempty"`
+	Peers           []PeerProtocol        `json:"peers,omitempty"`
+	Latency         []CallLatency         `json:"latency,omitempty"`
+	Uptime          string                `json:"uptime"`
+	Draining        bool                  `json:"draining,omitempty"`
+}
+
+type ProtocolInfo struct {
+	MinLayer        int32    `json:"min_layer"`
+	MaxLayer        int32    `json:"max_layer"`
+	LibraryVersions []string `json:"library_versions"`
+}
+
+// PeerProtocol is the protocol announced by the Telegram peer of an active call.
+type PeerProtocol struct {
+	CallID string `json:"call_id"`
+	ChatID int64  `json:"chat_id"`
+	ProtocolInfo
+}
+
+func protocolInfo(p ntgcalls.Protocol) ProtocolInfo {
+	return ProtocolInfo{MinLayer: p.MinLayer, MaxLayer: p.MaxLayer, LibraryVersions: p.Versions}
+}
+
+func (s *Service) Status() Status {
+	calls := s.Calls()
+	st := Status{
+		NTgCallsVersion: ntgcalls.Version(),
+		Protocol:        protocolInfo(ntgcalls.GetProtocol()),
+		ProtocolCheck:   s.cfg.TGProtocolCheck,
+		ActiveCalls:     len(calls),
+		Registration:    s.Registration(),
+		Accounts:        s.AccountRegistrations(),
+		Uptime:          time.Since(s.startedAt).Round(time.Second).String(),
+		Draining:        s.Draining(),
+	}
+	for _, c := range calls {
+		if p, ok := s.tg.PeerProtocol(c.ChatID); ok {
+			st.Peers = append(st.Peers, PeerProtocol{CallID: c.ID, ChatID: c.ChatID, ProtocolInfo: protocolInfo(p)})
+		}
+		if call, ok := s.Call(c.ID); ok {
+			if ms, ok := call.Stats(); ok {
+				st.Latency = append(st.Latency, callLatency(c.ID, ms))
+			}
+		}
+	}
+	return st
+}
+
+// String renders the status for Telegram replies.
+func (st Status) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ntgcalls %s\n", st.NTgCallsVersion)
+	fmt.Fprintf(&b, "layers %d-%d, versions %s\n", st.Protocol.MinLayer, st.Protocol.MaxLayer, strings.Join(st.Protocol.LibraryVersions, ", "))
+	fmt.Fprintf(&b, "protocol check: %s\n", st.ProtocolCheck)
+	fmt.Fprintf(&b, "active calls: %d, uptime %s", st.ActiveCalls, st.Uptime)
+	if st.Draining {
+		b.WriteString(" (shutting down)")
+	}
+	if r := st.Registration; r.State != RegistrationDisabled {
+		fmt.Fprintf(&b, "\nsip registration: %s", r.State)
+		switch {
+		case r.State == RegistrationRegistered:
+			fmt.Fprintf(&b, ", expires in %s", time.Until(r.Expires).Round(time.Second))
+		case r.LastError != "":
+			fmt.Fprintf(&b, " (%d in a row, %s), retry in %s", r.Failures, r.LastError, max(time.Until(r.NextRetry), 0).Round(time.Second))
+		}
+	}
+	for _, a := range st.Accounts {
+		fmt.Fprintf(&b, "\nsip account %s: %s, expires in %s", a.Username, a.Target, time.Until(a.Expires).Round(time.Second))
+		if a.UserAgent != "" {
+			fmt.Fprintf(&b, " (%s)", a.UserAgent)
+		}
+	}
+	for _, p := range st.Peers {
+		fmt.Fprintf(&b, "\npeer %d (call %s): layers %d-%d, versions %s", p.ChatID, p.CallID, p.MinLayer, p.MaxLayer, strings.Join(p.LibraryVersions, ", "))
+	}
+	for _, l := range st.Latency {
+		b.WriteString("\n" + l.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package storage
+
+func FreeSpace(string) (uint64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,206 @@
+// Package storage keeps directories of call artifacts (recordings,
+// voicemails) within a retention period and size quota, deleting the oldest
+// files first, and watches the free space left on their filesystem.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Options configures a Manager. Zero values disable the respective limit.
+type Options struct {
+	// Dirs are the directories to manage; files in subdirectories count too.
+	Dirs []string
+	// MaxAge deletes files last modified longer ago than this.
+	MaxAge time.Duration
+	// MaxBytes caps the total size of all Dirs.
+	MaxBytes int64
+	// MinFree is the free space below which HasSpace reports false.
+	MinFree uint64
+	// AlertFree is the free space below which the alert callback fires.
+	AlertFree uint64
+}
+
+// activeGrace protects files that are still being written: anything
+// modified this recently is never deleted.
+const activeGrace = time.Minute
+
+// ErrUnsupported is returned by FreeSpace on platforms without statfs.
+var ErrUnsupported = errors.New("free space check not supported on this platform")
+
+// Usage is the result of a sweep.
+type Usage struct {
+	Files        int
+	Bytes        int64
+	DeletedFiles int
+	DeletedBytes int64
+	// Free is the free space of the first directory, 0 if unknown.
+	Free uint64
+}
+
+// Manager enforces Options on its directories.
+type Manager struct {
+	opts   Options
+	logger *slog.Logger
+	alert  func(string)
+
+	mu       sync.Mutex
+	alerting bool
+}
+
+func New(opts Options, logger *slog.Logger) *Manager {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Manager{opts: opts, logger: logger}
+}
+
+// OnAlert sets the callback for low disk space warnings. It fires once when
+// free space drops below Options.AlertFree and again only after it
+// recovered in between.
+func (m *Manager) OnAlert(fn func(text string)) {
+	m.alert = fn
+}
+
+// HasSpace reports whether there is room for new files. It is true when
+// MinFree is unset or free space cannot be determined.
+func (m *Manager) HasSpace() bool {
+	if m == nil || m.opts.MinFree == 0 || len(m.opts.Dirs) == 0 {
+		return true
+	}
+	free, err := FreeSpace(m.opts.Dirs[0])
+	return err != nil || free >= m.opts.MinFree
+}
+
+// Run sweeps every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Sweep(time.Now()); err != nil {
+			m.logger.Warn("storage: sweep failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Sweep deletes files past MaxAge, then the oldest files until the total is
+// within MaxBytes, and checks free space against AlertFree.
+func (m *Manager) Sweep(now time.Time) (Usage, error) {
+	var usage Usage
+	var files []file
+	for _, dir := range m.opts.Dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files = append(files, file{path: path, size: info.Size(), modTime: info.ModTime()})
+			usage.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return usage, err
+		}
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+
+	for _, f := range files {
+		if now.Sub(f.modTime) < activeGrace {
+			break
+		}
+		expired := m.opts.MaxAge > 0 && now.Sub(f.modTime) > m.opts.MaxAge
+		overQuota := m.opts.MaxBytes > 0 && usage.Bytes > m.opts.MaxBytes
+		if !expired && !overQuota {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			m.logger.Warn("storage: delete failed", "path", f.path, "error", err)
+			continue
+		}
+		usage.Bytes -= f.size
+		usage.DeletedFiles++
+		usage.DeletedBytes += f.size
+	}
+	usage.Files = len(files) - usage.DeletedFiles
+	if usage.DeletedFiles > 0 {
+		m.logger.Info("storage: deleted old files", "files", usage.DeletedFiles, "bytes", usage.DeletedBytes, "remaining_bytes", usage.Bytes)
+	}
+
+	if len(m.opts.Dirs) > 0 {
+		free, err := FreeSpace(m.opts.Dirs[0])
+		if err == nil {
+			usage.Free = free
+			m.checkFree(free)
+		} else if !errors.Is(err, ErrUnsupported) && !errors.Is(err, fs.ErrNotExist) {
+			m.logger.Warn("storage: free space check failed", "error", err)
+		}
+	}
+	return usage, nil
+}
+
+func (m *Manager) checkFree(free uint64) {
+	if m.opts.AlertFree == 0 {
+		return
+	}
+	m.mu.Lock()
+	low := free < m.opts.AlertFree
+	fire := low && !m.alerting
+	m.alerting = low
+	m.mu.Unlock()
+	if !fire {
+		return
+	}
+	text := "Low disk space: " + FormatBytes(free) + " free in " + m.opts.Dirs[0]
+	if m.opts.MinFree > 0 && free < m.opts.MinFree {
+		text += ", new recordings are paused"
+	}
+	m.logger.Warn("storage: low disk space", "free_bytes", free, "dir", m.opts.Dirs[0])
+	if m.alert != nil {
+		m.alert(text)
+	}
+}
+
+// FormatBytes renders n like "1.5 GB".
+func FormatBytes[T int64 | uint64](n T) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		value /= unit
+		if value < unit || suffix == "TB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"context"
+	"log/slog"
+
+	"gotgcalls/bridge/storage"
+)
+
+func newStorageManager(cfg Config, logger *slog.Logger) *storage.Manager {
+	return storage.New(storage.Options{
+		Dirs:      []string{cfg.RecordingDir, cfg.VoicemailDir},
+		MaxAge:    cfg.StorageRetention,
+		MaxBytes:  cfg.StorageMaxBytes,
+		MinFree:   cfg.StorageMinFree,
+		AlertFree: cfg.StorageAlertFree,
+	}, logger)
+}
+
+// startStorageGuard applies the storage limits in the background and sends
+// low disk space alerts to the Telegram user. It does nothing when no limit
+// is configured.
+func (s *Service) startStorageGuard(ctx context.Context) {
+	cfg := s.cfg
+	if cfg.StorageRetention == 0 && cfg.StorageMaxBytes == 0 && cfg.StorageAlertFree == 0 {
+		return
+	}
+	s.storage.OnAlert(func(text string) {
+		s.notify(cfg.TGUserID, text)
+	})
+	go s.storage.Run(ctx, cfg.StorageCheckInterval)
+}
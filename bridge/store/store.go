@@ -0,0 +1,259 @@
+// Package store is the embedded store for the state the bridge keeps across
+// restarts: call records, voicemail, scheduled calls, contact aliases and
+// the SIP registration. Values are JSON documents in named buckets, held in
+// memory and appended to a single log file that is compacted when it has
+// grown well past its live data. It needs no database server and no cgo.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// compactMin is the least number of stale records that triggers a
+// compaction; below it the log is left alone.
+const compactMin = 1000
+
+// DB is an open store. Its methods may be called from different goroutines.
+type DB struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	buckets map[string]map[string]json.RawMessage
+	// live and stale count the records in the log file that hold a current
+	// value and that were overwritten or deleted since.
+	live, stale int
+}
+
+// record is one line of the log file.
+type record struct {
+	Bucket string          `json:"b"`
+	Key    string          `json:"k"`
+	Value  json.RawMessage `json:"v,omitempty"`
+	Delete bool            `json:"d,omitempty"`
+}
+
+// Memory returns a store that isn't written anywhere.
+func Memory() *DB {
+	return &DB{buckets: map[string]map[string]json.RawMessage{}}
+}
+
+// Open opens the store at path, creating it if needed; "" returns Memory().
+// A last line cut short by a crash is dropped.
+func Open(path string) (*DB, error) {
+	db := Memory()
+	if path == "" {
+		return db, nil
+	}
+	db.path = path
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if i == len(lines)-1 {
+				// The write of the last record was interrupted.
+				break
+			}
+			return nil, fmt.Errorf("%s: line %d: %w", path, i+1, err)
+		}
+		db.apply(rec)
+	}
+	// Start from a compact file, which also drops a torn last line.
+	if err := db.compact(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) apply(rec record) {
+	b := db.buckets[rec.Bucket]
+	if _, ok := b[rec.Key]; ok {
+		db.live--
+		db.stale++
+	}
+	if rec.Delete {
+		db.stale++
+		delete(b, rec.Key)
+		return
+	}
+	if b == nil {
+		b = map[string]json.RawMessage{}
+		db.buckets[rec.Bucket] = b
+	}
+	b[rec.Key] = rec.Value
+	db.live++
+}
+
+// Put stores v as JSON under key in bucket.
+func (db *DB) Put(bucket, key string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return db.write(record{Bucket: bucket, Key: key, Value: value})
+}
+
+// Delete removes key from bucket; deleting a missing key is not an error.
+func (db *DB) Delete(bucket, key string) error {
+	db.mu.Lock()
+	_, ok := db.buckets[bucket][key]
+	db.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return db.write(record{Bucket: bucket, Key: key, Delete: true})
+}
+
+func (db *DB) write(rec record) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f != nil {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		fi, err := db.f.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := db.f.Write(append(line, '\n')); err != nil {
+			// Cut off whatever part of the record made it to the file, so
+			// the next record doesn't follow a torn line.
+			if terr := db.f.Truncate(fi.Size()); terr != nil {
+				return errors.Join(err, terr)
+			}
+			return err
+		}
+		if err := db.f.Sync(); err != nil {
+			return err
+		}
+	}
+	db.apply(rec)
+	if db.f != nil && db.stale >= compactMin && db.stale > db.live {
+		return db.compact()
+	}
+	return nil
+}
+
+// Get decodes the value of key in bucket into v and reports whether there
+// was one.
+func (db *DB) Get(bucket, key string, v any) (bool, error) {
+	db.mu.Lock()
+	value, ok := db.buckets[bucket][key]
+	db.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// Keys returns the keys of bucket in ascending order.
+func (db *DB) Keys(bucket string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := make([]string, 0, len(db.buckets[bucket]))
+	for k := range db.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Len returns the number of keys in bucket.
+func (db *DB) Len(bucket string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.buckets[bucket])
+}
+
+// ForEach calls fn with the keys and values of bucket in ascending key
+// order, stopping at the first error.
+func (db *DB) ForEach(bucket string, fn func(key string, value json.RawMessage) error) error {
+	db.mu.Lock()
+	values := make(map[string]json.RawMessage, len(db.buckets[bucket]))
+	for k, v := range db.buckets[bucket] {
+		values[k] = v
+	}
+	db.mu.Unlock()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log file with the live records only and reopens it
+// for appending. Called with mu held (or before the DB is shared).
+func (db *DB) compact() error {
+	tmp := db.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	live := 0
+	for bucket, values := range db.buckets {
+		for key, value := range values {
+			if err := enc.Encode(record{Bucket: bucket, Key: key, Value: value}); err != nil {
+				f.Close()
+				return err
+			}
+			live++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return err
+	}
+	if db.f != nil {
+		_ = db.f.Close()
+	}
+	db.f, err = os.OpenFile(db.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	db.live, db.stale = live, 0
+	return nil
+}
+
+// Close closes the log file; the DB must not be used afterwards.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return nil
+	}
+	err := db.f.Close()
+	db.f = nil
+	return err
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		wantErr bool
+		want    map[string]string
+	}{
+		{
+			name: "torn last line",
+			log:  `{"b":"calls","k":"a","v":1}` + "\n" + `{"b":"calls","k":"b","v":2}` + "\n" + `{"b":"calls","k":"c","v`,
+			want: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:    "corrupt middle line",
+			log:     `{"b":"calls","k":"a","v":1}` + "\n" + `{"b":"calls",` + "\n" + `{"b":"calls","k":"b","v":2}` + "\n",
+			wantErr: true,
+		},
+		{
+			name: "overwrite and delete",
+			log:  `{"b":"calls","k":"a","v":1}` + "\n" + `{"b":"calls","k":"a","v":3}` + "\n" + `{"b":"calls","k":"b","v":2}` + "\n" + `{"b":"calls","k":"b","d":true}` + "\n",
+			want: map[string]string{"a": "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.db")
+			if err := os.WriteFile(path, []byte(tt.log), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			db, err := Open(path)
+			if tt.wantErr {
+				if err == nil {
+					db.Close()
+					t.Fatal("Open succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			checkBucket(t, db, "calls", tt.want)
+			// Open compacts, so the file holds just the live records.
+			if got := countLines(t, path); got != len(tt.want) {
+				t.Errorf("log has %d lines after Open, want %d", got, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCounting(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	steps := []struct {
+		name        string
+		do          func() error
+		live, stale int
+	}{
+		{"put a", func() error { return db.Put("b", "a", 1) }, 1, 0},
+		{"put b", func() error { return db.Put("b", "b", 2) }, 2, 0},
+		{"overwrite a", func() error { return db.Put("b", "a", 3) }, 2, 1},
+		{"delete b", func() error { return db.Delete("b", "b") }, 1, 3},
+		{"delete missing", func() error { return db.Delete("b", "b") }, 1, 3},
+		{"put b again", func() error { return db.Put("b", "b", 4) }, 2, 3},
+	}
+	for _, s := range steps {
+		if err := s.do(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if db.live != s.live || db.stale != s.stale {
+			t.Errorf("%s: live, stale = %d, %d, want %d, %d", s.name, db.live, db.stale, s.live, s.stale)
+		}
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", "keep", "k"); err != nil {
+		t.Fatal(err)
+	}
+	// Each overwrite of "x" leaves one stale record; the last one crosses
+	// compactMin and the log is rewritten.
+	for i := range compactMin + 1 {
+		if err := db.Put("b", "x", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.stale >= compactMin {
+		t.Errorf("stale = %d after crossing compactMin, want a compacted log", db.stale)
+	}
+	if got := countLines(t, path); got != 2 {
+		t.Errorf("log has %d lines after compaction, want 2", got)
+	}
+	// Writes after compaction go to the new file.
+	if err := db.Put("b", "after", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkBucket(t, db, "b", map[string]string{"keep": `"k"`, "x": "1000", "after": `"a"`})
+}
+
+func checkBucket(t *testing.T, db *DB, bucket string, want map[string]string) {
+	t.Helper()
+	if got := db.Len(bucket); got != len(want) {
+		t.Errorf("Len(%q) = %d, want %d", bucket, got, len(want))
+	}
+	for k, v := range want {
+		var got any
+		ok, err := db.Get(bucket, k, &got)
+		if err != nil || !ok {
+			t.Errorf("Get(%q, %q) = %v, %v", bucket, k, ok, err)
+			continue
+		}
+		var wantV any
+		if err := json.Unmarshal([]byte(v), &wantV); err != nil {
+			t.Fatal(err)
+		}
+		if got != wantV {
+			t.Errorf("Get(%q, %q) = %v, want %v", bucket, k, got, wantV)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
@@ -0,0 +1,158 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Minimal RFC 5389 STUN binding client. We only need the reflexive transport
+// address, so no auth, fingerprint or retransmission schedule is implemented.
+
+const (
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunAttrMappedAddr   = 0x0001
+	stunAttrXORMapped    = 0x0020
+	stunHeaderSize       = 20
+	defaultSTUNServer    = "stun.l.google.com:19302"
+	defaultSTUNAttempts  = 3
+	defaultSTUNAttemptTO = time.Second
+)
+
+// stunBinding sends a binding request from conn to server and returns the
+// mapped (public) address as seen by the server.
+func stunBinding(conn net.PacketConn, server string) (*net.UDPAddr, error) {
+	raddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("resolve stun server: %w", err)
+	}
+
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:4], 0)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	txID := req[8:20]
+	if _, err := rand.Read(txID); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < defaultSTUNAttempts; attempt++ {
+		if _, err := conn.WriteTo(req, raddr); err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(defaultSTUNAttemptTO))
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			addr, err := parseSTUNResponse(buf[:n], txID)
+			if err != nil {
+				// Not our response (or garbage) - keep waiting.
+				continue
+			}
+			_ = conn.SetReadDeadline(time.Time{})
+			return addr, nil
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return nil, errors.New("stun: no response")
+}
+
+// STUNMappedAddress binds an ephemeral UDP socket and resolves its public address.
+func STUNMappedAddress(server string) (*net.UDPAddr, error) {
+	if server == "" {
+		server = defaultSTUNServer
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return stunBinding(conn, server)
+}
+
+func parseSTUNResponse(msg []byte, txID []byte) (*net.UDPAddr, error) {
+	if len(msg) < stunHeaderSize {
+		return nil, errors.New("stun: short message")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingSuccess {
+		return nil, errors.New("stun: not a binding success")
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || string(msg[8:20]) != string(txID) {
+		return nil, errors.New("stun: transaction mismatch")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if stunHeaderSize+length > len(msg) {
+		return nil, errors.New("stun: truncated message")
+	}
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		alen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+alen > len(attrs) {
+			break
+		}
+		val := attrs[4 : 4+alen]
+		switch typ {
+		case stunAttrXORMapped:
+			if addr := decodeSTUNAddress(val, msg[4:20], true); addr != nil {
+				return addr, nil
+			}
+		case stunAttrMappedAddr:
+			mapped = decodeSTUNAddress(val, nil, false)
+		}
+		// Attributes are padded to 4 bytes.
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, errors.New("stun: no mapped address")
+}
+
+func decodeSTUNAddress(val []byte, xorKey []byte, xor bool) *net.UDPAddr {
+	if len(val) < 4 {
+		return nil
+	}
+	family := val[1]
+	port := binary.BigEndian.Uint16(val[2:4])
+	var ip net.IP
+	switch family {
+	case 0x01:
+		if len(val) < 8 {
+			return nil
+		}
+		ip = append(net.IP(nil), val[4:8]...)
+	case 0x02:
+		if len(val) < 20 {
+			return nil
+		}
+		ip = append(net.IP(nil), val[4:20]...)
+	default:
+		return nil
+	}
+	if xor {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
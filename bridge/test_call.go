@@ -0,0 +1,349 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo"
+	msdk "github.com/livekit/media-sdk"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/probe"
+)
+
+// ErrNoTestNumber is returned by TestCall without a number when
+// test_call.number is not configured.
+var ErrNoTestNumber = errors.New("no echo test number (test_call.number)")
+
+const (
+	// The test signal: testLead of silence while media settles, a chirp to
+	// time the echo, testGap, then a steady tone to check its level and
+	// continuity in testBlock steps.
+	testLead     = 500 * time.Millisecond
+	testGap      = 300 * time.Millisecond
+	testToneHz   = 1000
+	testToneDur  = 2 * time.Second
+	testToneGain = 0.3
+	testBlock    = 100 * time.Millisecond
+	// testListen is how long the echo is awaited after the signal; it
+	// bounds the round trip that can be measured.
+	testListen = 2 * time.Second
+	// testDropoutDB is how far below the typical level a tone block must
+	// fall to count as a dropout.
+	testDropoutDB = 10
+)
+
+// testBand is the probe chirp made louder: nothing else is playing.
+var testBand = probe.Band{From: probe.Voiceband.From, To: probe.Voiceband.To, Gain: 0.5}
+
+// TestCallResult is the outcome of a call to an echo test number.
+type TestCallResult struct {
+	Number string
+	// Err is set when the call could not be placed or answered.
+	Err   error
+	Codec string
+	// LevelDB is the level of everything received, in dBFS.
+	LevelDB float64
+	// EchoHeard reports whether the chirp came back; Correlation is how
+	// closely the best match resembled it and RoundTrip its delay.
+	EchoHeard   bool
+	Correlation float64
+	RoundTrip   time.Duration
+	// EchoLevelDB is the echoed tone relative to the sent one; Dropouts of
+	// the tone's Blocks were much quieter than the rest.
+	EchoLevelDB float64
+	Dropouts    int
+	Blocks      int
+}
+
+// Healthy reports whether audio made it to the far end and back intact.
+func (r TestCallResult) Healthy() bool {
+	return r.Err == nil && r.EchoHeard && r.Dropouts == 0
+}
+
+func (r TestCallResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("Test call to %s failed: %v", r.Number, r.Err)
+	}
+	var b strings.Builder
+	switch {
+	case !r.EchoHeard:
+		fmt.Fprintf(&b, "Test call to %s: NO ECHO (one-way or no audio)", r.Number)
+	case r.Dropouts > 0:
+		fmt.Fprintf(&b, "Test call to %s: DEGRADED", r.Number)
+	default:
+		fmt.Fprintf(&b, "Test call to %s: OK", r.Number)
+	}
+	fmt.Fprintf(&b, "\ncodec %s, received level %.1f dBFS", r.Codec, r.LevelDB)
+	if r.EchoHeard {
+		fmt.Fprintf(&b, "\nround trip %s (correlation %.2f)", r.RoundTrip.Round(time.Millisecond), r.Correlation)
+		fmt.Fprintf(&b, "\ntone echo %.1f dB, %d/%d blocks dropped", r.EchoLevelDB, r.Dropouts, r.Blocks)
+	} else {
+		fmt.Fprintf(&b, "\nbest correlation %.2f", r.Correlation)
+	}
+	return b.String()
+}
+
+// TestCall calls number (test_call.number when empty), plays a chirp and a
+// tone and checks what the far end echoes back. It returns once the call is
+// over; failures to set it up are reported in the result.
+func (s *Service) TestCall(ctx context.Context, number string) TestCallResult {
+	if number == "" {
+		number = s.cfg.TestCallNumber
+	}
+	res := TestCallResult{Number: normalizePhone(number)}
+	if number == "" {
+		res.Err = ErrNoTestNumber
+		return res
+	}
+	call, err := s.prepareOutboundCall(0, number, "")
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer s.activeCalls.Add(-1)
+	defer s.unregisterCall(call)
+	logger := s.logger.With("dial", call.Number, "bridge_call_id", call.ID, "test_call", true)
+
+	sipMedia, release, err := s.dialSIP(ctx, call, logger)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer release()
+	res.Codec = sipMedia.Codec.Name
+
+	rate := s.tgFormat().SampleRate
+	signal, chirpAt, toneAt := testSignal(rate)
+	var (
+		mu    sync.Mutex
+		heard []int16
+	)
+	port := newLocalPort(s.tgFormat(), func(frame []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := 0; i+1 < len(frame); i += 2 {
+			heard = append(heard, int16(uint16(frame[i])|uint16(frame[i+1])<<8))
+		}
+		return nil
+	})
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, port, tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		call.setCause(cdr.CauseMediaFailure)
+		res.Err = err
+		return res
+	}
+	bridge.PlaySIP(signal.Once())
+	bridge.Start()
+	call.setMedia(bridge)
+
+	timer := time.NewTimer(clipLength(signal) + testListen)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		call.setCause(cdr.CauseLocalHangup)
+	case <-call.sipDone():
+		call.setCause(call.sipHangupCause())
+	case <-call.Done():
+		call.setCause(cdr.CauseLocalHangup)
+	case <-ctx.Done():
+		call.setCause(cdr.CauseLocalHangup)
+	}
+	bridge.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	analyzeEcho(&res, heard, rate, chirpAt, toneAt)
+	logger.Info("test call: done", "healthy", res.Healthy(), "echo", res.EchoHeard, "correlation", res.Correlation,
+		"round_trip", res.RoundTrip, "echo_level_db", res.EchoLevelDB, "dropouts", res.Dropouts)
+	return res
+}
+
+// dialSIP places the SIP leg of call without a Telegram side: it dials
+// call.Number, waits for the answer and sets up the media. release hangs up
+// (unless the far end did) and frees the media. On errors the hangup cause
+// of call is set.
+func (s *Service) dialSIP(ctx context.Context, call *Call, logger *slog.Logger) (sipMedia *endpoints.SipEndpoint, release func(), err error) {
+	callCtx, cancel := context.WithTimeout(ctx, s.Tunables().EstablishTimeout)
+	defer cancel()
+	stopAbort := context.AfterFunc(call.ctx, cancel)
+	defer stopAbort()
+
+	recipient, err := s.buildOutboundURI(call.Number)
+	if err != nil {
+		call.setCause(cdr.CauseSIPFailure)
+		return nil, nil, err
+	}
+	s.setCallState(call, CallRinging)
+	dialog, earlyMedia, err := s.inviteFollowingRedirects(callCtx, recipient, logger, call)
+	if err != nil {
+		logger.Warn("sip invite failed", "error", err)
+		call.setCause(outboundFailureCause(call, err))
+		return nil, nil, err
+	}
+	call.setSIPDialog(dialog)
+	call.setSIPCallID(sipCallID(dialog))
+	hangup := func() {
+		defer dialog.Close()
+		if dialog.Context().Err() != nil {
+			return
+		}
+		byeCtx, byeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer byeCancel()
+		if err := dialog.Hangup(byeCtx); err != nil {
+			logger.Warn("sip hangup failed", "error", err)
+		}
+	}
+	if earlyMedia {
+		if err := dialog.WaitAnswer(callCtx, sipgo.AnswerOptions{}); err != nil {
+			call.setCause(outboundFailureCause(call, err))
+			dialog.Close()
+			return nil, nil, err
+		}
+		if err := dialog.Ack(callCtx); err != nil {
+			call.setCause(cdr.CauseSIPFailure)
+			hangup()
+			return nil, nil, err
+		}
+	}
+	s.setCallState(call, CallAnswered)
+
+	sipMedia, err = endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		call.setCause(cdr.CauseMediaFailure)
+		hangup()
+		return nil, nil, err
+	}
+	call.setCodec(sipMedia.Codec.Name)
+	return sipMedia, func() {
+		sipMedia.Close()
+		hangup()
+	}, nil
+}
+
+// testSignal builds the test signal and returns where its chirp and tone
+// start.
+func testSignal(rate int) (clip *audiofile.Clip, chirpAt, toneAt int) {
+	samplesOf := func(d time.Duration) int { return int(d.Seconds() * float64(rate)) }
+	chirp := probe.Chirp(rate, testBand)
+	chirpAt = samplesOf(testLead)
+	toneAt = chirpAt + len(chirp) + samplesOf(testGap)
+	n := samplesOf(testToneDur)
+	samples := make(msdk.PCM16Sample, toneAt+n)
+	copy(samples[chirpAt:], chirp)
+	fade := rate / 100
+	for i := range n {
+		gain := min(1, float64(i)/float64(fade), float64(n-1-i)/float64(fade))
+		v := math.Sin(2*math.Pi*testToneHz*float64(i)/float64(rate)) * gain
+		samples[toneAt+i] = int16(v * testToneGain * math.MaxInt16)
+	}
+	return &audiofile.Clip{Path: "test signal", SampleRate: rate, Samples: samples}, chirpAt, toneAt
+}
+
+// analyzeEcho looks for the test signal sent at chirpAt and toneAt in the
+// audio heard back.
+func analyzeEcho(res *TestCallResult, heard []int16, rate, chirpAt, toneAt int) {
+	res.LevelDB = levelDB(heard)
+	if len(heard) <= chirpAt {
+		return
+	}
+	window := heard[chirpAt:min(len(heard), chirpAt+int(testListen.Seconds()*float64(rate)))]
+	lag, correlation, ok := probe.Find(window, rate, testBand)
+	res.Correlation = correlation
+	if !ok {
+		return
+	}
+	res.EchoHeard = true
+	res.RoundTrip = time.Duration(lag) * time.Second / time.Duration(rate)
+
+	// Skip the first and last block, where the tone fades in and out.
+	block := int(testBlock.Seconds() * float64(rate))
+	var levels []float64
+	for at := toneAt + lag + block; at+2*block <= min(len(heard), toneAt+lag+int(testToneDur.Seconds()*float64(rate))); at += block {
+		levels = append(levels, toneAmplitude(heard[at:at+block], testToneHz, rate))
+	}
+	res.Blocks = len(levels)
+	if len(levels) == 0 {
+		return
+	}
+	sorted := slices.Sorted(slices.Values(levels))
+	median := sorted[len(sorted)/2]
+	res.EchoLevelDB = 20 * math.Log10(max(median, 1e-6)/testToneGain)
+	floor := median * math.Pow(10, -testDropoutDB/20.0)
+	for _, l := range levels {
+		if l < floor {
+			res.Dropouts++
+		}
+	}
+}
+
+// toneAmplitude returns the amplitude (full scale 1) of the hz component
+// of samples (Goertzel).
+func toneAmplitude(samples []int16, hz, rate int) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*float64(hz)/float64(rate))
+	var s1, s2 float64
+	for _, v := range samples {
+		s := float64(v)/math.MaxInt16 + coeff*s1 - s2
+		s1, s2 = s, s1
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(max(power, 0)) / float64(len(samples))
+}
+
+// levelDB returns the RMS level of samples in dBFS.
+func levelDB(samples []int16) float64 {
+	if len(samples) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, v := range samples {
+		f := float64(v) / math.MaxInt16
+		sum += f * f
+	}
+	return 10 * math.Log10(max(sum/float64(len(samples)), 1e-12))
+}
+
+// runTestCalls calls test_call.number every test_call.interval and tells the
+// Telegram user when audio health changes; healthy results are only logged.
+func (s *Service) runTestCalls(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.TestCallInterval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.draining.Load() {
+			return
+		}
+		res := s.TestCall(ctx, "")
+		if ctx.Err() != nil {
+			return
+		}
+		if !res.Healthy() {
+			s.notify(s.cfg.TGUserID, "Scheduled "+lowerFirst(res.String()))
+		} else if !healthy {
+			s.notify(s.cfg.TGUserID, "Scheduled "+lowerFirst(res.String())+"\n(audio recovered)")
+		}
+		healthy = res.Healthy()
+	}
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/cdr"
+)
+
+// Actions for an inbound call to a Telegram user who is already in a call
+// (call.busy_action).
+const (
+	BusyReject    = "reject"
+	BusyNotify    = "notify"
+	BusyVoicemail = "voicemail"
+)
+
+var ErrTGBusy = errors.New("telegram user is already in a call")
+
+// tgChatBusy reports whether the private chat chatID is in a call. A
+// private chat carries a single call; voice chats take any number of legs.
+func (s *Service) tgChatBusy(chatID int64) bool {
+	return chatID >= 0 && s.getTGSession(chatID) != nil
+}
+
+// routeTGBusy handles an inbound call to a Telegram user who is already in
+// a call according to call.busy_action: the caller gets 486 (and the user a
+// missed call message with notify), or leaves a voicemail.
+func (s *Service) routeTGBusy(dialog *diago.DialogServerSession, call *Call, earlyMediaSent bool, logger *slog.Logger) {
+	action := s.cfg.BusyAction
+	logger.Info("sip: telegram user busy", "tg_chat_id", call.ChatID, "action", action)
+	if action == BusyVoicemail && s.voicemailEnabled() {
+		s.takeVoicemail(dialog, call, logger)
+		return
+	}
+	call.setCause(cdr.CauseBusy)
+	if action != BusyReject {
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (you were on another call)", displayParty(call.Name, call.Number)))
+	}
+	s.rejectInbound(dialog, sip.StatusBusyHere, "Busy", AnnounceBusy, earlyMediaSent, logger)
+}
@@ -0,0 +1,215 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+var (
+	ErrNoASR           = errors.New("speech recognition is not configured (asr.url)")
+	ErrTranscribing    = errors.New("transcription already active")
+	ErrNotTranscribing = errors.New("no transcription active")
+)
+
+const (
+	// transcriptQueueFrames buffers the audio of one side between the
+	// media goroutine and its transcriber.
+	transcriptQueueFrames = 200
+	// transcriptPreRollFrames of audio before speech is detected go with
+	// the utterance, so its first syllable isn't cut off.
+	transcriptPreRollFrames = 30
+	// transcriptPending bounds the utterances waiting for their transcript.
+	transcriptPending = 8
+)
+
+// TranscribeLegs selects the sides of a call that are transcribed.
+type TranscribeLegs struct {
+	// SIP is the SIP party, TG the Telegram user.
+	SIP, TG bool
+}
+
+// ParseTranscribeLegs parses "sip", "tg" or "both".
+func ParseTranscribeLegs(s string) (TranscribeLegs, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "sip":
+		return TranscribeLegs{SIP: true}, nil
+	case "tg":
+		return TranscribeLegs{TG: true}, nil
+	case "both":
+		return TranscribeLegs{SIP: true, TG: true}, nil
+	}
+	return TranscribeLegs{}, fmt.Errorf("%q (sip, tg or both)", s)
+}
+
+func (l TranscribeLegs) String() string {
+	switch {
+	case l.SIP && l.TG:
+		return "both"
+	case l.SIP:
+		return "sip"
+	case l.TG:
+		return "tg"
+	}
+	return "none"
+}
+
+// callTranscript transcribes the sides of a bridged call utterance by
+// utterance (see recognizer) and messages each transcript to the Telegram
+// user as it comes in.
+type callTranscript struct {
+	legs    TranscribeLegs
+	sip, tg *transcriber
+	cancel  context.CancelFunc
+}
+
+// WriteSIP passes audio of the SIP party (PCM16LE mono at the TG format).
+func (t *callTranscript) WriteSIP(frame []byte) {
+	if t.sip != nil {
+		t.sip.push(frame)
+	}
+}
+
+// WriteTG passes audio of the Telegram user.
+func (t *callTranscript) WriteTG(frame []byte) {
+	if t.tg != nil {
+		t.tg.push(frame)
+	}
+}
+
+// Close stops transcribing; utterances not recognized yet are dropped.
+func (t *callTranscript) Close() {
+	t.cancel()
+}
+
+// transcriber is the audio of one side, queued for runTranscriber.
+type transcriber struct {
+	frames chan []byte
+}
+
+// push queues a frame, dropping it when the transcriber falls behind.
+func (tr *transcriber) push(frame []byte) {
+	select {
+	case tr.frames <- bytes.Clone(frame):
+	default:
+	}
+}
+
+// StartTranscription transcribes legs of call to the Telegram user.
+func (s *Service) StartTranscription(call *Call, legs TranscribeLegs) error {
+	if s.cfg.ASRURL == "" {
+		return ErrNoASR
+	}
+	media := call.mediaBridge()
+	if media == nil {
+		return ErrNotBridged
+	}
+	ctx, cancel := context.WithCancel(call.ctx)
+	t := &callTranscript{legs: legs, cancel: cancel}
+	logger := s.logger.With("bridge_call_id", call.ID)
+	if legs.SIP {
+		t.sip = &transcriber{frames: make(chan []byte, transcriptQueueFrames)}
+	}
+	if legs.TG {
+		t.tg = &transcriber{frames: make(chan []byte, transcriptQueueFrames)}
+	}
+	if !media.StartTranscript(t) {
+		cancel()
+		return ErrTranscribing
+	}
+	if t.sip != nil {
+		go s.runTranscriber(ctx, t.sip, displayParty(call.Name, call.Number), logger)
+	}
+	if t.tg != nil {
+		go s.runTranscriber(ctx, t.tg, "You", logger)
+	}
+	return nil
+}
+
+// StopTranscription stops transcribing call.
+func (s *Service) StopTranscription(call *Call) error {
+	media := call.mediaBridge()
+	if media == nil {
+		return ErrNotBridged
+	}
+	if media.StopTranscript() == nil {
+		return ErrNotTranscribing
+	}
+	return nil
+}
+
+// autoTranscribe starts transcribing a freshly bridged call when
+// transcription.enabled is set.
+func (s *Service) autoTranscribe(call *Call, logger *slog.Logger) {
+	if !s.cfg.TranscriptionEnabled {
+		return
+	}
+	if err := s.StartTranscription(call, s.cfg.Transcription); err != nil {
+		logger.Warn("transcription start failed", "error", err)
+	}
+}
+
+// runTranscriber waits for speech in the audio of tr, streams each
+// utterance to a recognizer and posts the transcripts, prefixed with
+// speaker, in the order they were spoken.
+func (s *Service) runTranscriber(ctx context.Context, tr *transcriber, speaker string, logger *slog.Logger) {
+	pending := make(chan chan speechResult, transcriptPending)
+	go func() {
+		for {
+			var result chan speechResult
+			select {
+			case <-ctx.Done():
+				return
+			case result = <-pending:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case res := <-result:
+				if text := strings.TrimSpace(res.Text); res.err == nil && text != "" {
+					s.notify(s.cfg.TGUserID, speaker+": "+text)
+				}
+			}
+		}
+	}()
+
+	var (
+		preRoll [][]byte
+		rec     *recognizer
+	)
+	for {
+		var frame []byte
+		select {
+		case <-ctx.Done():
+			return
+		case frame = <-tr.frames:
+		}
+		if rec != nil {
+			rec.write(frame)
+			if rec.ended {
+				rec = nil
+			}
+			continue
+		}
+		preRoll = append(preRoll, frame)
+		if len(preRoll) > transcriptPreRollFrames {
+			preRoll = preRoll[1:]
+		}
+		if frameLevelDB(frame) < asrSpeechDB {
+			continue
+		}
+		rec = s.startRecognizer(ctx, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case pending <- rec.result:
+		}
+		for _, f := range preRoll {
+			rec.write(f)
+		}
+		preRoll = nil
+	}
+}
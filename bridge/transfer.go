@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+
+	"gotgcalls/bridge/endpoints"
+)
+
+// sipDialog is the SIP leg of a call; a transfer replaces it.
+type sipDialog interface {
+	endpoints.SIPDialog
+	Context() context.Context
+	Hangup(ctx context.Context) error
+	Close() error
+}
+
+// ErrNoSIPLeg is returned when a call has no answered SIP dialog yet.
+var ErrNoSIPLeg = errors.New("call has no answered sip leg")
+
+// transferHangupDelay is how long the transferor gets to release the old
+// dialog after a REFER succeeded before we hang it up ourselves.
+const transferHangupDelay = 5 * time.Second
+
+// Transfer asks the SIP party of call to call target instead (blind transfer
+// with REFER). The call ends once the SIP party has been connected.
+func (s *Service) Transfer(ctx context.Context, call *Call, target string) error {
+	referTo, err := s.buildOutboundURI(target)
+	if err != nil {
+		return err
+	}
+	switch d := call.sipDialog().(type) {
+	case *diago.DialogServerSession:
+		err = d.Refer(ctx, referTo)
+	case *diago.DialogClientSession:
+		err = d.Refer(ctx, referTo)
+	default:
+		return ErrNoSIPLeg
+	}
+	if err != nil {
+		return err
+	}
+	call.mu.Lock()
+	call.referred = true
+	call.mu.Unlock()
+	s.logger.Info("sip: transfer accepted", "bridge_call_id", call.ID, "refer_to", referTo.String())
+	return nil
+}
+
+// referInvite dials the target of a REFER received on call's SIP leg, with
+// the same credentials and callbacks as our own outbound calls.
+func (s *Service) referInvite(call *Call, logger *slog.Logger) diago.ReferInviteFunc {
+	return func(_ context.Context, referTo sip.Uri) (*diago.DialogClientSession, error) {
+		// The transferor may hang up at any time; tie the new leg to the call.
+		ctx, cancel := context.WithTimeout(call.ctx, s.Tunables().EstablishTimeout)
+		defer cancel()
+		logger.Info("sip: transfer requested, dialing", "refer_to", referTo.String())
+		dialog, earlyMedia, err := s.inviteWithEarlyMedia(ctx, referTo, logger, call)
+		if err != nil {
+			logger.Warn("sip: transfer target failed", "refer_to", referTo.String(), "error", err)
+			return nil, err
+		}
+		if earlyMedia {
+			if err := dialog.WaitAnswer(ctx, sipgo.AnswerOptions{}); err != nil {
+				_ = dialog.Close()
+				return nil, err
+			}
+			if err := dialog.Ack(ctx); err != nil {
+				_ = dialog.Close()
+				return nil, err
+			}
+		}
+		return dialog, nil
+	}
+}
+
+// onTransferred moves call's media onto dialog, the answered target of a
+// REFER, and releases the old SIP leg. The Telegram leg is left untouched.
+func (s *Service) onTransferred(call *Call, logger *slog.Logger) func(*diago.DialogClientSession) {
+	return func(dialog *diago.DialogClientSession) {
+		media := call.mediaBridge()
+		sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+		if err != nil || media == nil {
+			logger.Warn("sip: transfer media setup failed", "error", err)
+			hangupDialog(dialog, logger)
+			return
+		}
+		old := call.sipDialog()
+		media.ReplaceSIP(sipMedia)
+		call.setSIPDialog(dialog)
+		call.setSIPCallID(sipCallID(dialog))
+		call.setCodec(sipMedia.Codec.Name)
+		s.setHoldState(call, sipMedia.OnHold)
+		logger.Info("sip: call transferred", "call_id", sipCallID(dialog), "codec", sipMedia.Codec.Name)
+
+		if old != nil {
+			go func() {
+				select {
+				case <-old.Context().Done():
+				case <-time.After(transferHangupDelay):
+					hangupDialog(old, logger)
+				}
+				_ = old.Close()
+			}()
+		}
+		go func() {
+			<-call.Done()
+			hangupDialog(dialog, logger)
+		}()
+	}
+}
+
+// hangupDialog sends BYE (if the dialog is still up) and releases it.
+func hangupDialog(d sipDialog, logger *slog.Logger) {
+	if d.Context().Err() == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := d.Hangup(ctx); err != nil {
+			logger.Warn("sip hangup failed", "error", err)
+		}
+	}
+	_ = d.Close()
+}
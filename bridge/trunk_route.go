@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// reservedTrunkHeaders are built by the SIP stack and can't be set with
+// sip.headers.
+var reservedTrunkHeaders = []string{
+	"via", "from", "to", "call-id", "cseq", "contact", "route", "record-route",
+	"max-forwards", "content-type", "content-length",
+}
+
+// parseRoute parses the sip.route entries. Entries without lr are marked
+// for loose routing: the bridge keeps the provider in the Request-URI.
+func parseRoute(entries []string) ([]string, error) {
+	var out []string
+	for _, entry := range entries {
+		entry = strings.Trim(strings.TrimSpace(entry), "<>")
+		if lower := strings.ToLower(entry); !strings.HasPrefix(lower, "sip:") && !strings.HasPrefix(lower, "sips:") {
+			entry = "sip:" + entry
+		}
+		var uri sip.Uri
+		if err := sip.ParseUri(entry, &uri); err != nil || uri.Host == "" {
+			return nil, fmt.Errorf("invalid sip.route entry %q (a SIP URI such as sip:sbc.example.com;lr)", entry)
+		}
+		if uri.UriParams == nil {
+			uri.UriParams = sip.HeaderParams{}
+		}
+		if !uri.UriParams.Has("lr") {
+			uri.UriParams.Add("lr", "")
+		}
+		out = append(out, uri.String())
+	}
+	return out, nil
+}
+
+// parseTrunkHeaders checks the names of sip.headers.
+func parseTrunkHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid sip.headers entry %q", name)
+		}
+		if slices.Contains(reservedTrunkHeaders, strings.ToLower(name)) {
+			return nil, fmt.Errorf("sip.headers can't set %s", name)
+		}
+		out[name] = strings.TrimSpace(value)
+	}
+	return out, nil
+}
+
+// outboundProxyURI is the loose Route to sip.outbound_proxy.
+func (s *Service) outboundProxyURI() sip.Uri {
+	host, port := splitHostPort(s.cfg.SIPOutboundProxy)
+	uri := sip.Uri{Host: host, Port: port, UriParams: sip.HeaderParams{}}
+	if s.cfg.SIPTransport != "" && s.cfg.SIPTransport != "udp" {
+		uri.UriParams.Add("transport", s.cfg.SIPTransport)
+	}
+	uri.UriParams.Add("lr", "")
+	return uri
+}
+
+// isTrunkURI reports whether recipient is at the SIP trunk, rather than a
+// registered phone or a redirect target elsewhere.
+func (s *Service) isTrunkURI(recipient sip.Uri) bool {
+	host, _ := splitHostPort(s.cfg.SIPProvider)
+	return strings.EqualFold(recipient.Host, host)
+}
+
+// trunkHeaders are the headers INVITEs of call to recipient get for the
+// trunk: the Route set of sip.outbound_proxy and sip.route, which the INVITE
+// is sent along, and sip.headers. Other recipients get none.
+func (s *Service) trunkHeaders(recipient sip.Uri, call *Call) []sip.Header {
+	if !s.isTrunkURI(recipient) {
+		return nil
+	}
+	var headers []sip.Header
+	if s.cfg.SIPOutboundProxy != "" {
+		proxy := s.outboundProxyURI()
+		headers = append(headers, sip.NewHeader("Route", "<"+proxy.String()+">"))
+	}
+	for _, route := range s.cfg.SIPRoute {
+		headers = append(headers, sip.NewHeader("Route", "<"+route+">"))
+	}
+	names := make([]string, 0, len(s.cfg.SIPHeaders))
+	for name := range s.cfg.SIPHeaders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	subst := trunkHeaderReplacer(recipient, call)
+	for _, name := range names {
+		headers = append(headers, sip.NewHeader(name, subst.Replace(s.cfg.SIPHeaders[name])))
+	}
+	return headers
+}
+
+// trunkHeaderReplacer fills in the placeholders of sip.headers values:
+// {number} is the dialed number, {caller} the calling party, {call_id} the
+// bridge call ID and {chat_id} the Telegram chat of the call.
+func trunkHeaderReplacer(recipient sip.Uri, call *Call) *strings.Replacer {
+	caller := call.Local
+	if call.Direction == CallInbound {
+		// A forwarded call.
+		caller = call.Number
+	}
+	return strings.NewReplacer(
+		"{number}", recipient.User,
+		"{caller}", caller,
+		"{call_id}", call.ID,
+		"{chat_id}", strconv.FormatInt(call.ChatID, 10),
+	)
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gotgcalls/bridge/audiofile"
+)
+
+// ErrNoTTS is returned when neither tts.command nor tts.url is configured.
+var ErrNoTTS = errors.New("text-to-speech is not configured (tts.command or tts.url)")
+
+// ttsTimeout bounds one run of tts.command or request to tts.url.
+const ttsTimeout = 15 * time.Second
+
+// ttsEnabled reports whether text can be spoken.
+func (c Config) ttsEnabled() bool {
+	return c.TTSURL != "" || len(c.TTSCommand) > 0
+}
+
+// synthesize speaks text with tts.url, else tts.command.
+func (s *Service) synthesize(ctx context.Context, text string) (*audiofile.Clip, error) {
+	if !s.cfg.ttsEnabled() {
+		return nil, ErrNoTTS
+	}
+	dir, err := os.MkdirTemp("", "sip-tg-tts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "speech.wav")
+
+	ctx, cancel := context.WithTimeout(ctx, ttsTimeout)
+	defer cancel()
+	if s.cfg.TTSURL != "" {
+		err = s.synthesizeHTTP(ctx, text, file)
+	} else {
+		err = s.synthesizeCommand(ctx, text, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	clip, err := audiofile.Load(file, s.cfg.SampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("tts: %w", err)
+	}
+	clip.Path = fmt.Sprintf("tts %q", text)
+	return clip, nil
+}
+
+// synthesizeCommand runs tts.command with {text} and {file} substituted in
+// its arguments and text on stdin (for piper). It must write a 16-bit mono
+// WAV file to {file}, or to stdout when it has no {file} argument.
+func (s *Service) synthesizeCommand(ctx context.Context, text, file string) error {
+	toFile := false
+	subst := strings.NewReplacer("{text}", text, "{file}", file)
+	args := make([]string, len(s.cfg.TTSCommand))
+	for i, arg := range s.cfg.TTSCommand {
+		toFile = toFile || strings.Contains(arg, "{file}")
+		args[i] = subst.Replace(arg)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tts: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if toFile {
+		return nil
+	}
+	return os.WriteFile(file, stdout.Bytes(), 0o600)
+}
+
+// synthesizeHTTP posts {"text": text} to tts.url, a cloud or local speech
+// service, and saves the 16-bit mono WAV file it answers with to file.
+func (s *Service) synthesizeHTTP(ctx context.Context, text, file string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TTSURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "audio/wav")
+	for k, v := range s.cfg.TTSHeaders {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("tts: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("tts returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	wav, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("tts: %w", err)
+	}
+	return os.WriteFile(file, wav, 0o600)
+}
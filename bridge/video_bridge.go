@@ -0,0 +1,389 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+	"github.com/pion/rtp"
+
+	"gotgcalls/bridge/h264"
+	"gotgcalls/third_party/ntgcalls"
+)
+
+const (
+	// videoMTU bounds the RTP payloads of the video sent to SIP.
+	videoMTU = 1200
+	// videoFrameQueue is how many Telegram frames wait for the encoder
+	// before frames are dropped.
+	videoFrameQueue = 4
+)
+
+// mediaSessioner is a SIP dialog (server or client) with its media.
+type mediaSessioner interface {
+	MediaSession() *media.MediaSession
+}
+
+// offersVideo reports whether an SDP has an enabled video stream.
+func offersVideo(body []byte) bool {
+	sd, err := sdp.MediaSection(body, "video")
+	if err != nil {
+		return false
+	}
+	md, err := sd.MediaDescription("video")
+	return err == nil && md.Port != 0
+}
+
+// tgVideo is the camera of a Telegram call with video: frames are
+// exchanged as raw I420 at video.width x video.height.
+func (s *Service) tgVideo() *ntgcalls.VideoDescription {
+	return &ntgcalls.VideoDescription{
+		MediaSource: ntgcalls.MediaSourceExternal,
+		Width:       int16(s.cfg.VideoWidth),
+		Height:      int16(s.cfg.VideoHeight),
+		Fps:         uint8(s.cfg.VideoFPS),
+		KeepOpen:    true,
+	}
+}
+
+// videoBridge carries the video of one private call between the SIP video
+// stream and the Telegram camera. ntgcalls exchanges raw I420 frames, so
+// both directions are transcoded by ffmpeg.
+type videoBridge struct {
+	s      *Service
+	chatID int64
+	video  *media.VideoSession
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// enc encodes the Telegram camera for SIP; it is started on the first
+	// frame and restarted when the frame size or rotation changes.
+	enc *videoEncoder
+}
+
+// startVideoBridge bridges the video of call once both legs are up, if
+// video.enabled and the SIP side negotiated video. The returned function
+// stops it.
+func (s *Service) startVideoBridge(dialog mediaSessioner, call *Call, logger *slog.Logger) func() {
+	if !s.cfg.VideoEnabled || call.ChatID < 0 || call.mixer != nil {
+		return func() {}
+	}
+	ms := dialog.MediaSession()
+	if ms == nil || ms.Video == nil || !ms.Video.Active() {
+		return func() {}
+	}
+	raddr := ms.Video.Raddr()
+	ctx, cancel := context.WithCancel(call.ctx)
+	vb := &videoBridge{
+		s:      s,
+		chatID: call.ChatID,
+		video:  ms.Video,
+		logger: logger.With("video_addr", raddr.String()),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	s.mu.Lock()
+	s.videoBridges[call.ChatID] = vb
+	s.mu.Unlock()
+	go vb.toTelegram()
+	vb.logger.Info("video: bridging", "codec_fmtp", ms.Video.Codec().FMTP)
+	return vb.stop
+}
+
+func (s *Service) getVideoBridge(chatID int64) *videoBridge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.videoBridges[chatID]
+}
+
+func (vb *videoBridge) stop() {
+	vb.cancel()
+	vb.s.mu.Lock()
+	if vb.s.videoBridges[vb.chatID] == vb {
+		delete(vb.s.videoBridges, vb.chatID)
+	}
+	vb.s.mu.Unlock()
+	vb.mu.Lock()
+	if vb.enc != nil {
+		vb.enc.close()
+		vb.enc = nil
+	}
+	vb.mu.Unlock()
+}
+
+// toTelegram decodes the SIP video to I420 frames for the Telegram camera.
+func (vb *videoBridge) toTelegram() {
+	cfg := vb.s.cfg
+	cmd := exec.CommandContext(vb.ctx, cfg.VideoFFmpeg, "-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-flags", "low_delay", "-probesize", "32", "-analyzeduration", "0",
+		"-f", "h264", "-i", "pipe:0",
+		"-vf", videoFilter(cfg.VideoWidth, cfg.VideoHeight, 0), "-fps_mode", "passthrough",
+		"-pix_fmt", "yuv420p", "-f", "rawvideo", "pipe:1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		vb.logger.Warn("video: decoder pipe failed", "error", err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		vb.logger.Warn("video: decoder pipe failed", "error", err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		vb.logger.Warn("video: decoder start failed", "command", cfg.VideoFFmpeg, "error", err)
+		return
+	}
+	go vb.sendFrames(stdout)
+
+	err = vb.readSIP(stdin)
+	_ = stdin.Close()
+	if werr := cmd.Wait(); werr != nil && vb.ctx.Err() == nil {
+		vb.logger.Warn("video: decoder failed", "error", werr, "output", strings.TrimSpace(stderr.String()))
+	}
+	vb.logger.Info("video: sip stream ended", "error", err)
+}
+
+// readSIP depacketizes the SIP video into w until the call ends. It asks
+// for a key frame until one arrived.
+func (vb *videoBridge) readSIP(w io.Writer) error {
+	buf := make([]byte, media.RTPBufSize)
+	var pkt rtp.Packet
+	senderSSRC := rand.Uint32()
+	depack := &h264.Depacketizer{W: w, ParameterSets: h264.ParameterSets(vb.video.Codec().FMTP)}
+	var lastPLI time.Time
+	for vb.ctx.Err() == nil {
+		if !depack.Started() && time.Since(lastPLI) >= videoKeyframeInterval {
+			lastPLI = time.Now()
+			_ = vb.video.RequestKeyframe(senderSSRC)
+		}
+		if err := vb.video.SetReadDeadline(time.Now().Add(videoKeyframeInterval)); err != nil {
+			return err
+		}
+		if _, err := vb.video.ReadRTP(buf, &pkt); err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		if pkt.PayloadType != vb.video.Codec().PayloadType {
+			continue
+		}
+		if err := depack.Push(pkt.SequenceNumber, pkt.Payload); err != nil && !errors.Is(err, h264.ErrShort) {
+			// The decoder is gone.
+			return err
+		}
+	}
+	return vb.ctx.Err()
+}
+
+// sendFrames hands the decoded frames to the Telegram camera.
+func (vb *videoBridge) sendFrames(r io.Reader) {
+	cfg := vb.s.cfg
+	frame := make([]byte, cfg.VideoWidth*cfg.VideoHeight*3/2)
+	var lastTs int64
+	for {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		ts := time.Now().UnixMilli()
+		if ts <= lastTs {
+			ts = lastTs + 1
+		}
+		lastTs = ts
+		data := ntgcalls.FrameData{
+			AbsoluteCaptureTimestampMs: ts,
+			Width:                      uint16(cfg.VideoWidth),
+			Height:                     uint16(cfg.VideoHeight),
+		}
+		if err := vb.s.tg.SendExternalFrame(vb.chatID, ntgcalls.CameraStream, frame, data); err != nil {
+			vb.logger.Debug("video: telegram frame failed", "error", err)
+		}
+	}
+}
+
+// pushTGFrame queues a Telegram camera frame for SIP.
+func (vb *videoBridge) pushTGFrame(frame ntgcalls.Frame) {
+	w, h, rot := int(frame.FrameData.Width), int(frame.FrameData.Height), int(frame.FrameData.Rotation)
+	if w == 0 || h == 0 || len(frame.Data) < w*h*3/2 {
+		return
+	}
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+	if vb.ctx.Err() != nil {
+		return
+	}
+	if enc := vb.enc; enc == nil || enc.width != w || enc.height != h || enc.rotation != rot {
+		if enc != nil {
+			enc.close()
+		}
+		enc, err := vb.startEncoder(w, h, rot)
+		if err != nil {
+			vb.logger.Warn("video: encoder start failed", "command", vb.s.cfg.VideoFFmpeg, "error", err)
+			vb.enc = nil
+			return
+		}
+		vb.enc = enc
+		vb.logger.Info("video: telegram camera", "width", w, "height", h, "rotation", rot)
+	}
+	select {
+	case vb.enc.frames <- frame.Data[:w*h*3/2]:
+	default:
+		// The encoder is behind; drop the frame.
+	}
+}
+
+// videoEncoder encodes Telegram camera frames of one size to H.264 and
+// sends them as RTP.
+type videoEncoder struct {
+	width, height, rotation int
+	frames                  chan []byte
+	cmd                     *exec.Cmd
+	stdin                   io.WriteCloser
+	done                    chan struct{}
+	closeOnce               sync.Once
+}
+
+func (vb *videoBridge) startEncoder(w, h, rot int) (*videoEncoder, error) {
+	cfg := vb.s.cfg
+	fps := strconv.Itoa(cfg.VideoFPS)
+	cmd := exec.CommandContext(vb.ctx, cfg.VideoFFmpeg, "-hide_banner", "-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "yuv420p", "-s", fmt.Sprintf("%dx%d", w, h), "-r", fps, "-i", "pipe:0",
+		"-vf", videoFilter(cfg.VideoWidth, cfg.VideoHeight, rot),
+		"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-profile:v", "baseline",
+		"-g", strconv.Itoa(cfg.VideoFPS*2), "-x264-params", "aud=1:repeat-headers=1",
+		"-f", "h264", "pipe:1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	enc := &videoEncoder{
+		width:    w,
+		height:   h,
+		rotation: rot,
+		frames:   make(chan []byte, videoFrameQueue),
+		cmd:      cmd,
+		stdin:    stdin,
+		done:     make(chan struct{}),
+	}
+	go enc.feed()
+	go func() {
+		if err := vb.sendRTP(stdout); err != nil && vb.ctx.Err() == nil {
+			vb.logger.Warn("video: sending to sip failed", "error", err)
+		}
+		_ = cmd.Wait()
+	}()
+	return enc, nil
+}
+
+func (enc *videoEncoder) feed() {
+	for {
+		select {
+		case <-enc.done:
+			_ = enc.stdin.Close()
+			return
+		case frame := <-enc.frames:
+			if _, err := enc.stdin.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close ends the input of the encoder, which then exits.
+func (enc *videoEncoder) close() {
+	enc.closeOnce.Do(func() { close(enc.done) })
+}
+
+// sendRTP packetizes the encoded stream to SIP, one access unit (frame) per
+// RTP timestamp. x264 delimits access units with AUD units.
+func (vb *videoBridge) sendRTP(r io.Reader) error {
+	reader := h264.NewReader(r)
+	pt := vb.video.Codec().PayloadType
+	ssrc := rand.Uint32()
+	seq := uint16(rand.Uint32())
+	start := time.Now()
+	var au [][]byte
+	flush := func() error {
+		ts := uint32(time.Since(start) * videoClockRate / time.Second)
+		for i, nal := range au {
+			payloads := h264.Packetize(nal, videoMTU)
+			for j, payload := range payloads {
+				pkt := &rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    pt,
+						SequenceNumber: seq,
+						Timestamp:      ts,
+						SSRC:           ssrc,
+						Marker:         i == len(au)-1 && j == len(payloads)-1,
+					},
+					Payload: payload,
+				}
+				seq++
+				if err := vb.video.WriteRTP(pkt); err != nil {
+					return err
+				}
+			}
+		}
+		au = au[:0]
+		return nil
+	}
+	for {
+		nal, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return flush()
+			}
+			return err
+		}
+		if nal[0]&0x1f == h264.NALAUD {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		au = append(au, append([]byte(nil), nal...))
+	}
+}
+
+// videoFilter is the ffmpeg filter fitting video into width x height
+// (letterboxed) after undoing a rotation of rot degrees clockwise.
+func videoFilter(width, height, rot int) string {
+	var filters []string
+	switch rot {
+	case 90:
+		filters = append(filters, "transpose=clock")
+	case 180:
+		filters = append(filters, "hflip,vflip")
+	case 270:
+		filters = append(filters, "transpose=cclock")
+	}
+	filters = append(filters,
+		fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", width, height),
+		fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", width, height),
+		"format=yuv420p")
+	return strings.Join(filters, ",")
+}
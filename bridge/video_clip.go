@@ -0,0 +1,174 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+	"github.com/pion/rtp"
+
+	"gotgcalls/bridge/h264"
+)
+
+const (
+	// videoKeyframeWait is how long a clip waits for the first key frame,
+	// asking for one every videoKeyframeInterval.
+	videoKeyframeWait     = 5 * time.Second
+	videoKeyframeInterval = time.Second
+	videoMuxTimeout       = time.Minute
+	videoClockRate        = 90000
+)
+
+// videoMode is the direction in which calls with chatID accept video: both
+// ways when video.enabled bridges it to a private call, receive only for
+// video.clip; none otherwise.
+func (s *Service) videoMode(chatID int64) string {
+	if s.cfg.VideoEnabled && chatID >= 0 {
+		return sdp.ModeSendrecv
+	}
+	if s.cfg.VideoClipEnabled {
+		return sdp.ModeRecvonly
+	}
+	return ""
+}
+
+// startVideoClip captures the video.clip of call once its SIP media is up
+// with video; it does nothing on later calls.
+func (s *Service) startVideoClip(dialog *diago.DialogServerSession, call *Call, logger *slog.Logger) {
+	if !s.cfg.VideoClipEnabled {
+		return
+	}
+	ms := dialog.MediaSession()
+	if ms == nil || ms.Video == nil || !ms.Video.Active() {
+		return
+	}
+	raddr := ms.Video.Raddr()
+	call.videoClip.Do(func() {
+		go s.captureVideoClip(ms.Video, call, logger.With("video_addr", raddr.String()))
+	})
+}
+
+// captureVideoClip records the first video.clip.length of video from the
+// first key frame, muxes it to MP4 and sends it to the Telegram user. A
+// call that ends earlier sends what was recorded.
+func (s *Service) captureVideoClip(video *media.VideoSession, call *Call, logger *slog.Logger) {
+	dir, err := os.MkdirTemp("", "sip-tg-video-")
+	if err != nil {
+		logger.Warn("video clip: temp dir failed", "error", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	raw := filepath.Join(dir, "clip.h264")
+	f, err := os.Create(raw)
+	if err != nil {
+		logger.Warn("video clip: create failed", "error", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	depack := &h264.Depacketizer{W: w, ParameterSets: h264.ParameterSets(video.Codec().FMTP)}
+	frames, length, err := readVideoClip(video, depack, s.cfg.VideoClipLength)
+	if ferr := errors.Join(w.Flush(), f.Close()); ferr != nil {
+		logger.Warn("video clip: write failed", "error", ferr)
+		return
+	}
+	if !depack.Started() {
+		logger.Info("video clip: no key frame received", "error", err)
+		return
+	}
+	logger.Info("video clip: captured", "frames", frames, "length", length, "error", err)
+
+	fps := 15.0
+	if frames > 1 && length > 0 {
+		fps = min(max(float64(frames-1)/length.Seconds(), 1), 60)
+	}
+	mp4 := filepath.Join(dir, fmt.Sprintf("video_%s.mp4", call.ID))
+	ctx, cancel := context.WithTimeout(context.Background(), videoMuxTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.cfg.VideoClipFFmpeg, "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "h264", "-framerate", strconv.FormatFloat(fps, 'f', 2, 64), "-i", raw,
+		"-c:v", "copy", "-movflags", "+faststart", mp4)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logger.Warn("video clip: mux failed", "command", s.cfg.VideoClipFFmpeg, "error", err, "output", strings.TrimSpace(string(out)))
+		return
+	}
+	if s.tgClient == nil {
+		return
+	}
+	opts := &tg.MediaOptions{
+		Caption:    fmt.Sprintf("Video from %s", displayParty(call.Name, call.Number)),
+		FileName:   filepath.Base(mp4),
+		MimeType:   "video/mp4",
+		Attributes: []tg.DocumentAttribute{&tg.DocumentAttributeVideo{SupportsStreaming: true, Nosound: true}},
+		Silent:     call.ring.Silent,
+	}
+	if _, err := s.tgClient.SendMedia(s.cfg.TGUserID, mp4, opts); err != nil {
+		logger.Warn("video clip: telegram upload failed", "error", err)
+		return
+	}
+	logger.Info("video clip: sent to telegram")
+}
+
+// readVideoClip depacketizes video until limit of video from the first key
+// frame was read, or reading fails (the call ended). It returns the number
+// of frames and their length.
+func readVideoClip(video *media.VideoSession, depack *h264.Depacketizer, limit time.Duration) (frames int, length time.Duration, err error) {
+	buf := make([]byte, media.RTPBufSize)
+	var pkt rtp.Packet
+	senderSSRC := rand.Uint32()
+	start := time.Now()
+	var lastPLI time.Time
+	var firstTS, lastTS uint32
+	for {
+		deadline := start.Add(videoKeyframeWait)
+		if depack.Started() {
+			// Allow for a slow start of the stream; the RTP timestamps
+			// end the clip.
+			deadline = time.Now().Add(videoKeyframeWait)
+		}
+		if err := video.SetReadDeadline(deadline); err != nil {
+			return frames, length, err
+		}
+		if _, err := video.ReadRTP(buf, &pkt); err != nil {
+			return frames, length, err
+		}
+		if pkt.PayloadType != video.Codec().PayloadType {
+			continue
+		}
+		started := depack.Started()
+		if started && pkt.Timestamp != lastTS && time.Duration(pkt.Timestamp-firstTS)*time.Second/videoClockRate >= limit {
+			// The next frame would pass the limit.
+			return frames, length, nil
+		}
+		if err := depack.Push(pkt.SequenceNumber, pkt.Payload); err != nil {
+			continue
+		}
+		switch {
+		case !depack.Started():
+			if time.Since(lastPLI) >= videoKeyframeInterval {
+				lastPLI = time.Now()
+				_ = video.RequestKeyframe(senderSSRC)
+			}
+			continue
+		case !started:
+			firstTS, lastTS = pkt.Timestamp, pkt.Timestamp
+			frames = 1
+		case pkt.Timestamp != lastTS:
+			lastTS = pkt.Timestamp
+			frames++
+		}
+		length = time.Duration(lastTS-firstTS) * time.Second / videoClockRate
+	}
+}
@@ -0,0 +1,202 @@
+package bridge
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// AutoJoinRules returns the voice chats joined automatically when they start.
+func (s *Service) AutoJoinRules() []VoiceChatAutoJoin {
+	s.autoJoinMu.Lock()
+	defer s.autoJoinMu.Unlock()
+	out := make([]VoiceChatAutoJoin, 0, len(s.autoJoinRules))
+	for _, rule := range s.autoJoinRules {
+		out = append(out, rule)
+	}
+	slices.SortFunc(out, func(a, b VoiceChatAutoJoin) int {
+		return cmp.Compare(a.ChatID, b.ChatID)
+	})
+	return out
+}
+
+// SetAutoJoin adds or replaces the auto-join rule for rule.ChatID. If the
+// voice chat is already running, its numbers are dialed on the next check.
+func (s *Service) SetAutoJoin(rule VoiceChatAutoJoin) error {
+	if rule.ChatID >= 0 {
+		return ErrNotGroupChat
+	}
+	if len(rule.Numbers) == 0 {
+		return errors.New("at least one number is required")
+	}
+	s.autoJoinMu.Lock()
+	s.autoJoinRules[rule.ChatID] = rule
+	delete(s.autoJoined, rule.ChatID)
+	s.autoJoinMu.Unlock()
+	s.wakeAutoJoin()
+	return nil
+}
+
+// RemoveAutoJoin deletes the auto-join rule for chatID. Calls already
+// dialed are left alone.
+func (s *Service) RemoveAutoJoin(chatID int64) bool {
+	s.autoJoinMu.Lock()
+	defer s.autoJoinMu.Unlock()
+	_, ok := s.autoJoinRules[chatID]
+	delete(s.autoJoinRules, chatID)
+	delete(s.autoJoined, chatID)
+	return ok
+}
+
+func (s *Service) wakeAutoJoin() {
+	select {
+	case s.autoJoinWake <- struct{}{}:
+	default:
+	}
+}
+
+// runAutoJoin polls the voice chats with auto-join rules and dials their
+// numbers once per voice chat, as soon as it starts. Scheduled voice chats
+// are checked again right at their start time.
+func (s *Service) runAutoJoin(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.autoJoinWake:
+		case <-timer.C:
+		}
+		next := s.cfg.VoiceChatPollInterval
+		for _, rule := range s.AutoJoinRules() {
+			if wait := s.checkAutoJoin(ctx, rule); wait > 0 && wait < next {
+				next = wait
+			}
+		}
+		timer.Stop()
+		timer.Reset(next)
+	}
+}
+
+// checkAutoJoin handles one rule and returns how long until its voice chat
+// is scheduled to start (zero when not scheduled).
+func (s *Service) checkAutoJoin(ctx context.Context, rule VoiceChatAutoJoin) time.Duration {
+	logger := s.logger.With("tg_chat_id", rule.ChatID)
+	call, err := s.voiceChat(rule.ChatID)
+	if err != nil {
+		logger.Warn("auto-join: voice chat lookup failed", "error", err)
+		return 0
+	}
+	if call == nil {
+		s.autoJoinMu.Lock()
+		delete(s.autoJoined, rule.ChatID)
+		s.autoJoinMu.Unlock()
+		return 0
+	}
+
+	if call.ScheduleDate != 0 {
+		wait := time.Until(time.Unix(int64(call.ScheduleDate), 0))
+		if wait > 0 {
+			// Not started yet; recheck at the scheduled time.
+			return wait
+		}
+		if !rule.StartScheduled {
+			// Overdue; keep polling until someone starts it.
+			return 0
+		}
+		logger.Info("auto-join: starting scheduled voice chat", "title", call.Title)
+		input := &tg.InputGroupCallObj{ID: call.ID, AccessHash: call.AccessHash}
+		if _, err := s.tgClient.PhoneStartScheduledGroupCall(input); err != nil {
+			logger.Warn("auto-join: starting scheduled voice chat failed", "error", err)
+		}
+		return time.Second
+	}
+
+	s.autoJoinMu.Lock()
+	if _, ok := s.autoJoinRules[rule.ChatID]; !ok || s.autoJoined[rule.ChatID] == call.ID {
+		s.autoJoinMu.Unlock()
+		return 0
+	}
+	s.autoJoined[rule.ChatID] = call.ID
+	s.autoJoinMu.Unlock()
+
+	logger.Info("auto-join: voice chat started, dialing", "title", call.Title, "numbers", rule.Numbers)
+	var dialed []string
+	for _, number := range rule.Numbers {
+		if _, err := s.Invite(ctx, rule.ChatID, number); err != nil {
+			logger.Warn("auto-join: invite failed", "number", number, "error", err)
+			continue
+		}
+		dialed = append(dialed, number)
+	}
+	title := call.Title
+	if title == "" {
+		title = fmt.Sprint(rule.ChatID)
+	}
+	s.notify(s.cfg.TGUserID, fmt.Sprintf("Voice chat %s started, dialing %s", title, strings.Join(dialed, ", ")))
+	return 0
+}
+
+// voiceChat returns the current voice chat of chatID, or nil if there is none.
+func (s *Service) voiceChat(chatID int64) (*tg.GroupCallObj, error) {
+	if s.tgClient == nil {
+		return nil, errors.New("telegram client not available")
+	}
+	peer, err := s.tgClient.ResolvePeer(chatID)
+	if err != nil {
+		return nil, err
+	}
+	var input tg.InputGroupCall
+	switch p := peer.(type) {
+	case *tg.InputPeerChannel:
+		full, err := s.tgClient.ChannelsGetFullChannel(&tg.InputChannelObj{ChannelID: p.ChannelID, AccessHash: p.AccessHash})
+		if err != nil {
+			return nil, err
+		}
+		if ch, ok := full.FullChat.(*tg.ChannelFull); ok {
+			input = ch.Call
+		}
+	case *tg.InputPeerChat:
+		full, err := s.tgClient.MessagesGetFullChat(p.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		if ch, ok := full.FullChat.(*tg.ChatFullObj); ok {
+			input = ch.Call
+		}
+	default:
+		return nil, ErrNotGroupChat
+	}
+	if input == nil {
+		return nil, nil
+	}
+	res, err := s.tgClient.PhoneGetGroupCall(input, 1)
+	if err != nil {
+		return nil, err
+	}
+	call, _ := res.Call.(*tg.GroupCallObj)
+	return call, nil
+}
+
+// FormatAutoJoin renders auto-join rules for a chat reply.
+func FormatAutoJoin(rules []VoiceChatAutoJoin) string {
+	if len(rules) == 0 {
+		return "No voice chats are joined automatically"
+	}
+	var b strings.Builder
+	b.WriteString("Auto-join voice chats:")
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "\n%d: %s", rule.ChatID, strings.Join(rule.Numbers, ", "))
+		if rule.StartScheduled {
+			b.WriteString(" (starts scheduled)")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,239 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+	"github.com/emiago/diago"
+	msdk "github.com/livekit/media-sdk"
+
+	"gotgcalls/bridge/audiofile"
+	"gotgcalls/bridge/cdr"
+	"gotgcalls/bridge/endpoints"
+	"gotgcalls/bridge/recording"
+)
+
+const (
+	// The beep marks the start of recording after the greeting.
+	voicemailBeepHz  = 1000
+	voicemailBeepDur = 400 * time.Millisecond
+	// voicemailMinLength drops messages of callers who hang up at the beep.
+	voicemailMinLength = time.Second
+	// voicemailEndDigit ends the message early.
+	voicemailEndDigit = '#'
+)
+
+// loadVoicemail decodes the voicemail greeting and builds the beep.
+func (s *Service) loadVoicemail() error {
+	if !s.cfg.VoicemailEnabled {
+		return nil
+	}
+	if s.cfg.VoicemailGreetingFile != "" {
+		clip, err := audiofile.Load(s.cfg.VoicemailGreetingFile, s.cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("voicemail.greeting_file: %w", err)
+		}
+		s.voicemailGreeting = clip
+	}
+	s.voicemailBeep = beepClip(s.tgFormat().SampleRate)
+	return nil
+}
+
+// beepClip returns a sine beep with short fades so it does not click.
+func beepClip(sampleRate int) *audiofile.Clip {
+	n := int(voicemailBeepDur.Seconds() * float64(sampleRate))
+	fade := sampleRate / 100
+	samples := make(msdk.PCM16Sample, n)
+	for i := range samples {
+		gain := min(1, float64(i)/float64(fade), float64(n-1-i)/float64(fade))
+		v := math.Sin(2*math.Pi*voicemailBeepHz*float64(i)/float64(sampleRate)) * gain
+		samples[i] = int16(v * 0.3 * math.MaxInt16)
+	}
+	return &audiofile.Clip{Path: "beep", SampleRate: sampleRate, Samples: samples}
+}
+
+// voicemailEnabled reports whether inbound calls the Telegram user does not
+// take go to voicemail instead of being rejected.
+func (s *Service) voicemailEnabled() bool {
+	return s.cfg.VoicemailEnabled && s.tgClient != nil
+}
+
+// takeVoicemail answers an inbound call the Telegram user did not take,
+// plays the greeting and a beep, and records the caller until they hang up,
+// press #, or voicemail.max_length passes. The message is then sent to the
+// Telegram user.
+func (s *Service) takeVoicemail(dialog *diago.DialogServerSession, call *Call, logger *slog.Logger) {
+	s.recordVoicemail(dialog, call, s.voicemailGreeting, logger)
+}
+
+// recordVoicemail is takeVoicemail with greeting (nil for none) in place of
+// the configured one.
+func (s *Service) recordVoicemail(dialog *diago.DialogServerSession, call *Call, greeting *audiofile.Clip, logger *slog.Logger) {
+	if !dialogAnswered(dialog) {
+		if err := dialog.AnswerOptions(diago.AnswerOptions{Codecs: s.sipCodecs(), RTPNAT: s.rtpNAT()}); err != nil {
+			logger.Warn("voicemail: answer failed", "error", err)
+			call.setCause(cdr.CauseSIPFailure)
+			return
+		}
+		s.setCallState(call, CallAnswered)
+		call.setSIPDialog(dialog)
+		logger.Info("voicemail: call answered")
+	}
+	call.setCause(cdr.CauseVoicemail)
+	defer hangupDialog(dialog, logger)
+
+	if greeting != nil {
+		s.playAnnouncement(dialog, greeting, logger)
+	}
+	if dialog.Context().Err() != nil {
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (caller hung up during the greeting)", displayParty(call.Name, call.Number)))
+		return
+	}
+
+	sipMedia, err := endpoints.NewSipEndpoint(dialog, s.sipMediaConfig())
+	if err != nil {
+		logger.Warn("voicemail: sip media setup failed", "error", err)
+		return
+	}
+	defer sipMedia.Close()
+	call.setCodec(sipMedia.Codec.Name)
+
+	track, err := recording.StartTrack(s.voicemailOptions(), recording.Vars{
+		ID:        call.ID,
+		Direction: "voicemail",
+		Number:    call.Number,
+		ChatID:    call.ChatID,
+		StartedAt: call.StartedAt,
+	})
+	if err != nil {
+		logger.Warn("voicemail: recording failed", "error", err)
+		return
+	}
+	box := newLocalPort(s.tgFormat(), track.Write)
+	tunables := s.Tunables()
+	bridge, err := NewMediaBridge(call.ctx, logger, sipMedia, box, tunables.DriftTargetFrames, tunables.DriftMaxBurst)
+	if err != nil {
+		logger.Warn("voicemail: bridge init failed", "error", err)
+		_ = track.Close()
+		_ = os.Remove(track.Path())
+		return
+	}
+	bridge.OnDTMF(func(digit rune) {
+		if digit == voicemailEndDigit {
+			box.finish()
+		}
+	})
+	bridge.PlaySIP(s.voicemailBeep.Once())
+	bridge.Start()
+	logger.Info("voicemail: recording", "file", track.Path(), "max_length", s.cfg.VoicemailMaxLength)
+
+	timer := time.NewTimer(s.cfg.VoicemailMaxLength + voicemailBeepDur)
+	defer timer.Stop()
+	select {
+	case <-dialog.Context().Done():
+	case <-call.Done():
+	case <-box.Done():
+	case <-timer.C:
+	}
+	bridge.Stop()
+
+	if err := track.Close(); err != nil {
+		logger.Warn("voicemail: recording close failed", "error", err)
+		return
+	}
+	if n := track.Dropped(); n > 0 {
+		logger.Warn("voicemail: recording dropped frames, the disk fell behind", "frames", n)
+	}
+	// The beep is part of the recorded time but not of the message.
+	length := max(track.Duration()-voicemailBeepDur, 0)
+	if length < voicemailMinLength {
+		logger.Info("voicemail: no message left")
+		_ = os.Remove(track.Path())
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Missed call from %s (no message)", displayParty(call.Name, call.Number)))
+		return
+	}
+	logger.Info("voicemail: message recorded", "file", track.Path(), "length", length.Round(time.Second))
+	s.queuePostProcess([]string{track.Path()}, func(files []string) {
+		s.sendVoicemail(call, files[0], length, logger)
+	})
+}
+
+func (s *Service) voicemailOptions() recording.Options {
+	tgFormat := s.tgFormat()
+	return recording.Options{
+		Dir:        s.cfg.VoicemailDir,
+		Template:   s.cfg.RecordingTemplate,
+		Format:     s.cfg.RecordingFormat,
+		Key:        s.recordingKey,
+		SampleRate: tgFormat.SampleRate,
+		FrameBytes: tgFormat.FrameBytes(),
+	}
+}
+
+// sendVoicemail uploads the message at path to the Telegram user: Ogg/Opus
+// files as a voice message, WAV files as an audio file.
+func (s *Service) sendVoicemail(call *Call, path string, length time.Duration, logger *slog.Logger) {
+	var media any = path
+	if strings.HasSuffix(path, recording.EncryptedExt) {
+		data, err := s.decryptFile(path)
+		if err != nil {
+			logger.Warn("voicemail: decrypt failed", "file", path, "error", err)
+			return
+		}
+		media = data
+	}
+	party := displayParty(call.Name, call.Number)
+	name := filepath.Base(strings.TrimSuffix(path, recording.EncryptedExt))
+	audio := &tg.DocumentAttributeAudio{Duration: int32(length.Round(time.Second).Seconds())}
+	opts := &tg.MediaOptions{
+		FileName:   name,
+		Caption:    fmt.Sprintf("Voicemail from %s, %s", party, call.StartedAt.Format("2006-01-02 15:04")),
+		Attributes: []tg.DocumentAttribute{audio},
+	}
+	if strings.HasSuffix(name, "."+recording.FormatOGG) {
+		audio.Voice = true
+		opts.MimeType = "audio/ogg"
+	} else {
+		audio.Title = "Voicemail"
+		audio.Performer = party
+		opts.MimeType = "audio/wav"
+	}
+	rec := VoicemailRecord{
+		CallID: call.ID,
+		Number: call.Number,
+		Name:   call.Name,
+		Time:   call.StartedAt,
+		Length: length.Round(time.Second).Seconds(),
+		File:   path,
+	}
+	if _, err := s.tgClient.SendMedia(s.cfg.TGUserID, media, opts); err != nil {
+		logger.Warn("voicemail: telegram upload failed", "file", path, "error", err)
+		s.saveVoicemail(rec)
+		s.notify(s.cfg.TGUserID, fmt.Sprintf("Voicemail from %s could not be sent; it is stored as %s", party, path))
+		return
+	}
+	logger.Info("voicemail: sent to telegram", "file", path)
+	rec.Delivered = true
+	s.saveVoicemail(rec)
+}
+
+// decryptFile returns the plaintext of an encrypted recording.
+func (s *Service) decryptFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var plain bytes.Buffer
+	if err := recording.Decrypt(&plain, f, s.recordingKey); err != nil {
+		return nil, err
+	}
+	return plain.Bytes(), nil
+}
@@ -0,0 +1,227 @@
+// Package webhook POSTs bridge events as JSON to HTTP endpoints. Requests
+// to an endpoint with a secret are signed with HMAC-SHA256; failed
+// deliveries (network errors, 429 and 5xx) are retried with backoff. Each
+// endpoint has its own queue, so a slow one doesn't hold up the others.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	CallStarted        = "call.started"
+	CallAnswered       = "call.answered"
+	CallEnded          = "call.ended"
+	RegistrationFailed = "registration.failed"
+	DTMFReceived       = "dtmf.received"
+)
+
+// Types lists the event types.
+var Types = []string{CallStarted, CallAnswered, CallEnded, RegistrationFailed, DTMFReceived}
+
+// Headers of webhook requests. The signature is "sha256=" and the hex
+// HMAC-SHA256 of "<timestamp>.<body>", see Sign.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	queueSize = 256
+	// retryBase is the wait before the first retry; it doubles with each.
+	retryBase = time.Second
+)
+
+// Event is the JSON body of a webhook request.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Endpoint is a URL events are POSTed to.
+type Endpoint struct {
+	URL string
+	// Secret signs the requests; empty sends them unsigned.
+	Secret string
+	// Events are the types sent; empty sends all.
+	Events []string
+}
+
+// Sign returns the X-Webhook-Signature of body sent at timestamp (Unix
+// seconds), for receivers to check theirs against.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender delivers events to its endpoints in the background.
+type Sender struct {
+	endpoints []*endpoint
+	client    *http.Client
+	retries   int
+	logger    *slog.Logger
+	// stop cuts retry waits short on Close; closed (under mu) turns Send
+	// into a no-op afterwards.
+	stop   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type endpoint struct {
+	Endpoint
+	queue chan Event
+}
+
+// NewSender starts a sender for endpoints. Each request may take timeout;
+// a failed delivery is tried again up to retries times.
+func NewSender(endpoints []Endpoint, timeout time.Duration, retries int, logger *slog.Logger) *Sender {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	s := &Sender{
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		logger:  logger,
+		stop:    make(chan struct{}),
+	}
+	for _, e := range endpoints {
+		ep := &endpoint{Endpoint: e, queue: make(chan Event, queueSize)}
+		s.endpoints = append(s.endpoints, ep)
+		s.wg.Add(1)
+		go s.run(ep)
+	}
+	return s
+}
+
+// Send queues an event of type typ with data for the endpoints that take
+// it. It never blocks; events are dropped when an endpoint's queue is full.
+func (s *Sender) Send(typ string, data any) {
+	if s == nil {
+		return
+	}
+	ev := Event{ID: newID(), Type: typ, Time: time.Now().UTC(), Data: data}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, ep := range s.endpoints {
+		if len(ep.Events) > 0 && !slices.Contains(ep.Events, typ) {
+			continue
+		}
+		select {
+		case ep.queue <- ev:
+		default:
+			s.logger.Warn("webhook: queue full, event dropped", "url", ep.URL, "event", typ)
+		}
+	}
+}
+
+// Close delivers the queued events, without waiting to retry failed ones,
+// and stops the sender.
+func (s *Sender) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	for _, ep := range s.endpoints {
+		close(ep.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Sender) run(ep *endpoint) {
+	defer s.wg.Done()
+	for ev := range ep.queue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			s.logger.Warn("webhook: encoding event failed", "event", ev.Type, "error", err)
+			continue
+		}
+		for attempt := 0; ; attempt++ {
+			retry, err := s.post(ep, ev, body)
+			if err == nil {
+				break
+			}
+			if !retry || attempt >= s.retries || !s.wait(retryBase<<attempt) {
+				s.logger.Warn("webhook: delivery failed", "url", ep.URL, "event", ev.Type, "attempts", attempt+1, "error", err)
+				break
+			}
+		}
+	}
+}
+
+// wait sleeps for d and reports false if Close cut it short.
+func (s *Sender) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// post sends ev once and reports whether a failure is worth retrying.
+func (s *Sender) post(ep *endpoint, ev Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, ev.Type)
+	req.Header.Set(HeaderID, ev.ID)
+	if ep.Secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+		req.Header.Set(HeaderSignature, Sign(ep.Secret, ts, body))
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return retry, fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return false, nil
+}
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"log/slog"
+
+	"gotgcalls/bridge/webhook"
+)
+
+// NewWebhookSender returns the sender for webhooks.endpoints, or nil
+// without any.
+func NewWebhookSender(cfg Config, logger *slog.Logger) *webhook.Sender {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+	endpoints := make([]webhook.Endpoint, 0, len(cfg.Webhooks))
+	for _, w := range cfg.Webhooks {
+		endpoints = append(endpoints, webhook.Endpoint{URL: w.URL, Secret: w.Secret, Events: w.Events})
+	}
+	return webhook.NewSender(endpoints, cfg.WebhookTimeout, cfg.WebhookRetries, logger)
+}
+
+// SetWebhooks sends call, registration and DTMF events to hooks. Must be
+// called before Start.
+func (s *Service) SetWebhooks(hooks *webhook.Sender) {
+	s.webhooks = hooks
+}
+
+// DTMFEvent is the data of a dtmf.received webhook.
+type DTMFEvent struct {
+	CallID string `json:"call_id"`
+	Digit  string `json:"digit"`
+}
+
+// webhookCallEvent sends call.answered and call.ended; call.started is sent
+// when a call is registered.
+func (s *Service) webhookCallEvent(ev CallEvent) {
+	switch ev.To {
+	case CallAnswered:
+		// An IVR answers before Telegram does; only the first answer counts.
+		ev.Call.answerHook.Do(func() {
+			s.webhooks.Send(webhook.CallAnswered, ev.Call.Info())
+		})
+	case CallEnded:
+		s.webhooks.Send(webhook.CallEnded, ev.Call.cdrRecord(ev.Time))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// command is a Telegram command of the bridge, as listed by /help and in
+// the command menu.
+type command struct {
+	name string
+	// args is the usage after the name, e.g. "<number> [call_id]".
+	args string
+	help string
+}
+
+// commandSet registers the bridge's Telegram commands and keeps the list
+// /help and the command menu are built from.
+type commandSet struct {
+	client *tg.Client
+	// authorize reports whether a Telegram user may use the commands.
+	authorize func(userID int64) bool
+	list      []command
+}
+
+// On handles /name (or !name, .name) from authorized users with handler
+// and lists it.
+func (c *commandSet) On(name, args, help string, handler func(*tg.NewMessage) error) {
+	c.client.On("message:[!/.]"+name, func(message *tg.NewMessage) error {
+		if !c.authorize(message.SenderID()) {
+			return nil
+		}
+		return handler(message)
+	})
+	c.list = append(c.list, command{name: name, args: args, help: help})
+}
+
+// OnText handles messages matching pattern (a regexp) from authorized
+// users with handler, without listing it.
+func (c *commandSet) OnText(pattern string, handler func(*tg.NewMessage) error) {
+	c.client.On("message:"+pattern, func(message *tg.NewMessage) error {
+		if !c.authorize(message.SenderID()) {
+			return nil
+		}
+		return handler(message)
+	})
+}
+
+// Help lists the registered commands with their usage.
+func (c *commandSet) Help() string {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range c.list {
+		b.WriteString("\n/" + cmd.name)
+		if cmd.args != "" {
+			b.WriteString(" " + cmd.args)
+		}
+		b.WriteString(" - " + cmd.help)
+	}
+	return b.String()
+}
+
+// PublishMenu sets the commands as the Telegram command menu of userID's
+// chat with the bridge. Only bot accounts have a command menu; for user
+// accounts it does nothing.
+func (c *commandSet) PublishMenu(userID int64, logger *slog.Logger) {
+	me, err := c.client.GetMe()
+	if err != nil || me == nil || !me.Bot {
+		return
+	}
+	peer, err := c.client.ResolvePeer(userID)
+	if err != nil {
+		logger.Warn("telegram: command menu not set, user not resolved", "user_id", userID, "error", err)
+		return
+	}
+	menu := make([]*tg.BotCommand, 0, len(c.list))
+	for _, cmd := range c.list {
+		menu = append(menu, &tg.BotCommand{Command: cmd.name, Description: cmd.help})
+	}
+	if _, err := c.client.BotsSetBotCommands(&tg.BotCommandScopePeer{Peer: peer}, "", menu); err != nil {
+		logger.Warn("telegram: setting the command menu failed", "error", err)
+		return
+	}
+	logger.Info("telegram: command menu set", "commands", len(menu), "user_id", userID)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gotgcalls/bridge"
+	"gotgcalls/bridge/recording"
+)
+
+// decryptRecording implements
+// "sip-tg-bridge decrypt-recording [-config file] <file.enc> [out]".
+// The key comes from the recording section of the config.
+func decryptRecording(args []string) int {
+	fs := flag.NewFlagSet("decrypt-recording", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "config file holding the recording key")
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "usage: sip-tg-bridge decrypt-recording [-config config.yaml] <file.enc> [output]")
+		return 2
+	}
+	in := fs.Arg(0)
+	out := strings.TrimSuffix(in, recording.EncryptedExt)
+	if fs.NArg() == 2 {
+		out = fs.Arg(1)
+	}
+	if out == in {
+		fmt.Fprintln(os.Stderr, "output file is required when the input has no "+recording.EncryptedExt+" suffix")
+		return 2
+	}
+
+	cfg, err := bridge.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config error:", err)
+		return 1
+	}
+	key, err := bridge.RecordingKey(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "no recording.encryption_key configured")
+		return 1
+	}
+
+	src, err := os.Open(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o640)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = recording.Decrypt(dst, src, key)
+	if err == nil {
+		err = recording.FixWAVSizes(dst)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(out)
+		fmt.Fprintln(os.Stderr, "decrypt failed:", err)
+		return 1
+	}
+	fmt.Println(out)
+	return 0
+}
@@ -2,19 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"gotgcalls/bridge"
+	"gotgcalls/bridge/api"
+	"gotgcalls/bridge/grpcapi"
+	"gotgcalls/bridge/logring"
 	"gotgcalls/third_party/ubot"
 
 	"github.com/Laky-64/gologging"
 	tg "github.com/amarnathcjd/gogram/telegram"
-	"github.com/emiago/diago"
-	"github.com/emiago/sipgo"
 )
 
 func main() {
@@ -22,8 +31,16 @@ func main() {
 	gologging.SetLevel(gologging.WarnLevel)
 	gologging.GetLogger("ntgcalls").SetLevel(gologging.WarnLevel)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// ctx is the lifetime of the bridge and the control APIs; the first
+	// SIGINT/SIGTERM stops the bridge once active calls were drained.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	if len(os.Args) > 1 && os.Args[1] == "decrypt-recording" {
+		os.Exit(decryptRecording(os.Args[2:]))
+	}
 
 	configPath := "config.yaml"
 	if len(os.Args) > 1 {
@@ -37,114 +54,1088 @@ func main() {
 	}
 
 	slog.Info("app id", "id", cfg.TGAppID, "hash", cfg.TGAppHash)
-	tgClient, err := tg.NewClient(tg.ClientConfig{
-		AppID:   cfg.TGAppID,
-		AppHash: cfg.TGAppHash,
-	})
+
+	// The last log lines go into crash reports.
+	logRing := logring.New(bridge.CrashLogLines)
+	logger := slog.New(slog.NewTextHandler(io.MultiWriter(os.Stdout, logRing), nil))
+
+	b, err := bridge.New(cfg,
+		bridge.WithLogger(logger),
+		bridge.WithLogRing(logRing),
+		bridge.WithConfigPath(configPath),
+	)
 	if err != nil {
-		slog.Error("telegram client init failed", "error", err)
+		logger.Error("bridge setup failed", "error", err)
 		os.Exit(1)
 	}
-	if err := tgClient.Start(); err != nil {
-		slog.Error("telegram client start failed", "error", err)
-		os.Exit(1)
+	service := b.Service()
+	commands := &commandSet{
+		client: b.TelegramClient(),
+		authorize: func(userID int64) bool {
+			return service.AuthorizeTelegram(ctx, userID, "").Action == bridge.AuthAllow
+		},
 	}
 
-	if me, err := tgClient.GetMe(); err == nil && me != nil {
-		logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-		logger.Info("telegram session", "self_id", me.ID, "first_name", me.FirstName, "last_name", me.LastName, "username", me.Username)
-		logger.Info("telegram target", "target_user_id", cfg.TGUserID)
-	} else if err != nil {
-		slog.Warn("telegram getMe failed", "error", err)
+	if cfg.APIListen != "" {
+		if cfg.APIToken == "" {
+			logger.Warn("api: no token configured, control API is unauthenticated")
+		}
+		apiServer := api.NewServer(service, cfg.APIToken, logger)
+		go func() {
+			if err := apiServer.ListenAndServe(ctx, cfg.APIListen); err != nil {
+				logger.Error("api server failed", "error", err)
+			}
+		}()
+	}
+	if cfg.GRPCListen != "" {
+		if cfg.GRPCToken == "" {
+			logger.Warn("grpc: no token configured, gRPC API is unauthenticated")
+		}
+		grpcServer := grpcapi.NewServer(service, cfg.GRPCToken, logger)
+		go func() {
+			if err := grpcServer.ListenAndServe(ctx, cfg.GRPCListen); err != nil {
+				logger.Error("grpc server failed", "error", err)
+			}
+		}()
 	}
 
-	tgBridge := ubot.NewInstance(tgClient)
+	commands.On("call", "<number> [from=<caller id>] [at 15:00 | in 30m]", "Call a number now or later; /call echo tests your audio", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 {
+			parts := strings.Fields(strings.TrimSpace(message.Text()))
+			if len(parts) > 1 {
+				args = parts[1:]
+			}
+		}
+		var number, callerID, when string
+		for i, arg := range args {
+			if id, ok := strings.CutPrefix(arg, "from="); ok {
+				callerID = id
+			} else if arg == "at" || arg == "in" {
+				when = strings.Join(args[i:], " ")
+				break
+			} else if number == "" {
+				number = arg
+			}
+		}
+		if number == "" {
+			_, err := message.Reply("Usage: /call +79991004050 [from=+74951234567] [at 15:00 | in 30m], or /call echo")
+			return err
+		}
+		switch d := service.AuthorizeTelegram(ctx, message.SenderID(), number); d.Action {
+		case bridge.AuthReject:
+			_, err := message.Reply("Call rejected: " + d.Reason)
+			return err
+		case bridge.AuthRedirect:
+			number = d.Target
+		}
+		if when != "" {
+			text := ""
+			at, err := bridge.ParseCallTime(when, time.Now())
+			if err == nil {
+				var sc bridge.ScheduledCall
+				if sc, err = service.ScheduleCall(number, callerID, at); err == nil {
+					text = fmt.Sprintf("Call to %s scheduled for %s (%s). /unschedule %s cancels it.",
+						sc.Number, sc.At.Format("2006-01-02 15:04"), sc.ID, sc.ID)
+				}
+			}
+			if err != nil {
+				text = "Schedule failed: " + err.Error()
+			}
+			_, err = message.Reply(text)
+			return err
+		}
+		status, err := message.Reply("Dialing " + number + "...")
+		if err != nil {
+			return err
+		}
+		// The reply follows the call: ringing, answered, then how it ended.
+		var (
+			statusMu   sync.Mutex
+			statusText string
+		)
+		setStatus := func(text string) {
+			statusMu.Lock()
+			defer statusMu.Unlock()
+			if text == statusText {
+				// Retransmitted responses; Telegram refuses unchanged edits.
+				return
+			}
+			statusText = text
+			if _, err := status.Edit("Call to " + number + ": " + text); err != nil {
+				logger.Debug("call status edit failed", "error", err)
+			}
+		}
+		go func() {
+			err := service.StartCallFromCommand(ctx, number, callerID, func(p bridge.CallProgress) {
+				setStatus(p.Text())
+			})
+			if err == nil {
+				return
+			}
+			logger.Warn("call command failed", "error", err, "number", number, "caller_id", callerID)
+			var protoErr *ubot.ProtocolError
+			switch {
+			case errors.As(err, &protoErr):
+				text := "Failed: your Telegram client's call protocol is incompatible with the bridge."
+				if protoErr.TooOld {
+					text = "Failed: peer client too old, please update Telegram."
+				}
+				setStatus(text)
+			case errors.Is(err, bridge.ErrCallerID):
+				setStatus("Failed: caller ID " + callerID + " is not in sip.caller_ids.")
+			case errors.Is(err, bridge.ErrScriptRejected):
+				setStatus("Failed: rejected by script.file.")
+			case errors.Is(err, bridge.ErrTGBusy):
+				setStatus("Failed: you are already in a call.")
+			case errors.Is(err, bridge.ErrNotRegistered):
+				setStatus("Failed: " + number + " is not registered.")
+			case errors.Is(err, bridge.ErrLoopDetected):
+				setStatus("Failed: " + number + " routes back to this bridge (call loop).")
+			}
+		}()
+		return nil
+	})
 
-	ua, err := sipgo.NewUA()
-	if err != nil {
-		slog.Error("sip ua init failed", "error", err)
-		os.Exit(1)
+	commands.On("dtmf", "<digits>", "Send DTMF digits to the current call", func(message *tg.NewMessage) error {
+		digits := strings.TrimSpace(message.Args())
+		if digits == "" {
+			_, err := message.Reply("Usage: /dtmf 1234#")
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if !ok {
+			_, err := message.Reply("No active call.")
+			return err
+		}
+		if err := service.SendDTMF(call, digits); err != nil {
+			_, err = message.Reply(fmt.Sprintf("DTMF failed: %v", err))
+			return err
+		}
+		return nil
+	})
+	// Digits sent as a plain message during a bridged call go out as DTMF
+	// as well, to get through IVR menus without typing /dtmf each time.
+	commands.OnText(`^[0-9*#]+$`, func(message *tg.NewMessage) error {
+		if !message.IsPrivate() {
+			return nil
+		}
+		call, ok := service.CurrentCall()
+		if !ok {
+			return nil
+		}
+		err := service.SendDTMF(call, message.Text())
+		if err == nil || errors.Is(err, bridge.ErrNotBridged) || errors.Is(err, bridge.ErrDTMFDisabled) {
+			return nil
+		}
+		_, err = message.Reply(fmt.Sprintf("DTMF failed: %v", err))
+		return err
+	})
+
+	commands.On("say", "[sip|tg|both] <text>", "Speak text into the current call", func(message *tg.NewMessage) error {
+		text := strings.TrimSpace(message.Args())
+		leg := bridge.SaySIP
+		if first, rest, _ := strings.Cut(text, " "); first == string(bridge.SayTG) || first == string(bridge.SaySIP) || first == string(bridge.SayBoth) {
+			leg, text = bridge.SayLeg(first), rest
+		}
+		if strings.TrimSpace(text) == "" {
+			_, err := message.Reply("Usage: /say [sip|tg|both] Please hold the line")
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if !ok {
+			_, err := message.Reply("No active call.")
+			return err
+		}
+		dur, err := service.Say(ctx, call, text, leg)
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Say failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(fmt.Sprintf("Speaking to %s (%s)", leg, dur.Round(100*time.Millisecond)))
+		return err
+	})
+
+	commands.On("sms", "<number> <text>", "Send a text message to a number", func(message *tg.NewMessage) error {
+		number, text, _ := strings.Cut(strings.TrimSpace(message.Args()), " ")
+		if number == "" || strings.TrimSpace(text) == "" {
+			_, err := message.Reply("Usage: /sms <number> <text>")
+			return err
+		}
+		if err := service.SendSMS(ctx, number, text); err != nil {
+			_, err = message.Reply(fmt.Sprintf("SMS failed: %v", err))
+			return err
+		}
+		_, err := message.Reply("SMS sent to " + number)
+		return err
+	})
+
+	commands.On("transfer", "<number> [call_id]", "Transfer the SIP party to another number", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 {
+			_, err := message.Reply("Usage: /transfer <number> [call_id]")
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		if err := service.Transfer(ctx, call, args[0]); err != nil {
+			_, err = message.Reply(fmt.Sprintf("Transfer failed: %v", err))
+			return err
+		}
+		_, err := message.Reply(fmt.Sprintf("Transferring %s to %s", call.Number, args[0]))
+		return err
+	})
+
+	commands.On("hangup", "[call_id]", "Hang up the active calls, or one of them", func(message *tg.NewMessage) error {
+		calls := service.HangupCalls(strings.TrimSpace(message.Args()))
+		if len(calls) == 0 {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		parties := make([]string, 0, len(calls))
+		for _, call := range calls {
+			parties = append(parties, fmt.Sprintf("%s (call %s)", call.Number, call.ID))
+		}
+		_, err := message.Reply("Hung up " + strings.Join(parties, ", "))
+		return err
+	})
+
+	// /mute, /hold and /unmute pause the audio of a call without touching
+	// either leg.
+	mute := func(message *tg.NewMessage, mode bridge.MuteMode) error {
+		call, ok := service.CurrentCall()
+		if id := strings.TrimSpace(message.Args()); id != "" {
+			call, ok = service.Call(id)
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		if err := service.SetMute(call, mode); err != nil {
+			_, err = message.Reply(fmt.Sprintf("Mute failed: %v", err))
+			return err
+		}
+		var text string
+		switch mode {
+		case bridge.MuteMic:
+			text = "Muted: " + call.Number + " no longer hears you. /unmute resumes."
+		case bridge.MuteHold:
+			text = call.Number + " on hold: neither of you hears the other. /unmute resumes."
+		default:
+			text = "Audio with " + call.Number + " resumed."
+		}
+		_, err := message.Reply(text)
+		return err
 	}
+	commands.On("mute", "[call_id]", "Stop the SIP party hearing you", func(message *tg.NewMessage) error {
+		return mute(message, bridge.MuteMic)
+	})
+	commands.On("hold", "[call_id]", "Pause the audio of a call both ways", func(message *tg.NewMessage) error {
+		return mute(message, bridge.MuteHold)
+	})
+	commands.On("unmute", "[call_id]", "Resume the audio after /mute or /hold", func(message *tg.NewMessage) error {
+		return mute(message, bridge.MuteOff)
+	})
 
-	udpTransport := diago.Transport{
-		Transport:    "udp",
-		BindHost:     "0.0.0.0",
-		BindPort:     cfg.SIPBindPort,
-		ExternalHost: cfg.SIPExternalIP,
+	decide := func(message *tg.NewMessage, answer bool) error {
+		call, ok := service.PendingCall()
+		if id := strings.TrimSpace(message.Args()); id != "" {
+			call, ok = service.Call(id)
+		}
+		if !ok {
+			_, err := message.Reply("No call is waiting for an answer.")
+			return err
+		}
+		var (
+			text string
+			err  error
+		)
+		if answer {
+			text = "Answering call from " + call.Number
+			err = service.AnswerCall(call)
+		} else {
+			text = "Declined call from " + call.Number
+			err = service.DeclineCall(call)
+		}
+		if err != nil {
+			_, err = message.Reply(err.Error())
+			return err
+		}
+		_, err = message.Reply(text)
+		return err
 	}
-	tcpTransport := diago.Transport{
-		Transport:    "tcp",
-		BindHost:     "0.0.0.0",
-		BindPort:     cfg.SIPBindPort,
-		ExternalHost: cfg.SIPExternalIP,
+	commands.On("answer", "", "Take a call waiting for confirmation or a waiting call", func(message *tg.NewMessage) error {
+		return decide(message, true)
+	})
+	commands.On("decline", "", "Reject a call waiting for confirmation or a waiting call", func(message *tg.NewMessage) error {
+		return decide(message, false)
+	})
+	// /scheduled lists the calls queued with /call ... at; /unschedule
+	// drops one.
+	commands.On("scheduled", "", "List the scheduled calls", func(message *tg.NewMessage) error {
+		calls := service.ScheduledCalls()
+		if len(calls) == 0 {
+			_, err := message.Reply("No scheduled calls.")
+			return err
+		}
+		var b strings.Builder
+		for _, sc := range calls {
+			fmt.Fprintf(&b, "%s %s %s", sc.At.Format("2006-01-02 15:04"), sc.Number, sc.ID)
+			if sc.Attempts > 0 {
+				fmt.Fprintf(&b, " (attempt %d, last error: %s)", sc.Attempts+1, sc.LastError)
+			}
+			b.WriteString("\n")
+		}
+		_, err := message.Reply(b.String())
+		return err
+	})
+	commands.On("unschedule", "<id>", "Cancel a scheduled call", func(message *tg.NewMessage) error {
+		id := strings.TrimSpace(message.Args())
+		if id == "" {
+			_, err := message.Reply("Usage: /unschedule <id> (see /scheduled)")
+			return err
+		}
+		text := "Scheduled call " + id + " cancelled."
+		if err := service.CancelScheduledCall(id); err != nil {
+			text = err.Error()
+		}
+		_, err := message.Reply(text)
+		return err
+	})
+	// /history lists the last calls, /voicemails the last voicemail
+	// messages (from storage.state_file).
+	commands.On("history", "[n]", "List the last calls", func(message *tg.NewMessage) error {
+		limit := 10
+		if n, err := strconv.Atoi(strings.TrimSpace(message.Args())); err == nil && n > 0 {
+			limit = min(n, 50)
+		}
+		records := service.CallHistory(limit)
+		if len(records) == 0 {
+			_, err := message.Reply("No calls recorded.")
+			return err
+		}
+		var b strings.Builder
+		for _, rec := range records {
+			party, name := rec.Callee, rec.CalleeName
+			if rec.Direction == string(bridge.CallInbound) {
+				party, name = rec.Caller, rec.CallerName
+			}
+			if name != "" {
+				party = name + " (" + party + ")"
+			}
+			fmt.Fprintf(&b, "%s %s %s, %s, %s\n", rec.StartTime.Local().Format("01-02 15:04"), rec.Direction, party,
+				(time.Duration(rec.Duration) * time.Second).String(), rec.HangupCause)
+		}
+		_, err := message.Reply(b.String())
+		return err
+	})
+	commands.On("voicemails", "", "List the last voicemail messages", func(message *tg.NewMessage) error {
+		records := service.Voicemails(10)
+		if len(records) == 0 {
+			_, err := message.Reply("No voicemail.")
+			return err
+		}
+		var b strings.Builder
+		for _, rec := range records {
+			party := rec.Number
+			if rec.Name != "" {
+				party = rec.Name + " (" + rec.Number + ")"
+			}
+			fmt.Fprintf(&b, "%s %s, %.0fs: %s", rec.Time.Local().Format("01-02 15:04"), party, rec.Length, rec.File)
+			if !rec.Delivered {
+				b.WriteString(" (not delivered)")
+			}
+			b.WriteString("\n")
+		}
+		_, err := message.Reply(b.String())
+		return err
+	})
+
+	// /alias names a number for caller ID (or lists the aliases without
+	// arguments); /unalias drops one. Aliases survive restarts.
+	commands.On("alias", "<number> <name>", "Name a caller, or list the names", func(message *tg.NewMessage) error {
+		number, name, _ := strings.Cut(strings.TrimSpace(message.Args()), " ")
+		if number == "" {
+			aliases := service.ContactAliases()
+			if len(aliases) == 0 {
+				_, err := message.Reply("No aliases. Usage: /alias <number> <name>")
+				return err
+			}
+			numbers := slices.Sorted(maps.Keys(aliases))
+			var b strings.Builder
+			for _, n := range numbers {
+				fmt.Fprintf(&b, "%s: %s\n", n, aliases[n])
+			}
+			_, err := message.Reply(b.String())
+			return err
+		}
+		text := "Alias saved."
+		if err := service.SetContactAlias(number, name); err != nil {
+			text = err.Error()
+		}
+		_, err := message.Reply(text)
+		return err
+	})
+	commands.On("unalias", "<number>", "Remove a caller's name", func(message *tg.NewMessage) error {
+		text := "Alias removed."
+		if err := service.RemoveContactAlias(strings.TrimSpace(message.Args())); err != nil {
+			text = err.Error()
+		}
+		_, err := message.Reply(text)
+		return err
+	})
+
+	// /swap switches between the current call and the one on hold (call
+	// waiting).
+	commands.On("swap", "", "Switch between the current and the held call", func(message *tg.NewMessage) error {
+		call, err := service.SwapHeld(cfg.TGUserID)
+		if err != nil {
+			_, err = message.Reply(err.Error())
+			return err
+		}
+		_, err = message.Reply("Resumed call with " + call.Number)
+		return err
+	})
+
+	// /block and /allow add caller rules (or list them without arguments);
+	// /unblock and /disallow remove runtime rules again.
+	callerRule := func(message *tg.NewMessage, list bridge.CallerList, add bool) error {
+		pattern := strings.TrimSpace(message.Args())
+		if pattern == "" {
+			if !add {
+				_, err := message.Reply("Usage: /unblock|/disallow <number or /regex/>")
+				return err
+			}
+			_, err := message.Reply(service.CallerRules().String())
+			return err
+		}
+		var err error
+		if add {
+			err = service.AddCallerRule(list, pattern)
+		} else {
+			err = service.RemoveCallerRule(list, pattern)
+		}
+		if err != nil {
+			_, err = message.Reply(err.Error())
+			return err
+		}
+		_, err = message.Reply(service.CallerRules().String())
+		return err
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-
-	sipBridge := diago.NewDiago(ua,
-		diago.WithTransport(udpTransport),
-		diago.WithTransport(tcpTransport),
-		diago.WithLogger(logger),
-		diago.WithMediaConfig(diago.MediaConfig{
-			Codecs: bridge.SIPCodecs(cfg),
-		}),
-	)
+	commands.On("block", "<number|/regex/>", "Reject a caller, or list the caller rules", func(message *tg.NewMessage) error {
+		return callerRule(message, bridge.CallerDeny, true)
+	})
+	commands.On("unblock", "<number|/regex/>", "Remove a block rule", func(message *tg.NewMessage) error {
+		return callerRule(message, bridge.CallerDeny, false)
+	})
+	commands.On("allow", "<number|/regex/>", "Allow a caller, or list the caller rules", func(message *tg.NewMessage) error {
+		return callerRule(message, bridge.CallerAllow, true)
+	})
+	commands.On("disallow", "<number|/regex/>", "Remove an allow rule", func(message *tg.NewMessage) error {
+		return callerRule(message, bridge.CallerAllow, false)
+	})
 
-	service := bridge.NewService(cfg, sipBridge, tgBridge, logger)
+	commands.On("record", "start|stop [call_id]", "Start or stop recording a call", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 || (args[0] != "start" && args[0] != "stop") {
+			_, err := message.Reply("Usage: /record start|stop [call_id]")
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		var (
+			files []string
+			err   error
+			text  string
+		)
+		if args[0] == "start" {
+			files, err = service.StartRecording(call)
+			text = "Recording to"
+		} else {
+			files, err = service.StopRecording(call)
+			text = "Recording saved to"
+		}
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Recording failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(text + ":\n" + strings.Join(files, "\n"))
+		return err
+	})
 
-	tgClient.On("message:[!/.]call", func(message *tg.NewMessage) error {
-		if message.SenderID() != cfg.TGUserID {
-			return nil
+	commands.On("transcribe", "on|off [sip|tg|both] [call_id]", "Post what is said in a call as messages", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 3 || (args[0] != "on" && args[0] != "off") {
+			_, err := message.Reply("Usage: /transcribe on|off [sip|tg|both] [call_id]")
+			return err
 		}
-		number := strings.TrimSpace(message.Args())
-		if number == "" {
-			text := strings.TrimSpace(message.Text())
-			parts := strings.Fields(text)
-			if len(parts) > 1 {
-				number = parts[1]
+		legs := cfg.Transcription
+		if len(args) > 1 {
+			if l, err := bridge.ParseTranscribeLegs(args[1]); err == nil {
+				legs, args = l, append(args[:1], args[2:]...)
 			}
 		}
-		if number == "" {
-			_, err := message.Reply("Usage: /call +79991004050")
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		} else if len(args) > 2 {
+			ok = false
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
 			return err
 		}
-		_, err := message.Reply("Dialing...")
+		var err error
+		text := "Transcription stopped."
+		if args[0] == "on" {
+			err = service.StartTranscription(call, legs)
+			text = fmt.Sprintf("Transcribing %s (%s).", call.Number, legs)
+		} else {
+			err = service.StopTranscription(call)
+		}
 		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Transcription failed: %v", err))
 			return err
 		}
-		go func() {
-			if err := service.StartCallFromCommand(ctx, number); err != nil {
-				logger.Warn("call command failed", "error", err, "number", number)
+		_, err = message.Reply(text)
+		return err
+	})
+
+	commands.On("dump", "on|off [call_id]", "Write debug dumps of calls", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+			_, err := message.Reply("Usage: /dump on|off [call_id]")
+			return err
+		}
+		if len(args) == 1 {
+			service.SetDebugDump(args[0] == "on")
+			text := "Debug dumps off."
+			if args[0] == "on" {
+				text = "Debug dumps on: calls are dumped to " + cfg.RTPDumpDir + "."
+			}
+			_, err := message.Reply(text)
+			return err
+		}
+		call, ok := service.Call(args[1])
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		var (
+			files []string
+			err   error
+			text  string
+		)
+		if args[0] == "on" {
+			files, err = service.StartDump(call)
+			text = "Dumping to"
+		} else {
+			files, err = service.StopDump(call)
+			text = "Dump saved to"
+		}
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Debug dump failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(text + ":\n" + strings.Join(files, "\n"))
+		return err
+	})
+
+	commands.On("rtplog", "on|off [call_id]", "Log the RTP events of calls", func(message *tg.NewMessage) error {
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+			_, err := message.Reply("Usage: /rtplog on|off [call_id]")
+			return err
+		}
+		if len(args) == 1 {
+			service.SetRTPEventLog(args[0] == "on")
+			text := "RTP event logs off."
+			if args[0] == "on" {
+				text = "RTP event logs on: logs are written to " + cfg.RTPEventsDir + " at call end."
+			}
+			_, err := message.Reply(text)
+			return err
+		}
+		call, ok := service.Call(args[1])
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		var (
+			file string
+			err  error
+			text string
+		)
+		if args[0] == "on" {
+			file, err = service.StartRTPLog(call)
+			text = "Logging RTP events, written at call end to "
+		} else {
+			file, err = service.StopRTPLog(call)
+			text = "RTP event log saved to "
+		}
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("RTP event log failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(text + file)
+		return err
+	})
+
+	commands.On("invite", "<number> [chat_id]", "Dial a number into a voice chat", func(message *tg.NewMessage) error {
+		const usage = "Usage: /invite <number> [chat_id]"
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		var chatID int64
+		if len(args) == 2 {
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				_, err = message.Reply(usage)
+				return err
 			}
+			chatID = id
+		} else {
+			chats := service.GroupCalls()
+			if len(chats) != 1 {
+				_, err := message.Reply("Pass the chat_id of the voice chat. " + usage)
+				return err
+			}
+			chatID = chats[0]
+		}
+		call, err := service.Invite(ctx, chatID, args[0])
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Invite failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(fmt.Sprintf("Dialing %s into voice chat %d (call %s)", call.Number, chatID, call.ID))
+		return err
+	})
+
+	commands.On("conference", "[chat_id] [call_id]", "Move a call into a voice chat", func(message *tg.NewMessage) error {
+		const usage = "Usage: /conference [chat_id] [call_id]"
+		args := strings.Fields(message.Args())
+		if len(args) > 2 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		var chatID int64
+		if len(args) > 0 {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				_, err = message.Reply(usage)
+				return err
+			}
+			chatID = id
+		} else {
+			chats := service.GroupCalls()
+			if len(chats) != 1 {
+				_, err := message.Reply("Pass the chat_id of the voice chat. " + usage)
+				return err
+			}
+			chatID = chats[0]
+		}
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		if err := service.Conference(ctx, call, chatID); err != nil {
+			_, err = message.Reply(fmt.Sprintf("Conference failed: %v", err))
+			return err
+		}
+		_, err := message.Reply(fmt.Sprintf("Moved %s into voice chat %d", call.Number, chatID))
+		return err
+	})
+
+	commands.On("add", "<number> [call_id]", "Dial another number into a running call", func(message *tg.NewMessage) error {
+		const usage = "Usage: /add +79991004050 [call_id]"
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		added, err := service.AddToCall(ctx, call, args[0])
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Add failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(fmt.Sprintf("Dialing %s into the call (call %s)", added.Number, added.ID))
+		return err
+	})
+
+	commands.On("gain", "[tg] <0-200> [call_id]", "Set how loud a party is mixed for the others", func(message *tg.NewMessage) error {
+		usage := fmt.Sprintf("Usage: /gain <0-%d> [call_id], or /gain tg <0-%d> [call_id] for the Telegram side", bridge.MaxVolume, bridge.MaxVolume)
+		args := strings.Fields(message.Args())
+		telegram := len(args) > 0 && args[0] == "tg"
+		if telegram {
+			args = args[1:]
+		}
+		if len(args) == 0 || len(args) > 2 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		percent, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+		if err != nil {
+			_, err = message.Reply(usage)
+			return err
+		}
+		call, ok := service.CurrentCall()
+		if len(args) == 2 {
+			call, ok = service.Call(args[1])
+		}
+		if !ok {
+			_, err := message.Reply("No such call.")
+			return err
+		}
+		reply := fmt.Sprintf("%s is mixed at %d%%", call.Number, percent)
+		if telegram {
+			err = service.SetConferenceTGGain(call, percent)
+			reply = fmt.Sprintf("Telegram is mixed at %d%%", percent)
+		} else {
+			err = service.SetConferenceGain(call, percent)
+		}
+		if err != nil {
+			_, err = message.Reply(fmt.Sprintf("Gain failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(reply)
+		return err
+	})
+
+	commands.On("participants", "[chat_id]", "List the members of a bridged voice chat", func(message *tg.NewMessage) error {
+		chats := service.GroupCalls()
+		if arg := strings.TrimSpace(message.Args()); arg != "" {
+			chatID, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				_, err = message.Reply("Usage: /participants [chat_id]")
+				return err
+			}
+			chats = []int64{chatID}
+		}
+		if len(chats) == 0 {
+			_, err := message.Reply("Not in a voice chat.")
+			return err
+		}
+		var replies []string
+		for _, chatID := range chats {
+			participants, err := service.Participants(chatID)
+			if err != nil {
+				replies = append(replies, fmt.Sprintf("Voice chat %d: %v", chatID, err))
+				continue
+			}
+			replies = append(replies, bridge.FormatParticipants(chatID, participants))
+		}
+		_, err := message.Reply(strings.Join(replies, "\n\n"))
+		return err
+	})
+
+	commands.On("listen", "<number|all> [chat_id]", "Hear one voice chat participant or everyone", func(message *tg.NewMessage) error {
+		const usage = "Usage: /listen <number|all> [chat_id]"
+		args := strings.Fields(message.Args())
+		if len(args) == 0 || len(args) > 2 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		index := 0
+		if args[0] != "all" {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				_, err = message.Reply(usage)
+				return err
+			}
+			index = n
+		}
+		var chatID int64
+		if len(args) == 2 {
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				_, err = message.Reply(usage)
+				return err
+			}
+			chatID = id
+		} else {
+			chats := service.GroupCalls()
+			switch len(chats) {
+			case 0:
+				_, err := message.Reply("Not in a voice chat.")
+				return err
+			case 1:
+				chatID = chats[0]
+			default:
+				_, err := message.Reply("In several voice chats, pass the chat_id. " + usage)
+				return err
+			}
+		}
+		p, err := service.ListenToIndex(chatID, index)
+		switch {
+		case err != nil:
+			_, err = message.Reply(fmt.Sprintf("Listen failed: %v", err))
+		case p == nil:
+			_, err = message.Reply("Listening to everyone.")
+		default:
+			_, err = message.Reply(fmt.Sprintf("Listening to %s only.", p.Name))
+		}
+		return err
+	})
+
+	commands.On("volume", "<number|call> <0-200%|mute|unmute> [chat_id]", "Change a participant's or the call's volume on the SIP side", func(message *tg.NewMessage) error {
+		usage := fmt.Sprintf("Usage: /volume <number|call> <0-%d%%|mute|unmute> [chat_id]", bridge.MaxVolume)
+		args := strings.Fields(message.Args())
+		if len(args) < 2 || len(args) > 3 {
+			_, err := message.Reply(usage)
+			return err
+		}
+		index := 0
+		if args[0] != "call" {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				_, err = message.Reply(usage)
+				return err
+			}
+			index = n
+		}
+		level := strings.TrimSuffix(args[1], "%")
+		percent, err := strconv.Atoi(level)
+		if level != "mute" && level != "unmute" && (err != nil || percent < 0 || percent > bridge.MaxVolume) {
+			_, err = message.Reply(usage)
+			return err
+		}
+		if index == 0 && (level == "mute" || level == "unmute") {
+			_, err = message.Reply("Mute single participants; for the whole call use /volume call 0.")
+			return err
+		}
+		var chatID int64
+		if len(args) == 3 {
+			id, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				_, err = message.Reply(usage)
+				return err
+			}
+			chatID = id
+		} else {
+			chats := service.GroupCalls()
+			switch {
+			case len(chats) == 1:
+				chatID = chats[0]
+			case len(chats) > 1:
+				_, err := message.Reply("In several voice chats, pass the chat_id. " + usage)
+				return err
+			case index == 0:
+				// A private call.
+				call, ok := service.CurrentCall()
+				if !ok {
+					_, err := message.Reply("No active call.")
+					return err
+				}
+				chatID = call.ChatID
+			default:
+				_, err := message.Reply("Not in a voice chat.")
+				return err
+			}
+		}
+		var (
+			p    *bridge.Participant
+			text string
+		)
+		switch {
+		case index == 0:
+			err = service.SetCallVolume(chatID, percent)
+			text = fmt.Sprintf("Call volume set to %d%%.", percent)
+		case level == "mute" || level == "unmute":
+			p, err = service.SetMutedIndex(chatID, index, level == "mute")
+			if err == nil {
+				text = fmt.Sprintf("%s %sd for the SIP side.", p.Name, level)
+			}
+		default:
+			p, err = service.SetVolumeIndex(chatID, index, percent)
+			if err == nil {
+				text = fmt.Sprintf("%s set to %d%%.", p.Name, percent)
+			}
+		}
+		if err != nil {
+			text = fmt.Sprintf("Volume change failed: %v", err)
+		}
+		_, err = message.Reply(text)
+		return err
+	})
+
+	commands.On("autojoin", "[<chat_id> [start] <number>... | off <chat_id>]", "Dial numbers into a voice chat when it starts", func(message *tg.NewMessage) error {
+		const usage = "Usage: /autojoin [<chat_id> [start] <number>... | off <chat_id>]"
+		args := strings.Fields(message.Args())
+		if len(args) == 0 {
+			_, err := message.Reply(bridge.FormatAutoJoin(service.AutoJoinRules()))
+			return err
+		}
+		if args[0] == "off" {
+			if len(args) != 2 {
+				_, err := message.Reply(usage)
+				return err
+			}
+			chatID, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				_, err = message.Reply(usage)
+				return err
+			}
+			reply := fmt.Sprintf("Voice chat %d is no longer joined automatically", chatID)
+			if !service.RemoveAutoJoin(chatID) {
+				reply = fmt.Sprintf("Voice chat %d was not joined automatically", chatID)
+			}
+			_, err = message.Reply(reply)
+			return err
+		}
+		chatID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || len(args) < 2 {
+			_, err = message.Reply(usage)
+			return err
+		}
+		rule := bridge.VoiceChatAutoJoin{ChatID: chatID, Numbers: args[1:]}
+		if args[1] == "start" {
+			rule.StartScheduled = true
+			rule.Numbers = args[2:]
+		}
+		if err := service.SetAutoJoin(rule); err != nil {
+			_, err = message.Reply(fmt.Sprintf("Auto-join failed: %v", err))
+			return err
+		}
+		_, err = message.Reply(fmt.Sprintf("Will dial %s into voice chat %d when it starts", strings.Join(rule.Numbers, ", "), chatID))
+		return err
+	})
+
+	commands.On("status", "", "Show the bridge status and active calls", func(message *tg.NewMessage) error {
+		_, err := message.Reply(service.Status().String())
+		return err
+	})
+
+	// /siprestart rebuilds the SIP stack from the config file (bind port,
+	// external IP, ...) without touching the Telegram session.
+	commands.On("siprestart", "[force]", "Rebuild the SIP stack from the config file", func(message *tg.NewMessage) error {
+		res, err := service.RestartSIP(strings.TrimSpace(message.Args()) == "force")
+		switch {
+		case errors.Is(err, bridge.ErrCallsActive):
+			_, err = message.Reply(fmt.Sprintf("SIP restart refused: %v. Reply /siprestart force to hang them up.", err))
+			return err
+		case err != nil:
+			_, err = message.Reply(fmt.Sprintf("SIP restart failed: %v", err))
+			return err
+		}
+		text := "SIP stack restarted, listening on " + strings.Join(res.Transports, ", ")
+		if len(res.Changed) > 0 {
+			text += "\nChanged: " + strings.Join(res.Changed, ", ")
+		}
+		if res.HungUp > 0 {
+			text += fmt.Sprintf("\nHung up %d call(s)", res.HungUp)
+		}
+		_, err = message.Reply(text)
+		return err
+	})
+
+	commands.On("testcall", "[number]", "Call an echo test number", func(message *tg.NewMessage) error {
+		number := strings.TrimSpace(message.Args())
+		if number == "" && cfg.TestCallNumber == "" {
+			_, err := message.Reply("Usage: /testcall <echo test number> (or set test_call.number)")
+			return err
+		}
+		if _, err := message.Reply("Placing test call..."); err != nil {
+			return err
+		}
+		go func() {
+			_, _ = message.Reply(service.TestCall(ctx, number).String())
 		}()
 		return nil
 	})
 
-	if cfg.SIPAuthUser != "" && cfg.SIPAuthPass != "" {
-		go func() {
-			recipient := bridge.SIPRegisterRecipient(cfg)
-			err := sipBridge.Register(ctx, recipient, diago.RegisterOptions{
-				Username:  cfg.SIPAuthUser,
-				Password:  cfg.SIPAuthPass,
-				ProxyHost: cfg.SIPProvider,
-				Expiry:    3600 * time.Second,
-			})
-			if err != nil && ctx.Err() == nil {
-				logger.Warn("sip registration failed", "error", err)
+	commands.On("simulate", "<number> [from=<caller id>] | in <caller> [called]", "Show how a call would be routed", func(message *tg.NewMessage) error {
+		const usage = "Usage: /simulate +79991004050 [from=+74951234567], or /simulate in <caller> [called number]"
+		args := strings.Fields(message.Args())
+		var steps []bridge.SimulationStep
+		switch {
+		case len(args) >= 2 && len(args) <= 3 && args[0] == "in":
+			var called string
+			if len(args) == 3 {
+				called = args[2]
 			}
-		}()
-	}
+			steps = service.SimulateInbound(args[1], called)
+		case len(args) >= 1 && len(args) <= 2 && args[0] != "in":
+			var callerID string
+			if len(args) == 2 {
+				id, ok := strings.CutPrefix(args[1], "from=")
+				if !ok {
+					_, err := message.Reply(usage)
+					return err
+				}
+				callerID = id
+			}
+			steps = service.SimulateOutbound(args[0], callerID)
+		default:
+			_, err := message.Reply(usage)
+			return err
+		}
+		var b strings.Builder
+		b.WriteString("Simulation (nothing was dialed):")
+		for _, step := range steps {
+			fmt.Fprintf(&b, "\n%s: %s", step.Step, step.Result)
+		}
+		_, err := message.Reply(b.String())
+		return err
+	})
 
-	err = service.Start(ctx)
+	commands.On("help", "", "List the commands", func(message *tg.NewMessage) error {
+		_, err := message.Reply(commands.Help())
+		return err
+	})
+	commands.PublishMenu(cfg.TGUserID, logger)
 
-	// Graceful shutdown
-	logger.Info("shutting down...")
+	go func() {
+		<-sigCtx.Done()
+		stopSignals()
+		// A second signal skips the wait and hangs up right away.
+		forceCtx, stopForce := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stopForce()
+		logger.Info("shutdown requested, no longer accepting calls")
+		_ = b.Stop(forceCtx)
+	}()
 
-	// Close telegram bridge and client
-	tgBridge.Close()
-	tgClient.Stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := service.Reload(); err != nil {
+				logger.Warn("config reload failed", "error", err)
+			}
+		}
+	}()
 
-	if err != nil && ctx.Err() == nil {
-		slog.Error("bridge stopped with error", "error", err)
+	if err := b.Start(ctx); err != nil {
+		logger.Error("bridge start failed", "error", err)
+		os.Exit(1)
+	}
+	if err := b.Wait(); err != nil {
+		logger.Error("bridge stopped with error", "error", err)
 		os.Exit(1)
 	}
 	logger.Info("shutdown complete")
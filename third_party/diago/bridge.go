@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/emiago/diago/media"
@@ -66,7 +67,9 @@ func (b *Bridge) AddDialogSession(d DialogSession) error {
 		_ = m.audioWriterProps(&mprops)
 
 		err := func() error {
-			if origProps.Codec != mprops.Codec {
+			// Payload types may differ per leg; each writer uses its own.
+			oc, mc := origProps.Codec, mprops.Codec
+			if !strings.EqualFold(oc.Name, mc.Name) || oc.SampleRate != mc.SampleRate || oc.NumChannels != mc.NumChannels {
 				return fmt.Errorf("no transcoding supported in bridge codec1=%+v codec2=%+v", origProps.Codec, mprops.Codec)
 			}
 			return nil
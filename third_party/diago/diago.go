@@ -10,9 +10,11 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emiago/diago/media"
@@ -27,11 +29,15 @@ type Diago struct {
 	client     *sipgo.Client
 	server     *sipgo.Server
 	transports []Transport
+	// transportsMu guards transports once serving, as SetExternalIP and
+	// ephemeral port binding update them.
+	transportsMu sync.RWMutex
 
 	serveHandler ServeDialogFunc
 
 	auth      sipgo.DigestAuth
 	mediaConf MediaConfig
+	rel100    string
 
 	log *slog.Logger
 
@@ -78,6 +84,14 @@ type Transport struct {
 
 	RewriteContact bool
 
+	// NoRport leaves the rport parameter (RFC 3581) out of Via headers, for
+	// servers that reject it.
+	NoRport bool
+
+	// TCPFallback sends INVITEs too large for UDP (RFC 3261 18.1.1) over
+	// the TCP transport of the same address family, if there is one.
+	TCPFallback bool
+
 	client *sipgo.Client
 }
 
@@ -91,7 +105,11 @@ func WithTransport(t Transport) DiagoOption {
 				network = "ip6"
 			}
 			var err error
-			t.mediaBindIP, _, err = sip.ResolveInterfacesIP(network, nil)
+			if network == "ip6" {
+				t.mediaBindIP, err = resolveGlobalIP6()
+			} else {
+				t.mediaBindIP, _, err = sip.ResolveInterfacesIP(network, nil)
+			}
 			if err != nil {
 				dg.log.Error("failed to resolve real IP", "error", err)
 			}
@@ -146,6 +164,9 @@ type MediaConfig struct {
 	bindIP     net.IP
 	externalIP net.IP
 	rtpNAT     int
+	// video is the direction of an H.264 stream next to the audio; empty
+	// for audio only.
+	video string
 
 	// TODO, For now it is global on media package
 	// RTPPortStart int
@@ -260,7 +281,8 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 			return dg.handleReInvite(req, tx, id)
 		}
 
-		tran, _ := dg.getTransport(req.Transport())
+		sourceHost, _, _ := net.SplitHostPort(req.Source())
+		tran, _ := dg.getTransportFor(req.Transport(), sourceHost)
 
 		// Proceed as new call
 		dialogUA := sipgo.DialogUA{
@@ -286,6 +308,10 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 				externalIP: tran.MediaExternalIP,
 			},
 		}
+		if dg.rel100 != Rel100Off && (hasOptionTag(req, "Supported", optionTag100rel) || hasOptionTag(req, "Require", optionTag100rel)) {
+			dWrap.rel100 = true
+			dWrap.pracked = make(chan struct{}, 1)
+		}
 
 		defer closeAndLog(dWrap, "closing dialog server returned error")
 
@@ -395,10 +421,31 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
 		res.AppendHeader(sip.NewHeader("Allow", strings.Join(methods, ", ")))
 		res.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
-		// res.AppendHeader(sip.NewHeader("Supported", "replaces, 100rel"))
+		if dg.rel100 != Rel100Off {
+			res.AppendHeader(sip.NewHeader("Supported", optionTag100rel))
+		}
 		return tx.Respond(res)
 	}))
 
+	dg.server.OnPrack(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		d, err := dg.cache.MatchDialogServer(req)
+		if err != nil {
+			return handleNoDialog(req, tx, err)
+		}
+		return d.readPrack(req, tx)
+	}))
+
+	dg.server.OnUpdate(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		sd, cd, err := dg.cache.MatchDialog(req)
+		if err != nil {
+			return handleNoDialog(req, tx, err)
+		}
+		if cd != nil {
+			return cd.handleUpdate(req, tx)
+		}
+		return sd.handleUpdate(req, tx)
+	}))
+
 	dg.server.OnRefer(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
 		sd, cd, err := dg.cache.MatchDialog(req)
 		if err != nil {
@@ -487,7 +534,9 @@ func (dg *Diago) serve(ctx context.Context, f ServeDialogFunc, readyCh func()) e
 					tran.BindPort = port
 					tran.ExternalPort = port
 					tran.client = dg.createClient(tran)
+					dg.transportsMu.Lock()
 					dg.transports[i] = tran
+					dg.transportsMu.Unlock()
 				}
 				readyCh()
 
@@ -530,11 +579,34 @@ func (dg *Diago) ServeBackground(ctx context.Context, f ServeDialogFunc) error {
 	return nil
 }
 
+// ServeReady is Serve calling ready once every transport listens.
+func (dg *Diago) ServeReady(ctx context.Context, f ServeDialogFunc, ready func()) error {
+	var listening atomic.Int32
+	n := int32(len(dg.transports))
+	return dg.serve(ctx, f, func() {
+		if listening.Add(1) == n {
+			ready()
+		}
+	})
+}
+
+// Close closes the transport and transaction layers of the user agent,
+// ending its dialogs. Cancel the Serve context first.
+func (dg *Diago) Close() error {
+	return dg.ua.Close()
+}
+
 // HandleFunc registers you handler function for dialog. Must be called before serving request
 func (dg *Diago) HandleFunc(f ServeDialogFunc) {
 	dg.serveHandler = f
 }
 
+// OnRegister handles REGISTER requests with f, for acting as a registrar.
+// Must be called before serving requests.
+func (dg *Diago) OnRegister(f func(req *sip.Request, tx sip.ServerTransaction)) {
+	dg.server.OnRegister(f)
+}
+
 type InviteOptions struct {
 	Originator DialogSession
 	OnResponse func(res *sip.Response) error
@@ -615,6 +687,11 @@ type NewDialogOptions struct {
 	Transport string
 	// TransportID matches diago transport by ID instead protocol
 	TransportID string
+	// Video is the direction (sdp.Mode...) in which H.264 video is offered
+	// next to the audio; empty offers audio only.
+	//
+	// Experimental
+	Video string
 }
 
 // NewDialog creates a new client dialog session after you can perform dialog Invite
@@ -628,7 +705,7 @@ func (dg *Diago) NewDialog(recipient sip.Uri, opts NewDialogOptions) (d *DialogC
 		}
 
 	}
-	tran, exists := dg.findTransport(transport, opts.TransportID)
+	tran, exists := dg.findTransport(transport, opts.TransportID, recipient.Host)
 	if !exists {
 		return nil, fmt.Errorf("transport %s does not exists", transport)
 	}
@@ -653,8 +730,23 @@ func (dg *Diago) NewDialog(recipient sip.Uri, opts NewDialogOptions) (d *DialogC
 				InviteRequest: inviteReq,
 			},
 		},
+		rel100: dg.rel100,
 	}
 	d.Init()
+	if tran.TCPFallback && transport == "udp" {
+		d.tcpFallback = func() (*sipgo.DialogUA, bool) {
+			t, ok := dg.getTransportFor("tcp", recipient.Host)
+			if !ok {
+				return nil, false
+			}
+			ua := &sipgo.DialogUA{
+				Client:         dg.getClient(&t),
+				RewriteContact: t.RewriteContact,
+			}
+			dg.contactHDRFromTransport(t, &ua.ContactHDR)
+			return ua, true
+		}
+	}
 
 	// Create media
 	// TODO explicit media format passing
@@ -663,6 +755,7 @@ func (dg *Diago) NewDialog(recipient sip.Uri, opts NewDialogOptions) (d *DialogC
 		secureRTP:  tran.MediaSRTP,
 		bindIP:     tran.mediaBindIP,
 		externalIP: tran.MediaExternalIP,
+		video:      opts.Video,
 	}
 
 	// if opts.Codecs != nil {
@@ -685,7 +778,24 @@ func (dg *Diago) NewDialog(recipient sip.Uri, opts NewDialogOptions) (d *DialogC
 		}
 	})
 
+	// Early dialogs are stored as well, for UPDATEs before the answer
+	var earlyIDs sync.Map
+	d.onEarlyDialog = func(id string) {
+		if _, loaded := earlyIDs.LoadOrStore(id, struct{}{}); loaded {
+			return
+		}
+		if err := dg.cache.client.DialogStore(context.Background(), id, d); err != nil {
+			dg.log.Error("Failed to store early dialog in cache", "error", err)
+		}
+	}
+
 	d.OnClose(func() error {
+		earlyIDs.Range(func(id, _ any) bool {
+			if id != d.ID {
+				_ = dg.cache.client.DialogDelete(context.Background(), id.(string))
+			}
+			return true
+		})
 		return dg.cache.client.DialogDelete(context.Background(), d.ID)
 	})
 	return d, nil
@@ -720,11 +830,42 @@ func (dg *Diago) getClient(tran *Transport) *sipgo.Client {
 	return tran.client
 }
 
+// transportList returns a copy of the transports safe to use while they
+// are updated.
+func (dg *Diago) transportList() []Transport {
+	dg.transportsMu.RLock()
+	defer dg.transportsMu.RUnlock()
+	return slices.Clone(dg.transports)
+}
+
+// SetExternalIP changes the address advertised in Via, Contact and SDP by
+// the transports of ip's address family, e.g. after the public IP behind a
+// NAT changed. Dialogs already set up keep the address they used.
+func (dg *Diago) SetExternalIP(ip net.IP) {
+	family := "ip4"
+	if ip.To4() == nil {
+		family = "ip6"
+	}
+	dg.transportsMu.Lock()
+	defer dg.transportsMu.Unlock()
+	for i, t := range dg.transports {
+		if t.family() != family {
+			continue
+		}
+		t.ExternalHost = ip.String()
+		t.MediaExternalIP = ip
+		t.client = dg.createClient(t)
+		dg.transports[i] = t
+		dg.log.Info("Transport external IP changed", "id", t.ID, "ip", ip)
+	}
+}
+
 func (dg *Diago) getTransport(transport string) (Transport, bool) {
+	transports := dg.transportList()
 	if transport == "" {
-		return dg.transports[0], true
+		return transports[0], true
 	}
-	for _, t := range dg.transports {
+	for _, t := range transports {
 		if sip.NetworkToLower(transport) == t.Transport {
 			return t, true
 		}
@@ -732,13 +873,78 @@ func (dg *Diago) getTransport(transport string) (Transport, bool) {
 	return Transport{}, false
 }
 
-func (dg *Diago) findTransport(transport string, id string) (Transport, bool) {
-	if transport != "" {
+// getTransportFor returns the transport named transport whose address family
+// reaches host: host itself when it is an IP, otherwise the first resolved
+// address that has a matching transport. Without transports of both
+// families it is getTransport.
+func (dg *Diago) getTransportFor(transport string, host string) (Transport, bool) {
+	transports := dg.transportList()
+	if transport == "" {
+		transport = transports[0].Transport
+	}
+	byFamily := map[string]Transport{}
+	for _, t := range transports {
+		if sip.NetworkToLower(transport) != t.Transport {
+			continue
+		}
+		if _, ok := byFamily[t.family()]; !ok {
+			byFamily[t.family()] = t
+		}
+	}
+	if len(byFamily) < 2 {
 		return dg.getTransport(transport)
 	}
+	host = strings.Trim(host, "[]")
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, _ = net.LookupIP(host)
+	}
+	for _, ip := range ips {
+		family := "ip4"
+		if ip.To4() == nil {
+			family = "ip6"
+		}
+		if t, ok := byFamily[family]; ok {
+			return t, true
+		}
+	}
+	return dg.getTransport(transport)
+}
+
+// family is "ip6" for transports bound to IPv6, otherwise "ip4".
+func (t Transport) family() string {
+	if strings.HasSuffix(t.network, "6") || (t.bindIP != nil && t.bindIP.To4() == nil) {
+		return "ip6"
+	}
+	return "ip4"
+}
+
+// resolveGlobalIP6 returns the first global unicast IPv6 address of the
+// host; link-local addresses cannot be reached from outside the link.
+func resolveGlobalIP6() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil {
+			continue
+		}
+		if ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, errors.New("no global IPv6 address found on system")
+}
+
+func (dg *Diago) findTransport(transport string, id string, host string) (Transport, bool) {
+	if transport != "" {
+		return dg.getTransportFor(transport, host)
+	}
 
 	if id != "" {
-		for _, t := range dg.transports {
+		for _, t := range dg.transportList() {
 			if id == t.ID {
 				return t, true
 			}
@@ -746,7 +952,7 @@ func (dg *Diago) findTransport(transport string, id string) (Transport, bool) {
 		return Transport{}, false
 	}
 
-	return dg.getTransport("udp")
+	return dg.getTransportFor("udp", host)
 }
 
 // Register will create register transaction and keep registration ongoing until error is hit.
@@ -813,7 +1019,14 @@ func (dg *Diago) RegisterTransaction(ctx context.Context, recipient sip.Uri, opt
 	if transport == "" {
 		transport = "udp"
 	}
-	tran, exists := dg.getTransport(transport)
+	host := recipient.Host
+	if opts.ProxyHost != "" {
+		host = opts.ProxyHost
+		if h, _, err := sip.ParseAddr(opts.ProxyHost); err == nil {
+			host = h
+		}
+	}
+	tran, exists := dg.getTransportFor(transport, host)
 	if !exists {
 		return nil, fmt.Errorf("transport=%s does not exists", transport)
 	}
@@ -860,9 +1073,11 @@ func (dg *Diago) createClient(tran Transport) (client *sipgo.Client) {
 	}
 
 	opts := []sipgo.ClientOption{
-		sipgo.WithClientNAT(),
 		sipgo.WithClientLogger(dg.log),
 	}
+	if !tran.NoRport {
+		opts = append(opts, sipgo.WithClientNAT())
+	}
 
 	// If resolved use specific connection and port
 	if hostname != "" {
@@ -27,6 +27,18 @@ type DialogClientSession struct {
 	DialogMedia
 
 	onReferDialog func(referDialog *DialogClientSession)
+	referInvite   ReferInviteFunc
+	// tcpFallback returns the dialog UA of the TCP transport an INVITE too
+	// large for UDP is sent with.
+	tcpFallback func() (*sipgo.DialogUA, bool)
+
+	// rel100 is the 100rel mode (see WithRel100); lastRSeq is the RSeq of
+	// the last reliable provisional response PRACKed.
+	rel100   string
+	lastRSeq uint32
+	// onEarlyDialog is called with the ID of each early dialog, so that
+	// requests within it (UPDATE) are matched.
+	onEarlyDialog func(id string)
 
 	closed atomic.Uint32
 }
@@ -81,7 +93,12 @@ type InviteClientOptions struct {
 	// OnMediaUpdate called when media is changed.
 	// NOTE: you should not block this call as it blocks response processing.
 	OnMediaUpdate func(d *DialogMedia)
-	OnRefer       func(referDialog *DialogClientSession)
+	// ReInviteCodecs limits the codecs re-INVITEs may negotiate.
+	ReInviteCodecs ReInviteCodecsFunc
+	OnRefer        func(referDialog *DialogClientSession)
+	// ReferInvite dials the Refer-To target of an accepted REFER. Defaults to
+	// Diago.Invite without options.
+	ReferInvite ReferInviteFunc
 	// For digest authentication
 	Username string
 	Password string
@@ -136,6 +153,11 @@ func (d *DialogClientSession) Invite(ctx context.Context, opts InviteClientOptio
 	for _, h := range opts.Headers {
 		inviteReq.AppendHeader(h)
 	}
+	if !hasOptionTag(inviteReq, "Supported", optionTag100rel) {
+		for _, h := range rel100Headers(d.rel100) {
+			inviteReq.AppendHeader(h)
+		}
+	}
 
 	if originator != nil {
 		// In case originator then:
@@ -224,8 +246,28 @@ func (d *DialogClientSession) Invite(ctx context.Context, opts InviteClientOptio
 		return err
 	}
 
+	// https://datatracker.ietf.org/doc/html/rfc3261#section-18.1.1
+	// A request larger than 1300 bytes, when the path MTU is unknown, must
+	// be sent over a congestion controlled transport.
+	if d.tcpFallback != nil && len(inviteReq.String()) > sip.UDPMTUSize-200 {
+		if ua, ok := d.tcpFallback(); ok {
+			d.UA = ua
+			inviteReq.SetTransport("TCP")
+			inviteReq.ReplaceHeader(&ua.ContactHDR)
+			inviteReq.RemoveHeader("Via")
+			if err := sipgo.ClientRequestBuild(ua.Client, inviteReq); err != nil {
+				return err
+			}
+		}
+	}
+
 	// This only gets called after session established
 	d.onMediaUpdate = opts.OnMediaUpdate
+	d.reInviteCodecs = opts.ReInviteCodecs
+	d.mu.Lock()
+	d.onReferDialog = opts.OnRefer
+	d.referInvite = opts.ReferInvite
+	d.mu.Unlock()
 	// reuse UDP listener
 	// Problem if listener is unspecified IP sipgo will not map this to listener
 	// Code below only works if our bind host is specified
@@ -241,17 +283,45 @@ func (d *DialogClientSession) Invite(ctx context.Context, opts InviteClientOptio
 		// sess.Close()
 		return err
 	}
+	// Digest auth is answered here instead of by sipgo, which only reads the
+	// first challenge and hashes no body for auth-int.
 	ansOpts := sipgo.AnswerOptions{
-		Username:   opts.Username,
-		Password:   opts.Password,
 		OnResponse: opts.OnResponse,
 	}
-
-	if opts.EarlyMediaDetect {
-		return d.waitAnswerEarly(ctx, ansOpts)
+	challenged := map[int]bool{}
+	for {
+		if opts.EarlyMediaDetect {
+			err = d.waitAnswerEarly(ctx, ansOpts)
+		} else {
+			err = d.waitAnswer(ctx, ansOpts)
+		}
+		var resErr *sipgo.ErrDialogResponse
+		if opts.Password == "" || !errors.As(err, &resErr) {
+			return err
+		}
+		// A proxy (407) and the callee (401) may each challenge once.
+		code := resErr.Res.StatusCode
+		if (code != sip.StatusUnauthorized && code != sip.StatusProxyAuthRequired) || challenged[code] {
+			return err
+		}
+		challenged[code] = true
+		if err := d.inviteAuthorized(ctx, resErr.Res, opts.Username, opts.Password); err != nil {
+			return err
+		}
 	}
+}
 
-	return d.waitAnswer(ctx, ansOpts)
+// inviteAuthorized resends the INVITE with the credentials answering the
+// challenge res.
+func (d *DialogClientSession) inviteAuthorized(ctx context.Context, res *sip.Response, username, password string) error {
+	req := d.InviteRequest
+	if err := digestAuthorize(req, res, username, password); err != nil {
+		return err
+	}
+	req.CSeq().SeqNo++
+	// Remove Via from original request so that a new transaction is created
+	req.RemoveHeader("Via")
+	return d.DialogClientSession.Invite(ctx, sipgo.ClientRequestAddVia)
 }
 
 // WaitAnswer waits dialog on answer. It should only be used if you have error Invite but still want to continue
@@ -276,8 +346,9 @@ func (d *DialogClientSession) waitAnswerEarly(ctx context.Context, opts sipgo.An
 			}
 		}
 
-		// handle 183 Session Progress early media
-		if res.StatusCode != sip.StatusSessionInProgress {
+		// handle early media of 183 Session Progress, or of any other
+		// provisional response carrying SDP (e.g. 180 Ringing, RFC 3960)
+		if !res.IsProvisional() || res.StatusCode == sip.StatusTrying {
 			return nil
 		}
 
@@ -313,6 +384,7 @@ func (d *DialogClientSession) waitAnswerEarly(ctx context.Context, opts sipgo.An
 }
 
 func (d *DialogClientSession) waitAnswer(ctx context.Context, opts sipgo.AnswerOptions) error {
+	opts.OnResponse = d.provisionalHandler(ctx, opts.OnResponse)
 	if err := d.DialogClientSession.WaitAnswer(ctx, opts); err != nil {
 		return err
 	}
@@ -431,6 +503,18 @@ func (d *DialogClientSession) ReInvite(ctx context.Context) error {
 
 // Refer tries todo refer (blind transfer) on call
 // TODO: not complete
+// Options sends an in-dialog OPTIONS (e.g. as keep-alive) and returns the
+// final response.
+func (d *DialogClientSession) Options(ctx context.Context) (*sip.Response, error) {
+	d.mu.Lock()
+	cont := d.remoteContactUnsafe()
+	d.mu.Unlock()
+	if cont == nil {
+		return nil, fmt.Errorf("no contact header present")
+	}
+	return d.Do(ctx, sip.NewRequest(sip.OPTIONS, cont.Address))
+}
+
 func (d *DialogClientSession) Refer(ctx context.Context, referTo sip.Uri) error {
 	cont := d.InviteResponse.Contact()
 	return dialogRefer(ctx, d, cont.Address, referTo)
@@ -443,13 +527,14 @@ func (d *DialogClientSession) handleReferNotify(req *sip.Request, tx sip.ServerT
 func (d *DialogClientSession) handleRefer(dg *Diago, req *sip.Request, tx sip.ServerTransaction) {
 	d.mu.Lock()
 	onRefDialog := d.onReferDialog
+	referInvite := d.referInvite
 	d.mu.Unlock()
 	if onRefDialog == nil {
 		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptable, "Not Acceptable", nil))
 		return
 	}
 
-	dialogHandleRefer(d, dg, req, tx, onRefDialog)
+	dialogHandleRefer(d, dg, req, tx, onRefDialog, referInvite)
 }
 
 func (d *DialogClientSession) handleReInvite(req *sip.Request, tx sip.ServerTransaction) error {
@@ -74,6 +74,9 @@ type DialogMedia struct {
 
 	onClose       func() error
 	onMediaUpdate func(*DialogMedia)
+	// reInviteCodecs limits the codecs of re-INVITEs; reInvites counts them.
+	reInviteCodecs ReInviteCodecsFunc
+	reInvites      int
 
 	closed bool
 }
@@ -171,6 +174,14 @@ func (d *DialogMedia) initMediaSessionFromConf(conf MediaConfig) error {
 	if err := sess.Init(); err != nil {
 		return err
 	}
+	if conf.video != "" && conf.secureRTP == 0 {
+		video, err := media.NewVideoSession(bindIP, conf.externalIP, conf.video, conf.rtpNAT)
+		if err != nil {
+			sess.Close()
+			return err
+		}
+		sess.Video = video
+	}
 	d.mediaSession = sess
 	return nil
 }
@@ -189,10 +200,18 @@ func (d *DialogMedia) MediaSession() *media.MediaSession {
 	return d.mediaSession
 }
 
+// ReInviteCodecsFunc returns the codecs the n-th re-INVITE received on a
+// dialog (counting from 1) may negotiate, out of the current ones.
+type ReInviteCodecsFunc func(n int, codecs []media.Codec) []media.Codec
+
 func (d *DialogMedia) handleMediaUpdate(req *sip.Request, tx sip.ServerTransaction, contactHDR sip.Header) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.lastInvite = req
+	d.reInvites++
+	if d.reInviteCodecs != nil && d.mediaSession != nil {
+		d.mediaSession.Codecs = d.reInviteCodecs(d.reInvites, d.mediaSession.Codecs)
+	}
 
 	if err := d.sdpReInviteUnsafe(req.Body()); err != nil {
 		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated - "+err.Error(), nil))
@@ -207,14 +226,16 @@ func (d *DialogMedia) handleMediaUpdate(req *sip.Request, tx sip.ServerTransacti
 }
 
 // Must be protected with lock
-func (d *DialogMedia) sdpReInviteUnsafe(sdp []byte) error {
+func (d *DialogMedia) sdpReInviteUnsafe(remoteSDP []byte) error {
 	if d.mediaSession == nil {
 		return fmt.Errorf("no media session present")
 	}
 
-	if err := d.sdpUpdateUnsafe(sdp); err != nil {
+	if err := d.sdpUpdateUnsafe(remoteSDP); err != nil {
 		return err
 	}
+	// Answer hold/resume with the matching direction
+	d.mediaSession.Mode = sdp.AnswerMode(d.mediaSession.RemoteMode)
 
 	if d.onMediaUpdate != nil {
 		d.onMediaUpdate(d)
@@ -22,9 +22,17 @@ type DialogServerSession struct {
 	DialogMedia
 
 	onReferDialog func(referDialog *DialogClientSession)
+	referInvite   ReferInviteFunc
 
 	mediaConf MediaConfig
 	closed    atomic.Uint32
+
+	// rel100 sends provisional responses reliably (RFC 3262): rseq numbers
+	// them, prackRSeq is the last one PRACKed, signaled on pracked.
+	rel100    bool
+	rseq      atomic.Uint32
+	prackRSeq atomic.Uint32
+	pracked   chan struct{}
 }
 
 func (d *DialogServerSession) Id() string {
@@ -77,10 +85,13 @@ type ProgressMediaOptions struct {
 
 	// RTPNAT exposes MediaSession property
 	RTPNAT int
+	// Video is the direction (sdp.Mode...) in which H.264 video offered by
+	// the caller is accepted, on media.MediaSession.Video; empty rejects it.
+	Video string
 }
 
 func (d *DialogServerSession) ProgressMediaOptions(opt ProgressMediaOptions) error {
-	d.updateMediaConf(opt.Codecs, opt.RTPNAT)
+	d.updateMediaConf(opt.Codecs, opt.RTPNAT, opt.Video)
 	if err := d.initMediaSessionFromConf(d.mediaConf); err != nil {
 		return err
 	}
@@ -91,14 +102,20 @@ func (d *DialogServerSession) ProgressMediaOptions(opt ProgressMediaOptions) err
 
 	headers := []sip.Header{sip.NewHeader("Content-Type", "application/sdp")}
 	body := rtpSess.Sess.LocalSDP()
-	if err := d.DialogServerSession.Respond(183, "Session Progress", body, headers...); err != nil {
+	if err := d.respondProvisional(183, "Session Progress", body, headers...); err != nil {
 		return err
 	}
 	return rtpSess.MonitorBackground()
 }
 
 func (d *DialogServerSession) Ringing() error {
-	return d.Respond(sip.StatusRinging, "Ringing", nil)
+	return d.respondProvisional(sip.StatusRinging, "Ringing", nil)
+}
+
+// SessionProgress sends 183 Session Progress without early media, for
+// callers that expect it rather than 180 Ringing.
+func (d *DialogServerSession) SessionProgress() error {
+	return d.respondProvisional(sip.StatusSessionInProgress, "Session Progress", nil)
 }
 
 func (d *DialogServerSession) DialogSIP() *sipgo.Dialog {
@@ -145,12 +162,20 @@ type AnswerOptions struct {
 	// OnMediaUpdate triggers when media update happens. It is blocking func, so make sure you exit
 	OnMediaUpdate func(d *DialogMedia)
 	OnRefer       func(referDialog *DialogClientSession)
+	// ReferInvite dials the Refer-To target of an accepted REFER. Defaults to
+	// Diago.Invite without options.
+	ReferInvite ReferInviteFunc
 	// Codecs that will be used
 	Codecs []media.Codec
+	// ReInviteCodecs limits the codecs re-INVITEs may negotiate.
+	ReInviteCodecs ReInviteCodecsFunc
 
 	// RTPNAT is media.MediaSession.RTPNAT
 	// Check media.RTPNAT... options
 	RTPNAT int
+	// Video is the direction (sdp.Mode...) in which H.264 video offered by
+	// the caller is accepted, on media.MediaSession.Video; empty rejects it.
+	Video string
 }
 
 // AnswerOptions allows to answer dialog with options
@@ -160,7 +185,9 @@ type AnswerOptions struct {
 func (d *DialogServerSession) AnswerOptions(opt AnswerOptions) error {
 	d.mu.Lock()
 	d.onReferDialog = opt.OnRefer
+	d.referInvite = opt.ReferInvite
 	d.onMediaUpdate = opt.OnMediaUpdate
+	d.reInviteCodecs = opt.ReInviteCodecs
 	d.mu.Unlock()
 
 	// If media exists as early, only respond 200
@@ -172,7 +199,7 @@ func (d *DialogServerSession) AnswerOptions(opt AnswerOptions) error {
 		return nil
 	}
 
-	d.updateMediaConf(opt.Codecs, opt.RTPNAT)
+	d.updateMediaConf(opt.Codecs, opt.RTPNAT, opt.Video)
 	if err := d.initMediaSessionFromConf(d.mediaConf); err != nil {
 		return err
 	}
@@ -180,13 +207,14 @@ func (d *DialogServerSession) AnswerOptions(opt AnswerOptions) error {
 	return d.answerSession(rtpSess)
 }
 
-func (d *DialogServerSession) updateMediaConf(codecs []media.Codec, rtpNAT int) {
+func (d *DialogServerSession) updateMediaConf(codecs []media.Codec, rtpNAT int, video string) {
 	// Let override of formats
 	conf := &d.mediaConf
 	if codecs != nil {
 		conf.Codecs = codecs
 	}
 	conf.rtpNAT = rtpNAT
+	conf.video = video
 }
 
 // answerSession. It allows answering with custom RTP Session.
@@ -333,6 +361,16 @@ func (d *DialogServerSession) ReInvite(ctx context.Context) error {
 }
 
 // Refer tries todo refer (blind transfer) on call
+// Options sends an in-dialog OPTIONS (e.g. as keep-alive) and returns the
+// final response.
+func (d *DialogServerSession) Options(ctx context.Context) (*sip.Response, error) {
+	cont := d.InviteRequest.Contact()
+	if cont == nil {
+		return nil, fmt.Errorf("no contact header present")
+	}
+	return d.Do(ctx, sip.NewRequest(sip.OPTIONS, cont.Address))
+}
+
 func (d *DialogServerSession) Refer(ctx context.Context, referTo sip.Uri, headers ...sip.Header) error {
 	cont := d.InviteRequest.Contact()
 	return dialogRefer(ctx, d, cont.Address, referTo, headers...)
@@ -345,13 +383,14 @@ func (d *DialogServerSession) handleReferNotify(req *sip.Request, tx sip.ServerT
 func (d *DialogServerSession) handleRefer(dg *Diago, req *sip.Request, tx sip.ServerTransaction) {
 	d.mu.Lock()
 	onRefDialog := d.onReferDialog
+	referInvite := d.referInvite
 	d.mu.Unlock()
 	if onRefDialog == nil {
 		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptable, "Not Acceptable", nil))
 		return
 	}
 
-	dialogHandleRefer(d, dg, req, tx, onRefDialog)
+	dialogHandleRefer(d, dg, req, tx, onRefDialog, referInvite)
 }
 
 func (d *DialogServerSession) handleReInvite(req *sip.Request, tx sip.ServerTransaction) error {
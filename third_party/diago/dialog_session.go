@@ -78,7 +78,10 @@ func dialogHandleReferNotify(d DialogSession, req *sip.Request, tx sip.ServerTra
 	}
 }
 
-func dialogHandleRefer(d DialogSession, dg *Diago, req *sip.Request, tx sip.ServerTransaction, onReferDialog func(referDialog *DialogClientSession)) {
+// ReferInviteFunc dials referTo on behalf of a transfer request.
+type ReferInviteFunc func(ctx context.Context, referTo sip.Uri) (*DialogClientSession, error)
+
+func dialogHandleRefer(d DialogSession, dg *Diago, req *sip.Request, tx sip.ServerTransaction, onReferDialog func(referDialog *DialogClientSession), referInvite ReferInviteFunc) {
 	referTo := req.GetHeader("Refer-To")
 	// https://datatracker.ietf.org/doc/html/rfc3515#section-2.4.2
 	// 	An agent responding to a REFER method MUST return a 400 (Bad Request)
@@ -141,10 +144,19 @@ func dialogHandleRefer(d DialogSession, dg *Diago, req *sip.Request, tx sip.Serv
 		return
 	}
 
-	referDialog, err := dg.Invite(ctx, referToUri, InviteOptions{})
+	var referDialog *DialogClientSession
+	if referInvite != nil {
+		referDialog, err = referInvite(ctx, referToUri)
+	} else {
+		referDialog, err = dg.Invite(ctx, referToUri, InviteOptions{})
+	}
 	if err != nil {
-		// DO notify?
 		log.Error("REFER dialog failed to dial", "error", err)
+		notify503 := notify.Clone()
+		addSipFrag(notify503, 503, "Service Unavailable")
+		if _, err := d.Do(ctx, notify503); err != nil {
+			log.Info("REFER NOTIFY 503 failed to sent", "error", err)
+		}
 		return
 	}
 	// We send ref dialog to processing. After sending 200 OK this session will terminate
@@ -4,13 +4,19 @@
 package diago
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/icholy/digest"
 )
@@ -20,8 +26,17 @@ type DigestAuth struct {
 	Password string
 	Realm    string
 	Expire   time.Duration
+	// Algorithms are challenged in this order of preference, one
+	// WWW-Authenticate header each (RFC 8760). Defaults to MD5.
+	Algorithms []string
+	// QOP offered in challenges: "auth" and/or "auth-int". Empty sends
+	// challenges without qop (RFC 2069 compatible).
+	QOP []string
 }
 
+// DigestAlgorithms are the supported digest algorithms, strongest first.
+var DigestAlgorithms = []string{"SHA-512-256", "SHA-256", "MD5"}
+
 func (a *DigestAuth) expire() time.Duration {
 	if a.Expire > 0 {
 		return a.Expire
@@ -30,7 +45,8 @@ func (a *DigestAuth) expire() time.Duration {
 }
 
 type digestChallengeEntry struct {
-	digest.Challenge
+	// challenges has one challenge per offered algorithm.
+	challenges  []digest.Challenge
 	expireTimer *time.Timer
 }
 
@@ -70,20 +86,24 @@ func (s *DigestAuthServer) AuthorizeRequest(req *sip.Request, auth DigestAuth) (
 			return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), err
 		}
 
-		e := &digestChallengeEntry{
-			Challenge: digest.Challenge{
+		algorithms := auth.Algorithms
+		if len(algorithms) == 0 {
+			algorithms = []string{"MD5"}
+		}
+		e := &digestChallengeEntry{}
+		res := sip.NewResponseFromRequest(req, 401, "Unathorized", nil)
+		for _, alg := range algorithms {
+			chal := digest.Challenge{
 				Realm: auth.Realm,
 				Nonce: nonce,
 				// Opaque:    "sipgo",
-				Algorithm: "MD5",
-			},
+				Algorithm: strings.ToUpper(alg),
+				QOP:       auth.QOP,
+			}
+			e.challenges = append(e.challenges, chal)
+			res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
 		}
 
-		chal := &e.Challenge
-
-		res := sip.NewResponseFromRequest(req, 401, "Unathorized", nil)
-		res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
-
 		s.mu.Lock()
 		s.cache[nonce] = e
 		s.mu.Unlock()
@@ -101,18 +121,38 @@ func (s *DigestAuthServer) AuthorizeRequest(req *sip.Request, auth DigestAuth) (
 		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil), err
 	}
 
+	s.mu.Lock()
 	e, exists := s.cache[cred.Nonce]
+	s.mu.Unlock()
 	if !exists {
 		return sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil), ErrDigestAuthNoChallenge
 	}
-	chal := &e.Challenge
+	// Answer with the challenge of the algorithm the client picked, and
+	// the qop it used.
+	i := slices.IndexFunc(e.challenges, func(c digest.Challenge) bool {
+		return strings.EqualFold(c.Algorithm, cred.Algorithm) || (cred.Algorithm == "" && c.Algorithm == "MD5")
+	})
+	if i < 0 {
+		return sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil), ErrDigestAuthNoChallenge
+	}
+	chal := e.challenges[i]
+	chal.QOP = nil
+	if cred.QOP != "" {
+		if !slices.Contains(e.challenges[i].QOP, cred.QOP) {
+			return sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil), ErrDigestAuthBadCreds
+		}
+		chal.QOP = []string{cred.QOP}
+	}
 
 	// Make digest and compare response
-	digCred, err := digest.Digest(chal, digest.Options{
+	digCred, err := digest.Digest(&chal, digest.Options{
 		Method:   req.Method.String(),
 		URI:      cred.URI,
 		Username: auth.Username,
 		Password: auth.Password,
+		Cnonce:   cred.Cnonce,
+		Count:    cred.Nc,
+		GetBody:  requestBody(req),
 	})
 
 	if err != nil {
@@ -142,6 +182,68 @@ func (s *DigestAuthServer) AuthorizeDialog(d *DialogServerSession, auth DigestAu
 	return errors.Join(err, nil)
 }
 
+// digestAuthorize adds the credentials answering the 401 or 407 res to
+// req, for the strongest challenge of res that can be answered. auth-int
+// is computed over the body of req.
+func digestAuthorize(req *sip.Request, res *sip.Response, username, password string) error {
+	hdrName, credName := "WWW-Authenticate", "Authorization"
+	if res.StatusCode == sip.StatusProxyAuthRequired {
+		hdrName, credName = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	var best *digest.Challenge
+	rank := len(DigestAlgorithms)
+	for _, h := range res.GetHeaders(hdrName) {
+		chal, err := digest.ParseChallenge(h.Value())
+		if err != nil {
+			continue
+		}
+		// Fix lower case algorithm although not supported by rfc
+		chal.Algorithm = sip.ASCIIToUpper(chal.Algorithm)
+		alg := chal.Algorithm
+		if alg == "" {
+			alg = "MD5"
+		}
+		r := slices.Index(DigestAlgorithms, alg)
+		if r < 0 || r >= rank || !digest.CanDigest(chal) {
+			continue
+		}
+		best, rank = chal, r
+	}
+	if best == nil {
+		return fmt.Errorf("no supported %s challenge in %q", hdrName, res.StartLine())
+	}
+	cred, err := digest.Digest(best, digest.Options{
+		Method:   req.Method.String(),
+		URI:      req.Recipient.Addr(),
+		Username: username,
+		Password: password,
+		GetBody:  requestBody(req),
+	})
+	if err != nil {
+		return fmt.Errorf("fail to build digest: %w", err)
+	}
+	req.RemoveHeader(credName)
+	req.AppendHeader(sip.NewHeader(credName, cred.String()))
+	return nil
+}
+
+// doDigestAuth resends req with the credentials answering res.
+func doDigestAuth(ctx context.Context, client *sipgo.Client, req *sip.Request, res *sip.Response, username, password string) (*sip.Response, error) {
+	if err := digestAuthorize(req, res, username, password); err != nil {
+		return nil, err
+	}
+	req.CSeq().SeqNo++
+	req.RemoveHeader("Via")
+	return client.Do(ctx, req, sipgo.ClientRequestAddVia)
+}
+
+// requestBody is the body of req for auth-int.
+func requestBody(req *sip.Request) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(req.Body())), nil
+	}
+}
+
 func generateNonce() (string, error) {
 	nonceBytes := make([]byte, 32)
 	_, err := rand.Read(nonceBytes)
@@ -33,6 +33,12 @@ type Codec struct {
 	SampleRate  uint32
 	SampleDur   time.Duration
 	NumChannels int // 1 or 2
+	// FMTP are the format parameters we advertise for the codec (a=fmtp),
+	// e.g. "useinbandfec=1" for opus. DTMF codecs default to "0-16".
+	FMTP string
+	// Priority overrides the codec registry priority when choosing among
+	// negotiated codecs if non-zero. Higher is better.
+	Priority int
 }
 
 func (c *Codec) String() string {
@@ -244,11 +250,14 @@ func CodecAudioFromList(codecs []Codec) (Codec, bool) {
 //
 // Higher is better. This intentionally follows the codec registry priority
 // (as registered in LiveKit media-sdk) so callers can control preference by
-// registration settings.
+// registration settings, unless the codec sets its own Priority.
 func CodecPreferenceWeight(c Codec) int {
 	if c.IsDTMF() || strings.EqualFold(c.Name, "telephone-event") {
 		return -1 << 20
 	}
+	if c.Priority != 0 {
+		return c.Priority
+	}
 
 	if lk := lksdp.CodecByName(CanonicalSDPName(c)); lk != nil {
 		return lk.Info().Priority
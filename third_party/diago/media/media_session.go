@@ -99,6 +99,8 @@ type MediaSession struct {
 
 	// Mode is sdp mode. Check consts sdp.ModeRecvOnly etc...
 	Mode string
+	// RemoteMode is the direction requested by the last remote SDP
+	RemoteMode string
 	// Laddr our local address which has full IP and port after media session creation
 	Laddr net.UDPAddr
 	// Raddr is our target remote address. Normally it is resolved by SDP parsing.
@@ -130,6 +132,10 @@ type MediaSession struct {
 	// ReadRTPFromAddr is set after Read operation. NOT THREAD SAFE and should be only used together with Read
 	// It can be used to validate source of RTP packet
 	ReadRTPFromAddr net.Addr
+
+	// Video is the optional H.264 stream negotiated next to the audio.
+	// Experimental
+	Video *VideoSession
 }
 
 func NewMediaSession(ip net.IP, port int) (s *MediaSession, e error) {
@@ -235,13 +241,15 @@ func (s *MediaSession) StartRTP(rw int8) error {
 // After this call it still expected that
 func (s *MediaSession) Fork() *MediaSession {
 	cp := MediaSession{
-		Laddr:    s.Laddr, // TODO clone it although it is read only
-		rtpConn:  s.rtpConn,
-		rtcpConn: s.rtcpConn,
-		Codecs:   slices.Clone(s.Codecs),
-		Mode:     sdp.ModeSendrecv,
-		RTPNAT:   s.RTPNAT,
-		sdp:      slices.Clone(s.sdp),
+		Laddr:      s.Laddr, // TODO clone it although it is read only
+		ExternalIP: s.ExternalIP,
+		rtpConn:    s.rtpConn,
+		rtcpConn:   s.rtcpConn,
+		Codecs:     slices.Clone(s.Codecs),
+		Mode:       sdp.ModeSendrecv,
+		RTPNAT:     s.RTPNAT,
+		sdp:        slices.Clone(s.sdp),
+		Video:      s.Video,
 	}
 	return &cp
 }
@@ -255,6 +263,9 @@ func (s *MediaSession) Close() error {
 	if s.rtpConn != nil {
 		e2 = s.rtpConn.Close()
 	}
+	if s.Video != nil {
+		return errors.Join(e1, e2, s.Video.Close())
+	}
 	return errors.Join(e1, e2)
 }
 
@@ -338,7 +349,13 @@ func (s *MediaSession) LocalSDP() []byte {
 		}
 	}
 
-	return generateSDPForAudio(rtpProfile, ip, connIP, rtpPort, s.Mode, codecs, localSDES)
+	body := generateSDPForAudio(rtpProfile, ip, connIP, rtpPort, s.Mode, codecs, localSDES)
+	if s.Video != nil {
+		if lines := s.Video.sdpLines(); len(lines) > 0 {
+			body = append(body, strings.Join(lines, "\r\n")+"\r\n"...)
+		}
+	}
+	return body
 }
 
 // RemoteSDP applies remote SDP.
@@ -354,6 +371,10 @@ func (s *MediaSession) RemoteSDP(sdpReceived []byte) error {
 	if err != nil {
 		return err
 	}
+	// Only the audio description's own attributes (and session ones) apply.
+	if sd, err = sdp.MediaSection(sdpReceived, "audio"); err != nil {
+		return err
+	}
 
 	// Confirm it is supported profile
 	secureRequest := false
@@ -440,7 +461,22 @@ func (s *MediaSession) RemoteSDP(sdpReceived []byte) error {
 		return fmt.Errorf("remote requested secure RTP, but no context is created proto=%s", md.Proto)
 	}
 
+	s.RemoteMode = sdp.ModeSendrecv
+	for _, v := range attrs {
+		switch v {
+		case sdp.ModeSendrecv, sdp.ModeSendonly, sdp.ModeRecvonly, sdp.ModeInactive:
+			s.RemoteMode = v
+		}
+	}
+	if ci.IP.IsUnspecified() && s.RemoteMode == sdp.ModeSendrecv {
+		// RFC 2543 style hold (c=0.0.0.0)
+		s.RemoteMode = sdp.ModeSendonly
+	}
+
 	s.SetRemoteAddr(&net.UDPAddr{IP: ci.IP, Port: md.Port})
+	if s.Video != nil {
+		return s.Video.remoteSDP(sdpReceived)
+	}
 	return nil
 }
 
@@ -460,6 +496,10 @@ func (s *MediaSession) updateRemoteCodecs(codecs []Codec) int {
 			//
 			// NOTE: We intentionally do NOT require payload type equality here.
 			if strings.EqualFold(c.SDPName(), rc.SDPName()) {
+				// Format parameters describe what we receive; keep ours,
+				// and our preference.
+				rc.FMTP = c.FMTP
+				rc.Priority = c.Priority
 				filter = append(filter, rc)
 				break
 			}
@@ -487,23 +527,23 @@ func (s *MediaSession) createListeners(laddr *net.UDPAddr) error {
 	}
 
 	if laddr.Port == 0 && RTPPortStart > 0 && RTPPortEnd > RTPPortStart {
-		// Get next available port
-		port := RTPPortStart + int(rtpPortOffset.Load())
-		var err error
-		for ; port < RTPPortEnd; port += 2 {
+		// Get next available even port (RTCP takes the odd one above it),
+		// starting after the last one handed out and wrapping around so the
+		// whole range is tried.
+		pairs := (RTPPortEnd - RTPPortStart) / 2
+		next := int(rtpPortOffset.Load()) / 2
+		err := errors.New("range too small")
+		for i := 0; i < pairs; i++ {
+			port := RTPPortStart + (next+i)%pairs*2
 			laddr.Port = port
-			err = s.listenRTPandRTCP(laddr)
-			if err == nil {
-				break
+			if err = s.listenRTPandRTCP(laddr); err == nil {
+				// Add some offset so that we use more from range
+				rtpPortOffset.Store(int32(port + 2 - RTPPortStart))
+				return nil
 			}
 		}
-		if err != nil {
-			return fmt.Errorf("No available ports in range %d:%d: %w", RTPPortStart, RTPPortEnd, err)
-		}
-		// Add some offset so that we use more from range
-		offset := (port + 2 - RTPPortStart) % (RTPPortEnd - RTPPortStart)
-		rtpPortOffset.Store(int32(offset)) // Reset to zero with module
-		return nil
+		laddr.Port = 0
+		return fmt.Errorf("No available ports in range %d:%d: %w", RTPPortStart, RTPPortEnd, err)
 	}
 
 	// Because we want to go +2 with ports in racy situations this will always fail
@@ -872,7 +912,9 @@ func generateSDPForAudio(rtpProfile string, originIP net.IP, connectionIP net.IP
 	formatsMap := []string{}
 	for i, f := range codecs {
 		formatsMap = append(formatsMap, fmt.Sprintf("a=rtpmap:%d %s", f.PayloadType, f.SDPName()))
-		if f.IsDTMF() {
+		if f.FMTP != "" {
+			formatsMap = append(formatsMap, fmt.Sprintf("a=fmtp:%d %s", f.PayloadType, f.FMTP))
+		} else if f.IsDTMF() {
 			formatsMap = append(formatsMap, fmt.Sprintf("a=fmtp:%d 0-16", f.PayloadType))
 		}
 		fmts[i] = strconv.Itoa(int(f.PayloadType))
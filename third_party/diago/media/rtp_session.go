@@ -112,6 +112,14 @@ type RTPReadStats struct {
 		stats.jitter = stats.jitter + (D-stats.jitter)/16
 	}
 */
+// Jitter returns interarrival jitter of the read stream
+func (stats RTPReadStats) Jitter() time.Duration {
+	if stats.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(stats.jitter / float64(stats.SampleRate) * float64(time.Second))
+}
+
 func (stats *RTPReadStats) calcJitter(now time.Time, readPktTimestamp uint32) {
 	sampleRate := float64(stats.SampleRate)
 
@@ -142,6 +150,12 @@ type RTPWriteStats struct {
 	// RTCP stats
 	PacketsCount uint64
 	OctetCount   uint64
+
+	// Remote receiver view of our stream, from the last reception report
+	RemoteFractionLost float64
+	RemoteTotalLost    uint32
+	RemoteJitter       time.Duration
+	RemoteReportTime   time.Time
 }
 
 // RTP session creates new RTP reader/writer from session
@@ -532,6 +546,14 @@ func (s *RTPSession) readReceptionReport(rr rtcp.ReceptionReport, now time.Time)
 	}
 	// used to calc fraction lost
 	s.readStats.lastReceptionReportSeqNum = rr.LastSequenceNumber
+
+	writeStats := &s.writeStats
+	writeStats.RemoteFractionLost = float64(rr.FractionLost) / 256
+	writeStats.RemoteTotalLost = rr.TotalLost
+	if writeStats.sampleRate > 0 {
+		writeStats.RemoteJitter = time.Duration(float64(rr.Jitter) / float64(writeStats.sampleRate) * float64(time.Second))
+	}
+	writeStats.RemoteReportTime = now
 }
 
 func (s *RTPSession) writeRTCP(now time.Time) error {
@@ -198,3 +198,36 @@ func nextLine(reader *bytes.Buffer) (line string, err error) {
 	line = line[:lenline-1]
 	return line, nil
 }
+
+// MediaSection parses the session-level lines of data together with the
+// lines of its first media description of mediaType, so attributes of other
+// media descriptions (e.g. a=sendonly of video) don't leak into it. A
+// connection line of the media description replaces the session one.
+func MediaSection(data []byte, mediaType string) (SessionDescription, error) {
+	section := SessionDescription{}
+	in, found, sessionLevel := false, false, true
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if key == "m" {
+			sessionLevel = false
+			in = !found && strings.HasPrefix(value, mediaType+" ")
+			found = found || in
+		}
+		switch {
+		case sessionLevel:
+			section[key] = append(section[key], value)
+		case in && key == "c":
+			section["c"] = []string{value}
+		case in:
+			section[key] = append(section[key], value)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Media not found for %q", mediaType)
+	}
+	return section, nil
+}
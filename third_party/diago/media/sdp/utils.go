@@ -29,8 +29,23 @@ const (
 	ModeRecvonly string = "recvonly"
 	ModeSendrecv string = "sendrecv"
 	ModeSendonly string = "sendonly"
+	ModeInactive string = "inactive"
 )
 
+// AnswerMode returns the direction for answering an offer with the given mode
+// https://datatracker.ietf.org/doc/html/rfc3264#section-6.1
+func AnswerMode(offer string) string {
+	switch offer {
+	case ModeSendonly:
+		return ModeRecvonly
+	case ModeRecvonly:
+		return ModeSendonly
+	case ModeInactive:
+		return ModeInactive
+	}
+	return ModeSendrecv
+}
+
 // GenerateForAudio is minimal AUDIO SDP setup
 // mode -> consts like ModeRecvOnly, ModeSendrecv
 func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode string, fmts Formats) []byte {
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package media
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/diago/media/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// VideoDefaultFMTP are the H.264 format parameters offered for video:
+// constrained baseline, level 3.1, non-interleaved packetization.
+const VideoDefaultFMTP = "profile-level-id=42e01f;packetization-mode=1"
+
+// VideoSession is the H.264 video stream next to the audio of a
+// MediaSession (a second media description). It has its own RTP/RTCP ports.
+// SRTP is not supported.
+//
+// Experimental
+type VideoSession struct {
+	// Mode is our direction, e.g. sdp.ModeRecvonly to only receive video.
+	Mode string
+
+	sess *MediaSession
+
+	mu sync.Mutex
+	// remoteSeen is set once a remote SDP was applied; remoteVideo tells
+	// whether it had an (enabled) video description.
+	remoteSeen  bool
+	remoteVideo bool
+	remoteMode  string
+	codec       Codec
+
+	remoteSSRC atomic.Uint32
+}
+
+// NewVideoSession listens for video on ip (within RTPPortStart-RTPPortEnd)
+// and advertises externalIP when set.
+func NewVideoSession(ip net.IP, externalIP net.IP, mode string, rtpNAT int) (*VideoSession, error) {
+	codec := Codec{
+		Name:        "H264",
+		PayloadType: 96,
+		SampleRate:  90000,
+		NumChannels: 1,
+		FMTP:        VideoDefaultFMTP,
+	}
+	sess := &MediaSession{
+		Codecs:     []Codec{codec},
+		Laddr:      net.UDPAddr{IP: ip, Port: 0},
+		ExternalIP: externalIP,
+		Mode:       mode,
+		RTPNAT:     rtpNAT,
+	}
+	if err := sess.Init(); err != nil {
+		return nil, fmt.Errorf("video session: %w", err)
+	}
+	return &VideoSession{Mode: mode, sess: sess, codec: codec}, nil
+}
+
+// Active reports whether the remote side negotiated video, i.e. it offered
+// or accepted an H.264 stream.
+func (v *VideoSession) Active() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.remoteVideo
+}
+
+// Codec returns the H.264 codec, with the negotiated payload type and
+// format parameters once the remote SDP was applied.
+func (v *VideoSession) Codec() Codec {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.codec
+}
+
+// Laddr is the local RTP address of the video stream.
+func (v *VideoSession) Laddr() net.UDPAddr {
+	return v.sess.Laddr
+}
+
+// Raddr is the remote RTP address of the video stream.
+func (v *VideoSession) Raddr() net.UDPAddr {
+	return v.sess.Raddr
+}
+
+// ReadRTP reads the next video RTP packet. buf must hold at least RTPBufSize
+// bytes.
+func (v *VideoSession) ReadRTP(buf []byte, pkt *rtp.Packet) (int, error) {
+	n, err := v.sess.ReadRTP(buf, pkt)
+	if err == nil {
+		v.remoteSSRC.Store(pkt.SSRC)
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the deadline of ReadRTP.
+func (v *VideoSession) SetReadDeadline(t time.Time) error {
+	return v.sess.rtpConn.SetReadDeadline(t)
+}
+
+// WriteRTP sends a video RTP packet.
+func (v *VideoSession) WriteRTP(pkt *rtp.Packet) error {
+	return v.sess.WriteRTP(pkt)
+}
+
+// WriteRTCP sends a video RTCP packet.
+func (v *VideoSession) WriteRTCP(pkt rtcp.Packet) error {
+	return v.sess.WriteRTCP(pkt)
+}
+
+// RequestKeyframe asks the sender for a key frame (RTCP PLI). It does
+// nothing before the first packet was read.
+func (v *VideoSession) RequestKeyframe(senderSSRC uint32) error {
+	ssrc := v.remoteSSRC.Load()
+	if ssrc == 0 {
+		return nil
+	}
+	return v.sess.WriteRTCP(&rtcp.PictureLossIndication{SenderSSRC: senderSSRC, MediaSSRC: ssrc})
+}
+
+// Close closes the video ports.
+func (v *VideoSession) Close() error {
+	return v.sess.Close()
+}
+
+// sdpLines returns the video media description of our SDP, or nil when the
+// remote side did not offer video in the SDP we answer.
+func (v *VideoSession) sdpLines() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.remoteSeen && !v.remoteVideo {
+		return nil
+	}
+	mode := v.Mode
+	if v.remoteSeen {
+		mode = videoAnswerMode(v.Mode, v.remoteMode)
+	}
+	c := v.codec
+	return []string{
+		fmt.Sprintf("m=video %d RTP/AVP %d", v.sess.Laddr.Port, c.PayloadType),
+		fmt.Sprintf("a=rtpmap:%d %s", c.PayloadType, c.SDPName()),
+		fmt.Sprintf("a=fmtp:%d %s", c.PayloadType, c.FMTP),
+		fmt.Sprintf("a=rtcp-fb:%d nack pli", c.PayloadType),
+		"a=" + mode,
+	}
+}
+
+// remoteSDP applies the video media description of a remote SDP. A missing
+// or rejected (port 0) description, or one without H.264, disables video.
+func (v *VideoSession) remoteSDP(data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.remoteSeen = true
+	v.remoteVideo = false
+	sd, err := sdp.MediaSection(data, "video")
+	if err != nil {
+		return nil
+	}
+	md, err := sd.MediaDescription("video")
+	if err != nil || md.Port == 0 || md.Proto != "RTP/AVP" {
+		return nil
+	}
+	attrs := sd.Values("a")
+	pt, fmtp, ok := h264Format(md.Formats, attrs)
+	if !ok {
+		return nil
+	}
+	ci, err := sd.ConnectionInformation()
+	if err != nil {
+		return err
+	}
+	v.remoteMode = sdp.ModeSendrecv
+	for _, a := range attrs {
+		switch a {
+		case sdp.ModeSendrecv, sdp.ModeSendonly, sdp.ModeRecvonly, sdp.ModeInactive:
+			v.remoteMode = a
+		}
+	}
+	v.codec.PayloadType = pt
+	if fmtp != "" {
+		v.codec.FMTP = fmtp
+	}
+	v.sess.SetRemoteAddr(&net.UDPAddr{IP: ci.IP, Port: md.Port})
+	v.remoteVideo = true
+	return nil
+}
+
+// h264Format finds the H.264 payload type of a video description, preferring
+// packetization-mode=1, and its format parameters.
+func h264Format(formats []string, attrs []string) (uint8, string, bool) {
+	var (
+		pt    uint8
+		fmtp  string
+		found bool
+	)
+	for _, f := range formats {
+		n, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			continue
+		}
+		var rtpmap, params string
+		for _, a := range attrs {
+			if v, ok := strings.CutPrefix(a, "rtpmap:"+f+" "); ok {
+				rtpmap = strings.TrimSpace(v)
+			}
+			if v, ok := strings.CutPrefix(a, "fmtp:"+f+" "); ok {
+				params = strings.TrimSpace(v)
+			}
+		}
+		if !strings.EqualFold(rtpmap, "H264/90000") {
+			continue
+		}
+		if !found || strings.Contains(params, "packetization-mode=1") {
+			pt, fmtp, found = uint8(n), params, true
+		}
+		if strings.Contains(params, "packetization-mode=1") {
+			break
+		}
+	}
+	return pt, fmtp, found
+}
+
+// videoAnswerMode is the direction answering an offered remote direction
+// with our own: we send only if both allow it, likewise for receiving.
+func videoAnswerMode(local, remote string) string {
+	canSend := local == sdp.ModeSendrecv || local == sdp.ModeSendonly
+	canRecv := local == sdp.ModeSendrecv || local == sdp.ModeRecvonly
+	send := canSend && (remote == sdp.ModeSendrecv || remote == sdp.ModeRecvonly)
+	recv := canRecv && (remote == sdp.ModeSendrecv || remote == sdp.ModeSendonly)
+	switch {
+	case send && recv:
+		return sdp.ModeSendrecv
+	case send:
+		return sdp.ModeSendonly
+	case recv:
+		return sdp.ModeRecvonly
+	}
+	return sdp.ModeInactive
+}
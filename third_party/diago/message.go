@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// OnMessage handles MESSAGE requests (RFC 3428) with f. Must be called
+// before serving requests.
+func (dg *Diago) OnMessage(f func(req *sip.Request, tx sip.ServerTransaction)) {
+	dg.server.OnMessage(f)
+}
+
+// MessageOptions are the options of Message.
+type MessageOptions struct {
+	// Transport or protocol that should be used; empty takes the
+	// transport parameter of the recipient.
+	Transport string
+	// For digest authentication
+	Username string
+	Password string
+	// Custom headers to pass, e.g. a From header.
+	Headers []sip.Header
+}
+
+// Message sends body (of contentType) to recipient in a MESSAGE request
+// outside of any dialog and returns the final response. A 401 or 407
+// challenge is answered once with Username and Password.
+func (dg *Diago) Message(ctx context.Context, recipient sip.Uri, contentType string, body []byte, opts MessageOptions) (*sip.Response, error) {
+	transport := opts.Transport
+	if transport == "" && recipient.UriParams != nil {
+		if t := recipient.UriParams["transport"]; t != "" {
+			transport = t
+			recipient.UriParams = recipient.UriParams.Clone()
+			delete(recipient.UriParams, "transport")
+		}
+	}
+	tran, exists := dg.findTransport(transport, "", recipient.Host)
+	if !exists {
+		return nil, fmt.Errorf("transport %s does not exists", transport)
+	}
+	client := dg.getClient(&tran)
+
+	req := sip.NewRequest(sip.MESSAGE, recipient)
+	req.SetTransport(sip.NetworkToUpper(tran.Transport))
+	for _, h := range opts.Headers {
+		req.AppendHeader(h)
+	}
+	req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+	req.SetBody(body)
+
+	res, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Password == "" || (res.StatusCode != sip.StatusUnauthorized && res.StatusCode != sip.StatusProxyAuthRequired) {
+		return res, nil
+	}
+	return doDigestAuth(ctx, client, req, res, opts.Username, opts.Password)
+}
@@ -5,8 +5,11 @@ package diago
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -44,6 +47,12 @@ type RegisterOptions struct {
 
 	OnRegistered func()
 
+	// InstanceID (a urn:uuid) and RegID make this an RFC 5626 (SIP outbound)
+	// registration: the registrar keeps the binding next to those of other
+	// devices on the account and routes calls over the registering flow.
+	InstanceID string
+	RegID      int
+
 	// Useragent default will be used on what is provided as NewUA()
 	// UserAgent         string
 	// UserAgentHostname string
@@ -57,12 +66,24 @@ type RegisterTransaction struct {
 	log    *slog.Logger
 
 	expiry time.Duration
+	// res is the last successful response; its source is the flow the
+	// registrar sees us on.
+	res *sip.Response
 }
 
 func newRegisterTransaction(client *sipgo.Client, recipient sip.Uri, contact sip.ContactHeader, log *slog.Logger, opts RegisterOptions) *RegisterTransaction {
 	expiry, allowHDRS := opts.Expiry, opts.AllowHeaders
 	// log := p.getLoggerCtx(ctx, "Register")
 	req := sip.NewRequest(sip.REGISTER, recipient)
+	if opts.InstanceID != "" {
+		contact.Params = contact.Params.Clone()
+		if contact.Params == nil {
+			contact.Params = sip.NewParams()
+		}
+		contact.Params.Add("+sip.instance", `"<`+opts.InstanceID+`>"`)
+		contact.Params.Add("reg-id", strconv.Itoa(max(opts.RegID, 1)))
+		req.AppendHeader(sip.NewHeader("Supported", "outbound, path"))
+	}
 	req.AppendHeader(&contact)
 
 	if opts.ProxyHost != "" {
@@ -136,10 +157,7 @@ func (t *RegisterTransaction) register(ctx context.Context) error {
 	}
 
 	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
-		res, err = client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{
-			Username: username,
-			Password: password,
-		})
+		res, err = doDigestAuth(ctx, client, req, res, username, password)
 		if err != nil {
 			return fmt.Errorf("fail to get response req=%q : %w", req.StartLine(), err)
 		}
@@ -162,6 +180,7 @@ func (t *RegisterTransaction) register(ctx context.Context) error {
 		}
 		t.expiry = time.Duration(val) * time.Second
 	}
+	t.res = res
 
 	return nil
 }
@@ -218,6 +237,11 @@ func (t *RegisterTransaction) calcRetry(expiry time.Duration) time.Duration {
 	return retry
 }
 
+// Expiry returns the registration lifetime last granted by the server.
+func (t *RegisterTransaction) Expiry() time.Duration {
+	return t.expiry
+}
+
 func (t *RegisterTransaction) Unregister(ctx context.Context) error {
 	req := t.Origin
 
@@ -246,10 +270,7 @@ func (t *RegisterTransaction) doRequest(ctx context.Context, req *sip.Request) e
 	}
 
 	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
-		res, err = client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{
-			Username: username,
-			Password: password,
-		})
+		res, err = doDigestAuth(ctx, client, req, res, username, password)
 		if err != nil {
 			return fmt.Errorf("fail to get response req=%q : %w", req.StartLine(), err)
 		}
@@ -271,10 +292,50 @@ func (t *RegisterTransaction) doRequest(ctx context.Context, req *sip.Request) e
 		}
 		t.expiry = time.Duration(val) * time.Second
 	}
+	t.res = res
 
 	return nil
 }
 
+// Response returns the last successful response of the registrar, nil
+// before the first one.
+func (t *RegisterTransaction) Response() *sip.Response {
+	return t.res
+}
+
+var keepAliveCRLF = []byte("\r\n\r\n")
+
+// KeepAlive sends a double-CRLF keep-alive (RFC 5626 section 4.4.1) on the
+// flow the registrar last answered on, keeping NAT bindings open. Over
+// connection-oriented transports the registrar answers with a CRLF pong.
+func (t *RegisterTransaction) KeepAlive() error {
+	res := t.res
+	if res == nil {
+		return errors.New("not registered")
+	}
+	network, src := sip.NetworkToLower(res.Transport()), res.Source()
+	conn, err := t.client.TransportLayer().GetConnection(network, src)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return fmt.Errorf("no %s flow to %s", network, src)
+	}
+	switch c := conn.(type) {
+	case *sip.UDPConnection:
+		addr, err := net.ResolveUDPAddr("udp", src)
+		if err != nil {
+			return err
+		}
+		_, err = c.WriteTo(keepAliveCRLF, addr)
+		return err
+	case io.Writer:
+		_, err = c.Write(keepAliveCRLF)
+		return err
+	}
+	return fmt.Errorf("keep-alive not supported on %s", network)
+}
+
 func getResponse(ctx context.Context, tx sip.ClientTransaction) (*sip.Response, error) {
 	select {
 	case <-tx.Done():
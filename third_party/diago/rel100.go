@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// Reliable provisional response (RFC 3262) modes, see WithRel100.
+const (
+	// Rel100Off ignores 100rel: provisional responses are sent unreliably
+	// and reliable ones received are not acknowledged.
+	Rel100Off = ""
+	// Rel100On advertises 100rel on outbound INVITEs and acknowledges
+	// reliable provisional responses with PRACK; inbound INVITEs supporting
+	// it get reliable 18x responses.
+	Rel100On = "on"
+	// Rel100Require is Rel100On, with outbound INVITEs also requiring
+	// 100rel.
+	Rel100Require = "require"
+)
+
+const optionTag100rel = "100rel"
+
+// ErrNoPRACK is returned when a reliable provisional response was not
+// acknowledged in time (64*T1).
+var ErrNoPRACK = errors.New("reliable provisional response not acknowledged")
+
+// WithRel100 sets how reliable provisional responses are used, one of
+// Rel100Off, Rel100On and Rel100Require.
+func WithRel100(mode string) DiagoOption {
+	return func(dg *Diago) {
+		dg.rel100 = mode
+	}
+}
+
+// hasOptionTag reports whether the Supported or Require header (name) of
+// msg lists tag.
+func hasOptionTag(msg sip.Message, name, tag string) bool {
+	for _, h := range msg.GetHeaders(name) {
+		for _, v := range strings.Split(h.Value(), ",") {
+			if strings.EqualFold(strings.TrimSpace(v), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rel100Headers are the option tags of an outbound INVITE in mode.
+func rel100Headers(mode string) []sip.Header {
+	switch mode {
+	case Rel100On:
+		return []sip.Header{sip.NewHeader("Supported", optionTag100rel)}
+	case Rel100Require:
+		return []sip.Header{
+			sip.NewHeader("Supported", optionTag100rel),
+			sip.NewHeader("Require", optionTag100rel),
+		}
+	}
+	return nil
+}
+
+// respondProvisional sends a provisional response to the INVITE. With
+// 100rel it is sent reliably: retransmitted until the caller PRACKs it,
+// which it waits for.
+func (d *DialogServerSession) respondProvisional(statusCode int, reason string, body []byte, headers ...sip.Header) error {
+	if !d.rel100 {
+		return d.DialogServerSession.Respond(statusCode, reason, body, headers...)
+	}
+	rseq := d.rseq.Add(1)
+	res := sip.NewResponseFromRequest(d.InviteRequest, statusCode, reason, body)
+	for _, h := range headers {
+		res.AppendHeader(h)
+	}
+	res.AppendHeader(sip.NewHeader("Require", optionTag100rel))
+	res.AppendHeader(sip.NewHeader("RSeq", strconv.FormatUint(uint64(rseq), 10)))
+
+	// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+	// Retransmitted with an interval that starts at T1 and doubles up to
+	// T2, until a PRACK arrives or 64*T1 passed.
+	giveUp := time.NewTimer(64 * sip.T1)
+	defer giveUp.Stop()
+	interval := sip.T1
+	for {
+		if err := d.WriteResponse(res); err != nil {
+			return err
+		}
+		retransmit := time.NewTimer(interval)
+		for wait := true; wait; {
+			select {
+			case <-d.pracked:
+				if d.prackRSeq.Load() >= rseq {
+					retransmit.Stop()
+					return nil
+				}
+			case <-retransmit.C:
+				wait = false
+			case <-giveUp.C:
+				retransmit.Stop()
+				return ErrNoPRACK
+			case <-d.Context().Done():
+				retransmit.Stop()
+				return d.Context().Err()
+			}
+		}
+		interval = min(2*interval, sip.T2)
+	}
+}
+
+// readPrack acknowledges a PRACK of a reliable provisional response.
+func (d *DialogServerSession) readPrack(req *sip.Request, tx sip.ServerTransaction) error {
+	rseq, cseq, ok := parseRAck(req)
+	if !ok {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad RAck", nil))
+	}
+	if !d.rel100 || cseq != d.InviteRequest.CSeq().SeqNo || rseq > d.rseq.Load() {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+	}
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, err.Error(), nil))
+	}
+	for {
+		prev := d.prackRSeq.Load()
+		if rseq <= prev || d.prackRSeq.CompareAndSwap(prev, rseq) {
+			break
+		}
+	}
+	select {
+	case d.pracked <- struct{}{}:
+	default:
+	}
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
+// parseRAck reads the RAck header of a PRACK: "<rseq> <cseq> INVITE".
+func parseRAck(req *sip.Request) (rseq, cseq uint32, ok bool) {
+	h := req.GetHeader("RAck")
+	if h == nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(h.Value())
+	if len(fields) != 3 || !strings.EqualFold(fields[2], sip.INVITE.String()) {
+		return 0, 0, false
+	}
+	r, err1 := strconv.ParseUint(fields[0], 10, 32)
+	c, err2 := strconv.ParseUint(fields[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return uint32(r), uint32(c), true
+}
+
+// handleUpdate answers an UPDATE (RFC 3311) on the inbound dialog, which
+// may still be early.
+func (d *DialogServerSession) handleUpdate(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, err.Error(), nil))
+	}
+	var contact sip.Header
+	if res := d.InviteResponse; res != nil && res.Contact() != nil {
+		contact = res.Contact()
+	}
+	return d.DialogMedia.handleUpdate(req, tx, contact)
+}
+
+// handleUpdate answers an UPDATE (RFC 3311) on the outbound dialog, which
+// may still be early.
+func (d *DialogClientSession) handleUpdate(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, err.Error(), nil))
+	}
+	return d.DialogMedia.handleUpdate(req, tx, d.InviteRequest.Contact())
+}
+
+// handleUpdate answers an UPDATE. Without a body it only refreshes the
+// session; an SDP offer updates the media like a re-INVITE. An offer before
+// the initial offer/answer completed is refused with 491 Request Pending.
+func (d *DialogMedia) handleUpdate(req *sip.Request, tx sip.ServerTransaction, contactHDR sip.Header) error {
+	respond := func(res *sip.Response) error {
+		if contactHDR != nil {
+			res.AppendHeader(contactHDR)
+		}
+		return tx.Respond(res)
+	}
+	if len(req.Body()) == 0 {
+		return respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rtpSession == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, 491, "Request Pending", nil))
+	}
+	if err := d.sdpReInviteUnsafe(req.Body()); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptableHere, "Not Acceptable Here - "+err.Error(), nil))
+	}
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", d.mediaSession.LocalSDP())
+	res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	return respond(res)
+}
+
+// acknowledgeProvisional handles a provisional response to the outbound
+// INVITE: it keeps early dialogs matchable for UPDATEs and, with 100rel,
+// PRACKs reliable responses. A failed PRACK is left to the callee, which
+// ends the call when it insists on one.
+func (d *DialogClientSession) acknowledgeProvisional(ctx context.Context, res *sip.Response) {
+	if !res.IsProvisional() || res.StatusCode == sip.StatusTrying {
+		return
+	}
+	if id, err := sip.DialogIDFromResponse(res); err == nil && d.onEarlyDialog != nil {
+		d.onEarlyDialog(id)
+	}
+	if d.rel100 == Rel100Off || !hasOptionTag(res, "Require", optionTag100rel) {
+		return
+	}
+	h := res.GetHeader("RSeq")
+	if h == nil {
+		return
+	}
+	rseq, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil || uint32(rseq) <= d.lastRSeq {
+		// A retransmission, already acknowledged.
+		return
+	}
+	d.lastRSeq = uint32(rseq)
+
+	recipient := d.InviteRequest.Recipient
+	if contact := res.Contact(); contact != nil {
+		recipient = contact.Address
+	}
+	prack := sip.NewRequest(sip.PRACK, *recipient.Clone())
+	prack.AppendHeader(sip.NewHeader("RAck", fmt.Sprintf("%d %d %s", rseq, d.InviteRequest.CSeq().SeqNo, sip.INVITE)))
+	if res.GetHeader("Record-Route") == nil {
+		// Without a route set of its own, the early dialog follows the
+		// INVITE's preloaded one.
+		sip.CopyHeaders("Route", d.InviteRequest, prack)
+	}
+	prackCtx, cancel := context.WithTimeout(ctx, 64*sip.T1)
+	defer cancel()
+	_, _ = d.Do(prackCtx, prack)
+}
+
+// provisionalHandler wraps onResponse to acknowledge provisional responses
+// first.
+func (d *DialogClientSession) provisionalHandler(ctx context.Context, onResponse func(res *sip.Response) error) func(res *sip.Response) error {
+	return func(res *sip.Response) error {
+		d.acknowledgeProvisional(ctx, res)
+		if onResponse != nil {
+			return onResponse(res)
+		}
+		return nil
+	}
+}
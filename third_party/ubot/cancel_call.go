@@ -0,0 +1,74 @@
+package ubot
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrCallCancelled is returned by Play when CancelCall aborted the setup of
+// a private call.
+var ErrCallCancelled = errors.New("call setup cancelled")
+
+// setupCancel is the cancel signal of a private call being set up.
+type setupCancel struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *setupCancel) cancel() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// CancelCall aborts a private call that Play is still setting up: instead of
+// waiting for the callee, Play discards the pending Telegram call (so it
+// stops ringing) and returns ErrCallCancelled. It reports whether a setup
+// was in flight.
+func (ctx *Context) CancelCall(chatId any) (bool, error) {
+	parsedChatId, err := ctx.parseChatId(chatId)
+	if err != nil {
+		return false, err
+	}
+	return ctx.cancelSetup(parsedChatId), nil
+}
+
+func (ctx *Context) cancelSetup(chatId int64) bool {
+	if chatId < 0 {
+		return false
+	}
+	ctx.setupMutex.Lock()
+	setup := ctx.setupCancels[chatId]
+	ctx.setupMutex.Unlock()
+	if setup == nil {
+		return false
+	}
+	setup.cancel()
+	return true
+}
+
+// beginSetup registers the cancel signal of a private call to chatId; it is
+// registered before any request goes out so CancelCall never misses it.
+func (ctx *Context) beginSetup(chatId int64) <-chan struct{} {
+	setup := &setupCancel{done: make(chan struct{})}
+	ctx.setupMutex.Lock()
+	ctx.setupCancels[chatId] = setup
+	ctx.setupMutex.Unlock()
+	return setup.done
+}
+
+// endSetup forgets the cancel signal registered by beginSetup.
+func (ctx *Context) endSetup(chatId int64, done <-chan struct{}) {
+	ctx.setupMutex.Lock()
+	defer ctx.setupMutex.Unlock()
+	if setup := ctx.setupCancels[chatId]; setup != nil && setup.done == done {
+		delete(ctx.setupCancels, chatId)
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,56 @@
+package ubot
+
+import (
+	"sync"
+	"testing"
+)
+
+func newTestContext() *Context {
+	return &Context{setupCancels: make(map[int64]*setupCancel)}
+}
+
+func TestCancelCallDuringSetup(t *testing.T) {
+	ctx := newTestContext()
+	done := ctx.beginSetup(42)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !ctx.cancelSetup(42) {
+				t.Error("setup in flight was not found")
+			}
+		}()
+	}
+	wg.Wait()
+	if !isClosed(done) {
+		t.Fatal("setup was not cancelled")
+	}
+	ctx.endSetup(42, done)
+	if ctx.cancelSetup(42) {
+		t.Fatal("finished setup reported as pending")
+	}
+}
+
+func TestCancelCallWithoutSetup(t *testing.T) {
+	ctx := newTestContext()
+	if ctx.cancelSetup(42) {
+		t.Fatal("unknown setup reported as pending")
+	}
+	if ctx.cancelSetup(-100) {
+		t.Fatal("group call reported as pending")
+	}
+}
+
+func TestEndSetupKeepsNewerSetup(t *testing.T) {
+	ctx := newTestContext()
+	old := ctx.beginSetup(42)
+	current := ctx.beginSetup(42)
+	ctx.endSetup(42, old)
+	if !ctx.cancelSetup(42) {
+		t.Fatal("newer setup was forgotten")
+	}
+	if isClosed(old) || !isClosed(current) {
+		t.Fatal("cancel reached the wrong setup")
+	}
+}
@@ -0,0 +1,91 @@
+package ubot
+
+import (
+	"fmt"
+	"gotgcalls/third_party/ntgcalls"
+	"slices"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// ProtocolError is returned when the remote client's call protocol doesn't
+// overlap with ours. Without this check the call silently times out.
+type ProtocolError struct {
+	ChatID int64
+	Local  ntgcalls.Protocol
+	Remote ntgcalls.Protocol
+	// TooOld is set when the peer's newest layer predates our oldest one.
+	TooOld bool
+}
+
+func (e *ProtocolError) Error() string {
+	reason := "incompatible peer call protocol"
+	if e.TooOld {
+		reason = "peer client too old"
+	}
+	return fmt.Sprintf(
+		"%s: peer layers %d-%d versions %v, ours %d-%d versions %v",
+		reason,
+		e.Remote.MinLayer, e.Remote.MaxLayer, e.Remote.Versions,
+		e.Local.MinLayer, e.Local.MaxLayer, e.Local.Versions,
+	)
+}
+
+// SetStrictProtocol makes calls fail with *ProtocolError when the peer's
+// protocol is incompatible. Otherwise mismatches are only reported through
+// OnProtocolMismatch callbacks and the call proceeds.
+func (ctx *Context) SetStrictProtocol(strict bool) {
+	ctx.strictProtocol = strict
+}
+
+func (ctx *Context) OnProtocolMismatch(callback func(chatId int64, err *ProtocolError)) {
+	ctx.protocolMismatchCallbacks = append(ctx.protocolMismatchCallbacks, callback)
+}
+
+// PeerProtocol returns the protocol announced by the peer of a P2P call.
+func (ctx *Context) PeerProtocol(chatId int64) (ntgcalls.Protocol, bool) {
+	ctx.peerProtocolsMutex.Lock()
+	defer ctx.peerProtocolsMutex.Unlock()
+	p, ok := ctx.peerProtocols[chatId]
+	return p, ok
+}
+
+// checkPeerProtocol records the peer protocol and returns an error if the call
+// must be aborted.
+func (ctx *Context) checkPeerProtocol(chatId int64, remote *tg.PhoneCallProtocol) error {
+	if remote == nil {
+		return nil
+	}
+	peer := ntgcalls.Protocol{
+		MinLayer:     remote.MinLayer,
+		MaxLayer:     remote.MaxLayer,
+		UdpP2P:       remote.UdpP2P,
+		UdpReflector: remote.UdpReflector,
+		Versions:     remote.LibraryVersions,
+	}
+	ctx.peerProtocolsMutex.Lock()
+	ctx.peerProtocols[chatId] = peer
+	ctx.peerProtocolsMutex.Unlock()
+
+	local := ntgcalls.GetProtocol()
+	layersOverlap := peer.MaxLayer >= local.MinLayer && peer.MinLayer <= local.MaxLayer
+	commonVersion := slices.ContainsFunc(peer.Versions, func(v string) bool {
+		return slices.Contains(local.Versions, v)
+	})
+	if layersOverlap && commonVersion {
+		return nil
+	}
+	protoErr := &ProtocolError{
+		ChatID: chatId,
+		Local:  local,
+		Remote: peer,
+		TooOld: peer.MaxLayer < local.MinLayer,
+	}
+	for _, callback := range ctx.protocolMismatchCallbacks {
+		go callback(chatId, protoErr)
+	}
+	if ctx.strictProtocol {
+		return protoErr
+	}
+	return nil
+}
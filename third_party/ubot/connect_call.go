@@ -17,6 +17,8 @@ func (ctx *Context) connectCall(chatId int64, mediaDescription ntgcalls.MediaDes
 	}()
 	ctx.waitConnect[chatId] = make(chan error)
 	if chatId >= 0 {
+		cancel := ctx.beginSetup(chatId)
+		defer ctx.endSetup(chatId, cancel)
 		defer func() {
 			if ctx.p2pConfigs[chatId] != nil {
 				delete(ctx.p2pConfigs, chatId)
@@ -29,6 +31,9 @@ func (ctx *Context) connectCall(chatId int64, mediaDescription ntgcalls.MediaDes
 			}
 			ctx.p2pConfigs[chatId] = p2pConfigs
 		}
+		if isClosed(cancel) {
+			return ErrCallCancelled
+		}
 
 		err := ctx.binding.CreateP2PCall(chatId)
 		if err != nil {
@@ -62,6 +67,10 @@ func (ctx *Context) connectCall(chatId int64, mediaDescription ntgcalls.MediaDes
 		if err != nil {
 			return err
 		}
+		if isClosed(cancel) {
+			_ = ctx.binding.Stop(chatId)
+			return ErrCallCancelled
+		}
 		if ctx.p2pConfigs[chatId].IsOutgoing {
 			callRes, err := ctx.app.PhoneRequestCall(
 				&tg.PhoneRequestCallParams{
@@ -99,11 +108,23 @@ func (ctx *Context) connectCall(chatId int64, mediaDescription ntgcalls.MediaDes
 		select {
 		case err = <-ctx.p2pConfigs[chatId].WaitData:
 			if err != nil {
+				// The peer discarded the call or, with strict protocol
+				// checks, speaks an incompatible protocol: tear down the
+				// ntgcalls call (and hang up the peer) before giving up.
+				_ = ctx.Stop(chatId)
 				return err
 			}
+		case <-cancel:
+			// Discards the requested call, so the callee stops ringing.
+			_ = ctx.Stop(chatId)
+			return ErrCallCancelled
 		case <-time.After(10 * time.Second):
 			return fmt.Errorf("timed out waiting for an answer")
 		}
+		if isClosed(cancel) {
+			_ = ctx.Stop(chatId)
+			return ErrCallCancelled
+		}
 		res, err := ctx.binding.ExchangeKeys(
 			chatId,
 			ctx.p2pConfigs[chatId].GAorB,
@@ -28,6 +28,19 @@ type Context struct {
 	streamEndCallbacks      []ntgcalls.StreamEndCallback
 	frameCallbacks          []ntgcalls.FrameCallback
 	callDisconnectCallbacks []func(chatId int64, reason string)
+
+	strictProtocol            bool
+	peerProtocolsMutex        sync.Mutex
+	peerProtocols             map[int64]ntgcalls.Protocol
+	protocolMismatchCallbacks []func(chatId int64, err *ProtocolError)
+
+	participantUpdateCallbacks []ParticipantUpdateCallback
+
+	volumesMutex sync.Mutex
+	callVolumes  map[int64]*types.CallVolumes
+
+	setupMutex   sync.Mutex
+	setupCancels map[int64]*setupCancel
 }
 
 func NewInstance(app *tg.Client) *Context {
@@ -42,6 +55,9 @@ func NewInstance(app *tg.Client) *Context {
 		callParticipants:    make(map[int64]*types.CallParticipantsCache),
 		callSources:         make(map[int64]*types.CallSources),
 		waitConnect:         make(map[int64]chan error),
+		peerProtocols:       make(map[int64]ntgcalls.Protocol),
+		callVolumes:         make(map[int64]*types.CallVolumes),
+		setupCancels:        make(map[int64]*setupCancel),
 	}
 	if app.IsConnected() {
 		self, err := app.GetMe()
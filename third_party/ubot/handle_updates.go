@@ -59,14 +59,14 @@ func (ctx *Context) handleUpdates() {
 		case *tg.PhoneCallAccepted:
 			if ctx.p2pConfigs[userId] != nil {
 				ctx.p2pConfigs[userId].GAorB = call.GB
-				ctx.p2pConfigs[userId].WaitData <- nil
+				ctx.p2pConfigs[userId].WaitData <- ctx.checkPeerProtocol(userId, call.Protocol)
 			}
 		case *tg.PhoneCallObj:
 			if ctx.p2pConfigs[userId] != nil {
 				ctx.p2pConfigs[userId].GAorB = call.GAOrB
 				ctx.p2pConfigs[userId].KeyFingerprint = call.KeyFingerprint
 				ctx.p2pConfigs[userId].PhoneCall = call
-				ctx.p2pConfigs[userId].WaitData <- nil
+				ctx.p2pConfigs[userId].WaitData <- ctx.checkPeerProtocol(userId, call.Protocol)
 			}
 		case *tg.PhoneCallDiscarded:
 			var reasonMessage string
@@ -110,6 +110,11 @@ func (ctx *Context) handleUpdates() {
 		participantsUpdate := m.(*tg.UpdateGroupCallParticipants)
 		chatId, err := ctx.convertGroupCallId(participantsUpdate.Call.(*tg.InputGroupCallObj).ID)
 		if err == nil {
+			type participantEvent struct {
+				participant *tg.GroupCallParticipant
+				action      ParticipantAction
+			}
+			var events []participantEvent
 			ctx.participantsMutex.Lock()
 			if ctx.callParticipants[chatId] == nil {
 				ctx.callParticipants[chatId] = &types.CallParticipantsCache{
@@ -118,8 +123,15 @@ func (ctx *Context) handleUpdates() {
 			}
 			for _, participant := range participantsUpdate.Participants {
 				participantId := getParticipantId(participant.Peer)
+				if !participant.Self && (ctx.self == nil || participantId != ctx.self.ID) {
+					prev := ctx.callParticipants[chatId].CallParticipants[participantId]
+					if action, ok := participantAction(prev, participant); ok {
+						events = append(events, participantEvent{participant, action})
+					}
+				}
 				if participant.Left {
 					delete(ctx.callParticipants[chatId].CallParticipants, participantId)
+					ctx.forgetSource(chatId, uint32(participant.Source))
 					if ctx.callSources != nil && ctx.callSources[chatId] != nil {
 						delete(ctx.callSources[chatId].CameraSources, participantId)
 						delete(ctx.callSources[chatId].ScreenSources, participantId)
@@ -170,6 +182,12 @@ func (ctx *Context) handleUpdates() {
 			ctx.callParticipants[chatId].LastMtprotoUpdate = time.Now()
 			ctx.participantsMutex.Unlock()
 
+			for _, event := range events {
+				for _, callback := range ctx.participantUpdateCallbacks {
+					go callback(chatId, event.participant, event.action)
+				}
+			}
+
 			for _, participant := range participantsUpdate.Participants {
 				userPeer := participant.Peer.(*tg.PeerUser)
 				if userPeer.UserID == ctx.self.ID {
@@ -309,6 +327,9 @@ func (ctx *Context) handleUpdates() {
 	})
 
 	ctx.binding.OnFrame(func(chatId int64, mode ntgcalls.StreamMode, device ntgcalls.StreamDevice, frames []ntgcalls.Frame) {
+		if mode == ntgcalls.PlaybackStream {
+			ctx.applyVolumes(chatId, frames)
+		}
 		for _, callback := range ctx.frameCallbacks {
 			go callback(chatId, mode, device, frames)
 		}
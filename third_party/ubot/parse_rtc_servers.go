@@ -3,25 +3,50 @@ package ubot
 import (
 	"gotgcalls/third_party/ntgcalls"
 	"log/slog"
+	"net"
+	"sync"
 
 	tg "github.com/amarnathcjd/gogram/telegram"
 )
 
+// ipv6Probe is an address only used to ask the kernel for a route; nothing
+// is sent to it.
+const ipv6Probe = "[2001:4860:4860::8888]:443"
+
+// hasWorkingIPv6 reports whether the host has a global IPv6 address with a
+// route to the internet. Checked once.
+var hasWorkingIPv6 = sync.OnceValue(func() bool {
+	conn, err := net.Dial("udp6", ipv6Probe)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	working := ok && addr.IP.IsGlobalUnicast() && addr.IP.To4() == nil
+	slog.Info("ipv6 connectivity", "working", working, "local", conn.LocalAddr().String())
+	return working
+})
+
+// rtcIPv6 returns the IPv6 address of a server to offer ntgcalls. Without
+// working IPv6 it is dropped when the server has IPv4: trying it would only
+// time out, especially on hosts with multiple virtual NICs (docker/vm
+// bridges).
+func rtcIPv6(ipv4, ipv6 string) string {
+	if ipv4 != "" && !hasWorkingIPv6() {
+		return ""
+	}
+	return ipv6
+}
+
 func parseRTCServers(connections []tg.PhoneConnection) []ntgcalls.RTCServer {
 	rtcServers := make([]ntgcalls.RTCServer, len(connections))
 	for i, connection := range connections {
 		switch connection := connection.(type) {
 		case *tg.PhoneConnectionWebrtc:
-			// Prefer IPv4 to avoid IPv6/interface issues that can lead to timeouts
-			// on hosts with multiple virtual NICs (docker/vm bridges).
-			ipv6 := connection.Ipv6
-			if connection.Ip != "" {
-				ipv6 = ""
-			}
 			rtcServers[i] = ntgcalls.RTCServer{
 				ID:       connection.ID,
 				Ipv4:     connection.Ip,
-				Ipv6:     ipv6,
+				Ipv6:     rtcIPv6(connection.Ip, connection.Ipv6),
 				Username: connection.Username,
 				Password: connection.Password,
 				Port:     connection.Port,
@@ -31,14 +56,10 @@ func parseRTCServers(connections []tg.PhoneConnection) []ntgcalls.RTCServer {
 
 			slog.Info("rtc server", "server", rtcServers[i])
 		case *tg.PhoneConnectionObj:
-			ipv6 := connection.Ipv6
-			if connection.Ip != "" {
-				ipv6 = ""
-			}
 			rtcServers[i] = ntgcalls.RTCServer{
 				ID:      connection.ID,
 				Ipv4:    connection.Ip,
-				Ipv6:    ipv6,
+				Ipv6:    rtcIPv6(connection.Ip, connection.Ipv6),
 				Port:    connection.Port,
 				Turn:    true,
 				Tcp:     connection.Tcp,
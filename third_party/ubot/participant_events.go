@@ -0,0 +1,51 @@
+package ubot
+
+import tg "github.com/amarnathcjd/gogram/telegram"
+
+type ParticipantAction int
+
+const (
+	ParticipantJoined ParticipantAction = iota
+	ParticipantLeft
+	ParticipantMuted
+	ParticipantUnmuted
+)
+
+func (a ParticipantAction) String() string {
+	switch a {
+	case ParticipantJoined:
+		return "joined"
+	case ParticipantLeft:
+		return "left"
+	case ParticipantMuted:
+		return "muted"
+	case ParticipantUnmuted:
+		return "unmuted"
+	}
+	return "unknown"
+}
+
+type ParticipantUpdateCallback func(chatId int64, participant *tg.GroupCallParticipant, action ParticipantAction)
+
+// OnParticipantUpdate is called for join/leave/mute changes of other
+// participants in group calls we are connected to.
+func (ctx *Context) OnParticipantUpdate(callback ParticipantUpdateCallback) {
+	ctx.participantUpdateCallbacks = append(ctx.participantUpdateCallbacks, callback)
+}
+
+// participantAction classifies an update against the cached previous state.
+// ok is false when nothing relevant changed.
+func participantAction(prev, cur *tg.GroupCallParticipant) (ParticipantAction, bool) {
+	switch {
+	case cur.Left:
+		return ParticipantLeft, prev != nil
+	case prev == nil:
+		return ParticipantJoined, true
+	case prev.Muted != cur.Muted:
+		if cur.Muted {
+			return ParticipantMuted, true
+		}
+		return ParticipantUnmuted, true
+	}
+	return 0, false
+}
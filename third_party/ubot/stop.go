@@ -9,6 +9,9 @@ func (ctx *Context) Stop(chatId any) error {
 	}
 	ctx.presentations = stdRemove(ctx.presentations, parsedChatId)
 	delete(ctx.callSources, parsedChatId)
+	ctx.volumesMutex.Lock()
+	delete(ctx.callVolumes, parsedChatId)
+	ctx.volumesMutex.Unlock()
 	err = ctx.binding.Stop(parsedChatId)
 	if err != nil {
 		return err
@@ -26,6 +29,9 @@ func (ctx *Context) Stop(chatId any) error {
 		}
 		delete(ctx.inputCalls, parsedChatId)
 		delete(ctx.p2pConfigs, parsedChatId)
+		ctx.peerProtocolsMutex.Lock()
+		delete(ctx.peerProtocols, parsedChatId)
+		ctx.peerProtocolsMutex.Unlock()
 		return nil
 	}
 
@@ -0,0 +1,9 @@
+package types
+
+// CallVolumes are the local playback volumes of a group call in percent
+// (100 = unchanged): of the whole call, and of single audio sources.
+type CallVolumes struct {
+	Call    int
+	Sources map[uint32]int
+	Muted   map[uint32]bool
+}
@@ -0,0 +1,189 @@
+package ubot
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"gotgcalls/third_party/ntgcalls"
+	"gotgcalls/third_party/ubot/types"
+)
+
+// MaxVolume is the highest local playback volume, in percent.
+const MaxVolume = 200
+
+// Source is the media of one group call participant.
+type Source struct {
+	ParticipantId int64
+	// Audio is the SSRC of the participant's audio, 0 without one.
+	Audio uint32
+	// Camera and Screen are the video endpoints, "" when off.
+	Camera string
+	Screen string
+	// Volume (percent) and Muted are the local playback settings of Audio.
+	Volume int
+	Muted  bool
+}
+
+// GetSources lists the media sources of the participants of a group call.
+func (ctx *Context) GetSources(chatId any) ([]Source, error) {
+	parsedChatId, err := ctx.parseChatId(chatId)
+	if err != nil {
+		return nil, err
+	}
+	participants, err := ctx.GetParticipants(parsedChatId)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Source, 0, len(participants))
+	for _, participant := range participants {
+		source := Source{
+			ParticipantId: getParticipantId(participant.Peer),
+			Audio:         uint32(participant.Source),
+		}
+		if participant.Video != nil {
+			source.Camera = participant.Video.Endpoint
+		}
+		if participant.Presentation != nil {
+			source.Screen = participant.Presentation.Endpoint
+		}
+		source.Volume, source.Muted = ctx.GetSourceVolume(parsedChatId, source.Audio)
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// SetCallVolume sets the local playback volume of a whole group call
+// (0-MaxVolume percent). Like the source volumes, it only changes the
+// audio delivered to OnFrame callbacks; other participants hear no change.
+func (ctx *Context) SetCallVolume(chatId any, percent int) error {
+	return ctx.editVolumes(chatId, percent, func(v *types.CallVolumes) {
+		v.Call = percent
+	})
+}
+
+// SetSourceVolume sets the local playback volume of one audio source (SSRC)
+// of a group call (0-MaxVolume percent).
+func (ctx *Context) SetSourceVolume(chatId any, ssrc uint32, percent int) error {
+	return ctx.editVolumes(chatId, percent, func(v *types.CallVolumes) {
+		if percent == 100 {
+			delete(v.Sources, ssrc)
+		} else {
+			v.Sources[ssrc] = percent
+		}
+	})
+}
+
+// SetSourceMuted mutes or unmutes one audio source (SSRC) of a group call
+// locally, keeping its volume for when it is unmuted.
+func (ctx *Context) SetSourceMuted(chatId any, ssrc uint32, muted bool) error {
+	return ctx.editVolumes(chatId, 100, func(v *types.CallVolumes) {
+		if muted {
+			v.Muted[ssrc] = true
+		} else {
+			delete(v.Muted, ssrc)
+		}
+	})
+}
+
+// GetCallVolume returns the local playback volume of a group call.
+func (ctx *Context) GetCallVolume(chatId any) int {
+	parsedChatId, err := ctx.parseChatId(chatId)
+	if err != nil {
+		return 100
+	}
+	ctx.volumesMutex.Lock()
+	defer ctx.volumesMutex.Unlock()
+	if v := ctx.callVolumes[parsedChatId]; v != nil {
+		return v.Call
+	}
+	return 100
+}
+
+// GetSourceVolume returns the local playback volume of an audio source and
+// whether it is muted locally.
+func (ctx *Context) GetSourceVolume(chatId any, ssrc uint32) (percent int, muted bool) {
+	parsedChatId, err := ctx.parseChatId(chatId)
+	if err != nil {
+		return 100, false
+	}
+	ctx.volumesMutex.Lock()
+	defer ctx.volumesMutex.Unlock()
+	v := ctx.callVolumes[parsedChatId]
+	if v == nil {
+		return 100, false
+	}
+	percent, ok := v.Sources[ssrc]
+	if !ok {
+		percent = 100
+	}
+	return percent, v.Muted[ssrc]
+}
+
+func (ctx *Context) editVolumes(chatId any, percent int, edit func(v *types.CallVolumes)) error {
+	parsedChatId, err := ctx.parseChatId(chatId)
+	if err != nil {
+		return err
+	}
+	if percent < 0 || percent > MaxVolume {
+		return fmt.Errorf("volume must be between 0 and %d%%", MaxVolume)
+	}
+	ctx.volumesMutex.Lock()
+	defer ctx.volumesMutex.Unlock()
+	v := ctx.callVolumes[parsedChatId]
+	if v == nil {
+		v = &types.CallVolumes{
+			Call:    100,
+			Sources: make(map[uint32]int),
+			Muted:   make(map[uint32]bool),
+		}
+		ctx.callVolumes[parsedChatId] = v
+	}
+	edit(v)
+	if v.Call == 100 && len(v.Sources) == 0 && len(v.Muted) == 0 {
+		delete(ctx.callVolumes, parsedChatId)
+	}
+	return nil
+}
+
+// forgetSource drops the volume settings of an audio source that left.
+func (ctx *Context) forgetSource(chatId int64, ssrc uint32) {
+	ctx.volumesMutex.Lock()
+	defer ctx.volumesMutex.Unlock()
+	if v := ctx.callVolumes[chatId]; v != nil {
+		delete(v.Sources, ssrc)
+		delete(v.Muted, ssrc)
+	}
+}
+
+// applyVolumes scales the 16-bit PCM of received frames in place by the
+// volumes of their sources.
+func (ctx *Context) applyVolumes(chatId int64, frames []ntgcalls.Frame) {
+	ctx.volumesMutex.Lock()
+	v := ctx.callVolumes[chatId]
+	if v == nil {
+		ctx.volumesMutex.Unlock()
+		return
+	}
+	gains := make([]float64, len(frames))
+	for i, frame := range frames {
+		gain := float64(v.Call) / 100
+		if percent, ok := v.Sources[frame.Ssrc]; ok {
+			gain *= float64(percent) / 100
+		}
+		if v.Muted[frame.Ssrc] {
+			gain = 0
+		}
+		gains[i] = gain
+	}
+	ctx.volumesMutex.Unlock()
+	for i, frame := range frames {
+		if gains[i] == 1 {
+			continue
+		}
+		data := frame.Data
+		for j := 0; j+1 < len(data); j += 2 {
+			sample := float64(int16(binary.LittleEndian.Uint16(data[j:]))) * gains[i]
+			binary.LittleEndian.PutUint16(data[j:], uint16(int16(max(-32768, min(32767, sample)))))
+		}
+	}
+}